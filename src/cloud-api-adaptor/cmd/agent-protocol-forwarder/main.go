@@ -6,21 +6,28 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
 	"os"
 	"strings"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/cmd"
 	daemon "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/interceptor"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/metrics"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/wgutil"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/apic"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/ppssh"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/ppwg"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/sshutil"
 )
 
@@ -39,6 +46,7 @@ type Config struct {
 	kataAgentSocketPath string
 	podNamespace        string
 	HostInterface       string
+	metricsAddr         string
 }
 
 func load(path string, obj interface{}) error {
@@ -62,6 +70,7 @@ func (cfg *Config) Setup() (cmd.Starter, error) {
 		secureComms          bool
 		secureCommsInbounds  string
 		secureCommsOutbounds string
+		secureCommsTransport string
 		tlsConfig            tlsutil.TLSConfig
 		services             []cmd.Service
 	)
@@ -81,6 +90,8 @@ func (cfg *Config) Setup() (cmd.Starter, error) {
 		flags.BoolVar(&secureComms, "secure-comms", false, "Use SSH to secure communication between cluster and peer pods")
 		flags.StringVar(&secureCommsInbounds, "secure-comms-inbounds", "", "Inbound tags for secure communication tunnels")
 		flags.StringVar(&secureCommsOutbounds, "secure-comms-outbounds", "", "Outbound tags for secure communication tunnels")
+		flags.StringVar(&secureCommsTransport, "secure-comms-transport", "", "Transport used for secure communication: ssh (default) or wireguard")
+		flags.StringVar(&cfg.metricsAddr, "metrics-listen", "", "Listen address of the Prometheus metrics endpoint (disabled by default)")
 	})
 
 	cmd.ShowVersion(programName)
@@ -93,7 +104,53 @@ func (cfg *Config) Setup() (cmd.Starter, error) {
 		return nil, err
 	}
 
-	if secureComms || cfg.daemonConfig.SecureComms {
+	if cfg.daemonConfig.SandboxID != "" {
+		logger.Printf("pod VM of pod %s/%s (sandbox %s) on node %s of cluster %q in %s", cfg.daemonConfig.PodNamespace, cfg.daemonConfig.PodName,
+			cfg.daemonConfig.SandboxID, cfg.daemonConfig.NodeName, cfg.daemonConfig.ClusterID, cfg.daemonConfig.Provider)
+	}
+
+	// A port delivered in the daemon config applies unless a listen address is given explicitly
+	if cfg.daemonConfig.ForwarderPort != "" && cfg.listenAddr == daemon.DefaultListenAddr {
+		cfg.listenAddr = net.JoinHostPort(daemon.DefaultListenHost, cfg.daemonConfig.ForwarderPort)
+	}
+
+	if secureCommsTransport == "" {
+		secureCommsTransport = cfg.daemonConfig.SecureCommsTransport
+	}
+
+	var daemonReady chan struct{}
+
+	if (secureComms || cfg.daemonConfig.SecureComms) && secureCommsTransport == "wireguard" {
+		// The WireGuard keys are only delivered through the KBS
+		if !secureComms {
+			return nil, fmt.Errorf("the wireguard secure comms transport requires -secure-comms")
+		}
+		ppAddr, err := netip.ParsePrefix(cfg.daemonConfig.WgAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WireGuard address %q: %w", cfg.daemonConfig.WgAddress, err)
+		}
+		wnAddr, err := netip.ParseAddr(cfg.daemonConfig.WgWnAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WireGuard WN address %q: %w", cfg.daemonConfig.WgWnAddress, err)
+		}
+
+		// The forwarder is only reachable through the WireGuard tunnel
+		_, port, err := net.SplitHostPort(cfg.listenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", cfg.listenAddr, err)
+		}
+		cfg.listenAddr = net.JoinHostPort(ppAddr.Addr().String(), port)
+
+		apic := apic.NewApiClient(API_SERVER_REST_PORT, cfg.podNamespace)
+
+		ppSecrets := ppssh.NewPpSecrets(ppssh.GetSecret(apic.GetKey))
+		ppSecrets.AddKey(ppwg.WN_WG_PUBLIC_KEY)
+		ppSecrets.AddKey(ppwg.PP_WG_PRIVATE_KEY)
+
+		wgServer := ppwg.NewWgServer(ppAddr, wnAddr, ppSecrets, wgutil.DefaultPort)
+		daemonReady = wgServer.Ready()
+		services = append(services, wgServer)
+	} else if secureComms || cfg.daemonConfig.SecureComms {
 		var inbounds, outbounds []string
 
 		ppssh.Singleton()
@@ -129,6 +186,9 @@ func (cfg *Config) Setup() (cmd.Starter, error) {
 
 		outbounds = append([]string{"KUBERNETES_PHASE:KATAAGENT:" + port}, strings.Split(secureCommsOutbounds, ",")...)
 		outbounds = append(outbounds, strings.Split(cfg.daemonConfig.SecureCommsOutbounds, ",")...)
+		if cfg.daemonConfig.MetricsPort != "" {
+			outbounds = append(outbounds, "KUBERNETES_PHASE:METRICS:"+cfg.daemonConfig.MetricsPort)
+		}
 
 		services = append(services, ppssh.NewSshServer(inbounds, outbounds, ppSecrets, sshutil.SSHPORT))
 	} else {
@@ -137,15 +197,80 @@ func (cfg *Config) Setup() (cmd.Starter, error) {
 		}
 	}
 
-	interceptor := interceptor.NewInterceptor(cfg.kataAgentSocketPath, cfg.podNamespace)
+	// The metrics endpoint listens next to the forwarder unless an explicit address is given.
+	// Secure comms tunnels authenticate cloud-api-adaptor, and otherwise the endpoint requires
+	// the client certificate of the agent protocol, or is only reachable from the pod VM
+	if cfg.metricsAddr == "" && cfg.daemonConfig.MetricsPort != "" {
+		host, _, err := net.SplitHostPort(cfg.listenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", cfg.listenAddr, err)
+		}
+		if !secureComms && !cfg.daemonConfig.SecureComms && cfg.tlsConfig == nil {
+			logger.Printf("TLS is disabled, so the metrics endpoint only listens on localhost")
+			host = "127.0.0.1"
+		}
+		cfg.metricsAddr = net.JoinHostPort(host, cfg.daemonConfig.MetricsPort)
+	}
+
+	if cfg.metricsAddr != "" {
+		// cfg.tlsConfig gets the certificates of the daemon config in NewDaemon below, before the server is started
+		var metricsServer cmd.Service = metrics.NewServer(cfg.metricsAddr, cfg.tlsConfig)
+		if daemonReady != nil {
+			metricsServer = &waitingService{Service: metricsServer, waitCh: daemonReady}
+		}
+		services = append(services, metricsServer)
+	}
+
+	sandboxID, err := loadSandboxID(paths.SandboxIDPath)
+	if err != nil {
+		return nil, err
+	}
+
+	interceptor := interceptor.NewInterceptor(cfg.kataAgentSocketPath, cfg.podNamespace, sandboxID)
 
 	podNode := podnetwork.NewPodNode(cfg.podNamespace, cfg.HostInterface, cfg.daemonConfig.PodNetwork)
 
-	services = append(services, daemon.NewDaemon(&cfg.daemonConfig, cfg.listenAddr, cfg.tlsConfig, interceptor, podNode))
+	// The kata agent becoming ready is reported as a boot phase of the pod VM
+	services = append(services, status.NewAgentWatcher(cfg.kataAgentSocketPath, status.DefaultStatusPath))
+
+	var forwarder cmd.Service = daemon.NewDaemon(&cfg.daemonConfig, cfg.listenAddr, cfg.tlsConfig, interceptor, podNode)
+	if daemonReady != nil {
+		forwarder = &waitingService{Service: forwarder, waitCh: daemonReady}
+	}
+	services = append(services, forwarder)
 
 	return cmd.NewStarter(services...), nil
 }
 
+// loadSandboxID loads the sandbox ID that process-user-data verified with signed userdata. It returns an empty
+// string if userdata is not signed
+func loadSandboxID(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read sandbox ID: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// waitingService delays starting a service until waitCh is closed, e.g. until
+// the interface the service listens on is available
+type waitingService struct {
+	cmd.Service
+	waitCh chan struct{}
+}
+
+func (s *waitingService) Start(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-s.waitCh:
+	}
+	return s.Service.Start(ctx)
+}
+
 var config cmd.Config = &Config{}
 
 func main() {
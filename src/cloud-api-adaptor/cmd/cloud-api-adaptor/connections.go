@@ -0,0 +1,113 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/connstats"
+)
+
+// showConnections prints the bandwidth and latency statistics of the connections of a running
+// cloud-api-adaptor, or of the agent-protocol-forwarder of a pod, and returns the exit code
+func showConnections(args []string) int {
+	flags := flag.NewFlagSet(programName+" connections", flag.ContinueOnError)
+
+	defaultAddr := adaptor.DefaultMonitoringAddr
+	if addr := os.Getenv("MONITORING_ADDR"); addr != "" {
+		defaultAddr = addr
+	}
+
+	var addr, pod, tokenFile string
+	flags.StringVar(&addr, "addr", defaultAddr, "Address of the cloud-api-adaptor monitoring server")
+	flags.StringVar(&tokenFile, "token-file", os.Getenv("MONITORING_TOKEN_FILE"), "File of the bearer token of the cloud-api-adaptor monitoring server")
+	flags.StringVar(&pod, "pod", "", "Show the connections of the agent-protocol-forwarder of a pod, given as <namespace>/<name>")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	path := adaptor.ConnectionsPath
+	if pod != "" {
+		if strings.Count(pod, "/") != 1 {
+			fmt.Fprintf(os.Stderr, "%s: invalid pod %q, expected <namespace>/<name>\n", programName, pod)
+			return 1
+		}
+		path = adaptor.PodVMConnectionsPath + pod
+	}
+
+	var token string
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", programName, err)
+			return 1
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	snapshots, err := fetchConnections("http://"+addr+path, token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", programName, err)
+		return 1
+	}
+
+	printConnections(os.Stdout, snapshots)
+	return 0
+}
+
+func fetchConnections(url, token string) ([]connstats.Snapshot, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching connection statistics: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching connection statistics: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("fetching connection statistics: %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var snapshots []connstats.Snapshot
+	if err := json.NewDecoder(res.Body).Decode(&snapshots); err != nil {
+		return nil, fmt.Errorf("decoding connection statistics: %w", err)
+	}
+	return snapshots, nil
+}
+
+func printConnections(out io.Writer, snapshots []connstats.Snapshot) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "STREAM\tID\tREMOTE\tIN\tOUT\tIN/S\tOUT/S\tRTT")
+	for _, s := range snapshots {
+		rtt := "-"
+		if s.RTT > 0 {
+			rtt = s.RTT.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.0f\t%.0f\t%s\n", s.Stream, s.ID, s.RemoteAddr, s.BytesIn, s.BytesOut, s.InBytesPerSecond, s.OutBytesPerSecond, rtt)
+	}
+	w.Flush()
+}
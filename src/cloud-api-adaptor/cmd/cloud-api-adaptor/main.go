@@ -8,18 +8,29 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net/netip"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/cmd"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/cloud"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/k8sops"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/proxy"
 	daemon "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/geneve"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/vxlan"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/wireguard"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/kubemgr"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/wnwg"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/streamcompress"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	putil "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/probe"
@@ -27,6 +38,8 @@ import (
 
 const (
 	programName = "cloud-api-adaptor"
+
+	defaultAttestationTimeout = 5 * time.Minute
 )
 
 type daemonConfig struct {
@@ -35,7 +48,7 @@ type daemonConfig struct {
 }
 
 func printHelp(out io.Writer) {
-	fmt.Fprintf(out, "Usage: %s <provider-name> [options] | help | version\n", programName)
+	fmt.Fprintf(out, "Usage: %s <provider-name> [options] | connections [options] | help | version\n", programName)
 	fmt.Fprintln(out)
 	fmt.Fprintln(out, "Supported cloud providers are:")
 
@@ -58,6 +71,8 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 	cloudName := os.Args[1]
 
 	switch cloudName {
+	case "connections":
+		cmd.Exit(showConnections(os.Args[2:]))
 	case "version":
 		cmd.ShowVersion(programName)
 		cmd.Exit(0)
@@ -90,6 +105,20 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 		secureCommsPpInbounds  string
 		secureCommsPpOutbounds string
 		secureCommsKbsAddr     string
+		secureCommsTransport   string
+		secureCommsWgOverlay   string
+		instanceNameTemplate   string
+		clusterID              string
+		userDataSigningKey     string
+		podvmProxy             string
+		podvmNoProxy           string
+		wireGuardTunnelOverlay string
+		clusterCIDRs           string
+		forwarderCompression   string
+		secureCommsKeyRotation time.Duration
+		allowedKBSURLs         string
+		monitoringAddr         string
+		monitoringTokenFile    string
 	)
 
 	cmd.Parse(programName, os.Args[1:], func(flags *flag.FlagSet) {
@@ -104,6 +133,10 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 		flags.StringVar(&cfg.serverConfig.PodsDir, "pods-dir", adaptor.DefaultPodsDir, "base directory for pod directories")
 		flags.StringVar(&cfg.serverConfig.PauseImage, "pause-image", "", "pause image to be used for the pods")
 		flags.StringVar(&cfg.serverConfig.ForwarderPort, "forwarder-port", daemon.DefaultListenPort, "port number of agent protocol forwarder")
+		flags.StringVar(&forwarderCompression, "forwarder-compression", "", "Comma separated compression algorithms (zstd, s2) to negotiate with agent protocol forwarder in order of preference (disabled by default)")
+		flags.StringVar(&cfg.serverConfig.ForwarderMetricsPort, "forwarder-metrics-port", "", "port number of the agent protocol forwarder metrics endpoint (disabled by default)")
+		flags.StringVar(&monitoringAddr, "monitoring-addr", adaptor.DefaultMonitoringAddr, "Listen address of the metrics, connections, pod VM status and measurement endpoints, which requires -monitoring-token-file unless it is a loopback address")
+		flags.StringVar(&monitoringTokenFile, "monitoring-token-file", "", "File of a bearer token that requests to the monitoring endpoints must present")
 		flags.StringVar(&tlsConfig.CAFile, "ca-cert-file", "", "CA cert file")
 		flags.StringVar(&tlsConfig.CertFile, "cert-file", "", "cert file")
 		flags.StringVar(&tlsConfig.KeyFile, "cert-key", "", "cert key")
@@ -115,16 +148,39 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 		flags.StringVar(&secureCommsOutbounds, "secure-comms-outbounds", "", "WN Outbound tags for secure communication tunnels")
 		flags.StringVar(&secureCommsPpInbounds, "secure-comms-pp-inbounds", "", "PP Inbound tags for secure communication tunnels")
 		flags.StringVar(&secureCommsPpOutbounds, "secure-comms-pp-outbounds", "", "PP Outbound tags for secure communication tunnels")
-		flags.StringVar(&secureCommsKbsAddr, "secure-comms-kbs", "kbs-service.trustee-operator-system:8080", "Address of a Trustee Service for Secure-Comms")
+		flags.StringVar(&secureCommsKbsAddr, "secure-comms-kbs", "kbs-service.trustee-operator-system:8080", "Address of a Trustee Service for Secure-Comms and sealed userdata")
+		flags.StringVar(&secureCommsTransport, "secure-comms-transport", "ssh", "Transport used for secure communication between cluster and peer pods: ssh or wireguard")
+		flags.StringVar(&secureCommsWgOverlay, "secure-comms-wg-overlay", wnwg.DefaultOverlay, "Overlay network of the WireGuard secure comms transport")
+		flags.DurationVar(&secureCommsKeyRotation, "secure-comms-key-rotation", 0, "Interval of rotating peer pod keys through Trustee, 0 disables rotation (ssh transport only)")
+		flags.BoolVar(&cfg.serverConfig.SealUserData, "seal-userdata", false, "Encrypt sensitive files in userdata with a key that Trustee releases to attested pod VMs only")
+		flags.BoolVar(&cfg.serverConfig.RequireAttestation, "require-attestation", false, "Start pod VMs only after they report successful attestation (requires -seal-userdata and -forwarder-metrics-port)")
+		flags.DurationVar(&cfg.serverConfig.AttestationTimeout, "attestation-timeout", defaultAttestationTimeout, "Timeout for pod VMs to report successful attestation with -require-attestation")
+		flags.StringVar(&podvmProxy, "podvm-proxy", "", "HTTP CONNECT (http://host:port) or SOCKS5 (socks5://host:port) proxy used to reach agent protocol forwarder")
+		flags.StringVar(&podvmNoProxy, "podvm-no-proxy", "", "Comma separated CIDRs of pod VM addresses that are reached without -podvm-proxy")
 		flags.DurationVar(&cfg.serverConfig.ProxyTimeout, "proxy-timeout", proxy.DefaultProxyTimeout, "Maximum timeout in minutes for establishing agent proxy connection")
+		flags.DurationVar(&cfg.serverConfig.ProxyKeepalive, "proxy-keepalive", proxy.DefaultKeepalive, "Interval of keepalive health checks on agent proxy connections, 0 disables them")
 
 		flags.StringVar(&cfg.networkConfig.TunnelType, "tunnel-type", podnetwork.DefaultTunnelType, "Tunnel provider")
 		flags.StringVar(&cfg.networkConfig.HostInterface, "host-interface", "", "Host Interface")
 		flags.IntVar(&cfg.networkConfig.VXLAN.Port, "vxlan-port", vxlan.DefaultVXLANPort, "VXLAN UDP port number (VXLAN tunnel mode only")
 		flags.IntVar(&cfg.networkConfig.VXLAN.MinID, "vxlan-min-id", vxlan.DefaultVXLANMinID, "Minimum VXLAN ID (VXLAN tunnel mode only")
+		flags.IntVar(&cfg.networkConfig.Geneve.Port, "geneve-port", geneve.DefaultGenevePort, "Geneve UDP port number (Geneve tunnel mode only)")
+		flags.IntVar(&cfg.networkConfig.Geneve.MinID, "geneve-min-id", geneve.DefaultGeneveMinID, "Minimum Geneve VNI (Geneve tunnel mode only)")
+		flags.IntVar(&cfg.networkConfig.WireGuard.Port, "wireguard-tunnel-port", wireguard.DefaultWireGuardPort, "WireGuard UDP port number of pod VMs (WireGuard tunnel mode only)")
+		flags.StringVar(&wireGuardTunnelOverlay, "wireguard-tunnel-overlay", wireguard.DefaultOverlay, "IPv4 network of the WireGuard links between the worker node and pod VMs (WireGuard tunnel mode only)")
+		flags.DurationVar(&cfg.serverConfig.TunnelCheckInterval, "tunnel-check-interval", podnetwork.DefaultCheckInterval, "Interval of checking and repairing pod network tunnels, 0 disables it")
+		flags.StringVar(&cfg.networkConfig.SourceIPPolicy, "pod-source-ip-policy", podnetwork.DefaultSourceIPPolicy, "Source IP of traffic from pod VMs: preserve (pod IP) or masquerade (worker node IP)")
+		flags.StringVar(&clusterCIDRs, "cluster-cidrs", "", "Comma separated pod and service CIDRs of the cluster, to which traffic from pod VMs is not masqueraded (required by -pod-source-ip-policy masquerade)")
+		flags.StringVar(&cfg.networkConfig.Datapath, "pod-datapath", podnetwork.DefaultDatapath, "Datapath between pod interfaces and tunnel interfaces on the worker node: tc (u32 and mirred) or ebpf")
 		flags.StringVar(&cfg.serverConfig.Initdata, "initdata", "", "Default initdata for all Pods")
+		flags.IntVar(&cfg.serverConfig.InitdataInlineLimit, "initdata-inline-limit", initdata.DefaultInlineLimit, "Size limit of base64 encoded initdata in userdata. Larger initdata is sent to pod VMs over the TLS connection of the agent protocol (0: no limit)")
+		flags.StringVar(&allowedKBSURLs, "allowed-kbs-urls", "", "Comma separated KBS URLs that pods and namespaces may select with the kbs_url annotation (disabled by default)")
 		flags.BoolVar(&cfg.serverConfig.EnableCloudConfigVerify, "cloud-config-verify", false, "Enable cloud config verify - should use it for production")
 		flags.IntVar(&cfg.serverConfig.PeerPodsLimitPerNode, "peerpods-limit-per-node", 10, "peer pods limit per node (default=10)")
+		flags.StringVar(&cfg.serverConfig.ExtendedResource, "peerpods-extended-resource", k8sops.DefaultExtendedResource, "Extended resource advertised on the node with the peer pods limit, which the webhook adds to peer pods")
+		flags.StringVar(&instanceNameTemplate, "instance-name-template", "", "Go template for Pod VM instance names, e.g. {{.ClusterID}}-{{.Namespace}}-{{.PodName}}-{{.Hash}}")
+		flags.StringVar(&clusterID, "cluster-id", "", "Cluster ID available to the instance name template, userdata templates and the daemon config")
+		flags.StringVar(&userDataSigningKey, "userdata-signing-key", "", "Path of an Ed25519 private key in PEM format to sign userdata, which pod VMs verify with the public key in the image")
 
 		cloud.ParseCmd(flags)
 	})
@@ -146,12 +202,115 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 		cfg.serverConfig.SecureCommsPpInbounds = secureCommsPpInbounds
 		cfg.serverConfig.SecureCommsPpOutbounds = secureCommsPpOutbounds
 		cfg.serverConfig.SecureCommsKbsAddress = secureCommsKbsAddr
+		cfg.serverConfig.SecureCommsKeyRotation = secureCommsKeyRotation
+
+		switch secureCommsTransport {
+		case "ssh", "wireguard":
+			cfg.serverConfig.SecureCommsTransport = secureCommsTransport
+		default:
+			return nil, fmt.Errorf("unsupported secure comms transport: %s", secureCommsTransport)
+		}
+		if secureCommsTransport == "wireguard" {
+			// The private keys of the peer pods are only delivered through the KBS
+			if secureCommsNoTrustee {
+				return nil, fmt.Errorf("the wireguard secure comms transport requires Trustee mode")
+			}
+			overlay, err := netip.ParsePrefix(secureCommsWgOverlay)
+			if err != nil {
+				return nil, fmt.Errorf("invalid WireGuard overlay network %q: %w", secureCommsWgOverlay, err)
+			}
+			cfg.serverConfig.SecureCommsWgOverlay = overlay
+		}
 	} else {
 		if !disableTLS {
 			cfg.serverConfig.TLSConfig = &tlsConfig
 		}
 	}
 
+	if cfg.serverConfig.SealUserData {
+		if !secureComms {
+			if err := kubemgr.InitKubeMgrInVivo(); err != nil {
+				return nil, fmt.Errorf("sealing userdata failed to initialize KubeMgr: %w", err)
+			}
+		}
+		cfg.serverConfig.SecureCommsKbsAddress = secureCommsKbsAddr
+	}
+
+	// The ESP keys of the IPsec tunnel are delivered in the daemon config, which must not be readable in userdata
+	if cfg.networkConfig.TunnelType == "ipsec" && !cfg.serverConfig.SealUserData {
+		return nil, fmt.Errorf("-tunnel-type ipsec requires -seal-userdata")
+	}
+
+	// Pod VMs attest to unseal userdata, and report the result through the metrics endpoint of the forwarder
+	if cfg.serverConfig.RequireAttestation {
+		if !cfg.serverConfig.SealUserData {
+			return nil, fmt.Errorf("-require-attestation requires -seal-userdata")
+		}
+		if cfg.serverConfig.ForwarderMetricsPort == "" {
+			return nil, fmt.Errorf("-require-attestation requires -forwarder-metrics-port")
+		}
+	}
+
+	if allowedKBSURLs != "" {
+		cfg.serverConfig.AllowedKBSURLs = strings.Split(allowedKBSURLs, ",")
+	}
+
+	upstreamProxy, err := proxy.ParseUpstreamProxy(podvmProxy)
+	if err != nil {
+		return nil, err
+	}
+	cfg.serverConfig.UpstreamProxy = upstreamProxy
+
+	if cfg.networkConfig.ClusterCIDRs, err = podnetwork.ParseCIDRs(clusterCIDRs); err != nil {
+		return nil, fmt.Errorf("invalid cluster CIDRs: %w", err)
+	}
+
+	if cfg.networkConfig.TunnelType == "wireguard" {
+		overlay, err := netip.ParsePrefix(wireGuardTunnelOverlay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WireGuard tunnel overlay network %q: %w", wireGuardTunnelOverlay, err)
+		}
+		if err := wireguard.ValidateOverlay(overlay); err != nil {
+			return nil, err
+		}
+		if secureCommsOverlay := cfg.serverConfig.SecureCommsWgOverlay; secureCommsOverlay.IsValid() && secureCommsOverlay.Overlaps(overlay) {
+			return nil, fmt.Errorf("WireGuard tunnel overlay network %s overlaps the secure comms overlay network %s", overlay, secureCommsOverlay)
+		}
+		cfg.networkConfig.WireGuard.Overlay = overlay.Masked()
+	}
+
+	noProxy, err := proxy.ParseNoProxy(podvmNoProxy)
+	if err != nil {
+		return nil, err
+	}
+	// The WireGuard overlay network is only reachable through the tunnel of the worker node
+	if cfg.serverConfig.SecureCommsWgOverlay.IsValid() {
+		noProxy = append(noProxy, cfg.serverConfig.SecureCommsWgOverlay.Masked())
+	}
+	cfg.serverConfig.UpstreamNoProxy = noProxy
+
+	compression, err := streamcompress.ParseAlgorithms(forwarderCompression)
+	if err != nil {
+		return nil, err
+	}
+	cfg.serverConfig.ForwarderCompression = compression
+
+	instanceNamer, err := putil.NewInstanceNamer(instanceNameTemplate, os.Getenv("NODE_NAME"), clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if userDataSigningKey != "" {
+		key, err := cloudinit.LoadSigningKey(userDataSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		cloudinit.SetSigningKey(key)
+	}
+
+	cfg.serverConfig.ClusterID = clusterID
+	cfg.serverConfig.InstanceNamer = instanceNamer
+	cfg.serverConfig.CloudProvider = cloudName
+
 	cloud.LoadEnv()
 
 	workerNode, err := podnetwork.NewWorkerNode(&cfg.networkConfig)
@@ -159,14 +318,26 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 		return nil, err
 	}
 
-	provider, err := cloud.NewProvider()
+	podVMProvider, err := cloud.NewProvider()
 	if err != nil {
 		return nil, err
 	}
+	if err := instanceNamer.Validate(provider.InstanceNameRules(podVMProvider)); err != nil {
+		return nil, err
+	}
 
-	server := adaptor.NewServer(provider, &cfg.serverConfig, workerNode)
+	server, err := adaptor.NewServer(podVMProvider, &cfg.serverConfig, workerNode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metrics are served by a monitoring server that is separate from the probe server
+	monitoringServer, err := adaptor.NewMonitoringServer(monitoringAddr, monitoringTokenFile, server, cfg.serverConfig.ForwarderMetricsPort != "")
+	if err != nil {
+		return nil, err
+	}
 
-	return cmd.NewStarter(server), nil
+	return cmd.NewStarter(server, monitoringServer), nil
 }
 
 var config = &daemonConfig{}
@@ -4,9 +4,13 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	cmdUtil "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/cmd"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/userdata"
 	"github.com/spf13/cobra"
 )
@@ -45,6 +49,33 @@ func init() {
 	}
 	provisionFilesCmd.Flags().IntVarP(&fetchTimeout, "user-data-fetch-timeout", "t", 180, "Timeout (in secs) for fetching user data")
 	rootCmd.AddCommand(provisionFilesCmd)
+
+	var unsealTimeout int
+	var unsealFilesCmd = &cobra.Command{
+		Use:   "unseal-files",
+		Short: "Decrypt sealed files in user data with a key obtained after attestation",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cfg := userdata.NewConfig(unsealTimeout)
+			return userdata.UnsealFiles(cfg)
+		},
+		SilenceUsage: true, // Silence usage on error
+	}
+	unsealFilesCmd.Flags().IntVarP(&unsealTimeout, "key-fetch-timeout", "t", 300, "Timeout (in secs) for obtaining the key of sealed files")
+	rootCmd.AddCommand(unsealFilesCmd)
+
+	var statusAddr string
+	var serveStatusCmd = &cobra.Command{
+		Use:   "serve-status",
+		Short: "Serve the boot status of the pod VM when provisioning fails before agent-protocol-forwarder starts",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			return status.Serve(ctx, statusAddr, status.DefaultStatusPath)
+		},
+		SilenceUsage: true, // Silence usage on error
+	}
+	serveStatusCmd.Flags().StringVarP(&statusAddr, "listen", "l", ":"+status.FallbackPort, "Listen address of the status endpoint")
+	rootCmd.AddCommand(serveStatusCmd)
 }
 
 func main() {
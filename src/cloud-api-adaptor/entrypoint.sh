@@ -18,12 +18,31 @@ optionals+=""
 [[ "${PAUSE_IMAGE}" ]] && optionals+="-pause-image ${PAUSE_IMAGE} "
 [[ "${TUNNEL_TYPE}" ]] && optionals+="-tunnel-type ${TUNNEL_TYPE} "
 [[ "${VXLAN_PORT}" ]] && optionals+="-vxlan-port ${VXLAN_PORT} "
+[[ "${GENEVE_PORT}" ]] && optionals+="-geneve-port ${GENEVE_PORT} "
+[[ "${WIREGUARD_TUNNEL_PORT}" ]] && optionals+="-wireguard-tunnel-port ${WIREGUARD_TUNNEL_PORT} "
+[[ "${WIREGUARD_TUNNEL_OVERLAY}" ]] && optionals+="-wireguard-tunnel-overlay ${WIREGUARD_TUNNEL_OVERLAY} "
+[[ "${TUNNEL_CHECK_INTERVAL}" ]] && optionals+="-tunnel-check-interval ${TUNNEL_CHECK_INTERVAL} "
+[[ "${POD_SOURCE_IP_POLICY}" ]] && optionals+="-pod-source-ip-policy ${POD_SOURCE_IP_POLICY} "
+[[ "${CLUSTER_CIDRS}" ]] && optionals+="-cluster-cidrs ${CLUSTER_CIDRS} "
+[[ "${POD_DATAPATH}" ]] && optionals+="-pod-datapath ${POD_DATAPATH} "
 [[ "${CACERT_FILE}" ]] && optionals+="-ca-cert-file ${CACERT_FILE} "
 [[ "${CERT_FILE}" ]] && [[ "${CERT_KEY}" ]] && optionals+="-cert-file ${CERT_FILE} -cert-key ${CERT_KEY} "
 [[ "${TLS_SKIP_VERIFY}" ]] && optionals+="-tls-skip-verify "
 [[ "${PROXY_TIMEOUT}" ]] && optionals+="-proxy-timeout ${PROXY_TIMEOUT} "
+[[ "${PROXY_KEEPALIVE}" ]] && optionals+="-proxy-keepalive ${PROXY_KEEPALIVE} "
+# A provider specific proxy, e.g. AWS_PODVM_PROXY, takes precedence over PODVM_PROXY
+PROVIDER_PODVM_PROXY="$(echo "${CLOUD_PROVIDER}" | tr 'a-z-' 'A-Z_')_PODVM_PROXY"
+PODVM_PROXY=${!PROVIDER_PODVM_PROXY:-$PODVM_PROXY}
+[[ "${PODVM_PROXY}" ]] && optionals+="-podvm-proxy ${PODVM_PROXY} "
+[[ "${PODVM_NO_PROXY}" ]] && optionals+="-podvm-no-proxy ${PODVM_NO_PROXY} "
 [[ "${INITDATA}" ]] && optionals+="-initdata ${INITDATA} "
+[[ "${ALLOWED_KBS_URLS}" ]] && optionals+="-allowed-kbs-urls ${ALLOWED_KBS_URLS} "
+[[ "${INITDATA_INLINE_LIMIT}" ]] && optionals+="-initdata-inline-limit ${INITDATA_INLINE_LIMIT} "
 [[ "${FORWARDER_PORT}" ]] && optionals+="-forwarder-port ${FORWARDER_PORT} "
+[[ "${FORWARDER_COMPRESSION}" ]] && optionals+="-forwarder-compression ${FORWARDER_COMPRESSION} "
+[[ "${FORWARDER_METRICS_PORT}" ]] && optionals+="-forwarder-metrics-port ${FORWARDER_METRICS_PORT} "
+[[ "${MONITORING_ADDR}" ]] && optionals+="-monitoring-addr ${MONITORING_ADDR} "
+[[ "${MONITORING_TOKEN_FILE}" ]] && optionals+="-monitoring-token-file ${MONITORING_TOKEN_FILE} "
 [[ "${CLOUD_CONFIG_VERIFY}" == "true" ]] && optionals+="-cloud-config-verify "
 [[ "${SECURE_COMMS}" == "true" ]] && optionals+="-secure-comms "
 [[ "${SECURE_COMMS_NO_TRUSTEE}" == "true" ]] && optionals+="-secure-comms-no-trustee "
@@ -32,7 +51,20 @@ optionals+=""
 [[ "${SECURE_COMMS_PP_INBOUNDS}" ]] && optionals+="-secure-comms-pp-inbounds ${SECURE_COMMS_PP_INBOUNDS} "
 [[ "${SECURE_COMMS_PP_OUTBOUNDS}" ]] && optionals+="-secure-comms-pp-outbounds ${SECURE_COMMS_PP_OUTBOUNDS} "
 [[ "${SECURE_COMMS_KBS_ADDR}" ]] && optionals+="-secure-comms-kbs ${SECURE_COMMS_KBS_ADDR} "
+[[ "${SECURE_COMMS_TRANSPORT}" ]] && optionals+="-secure-comms-transport ${SECURE_COMMS_TRANSPORT} "
+[[ "${SECURE_COMMS_WG_OVERLAY}" ]] && optionals+="-secure-comms-wg-overlay ${SECURE_COMMS_WG_OVERLAY} "
+[[ "${SEAL_USERDATA}" == "true" ]] && optionals+="-seal-userdata "
+[[ "${REQUIRE_ATTESTATION}" == "true" ]] && optionals+="-require-attestation "
+[[ "${ATTESTATION_TIMEOUT}" ]] && optionals+="-attestation-timeout ${ATTESTATION_TIMEOUT} "
+[[ "${SECURE_COMMS_KEY_ROTATION}" ]] && optionals+="-secure-comms-key-rotation ${SECURE_COMMS_KEY_ROTATION} "
 [[ "${PEERPODS_LIMIT_PER_NODE}" ]] && optionals+="-peerpods-limit-per-node ${PEERPODS_LIMIT_PER_NODE} "
+[[ "${PEERPODS_EXTENDED_RESOURCE}" ]] && optionals+="-peerpods-extended-resource ${PEERPODS_EXTENDED_RESOURCE} "
+[[ "${CLUSTER_ID}" ]] && optionals+="-cluster-id ${CLUSTER_ID} "
+[[ "${USERDATA_SIGNING_KEY}" ]] && optionals+="-userdata-signing-key ${USERDATA_SIGNING_KEY} "
+
+# Options whose values may have spaces are passed as separate quoted arguments instead of in the optionals
+quoted_optionals=()
+[[ "${INSTANCE_NAME_TEMPLATE}" ]] && quoted_optionals+=(-instance-name-template "${INSTANCE_NAME_TEMPLATE}")
 
 test_vars() {
     for i in "$@"; do
@@ -63,12 +95,14 @@ aws() {
     [[ "${USE_PUBLIC_IP}" == "true" ]] && optionals+="-use-public-ip "                 # Use public IP for pod vm
     [[ "${ROOT_VOLUME_SIZE}" ]] && optionals+="-root-volume-size ${ROOT_VOLUME_SIZE} " # Specify root volume size for pod vm
     [[ "${DISABLECVM}" == "true" ]] && optionals+="-disable-cvm "
+    [[ "${USERDATA_FORMAT}" ]] && optionals+="-userdata-format ${USERDATA_FORMAT} "
+    [[ "${USERDATA_S3_BUCKET}" ]] && optionals+="-userdata-s3-bucket ${USERDATA_S3_BUCKET} "
 
     set -x
     exec cloud-api-adaptor aws \
         -pods-dir "${PEER_PODS_DIR}" \
         -socket "${REMOTE_HYPERVISOR_ENDPOINT}" \
-        ${optionals}
+        ${optionals} "${quoted_optionals[@]}"
 
 }
 
@@ -81,6 +115,9 @@ azure() {
     [[ "${TAGS}" ]] && optionals+="-tags ${TAGS} " # Custom tags applied to pod vm
     [[ "${ENABLE_SECURE_BOOT}" == "true" ]] && optionals+="-enable-secure-boot "
     [[ "${USE_PUBLIC_IP}" == "true" ]] && optionals+="-use-public-ip "
+    [[ "${USERDATA_FORMAT}" ]] && optionals+="-userdata-format ${USERDATA_FORMAT} "
+    [[ "${AZURE_USERDATA_STORAGE_ACCOUNT}" ]] && optionals+="-userdata-storage-account ${AZURE_USERDATA_STORAGE_ACCOUNT} "
+    [[ "${AZURE_USERDATA_CONTAINER}" ]] && optionals+="-userdata-container ${AZURE_USERDATA_CONTAINER} " # defaults to 'peerpod-userdata'
 
     set -x
     exec cloud-api-adaptor azure \
@@ -93,7 +130,7 @@ azure() {
         -subnetid "${AZURE_SUBNET_ID}" \
         -securitygroupid "${AZURE_NSG_ID}" \
         -imageid "${AZURE_IMAGE_ID}" \
-        ${optionals}
+        ${optionals} "${quoted_optionals[@]}"
 }
 
 gcp() {
@@ -105,18 +142,21 @@ gcp() {
     [[ "${GCP_MACHINE_TYPE}" ]] && optionals+="-machine-type ${GCP_MACHINE_TYPE} " # default e2-medium
     [[ "${GCP_NETWORK}" ]] && optionals+="-network ${GCP_NETWORK} "                # defaults to 'default'
     [[ "${GCP_DISK_TYPE}" ]] && optionals+="-disk-type ${GCP_DISK_TYPE} "          # defaults to 'pd-standard'
+    [[ "${USERDATA_FORMAT}" ]] && optionals+="-userdata-format ${USERDATA_FORMAT} "
+    [[ "${USERDATA_GCS_BUCKET}" ]] && optionals+="-userdata-gcs-bucket ${USERDATA_GCS_BUCKET} "
 
     set -x
     exec cloud-api-adaptor gcp \
         -pods-dir "${PEER_PODS_DIR}" \
         -socket "${REMOTE_HYPERVISOR_ENDPOINT}" \
-        ${optionals}
+        ${optionals} "${quoted_optionals[@]}"
 }
 
 ibmcloud() {
     one_of IBMCLOUD_API_KEY IBMCLOUD_IAM_PROFILE_ID
 
     [[ "${DISABLECVM}" = "true" ]] && optionals+="-disable-cvm "
+    [[ "${USERDATA_FORMAT}" ]] && optionals+="-userdata-format ${USERDATA_FORMAT} "
 
     set -x
     exec cloud-api-adaptor ibmcloud \
@@ -133,7 +173,7 @@ ibmcloud() {
         -primary-subnet-id "${IBMCLOUD_VPC_SUBNET_ID}" \
         -primary-security-group-id "${IBMCLOUD_VPC_SG_ID}" \
         -vpc-id "${IBMCLOUD_VPC_ID}" \
-        ${optionals}
+        ${optionals} "${quoted_optionals[@]}"
 
 }
 
@@ -155,7 +195,7 @@ ibmcloud_powervs() {
         -image-id "${POWERVS_IMAGE_ID}" \
         -network-id "${POWERVS_NETWORK_ID}" \
         -ssh-key "${POWERVS_SSH_KEY_NAME}" \
-        ${optionals}
+        ${optionals} "${quoted_optionals[@]}"
 
 }
 
@@ -171,7 +211,7 @@ libvirt() {
         -data-dir /opt/data-dir \
         -network-name "${LIBVIRT_NET:-default}" \
         -pool-name "${LIBVIRT_POOL:-default}" \
-        ${optionals}
+        ${optionals} "${quoted_optionals[@]}"
 
 }
 
@@ -191,7 +231,7 @@ vsphere() {
         -socket "${REMOTE_HYPERVISOR_ENDPOINT}" \
         -vcenter-url ${GOVC_URL} \
         -data-center ${GOVC_DATACENTER} \
-        ${optionals}
+        ${optionals} "${quoted_optionals[@]}"
 
 }
 
@@ -207,16 +247,28 @@ docker() {
     exec cloud-api-adaptor docker \
         -pods-dir "${PEER_PODS_DIR}" \
         -socket "${REMOTE_HYPERVISOR_ENDPOINT}" \
-        ${optionals}
+        ${optionals} "${quoted_optionals[@]}"
+
+}
+
+mock() {
+    [[ "${MOCK_INSTANCE_IP}" ]] && optionals+="-mock-instance-ip ${MOCK_INSTANCE_IP} "
+    [[ "${MOCK_DATA_DIR}" ]] && optionals+="-data-dir ${MOCK_DATA_DIR} "
+
+    set -x
+    exec cloud-api-adaptor mock \
+        -pods-dir "${PEER_PODS_DIR}" \
+        -socket "${REMOTE_HYPERVISOR_ENDPOINT}" \
+        ${optionals} "${quoted_optionals[@]}"
 
 }
 
 help_msg() {
     cat <<EOF
 Usage:
-	CLOUD_PROVIDER=aws|azure|gcp|ibmcloud|ibmcloud-powervs|libvirt|vsphere|docker $0
+	CLOUD_PROVIDER=aws|azure|gcp|ibmcloud|ibmcloud-powervs|libvirt|vsphere|docker|mock $0
 or
-	$0 aws|azure|gcp|ibmcloud|ibmcloud-powervs|libvirt|vsphere|docker
+	$0 aws|azure|gcp|ibmcloud|ibmcloud-powervs|libvirt|vsphere|docker|mock
 
 in addition all cloud provider specific env variables must be set and valid
 (CLOUD_PROVIDER is currently set to "$CLOUD_PROVIDER")
@@ -239,6 +291,8 @@ elif [[ "$CLOUD_PROVIDER" == "vsphere" ]]; then
     vsphere
 elif [[ "$CLOUD_PROVIDER" == "docker" ]]; then
     docker
+elif [[ "$CLOUD_PROVIDER" == "mock" ]]; then
+    mock
 else
     help_msg
 fi
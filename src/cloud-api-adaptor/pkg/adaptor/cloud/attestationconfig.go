@@ -0,0 +1,184 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+// configFragment is a TOML fragment that is merged into a config file of initdata
+type configFragment struct {
+	file   string
+	source string
+	toml   string
+}
+
+// kbsKeys are the keys of the attestation agent and confidential data hub configs that select a KBS. Fragments must
+// not set them, since they are not checked against the allowed KBS URLs. The kbs_url annotation and the initdata of a
+// pod are checked by getKBSOverride and checkInitdataKBS
+var kbsKeys = map[string][][]string{
+	aaConfigName:  {{"token_configs", "kbs", "url"}, {"token_configs", "kbs", "cert"}},
+	cdhConfigName: {{"kbc", "url"}, {"kbc", "kbs_cert"}},
+}
+
+// attestationConfigFragments returns the fragments of the attestation agent and confidential data hub configs of a pod
+// in the order they are merged. The ConfigMap selected by the pod or its namespace is merged first, then the annotations
+// of the namespace, and then the annotations of the pod
+func attestationConfigFragments(getter objectDataGetter, namespace string, podAnnotations, nsAnnotations map[string]string) ([]configFragment, error) {
+	var fragments []configFragment
+
+	configMap := podAnnotations[util.AttestationConfigMapAnnotation]
+	if configMap == "" {
+		configMap = nsAnnotations[util.AttestationConfigMapAnnotation]
+	}
+	if configMap != "" {
+		if getter == nil {
+			return nil, fmt.Errorf("%s annotation requires access to the Kubernetes API", util.AttestationConfigMapAnnotation)
+		}
+		data, err := getter.GetConfigMapData(configMap, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ConfigMap %s in namespace %s: %w", configMap, namespace, err)
+		}
+		for _, file := range []string{aaConfigName, cdhConfigName} {
+			if value, ok := data[file]; ok {
+				fragments = append(fragments, configFragment{file: file, source: "ConfigMap " + configMap, toml: string(value)})
+			}
+		}
+	}
+
+	for _, annotations := range []struct {
+		source string
+		values map[string]string
+	}{
+		{"namespace " + namespace, nsAnnotations},
+		{"pod", podAnnotations},
+	} {
+		if value, ok := annotations.values[util.AAConfigAnnotation]; ok {
+			fragments = append(fragments, configFragment{file: aaConfigName, source: util.AAConfigAnnotation + " annotation of " + annotations.source, toml: value})
+		}
+		if value, ok := annotations.values[util.CDHConfigAnnotation]; ok {
+			fragments = append(fragments, configFragment{file: cdhConfigName, source: util.CDHConfigAnnotation + " annotation of " + annotations.source, toml: value})
+		}
+	}
+
+	return fragments, nil
+}
+
+// mergeInitdataConfig merges fragments expanded with the variables of userdata templates into the config files of
+// base64 encoded initdata. Initdata is created if it is empty
+func mergeInitdataConfig(initdataStr string, fragments []configFragment, vars *cloudinit.UserDataVars) (string, error) {
+	data, err := decodeInitdata(initdataStr)
+	if err != nil {
+		return "", err
+	}
+
+	for _, fragment := range fragments {
+		expanded, err := cloudinit.ExpandUserDataVars(fragment.toml, vars)
+		if err != nil {
+			return "", fmt.Errorf("expanding %s: %w", fragment.source, err)
+		}
+		if err := checkKBSKeys(fragment.file, expanded); err != nil {
+			return "", fmt.Errorf("%s: %w", fragment.source, err)
+		}
+		merged, err := mergeTOML(data.Data[fragment.file], expanded)
+		if err != nil {
+			return "", fmt.Errorf("merging %s into %s: %w", fragment.source, fragment.file, err)
+		}
+		data.Data[fragment.file] = merged
+	}
+
+	return encodeInitdata(data)
+}
+
+// checkKBSKeys returns an error if a fragment of file sets a key that selects a KBS, or replaces a table with such a key
+func checkKBSKeys(file, fragment string) error {
+	config := map[string]interface{}{}
+	if err := toml.Unmarshal([]byte(fragment), &config); err != nil {
+		return fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	for _, path := range kbsKeys[file] {
+		table := config
+		for i, key := range path {
+			value, ok := table[key]
+			if !ok {
+				break
+			}
+			next, isTable := value.(map[string]interface{})
+			if !isTable || i == len(path)-1 {
+				return fmt.Errorf("%s of %s selects a KBS, which is only allowed with the %s annotation", strings.Join(path[:i+1], "."), file, util.KBSURLAnnotation)
+			}
+			table = next
+		}
+	}
+	return nil
+}
+
+// decodeInitdata decodes base64 encoded initdata, or returns empty initdata with the default algorithm if it is empty
+func decodeInitdata(initdataStr string) (*InitData, error) {
+	data := &InitData{
+		Algorithm: defaultInitdataAlgorithm,
+		Version:   defaultInitdataVersion,
+	}
+	if initdataStr != "" {
+		decoded, err := base64.StdEncoding.DecodeString(initdataStr)
+		if err != nil {
+			return nil, fmt.Errorf("error base64 decode initdata: %w", err)
+		}
+		if err := toml.Unmarshal(decoded, data); err != nil {
+			return nil, fmt.Errorf("error unmarshalling initdata: %w", err)
+		}
+	}
+	if data.Data == nil {
+		data.Data = map[string]string{}
+	}
+	return data, nil
+}
+
+func encodeInitdata(data *InitData) (string, error) {
+	encoded, err := toml.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling initdata: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+// mergeTOML merges a fragment into a TOML document. Tables are merged recursively, and other values of the fragment
+// replace the ones of the document
+func mergeTOML(document, fragment string) (string, error) {
+	config := map[string]interface{}{}
+	if err := toml.Unmarshal([]byte(document), &config); err != nil {
+		return "", err
+	}
+	extra := map[string]interface{}{}
+	if err := toml.Unmarshal([]byte(fragment), &extra); err != nil {
+		return "", err
+	}
+
+	mergeTables(config, extra)
+
+	encoded, err := toml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func mergeTables(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcTable, srcOK := value.(map[string]interface{})
+		dstTable, dstOK := dst[key].(map[string]interface{})
+		if srcOK && dstOK {
+			mergeTables(dstTable, srcTable)
+		} else {
+			dst[key] = value
+		}
+	}
+}
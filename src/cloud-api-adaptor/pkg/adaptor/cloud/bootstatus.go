@@ -0,0 +1,295 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
+)
+
+const (
+	bootStatusInterval = 2 * time.Second
+	bootStatusTimeout  = 5 * time.Second
+)
+
+var (
+	bootPhaseSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "peerpod",
+		Subsystem: "adaptor",
+		Name:      "podvm_boot_phase_seconds",
+		Help:      "Time from StartVM until a pod VM reported a boot phase",
+		Buckets:   prometheus.ExponentialBuckets(5, 2, 8),
+	}, []string{"phase"})
+
+	bootPhaseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "adaptor",
+		Name:      "podvm_boot_phase_failures_total",
+		Help:      "Number of boot phases that pod VMs reported as failed",
+	}, []string{"phase"})
+
+	attestationGateFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "adaptor",
+		Name:      "podvm_attestation_gate_failures_total",
+		Help:      "Number of pod VMs that were not started, since they did not report successful attestation in time",
+	})
+)
+
+// BootStatusCollectors returns the metrics of the boot phases of pod VMs
+func BootStatusCollectors() []prometheus.Collector {
+	return []prometheus.Collector{bootPhaseSeconds, bootPhaseFailures, attestationGateFailures}
+}
+
+// Reasons of the pod events of boot phases
+var bootPhaseReasons = map[status.Phase][2]string{
+	status.PhaseUserDataApplied: {"PodVMUserDataApplied", "PodVMUserDataFailed"},
+	status.PhaseAttested:        {"PodVMAttested", "PodVMAttestationFailed"},
+	status.PhaseAgentReady:      {"PodVMAgentReady", "PodVMAgentFailed"},
+}
+
+// bootWatcher polls the boot status of a pod VM from the metrics endpoint of its agent-protocol-forwarder.
+// Until the forwarder starts, it polls the fallback endpoint that process-user-data serves when provisioning fails
+type bootWatcher struct {
+	client         *http.Client
+	url            string
+	fallbackClient *http.Client
+	fallbackURL    string
+	onReport       func(status.Report)
+
+	mutex    sync.Mutex
+	reported map[string]bool
+	last     *status.Report
+	// attestation is the report of PhaseAttested, and attestedCh is closed when it is reported
+	attestation *status.Report
+	attestedCh  chan struct{}
+	// Successful attestation is only taken if it is proven with the key of the sealed userdata resource
+	proofKey      []byte
+	proofResource string
+}
+
+// newBootWatcher returns a watcher of the metrics endpoint at metricsAddr, which is served over HTTPS with tlsConfig,
+// and of the fallback endpoint at fallbackAddr unless it is empty
+func newBootWatcher(metricsAddr string, tlsConfig *tls.Config, fallbackAddr string, onReport func(status.Report)) *bootWatcher {
+	statusURL := &url.URL{
+		Scheme: "http",
+		Host:   metricsAddr,
+		Path:   status.StatusURLPath,
+	}
+	client := &http.Client{Timeout: bootStatusTimeout}
+	if tlsConfig != nil {
+		statusURL.Scheme = "https"
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	var fallbackURL string
+	if fallbackAddr != "" {
+		fallbackURL = (&url.URL{Scheme: "http", Host: fallbackAddr, Path: status.StatusURLPath}).String()
+	}
+	return &bootWatcher{
+		client:         client,
+		url:            statusURL.String(),
+		fallbackClient: &http.Client{Timeout: bootStatusTimeout},
+		fallbackURL:    fallbackURL,
+		onReport:       onReport,
+		reported:       make(map[string]bool),
+		attestedCh:     make(chan struct{}),
+	}
+}
+
+// requireAttestationProof makes the watcher ignore reports of successful attestation that are not proven with
+// the key of the sealed userdata resource. Trustee releases the key only to the attested pod VM, so that the
+// status endpoint can't be used to forge attestation. It must be called before the watcher polls
+func (w *bootWatcher) requireAttestationProof(key []byte, resource string) {
+	w.proofKey = key
+	w.proofResource = resource
+}
+
+// run polls the boot status until ctx is canceled
+func (w *bootWatcher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Errors are expected until the forwarder starts, so they are not logged
+		_ = w.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *bootWatcher) poll(ctx context.Context) error {
+	st, err := w.fetch(ctx, w.client, w.url)
+	if err != nil {
+		if w.fallbackURL == "" {
+			return err
+		}
+		// The forwarder doesn't start if provisioning of the pod VM fails. The fallback endpoint is not
+		// authenticated, so only failures are taken from it, which can't make a pod VM pass attestation gating
+		fallback, fallbackErr := w.fetch(ctx, w.fallbackClient, w.fallbackURL)
+		if fallbackErr != nil {
+			return err
+		}
+		var failures []status.Report
+		for _, report := range fallback.Reports {
+			if report.Error != "" {
+				failures = append(failures, report)
+			}
+		}
+		st = &status.Status{Reports: failures}
+	}
+
+	w.mutex.Lock()
+	var reports []status.Report
+	for _, report := range st.Reports {
+		// Reports of the fallback endpoint are served by the forwarder as well once it starts
+		key := fmt.Sprintf("%s/%s/%s", report.Phase, report.Time.Format(time.RFC3339Nano), report.Error)
+		if w.reported[key] {
+			continue
+		}
+		w.reported[key] = true
+		if w.proofKey != nil && report.Phase == status.PhaseAttested && report.Error == "" && !report.VerifyAttestation(w.proofKey, w.proofResource) {
+			logger.Printf("ignoring a report of successful attestation without a valid proof from %s", w.url)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	for i := range reports {
+		w.last = &reports[i]
+		if reports[i].Phase == status.PhaseAttested && w.attestation == nil {
+			w.attestation = &reports[i]
+			close(w.attestedCh)
+		}
+	}
+	w.mutex.Unlock()
+
+	for _, report := range reports {
+		w.onReport(report)
+	}
+
+	return nil
+}
+
+// fetch gets the boot status from statusURL
+func (w *bootWatcher) fetch(ctx context.Context, client *http.Client, statusURL string) (*status.Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", statusURL, res.Status)
+	}
+
+	var st status.Status
+	if err := json.NewDecoder(res.Body).Decode(&st); err != nil {
+		return nil, fmt.Errorf("failed to decode boot status: %w", err)
+	}
+	return &st, nil
+}
+
+// describe explains how far the pod VM got in its boot sequence
+func (w *bootWatcher) describe() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	switch {
+	case w.last == nil:
+		return "pod VM did not report any boot phase"
+	case w.last.Error != "":
+		return fmt.Sprintf("pod VM failed at boot phase %s: %s", w.last.Phase, w.last.Error)
+	}
+	return fmt.Sprintf("last boot phase reported by pod VM: %s", w.last.Phase)
+}
+
+// waitAttestation waits until the pod VM reports the result of attestation, and returns an error unless it succeeded
+func (w *bootWatcher) waitAttestation(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("pod VM did not report attestation: %w", ctx.Err())
+	case <-w.attestedCh:
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.attestation.Error != "" {
+		return fmt.Errorf("attestation of pod VM failed: %s", w.attestation.Error)
+	}
+	return nil
+}
+
+// gateAttestation waits for successful attestation of the pod VM of a sandbox until the attestation timeout.
+// A pod VM that is not attested is reported with a distinct event of the pod
+func (s *cloudService) gateAttestation(ctx context.Context, sandbox *sandbox, watcher *bootWatcher) error {
+	if watcher.proofKey == nil {
+		return fmt.Errorf("attestation gating requires sealed userdata, whose key proves attestation")
+	}
+
+	logger.Printf("waiting for attestation of the pod VM of pod %s/%s", sandbox.podNamespace, sandbox.podName)
+
+	ctx, cancel := context.WithTimeout(ctx, s.serverConfig.AttestationTimeout)
+	defer cancel()
+
+	err := watcher.waitAttestation(ctx)
+	if err == nil {
+		logger.Printf("pod VM of pod %s/%s is attested", sandbox.podNamespace, sandbox.podName)
+		return nil
+	}
+
+	attestationGateFailures.Inc()
+	message := fmt.Sprintf("Pod VM is not started without successful attestation: %v", err)
+	logger.Printf("pod %s/%s: %s", sandbox.podNamespace, sandbox.podName, message)
+	if s.ppService != nil {
+		if err := s.ppService.RecordEvent(sandbox.podName, sandbox.podNamespace, "Warning", "PodVMAttestationGateFailed", message); err != nil {
+			logger.Printf("failed to record an event of pod %s in namespace %s: %v", sandbox.podName, sandbox.podNamespace, err)
+		}
+	}
+	return err
+}
+
+// reportBootPhase logs a boot phase of the pod VM of a sandbox, and exposes it in metrics and as an event of the pod
+func (s *cloudService) reportBootPhase(sandbox *sandbox, started time.Time, report status.Report) {
+
+	eventType, message := "Normal", fmt.Sprintf("Pod VM reached boot phase %s", report.Phase)
+	reason := bootPhaseReasons[report.Phase][0]
+	if report.Error != "" {
+		eventType, message = "Warning", fmt.Sprintf("Pod VM failed at boot phase %s: %s", report.Phase, report.Error)
+		reason = bootPhaseReasons[report.Phase][1]
+		bootPhaseFailures.WithLabelValues(string(report.Phase)).Inc()
+	} else {
+		bootPhaseSeconds.WithLabelValues(string(report.Phase)).Observe(time.Since(started).Seconds())
+	}
+	if reason == "" {
+		reason = "PodVMBootPhase"
+	}
+
+	logger.Printf("pod %s/%s: %s", sandbox.podNamespace, sandbox.podName, message)
+
+	if s.ppService == nil {
+		return
+	}
+	if err := s.ppService.RecordEvent(sandbox.podName, sandbox.podNamespace, eventType, reason, message); err != nil {
+		logger.Printf("failed to record an event of pod %s in namespace %s: %v", sandbox.podName, sandbox.podNamespace, err)
+	}
+}
@@ -5,12 +5,14 @@ package cloud
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -24,14 +26,20 @@ import (
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/k8sops"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/proxy"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
 	. "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/wnssh"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/wnwg"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/wgutil"
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
 	putil "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+	peerPodV1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
 	toml "github.com/pelletier/go-toml/v2"
 )
 
@@ -52,7 +60,13 @@ type ServerConfig struct {
 	PauseImage              string
 	PodsDir                 string
 	ForwarderPort           string
+	ForwarderMetricsPort    string
 	ProxyTimeout            time.Duration
+	UpstreamProxy           *url.URL
+	UpstreamNoProxy         []netip.Prefix
+	ProxyKeepalive          time.Duration
+	ForwarderCompression    []string
+	TunnelCheckInterval     time.Duration
 	Initdata                string
 	EnableCloudConfigVerify bool
 	SecureComms             bool
@@ -62,7 +76,19 @@ type ServerConfig struct {
 	SecureCommsPpInbounds   string
 	SecureCommsPpOutbounds  string
 	SecureCommsKbsAddress   string
+	SecureCommsTransport    string
+	SecureCommsWgOverlay    netip.Prefix
+	SecureCommsKeyRotation  time.Duration
+	SealUserData            bool
+	ClusterID               string
+	InstanceNamer           *putil.InstanceNamer
+	CloudProvider           string
 	PeerPodsLimitPerNode    int
+	ExtendedResource        string
+	AllowedKBSURLs          []string
+	InitdataInlineLimit     int
+	RequireAttestation      bool
+	AttestationTimeout      time.Duration
 }
 
 var logger = log.New(log.Writer(), "[adaptor/cloud] ", log.LstdFlags|log.Lmsgprefix)
@@ -104,12 +130,21 @@ func (s *cloudService) removeSandbox(id sandboxID) error {
 }
 
 func NewService(provider provider.Provider, proxyFactory proxy.Factory, workerNode podnetwork.WorkerNode,
-	serverConfig *ServerConfig, sshport string) Service {
+	serverConfig *ServerConfig, sshport string) (Service, error) {
 	var err error
 	var sshClient *wnssh.SshClient
+	var wgClient *wnwg.WgClient
 
-	if serverConfig.SecureComms {
+	if serverConfig.SecureComms && serverConfig.SecureCommsTransport == "wireguard" {
+		wgClient, err = wnwg.InitWgClient(serverConfig.SecureCommsTrustee, serverConfig.SecureCommsKbsAddress, wgutil.DefaultPort, serverConfig.SecureCommsWgOverlay)
+		if err != nil {
+			return nil, fmt.Errorf("InitWgClient: %w", err)
+		}
+	} else if serverConfig.SecureComms {
 		inbounds := append([]string{"KUBERNETES_PHASE:KATAAGENT:0"}, strings.Split(serverConfig.SecureCommsInbounds, ",")...)
+		if serverConfig.ForwarderMetricsPort != "" {
+			inbounds = append(inbounds, "KUBERNETES_PHASE:METRICS:0")
+		}
 
 		var outbounds []string
 		outbounds = append(outbounds, strings.Split(serverConfig.SecureCommsOutbounds, ",")...)
@@ -119,8 +154,9 @@ func NewService(provider provider.Provider, proxyFactory proxy.Factory, workerNo
 
 		sshClient, err = wnssh.InitSshClient(inbounds, outbounds, serverConfig.SecureCommsTrustee, serverConfig.SecureCommsKbsAddress, sshport)
 		if err != nil {
-			log.Fatalf("InitSshClient %v", err)
+			return nil, fmt.Errorf("InitSshClient: %w", err)
 		}
+		sshClient.SetKeyRotation(serverConfig.SecureCommsKeyRotation)
 	}
 
 	s := &cloudService{
@@ -130,6 +166,15 @@ func NewService(provider provider.Provider, proxyFactory proxy.Factory, workerNo
 		serverConfig: serverConfig,
 		workerNode:   workerNode,
 		sshClient:    sshClient,
+		wgClient:     wgClient,
+		credentials:  newCredentialsProviders(),
+	}
+	if serverConfig.SealUserData {
+		kbsClient, err := initKbsClient(serverConfig.SecureCommsKbsAddress)
+		if err != nil {
+			return nil, fmt.Errorf("initKbsClient: %w", err)
+		}
+		s.kbsClient = kbsClient
 	}
 	s.cond = sync.NewCond(&s.mutex)
 	s.ppService, err = k8sops.NewPeerPodService()
@@ -137,7 +182,7 @@ func NewService(provider provider.Provider, proxyFactory proxy.Factory, workerNo
 		logger.Printf("failed to create PeerPodService, runtime failure may result in dangling resources %s", err)
 	}
 
-	return s
+	return s, nil
 }
 
 func (s *cloudService) Teardown() error {
@@ -148,6 +193,12 @@ func (s *cloudService) ConfigVerifier() error {
 	return s.provider.ConfigVerifier()
 }
 
+// instanceNameContext returns a copy of ctx carrying the instance namer and the pod namespace that providers
+// use to name the instance of a pod
+func (s *cloudService) instanceNameContext(ctx context.Context, namespace string) context.Context {
+	return putil.WithPodNamespace(putil.WithInstanceNamer(ctx, s.serverConfig.InstanceNamer), namespace)
+}
+
 func (s *cloudService) setInstance(sid sandboxID, instanceID, instanceName string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -190,6 +241,37 @@ func (s *cloudService) GetInstanceID(ctx context.Context, podNamespace, podName
 	}
 }
 
+// GetMetricsAddr returns the address of the metrics endpoint of the pod VM of a pod, and the TLS config
+// to connect to it, which is nil if the endpoint is reached through a secure comms tunnel or TLS is disabled
+func (s *cloudService) GetMetricsAddr(podNamespace, podName string) (string, *tls.Config, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, sandbox := range s.sandboxes {
+		if sandbox.podNamespace == podNamespace && sandbox.podName == podName {
+			if sandbox.metricsAddr == "" {
+				return "", nil, fmt.Errorf("metrics of pod %s/%s are not available", podNamespace, podName)
+			}
+			return sandbox.metricsAddr, sandbox.metricsTLS, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("pod %s/%s was not found", podNamespace, podName)
+}
+
+func (s *cloudService) GetMeasurement(podNamespace, podName string) (*initdata.Measurement, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, sandbox := range s.sandboxes {
+		if sandbox.podNamespace == podNamespace && sandbox.podName == podName {
+			return sandbox.measurement, nil
+		}
+	}
+
+	return nil, fmt.Errorf("pod %s/%s was not found", podNamespace, podName)
+}
+
 func (s *cloudService) Version(ctx context.Context, req *pb.VersionRequest) (*pb.VersionResponse, error) {
 	return &pb.VersionResponse{Version: Version}, nil
 }
@@ -217,12 +299,38 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 		return nil, fmt.Errorf("namespace name %s is missing in annotations", annotations.SandboxNamespace)
 	}
 
+	// The runtime only passes a fixed set of annotations, so other annotations are taken from the pod if possible
+	podAnnotations := req.Annotations
+	if s.ppService != nil {
+		if podAnnotations, err = s.ppService.GetPodAnnotations(pod, namespace); err != nil {
+			return nil, fmt.Errorf("failed to get annotations of pod %s in namespace %s: %w", pod, namespace, err)
+		}
+	}
+
+	// Get agent-protocol-forwarder port from annotations
+	forwarderPort, err := util.GetForwarderPortFromAnnotation(podAnnotations)
+	if err != nil {
+		return nil, err
+	}
+	if forwarderPort == "" {
+		forwarderPort = s.serverConfig.ForwarderPort
+	}
+
+	// Get pod network MTU override from annotations
+	tunnelMTU, err := util.GetTunnelMTUFromAnnotation(podAnnotations)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get Pod VM instance type from annotations
 	instanceType := util.GetInstanceTypeFromAnnotation(req.Annotations)
 
 	// Get Pod VM cpu and memory from annotations
 	vcpus, memory, gpus := util.GetPodvmResourcesFromAnnotation(req.Annotations)
 
+	// Get Pod VM GPU model from annotations
+	gpuModel := util.GetGPUModelFromAnnotation(req.Annotations)
+
 	// Get Pod VM image from annotations
 	image := util.GetImageFromAnnotation(req.Annotations)
 
@@ -232,11 +340,15 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 		VCPUs:        vcpus,
 		Memory:       memory,
 		GPUs:         gpus,
+		GPUModel:     gpuModel,
 		Image:        image,
 	}
 
 	// TODO: server name is also generated in each cloud provider, and possibly inconsistent
-	serverName := putil.GenerateInstanceName(pod, string(sid), 63)
+	serverName, err := putil.GenerateInstanceName(s.instanceNameContext(ctx, namespace), pod, string(sid), putil.DefaultInstanceNameRules)
+	if err != nil {
+		return nil, err
+	}
 
 	netNSPath := req.NetworkNamespacePath
 
@@ -244,6 +356,45 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect netns %s: %w", netNSPath, err)
 	}
+	defer func() {
+		if err != nil {
+			s.workerNode.Release(podNetworkConfig)
+		}
+	}()
+
+	if tunnelMTU != 0 {
+		logger.Printf("MTU of the pod network of sandbox %s is set to %d", sid, tunnelMTU)
+		podNetworkConfig.MTU = tunnelMTU
+		podNetworkConfig.MTUOverride = true
+	}
+
+	// Host ports are not set in annotations, so they are taken from the pod spec
+	if s.ppService != nil {
+		ports, err := s.ppService.GetHostPorts(pod, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get host ports of pod %s in namespace %s: %w", pod, namespace, err)
+		}
+		for _, port := range ports {
+			hostPort := &tunneler.HostPort{
+				HostPort:      int(port.HostPort),
+				ContainerPort: int(port.ContainerPort),
+				Protocol:      string(port.Protocol),
+			}
+			if port.HostIP != "" {
+				if hostPort.HostIP, err = netip.ParseAddr(port.HostIP); err != nil {
+					return nil, fmt.Errorf("invalid host IP of host port %d: %w", port.HostPort, err)
+				}
+			}
+			podNetworkConfig.HostPorts = append(podNetworkConfig.HostPorts, hostPort)
+		}
+	}
+
+	if podNetworkConfig.IngressBandwidth, err = util.GetBandwidthFromAnnotation(podAnnotations, util.IngressBandwidthAnnotation); err != nil {
+		return nil, err
+	}
+	if podNetworkConfig.EgressBandwidth, err = util.GetBandwidthFromAnnotation(podAnnotations, util.EgressBandwidthAnnotation); err != nil {
+		return nil, err
+	}
 
 	podDir := filepath.Join(s.serverConfig.PodsDir, string(sid))
 	if err := os.MkdirAll(podDir, os.ModePerm); err != nil {
@@ -265,11 +416,29 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 		logger.Printf("configure agent to use credentials file %s", SrcAuthfilePath)
 	}
 
+	userDataVars := &cloudinit.UserDataVars{
+		PodName:   pod,
+		Namespace: namespace,
+		SandboxID: string(sid),
+		NodeName:  os.Getenv("NODE_NAME"),
+		ClusterID: s.serverConfig.ClusterID,
+		Provider:  s.serverConfig.CloudProvider,
+	}
+
 	daemonConfig := forwarder.Config{
 		PodNamespace: namespace,
 		PodName:      pod,
+		SandboxID:    userDataVars.SandboxID,
+		NodeName:     userDataVars.NodeName,
+		ClusterID:    userDataVars.ClusterID,
+		Provider:     userDataVars.Provider,
 		PodNetwork:   podNetworkConfig,
 		TLSClientCA:  string(agentProxy.ClientCA()),
+		MetricsPort:  s.serverConfig.ForwarderMetricsPort,
+
+		TunnelCheckInterval: s.serverConfig.TunnelCheckInterval,
+
+		ForwarderPort: forwarderPort,
 	}
 
 	if caService := agentProxy.CAService(); caService != nil {
@@ -299,6 +468,23 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 		}
 	}
 
+	var wgCi *wnwg.WgClientInstance
+
+	if s.wgClient != nil {
+		wgCi, err = s.wgClient.InitPP(string(sid))
+		if err != nil {
+			return nil, fmt.Errorf("failed wgClient.InitPP: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				wgCi.Release()
+			}
+		}()
+		daemonConfig.SecureCommsTransport = "wireguard"
+		daemonConfig.WgAddress = wgCi.GetPpPrefix().String()
+		daemonConfig.WgWnAddress = s.wgClient.GetWnAddr().String()
+	}
+
 	daemonJSON, err := json.MarshalIndent(daemonConfig, "", "    ")
 	if err != nil {
 		return nil, fmt.Errorf("generating JSON data: %w", err)
@@ -318,6 +504,7 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 				Content: string(daemonJSON),
 			},
 		},
+		SandboxID: string(sid),
 	}
 
 	if authJSON != nil {
@@ -331,6 +518,13 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 		}
 	}
 
+	var nsAnnotations map[string]string
+	if s.ppService != nil {
+		if nsAnnotations, err = s.ppService.GetNamespaceAnnotations(namespace); err != nil {
+			return nil, fmt.Errorf("failed to get annotations of namespace %s: %w", namespace, err)
+		}
+	}
+
 	initdataStr := util.GetInitdataFromAnnotation(req.Annotations)
 	logger.Printf("initdata in Pod annotation: %s", initdataStr)
 
@@ -339,21 +533,130 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 		initdataStr = s.serverConfig.Initdata
 	}
 
+	var getter objectDataGetter
+	if s.ppService != nil {
+		getter = s.ppService
+	}
+	fragments, err := attestationConfigFragments(getter, namespace, podAnnotations, nsAnnotations)
+	if err != nil {
+		return nil, err
+	}
+	if len(fragments) > 0 {
+		if initdataStr, err = mergeInitdataConfig(initdataStr, fragments, userDataVars); err != nil {
+			return nil, fmt.Errorf("merging attestation config of pod %s: %w", pod, err)
+		}
+	}
+
+	kbs, err := getKBSOverride(podAnnotations, nsAnnotations, s.serverConfig.AllowedKBSURLs)
+	if err != nil {
+		return nil, err
+	}
+	if kbs != nil {
+		// The key of sealed userdata and secure comms are stored in the KBS of cloud-api-adaptor
+		if s.serverConfig.SealUserData || (s.serverConfig.SecureComms && s.serverConfig.SecureCommsTrustee) {
+			return nil, fmt.Errorf("%s annotation is not supported with sealed userdata or secure comms", util.KBSURLAnnotation)
+		}
+		logger.Printf("pod %s attests against KBS %s", pod, kbs.URL)
+		if initdataStr, err = overrideInitdataKBS(initdataStr, kbs); err != nil {
+			return nil, err
+		}
+	}
+
+	// The initdata annotation is passed to the runtime, so a pod can set its KBS without the kbs_url annotation
+	if err := checkInitdataKBS(initdataStr, s.serverConfig.Initdata, s.serverConfig.AllowedKBSURLs); err != nil {
+		return nil, fmt.Errorf("initdata of pod %s: %w", pod, err)
+	}
+
+	var decodedInitdata []byte
+	var sentInitdata string
 	if initdataStr != "" {
 		decodedBytes, err := base64.StdEncoding.DecodeString(initdataStr)
 		if err != nil {
 			return nil, fmt.Errorf("error base64 decode initdata: %w", err)
 		}
+		decodedInitdata = decodedBytes
 		initdata := InitData{}
 		err = toml.Unmarshal(decodedBytes, &initdata)
 		if err != nil {
 			return nil, fmt.Errorf("error unmarshalling initdata: %w", err)
 		}
 
-		cloudConfig.WriteFiles = append(cloudConfig.WriteFiles, cloudinit.WriteFile{
-			Path:    InitDataPath,
-			Content: initdataStr,
-		})
+		file, send, err := s.initdataWriteFile(initdataStr, &daemonConfig)
+		if err != nil {
+			return nil, err
+		}
+		if send {
+			logger.Printf("initdata of pod %s (%d bytes) is sent by cloud-api-adaptor instead of userdata", pod, len(initdataStr))
+			sentInitdata = initdataStr
+		}
+		cloudConfig.WriteFiles = append(cloudConfig.WriteFiles, *file)
+	}
+
+	measurement, err := initdata.NewMeasurement(decodedInitdata, daemonJSON)
+	if err != nil {
+		return nil, fmt.Errorf("measuring initdata: %w", err)
+	}
+	measurementJSON, err := json.MarshalIndent(measurement, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("generating measurement: %w", err)
+	}
+	measurementJSONPath := filepath.Join(podDir, "measurement.json")
+	if err := os.WriteFile(measurementJSONPath, measurementJSON, 0o666); err != nil {
+		return nil, fmt.Errorf("storing %s: %w", measurementJSONPath, err)
+	}
+	logger.Printf("expected PCR %d value of pod %s: %s", measurement.PCR, pod, measurement.PCRValue)
+
+	if extra, ok := nsAnnotations[util.CloudConfigAnnotation]; ok {
+		if err := mergeCloudConfig(cloudConfig, extra, userDataVars); err != nil {
+			return nil, fmt.Errorf("merging %s annotation of namespace %s: %w", util.CloudConfigAnnotation, namespace, err)
+		}
+	}
+	if extra, ok := podAnnotations[util.CloudConfigAnnotation]; ok {
+		if err := mergeCloudConfig(cloudConfig, extra, userDataVars); err != nil {
+			return nil, fmt.Errorf("merging %s annotation of pod %s: %w", util.CloudConfigAnnotation, pod, err)
+		}
+	}
+
+	userDataFiles, err := util.GetUserDataFilesFromAnnotation(podAnnotations)
+	if err != nil {
+		return nil, err
+	}
+	if len(userDataFiles) > 0 {
+		if s.ppService == nil {
+			return nil, fmt.Errorf("%s annotation requires access to the Kubernetes API", util.UserDataFilesAnnotation)
+		}
+		files, err := userDataWriteFiles(s.ppService, namespace, userDataFiles, s.serverConfig.SealUserData)
+		if err != nil {
+			return nil, err
+		}
+		cloudConfig.WriteFiles = append(cloudConfig.WriteFiles, files...)
+	}
+
+	if vmSpec.Tags, err = instanceTags(podAnnotations, nsAnnotations); err != nil {
+		return nil, err
+	}
+
+	sandboxProvider := s.provider
+	credentialsSecret := credentialsSecret(podAnnotations, nsAnnotations)
+	if credentialsSecret != "" {
+		if s.ppService == nil {
+			return nil, fmt.Errorf("%s annotation requires access to the Kubernetes API", util.CredentialsSecretAnnotation)
+		}
+		if sandboxProvider, err = s.credentials.get(s.ppService, s.serverConfig.CloudProvider, namespace, credentialsSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	var userDataKey []byte
+	if s.kbsClient != nil {
+		if userDataKey, err = s.sealCloudConfig(sid, cloudConfig); err != nil {
+			return nil, fmt.Errorf("sealing cloud config: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				s.deleteUserDataKey(sid)
+			}
+		}()
 	}
 
 	sandbox := &sandbox{
@@ -366,6 +669,14 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 		cloudConfig:   cloudConfig,
 		spec:          vmSpec,
 		sshClientInst: sshCi,
+		wgClientInst:  wgCi,
+		forwarderPort: forwarderPort,
+		measurement:   measurement,
+		initdata:      sentInitdata,
+		userDataKey:   userDataKey,
+
+		provider:          sandboxProvider,
+		credentialsSecret: credentialsSecret,
 	}
 
 	if err := s.addSandbox(sid, sandbox); err != nil {
@@ -385,31 +696,50 @@ func (s *cloudService) StartVM(ctx context.Context, req *pb.StartVMRequest) (res
 	}()
 
 	sid := sandboxID(req.Id)
+	started := time.Now()
 
 	sandbox, err := s.getSandbox(sid)
 	if err != nil {
 		return nil, fmt.Errorf("getting sandbox: %w", err)
 	}
 
-	instance, err := s.provider.CreateInstance(ctx, sandbox.podName, string(sid), sandbox.cloudConfig, sandbox.spec)
+	instance, err := sandbox.provider.CreateInstance(s.instanceNameContext(ctx, sandbox.podNamespace), sandbox.podName, string(sid), sandbox.cloudConfig, sandbox.spec)
 	if err != nil {
 		return nil, fmt.Errorf("creating an instance : %w", err)
 	}
 
 	if s.ppService != nil {
-		if err := s.ppService.OwnPeerPod(sandbox.podName, sandbox.podNamespace, instance.ID); err != nil {
+		if err := s.ppService.OwnPeerPod(sandbox.podName, sandbox.podNamespace, instance.ID, string(sid), sandbox.credentialsSecret); err != nil {
 			logger.Printf("failed to create PeerPod: %v", err)
 		}
 	}
 
+	var ips []string
+	for _, ip := range instance.IPs {
+		ips = append(ips, ip.String())
+	}
+	s.updatePeerPodStatus(sandbox, peerPodV1alpha1.PeerPodStatus{
+		Phase:        peerPodV1alpha1.PeerPodCreated,
+		InstanceName: instance.Name,
+		InstanceType: instance.InstanceType,
+		Zone:         instance.Zone,
+		IPs:          ips,
+	})
+
 	if err := s.setInstance(sid, instance.ID, instance.Name); err != nil {
 		return nil, fmt.Errorf("setting instance: %w", err)
 	}
 
 	logger.Printf("created an instance %s for sandbox %s", instance.Name, sid)
 
-	instanceIP := instance.IPs[0].String()
-	forwarderPort := s.serverConfig.ForwarderPort
+	// Dual-stack pod VMs are reached on any of their addresses
+	instanceIPs := proxy.SortAddrs(instance.IPs)
+	instanceIP := instanceIPs[0].String()
+	// process-user-data serves the boot status on the address of the pod VM if provisioning fails
+	fallbackStatusAddr := net.JoinHostPort(instanceIP, status.FallbackPort)
+	fallbackIPs := instanceIPs[1:]
+	forwarderPort := sandbox.forwarderPort
+	metricsPort := s.serverConfig.ForwarderMetricsPort
 
 	if s.sshClient != nil {
 		if err := sandbox.sshClientInst.Start(instance.IPs); err != nil {
@@ -418,24 +748,119 @@ func (s *cloudService) StartVM(ctx context.Context, req *pb.StartVMRequest) (res
 
 		// Set agentProxy
 		instanceIP = "127.0.0.1"
+		fallbackIPs = nil
 		forwarderPort = sandbox.sshClientInst.GetPort("KATAAGENT")
+		if metricsPort != "" {
+			metricsPort = sandbox.sshClientInst.GetPort("METRICS")
+		}
+	}
+
+	if sandbox.wgClientInst != nil {
+		if err := sandbox.wgClientInst.Start(instance.IPs); err != nil {
+			return nil, fmt.Errorf("failed WgClientInstance.Start: %w", err)
+		}
+
+		// Set agentProxy
+		instanceIP = sandbox.wgClientInst.GetPpAddr().String()
+		fallbackIPs = nil
+	}
+
+	// The boot phases of the pod VM are reported through the metrics endpoint until the agent proxy is ready
+	var watcher *bootWatcher
+	if metricsPort != "" {
+		// Without a secure comms tunnel, the metrics endpoint requires the client certificate of the agent proxy
+		var metricsTLS *tls.Config
+		if sandbox.sshClientInst == nil && sandbox.wgClientInst == nil {
+			var err error
+			if metricsTLS, err = sandbox.agentProxy.ClientTLSConfig(); err != nil {
+				return nil, fmt.Errorf("creating TLS config of metrics endpoint: %w", err)
+			}
+		}
+
+		s.mutex.Lock()
+		sandbox.metricsAddr = net.JoinHostPort(instanceIP, metricsPort)
+		sandbox.metricsTLS = metricsTLS
+		s.mutex.Unlock()
+
+		watcher = newBootWatcher(sandbox.metricsAddr, metricsTLS, fallbackStatusAddr, func(report status.Report) {
+			s.reportBootPhase(sandbox, started, report)
+		})
+		if s.serverConfig.RequireAttestation && sandbox.userDataKey != nil {
+			watcher.requireAttestationProof(sandbox.userDataKey, userDataKeyPath(sid))
+		}
+		watchCtx, stopWatcher := context.WithCancel(context.Background())
+		defer stopWatcher()
+		go watcher.run(watchCtx, bootStatusInterval)
+	}
+
+	// A failure to start the pod VM is explained by its boot phases
+	bootError := func(err error) error {
+		if watcher == nil {
+			return err
+		}
+		return fmt.Errorf("%w (%s)", err, watcher.describe())
+	}
+
+	// process-user-data waits for initdata that exceeds the inline limit of userdata on the forwarder port.
+	// Secure comms are not supported with it, so the pod VM is reached on its own addresses
+	if sandbox.initdata != "" {
+		var addrs []string
+		for _, ip := range instanceIPs {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), forwarderPort))
+		}
+		if err := sandbox.agentProxy.SendInitdata(ctx, addrs, []byte(sandbox.initdata)); err != nil {
+			return nil, bootError(err)
+		}
+	}
+
+	// With attestation gating, neither the traffic of the pod nor the agent proxy reach the pod VM until it is attested
+	if s.serverConfig.RequireAttestation {
+		if watcher == nil {
+			return nil, fmt.Errorf("attestation gating requires the metrics endpoint of pod VMs")
+		}
+		if err := s.gateAttestation(ctx, sandbox, watcher); err != nil {
+			return nil, bootError(err)
+		}
 	}
 
 	if err := s.workerNode.Setup(sandbox.netNSPath, instance.IPs, sandbox.podNetwork); err != nil {
 		return nil, fmt.Errorf("setting up pod network tunnel on netns %s: %w", sandbox.netNSPath, err)
 	}
 
+	if interval := s.serverConfig.TunnelCheckInterval; interval > 0 {
+		podNodeIPs := instance.IPs
+		stopMonitor := podnetwork.StartMonitor(interval,
+			func() error {
+				return s.workerNode.Check(sandbox.netNSPath, sandbox.podNetwork)
+			},
+			func() error {
+				return s.workerNode.Repair(sandbox.netNSPath, podNodeIPs, sandbox.podNetwork)
+			},
+			func(checkErr, repairErr error) {
+				s.recordTunnelRepair(sandbox, checkErr, repairErr)
+			},
+		)
+		s.mutex.Lock()
+		sandbox.stopMonitor = stopMonitor
+		s.mutex.Unlock()
+	}
+
 	serverURL := &url.URL{
 		Scheme: "http",
 		Host:   net.JoinHostPort(instanceIP, forwarderPort),
 		Path:   forwarder.AgentURLPath,
 	}
 
+	var fallbackAddrs []string
+	for _, ip := range fallbackIPs {
+		fallbackAddrs = append(fallbackAddrs, net.JoinHostPort(ip.String(), forwarderPort))
+	}
+
 	errCh := make(chan error)
 	go func() {
 		defer close(errCh)
 
-		if err := sandbox.agentProxy.Start(context.Background(), serverURL); err != nil {
+		if err := sandbox.agentProxy.Start(context.Background(), serverURL, fallbackAddrs); err != nil {
 			logger.Printf("error running agent proxy: %v", err)
 			errCh <- err
 		}
@@ -448,17 +873,35 @@ func (s *cloudService) StartVM(ctx context.Context, req *pb.StartVMRequest) (res
 		if err := sandbox.agentProxy.Shutdown(); err != nil {
 			logger.Printf("stopping agent proxy: %v", err)
 		}
-		return nil, ctx.Err()
+		return nil, bootError(ctx.Err())
 	case err := <-errCh:
-		return nil, err
+		return nil, bootError(err)
 	case <-sandbox.agentProxy.Ready():
 	}
 
 	logger.Print("agent proxy is ready")
+	s.updatePeerPodStatus(sandbox, peerPodV1alpha1.PeerPodStatus{Phase: peerPodV1alpha1.PeerPodRunning})
+
+	// Phases reported since the last poll, such as agent-ready, are not missed
+	if watcher != nil {
+		if err := watcher.poll(ctx); err != nil {
+			logger.Printf("failed to get boot status of pod VM: %v", err)
+		}
+	}
 
 	return &pb.StartVMResponse{}, nil
 }
 
+// updatePeerPodStatus updates the status of the PeerPod of a sandbox. Empty fields of status are left unchanged
+func (s *cloudService) updatePeerPodStatus(sandbox *sandbox, status peerPodV1alpha1.PeerPodStatus) {
+	if s.ppService == nil {
+		return
+	}
+	if err := s.ppService.UpdatePeerPodStatus(sandbox.podName, sandbox.podNamespace, status); err != nil {
+		logger.Printf("failed to update status of PeerPod of pod %s/%s: %v", sandbox.podNamespace, sandbox.podName, err)
+	}
+}
+
 func (s *cloudService) StopVM(ctx context.Context, req *pb.StopVMRequest) (*pb.StopVMResponse, error) {
 	sid := sandboxID(req.Id)
 
@@ -477,7 +920,13 @@ func (s *cloudService) StopVM(ctx context.Context, req *pb.StopVMRequest) (*pb.S
 		sandbox.sshClientInst.DisconnectPP(string(sid))
 	}
 
-	if err := s.provider.DeleteInstance(ctx, sandbox.instanceID); err != nil {
+	if sandbox.wgClientInst != nil {
+		sandbox.wgClientInst.DisconnectPP()
+	}
+
+	s.updatePeerPodStatus(sandbox, peerPodV1alpha1.PeerPodStatus{Phase: peerPodV1alpha1.PeerPodTerminating})
+
+	if err := sandbox.provider.DeleteInstance(ctx, sandbox.instanceID); err != nil {
 		logger.Printf("Error deleting an instance %s: %v", sandbox.instanceID, err)
 	} else if s.ppService != nil {
 		if err := s.ppService.ReleasePeerPod(sandbox.podName, sandbox.podNamespace, sandbox.instanceID); err != nil {
@@ -485,13 +934,41 @@ func (s *cloudService) StopVM(ctx context.Context, req *pb.StopVMRequest) (*pb.S
 		}
 	}
 
+	s.mutex.Lock()
+	stopMonitor := sandbox.stopMonitor
+	s.mutex.Unlock()
+	if stopMonitor != nil {
+		stopMonitor()
+	}
+
 	if err := s.workerNode.Teardown(sandbox.netNSPath, sandbox.podNetwork); err != nil {
 		logger.Printf("tearing down netns %s: %v", sandbox.netNSPath, err)
 	}
 
+	if s.kbsClient != nil {
+		s.deleteUserDataKey(sid)
+	}
+
 	if err = s.removeSandbox(sid); err != nil {
 		logger.Printf("removing sandbox %s: %v", sid, err)
 	}
 
 	return &pb.StopVMResponse{}, nil
 }
+
+// recordTunnelRepair reports a repair of the pod network tunnel of a sandbox as an event of the pod
+func (s *cloudService) recordTunnelRepair(sandbox *sandbox, checkErr, repairErr error) {
+
+	if s.ppService == nil {
+		return
+	}
+
+	eventType, reason, message := "Normal", "TunnelRepaired", fmt.Sprintf("Pod network tunnel was repaired: %v", checkErr)
+	if repairErr != nil {
+		eventType, reason, message = "Warning", "TunnelRepairFailed", fmt.Sprintf("Failed to repair pod network tunnel: %v", repairErr)
+	}
+
+	if err := s.ppService.RecordEvent(sandbox.podName, sandbox.podNamespace, eventType, reason, message); err != nil {
+		logger.Printf("failed to record an event of pod %s in namespace %s: %v", sandbox.podName, sandbox.podNamespace, err)
+	}
+}
@@ -5,20 +5,38 @@ package cloud
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	cri "github.com/containerd/containerd/pkg/cri/annotations"
 	pb "github.com/kata-containers/kata-containers/src/runtime/protocols/hypervisor"
+	toml "github.com/pelletier/go-toml/v2"
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/k8sops"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/proxy"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
+	. "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/kubemgr"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/ppssh"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/test/securecomms/test"
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
@@ -59,7 +77,7 @@ type mockProxy struct {
 	socketPath string
 }
 
-func (p *mockProxy) Start(ctx context.Context, serverURL *url.URL) error {
+func (p *mockProxy) Start(ctx context.Context, serverURL *url.URL, fallbackAddrs []string) error {
 	close(p.readyCh)
 	<-p.stopCh
 	return nil
@@ -78,10 +96,18 @@ func (p *mockProxy) ClientCA() (certPEM []byte) {
 	return nil
 }
 
+func (p *mockProxy) ClientTLSConfig() (*tls.Config, error) {
+	return nil, nil
+}
+
 func (p *mockProxy) CAService() tlsutil.CAService {
 	return nil
 }
 
+func (p *mockProxy) SendInitdata(ctx context.Context, addresses []string, data []byte) error {
+	return nil
+}
+
 type mockProxyFactory struct {
 	podsDir string
 }
@@ -97,7 +123,7 @@ func (f *mockProxyFactory) New(serverName, socketPath string) proxy.AgentProxy {
 type mockWorkerNode struct{}
 
 func (n mockWorkerNode) Inspect(nsPath string) (*tunneler.Config, error) {
-	return nil, nil
+	return &tunneler.Config{}, nil
 }
 
 func (n *mockWorkerNode) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
@@ -108,6 +134,17 @@ func (n *mockWorkerNode) Teardown(nsPath string, config *tunneler.Config) error
 	return nil
 }
 
+func (n *mockWorkerNode) Check(nsPath string, config *tunneler.Config) error {
+	return nil
+}
+
+func (n *mockWorkerNode) Repair(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+	return nil
+}
+
+func (n *mockWorkerNode) Release(config *tunneler.Config) {
+}
+
 func TestCloudService(t *testing.T) {
 
 	ctx := context.Background()
@@ -124,8 +161,8 @@ func TestCloudService(t *testing.T) {
 	}
 
 	// false, "", "", "", "", "", dir, forwarder.DefaultListenPort, ""
-	s := NewService(&mockProvider{}, proxyFactory, &mockWorkerNode{}, cfg, "")
-
+	s, err := NewService(&mockProvider{}, proxyFactory, &mockWorkerNode{}, cfg, "")
+	assert.NoError(t, err)
 	assert.NotNil(t, s)
 
 	sandboxID := "123"
@@ -157,6 +194,83 @@ func TestCloudService(t *testing.T) {
 	assert.NotNil(t, res3)
 }
 
+func TestCloudServicePodAnnotations(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cfg := &ServerConfig{
+		PodsDir:       dir,
+		ForwarderPort: forwarder.DefaultListenPort,
+	}
+
+	s, err := NewService(&mockProvider{}, &mockProxyFactory{podsDir: dir}, &mockWorkerNode{}, cfg, "")
+	assert.NoError(t, err)
+
+	// The runtime does not pass the annotations of peer pods, so they are only set on the pod
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "default",
+			Annotations: map[string]string{util.TagsAnnotation: "team=a,env=dev"},
+		}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "mypod",
+				Namespace: "default",
+				Annotations: map[string]string{
+					util.TagsAnnotation:          "env=prod",
+					util.ForwarderPortAnnotation: "15160",
+					util.TunnelMTUAnnotation:     "1400",
+					util.CloudConfigAnnotation:   "write_files:\n- path: /opt/peerpod-name\n  content: '{{.PodName}}'\n",
+				},
+			},
+		},
+	)
+	s.(*cloudService).ppService = k8sops.NewPeerPodServiceForClient(clientset, "mock")
+
+	req := &pb.CreateVMRequest{
+		Id: "123",
+		Annotations: map[string]string{
+			cri.SandboxNamespace: "default",
+			cri.SandboxName:      "mypod",
+		},
+	}
+
+	_, err = s.CreateVM(ctx, req)
+	assert.NoError(t, err)
+
+	sandbox, err := s.(*cloudService).getSandbox("123")
+	assert.NoError(t, err)
+	assert.Equal(t, "15160", sandbox.forwarderPort)
+	assert.Equal(t, 1400, sandbox.podNetwork.MTU)
+	assert.Equal(t, map[string]string{"team": "a", "env": "prod"}, sandbox.spec.Tags)
+
+	var found bool
+	for _, file := range sandbox.cloudConfig.WriteFiles {
+		if file.Path == "/opt/peerpod-name" {
+			found = true
+		}
+	}
+	assert.True(t, found, "cloud-config annotation of the pod is not merged")
+}
+
+func TestInstanceTags(t *testing.T) {
+	tags, err := instanceTags(nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+
+	tags, err = instanceTags(map[string]string{util.TagsAnnotation: "env=prod, owner=alice"}, map[string]string{util.TagsAnnotation: "env=dev,team=a"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "owner": "alice", "team": "a"}, tags)
+
+	_, err = instanceTags(map[string]string{util.TagsAnnotation: "env"}, nil)
+	assert.Error(t, err)
+
+	// The tags that cloud-api-adaptor sets can't be overridden
+	_, err = instanceTags(nil, map[string]string{util.TagsAnnotation: "peerpod-cluster-id=other"})
+	assert.Error(t, err)
+}
+
 func TestCloudServiceWithSecureComms(t *testing.T) {
 	sshport := "6001"
 	kubemgr.InitKubeMgrMock()
@@ -195,8 +309,8 @@ func TestCloudServiceWithSecureComms(t *testing.T) {
 		SecureCommsKbsAddress: "127.0.0.1:9009",
 	}
 
-	s := NewService(&mockProvider{}, proxyFactory, &mockWorkerNode{}, cfg, sshport)
-
+	s, err := NewService(&mockProvider{}, proxyFactory, &mockWorkerNode{}, cfg, sshport)
+	assert.NoError(t, err)
 	assert.NotNil(t, s)
 
 	sandboxID := "123"
@@ -227,3 +341,471 @@ func TestCloudServiceWithSecureComms(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, res3)
 }
+
+type mockKbsClient struct {
+	resources map[string][]byte
+}
+
+func (c *mockKbsClient) PostResource(path string, data []byte) error {
+	c.resources[path] = data
+	return nil
+}
+
+func (c *mockKbsClient) DeleteResource(path string) error {
+	delete(c.resources, path)
+	return nil
+}
+
+func TestSealCloudConfig(t *testing.T) {
+	kbsClient := &mockKbsClient{resources: map[string][]byte{}}
+	s := &cloudService{kbsClient: kbsClient}
+
+	cloudConfig := &cloudinit.CloudConfig{
+		WriteFiles: []cloudinit.WriteFile{
+			{Path: forwarder.DefaultConfigPath, Content: "{}"},
+			{Path: InitDataPath, Content: "initdata"},
+		},
+	}
+
+	key, err := s.sealCloudConfig("123", cloudConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, key, kbsClient.resources["default/pp-123/userdata-key"])
+
+	assert.Len(t, cloudConfig.WriteFiles, 2)
+	assert.Equal(t, InitDataPath, cloudConfig.WriteFiles[0].Path)
+	assert.Equal(t, cloudinit.SealedFilesPath, cloudConfig.WriteFiles[1].Path)
+	assert.NotContains(t, cloudConfig.WriteFiles[1].Content, "{}")
+	assert.Len(t, kbsClient.resources["default/pp-123/userdata-key"], cloudinit.SealKeySize)
+
+	s.deleteUserDataKey("123")
+	assert.NotContains(t, kbsClient.resources, "default/pp-123/userdata-key")
+}
+
+type mockObjectDataGetter struct{}
+
+func (g *mockObjectDataGetter) GetConfigMapData(name string, namespace string) (map[string][]byte, error) {
+	if name != "ca-bundle" || namespace != "default" {
+		return nil, fmt.Errorf("configmap %s/%s not found", namespace, name)
+	}
+	return map[string][]byte{"ca.crt": []byte("certificate"), "extra.crt": []byte("extra")}, nil
+}
+
+func (g *mockObjectDataGetter) GetSecretData(name string, namespace string) (map[string][]byte, error) {
+	if name != "tls" || namespace != "default" {
+		return nil, fmt.Errorf("secret %s/%s not found", namespace, name)
+	}
+	return map[string][]byte{"tls.key": []byte("key"), "tls.crt": []byte("cert")}, nil
+}
+
+func TestUserDataWriteFiles(t *testing.T) {
+	files, err := userDataWriteFiles(&mockObjectDataGetter{}, "default", []util.UserDataFile{
+		{ConfigMap: "ca-bundle", Key: "ca.crt", Path: "certs/ca.crt"},
+		{Secret: "tls", Path: "tls", Mode: "0600", Owner: "root"},
+	}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []cloudinit.WriteFile{
+		{Path: UserDataFilesDir + "/certs/ca.crt", Encoding: "b64", Content: "Y2VydGlmaWNhdGU="},
+		{Path: UserDataFilesDir + "/tls/tls.crt", Owner: "root", Permissions: "0600", Encoding: "b64", Content: "Y2VydA=="},
+		{Path: UserDataFilesDir + "/tls/tls.key", Owner: "root", Permissions: "0600", Encoding: "b64", Content: "a2V5"},
+	}, files)
+
+	_, err = userDataWriteFiles(&mockObjectDataGetter{}, "default", []util.UserDataFile{
+		{ConfigMap: "ca-bundle", Key: "missing.crt", Path: "certs/ca.crt"},
+	}, true)
+	assert.Error(t, err)
+
+	_, err = userDataWriteFiles(&mockObjectDataGetter{}, "other", []util.UserDataFile{
+		{Secret: "tls", Path: "tls"},
+	}, true)
+	assert.Error(t, err)
+
+	// Secrets would be readable from the instance metadata without sealed userdata
+	_, err = userDataWriteFiles(&mockObjectDataGetter{}, "default", []util.UserDataFile{
+		{Secret: "tls", Path: "tls"},
+	}, false)
+	assert.Error(t, err)
+
+	files, err = userDataWriteFiles(&mockObjectDataGetter{}, "default", []util.UserDataFile{
+		{ConfigMap: "ca-bundle", Key: "ca.crt", Path: "certs/ca.crt"},
+	}, false)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestMergeCloudConfig(t *testing.T) {
+	vars := &cloudinit.UserDataVars{PodName: "nginx", Namespace: "default", ClusterID: "prod"}
+
+	cloudConfig := &cloudinit.CloudConfig{}
+	err := mergeCloudConfig(cloudConfig, "write_files:\n- path: /opt/peerpod-id\n  content: '{{.ClusterID}}/{{.Namespace}}/{{.PodName}}'\n", vars)
+	assert.NoError(t, err)
+	assert.Len(t, cloudConfig.WriteFiles, 1)
+	assert.Equal(t, "cHJvZC9kZWZhdWx0L25naW54", cloudConfig.WriteFiles[0].Content)
+
+	err = mergeCloudConfig(cloudConfig, "runcmd:\n- echo {{.Unknown}}\n", vars)
+	assert.Error(t, err)
+}
+
+type mockSecretGetter struct {
+	mockObjectDataGetter
+	data map[string][]byte
+}
+
+func (g *mockSecretGetter) GetSecretData(name string, namespace string) (map[string][]byte, error) {
+	if name != "credentials" || namespace != "tenant" {
+		return nil, fmt.Errorf("secret %s/%s not found", namespace, name)
+	}
+	return g.data, nil
+}
+
+func TestCredentialsProviders(t *testing.T) {
+	assert.Equal(t, "pod", credentialsSecret(map[string]string{util.CredentialsSecretAnnotation: "pod"}, map[string]string{util.CredentialsSecretAnnotation: "namespace"}))
+	assert.Equal(t, "namespace", credentialsSecret(nil, map[string]string{util.CredentialsSecretAnnotation: "namespace"}))
+	assert.Empty(t, credentialsSecret(nil, nil))
+
+	var created []map[string]string
+	c := newCredentialsProviders()
+	c.newProvider = func(cloudName string, credentials map[string]string) (provider.Provider, error) {
+		assert.Equal(t, "test", cloudName)
+		created = append(created, credentials)
+		return &mockProvider{}, nil
+	}
+	getter := &mockSecretGetter{data: map[string][]byte{"KEY": []byte("one")}}
+
+	for i := 0; i < 2; i++ {
+		_, err := c.get(getter, "test", "tenant", "credentials")
+		assert.NoError(t, err)
+	}
+	assert.Len(t, created, 1)
+
+	// A changed Secret creates a provider again
+	getter.data = map[string][]byte{"KEY": []byte("two")}
+	_, err := c.get(getter, "test", "tenant", "credentials")
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"KEY": "one"}, {"KEY": "two"}}, created)
+
+	_, err = c.get(getter, "test", "other", "credentials")
+	assert.Error(t, err)
+}
+
+func TestBootWatcher(t *testing.T) {
+	st := status.Status{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, status.StatusURLPath, r.URL.Path)
+		assert.NoError(t, json.NewEncoder(w).Encode(&st))
+	}))
+	defer srv.Close()
+
+	var reported []status.Phase
+	watcher := newBootWatcher(strings.TrimPrefix(srv.URL, "http://"), nil, "", func(report status.Report) {
+		reported = append(reported, report.Phase)
+	})
+
+	assert.NoError(t, watcher.poll(context.Background()))
+	assert.Equal(t, "pod VM did not report any boot phase", watcher.describe())
+
+	st.Reports = append(st.Reports, status.Report{Phase: status.PhaseUserDataApplied})
+	assert.NoError(t, watcher.poll(context.Background()))
+	assert.Equal(t, "last boot phase reported by pod VM: userdata-applied", watcher.describe())
+
+	// Only new reports are passed to the callback
+	st.Reports = append(st.Reports, status.Report{Phase: status.PhaseAttested, Error: "attestation failed"})
+	assert.NoError(t, watcher.poll(context.Background()))
+	assert.Equal(t, []status.Phase{status.PhaseUserDataApplied, status.PhaseAttested}, reported)
+	assert.Equal(t, "pod VM failed at boot phase attestation-succeeded: attestation failed", watcher.describe())
+}
+
+func TestBootWatcherFallback(t *testing.T) {
+	now := time.Now().UTC()
+	fallbackStatus := status.Status{Reports: []status.Report{
+		{Phase: status.PhaseAttested, Time: now},
+		{Phase: status.PhaseUserDataApplied, Time: now, Error: "no userdata source is available"},
+	}}
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewEncoder(w).Encode(&fallbackStatus))
+	}))
+	defer fallback.Close()
+
+	forwarderUp := false
+	forwarder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !forwarderUp {
+			http.Error(w, "not started", http.StatusServiceUnavailable)
+			return
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(&fallbackStatus))
+	}))
+	defer forwarder.Close()
+
+	var reported []status.Report
+	watcher := newBootWatcher(strings.TrimPrefix(forwarder.URL, "http://"), nil, strings.TrimPrefix(fallback.URL, "http://"), func(report status.Report) {
+		reported = append(reported, report)
+	})
+
+	// Only failures are taken from the fallback endpoint, which is not authenticated
+	assert.NoError(t, watcher.poll(context.Background()))
+	assert.Len(t, reported, 1)
+	assert.Equal(t, "pod VM failed at boot phase userdata-applied: no userdata source is available", watcher.describe())
+	assert.Nil(t, watcher.attestation)
+
+	// Reports of the fallback endpoint are not reported again by the forwarder
+	forwarderUp = true
+	assert.NoError(t, watcher.poll(context.Background()))
+	assert.Len(t, reported, 2)
+	assert.Equal(t, status.PhaseAttested, reported[1].Phase)
+}
+
+func TestGateAttestation(t *testing.T) {
+	st := status.Status{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewEncoder(w).Encode(&st))
+	}))
+	defer srv.Close()
+
+	s := &cloudService{serverConfig: &ServerConfig{AttestationTimeout: 100 * time.Millisecond}}
+	sb := &sandbox{podName: "nginx", podNamespace: "default"}
+	key := []byte("0123456789abcdef0123456789abcdef")
+	resource := userDataKeyPath("123")
+	newWatcher := func() *bootWatcher {
+		watcher := newBootWatcher(strings.TrimPrefix(srv.URL, "http://"), nil, "", func(status.Report) {})
+		watcher.requireAttestationProof(key, resource)
+		assert.NoError(t, watcher.poll(context.Background()))
+		return watcher
+	}
+
+	// Gating requires the key of sealed userdata
+	unsealed := newBootWatcher(strings.TrimPrefix(srv.URL, "http://"), nil, "", func(status.Report) {})
+	assert.ErrorContains(t, s.gateAttestation(context.Background(), sb, unsealed), "sealed userdata")
+
+	// A pod VM that does not report attestation times out
+	st.Reports = []status.Report{{Phase: status.PhaseUserDataApplied}}
+	assert.ErrorIs(t, s.gateAttestation(context.Background(), sb, newWatcher()), context.DeadlineExceeded)
+
+	st.Reports = append(st.Reports, status.Report{Phase: status.PhaseAttested, Error: "key was not released"})
+	assert.ErrorContains(t, s.gateAttestation(context.Background(), sb, newWatcher()), "key was not released")
+
+	// Successful attestation without a proof made with the key released by Trustee is ignored
+	st.Reports = []status.Report{{Phase: status.PhaseUserDataApplied}, {Phase: status.PhaseAttested}, {Phase: status.PhaseAgentReady}}
+	assert.ErrorIs(t, s.gateAttestation(context.Background(), sb, newWatcher()), context.DeadlineExceeded)
+
+	st.Reports[1].Proof = status.AttestationProof([]byte("forged key"), resource)
+	assert.ErrorIs(t, s.gateAttestation(context.Background(), sb, newWatcher()), context.DeadlineExceeded)
+
+	st.Reports[1].Proof = status.AttestationProof(key, resource)
+	assert.NoError(t, s.gateAttestation(context.Background(), sb, newWatcher()))
+}
+
+func TestGetKBSOverride(t *testing.T) {
+	allowed := []string{"https://kbs.tenant-a:8080", "https://kbs.tenant-b:8080"}
+
+	kbs, err := getKBSOverride(nil, nil, allowed)
+	assert.NoError(t, err)
+	assert.Nil(t, kbs)
+
+	kbs, err = getKBSOverride(
+		map[string]string{util.KBSURLAnnotation: "https://kbs.tenant-a:8080", util.KBSCertAnnotation: "cert-a"},
+		map[string]string{util.KBSURLAnnotation: "https://kbs.tenant-b:8080"},
+		allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, &kbsOverride{URL: "https://kbs.tenant-a:8080", Cert: "cert-a"}, kbs)
+
+	kbs, err = getKBSOverride(nil, map[string]string{util.KBSURLAnnotation: "https://kbs.tenant-b:8080"}, allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, &kbsOverride{URL: "https://kbs.tenant-b:8080"}, kbs)
+
+	_, err = getKBSOverride(map[string]string{util.KBSURLAnnotation: "https://kbs.evil:8080"}, nil, allowed)
+	assert.Error(t, err)
+
+	_, err = getKBSOverride(map[string]string{util.KBSURLAnnotation: "https://kbs.tenant-a:8080"}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestOverrideInitdataKBS(t *testing.T) {
+	initdata := `algorithm = "sha256"
+version = "0.1.0"
+
+[data]
+"aa.toml" = '''
+[token_configs.kbs]
+url = "http://kbs.default:8080"
+cert = "default-cert"
+'''
+"policy.rego" = "package agent_policy"
+`
+	encoded, err := overrideInitdataKBS(base64.StdEncoding.EncodeToString([]byte(initdata)), &kbsOverride{URL: "https://kbs.tenant:8080"})
+	assert.NoError(t, err)
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	assert.NoError(t, err)
+	data := InitData{}
+	assert.NoError(t, toml.Unmarshal(decoded, &data))
+	assert.Equal(t, "sha256", data.Algorithm)
+	assert.Equal(t, "package agent_policy", data.Data["policy.rego"])
+
+	aaConfig := map[string]map[string]map[string]string{}
+	assert.NoError(t, toml.Unmarshal([]byte(data.Data[aaConfigName]), &aaConfig))
+	assert.Equal(t, map[string]string{"url": "https://kbs.tenant:8080"}, aaConfig["token_configs"]["kbs"])
+
+	cdhConfig := map[string]map[string]string{}
+	assert.NoError(t, toml.Unmarshal([]byte(data.Data[cdhConfigName]), &cdhConfig))
+	assert.Equal(t, map[string]string{"name": "cc_kbc", "url": "https://kbs.tenant:8080"}, cdhConfig["kbc"])
+
+	// Initdata is created when a pod has none
+	encoded, err = overrideInitdataKBS("", &kbsOverride{URL: "https://kbs.tenant:8080", Cert: "tenant-cert"})
+	assert.NoError(t, err)
+	decoded, err = base64.StdEncoding.DecodeString(encoded)
+	assert.NoError(t, err)
+	data = InitData{}
+	assert.NoError(t, toml.Unmarshal(decoded, &data))
+	assert.Equal(t, defaultInitdataAlgorithm, data.Algorithm)
+	assert.Contains(t, data.Data[cdhConfigName], "tenant-cert")
+
+	_, err = overrideInitdataKBS("not base64!", &kbsOverride{URL: "https://kbs.tenant:8080"})
+	assert.Error(t, err)
+}
+
+func TestCheckInitdataKBS(t *testing.T) {
+	encode := func(aaConfig, cdhConfig string) string {
+		initdata := fmt.Sprintf("algorithm = \"sha256\"\nversion = \"0.1.0\"\n\n[data]\n\"aa.toml\" = '''\n%s'''\n\"cdh.toml\" = '''\n%s'''\n", aaConfig, cdhConfig)
+		return base64.StdEncoding.EncodeToString([]byte(initdata))
+	}
+	allowed := []string{"https://kbs.tenant-a:8080"}
+	global := encode("[token_configs.kbs]\nurl = \"http://kbs.default:8080\"\n", "")
+
+	assert.NoError(t, checkInitdataKBS("", global, allowed))
+	assert.NoError(t, checkInitdataKBS(encode("", ""), global, allowed))
+	assert.NoError(t, checkInitdataKBS(encode("[token_configs.kbs]\nurl = \"https://kbs.tenant-a:8080\"\n", "[kbc]\nname = \"cc_kbc\"\nurl = \"https://kbs.tenant-a:8080\"\n"), global, allowed))
+	assert.NoError(t, checkInitdataKBS(encode("[token_configs.kbs]\nurl = \"http://kbs.default:8080\"\n", ""), global, allowed))
+
+	// A pod can't select a KBS with its own initdata
+	assert.Error(t, checkInitdataKBS(encode("[token_configs.kbs]\nurl = \"https://kbs.evil:8080\"\n", ""), global, allowed))
+	assert.Error(t, checkInitdataKBS(encode("", "[kbc]\nname = \"cc_kbc\"\nurl = \"https://kbs.evil:8080\"\n"), global, allowed))
+	assert.Error(t, checkInitdataKBS(encode("token_configs = 1\n", ""), global, allowed))
+	assert.Error(t, checkInitdataKBS("not base64!", global, allowed))
+
+	// The check is disabled when no KBS URL is allowed
+	assert.NoError(t, checkInitdataKBS(encode("[token_configs.kbs]\nurl = \"https://kbs.evil:8080\"\n", ""), global, nil))
+}
+
+func TestInitdataWriteFile(t *testing.T) {
+	s := &cloudService{
+		serverConfig: &ServerConfig{InitdataInlineLimit: 8},
+	}
+	daemonConfig := &forwarder.Config{ForwarderPort: "15150", TLSServerCert: "cert", TLSClientCA: "ca"}
+
+	file, send, err := s.initdataWriteFile("c21hbGw=", daemonConfig)
+	assert.NoError(t, err)
+	assert.False(t, send)
+	assert.Equal(t, &cloudinit.WriteFile{Path: InitDataPath, Content: "c21hbGw="}, file)
+
+	large := base64.StdEncoding.EncodeToString([]byte("large initdata"))
+	file, send, err = s.initdataWriteFile(large, daemonConfig)
+	assert.NoError(t, err)
+	assert.True(t, send)
+	assert.Equal(t, InitDataSourcePath, file.Path)
+
+	var source initdata.Source
+	assert.NoError(t, json.Unmarshal([]byte(file.Content), &source))
+	assert.Equal(t, "15150", source.Port)
+	sum := sha256.Sum256([]byte(large))
+	assert.Equal(t, hex.EncodeToString(sum[:]), source.SHA256)
+
+	// Initdata is sent over TLS with the certificates that cloud-api-adaptor issues
+	_, _, err = s.initdataWriteFile(large, &forwarder.Config{ForwarderPort: "15150"})
+	assert.Error(t, err)
+
+	// The daemon config is not available to process-user-data before sealed userdata is unsealed
+	s.serverConfig.SealUserData = true
+	_, _, err = s.initdataWriteFile(large, daemonConfig)
+	assert.Error(t, err)
+
+	// Initdata is always included in userdata without a limit
+	s.serverConfig.InitdataInlineLimit = 0
+	file, send, err = s.initdataWriteFile(large, daemonConfig)
+	assert.NoError(t, err)
+	assert.False(t, send)
+	assert.Equal(t, InitDataPath, file.Path)
+}
+
+type mockAttestationConfigGetter struct {
+	mockObjectDataGetter
+}
+
+func (g *mockAttestationConfigGetter) GetConfigMapData(name string, namespace string) (map[string][]byte, error) {
+	if name != "attestation" || namespace != "tenant" {
+		return nil, fmt.Errorf("configmap %s/%s not found", namespace, name)
+	}
+	return map[string][]byte{
+		"cdh.toml": []byte("[image]\nimage_security_policy_uri = 'kbs:///{{.Namespace}}/security-policy/test'\n"),
+	}, nil
+}
+
+func TestMergeInitdataConfig(t *testing.T) {
+	nsAnnotations := map[string]string{
+		util.AttestationConfigMapAnnotation: "attestation",
+		util.CDHConfigAnnotation:            "[kbc]\nname = 'cc_kbc'\n",
+	}
+	podAnnotations := map[string]string{
+		util.CDHConfigAnnotation: "[image]\nauthenticated_registry_credentials_uri = 'kbs:///{{.Namespace}}/credentials/{{.PodName}}'\n",
+		util.AAConfigAnnotation:  "[eventlog_config]\neventlog_algorithm = 'sha384'\n",
+	}
+
+	fragments, err := attestationConfigFragments(&mockAttestationConfigGetter{}, "tenant", podAnnotations, nsAnnotations)
+	assert.NoError(t, err)
+	assert.Len(t, fragments, 4)
+
+	initdata := base64.StdEncoding.EncodeToString([]byte(`algorithm = "sha256"
+version = "0.1.0"
+
+[data]
+"cdh.toml" = '''
+socket = 'unix:///run/confidential-containers/cdh.sock'
+
+[kbc]
+name = 'offline_fs_kbc'
+'''
+`))
+	vars := &cloudinit.UserDataVars{PodName: "nginx", Namespace: "tenant"}
+	encoded, err := mergeInitdataConfig(initdata, fragments, vars)
+	assert.NoError(t, err)
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	assert.NoError(t, err)
+	data := InitData{}
+	assert.NoError(t, toml.Unmarshal(decoded, &data))
+	assert.Equal(t, "sha256", data.Algorithm)
+
+	cdhConfig := map[string]interface{}{}
+	assert.NoError(t, toml.Unmarshal([]byte(data.Data[cdhConfigName]), &cdhConfig))
+	assert.Equal(t, "unix:///run/confidential-containers/cdh.sock", cdhConfig["socket"])
+	assert.Equal(t, map[string]interface{}{"name": "cc_kbc"}, cdhConfig["kbc"])
+	assert.Equal(t, map[string]interface{}{
+		"image_security_policy_uri":              "kbs:///tenant/security-policy/test",
+		"authenticated_registry_credentials_uri": "kbs:///tenant/credentials/nginx",
+	}, cdhConfig["image"])
+
+	aaConfig := map[string]map[string]string{}
+	assert.NoError(t, toml.Unmarshal([]byte(data.Data[aaConfigName]), &aaConfig))
+	assert.Equal(t, "sha384", aaConfig["eventlog_config"]["eventlog_algorithm"])
+
+	// Fragments can't select a KBS, which bypasses the allowed KBS URLs
+	for _, fragment := range []configFragment{
+		{file: cdhConfigName, source: "test", toml: "[kbc]\nurl = 'http://kbs.attacker:8080'\n"},
+		{file: cdhConfigName, source: "test", toml: "kbc = 'http://kbs.attacker:8080'\n"},
+		{file: aaConfigName, source: "test", toml: "[token_configs.kbs]\nurl = 'http://kbs.attacker:8080'\n"},
+		{file: aaConfigName, source: "test", toml: "[token_configs]\nkbs = { url = 'http://kbs.attacker:8080' }\n"},
+		{file: aaConfigName, source: "test", toml: "[token_configs.kbs]\ncert = 'attacker-cert'\n"},
+	} {
+		_, err = mergeInitdataConfig(initdata, []configFragment{fragment}, vars)
+		assert.ErrorContains(t, err, "selects a KBS", fragment.toml)
+	}
+
+	_, err = mergeInitdataConfig("", []configFragment{{file: aaConfigName, source: "test", toml: "[invalid"}}, vars)
+	assert.Error(t, err)
+
+	_, err = attestationConfigFragments(nil, "tenant", map[string]string{util.AttestationConfigMapAnnotation: "attestation"}, nil)
+	assert.Error(t, err)
+	_, err = attestationConfigFragments(&mockAttestationConfigGetter{}, "other", map[string]string{util.AttestationConfigMapAnnotation: "attestation"}, nil)
+	assert.Error(t, err)
+}
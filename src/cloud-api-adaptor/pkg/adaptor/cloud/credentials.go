@@ -0,0 +1,82 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util"
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+)
+
+// credentialsSecret returns the name of the Secret with the cloud credentials of a pod.
+// The annotation of a pod takes precedence over the one of its namespace
+func credentialsSecret(podAnnotations, nsAnnotations map[string]string) string {
+	if secret := podAnnotations[util.CredentialsSecretAnnotation]; secret != "" {
+		return secret
+	}
+	return nsAnnotations[util.CredentialsSecretAnnotation]
+}
+
+// credentialsProviders caches the providers created with the credentials of Secrets, since creating
+// a provider may call the APIs of the cloud. A provider is created again when its Secret changes
+type credentialsProviders struct {
+	mutex       sync.Mutex
+	providers   map[string]*credentialsProvider // by namespace/name of Secret
+	newProvider func(cloudName string, credentials map[string]string) (provider.Provider, error)
+}
+
+type credentialsProvider struct {
+	digest   [sha256.Size]byte
+	provider provider.Provider
+}
+
+func newCredentialsProviders() *credentialsProviders {
+	return &credentialsProviders{
+		providers:   map[string]*credentialsProvider{},
+		newProvider: provider.NewProviderWithCredentials,
+	}
+}
+
+// get returns a provider of a cloud provider with the credentials of a Secret in namespace
+func (c *credentialsProviders) get(getter objectDataGetter, cloudName, namespace, secret string) (provider.Provider, error) {
+	data, err := getter.GetSecretData(secret, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials Secret %s in namespace %s: %w", secret, namespace, err)
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	credentials := make(map[string]string, len(data))
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\x00%s\x00", key, data[key])
+		credentials[key] = string(data[key])
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	name := namespace + "/" + secret
+	if cached, ok := c.providers[name]; ok && cached.digest == digest {
+		return cached.provider, nil
+	}
+
+	p, err := c.newProvider(cloudName, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider with credentials Secret %s in namespace %s: %w", secret, namespace, err)
+	}
+	c.providers[name] = &credentialsProvider{digest: digest, provider: p}
+	logger.Printf("created provider with credentials Secret %s in namespace %s", secret, namespace)
+
+	return p, nil
+}
@@ -0,0 +1,47 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
+	. "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+// initdataWriteFile returns the file of a cloud config that provides initdata to a pod VM. Initdata that exceeds
+// the inline limit is replaced with a source, and true is returned if cloud-api-adaptor has to send it to the pod VM.
+//
+// Initdata is sent over TLS with the certificates of the agent protocol before agent-protocol-forwarder starts, since
+// secure comms tunnels are only established by agent-protocol-forwarder, which runs after initdata is provisioned
+func (s *cloudService) initdataWriteFile(initdataStr string, daemonConfig *forwarder.Config) (*cloudinit.WriteFile, bool, error) {
+	limit := s.serverConfig.InitdataInlineLimit
+	if limit <= 0 || len(initdataStr) <= limit {
+		return &cloudinit.WriteFile{Path: InitDataPath, Content: initdataStr}, false, nil
+	}
+
+	switch {
+	case s.sshClient != nil || s.wgClient != nil:
+		return nil, false, fmt.Errorf("initdata of %d bytes exceeds the inline limit of %d bytes, which is not supported with secure comms", len(initdataStr), limit)
+	case s.serverConfig.SealUserData:
+		return nil, false, fmt.Errorf("initdata of %d bytes exceeds the inline limit of %d bytes, which is not supported with sealed userdata", len(initdataStr), limit)
+	case daemonConfig.TLSServerCert == "" || daemonConfig.TLSClientCA == "":
+		return nil, false, fmt.Errorf("initdata of %d bytes exceeds the inline limit of %d bytes, which requires TLS certificates issued by cloud-api-adaptor", len(initdataStr), limit)
+	}
+
+	sum := sha256.Sum256([]byte(initdataStr))
+	source, err := json.Marshal(&initdata.Source{
+		Port:   daemonConfig.ForwarderPort,
+		SHA256: hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("generating initdata source: %w", err)
+	}
+	return &cloudinit.WriteFile{Path: InitDataSourcePath, Content: string(source)}, true, nil
+}
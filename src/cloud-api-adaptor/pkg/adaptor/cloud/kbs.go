@@ -0,0 +1,185 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"fmt"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util"
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+const (
+	aaConfigName  = "aa.toml"
+	cdhConfigName = "cdh.toml"
+
+	defaultInitdataAlgorithm = "sha384"
+	defaultInitdataVersion   = "0.1.0"
+)
+
+// kbsOverride is the KBS that a pod VM attests against instead of the one in the initdata of its pod
+type kbsOverride struct {
+	URL  string
+	Cert string
+}
+
+// getKBSOverride returns the KBS selected by the annotations of a pod or its namespace, if any.
+// The annotations of a pod take precedence over the ones of its namespace, and the URL must be allowed
+func getKBSOverride(podAnnotations, nsAnnotations map[string]string, allowedURLs []string) (*kbsOverride, error) {
+	annotations := podAnnotations
+	if annotations[util.KBSURLAnnotation] == "" {
+		annotations = nsAnnotations
+	}
+	url := annotations[util.KBSURLAnnotation]
+	if url == "" {
+		return nil, nil
+	}
+	if !util.Contains(allowedURLs, url) {
+		return nil, fmt.Errorf("KBS URL %q of %s annotation is not allowed", url, util.KBSURLAnnotation)
+	}
+	return &kbsOverride{
+		URL:  url,
+		Cert: annotations[util.KBSCertAnnotation],
+	}, nil
+}
+
+// checkInitdataKBS returns an error if base64 encoded initdata, e.g. the one in the annotation of a pod, sets a KBS
+// whose URL is neither allowed nor set in the global initdata. It does nothing if no KBS URL is allowed
+func checkInitdataKBS(initdataStr, globalInitdataStr string, allowedURLs []string) error {
+	if len(allowedURLs) == 0 || initdataStr == "" {
+		return nil
+	}
+
+	urls, err := initdataKBSURLs(initdataStr)
+	if err != nil {
+		return err
+	}
+	globalURLs, err := initdataKBSURLs(globalInitdataStr)
+	if err != nil {
+		return fmt.Errorf("global initdata: %w", err)
+	}
+	for _, url := range urls {
+		if !util.Contains(allowedURLs, url) && !util.Contains(globalURLs, url) {
+			return fmt.Errorf("KBS URL %q of initdata is not allowed", url)
+		}
+	}
+	return nil
+}
+
+// initdataKBSURLs returns the KBS URLs in [token_configs.kbs] of aa.toml and in [kbc] of cdh.toml of base64 encoded initdata
+func initdataKBSURLs(initdataStr string) ([]string, error) {
+	if initdataStr == "" {
+		return nil, nil
+	}
+	data, err := decodeInitdata(initdataStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, config := range []struct {
+		name string
+		path []string
+	}{
+		{aaConfigName, []string{"token_configs", "kbs"}},
+		{cdhConfigName, []string{"kbc"}},
+	} {
+		url, err := getTOMLString(data.Data[config.name], config.path, "url")
+		if err != nil {
+			return nil, fmt.Errorf("reading KBS of %s: %w", config.name, err)
+		}
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls, nil
+}
+
+// getTOMLString returns a string value of a table of a TOML document, or an empty string if it is not set
+func getTOMLString(document string, path []string, key string) (string, error) {
+	config := map[string]interface{}{}
+	if err := toml.Unmarshal([]byte(document), &config); err != nil {
+		return "", err
+	}
+
+	table := config
+	for _, name := range path {
+		next, ok := table[name].(map[string]interface{})
+		if !ok {
+			if _, exists := table[name]; exists {
+				return "", fmt.Errorf("%s is not a table", name)
+			}
+			return "", nil
+		}
+		table = next
+	}
+	value, ok := table[key]
+	if !ok {
+		return "", nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s is not a string", key)
+	}
+	return str, nil
+}
+
+// overrideInitdataKBS sets the KBS of the attestation agent and the confidential data hub in base64 encoded initdata.
+// Initdata is created if it is empty. The other settings of the initdata are preserved
+func overrideInitdataKBS(initdataStr string, kbs *kbsOverride) (string, error) {
+	data, err := decodeInitdata(initdataStr)
+	if err != nil {
+		return "", err
+	}
+
+	// [token_configs.kbs] of aa.toml
+	aaConfig, err := setTOMLTable(data.Data[aaConfigName], []string{"token_configs", "kbs"}, map[string]string{"url": kbs.URL, "cert": kbs.Cert})
+	if err != nil {
+		return "", fmt.Errorf("overriding KBS of %s: %w", aaConfigName, err)
+	}
+	data.Data[aaConfigName] = aaConfig
+
+	// [kbc] of cdh.toml
+	cdhConfig, err := setTOMLTable(data.Data[cdhConfigName], []string{"kbc"}, map[string]string{"name": "cc_kbc", "url": kbs.URL, "kbs_cert": kbs.Cert})
+	if err != nil {
+		return "", fmt.Errorf("overriding KBS of %s: %w", cdhConfigName, err)
+	}
+	data.Data[cdhConfigName] = cdhConfig
+
+	return encodeInitdata(data)
+}
+
+// setTOMLTable sets the values of a table of a TOML document, and removes the values that are empty
+func setTOMLTable(document string, path []string, values map[string]string) (string, error) {
+	config := map[string]interface{}{}
+	if err := toml.Unmarshal([]byte(document), &config); err != nil {
+		return "", err
+	}
+
+	table := config
+	for _, key := range path {
+		next, ok := table[key].(map[string]interface{})
+		if !ok {
+			if _, exists := table[key]; exists {
+				return "", fmt.Errorf("%s is not a table", key)
+			}
+			next = map[string]interface{}{}
+			table[key] = next
+		}
+		table = next
+	}
+	for key, value := range values {
+		if value == "" {
+			delete(table, key)
+		} else {
+			table[key] = value
+		}
+	}
+
+	encoded, err := toml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
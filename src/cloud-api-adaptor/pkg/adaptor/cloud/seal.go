@@ -0,0 +1,103 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder"
+	. "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/kubemgr"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/sshutil"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/wnssh"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+// sealedFiles are files of a cloud config that contain secrets, such as TLS and secure comms keys.
+// Files from ConfigMaps and Secrets under UserDataFilesDir are sealed as well.
+// Initdata is not sealed, since it configures attestation to obtain the key.
+var sealedFiles = []string{forwarder.DefaultConfigPath, AuthFilePath}
+
+// Make resourceStore a mockable interface of wnssh.KbsClient
+type resourceStore interface {
+	PostResource(path string, data []byte) error
+	DeleteResource(path string) error
+}
+
+func initKbsClient(kbsAddress string) (*wnssh.KbsClient, error) {
+	kbscPrivateKey, _, err := kubemgr.KubeMgr.ReadSecret(sshutil.KBS_CLIENT_SECRET)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KBS client secret: %w", err)
+	}
+
+	kc := wnssh.InitKbsClient(kbsAddress)
+	if err := kc.SetPemSecret(kbscPrivateKey); err != nil {
+		return nil, fmt.Errorf("KbsClient - %w", err)
+	}
+	return kc, nil
+}
+
+func userDataKeyPath(sid sandboxID) string {
+	return fmt.Sprintf("default/pp-%s/userdata-key", sid)
+}
+
+// sealCloudConfig replaces sensitive files of a cloud config with a file encrypted with a per pod key.
+// The key is stored in Trustee, which releases it only to a pod VM that passes attestation.
+// The key is returned, or nil if no file is sealed.
+func (s *cloudService) sealCloudConfig(sid sandboxID, cloudConfig *cloudinit.CloudConfig) ([]byte, error) {
+
+	var files, sealed []cloudinit.WriteFile
+	for _, file := range cloudConfig.WriteFiles {
+		if isSealedFile(file.Path) {
+			sealed = append(sealed, file)
+		} else {
+			files = append(files, file)
+		}
+	}
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+
+	key, err := cloudinit.NewSealKey()
+	if err != nil {
+		return nil, err
+	}
+
+	resource := userDataKeyPath(sid)
+	sealedFile, err := cloudinit.SealFiles(sealed, resource, key)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Printf("Updating KBS with userdata key for: %s", resource)
+	if err := s.kbsClient.PostResource(resource, key); err != nil {
+		return nil, fmt.Errorf("failed to PostResource userdata key: %w", err)
+	}
+
+	cloudConfig.WriteFiles = append(files, *sealedFile)
+	return key, nil
+}
+
+// deleteUserDataKey deletes the userdata key of a pod from Trustee, so that it is not released after the pod is deleted
+func (s *cloudService) deleteUserDataKey(sid sandboxID) {
+
+	resource := userDataKeyPath(sid)
+	logger.Printf("Deleting userdata key from KBS: %s", resource)
+	if err := s.kbsClient.DeleteResource(resource); err != nil {
+		logger.Printf("failed to delete userdata key %s: %v", resource, err)
+	}
+}
+
+func isSealedFile(path string) bool {
+	if strings.HasPrefix(path, UserDataFilesDir+"/") {
+		return true
+	}
+	for _, sealed := range sealedFiles {
+		if path == sealed {
+			return true
+		}
+	}
+	return false
+}
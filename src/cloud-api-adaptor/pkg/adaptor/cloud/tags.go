@@ -0,0 +1,44 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util"
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+)
+
+// reservedTagPrefix is the prefix of the tags that cloud-api-adaptor sets to find its instances and their objects
+const reservedTagPrefix = "peerpod-"
+
+// instanceTags returns the custom tags of the pod VM of a pod. The tags of a pod take precedence over the ones of
+// its namespace, which take precedence over the tags of the provider config
+func instanceTags(podAnnotations, nsAnnotations map[string]string) (map[string]string, error) {
+	var tags provider.KeyValueFlag
+
+	for _, annotations := range []struct {
+		source string
+		values map[string]string
+	}{
+		{"namespace", nsAnnotations},
+		{"pod", podAnnotations},
+	} {
+		value := annotations.values[util.TagsAnnotation]
+		if value == "" {
+			continue
+		}
+		if err := tags.Set(value); err != nil {
+			return nil, fmt.Errorf("%s annotation of %s: %w", util.TagsAnnotation, annotations.source, err)
+		}
+	}
+
+	for key := range tags {
+		if key == "" || strings.HasPrefix(key, reservedTagPrefix) {
+			return nil, fmt.Errorf("%s annotation: tag %q is reserved", util.TagsAnnotation, key)
+		}
+	}
+	return tags, nil
+}
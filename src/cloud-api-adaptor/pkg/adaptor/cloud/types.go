@@ -5,10 +5,12 @@ package cloud
 
 import (
 	"context"
+	"crypto/tls"
 	"sync"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/k8sops"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/proxy"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
@@ -16,11 +18,14 @@ import (
 	pb "github.com/kata-containers/kata-containers/src/runtime/protocols/hypervisor"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/wnssh"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/wnwg"
 )
 
 type Service interface {
 	pb.HypervisorService
 	GetInstanceID(ctx context.Context, podNamespace, podName string, wait bool) (string, error)
+	GetMetricsAddr(podNamespace, podName string) (string, *tls.Config, error)
+	GetMeasurement(podNamespace, podName string) (*initdata.Measurement, error)
 	ConfigVerifier() error
 	Teardown() error
 }
@@ -34,7 +39,11 @@ type cloudService struct {
 	mutex        sync.Mutex
 	ppService    *k8sops.PeerPodService
 	sshClient    *wnssh.SshClient
+	wgClient     *wnwg.WgClient
+	kbsClient    resourceStore
 	serverConfig *ServerConfig
+	// credentials caches the providers with the credentials of namespaces and pods
+	credentials *credentialsProviders
 }
 
 type sandboxID string
@@ -51,4 +60,17 @@ type sandbox struct {
 	netNSPath     string
 	spec          provider.InstanceTypeSpec
 	sshClientInst *wnssh.SshClientInstance
+	wgClientInst  *wnwg.WgClientInstance
+	metricsAddr   string
+	metricsTLS    *tls.Config
+	forwarderPort string
+	stopMonitor   func()
+	measurement   *initdata.Measurement
+	// initdata is sent to the pod VM when it exceeds the inline limit of userdata
+	initdata string
+	// userDataKey is the key of sealed userdata, with which the pod VM proves that it is attested
+	userDataKey []byte
+	// provider creates and deletes the instance, with the credentials of credentialsSecret if it is set
+	provider          provider.Provider
+	credentialsSecret string
 }
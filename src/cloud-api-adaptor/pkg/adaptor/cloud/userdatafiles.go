@@ -0,0 +1,85 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"sort"
+
+	. "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+// Make objectDataGetter a mockable interface of k8sops.PeerPodService
+type objectDataGetter interface {
+	GetConfigMapData(name string, namespace string) (map[string][]byte, error)
+	GetSecretData(name string, namespace string) (map[string][]byte, error)
+}
+
+// userDataWriteFiles returns write_files entries of the keys of ConfigMaps and Secrets referenced by a pod.
+// Secrets are only allowed if userdata is sealed, since userdata is readable from the instance metadata otherwise
+func userDataWriteFiles(getter objectDataGetter, namespace string, refs []util.UserDataFile, sealed bool) ([]cloudinit.WriteFile, error) {
+	var files []cloudinit.WriteFile
+
+	for _, ref := range refs {
+		if ref.Secret != "" && !sealed {
+			return nil, fmt.Errorf("secret %s in %s annotation requires sealed userdata", ref.Secret, util.UserDataFilesAnnotation)
+		}
+
+		var data map[string][]byte
+		var err error
+		if ref.ConfigMap != "" {
+			if data, err = getter.GetConfigMapData(ref.ConfigMap, namespace); err != nil {
+				return nil, fmt.Errorf("failed to get ConfigMap %s in namespace %s: %w", ref.ConfigMap, namespace, err)
+			}
+		} else {
+			if data, err = getter.GetSecretData(ref.Secret, namespace); err != nil {
+				return nil, fmt.Errorf("failed to get Secret %s in namespace %s: %w", ref.Secret, namespace, err)
+			}
+		}
+
+		paths := map[string]string{}
+		if ref.Key != "" {
+			if _, ok := data[ref.Key]; !ok {
+				return nil, fmt.Errorf("key %s is not found in %s%s", ref.Key, ref.ConfigMap, ref.Secret)
+			}
+			paths[ref.Key] = path.Join(UserDataFilesDir, ref.Path)
+		} else {
+			for key := range data {
+				paths[key] = path.Join(UserDataFilesDir, ref.Path, key)
+			}
+		}
+
+		keys := make([]string, 0, len(paths))
+		for key := range paths {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			// Content is base64 encoded, since keys may have binary data
+			files = append(files, cloudinit.WriteFile{
+				Path:        paths[key],
+				Owner:       ref.Owner,
+				Permissions: ref.Mode,
+				Encoding:    "b64",
+				Content:     base64.StdEncoding.EncodeToString(data[key]),
+			})
+		}
+	}
+
+	return files, nil
+}
+
+// mergeCloudConfig merges a user supplied cloud-config after expanding variables such as {{.PodName}}
+func mergeCloudConfig(cloudConfig *cloudinit.CloudConfig, extra string, vars *cloudinit.UserDataVars) error {
+	expanded, err := cloudinit.ExpandUserDataVars(extra, vars)
+	if err != nil {
+		return err
+	}
+	return cloudConfig.Merge(expanded)
+}
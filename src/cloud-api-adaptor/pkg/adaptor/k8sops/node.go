@@ -15,8 +15,11 @@ import (
 	restclient "k8s.io/client-go/rest"
 )
 
+// DefaultExtendedResource is the extended resource that peer pods request by default
+const DefaultExtendedResource = "kata.peerpods.io/vm"
+
 // AdvertiseExtendedResources sets up extended resources for the node
-func AdvertiseExtendedResources(peerPodsLimitPerNode int) error {
+func AdvertiseExtendedResources(extendedResource string, peerPodsLimitPerNode int) error {
 
 	logger.Printf("set up extended resources")
 
@@ -27,7 +30,7 @@ func AdvertiseExtendedResources(peerPodsLimitPerNode int) error {
 
 	nodeName := os.Getenv("NODE_NAME")
 
-	patch := append([]jsonPatch{}, newJsonPatch("add", "/status/capacity", "kata.peerpods.io~1vm",
+	patch := append([]jsonPatch{}, newJsonPatch("add", "/status/capacity", escapeJsonPointer(extendedResource),
 		strconv.Itoa(peerPodsLimitPerNode)))
 
 	config, err := getKubeConfig()
@@ -52,13 +55,13 @@ func AdvertiseExtendedResources(peerPodsLimitPerNode int) error {
 }
 
 // Patch the status of a node to remove extended resources
-func RemoveExtendedResources() error {
+func RemoveExtendedResources(extendedResource string) error {
 
 	logger.Printf("remove extended resources")
 
 	nodeName := os.Getenv("NODE_NAME")
 
-	patch := append([]jsonPatch{}, newJsonPatch("remove", "/status/capacity", "kata.peerpods.io~1vm", ""))
+	patch := append([]jsonPatch{}, newJsonPatch("remove", "/status/capacity", escapeJsonPointer(extendedResource), ""))
 
 	config, err := getKubeConfig()
 	if err != nil {
@@ -81,6 +84,11 @@ func RemoveExtendedResources() error {
 	return nil
 }
 
+// escapeJsonPointer escapes a key for a JSON pointer, e.g. kata.peerpods.io/vm to kata.peerpods.io~1vm
+func escapeJsonPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
 // patchNodeStatus patches the status of a node
 func patchNodeStatus(c *k8sclient.Clientset, nodeName string, patches []jsonPatch) error {
 	if len(patches) > 0 {
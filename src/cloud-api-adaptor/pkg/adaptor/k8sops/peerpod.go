@@ -5,6 +5,7 @@ package k8sops
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -26,7 +27,7 @@ var logger = log.New(log.Writer(), "[util/k8sops] ", log.LstdFlags|log.Lmsgprefi
 var ppFinalizer string = "peer.pod/finalizer"
 
 type PeerPodService struct {
-	client        *kubernetes.Clientset
+	client        kubernetes.Interface
 	uclient       *rest.RESTClient // use generated client instead
 	cloudProvider string
 	podToPP       map[string]string // map Pod UID to owned PeerPod Name
@@ -58,7 +59,13 @@ func NewPeerPodService() (*PeerPodService, error) {
 	return &PeerPodService{client: clientset, uclient: restClient, cloudProvider: cloudProvider, podToPP: make(map[string]string)}, nil
 }
 
-func (s *PeerPodService) newPeerPod(pod *v1.Pod, instanceId string) *peerPodV1alpha1.PeerPod {
+// NewPeerPodServiceForClient returns a PeerPodService that reads pods, namespaces and their objects with client.
+// It can't manage PeerPod objects, which require the REST client that NewPeerPodService creates
+func NewPeerPodServiceForClient(client kubernetes.Interface, cloudProvider string) *PeerPodService {
+	return &PeerPodService{client: client, cloudProvider: cloudProvider, podToPP: make(map[string]string)}
+}
+
+func (s *PeerPodService) newPeerPod(pod *v1.Pod, instanceId string, sandboxID string, credentialsSecret string) *peerPodV1alpha1.PeerPod {
 	pp := peerPodV1alpha1.PeerPod{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: peerPodV1alpha1.GroupVersion.Group + "/" + peerPodV1alpha1.GroupVersion.Version,
@@ -68,13 +75,17 @@ func (s *PeerPodService) newPeerPod(pod *v1.Pod, instanceId string) *peerPodV1al
 			Name:       pod.Name + "-resource-" + rand.String(5),
 			Namespace:  pod.Namespace,
 			Finalizers: []string{ppFinalizer},
+			Annotations: map[string]string{
+				peerPodV1alpha1.SandboxIDAnnotation: sandboxID,
+			},
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(pod, v1.SchemeGroupVersion.WithKind("Pod")),
 			},
 		},
 		Spec: peerPodV1alpha1.PeerPodSpec{
-			InstanceID:    string(instanceId),
-			CloudProvider: s.cloudProvider,
+			InstanceID:        string(instanceId),
+			CloudProvider:     s.cloudProvider,
+			CredentialsSecret: credentialsSecret,
 		},
 	}
 	*pp.ObjectMeta.OwnerReferences[0].BlockOwnerDeletion = true // needed?
@@ -89,13 +100,110 @@ func (s *PeerPodService) getPod(podname string, podns string) (*v1.Pod, error) {
 	return pod, nil
 }
 
-// make the pod an owner of a PeerPod
-func (s *PeerPodService) OwnPeerPod(podname string, podns string, instanceID string) error {
+// GetHostPorts returns the container ports of a pod that are exposed on the worker node with hostPort
+func (s *PeerPodService) GetHostPorts(podname string, podns string) ([]v1.ContainerPort, error) {
+	pod, err := s.getPod(podname, podns)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []v1.ContainerPort
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort > 0 {
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+// GetPodAnnotations returns the annotations of a pod
+func (s *PeerPodService) GetPodAnnotations(podname string, podns string) (map[string]string, error) {
+	pod, err := s.getPod(podname, podns)
+	if err != nil {
+		return nil, err
+	}
+	return pod.Annotations, nil
+}
+
+// GetNamespaceAnnotations returns the annotations of a namespace
+func (s *PeerPodService) GetNamespaceAnnotations(namespace string) (map[string]string, error) {
+	ns, err := s.client.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return ns.Annotations, nil
+}
+
+// GetConfigMapData returns the data and binary data of a ConfigMap
+func (s *PeerPodService) GetConfigMapData(name string, namespace string) (map[string][]byte, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+	for key, value := range cm.Data {
+		data[key] = []byte(value)
+	}
+	for key, value := range cm.BinaryData {
+		data[key] = value
+	}
+	return data, nil
+}
+
+// GetSecretData returns the data of a Secret
+func (s *PeerPodService) GetSecretData(name string, namespace string) (map[string][]byte, error) {
+	secret, err := s.client.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+// RecordEvent creates an event of a pod
+func (s *PeerPodService) RecordEvent(podname string, podns string, eventType, reason, message string) error {
+	pod, err := s.getPod(podname, podns)
+	if err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pod.Name + ".",
+			Namespace:    pod.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       pod.Name,
+			Namespace:  pod.Namespace,
+			UID:        pod.UID,
+		},
+		Type:    eventType,
+		Reason:  reason,
+		Message: message,
+		Source: v1.EventSource{
+			Component: "cloud-api-adaptor",
+			Host:      os.Getenv("NODE_NAME"),
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err = s.client.CoreV1().Events(pod.Namespace).Create(context.TODO(), event, metav1.CreateOptions{})
+	return err
+}
+
+// make the pod an owner of a PeerPod. credentialsSecret is the Secret with the cloud credentials of the instance, if any
+func (s *PeerPodService) OwnPeerPod(podname string, podns string, instanceID string, sandboxID string, credentialsSecret string) error {
 	pod, err := s.getPod(podname, podns)
 	if err != nil {
 		return err
 	}
-	pp := s.newPeerPod(pod, instanceID)
+	pp := s.newPeerPod(pod, instanceID, sandboxID, credentialsSecret)
 	result := peerPodV1alpha1.PeerPod{}
 	err = s.uclient.Post().Namespace(pod.Namespace).Resource("peerPods").Body(pp).Do(context.TODO()).Into(&result)
 	if err != nil {
@@ -106,6 +214,25 @@ func (s *PeerPodService) OwnPeerPod(podname string, podns string, instanceID str
 	return nil
 }
 
+// update the status of the PeerPod owned by a pod. Empty fields of status are left unchanged
+func (s *PeerPodService) UpdatePeerPodStatus(podname string, podns string, status peerPodV1alpha1.PeerPodStatus) error {
+	pod, err := s.getPod(podname, podns)
+	if err != nil {
+		return err
+	}
+
+	ownedPPName, ok := s.podToPP[string(pod.UID)]
+	if !ok {
+		return errors.New("pod to PeerPod mapping not found")
+	}
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return err
+	}
+	result := peerPodV1alpha1.PeerPod{}
+	return s.uclient.Patch(types.MergePatchType).Name(ownedPPName).Namespace(podns).Resource("peerPods").SubResource("status").Body(patch).Do(context.TODO()).Into(&result)
+}
+
 // remove finalizer from PeerPod
 func (s *PeerPodService) ReleasePeerPod(podname string, podns string, instanceID string) error {
 	pod, err := s.getPod(podname, podns)
@@ -0,0 +1,160 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package adaptor
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/cloud"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/proxy"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/connstats"
+)
+
+const (
+	// PodVMMetricsPath is the URL path prefix under which the metrics of the
+	// agent-protocol-forwarder of a pod are served, e.g. /podvm-metrics/<namespace>/<pod>
+	PodVMMetricsPath = "/podvm-metrics/"
+	// PodVMConnectionsPath is the URL path prefix under which the connection statistics
+	// of the agent-protocol-forwarder of a pod are served, e.g. /podvm-connections/<namespace>/<pod>
+	PodVMConnectionsPath = "/podvm-connections/"
+	// PodVMStatusPath is the URL path prefix under which the boot status of a pod VM
+	// is served, e.g. /podvm-status/<namespace>/<pod>
+	PodVMStatusPath = "/podvm-status/"
+	// PodVMMeasurementPath is the URL path prefix under which the expected measurement of the initdata
+	// and the daemon config of a pod VM is served, e.g. /podvm-measurement/<namespace>/<pod>
+	PodVMMeasurementPath = "/podvm-measurement/"
+
+	// MetricsPath and ConnectionsPath serve the metrics and the connection statistics of cloud-api-adaptor itself
+	MetricsPath     = "/metrics"
+	ConnectionsPath = "/connections"
+)
+
+const podVMMetricsTimeout = 10 * time.Second
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(connstats.NewCollector(proxy.Connections, "peerpod", "adaptor"))
+	registry.MustRegister(cloud.BootStatusCollectors()...)
+}
+
+// MetricsHandler returns an HTTP handler exposing the cloud-api-adaptor metrics in the Prometheus text format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ConnectionsHandler returns an HTTP handler serving the statistics of the connections to peer pod VMs as JSON
+func ConnectionsHandler() http.Handler {
+	return proxy.Connections.Handler()
+}
+
+type podVMMetricsHandler struct {
+	cloudService cloud.Service
+	client       *http.Client
+	prefix       string
+	targetPath   string
+}
+
+// newPodVMMetricsHandler returns a handler that forwards requests for prefix<namespace>/<pod>
+// to targetPath of the metrics endpoint of the agent-protocol-forwarder of the pod
+func newPodVMMetricsHandler(cloudService cloud.Service, prefix, targetPath string) http.Handler {
+	return &podVMMetricsHandler{
+		cloudService: cloudService,
+		client:       &http.Client{Timeout: podVMMetricsTimeout},
+		prefix:       prefix,
+		targetPath:   targetPath,
+	}
+}
+
+func (h *podVMMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	namespace, podName, ok := parsePodPath(w, r, h.prefix)
+	if !ok {
+		return
+	}
+
+	addr, tlsConfig, err := h.cloudService.GetMetricsAddr(namespace, podName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	metricsURL := &url.URL{
+		Scheme: "http",
+		Host:   addr,
+		Path:   h.targetPath,
+	}
+
+	client := h.client
+	if tlsConfig != nil {
+		metricsURL.Scheme = "https"
+		client = &http.Client{
+			Timeout:   podVMMetricsTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true},
+		}
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, metricsURL.String(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if accept := r.Header.Get("Accept"); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		logger.Printf("failed to scrape metrics of pod %s/%s: %v", namespace, podName, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(res.StatusCode)
+	if _, err := io.Copy(w, res.Body); err != nil {
+		logger.Printf("failed to forward metrics of pod %s/%s: %v", namespace, podName, err)
+	}
+}
+
+// parsePodPath parses prefix<namespace>/<pod> in the path of r
+func parsePodPath(w http.ResponseWriter, r *http.Request, prefix string) (namespace, podName string, ok bool) {
+	namespace, podName, ok = strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if !ok || namespace == "" || podName == "" || strings.Contains(podName, "/") {
+		http.Error(w, "expected "+prefix+"<namespace>/<pod>", http.StatusBadRequest)
+		return "", "", false
+	}
+	return namespace, podName, true
+}
+
+// newPodVMMeasurementHandler returns a handler serving the measurement of prefix<namespace>/<pod> as JSON
+func newPodVMMeasurementHandler(cloudService cloud.Service, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace, podName, ok := parsePodPath(w, r, prefix)
+		if !ok {
+			return
+		}
+
+		measurement, err := cloudService.GetMeasurement(namespace, podName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(measurement); err != nil {
+			logger.Printf("failed to write measurement of pod %s/%s: %v", namespace, podName, err)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package adaptor
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultMonitoringAddr is the default listen address of the monitoring server, which is only reachable from the node
+const DefaultMonitoringAddr = "127.0.0.1:8006"
+
+// MonitoringServer serves the metrics and the connection statistics of cloud-api-adaptor, and the metrics, the
+// connection statistics, the boot status and the measurement of each pod VM. It is separate from the probe server,
+// since it exposes the pods of all tenants. Requests need a bearer token if the server has one, which is required
+// unless the server listens on a loopback address
+type MonitoringServer struct {
+	listenAddr string
+	token      string
+	mux        *http.ServeMux
+	readyCh    chan struct{}
+}
+
+// NewMonitoringServer returns a monitoring server listening on listenAddr. The bearer token is read from tokenFile
+// if it is not empty. The pod VM endpoints are served when podVMs is set, and only the measurements otherwise
+func NewMonitoringServer(listenAddr, tokenFile string, server Server, podVMs bool) (*MonitoringServer, error) {
+
+	var token string
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading monitoring token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			return nil, fmt.Errorf("monitoring token file %s is empty", tokenFile)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid monitoring address %q: %w", listenAddr, err)
+	}
+	if ip := net.ParseIP(host); token == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("monitoring address %s is not a loopback address, which requires a token", listenAddr)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, MetricsHandler())
+	mux.Handle(ConnectionsPath, ConnectionsHandler())
+	if server != nil {
+		mux.Handle(PodVMMeasurementPath, server.PodVMMeasurementHandler())
+		if podVMs {
+			mux.Handle(PodVMMetricsPath, server.PodVMMetricsHandler())
+			mux.Handle(PodVMConnectionsPath, server.PodVMConnectionsHandler())
+			mux.Handle(PodVMStatusPath, server.PodVMStatusHandler())
+		}
+	}
+
+	return &MonitoringServer{
+		listenAddr: listenAddr,
+		token:      token,
+		mux:        mux,
+		readyCh:    make(chan struct{}),
+	}, nil
+}
+
+func (s *MonitoringServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *MonitoringServer) Start(ctx context.Context) error {
+
+	listener, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to create monitoring listener: %w", err)
+	}
+	s.listenAddr = listener.Addr().String()
+
+	httpServer := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serverErr := make(chan error)
+	go func() {
+		defer close(serverErr)
+
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- fmt.Errorf("error running monitoring server: %w", err)
+		}
+	}()
+
+	logger.Printf("serving metrics on %s%s", s.listenAddr, MetricsPath)
+
+	close(s.readyCh)
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return httpServer.Shutdown(shutdownCtx)
+	case err := <-serverErr:
+		return err
+	}
+}
+
+func (s *MonitoringServer) Ready() chan struct{} {
+	return s.readyCh
+}
+
+// Addr returns the address the monitoring server listens on, once it is ready
+func (s *MonitoringServer) Addr() string {
+	<-s.readyCh
+	return s.listenAddr
+}
@@ -0,0 +1,95 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package adaptor
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMonitoringServerAddress(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		addr      string
+		tokenFile string
+		valid     bool
+	}{
+		{addr: "127.0.0.1:0", valid: true},
+		{addr: "[::1]:0", valid: true},
+		{addr: "localhost:0", valid: true},
+		{addr: "0.0.0.0:8006"},
+		{addr: ":8006"},
+		{addr: "0.0.0.0:8006", tokenFile: tokenFile, valid: true},
+		{addr: "8006"},
+	} {
+		_, err := NewMonitoringServer(tc.addr, tc.tokenFile, nil, false)
+		if tc.valid && err != nil {
+			t.Errorf("address %q with token file %q: %v", tc.addr, tc.tokenFile, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("address %q with token file %q: expected an error", tc.addr, tc.tokenFile)
+		}
+	}
+
+	if _, err := NewMonitoringServer("0.0.0.0:8006", filepath.Join(t.TempDir(), "missing"), nil, false); err == nil {
+		t.Error("expected an error for a missing token file")
+	}
+}
+
+func TestMonitoringServerToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewMonitoringServer("127.0.0.1:0", tokenFile, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverErr := make(chan error)
+	go func() {
+		serverErr <- s.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-serverErr; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, tc := range []struct {
+		auth   string
+		status int
+	}{
+		{auth: "", status: http.StatusUnauthorized},
+		{auth: "Bearer wrong", status: http.StatusUnauthorized},
+		{auth: "secret", status: http.StatusUnauthorized},
+		{auth: "Bearer secret", status: http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+s.Addr()+ConnectionsPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("authorization %q: expected status %d, got %d", tc.auth, tc.status, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+type contextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// ParseUpstreamProxy parses the URL of a proxy used to reach agent-protocol-forwarder.
+// Supported schemes are http (HTTP CONNECT) and socks5. An empty string disables the proxy.
+func ParseUpstreamProxy(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", rawURL, err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q in %q: use http or socks5", proxyURL.Scheme, rawURL)
+	}
+
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", rawURL)
+	}
+
+	return proxyURL, nil
+}
+
+// ParseNoProxy parses a comma separated list of CIDRs of pod VM addresses that are reached without the upstream proxy
+func ParseNoProxy(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid no-proxy CIDR %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// bypassesUpstream returns true if address is a loopback address, like the local ends of secure comms
+// tunnels, or in one of the noProxy prefixes, like the overlay network of WireGuard secure comms
+func bypassesUpstream(address string, noProxy []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, prefix := range noProxy {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newUpstreamDialer returns a dialer that establishes connections through proxyURL
+func newUpstreamDialer(proxyURL *url.URL, forward *net.Dialer) (contextDialer, error) {
+	switch proxyURL.Scheme {
+	case "http":
+		return &connectDialer{proxyURL: proxyURL, forward: forward}, nil
+	case "socks5", "socks5h":
+		var auth *xproxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &xproxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		dialer, err := xproxy.SOCKS5("tcp", proxyURL.Host, auth, forward)
+		if err != nil {
+			return nil, fmt.Errorf("creating SOCKS5 dialer for %s: %w", proxyURL.Redacted(), err)
+		}
+		return dialer.(contextDialer), nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+// connectDialer tunnels connections through an HTTP proxy using the CONNECT method
+type connectDialer struct {
+	proxyURL *url.URL
+	forward  *net.Dialer
+}
+
+func (d *connectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to proxy %s: %w", d.proxyURL.Redacted(), err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	// Abort the CONNECT handshake when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sending CONNECT request to proxy %s: %w", d.proxyURL.Redacted(), err)
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading CONNECT response from proxy %s: %w", d.proxyURL.Redacted(), err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", d.proxyURL.Redacted(), address, res.Status)
+	}
+
+	if ctx.Err() != nil {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns data the proxy sent right after its CONNECT response before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// NetConn returns the connection to the proxy
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// tlsDialer runs a TLS handshake over connections established by an arbitrary dialer
+type tlsDialer struct {
+	dialer contextDialer
+	config *tls.Config
+}
+
+func (d *tlsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, d.config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -4,6 +4,8 @@
 package proxy
 
 import (
+	"net/netip"
+	"net/url"
 	"time"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
@@ -18,9 +20,13 @@ type factory struct {
 	tlsConfig    *tlsutil.TLSConfig
 	caService    tlsutil.CAService
 	proxyTimeout time.Duration
+	upstream     *url.URL
+	noProxy      []netip.Prefix
+	keepalive    time.Duration
+	compression  []string
 }
 
-func NewFactory(pauseImage string, tlsConfig *tlsutil.TLSConfig, proxyTimeout time.Duration) Factory {
+func NewFactory(pauseImage string, tlsConfig *tlsutil.TLSConfig, proxyTimeout time.Duration, upstream *url.URL, noProxy []netip.Prefix, keepalive time.Duration, compression []string) Factory {
 
 	if tlsConfig != nil && !tlsConfig.HasCertAuth() {
 
@@ -49,10 +55,14 @@ func NewFactory(pauseImage string, tlsConfig *tlsutil.TLSConfig, proxyTimeout ti
 		tlsConfig:    tlsConfig,
 		caService:    caService,
 		proxyTimeout: proxyTimeout,
+		upstream:     upstream,
+		noProxy:      noProxy,
+		keepalive:    keepalive,
+		compression:  compression,
 	}
 }
 
 func (f *factory) New(serverName, socketPath string) AgentProxy {
 
-	return NewAgentProxy(serverName, socketPath, f.pauseImage, f.tlsConfig, f.caService, f.proxyTimeout)
+	return NewAgentProxy(serverName, socketPath, f.pauseImage, f.tlsConfig, f.caService, f.proxyTimeout, f.upstream, f.noProxy, f.keepalive, f.compression)
 }
@@ -0,0 +1,116 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// FallbackDelay is how long a connection attempt may take before an attempt to the next address of a pod VM starts in parallel
+const FallbackDelay = 300 * time.Millisecond
+
+// SortAddrs orders the addresses of a dual-stack pod VM for connection attempts. Address families
+// alternate starting with the family of the first address, as recommended by RFC 8305.
+func SortAddrs(addrs []netip.Addr) []netip.Addr {
+	if len(addrs) == 0 {
+		return addrs
+	}
+
+	var primary, secondary []netip.Addr
+	for _, addr := range addrs {
+		if addr.Unmap().Is4() == addrs[0].Unmap().Is4() {
+			primary = append(primary, addr)
+		} else {
+			secondary = append(secondary, addr)
+		}
+	}
+
+	sorted := make([]netip.Addr, 0, len(addrs))
+	for len(primary) > 0 || len(secondary) > 0 {
+		if len(primary) > 0 {
+			sorted = append(sorted, primary[0])
+			primary = primary[1:]
+		}
+		if len(secondary) > 0 {
+			sorted = append(sorted, secondary[0])
+			secondary = secondary[1:]
+		}
+	}
+	return sorted
+}
+
+// dialParallel connects to the first address that accepts a connection. An attempt to the next address starts
+// when the previous attempt failed or did not complete within fallbackDelay, and slower attempts are canceled.
+func dialParallel(ctx context.Context, dialer contextDialer, network string, addresses []string, fallbackDelay time.Duration) (net.Conn, error) {
+	if len(addresses) == 1 {
+		return dialer.DialContext(ctx, network, addresses[0])
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addresses))
+
+	next, pending := 0, 0
+	start := func() {
+		address := addresses[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, address)
+			results <- result{conn, err}
+		}()
+	}
+	start()
+
+	var errs []error
+	for pending > 0 {
+		var fallback <-chan time.Time
+		var timer *time.Timer
+		if next < len(addresses) {
+			timer = time.NewTimer(fallbackDelay)
+			fallback = timer.C
+		}
+
+		var conn net.Conn
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Close connections that were established concurrently
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				conn = r.conn
+			} else {
+				errs = append(errs, r.err)
+				if next < len(addresses) {
+					start()
+				}
+			}
+		case <-fallback:
+			start()
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if conn != nil {
+			return conn, nil
+		}
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,103 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortAddrs(t *testing.T) {
+	parse := func(addrs ...string) []netip.Addr {
+		var parsed []netip.Addr
+		for _, addr := range addrs {
+			parsed = append(parsed, netip.MustParseAddr(addr))
+		}
+		return parsed
+	}
+
+	for _, tc := range []struct {
+		name     string
+		addrs    []netip.Addr
+		expected []netip.Addr
+	}{
+		{
+			name:     "IPv4 only",
+			addrs:    parse("192.0.2.1", "192.0.2.2"),
+			expected: parse("192.0.2.1", "192.0.2.2"),
+		},
+		{
+			name:     "IPv6 only",
+			addrs:    parse("2001:db8::1"),
+			expected: parse("2001:db8::1"),
+		},
+		{
+			name:     "dual-stack",
+			addrs:    parse("2001:db8::1", "2001:db8::2", "192.0.2.1"),
+			expected: parse("2001:db8::1", "192.0.2.1", "2001:db8::2"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if sorted := SortAddrs(tc.addrs); !reflect.DeepEqual(sorted, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, sorted)
+			}
+		})
+	}
+}
+
+// blockingDialer never completes connection attempts to blocked addresses
+type blockingDialer struct {
+	net.Dialer
+	blocked string
+}
+
+func (d *blockingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if address == d.blocked {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+func TestDialParallel(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	blocked := "192.0.2.1:15150"
+	dialer := &blockingDialer{blocked: blocked}
+
+	start := time.Now()
+	conn, err := dialParallel(context.Background(), dialer, "tcp", []string{blocked, listener.Addr().String()}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected a connection to the fallback address: %v", err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("connecting took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := dialParallel(ctx, dialer, "tcp", []string{blocked}, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
@@ -4,19 +4,28 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/avast/retry-go/v4"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/connstats"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/streamcompress"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
 	"github.com/containerd/ttrpc"
 	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
@@ -25,6 +34,7 @@ import (
 const (
 	SocketName          = "agent.ttrpc"
 	DefaultProxyTimeout = 5 * time.Minute
+	DefaultKeepalive    = 30 * time.Second
 
 	// The server TLS certificate must have this as SAN
 	// TODO: Avoid hard coding of server name
@@ -33,12 +43,20 @@ const (
 
 var logger = log.New(log.Writer(), "[adaptor/proxy] ", log.LstdFlags|log.Lmsgprefix)
 
+// Connections tracks the bandwidth and latency of the connections to agent-protocol-forwarder
+var Connections = connstats.NewTracker()
+
+// PodVMStream labels connections to agent-protocol-forwarder in connection statistics
+const PodVMStream = "podvm"
+
 type AgentProxy interface {
-	Start(ctx context.Context, serverURL *url.URL) error
+	Start(ctx context.Context, serverURL *url.URL, fallbackAddrs []string) error
 	Ready() chan struct{}
 	Shutdown() error
 	CAService() tlsutil.CAService
 	ClientCA() (certPEM []byte)
+	ClientTLSConfig() (*tls.Config, error)
+	SendInitdata(ctx context.Context, addresses []string, data []byte) error
 }
 
 type agentProxy struct {
@@ -50,11 +68,27 @@ type agentProxy struct {
 	socketPath   string
 	pauseImage   string
 	proxyTimeout time.Duration
+	upstream     *url.URL
+	noProxy      []netip.Prefix
+	keepalive    time.Duration
+	compression  []string
 	stopOnce     sync.Once
+
+	// uncompressed is set when the forwarder does not support compression, so that it is not offered again
+	uncompressed atomic.Bool
+
+	// clientTLS is created once, so that the files of tlsConfig are not read and parsed for every connection
+	clientTLSOnce sync.Once
+	clientTLS     *tls.Config
+	clientTLSErr  error
 }
 
-func NewAgentProxy(serverName, socketPath, pauseImage string, tlsConfig *tlsutil.TLSConfig, caService tlsutil.CAService, proxyTimeout time.Duration) AgentProxy {
+func NewAgentProxy(serverName, socketPath, pauseImage string, tlsConfig *tlsutil.TLSConfig, caService tlsutil.CAService, proxyTimeout time.Duration, upstream *url.URL, noProxy []netip.Prefix, keepalive time.Duration, compression []string) AgentProxy {
 	return &agentProxy{
+		compression:  compression,
+		keepalive:    keepalive,
+		upstream:     upstream,
+		noProxy:      noProxy,
 		serverName:   serverName,
 		socketPath:   socketPath,
 		readyCh:      make(chan struct{}),
@@ -66,41 +100,70 @@ func NewAgentProxy(serverName, socketPath, pauseImage string, tlsConfig *tlsutil
 	}
 }
 
-func (p *agentProxy) dial(ctx context.Context, address string) (net.Conn, error) {
-	var conn net.Conn
+func (p *agentProxy) dial(ctx context.Context, addresses []string) (net.Conn, error) {
+	conn, err := p.dialConn(ctx, addresses)
+	if err != nil {
+		return nil, err
+	}
 
-	var dialer interface {
-		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	address := strings.Join(addresses, ",")
+	if len(p.compression) > 0 && !p.uncompressed.Load() {
+		compressed, err := streamcompress.Client(ctx, conn, p.compression)
+		switch {
+		case errors.Is(err, streamcompress.ErrUnsupported):
+			// The forwarder received the preface as agent protocol data, so the connection can't be used anymore
+			logger.Printf("falling back to uncompressed traffic with %s: %v", address, err)
+			conn.Close()
+			p.uncompressed.Store(true)
+			if conn, err = p.dialConn(ctx, addresses); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			conn.Close()
+			err = fmt.Errorf("failed to negotiate compression with %s: %w", address, err)
+			logger.Print(err)
+			return nil, err
+		default:
+			conn = compressed
+		}
 	}
 
+	logger.Printf("established agent proxy connection to %s", address)
+	return Connections.Track(conn, PodVMStream, p.serverName), nil
+}
+
+// dialConn connects to the first of addresses that accepts a connection, over TLS if it is enabled
+func (p *agentProxy) dialConn(ctx context.Context, addresses []string) (net.Conn, error) {
+	var conn net.Conn
+
+	var dialer contextDialer
+	address := strings.Join(addresses, ",")
+
 	netDialer := &net.Dialer{Timeout: p.proxyTimeout}
+	dialer = netDialer
+
+	// Connections to secure comms tunnels never go through the upstream proxy
+	if p.upstream != nil && !bypassesUpstream(addresses[0], p.noProxy) {
+		upstreamDialer, err := newUpstreamDialer(p.upstream, netDialer)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("Using proxy %s to connect to %s", p.upstream.Redacted(), address)
+		dialer = upstreamDialer
+	}
 
 	if p.tlsConfig != nil {
 
 		// Create a TLS configuration object
-		config, err := tlsutil.GetTLSConfigFor(p.tlsConfig)
+		config, err := p.ClientTLSConfig()
 		if err != nil {
 			return nil, fmt.Errorf("Failed to create tls config: %v", err)
 		}
-		// This is important otherwise you'll hit the following error
-		// cannot validate certificate for <IP> because it doesn't contain any IP SAN
-		// Since it's not possible to know the IP address of the pod VM apriori,
-		// we are using a well-defined hostname here. Other option is to create
-		// certificates with IP SAN having all the IPs in the network range
-		// When CA service is enabled, a server certificate is automatically generated for
-		// the instance VM name.
-		if p.caService != nil {
-			config.ServerName = p.serverName
-		} else {
-			config.ServerName = podvmServername
-		}
 
-		dialer = &tls.Dialer{
-			NetDialer: netDialer,
-			Config:    config,
+		dialer = &tlsDialer{
+			dialer: dialer,
+			config: config,
 		}
-	} else {
-		dialer = netDialer
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, p.proxyTimeout)
@@ -110,7 +173,7 @@ func (p *agentProxy) dial(ctx context.Context, address string) (net.Conn, error)
 	err := retry.Do(
 		func() error {
 			var err error
-			if conn, err = dialer.DialContext(ctx, "tcp", address); err != nil {
+			if conn, err = dialParallel(ctx, dialer, "tcp", addresses, FallbackDelay); err != nil {
 				logger.Printf("Retrying agent proxy connection to %s...", address)
 			}
 			return err
@@ -124,12 +187,48 @@ func (p *agentProxy) dial(ctx context.Context, address string) (net.Conn, error)
 		logger.Print(err)
 		return nil, err
 	}
-
-	logger.Printf("established agent proxy connection to %s", address)
 	return conn, nil
 }
 
-func (p *agentProxy) Start(ctx context.Context, serverURL *url.URL) error {
+// SendInitdata sends initdata that exceeds the inline limit of userdata to process-user-data of the pod VM at
+// addresses. process-user-data receives it with the TLS certificates of the agent protocol, before
+// agent-protocol-forwarder listens on the same port
+func (p *agentProxy) SendInitdata(ctx context.Context, addresses []string, data []byte) error {
+	if p.tlsConfig == nil {
+		return errors.New("sending initdata requires TLS")
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialTLSContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return p.dialConn(ctx, addresses)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+
+	// The host of the URL is not resolved, since connections are established by dialConn
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "https://"+p.serverName+initdata.SendPath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send initdata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to send initdata: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	logger.Printf("sent initdata to %s", strings.Join(addresses, ","))
+	return nil
+}
+
+// Start serves the agent protocol on the socket and forwards it to serverURL. When the pod VM has several
+// addresses, fallbackAddrs are tried in parallel if connecting to the address of serverURL is slow.
+func (p *agentProxy) Start(ctx context.Context, serverURL *url.URL, fallbackAddrs []string) error {
 	if err := os.MkdirAll(filepath.Dir(p.socketPath), os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create parent directories for socket: %s", p.socketPath)
 	}
@@ -145,10 +244,10 @@ func (p *agentProxy) Start(ctx context.Context, serverURL *url.URL) error {
 	}
 
 	dialer := func(ctx context.Context) (net.Conn, error) {
-		return p.dial(ctx, serverURL.Host)
+		return p.dial(ctx, append([]string{serverURL.Host}, fallbackAddrs...))
 	}
 
-	proxyService := newProxyService(dialer, p.pauseImage)
+	proxyService := newProxyService(dialer, p.pauseImage, p.keepalive)
 	defer func() {
 		if err := proxyService.Close(); err != nil {
 			logger.Printf("error closing agent proxy connection: %v", err)
@@ -215,6 +314,41 @@ func (p *agentProxy) CAService() tlsutil.CAService {
 	return p.caService
 }
 
+// clientTLSConfig returns the TLS configuration of connections to agent-protocol-forwarder.
+// Its certificates are reloaded when their files change
+func (p *agentProxy) clientTLSConfig() (*tls.Config, error) {
+	p.clientTLSOnce.Do(func() {
+		p.clientTLS, p.clientTLSErr = tlsutil.GetTLSConfigFor(p.tlsConfig)
+	})
+	return p.clientTLS, p.clientTLSErr
+}
+
+// ClientTLSConfig returns the TLS configuration of connections to the agent-protocol-forwarder of the pod VM,
+// or nil if TLS is disabled
+func (p *agentProxy) ClientTLSConfig() (*tls.Config, error) {
+	if p.tlsConfig == nil {
+		return nil, nil
+	}
+	config, err := p.clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	config = config.Clone()
+	// This is important otherwise you'll hit the following error
+	// cannot validate certificate for <IP> because it doesn't contain any IP SAN
+	// Since it's not possible to know the IP address of the pod VM apriori,
+	// we are using a well-defined hostname here. Other option is to create
+	// certificates with IP SAN having all the IPs in the network range
+	// When CA service is enabled, a server certificate is automatically generated for
+	// the instance VM name.
+	if p.caService != nil {
+		config.ServerName = p.serverName
+	} else {
+		config.ServerName = podvmServername
+	}
+	return config, nil
+}
+
 func (p *agentProxy) ClientCA() (certPEM []byte) {
 	if p.tlsConfig == nil {
 		return nil
@@ -223,6 +357,15 @@ func (p *agentProxy) ClientCA() (certPEM []byte) {
 		// When a client CA file is explicitly specified, we don't need to put it in cloud-init data
 		return nil
 	}
+	if len(p.tlsConfig.CertData) == 0 && p.tlsConfig.CertFile != "" {
+		// The client certificate file may have been rotated, so the current certificate is used
+		config, err := p.clientTLSConfig()
+		if err != nil {
+			logger.Printf("failed to load client certificate %s: %v", p.tlsConfig.CertFile, err)
+			return nil
+		}
+		return tlsutil.CertificatePEM(config)
+	}
 
 	return p.tlsConfig.CertData
 }
@@ -23,7 +23,7 @@ func TestNewAgentProxy(t *testing.T) {
 
 	socketPath := "/run/dummy.sock"
 
-	proxy := NewAgentProxy("podvm", socketPath, "", nil, nil, 0)
+	proxy := NewAgentProxy("podvm", socketPath, "", nil, nil, 0, nil, nil, 0, nil)
 	p, ok := proxy.(*agentProxy)
 	if !ok {
 		t.Fatalf("expect %T, got %T", &agentProxy{}, proxy)
@@ -79,7 +79,7 @@ func TestStartStop(t *testing.T) {
 		Host:   agentListener.Addr().String(),
 	}
 
-	proxy := NewAgentProxy("podvm", socketPath, "", nil, nil, 5*time.Second)
+	proxy := NewAgentProxy("podvm", socketPath, "", nil, nil, 5*time.Second, nil, nil, 0, nil)
 	p, ok := proxy.(*agentProxy)
 	if !ok {
 		t.Fatalf("expect %T, got %T", &agentProxy{}, proxy)
@@ -89,7 +89,7 @@ func TestStartStop(t *testing.T) {
 	go func() {
 		defer close(proxyErrCh)
 
-		if err := proxy.Start(context.Background(), serverURL); err != nil {
+		if err := proxy.Start(context.Background(), serverURL, nil); err != nil {
 			proxyErrCh <- err
 		}
 	}()
@@ -170,7 +170,7 @@ func TestDialerSuccess(t *testing.T) {
 			}
 		}()
 
-		conn, err := p.dial(context.Background(), address)
+		conn, err := p.dial(context.Background(), []string{address})
 		if err == nil {
 			listener.Close()
 			break
@@ -197,7 +197,7 @@ func TestDialerFailure(t *testing.T) {
 	}
 
 	address := "0.0.0.0:0"
-	conn, err := p.dial(context.Background(), address)
+	conn, err := p.dial(context.Background(), []string{address})
 	if err == nil {
 		conn.Close()
 		t.Fatal("expect error, got nil")
@@ -208,6 +208,54 @@ func TestDialerFailure(t *testing.T) {
 	}
 }
 
+func TestDialerCompressionFallback(t *testing.T) {
+	p := &agentProxy{
+		proxyTimeout: 5 * time.Second,
+		compression:  []string{"zstd"},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+	defer listener.Close()
+
+	// The forwarder does not support compression, so it passes the preface to the agent, which closes the connection
+	received := make(chan string, 3)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 64)
+			n, _ := conn.Read(buf)
+			received <- string(buf[:n])
+			conn.Close()
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn, err := p.dial(context.Background(), []string{listener.Addr().String()})
+		if err != nil {
+			t.Fatalf("expect no error, got %q", err)
+		}
+		if _, err := conn.Write([]byte("agent")); err != nil {
+			t.Fatalf("expect no error, got %q", err)
+		}
+		conn.Close()
+	}
+
+	if !p.uncompressed.Load() {
+		t.Fatal("expect compression to be disabled")
+	}
+	for i, expected := range []string{"CAA-COMPRESS/1 zstd\n", "agent", "agent"} {
+		if data := <-received; data != expected {
+			t.Fatalf("expect connection %d to receive %q, got %q", i, expected, data)
+		}
+	}
+}
+
 type agentMock struct{}
 
 func (m *agentMock) CreateContainer(ctx context.Context, req *pb.CreateContainerRequest) (*emptypb.Empty, error) {
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/agentproto"
 	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
@@ -29,9 +30,9 @@ const (
 	imageGuestPull               = "image_guest_pull"
 )
 
-func newProxyService(dialer func(context.Context) (net.Conn, error), pauseImage string) *proxyService {
+func newProxyService(dialer func(context.Context) (net.Conn, error), pauseImage string, keepalive time.Duration) *proxyService {
 
-	redirector := agentproto.NewRedirector(dialer)
+	redirector := agentproto.NewRedirector(dialer, agentproto.WithKeepalive(keepalive))
 
 	return &proxyService{
 		Redirector: redirector,
@@ -7,6 +7,7 @@ import (
 	"context"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
@@ -18,6 +19,8 @@ import (
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/k8sops"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/proxy"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/adaptor/vminfo"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/metrics"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/sshutil"
 	pbPodVMInfo "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/proto/podvminfo"
@@ -35,6 +38,10 @@ type Server interface {
 	Start(ctx context.Context) error
 	Shutdown() error
 	Ready() chan struct{}
+	PodVMMetricsHandler() http.Handler
+	PodVMConnectionsHandler() http.Handler
+	PodVMStatusHandler() http.Handler
+	PodVMMeasurementHandler() http.Handler
 }
 
 type server struct {
@@ -48,16 +55,25 @@ type server struct {
 	stopOnce                sync.Once
 	enableCloudConfigVerify bool
 	PeerPodsLimitPerNode    int
+	extendedResource        string
 }
 
-func NewServer(provider provider.Provider, cfg *cloud.ServerConfig, workerNode podnetwork.WorkerNode) Server {
+func NewServer(provider provider.Provider, cfg *cloud.ServerConfig, workerNode podnetwork.WorkerNode) (Server, error) {
 
 	logger.Printf("server config: %#v", cfg)
 
-	agentFactory := proxy.NewFactory(cfg.PauseImage, cfg.TLSConfig, cfg.ProxyTimeout)
-	cloudService := cloud.NewService(provider, agentFactory, workerNode, cfg, sshutil.SSHPORT)
+	agentFactory := proxy.NewFactory(cfg.PauseImage, cfg.TLSConfig, cfg.ProxyTimeout, cfg.UpstreamProxy, cfg.UpstreamNoProxy, cfg.ProxyKeepalive, cfg.ForwarderCompression)
+	cloudService, err := cloud.NewService(provider, agentFactory, workerNode, cfg, sshutil.SSHPORT)
+	if err != nil {
+		return nil, err
+	}
 	vmInfoService := vminfo.NewService(cloudService)
 
+	extendedResource := cfg.ExtendedResource
+	if extendedResource == "" {
+		extendedResource = k8sops.DefaultExtendedResource
+	}
+
 	return &server{
 		socketPath:              cfg.SocketPath,
 		cloudService:            cloudService,
@@ -67,7 +83,8 @@ func NewServer(provider provider.Provider, cfg *cloud.ServerConfig, workerNode p
 		stopCh:                  make(chan struct{}),
 		enableCloudConfigVerify: cfg.EnableCloudConfigVerify,
 		PeerPodsLimitPerNode:    cfg.PeerPodsLimitPerNode,
-	}
+		extendedResource:        extendedResource,
+	}, nil
 }
 
 func (s *server) Start(ctx context.Context) (err error) {
@@ -78,7 +95,7 @@ func (s *server) Start(ctx context.Context) (err error) {
 		}
 	}
 	// Advertise node resources
-	err = k8sops.AdvertiseExtendedResources(s.PeerPodsLimitPerNode)
+	err = k8sops.AdvertiseExtendedResources(s.extendedResource, s.PeerPodsLimitPerNode)
 	if err != nil {
 		return err
 	}
@@ -137,7 +154,7 @@ func (s *server) Shutdown() error {
 		close(s.stopCh)
 	})
 
-	_ = k8sops.RemoveExtendedResources()
+	_ = k8sops.RemoveExtendedResources(s.extendedResource)
 
 	return s.cloudService.Teardown()
 }
@@ -145,3 +162,25 @@ func (s *server) Shutdown() error {
 func (s *server) Ready() chan struct{} {
 	return s.readyCh
 }
+
+// PodVMMetricsHandler returns an HTTP handler that scrapes the metrics of the
+// agent-protocol-forwarder running in a peer pod VM
+func (s *server) PodVMMetricsHandler() http.Handler {
+	return newPodVMMetricsHandler(s.cloudService, PodVMMetricsPath, metrics.MetricsURLPath)
+}
+
+// PodVMConnectionsHandler returns an HTTP handler that fetches the connection statistics
+// of the agent-protocol-forwarder running in a peer pod VM
+func (s *server) PodVMConnectionsHandler() http.Handler {
+	return newPodVMMetricsHandler(s.cloudService, PodVMConnectionsPath, metrics.ConnectionsURLPath)
+}
+
+// PodVMStatusHandler returns an HTTP handler that fetches the boot status reported by a peer pod VM
+func (s *server) PodVMStatusHandler() http.Handler {
+	return newPodVMMetricsHandler(s.cloudService, PodVMStatusPath, status.StatusURLPath)
+}
+
+// PodVMMeasurementHandler returns an HTTP handler serving the expected measurement of a peer pod VM
+func (s *server) PodVMMeasurementHandler() http.Handler {
+	return newPodVMMeasurementHandler(s.cloudService, PodVMMeasurementPath)
+}
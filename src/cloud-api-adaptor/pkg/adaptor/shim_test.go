@@ -99,7 +99,10 @@ func TestShim(t *testing.T) {
 	}
 
 	provider := &mockProvider{primaryIP: primaryIP, secondaryIP: secondaryIP}
-	srv := NewServer(provider, serverConfig, workerNode)
+	srv, err := NewServer(provider, serverConfig, workerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	serverDone := make(chan struct{})
 	go func() {
@@ -165,7 +168,7 @@ func startDaemon(t *testing.T, ctx context.Context, agentSocketPath string, port
 	config := &daemon.Config{}
 
 	nsPath := os.Getenv("AGENT_PROTOCOL_FORWARDER_NAMESPACE")
-	interceptor := interceptor.NewInterceptor(agentSocketPath, nsPath)
+	interceptor := interceptor.NewInterceptor(agentSocketPath, nsPath, "")
 
 	d := daemon.NewDaemon(config, "127.0.0.1:0", nil, interceptor, &mockPodNode{})
 
@@ -546,3 +549,11 @@ func (n *mockPodNode) Setup() error {
 func (n *mockPodNode) Teardown() error {
 	return nil
 }
+
+func (n *mockPodNode) Check() error {
+	return nil
+}
+
+func (n *mockPodNode) Repair() error {
+	return nil
+}
@@ -11,19 +11,23 @@ import (
 	"log"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/containerd/ttrpc"
 	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/interceptor"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/metrics"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/streamcompress"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
 )
 
 var logger = log.New(log.Writer(), "[forwarder] ", log.LstdFlags|log.Lmsgprefix)
 
 const (
+	// The unspecified IPv4 address also accepts IPv6 connections, so pod VMs with only IPv6 addresses are reachable
 	DefaultListenHost          = "0.0.0.0"
 	DefaultListenPort          = "15150"
 	DefaultListenAddr          = DefaultListenHost + ":" + DefaultListenPort
@@ -39,6 +43,14 @@ type Config struct {
 	PodNamespace string           `json:"pod-namespace"`
 	PodName      string           `json:"pod-name"`
 
+	// Identity of the pod VM, which guests can use for logging and attestation policies
+	SandboxID string `json:"sandbox-id,omitempty"`
+	NodeName  string `json:"node-name,omitempty"`
+	ClusterID string `json:"cluster-id,omitempty"`
+	Provider  string `json:"provider,omitempty"`
+
+	ForwarderPort string `json:"forwarder-port,omitempty"`
+
 	TLSServerKey  string `json:"tls-server-key,omitempty"`
 	TLSServerCert string `json:"tls-server-cert,omitempty"`
 	TLSClientCA   string `json:"tls-client-ca,omitempty"`
@@ -48,6 +60,13 @@ type Config struct {
 	SecureCommsInbounds  string `json:"sc-inbounds,omitempty"`
 	SecureCommsOutbounds string `json:"sc-outbounds,omitempty"`
 	SecureComms          bool   `json:"sc,omitempty"`
+	SecureCommsTransport string `json:"sc-transport,omitempty"`
+	WgAddress            string `json:"sc-wg-addr,omitempty"`
+	WgWnAddress          string `json:"sc-wg-wn-addr,omitempty"`
+
+	MetricsPort string `json:"metrics-port,omitempty"`
+
+	TunnelCheckInterval time.Duration `json:"tunnel-check-interval,omitempty"`
 }
 
 type Daemon interface {
@@ -58,17 +77,20 @@ type Daemon interface {
 }
 
 type daemon struct {
-	tlsConfig   *tlsutil.TLSConfig
-	interceptor interceptor.Interceptor
-	podNode     podnetwork.PodNode
-	readyCh     chan struct{}
-	stopCh      chan struct{}
-	listenAddr  string
-	stopOnce    sync.Once
+	tlsConfig           *tlsutil.TLSConfig
+	interceptor         interceptor.Interceptor
+	podNode             podnetwork.PodNode
+	tunnelCheckInterval time.Duration
+	readyCh             chan struct{}
+	stopCh              chan struct{}
+	listenAddr          string
+	stopOnce            sync.Once
 }
 
 func NewDaemon(spec *Config, listenAddr string, tlsConfig *tlsutil.TLSConfig, interceptor interceptor.Interceptor, podNode podnetwork.PodNode) Daemon {
 
+	// The certificates in the daemon config are issued by cloud-api-adaptor for the lifetime of the Pod VM,
+	// so unlike certificate files they are not reloaded
 	if tlsConfig != nil && !tlsConfig.HasCertAuth() {
 		tlsConfig.CertData = []byte(spec.TLSServerCert)
 		tlsConfig.KeyData = []byte(spec.TLSServerKey)
@@ -79,12 +101,13 @@ func NewDaemon(spec *Config, listenAddr string, tlsConfig *tlsutil.TLSConfig, in
 	}
 
 	daemon := &daemon{
-		listenAddr:  listenAddr,
-		tlsConfig:   tlsConfig,
-		interceptor: interceptor,
-		podNode:     podNode,
-		readyCh:     make(chan struct{}),
-		stopCh:      make(chan struct{}),
+		listenAddr:          listenAddr,
+		tlsConfig:           tlsConfig,
+		interceptor:         interceptor,
+		podNode:             podNode,
+		tunnelCheckInterval: spec.TunnelCheckInterval,
+		readyCh:             make(chan struct{}),
+		stopCh:              make(chan struct{}),
 	}
 
 	return daemon
@@ -103,6 +126,11 @@ func (d *daemon) Start(ctx context.Context) error {
 		}
 	}()
 
+	if d.tunnelCheckInterval > 0 {
+		stopMonitor := podnetwork.StartMonitor(d.tunnelCheckInterval, d.podNode.Check, d.podNode.Repair, nil)
+		defer stopMonitor()
+	}
+
 	// Set up agent protocol interceptor
 
 	var listener net.Listener
@@ -133,6 +161,9 @@ func (d *daemon) Start(ctx context.Context) error {
 	}
 
 	d.listenAddr = listener.Addr().String()
+	listener = metrics.Listener(listener, metrics.AdaptorStream)
+	// Compression is used when cloud-api-adaptor asks for it
+	listener = streamcompress.Listener(listener, streamcompress.Supported)
 
 	ttrpcServer, err := ttrpc.NewServer()
 	if err != nil {
@@ -100,3 +100,11 @@ func (n *mockPodNode) Setup() error {
 func (n *mockPodNode) Teardown() error {
 	return nil
 }
+
+func (n *mockPodNode) Check() error {
+	return nil
+}
+
+func (n *mockPodNode) Repair() error {
+	return nil
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/metrics"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/agentproto"
 )
 
@@ -37,6 +38,8 @@ type interceptor struct {
 	agentproto.Redirector
 
 	nsPath string
+	// sandboxID is the sandbox that the pod VM is created for. CreateSandbox of other sandboxes is rejected unless it is empty
+	sandboxID string
 }
 
 func dial(ctx context.Context, agentSocket string) (net.Conn, error) {
@@ -66,17 +69,18 @@ func dial(ctx context.Context, agentSocket string) (net.Conn, error) {
 	return conn, nil
 }
 
-func NewInterceptor(agentSocket, nsPath string) Interceptor {
+func NewInterceptor(agentSocket, nsPath, sandboxID string) Interceptor {
 
-	agentDialer := func(ctx context.Context) (net.Conn, error) {
+	agentDialer := metrics.Dialer(func(ctx context.Context) (net.Conn, error) {
 		return dial(ctx, agentSocket)
-	}
+	}, metrics.AgentStream)
 
 	redirector := agentproto.NewRedirector(agentDialer)
 
 	return &interceptor{
 		Redirector: redirector,
 		nsPath:     nsPath,
+		sandboxID:  sandboxID,
 	}
 }
 
@@ -196,6 +200,13 @@ func (i *interceptor) CreateSandbox(ctx context.Context, req *pb.CreateSandboxRe
 
 	logger.Printf("CreateSandbox: hostname:%s sandboxId:%s", req.Hostname, req.SandboxId)
 
+	// Signed user data of the pod VM is bound to a sandbox, so that user data of another pod VM is not replayed
+	if i.sandboxID != "" && req.SandboxId != i.sandboxID {
+		err := fmt.Errorf("sandbox %s does not match sandbox %s of the pod VM", req.SandboxId, i.sandboxID)
+		logger.Printf("CreateSandbox failed with error: %v", err)
+		return nil, err
+	}
+
 	if len(req.Dns) > 0 {
 		logger.Print("    dns:")
 		for _, d := range req.Dns {
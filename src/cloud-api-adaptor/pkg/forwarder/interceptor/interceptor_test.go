@@ -4,8 +4,10 @@
 package interceptor
 
 import (
+	"context"
 	"testing"
 
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,12 +15,20 @@ func TestNewInterceptor(t *testing.T) {
 
 	socketName := "dummy.sock"
 
-	i := NewInterceptor(socketName, "")
+	i := NewInterceptor(socketName, "", "")
 	if i == nil {
 		t.Fatal("Expect non nil, got nil")
 	}
 }
 
+func TestCreateSandboxOfAnotherSandbox(t *testing.T) {
+
+	i := NewInterceptor("dummy.sock", "", "0123456789abcdef")
+
+	_, err := i.CreateSandbox(context.Background(), &pb.CreateSandboxRequest{SandboxId: "fedcba9876543210"})
+	assert.Error(t, err)
+}
+
 func TestIsTargetPath(t *testing.T) {
 	path := "/path/to/target"
 
@@ -0,0 +1,268 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/connstats"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
+)
+
+var logger = log.New(log.Writer(), "[forwarder/metrics] ", log.LstdFlags|log.Lmsgprefix)
+
+const (
+	// AdaptorStream labels connections accepted from cloud-api-adaptor
+	AdaptorStream = "adaptor"
+	// AgentStream labels connections made to the kata agent
+	AgentStream = "agent"
+
+	MetricsURLPath     = "/metrics"
+	ConnectionsURLPath = "/connections"
+)
+
+var (
+	registry    = prometheus.NewRegistry()
+	connections = connstats.NewTracker()
+	connSeq     atomic.Uint64
+
+	activeConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "peerpod",
+		Subsystem: "forwarder",
+		Name:      "active_connections",
+		Help:      "Number of open connections per stream",
+	}, []string{"stream"})
+
+	bytesForwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "forwarder",
+		Name:      "bytes_total",
+		Help:      "Number of bytes forwarded per stream and direction",
+	}, []string{"stream", "direction"})
+
+	reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "forwarder",
+		Name:      "reconnects_total",
+		Help:      "Number of connections established after all the connections of a stream were closed",
+	}, []string{"stream"})
+
+	tlsHandshakeFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "forwarder",
+		Name:      "tls_handshake_failures_total",
+		Help:      "Number of failed TLS handshakes with cloud-api-adaptor",
+	})
+)
+
+func init() {
+	registry.MustRegister(activeConnections, bytesForwarded, reconnects, tlsHandshakeFailures)
+	registry.MustRegister(connstats.NewCollector(connections, "peerpod", "forwarder"))
+}
+
+// Handler returns an HTTP handler exposing the forwarder metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// streamState detects reconnects of a stream. Concurrent connections, like the ones the kata shim opens,
+// are not reconnects, but a connection established after all the previous ones were closed is
+type streamState struct {
+	stream    string
+	open      atomic.Int64
+	connected atomic.Bool
+}
+
+func (s *streamState) opened() {
+	if s.open.Add(1) == 1 && s.connected.Swap(true) {
+		reconnects.WithLabelValues(s.stream).Inc()
+	}
+}
+
+func (s *streamState) closed() {
+	s.open.Add(-1)
+}
+
+type conn struct {
+	net.Conn
+	state     *streamState
+	closeOnce sync.Once
+	tlsFailed atomic.Bool
+}
+
+// newConn returns a connection accounted to the stream of state, whose bandwidth and latency are tracked individually
+func newConn(c net.Conn, state *streamState) net.Conn {
+	activeConnections.WithLabelValues(state.stream).Inc()
+	state.opened()
+	id := strconv.FormatUint(connSeq.Add(1), 10)
+	return connections.Track(&conn{Conn: c, state: state}, state.stream, id)
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	bytesForwarded.WithLabelValues(c.state.stream, "in").Add(float64(n))
+	c.checkHandshake(err)
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	bytesForwarded.WithLabelValues(c.state.stream, "out").Add(float64(n))
+	c.checkHandshake(err)
+	return n, err
+}
+
+// NetConn returns the wrapped connection
+func (c *conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		activeConnections.WithLabelValues(c.state.stream).Dec()
+		c.state.closed()
+	})
+	return c.Conn.Close()
+}
+
+// checkHandshake counts an I/O error on a TLS connection whose handshake never completed as a handshake failure
+func (c *conn) checkHandshake(err error) {
+	if err == nil {
+		return
+	}
+	tlsConn, ok := c.Conn.(*tls.Conn)
+	if !ok || tlsConn.ConnectionState().HandshakeComplete {
+		return
+	}
+	if c.tlsFailed.CompareAndSwap(false, true) {
+		tlsHandshakeFailures.Inc()
+	}
+}
+
+type listener struct {
+	net.Listener
+	state *streamState
+}
+
+// Listener returns a listener whose accepted connections are accounted to stream
+func Listener(l net.Listener, stream string) net.Listener {
+	return &listener{Listener: l, state: &streamState{stream: stream}}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(c, l.state), nil
+}
+
+// Dialer returns a dial function whose connections are accounted to stream
+func Dialer(dial func(context.Context) (net.Conn, error), stream string) func(context.Context) (net.Conn, error) {
+	state := &streamState{stream: stream}
+
+	return func(ctx context.Context) (net.Conn, error) {
+		c, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return newConn(c, state), nil
+	}
+}
+
+// Server serves the forwarder metrics and the boot status of the pod VM over HTTP,
+// or over HTTPS when it has a TLS config
+type Server struct {
+	listenAddr string
+	tlsConfig  *tlsutil.TLSConfig
+	statusPath string
+	readyCh    chan struct{}
+}
+
+// NewServer returns a metrics server listening on listenAddr. With a TLS config, the server
+// requires the same client certificates as the agent protocol, and tlsConfig is loaded when
+// the server is started
+func NewServer(listenAddr string, tlsConfig *tlsutil.TLSConfig) *Server {
+	return &Server{
+		listenAddr: listenAddr,
+		tlsConfig:  tlsConfig,
+		statusPath: status.DefaultStatusPath,
+		readyCh:    make(chan struct{}),
+	}
+}
+
+func (s *Server) Start(ctx context.Context) error {
+
+	listener, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to create metrics listener: %w", err)
+	}
+	s.listenAddr = listener.Addr().String()
+
+	if s.tlsConfig != nil {
+		tlsConfig, err := tlsutil.GetTLSConfigFor(s.tlsConfig)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to create TLS config of metrics server: %w", err)
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(MetricsURLPath, Handler())
+	mux.Handle(ConnectionsURLPath, connections.Handler())
+	mux.Handle(status.StatusURLPath, status.Handler(s.statusPath))
+
+	httpServer := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serverErr := make(chan error)
+	go func() {
+		defer close(serverErr)
+
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- fmt.Errorf("error running metrics server: %w", err)
+		}
+	}()
+
+	logger.Printf("serving metrics on %s%s", s.listenAddr, MetricsURLPath)
+
+	close(s.readyCh)
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return httpServer.Shutdown(shutdownCtx)
+	case err := <-serverErr:
+		return err
+	}
+}
+
+func (s *Server) Ready() chan struct{} {
+	return s.readyCh
+}
+
+func (s *Server) Addr() string {
+	<-s.readyCh
+	return s.listenAddr
+}
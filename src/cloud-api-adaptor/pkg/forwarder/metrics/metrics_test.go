@@ -0,0 +1,234 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
+)
+
+func TestListener(t *testing.T) {
+	const stream = "test-listener"
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	listener := Listener(l, stream)
+	defer listener.Close()
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				return
+			}
+			_, _ = c.Write([]byte("hello"))
+			_, _ = io.ReadFull(c, make([]byte, 3))
+			c.Close()
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		c, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("failed to accept: %v", err)
+		}
+		if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if _, err := c.Write([]byte("bye")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if got := testutil.ToFloat64(activeConnections.WithLabelValues(stream)); got != 1 {
+			t.Errorf("expected 1 active connection, got %v", got)
+		}
+		c.Close()
+	}
+
+	if got := testutil.ToFloat64(activeConnections.WithLabelValues(stream)); got != 0 {
+		t.Errorf("expected no active connections, got %v", got)
+	}
+	if got := testutil.ToFloat64(bytesForwarded.WithLabelValues(stream, "in")); got != 10 {
+		t.Errorf("expected 10 bytes in, got %v", got)
+	}
+	if got := testutil.ToFloat64(bytesForwarded.WithLabelValues(stream, "out")); got != 6 {
+		t.Errorf("expected 6 bytes out, got %v", got)
+	}
+	if got := testutil.ToFloat64(reconnects.WithLabelValues(stream)); got != 1 {
+		t.Errorf("expected 1 reconnect, got %v", got)
+	}
+}
+
+func TestReconnects(t *testing.T) {
+	const stream = "test-reconnects"
+
+	dial := Dialer(func(context.Context) (net.Conn, error) {
+		c, _ := net.Pipe()
+		return c, nil
+	}, stream)
+
+	var conns []net.Conn
+	connect := func() {
+		c, err := dial(context.Background())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		conns = append(conns, c)
+	}
+
+	// Concurrent connections are not reconnects, even when one of them is replaced
+	connect()
+	connect()
+	conns[0].Close()
+	connect()
+	if got := testutil.ToFloat64(reconnects.WithLabelValues(stream)); got != 0 {
+		t.Errorf("expected no reconnects, got %v", got)
+	}
+
+	// A connection established after all the connections were closed is a reconnect
+	for _, c := range conns {
+		c.Close()
+	}
+	connect()
+	if got := testutil.ToFloat64(reconnects.WithLabelValues(stream)); got != 1 {
+		t.Errorf("expected 1 reconnect, got %v", got)
+	}
+}
+
+func TestTLSHandshakeFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	// The server has no certificate, so every handshake fails
+	listener := Listener(tls.NewListener(l, &tls.Config{}), "test-tls")
+	defer listener.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_ = tls.Client(c, &tls.Config{InsecureSkipVerify: true}).Handshake()
+	}()
+
+	before := testutil.ToFloat64(tlsHandshakeFailures)
+
+	c, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+
+	if got := testutil.ToFloat64(tlsHandshakeFailures) - before; got != 1 {
+		t.Errorf("expected 1 handshake failure, got %v", got)
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer("127.0.0.1:0", nil)
+	errCh := make(chan error)
+	go func() {
+		errCh <- server.Start(ctx)
+	}()
+
+	res, err := http.Get("http://" + server.Addr() + MetricsURLPath)
+	if err != nil {
+		t.Fatalf("failed to get metrics: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	if !strings.Contains(string(body), "peerpod_forwarder_tls_handshake_failures_total") {
+		t.Errorf("metrics do not contain the handshake failure counter:\n%s", body)
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	caService, err := tlsutil.NewCAService("test")
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	serverCert, serverKey, err := caService.Issue("podvm")
+	if err != nil {
+		t.Fatalf("failed to issue server certificate: %v", err)
+	}
+	clientCert, clientKey, err := tlsutil.NewClientCertificate("test")
+	if err != nil {
+		t.Fatalf("failed to create client certificate: %v", err)
+	}
+
+	server := NewServer("127.0.0.1:0", &tlsutil.TLSConfig{CertData: serverCert, KeyData: serverKey, CAData: clientCert})
+	errCh := make(chan error)
+	go func() {
+		errCh <- server.Start(ctx)
+	}()
+
+	// Plain HTTP and clients without a certificate are rejected
+	if res, err := http.Get("http://" + server.Addr() + MetricsURLPath); err == nil {
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			t.Error("expected plain HTTP to be rejected")
+		}
+	}
+
+	clientTLS, err := tlsutil.GetTLSConfigFor(&tlsutil.TLSConfig{CertData: clientCert, KeyData: clientKey, CAData: caService.RootCertificate()})
+	if err != nil {
+		t.Fatalf("failed to create client TLS config: %v", err)
+	}
+	clientTLS.ServerName = "podvm"
+
+	anonymousTLS := clientTLS.Clone()
+	anonymousTLS.Certificates = nil
+	anonymousTLS.GetClientCertificate = nil
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: anonymousTLS}}
+	if res, err := anonymous.Get("https://" + server.Addr() + MetricsURLPath); err == nil {
+		res.Body.Close()
+		t.Error("expected a client without a certificate to be rejected")
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	res, err := client.Get("https://" + server.Addr() + MetricsURLPath)
+	if err != nil {
+		t.Fatalf("failed to get metrics: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
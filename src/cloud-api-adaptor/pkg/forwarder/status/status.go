@@ -0,0 +1,264 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package status records the boot phases of a pod VM, which cloud-api-adaptor reads from the metrics endpoint
+// of agent-protocol-forwarder to tell at which phase a pod VM failed to start
+package status
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var logger = log.New(log.Writer(), "[forwarder/status] ", log.LstdFlags|log.Lmsgprefix)
+
+const (
+	DefaultStatusPath = "/run/peerpod/status.json"
+	StatusURLPath     = "/status"
+	// FallbackPort is the port where process-user-data serves the status when provisioning of the pod VM fails,
+	// since agent-protocol-forwarder doesn't start without the daemon config in userdata. The endpoint is not
+	// authenticated, so cloud-api-adaptor only takes failures from it.
+	FallbackPort = "15152"
+)
+
+// Phase is a boot phase of a pod VM
+type Phase string
+
+const (
+	// PhaseUserDataApplied is reported by process-user-data when the files in userdata are written
+	PhaseUserDataApplied Phase = "userdata-applied"
+	// PhaseAttested is reported by process-user-data when the key of sealed userdata is released after attestation
+	PhaseAttested Phase = "attestation-succeeded"
+	// PhaseAgentReady is reported by agent-protocol-forwarder when the kata agent accepts connections
+	PhaseAgentReady Phase = "agent-ready"
+)
+
+// Phases are the boot phases in the order that a pod VM reaches them
+var Phases = []Phase{PhaseUserDataApplied, PhaseAttested, PhaseAgentReady}
+
+// Report is the result of a boot phase
+type Report struct {
+	Phase Phase     `json:"phase"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+	// Proof of PhaseAttested is made with the key of sealed userdata, which Trustee releases only to attested
+	// pod VMs, so that cloud-api-adaptor can tell it from a report that anyone else made
+	Proof string `json:"proof,omitempty"`
+}
+
+// AttestationProof returns the proof of PhaseAttested, which is an HMAC of the resource of sealed userdata with its key
+func AttestationProof(key []byte, resource string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(string(PhaseAttested) + ":" + resource))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyAttestation returns whether the report is PhaseAttested proven with the key of the resource of sealed userdata
+func (r *Report) VerifyAttestation(key []byte, resource string) bool {
+	return r.Phase == PhaseAttested && r.Error == "" && hmac.Equal([]byte(r.Proof), []byte(AttestationProof(key, resource)))
+}
+
+// Status is the reports of the boot phases of a pod VM in the order that they are recorded
+type Status struct {
+	Reports []Report `json:"reports"`
+}
+
+// Last returns the last report, or nil if no phase is reported
+func (s *Status) Last() *Report {
+	if len(s.Reports) == 0 {
+		return nil
+	}
+	return &s.Reports[len(s.Reports)-1]
+}
+
+// Read reads the status file at path. A missing file is an empty status
+func Read(path string) (*Status, error) {
+	var status Status
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &status, nil
+		}
+		return nil, fmt.Errorf("failed to read status file %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to decode status file %s: %w", path, err)
+	}
+
+	return &status, nil
+}
+
+// Record appends a report of phase to the status file at path. A non-nil phaseErr reports a failure of the phase
+func Record(path string, phase Phase, phaseErr error) error {
+	report := Report{Phase: phase, Time: time.Now().UTC()}
+	if phaseErr != nil {
+		report.Error = phaseErr.Error()
+	}
+	return record(path, report)
+}
+
+// RecordAttested appends a report of PhaseAttested with proof to the status file at path
+func RecordAttested(path, proof string) error {
+	return record(path, Report{Phase: PhaseAttested, Time: time.Now().UTC(), Proof: proof})
+}
+
+// record appends report to the status file at path. Records of the components of a pod VM are serialized with
+// a lock file, since a record rewrites the whole file
+func record(path string, report Report) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of status file %s: %w", path, err)
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file of status file %s: %w", path, err)
+	}
+	// Closing the lock file releases the lock
+	defer lock.Close()
+
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock status file %s: %w", path, err)
+	}
+
+	status, err := Read(path)
+	if err != nil {
+		return err
+	}
+
+	status.Reports = append(status.Reports, report)
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to encode status: %w", err)
+	}
+
+	// The file is replaced atomically, since the metrics endpoint may be reading it
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary status file of %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write status file %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to change permissions of status file %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write status file %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename status file %s: %w", tmp.Name(), err)
+	}
+
+	return nil
+}
+
+// Handler returns an HTTP handler serving the status file at path as JSON
+func Handler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := Read(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			logger.Printf("failed to write status: %v", err)
+		}
+	})
+}
+
+// Serve serves the status file at path over plain HTTP at addr until ctx is canceled
+func Serve(ctx context.Context, addr, path string) error {
+
+	mux := http.NewServeMux()
+	mux.Handle(StatusURLPath, Handler(path))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Printf("failed to shut down status server: %v", err)
+		}
+	}()
+
+	logger.Printf("serving status at %s", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve status at %s: %w", addr, err)
+	}
+	return nil
+}
+
+const agentCheckInterval = time.Second
+
+// AgentWatcher records PhaseAgentReady when the kata agent socket accepts a connection
+type AgentWatcher struct {
+	socketPath string
+	statusPath string
+	readyCh    chan struct{}
+}
+
+func NewAgentWatcher(socketPath, statusPath string) *AgentWatcher {
+	return &AgentWatcher{
+		socketPath: socketPath,
+		statusPath: statusPath,
+		readyCh:    make(chan struct{}),
+	}
+}
+
+func (w *AgentWatcher) Start(ctx context.Context) error {
+
+	// The watcher doesn't delay the readiness of the forwarder
+	close(w.readyCh)
+
+	ticker := time.NewTicker(agentCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "unix", w.socketPath)
+		if err == nil {
+			conn.Close()
+			if err := Record(w.statusPath, PhaseAgentReady, nil); err != nil {
+				logger.Printf("failed to record %s: %v", PhaseAgentReady, err)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *AgentWatcher) Ready() chan struct{} {
+	return w.readyCh
+}
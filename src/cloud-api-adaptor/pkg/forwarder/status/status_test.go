@@ -0,0 +1,196 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peerpod", "status.json")
+
+	status, err := Read(path)
+	if err != nil {
+		t.Fatalf("failed to read missing status: %v", err)
+	}
+	if status.Last() != nil {
+		t.Fatalf("expected no reports, got %v", status.Reports)
+	}
+
+	if err := Record(path, PhaseUserDataApplied, nil); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := Record(path, PhaseAttested, errors.New("attestation failed")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+
+	status, err = Read(path)
+	if err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	if len(status.Reports) != 2 || status.Reports[0].Phase != PhaseUserDataApplied || status.Reports[0].Error != "" {
+		t.Fatalf("unexpected reports: %v", status.Reports)
+	}
+	if last := status.Last(); last.Phase != PhaseAttested || last.Error != "attestation failed" {
+		t.Fatalf("unexpected last report: %v", last)
+	}
+
+	srv := httptest.NewServer(Handler(path))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + StatusURLPath)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	defer res.Body.Close()
+
+	var served Status
+	if err := json.NewDecoder(res.Body).Decode(&served); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if len(served.Reports) != 2 || served.Last().Phase != PhaseAttested {
+		t.Fatalf("unexpected served reports: %v", served.Reports)
+	}
+}
+
+func TestRecordAttested(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	key := []byte("0123456789abcdef0123456789abcdef")
+	resource := "default/pp-123/userdata-key"
+
+	if err := RecordAttested(path, AttestationProof(key, resource)); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	status, err := Read(path)
+	if err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	last := status.Last()
+	if !last.VerifyAttestation(key, resource) {
+		t.Fatalf("expected a proven attestation: %v", last)
+	}
+
+	// A proof is bound to the key and the resource of a pod VM
+	if last.VerifyAttestation([]byte("other key"), resource) || last.VerifyAttestation(key, "default/pp-456/userdata-key") {
+		t.Fatal("expected the proof to be rejected")
+	}
+	if (&Report{Phase: PhaseAttested}).VerifyAttestation(key, resource) {
+		t.Fatal("expected a report without proof to be rejected")
+	}
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+
+	// process-user-data and agent-protocol-forwarder may record phases at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := Record(path, PhaseAgentReady, nil); err != nil {
+				t.Errorf("failed to record: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	status, err := Read(path)
+	if err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	if len(status.Reports) != 20 {
+		t.Fatalf("expected 20 reports, got %d", len(status.Reports))
+	}
+}
+
+func TestServe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	if err := Record(path, PhaseUserDataApplied, errors.New("no userdata source is available")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Serve(ctx, addr, path)
+	}()
+
+	var res *http.Response
+	for i := 0; i < 50; i++ {
+		if res, err = http.Get("http://" + addr + StatusURLPath); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	var served Status
+	err = json.NewDecoder(res.Body).Decode(&served)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if last := served.Last(); last == nil || last.Phase != PhaseUserDataApplied || last.Error == "" {
+		t.Fatalf("unexpected served reports: %v", served.Reports)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("status server failed: %v", err)
+	}
+}
+
+func TestAgentWatcher(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "agent.sock")
+	statusPath := filepath.Join(dir, "status.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watcher := NewAgentWatcher(socketPath, statusPath)
+	done := make(chan error)
+	go func() {
+		done <- watcher.Start(ctx)
+	}()
+	<-watcher.Ready()
+
+	// The agent starts listening after the watcher
+	time.Sleep(100 * time.Millisecond)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("watcher failed: %v", err)
+	}
+
+	status, err := Read(statusPath)
+	if err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	if last := status.Last(); last == nil || last.Phase != PhaseAgentReady {
+		t.Fatalf("expected %s, got %v", PhaseAgentReady, status.Reports)
+	}
+}
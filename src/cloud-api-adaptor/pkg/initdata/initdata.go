@@ -5,3 +5,18 @@ type InitData struct {
 	Version   string            `toml:"version"`
 	Data      map[string]string `toml:"data,omitempty"`
 }
+
+// DefaultInlineLimit is the size of base64 encoded initdata above which cloud-api-adaptor sends initdata
+// to the pod VM instead of including it in userdata. It is disabled by default
+const DefaultInlineLimit = 0
+
+// SendPath is the URL path at which process-user-data receives initdata that is too large to be included in userdata
+const SendPath = "/podvm-initdata"
+
+// Source specifies initdata that is too large to be included in userdata. process-user-data listens on Port
+// with the TLS certificates of the daemon config, and verifies the initdata that cloud-api-adaptor sends with
+// its SHA-256 digest
+type Source struct {
+	Port   string `json:"port"`
+	SHA256 string `json:"sha256"`
+}
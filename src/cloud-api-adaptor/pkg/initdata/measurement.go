@@ -0,0 +1,94 @@
+package initdata
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+// MeasurementPCR is the vTPM PCR that process-user-data extends with the digests of initdata and the daemon config
+const MeasurementPCR = 8
+
+// Digest returns the hex encoded digest of initdata calculated with its algorithm
+func Digest(algorithm string, data []byte) (string, error) {
+	switch algorithm {
+	case "sha256":
+		hash := sha256.Sum256(data)
+		return hex.EncodeToString(hash[:]), nil
+	case "sha384":
+		hash := sha512.Sum384(data)
+		return hex.EncodeToString(hash[:]), nil
+	case "sha512":
+		hash := sha512.Sum512(data)
+		return hex.EncodeToString(hash[:]), nil
+	}
+	return "", fmt.Errorf("Error creating initdata hash, the Algorithm %s not supported", algorithm)
+}
+
+// PCRDigest returns the SHA-256 digest that a hex encoded initdata digest extends the PCR with,
+// which is the first 32 bytes of the initdata digest
+func PCRDigest(initdataDigest string) ([]byte, error) {
+	digest, err := hex.DecodeString(initdataDigest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid initdata digest: %w", err)
+	}
+	if len(digest) < sha256.Size {
+		return nil, fmt.Errorf("initdata digest is shorter than %d bytes", sha256.Size)
+	}
+	return digest[:sha256.Size], nil
+}
+
+// Measurement is what process-user-data measures into MeasurementPCR of a pod VM.
+// The PCR is extended with the initdata digest first, and then with the SHA-256 digest of the daemon config.
+type Measurement struct {
+	PCR                int    `json:"pcr"`
+	InitdataDigest     string `json:"initdata-digest,omitempty"`
+	DaemonConfigDigest string `json:"daemon-config-digest"`
+	// PCRValue is the expected value of the SHA-256 bank of the PCR, provided that nothing else extends it
+	PCRValue string `json:"pcr-value"`
+}
+
+// NewMeasurement returns the measurement of a pod VM booted with a daemon config and initdata in TOML, which is nil if not given
+func NewMeasurement(initdata []byte, daemonConfig []byte) (*Measurement, error) {
+	m := &Measurement{PCR: MeasurementPCR}
+	var digests [][]byte
+
+	if initdata != nil {
+		var data InitData
+		if err := toml.Unmarshal(initdata, &data); err != nil {
+			return nil, fmt.Errorf("Error unmarshalling initdata: %w", err)
+		}
+		digest, err := Digest(data.Algorithm, initdata)
+		if err != nil {
+			return nil, err
+		}
+		pcrDigest, err := PCRDigest(digest)
+		if err != nil {
+			return nil, err
+		}
+		m.InitdataDigest = digest
+		digests = append(digests, pcrDigest)
+	}
+
+	configDigest := sha256.Sum256(daemonConfig)
+	m.DaemonConfigDigest = hex.EncodeToString(configDigest[:])
+	digests = append(digests, configDigest[:])
+
+	m.PCRValue = hex.EncodeToString(ExtendPCR(make([]byte, sha256.Size), digests...))
+
+	return m, nil
+}
+
+// ExtendPCR returns the value of a SHA-256 PCR bank extended with digests in order
+func ExtendPCR(value []byte, digests ...[]byte) []byte {
+	for _, digest := range digests {
+		hash := sha256.New()
+		hash.Write(value)
+		hash.Write(digest)
+		value = hash.Sum(nil)
+	}
+	return value
+}
@@ -0,0 +1,49 @@
+package initdata
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+)
+
+func TestNewMeasurement(t *testing.T) {
+	data := []byte("algorithm = \"sha384\"\nversion = \"0.1.0\"\n")
+	config := []byte(`{"pod-network":{}}`)
+
+	m, err := NewMeasurement(data, config)
+	if err != nil {
+		t.Fatalf("failed to measure: %v", err)
+	}
+
+	initdataDigest := sha512.Sum384(data)
+	configDigest := sha256.Sum256(config)
+	if m.PCR != MeasurementPCR {
+		t.Errorf("expected PCR %d, got %d", MeasurementPCR, m.PCR)
+	}
+	if m.InitdataDigest != hex.EncodeToString(initdataDigest[:]) {
+		t.Errorf("unexpected initdata digest %s", m.InitdataDigest)
+	}
+	if m.DaemonConfigDigest != hex.EncodeToString(configDigest[:]) {
+		t.Errorf("unexpected daemon config digest %s", m.DaemonConfigDigest)
+	}
+
+	pcr := sha256.Sum256(append(make([]byte, sha256.Size), initdataDigest[:sha256.Size]...))
+	pcr = sha256.Sum256(append(pcr[:], configDigest[:]...))
+	if m.PCRValue != hex.EncodeToString(pcr[:]) {
+		t.Errorf("expected PCR value %x, got %s", pcr, m.PCRValue)
+	}
+
+	m, err = NewMeasurement(nil, config)
+	if err != nil {
+		t.Fatalf("failed to measure without initdata: %v", err)
+	}
+	pcr = sha256.Sum256(append(make([]byte, sha256.Size), configDigest[:]...))
+	if m.InitdataDigest != "" || m.PCRValue != hex.EncodeToString(pcr[:]) {
+		t.Errorf("unexpected measurement without initdata: %+v", m)
+	}
+
+	if _, err := NewMeasurement([]byte("algorithm = \"md5\"\n"), config); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
@@ -7,5 +7,17 @@ const (
 	InitDataPath     = "/run/peerpod/initdata"
 	AgentCfgPath     = "/run/peerpod/agent-config.toml"
 	ForwarderCfgPath = "/run/peerpod/daemon.json"
+	SealedFilesPath  = "/run/peerpod/sealed-files.json"
+	UserDataFilesDir = "/run/peerpod/files"
 	UserDataPath     = "/media/cidata/user-data"
+	// ConfigDriveUserDataPath is userdata in an OpenStack config drive, which is mounted in the same way as cidata
+	ConfigDriveUserDataPath = "/media/config-2/openstack/latest/user_data"
+	// VendorUserDataPath is userdata that a pod VM image or a platform provides as a file
+	VendorUserDataPath = "/etc/peerpod/user-data"
+	// InitDataSourcePath specifies initdata that cloud-api-adaptor sends instead of including it in userdata
+	InitDataSourcePath = "/run/peerpod/initdata-source.json"
+	// SandboxIDPath is the sandbox ID that signed userdata is bound to, which process-user-data writes after verifying it
+	SandboxIDPath = "/run/peerpod/sandbox-id"
+	// CloudInitConfigPath passes cloud-config modules of userdata that cloud-init does not process to cloud-init
+	CloudInitConfigPath = "/etc/cloud/cloud.cfg.d/99-peerpod-userdata.cfg"
 )
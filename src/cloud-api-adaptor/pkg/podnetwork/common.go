@@ -9,14 +9,20 @@ import (
 	"math"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/geneve"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/ipsec"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/vxlan"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/wireguard"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
 )
 
 var logger = log.New(log.Writer(), "[podnetwork] ", log.LstdFlags|log.Lmsgprefix)
 
 func init() {
-	tunneler.Register("vxlan", vxlan.NewWorkerNodeTunneler, vxlan.NewPodNodeTunneler)
+	tunneler.Register("vxlan", vxlan.Overhead, vxlan.NewWorkerNodeTunneler, vxlan.NewPodNodeTunneler)
+	tunneler.Register("geneve", geneve.Overhead, geneve.NewWorkerNodeTunneler, geneve.NewPodNodeTunneler)
+	tunneler.Register("ipsec", ipsec.Overhead, ipsec.NewWorkerNodeTunneler, ipsec.NewPodNodeTunneler)
+	tunneler.Register("wireguard", wireguard.Overhead, wireguard.NewWorkerNodeTunneler, wireguard.NewPodNodeTunneler)
 }
 
 // findPrimaryInterface identifies the primary interface on the given network namespace.
@@ -0,0 +1,176 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package podnetwork
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-iptables/iptables"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+)
+
+// Host ports are forwarded to the pod IP on the worker node, and packets to the pod IP are sent to the pod VM through the tunnel.
+// This is what the CNI portmap plugin does for regular pods.
+const iptablesHostPortChainName = "peerpod-HOSTPORTS"
+
+type hostPortRule struct {
+	protocol iptables.Protocol
+	spec     []string
+}
+
+func hostPortRules(config *tunneler.Config) ([]*hostPortRule, error) {
+
+	var rules []*hostPortRule
+
+	for _, hostPort := range config.HostPorts {
+
+		proto := strings.ToLower(hostPort.Protocol)
+		switch proto {
+		case "":
+			proto = "tcp"
+		case "tcp", "udp", "sctp":
+		default:
+			return nil, fmt.Errorf("unsupported protocol of host port %d: %s", hostPort.HostPort, hostPort.Protocol)
+		}
+
+		if hostPort.HostPort <= 0 || hostPort.HostPort > 65535 || hostPort.ContainerPort <= 0 || hostPort.ContainerPort > 65535 {
+			return nil, fmt.Errorf("invalid host port mapping: %d to %d", hostPort.HostPort, hostPort.ContainerPort)
+		}
+
+		for _, podIP := range []netip.Prefix{config.PodIP, config.PodIPv6} {
+			if !podIP.IsValid() {
+				continue
+			}
+
+			protocol := iptables.ProtocolIPv4
+			if podIP.Addr().Is6() {
+				protocol = iptables.ProtocolIPv6
+			}
+
+			spec := []string{
+				"-m", "comment", "--comment", fmt.Sprintf("peerpod [pod:%s]", config.PodIP.Addr()),
+				"-p", proto, "-m", proto, "--dport", strconv.Itoa(hostPort.HostPort),
+			}
+
+			if hostPort.HostIP.IsValid() && !hostPort.HostIP.IsUnspecified() {
+				if hostPort.HostIP.Is6() != podIP.Addr().Is6() {
+					continue
+				}
+				spec = append(spec, "-d", hostPort.HostIP.String())
+			} else {
+				spec = append(spec, "-m", "addrtype", "--dst-type", "LOCAL")
+			}
+
+			dst := netip.AddrPortFrom(podIP.Addr(), uint16(hostPort.ContainerPort))
+			spec = append(spec, "-j", "DNAT", "--to-destination", dst.String())
+
+			rules = append(rules, &hostPortRule{protocol: protocol, spec: spec})
+		}
+	}
+
+	return rules, nil
+}
+
+var hostPortMutex sync.Mutex
+
+func hostPortSetup(ns netops.Namespace, config *tunneler.Config) error {
+
+	hostPortMutex.Lock()
+	defer hostPortMutex.Unlock()
+
+	rules, err := hostPortRules(config)
+	if err != nil {
+		return err
+	}
+
+	return ns.Run(func() error {
+
+		for _, rule := range rules {
+
+			ipt, err := iptables.New(iptables.IPFamily(rule.protocol))
+			if err != nil {
+				return fmt.Errorf("failed to initialize iptables: %w", err)
+			}
+
+			exists, err := ipt.ChainExists("nat", iptablesHostPortChainName)
+			if err != nil {
+				return fmt.Errorf("failed to check the existence of iptables chain %q: %w", iptablesHostPortChainName, err)
+			}
+
+			if !exists {
+				// Add "-N <chain>"
+				if err := ipt.NewChain("nat", iptablesHostPortChainName); err != nil {
+					return fmt.Errorf("failed to create iptables chain %s on table nat: %w", iptablesHostPortChainName, err)
+				}
+				// Add "-A <base> -j <chain>" for packets from other hosts and from the worker node itself
+				for _, base := range []string{"PREROUTING", "OUTPUT"} {
+					if err := ipt.AppendUnique("nat", base, "-j", iptablesHostPortChainName); err != nil {
+						return fmt.Errorf("failed to add iptables rule \"-t nat -A %s -j %s\": %w", base, iptablesHostPortChainName, err)
+					}
+				}
+			}
+
+			if err := ipt.AppendUnique("nat", iptablesHostPortChainName, rule.spec...); err != nil {
+				return fmt.Errorf("failed to add iptables rule \"-t nat -A %s %s\": %w", iptablesHostPortChainName, strings.Join(rule.spec, " "), err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func hostPortTeardown(ns netops.Namespace, config *tunneler.Config) error {
+
+	hostPortMutex.Lock()
+	defer hostPortMutex.Unlock()
+
+	rules, err := hostPortRules(config)
+	if err != nil {
+		return err
+	}
+
+	return ns.Run(func() error {
+
+		for _, rule := range rules {
+
+			ipt, err := iptables.New(iptables.IPFamily(rule.protocol))
+			if err != nil {
+				return fmt.Errorf("failed to initialize iptables: %w", err)
+			}
+
+			if err := ipt.DeleteIfExists("nat", iptablesHostPortChainName, rule.spec...); err != nil {
+				return fmt.Errorf("failed to delete iptables rule \"-t nat -A %s %s\": %w", iptablesHostPortChainName, strings.Join(rule.spec, " "), err)
+			}
+
+			list, err := ipt.List("nat", iptablesHostPortChainName)
+			if err != nil {
+				return fmt.Errorf("failed to list rules in chain %s on table nat: %w", iptablesHostPortChainName, err)
+			}
+
+			if len(list) > 1 {
+				// There are remaining rules other than "-N <chain>"
+				continue
+			}
+
+			for _, base := range []string{"PREROUTING", "OUTPUT"} {
+				// Delete "-A <base> -j <chain>"
+				if err := ipt.DeleteIfExists("nat", base, "-j", iptablesHostPortChainName); err != nil {
+					return fmt.Errorf("failed to delete iptables rule \"-t nat -A %s -j %s\": %w", base, iptablesHostPortChainName, err)
+				}
+			}
+			// Delete "-N <chain>"
+			if err := ipt.DeleteChain("nat", iptablesHostPortChainName); err != nil {
+				return fmt.Errorf("failed to delete iptables chain %s on table nat: %w", iptablesHostPortChainName, err)
+			}
+		}
+
+		return nil
+	})
+}
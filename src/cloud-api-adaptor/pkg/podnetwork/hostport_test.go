@@ -0,0 +1,50 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package podnetwork
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/require"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+)
+
+func TestHostPortRules(t *testing.T) {
+
+	config := &tunneler.Config{
+		PodIP:   netip.MustParsePrefix("172.16.0.2/24"),
+		PodIPv6: netip.MustParsePrefix("fd00::2/64"),
+		HostPorts: []*tunneler.HostPort{
+			{HostPort: 8080, ContainerPort: 80, Protocol: "TCP"},
+			{HostIP: netip.MustParseAddr("192.168.0.2"), HostPort: 5353, ContainerPort: 53, Protocol: "UDP"},
+		},
+	}
+
+	rules, err := hostPortRules(config)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(rules))
+
+	require.Equal(t, iptables.ProtocolIPv4, rules[0].protocol)
+	require.Equal(t, "-m comment --comment peerpod [pod:172.16.0.2] -p tcp -m tcp --dport 8080 -m addrtype --dst-type LOCAL -j DNAT --to-destination 172.16.0.2:80", strings.Join(rules[0].spec, " "))
+
+	require.Equal(t, iptables.ProtocolIPv6, rules[1].protocol)
+	require.Equal(t, "-m comment --comment peerpod [pod:172.16.0.2] -p tcp -m tcp --dport 8080 -m addrtype --dst-type LOCAL -j DNAT --to-destination [fd00::2]:80", strings.Join(rules[1].spec, " "))
+
+	// A host port bound to an IPv4 address is not forwarded to the IPv6 address of the pod
+	require.Equal(t, iptables.ProtocolIPv4, rules[2].protocol)
+	require.Equal(t, "-m comment --comment peerpod [pod:172.16.0.2] -p udp -m udp --dport 5353 -d 192.168.0.2 -j DNAT --to-destination 172.16.0.2:53", strings.Join(rules[2].spec, " "))
+
+	for _, hostPort := range []*tunneler.HostPort{
+		{HostPort: 8080, ContainerPort: 80, Protocol: "ICMP"},
+		{HostPort: 0, ContainerPort: 80},
+		{HostPort: 8080, ContainerPort: 65536},
+	} {
+		_, err := hostPortRules(&tunneler.Config{PodIP: config.PodIP, HostPorts: []*tunneler.HostPort{hostPort}})
+		require.NotNil(t, err, "%#v", hostPort)
+	}
+}
@@ -0,0 +1,170 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package podnetwork
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-iptables/iptables"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+)
+
+// Traffic from a pod VM leaves the pod network namespace on the worker node with the pod IP as its source.
+// When the masquerade policy is selected, the source IP is rewritten to an IP of the worker node.
+// Replies are translated back to the pod IP by conntrack, and routed to the pod network namespace by the routes of the CNI plugin.
+// Traffic to the cluster CIDRs and to the subnet of the pod keeps the pod IP, so that NetworkPolicies apply to it.
+const iptablesPostRoutingChainName = "peerpod-POSTROUTING"
+
+type masqueradeRule struct {
+	protocol iptables.Protocol
+	spec     []string
+}
+
+// masqueradeRules returns the rules that exclude the cluster CIDRs from masquerading, followed by the masquerade rule, for each pod IP
+func masqueradeRules(config *tunneler.Config, clusterCIDRs []netip.Prefix) []*masqueradeRule {
+
+	var rules []*masqueradeRule
+
+	for _, podIP := range []netip.Prefix{config.PodIP, config.PodIPv6} {
+		if !podIP.IsValid() {
+			continue
+		}
+
+		protocol := iptables.ProtocolIPv4
+		if podIP.Addr().Is6() {
+			protocol = iptables.ProtocolIPv6
+		}
+
+		comment := []string{"-m", "comment", "--comment", fmt.Sprintf("peerpod [pod:%s]", config.PodIP.Addr())}
+
+		excluded := []netip.Prefix{podIP.Masked()}
+		for _, cidr := range clusterCIDRs {
+			if cidr.Addr().Is6() == podIP.Addr().Is6() && cidr.Masked() != podIP.Masked() {
+				excluded = append(excluded, cidr.Masked())
+			}
+		}
+		for _, cidr := range excluded {
+			spec := append(append([]string{}, comment...), "-s", podIP.Addr().String(), "-d", cidr.String(), "-j", "RETURN")
+			rules = append(rules, &masqueradeRule{protocol: protocol, spec: spec})
+		}
+
+		spec := append(append([]string{}, comment...), "-s", podIP.Addr().String(), "-j", "MASQUERADE")
+		rules = append(rules, &masqueradeRule{protocol: protocol, spec: spec})
+	}
+
+	return rules
+}
+
+// ParseCIDRs parses a comma separated list of CIDRs, such as the cluster CIDRs
+func ParseCIDRs(list string) ([]netip.Prefix, error) {
+	var cidrs []netip.Prefix
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		cidrs = append(cidrs, prefix.Masked())
+	}
+	return cidrs, nil
+}
+
+var masqueradeMutex sync.Mutex
+
+func masqueradeSetup(ns netops.Namespace, config *tunneler.Config, clusterCIDRs []netip.Prefix) error {
+
+	if !config.Masquerade {
+		return nil
+	}
+
+	masqueradeMutex.Lock()
+	defer masqueradeMutex.Unlock()
+
+	return ns.Run(func() error {
+
+		for _, rule := range masqueradeRules(config, clusterCIDRs) {
+
+			ipt, err := iptables.New(iptables.IPFamily(rule.protocol))
+			if err != nil {
+				return fmt.Errorf("failed to initialize iptables: %w", err)
+			}
+
+			exists, err := ipt.ChainExists("nat", iptablesPostRoutingChainName)
+			if err != nil {
+				return fmt.Errorf("failed to check the existence of iptables chain %q: %w", iptablesPostRoutingChainName, err)
+			}
+
+			if !exists {
+				// Add "-N <chain>"
+				if err := ipt.NewChain("nat", iptablesPostRoutingChainName); err != nil {
+					return fmt.Errorf("failed to create iptables chain %s on table nat: %w", iptablesPostRoutingChainName, err)
+				}
+				// Add "-A <base> -j <chain>"
+				if err := ipt.AppendUnique("nat", "POSTROUTING", "-j", iptablesPostRoutingChainName); err != nil {
+					return fmt.Errorf("failed to add iptables rule \"-t nat -A POSTROUTING -j %s\": %w", iptablesPostRoutingChainName, err)
+				}
+			}
+
+			if err := ipt.AppendUnique("nat", iptablesPostRoutingChainName, rule.spec...); err != nil {
+				return fmt.Errorf("failed to add iptables rule \"-t nat -A %s %s\": %w", iptablesPostRoutingChainName, strings.Join(rule.spec, " "), err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func masqueradeTeardown(ns netops.Namespace, config *tunneler.Config, clusterCIDRs []netip.Prefix) error {
+
+	if !config.Masquerade {
+		return nil
+	}
+
+	masqueradeMutex.Lock()
+	defer masqueradeMutex.Unlock()
+
+	return ns.Run(func() error {
+
+		for _, rule := range masqueradeRules(config, clusterCIDRs) {
+
+			ipt, err := iptables.New(iptables.IPFamily(rule.protocol))
+			if err != nil {
+				return fmt.Errorf("failed to initialize iptables: %w", err)
+			}
+
+			if err := ipt.DeleteIfExists("nat", iptablesPostRoutingChainName, rule.spec...); err != nil {
+				return fmt.Errorf("failed to delete iptables rule \"-t nat -A %s %s\": %w", iptablesPostRoutingChainName, strings.Join(rule.spec, " "), err)
+			}
+
+			list, err := ipt.List("nat", iptablesPostRoutingChainName)
+			if err != nil {
+				return fmt.Errorf("failed to list rules in chain %s on table nat: %w", iptablesPostRoutingChainName, err)
+			}
+
+			if len(list) > 1 {
+				// There are remaining rules other than "-N <chain>"
+				continue
+			}
+
+			// Delete "-A <base> -j <chain>"
+			if err := ipt.DeleteIfExists("nat", "POSTROUTING", "-j", iptablesPostRoutingChainName); err != nil {
+				return fmt.Errorf("failed to delete iptables rule \"-t nat -A POSTROUTING -j %s\": %w", iptablesPostRoutingChainName, err)
+			}
+			// Delete "-N <chain>"
+			if err := ipt.DeleteChain("nat", iptablesPostRoutingChainName); err != nil {
+				return fmt.Errorf("failed to delete iptables chain %s on table nat: %w", iptablesPostRoutingChainName, err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package podnetwork
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/require"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+)
+
+func TestMasqueradeRules(t *testing.T) {
+
+	rules := masqueradeRules(&tunneler.Config{
+		PodIP:   netip.MustParsePrefix("172.16.0.2/24"),
+		PodIPv6: netip.MustParsePrefix("fd00::2/64"),
+	}, []netip.Prefix{
+		netip.MustParsePrefix("172.16.0.0/16"),
+		netip.MustParsePrefix("10.96.0.0/12"),
+		netip.MustParsePrefix("fd00::/64"),
+		netip.MustParsePrefix("fd01::/108"),
+	})
+	require.Equal(t, 7, len(rules))
+
+	var specs []string
+	for i, rule := range rules {
+		expected := iptables.ProtocolIPv4
+		if i >= 4 {
+			expected = iptables.ProtocolIPv6
+		}
+		require.Equal(t, expected, rule.protocol)
+		specs = append(specs, strings.Join(rule.spec, " "))
+	}
+
+	// Traffic to the subnet of the pod and to the cluster CIDRs keeps the pod IP
+	require.Equal(t, []string{
+		"-m comment --comment peerpod [pod:172.16.0.2] -s 172.16.0.2 -d 172.16.0.0/24 -j RETURN",
+		"-m comment --comment peerpod [pod:172.16.0.2] -s 172.16.0.2 -d 172.16.0.0/16 -j RETURN",
+		"-m comment --comment peerpod [pod:172.16.0.2] -s 172.16.0.2 -d 10.96.0.0/12 -j RETURN",
+		"-m comment --comment peerpod [pod:172.16.0.2] -s 172.16.0.2 -j MASQUERADE",
+		"-m comment --comment peerpod [pod:172.16.0.2] -s fd00::2 -d fd00::/64 -j RETURN",
+		"-m comment --comment peerpod [pod:172.16.0.2] -s fd00::2 -d fd01::/108 -j RETURN",
+		"-m comment --comment peerpod [pod:172.16.0.2] -s fd00::2 -j MASQUERADE",
+	}, specs)
+}
+
+func TestSourceIPPolicy(t *testing.T) {
+
+	for policy, valid := range map[string]bool{
+		"":                       true,
+		SourceIPPolicyPreserve:   true,
+		SourceIPPolicyMasquerade: true,
+		"snat":                   false,
+	} {
+		_, err := NewWorkerNode(&tunneler.NetworkConfig{TunnelType: DefaultTunnelType, SourceIPPolicy: policy, ClusterCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+		require.Equal(t, valid, err == nil, "policy=%q: %v", policy, err)
+	}
+
+	_, err := NewWorkerNode(&tunneler.NetworkConfig{TunnelType: DefaultTunnelType, SourceIPPolicy: SourceIPPolicyMasquerade})
+	require.Error(t, err, "masquerading without cluster CIDRs")
+}
@@ -0,0 +1,58 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package podnetwork
+
+import (
+	"time"
+)
+
+// DefaultCheckInterval is the default interval of checking the tunnels of a pod
+const DefaultCheckInterval = 30 * time.Second
+
+// StartMonitor calls check at every interval, and calls repair when check fails, e.g. because an interface of
+// a tunnel disappeared after a restart of the network service. notify is called with the results of each repair.
+// The returned function stops the monitor, and waits for a running repair to finish.
+func StartMonitor(interval time.Duration, check, repair func() error, notify func(checkErr, repairErr error)) (stop func()) {
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+
+	go func() {
+		defer close(doneCh)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+
+			checkErr := check()
+			if checkErr == nil {
+				continue
+			}
+
+			logger.Printf("pod network tunnel check failed: %v (repairing...)", checkErr)
+
+			repairErr := repair()
+			if repairErr != nil {
+				logger.Printf("failed to repair pod network tunnel: %v", repairErr)
+			} else {
+				logger.Printf("pod network tunnel is repaired")
+			}
+
+			if notify != nil {
+				notify(checkErr, repairErr)
+			}
+		}
+	}()
+
+	return func() {
+		close(stopCh)
+		<-doneCh
+	}
+}
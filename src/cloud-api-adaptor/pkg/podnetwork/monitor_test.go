@@ -0,0 +1,63 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package podnetwork
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitor(t *testing.T) {
+
+	var mutex sync.Mutex
+	broken := true
+	var repairs, notifications int
+
+	check := func() error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if broken {
+			return errors.New("vxlan1 is missing")
+		}
+		return nil
+	}
+
+	repair := func() error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		repairs++
+		broken = false
+		return nil
+	}
+
+	notify := func(checkErr, repairErr error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		require.NotNil(t, checkErr)
+		require.Nil(t, repairErr)
+		notifications++
+	}
+
+	stop := StartMonitor(10*time.Millisecond, check, repair, notify)
+
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return !broken
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A healthy tunnel is not repaired again
+	time.Sleep(50 * time.Millisecond)
+
+	stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Equal(t, 1, repairs)
+	require.Equal(t, 1, notifications)
+}
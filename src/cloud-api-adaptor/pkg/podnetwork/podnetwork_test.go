@@ -48,11 +48,69 @@ func (t *mockPodNodeTunneler) Teardown(nsPath, hostInterface string, config *tun
 	return nil
 }
 
+func TestPodIndex(t *testing.T) {
+	p := podIndex{inUse: make(map[int]bool)}
+
+	require.Equal(t, 0, p.Get())
+	require.Equal(t, 1, p.Get())
+	require.Equal(t, 2, p.Get())
+
+	// Released indexes are reused, smallest first
+	p.Release(1)
+	p.Release(0)
+	require.Equal(t, 0, p.Get())
+	require.Equal(t, 1, p.Get())
+	require.Equal(t, 3, p.Get())
+}
+
+type releasingTunneler struct {
+	mockWorkerNodeTunneler
+	released []int
+}
+
+func (t *releasingTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
+	return fmt.Errorf("teardown failure")
+}
+
+func (t *releasingTunneler) Release(config *tunneler.Config) {
+	t.released = append(t.released, config.Index)
+}
+
+func TestWorkerNodeRelease(t *testing.T) {
+
+	tun := &releasingTunneler{}
+	n := &workerNode{
+		NetworkConfig: &tunneler.NetworkConfig{HostInterface: "eth0"},
+		tunneler:      tun,
+	}
+
+	newConfig := func() *tunneler.Config {
+		return &tunneler.Config{
+			Index:             podIndexManager.Get(),
+			SecondaryNetworks: []*tunneler.Config{{Index: podIndexManager.Get()}},
+		}
+	}
+
+	config := newConfig()
+	n.Release(config)
+	require.Equal(t, []int{config.Index, config.SecondaryNetworks[0].Index}, tun.released)
+	require.False(t, podIndexManager.inUse[config.Index])
+	require.False(t, podIndexManager.inUse[config.SecondaryNetworks[0].Index])
+
+	// Resources are released even if a teardown fails
+	tun.released = nil
+	config = newConfig()
+	require.Error(t, n.Teardown("", config))
+	require.Equal(t, []int{config.Index, config.SecondaryNetworks[0].Index}, tun.released)
+	require.False(t, podIndexManager.inUse[config.Index])
+	require.False(t, podIndexManager.inUse[config.SecondaryNetworks[0].Index])
+}
+
 func TestWorkerNode(t *testing.T) {
 	testutils.SkipTestIfNotRoot(t)
 
 	mockTunnelType := "mock"
-	tunneler.Register(mockTunnelType, newMockWorkerNodeTunneler, newMockPodNodeTunneler)
+	tunneler.Register(mockTunnelType, 0, newMockWorkerNodeTunneler, newMockPodNodeTunneler)
 
 	workerNodeNS, _ := tuntest.NewNamedNS(t, "test-workernode")
 	defer tuntest.DeleteNamedNS(t, workerNodeNS)
@@ -121,11 +179,71 @@ func TestWorkerNode(t *testing.T) {
 	}
 }
 
+func TestWorkerNodeSecondaryNetworks(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	mockTunnelType := "mock"
+	tunneler.Register(mockTunnelType, 0, newMockWorkerNodeTunneler, newMockPodNodeTunneler)
+
+	workerNodeNS, _ := tuntest.NewNamedNS(t, "test-workernode")
+	defer tuntest.DeleteNamedNS(t, workerNodeNS)
+
+	tuntest.BridgeAdd(t, workerNodeNS, "ens0")
+	tuntest.AddrAdd(t, workerNodeNS, "ens0", "192.168.0.2/24")
+	tuntest.RouteAdd(t, workerNodeNS, "", "192.168.0.1", "ens0")
+
+	workerPodNS, _ := tuntest.NewNamedNS(t, "test-workerpod")
+	defer tuntest.DeleteNamedNS(t, workerPodNS)
+
+	tuntest.BridgeAdd(t, workerPodNS, "eth0")
+	tuntest.AddrAdd(t, workerPodNS, "eth0", "172.16.0.2/24")
+	tuntest.RouteAdd(t, workerPodNS, "", "172.16.0.1", "eth0")
+	tuntest.BridgeAdd(t, workerPodNS, "net1")
+	tuntest.AddrAdd(t, workerPodNS, "net1", "10.10.0.2/24")
+
+	err := workerNodeNS.Run(func() error {
+
+		workerNode, err := NewWorkerNode(&tunneler.NetworkConfig{TunnelType: mockTunnelType})
+		require.Nil(t, err)
+
+		config, err := workerNode.Inspect(workerPodNS.Path())
+		require.Nil(t, err)
+
+		require.Equal(t, "eth0", config.InterfaceName)
+		require.Equal(t, false, config.Secondary)
+		require.Equal(t, 1, len(config.SecondaryNetworks))
+
+		secondary := config.SecondaryNetworks[0]
+		require.Equal(t, "net1", secondary.InterfaceName)
+		require.Equal(t, "10.10.0.2/24", secondary.PodIP.String())
+		require.Equal(t, true, secondary.Secondary)
+		require.Equal(t, config.WorkerNodeIP, secondary.WorkerNodeIP)
+		require.NotEqual(t, config.Index, secondary.Index)
+
+		var found bool
+		for _, route := range config.Routes {
+			if route.Dst.String() == "10.10.0.0/24" && route.Dev == "net1" {
+				found = true
+			}
+		}
+		require.True(t, found, "route to the secondary network is not found: %v", config.Routes)
+
+		err = workerNode.Setup(workerPodNS.Path(), []netip.Addr{netip.MustParseAddr("192.168.0.3")}, config)
+		require.Nil(t, err)
+
+		err = workerNode.Teardown(workerPodNS.Path(), config)
+		require.Nil(t, err)
+
+		return nil
+	})
+	require.Nil(t, err)
+}
+
 func TestPodNode(t *testing.T) {
 	testutils.SkipTestIfNotRoot(t)
 
 	mockTunnelType := "mock"
-	tunneler.Register(mockTunnelType, newMockWorkerNodeTunneler, newMockPodNodeTunneler)
+	tunneler.Register(mockTunnelType, 0, newMockWorkerNodeTunneler, newMockPodNodeTunneler)
 
 	podNodeNS, _ := tuntest.NewNamedNS(t, "test-podnode")
 	defer tuntest.DeleteNamedNS(t, podNodeNS)
@@ -15,6 +15,8 @@ import (
 type PodNode interface {
 	Setup() error
 	Teardown() error
+	Check() error
+	Repair() error
 }
 
 type podNode struct {
@@ -81,6 +83,28 @@ func (n *podNode) Setup() error {
 		podNodeIPs = append(podNodeIPs, dedicatedPodNodeIP)
 	}
 
+	// The network of the pod VM may have a smaller MTU than the network of the worker node
+	if !n.config.MTUOverride {
+		hostLink, err := hostNS.LinkFind(hostInterface)
+		if err != nil {
+			return fmt.Errorf("failed to find host interface %q on netns %s: %w", hostInterface, hostNS.Path(), err)
+		}
+		hostMTU, err := hostLink.GetMTU()
+		if err != nil {
+			return fmt.Errorf("failed to get MTU size of %s: %w", hostInterface, err)
+		}
+		tunnelMTU, err := tunneler.TunnelMTU(n.config.TunnelType, hostMTU)
+		if err != nil {
+			return err
+		}
+		for _, config := range append([]*tunneler.Config{n.config}, n.config.SecondaryNetworks...) {
+			if tunnelMTU < config.MTU {
+				logger.Printf("MTU of %s is reduced from %d to %d to fit into the MTU %d of %s", config.InterfaceName, config.MTU, tunnelMTU, hostMTU, hostInterface)
+				config.MTU = tunnelMTU
+			}
+		}
+	}
+
 	podNS, err := netops.OpenNamespace(n.nsPath)
 	if err != nil {
 		return fmt.Errorf("failed to open network namespace %q: %w", n.nsPath, err)
@@ -91,24 +115,11 @@ func (n *podNode) Setup() error {
 		}
 	}()
 
-	if err := tun.Setup(n.nsPath, podNodeIPs, n.config); err != nil {
-		return fmt.Errorf("failed to set up tunnel %q: %w", n.config.TunnelType, err)
-	}
-
-	if !n.config.PodIP.IsSingleIP() {
-		// Delete the nRoute that was automatically added by kernel for eth0
-		// CNI plugins like PTP and GKE need this trick, otherwise adding a route will fail in a later step.
-		// The deleted route will be restored again in the cases of usual CNI plugins such as Flannel and Calico.
-		// https://github.com/containernetworking/plugins/blob/acf8ddc8e1128e6f68a34f7fe91122afeb1fa93d/plugins/main/ptp/ptp.go#L58-L61
-
-		nRoute := netops.Route{
-			Destination: n.config.PodIP.Masked(),
-			Device:      n.config.InterfaceName,
-		}
-		if err := podNS.RouteDel(&nRoute); err != nil {
-			return fmt.Errorf("failed to remove route %s dev %s: %v", nRoute.Destination, nRoute.Device, err)
+	// Secondary networks need to be set up before routes are added, since routes may use their interfaces
+	for _, config := range append([]*tunneler.Config{n.config}, n.config.SecondaryNetworks...) {
+		if err := n.setupInterface(tun, podNS, podNodeIPs, config); err != nil {
+			return err
 		}
-		logger.Printf("removed route %s dev %s", nRoute.Destination, nRoute.Device)
 	}
 
 	// We need to process routes without gateway address first. Processing routes with a gateway causes an error if the gateway is not reachable.
@@ -180,6 +191,12 @@ func (n *podNode) Teardown() error {
 		hostInterface = hostPrimaryInterface
 	}
 
+	for _, config := range n.config.SecondaryNetworks {
+		if err := tun.Teardown(n.nsPath, hostInterface, config); err != nil {
+			return fmt.Errorf("failed to tear down tunnel %q of secondary network %s: %w", config.TunnelType, config.InterfaceName, err)
+		}
+	}
+
 	if err := tun.Teardown(n.nsPath, hostInterface, n.config); err != nil {
 		return fmt.Errorf("failed to tear down tunnel %q: %w", n.config.TunnelType, err)
 	}
@@ -187,6 +204,94 @@ func (n *podNode) Teardown() error {
 	return nil
 }
 
+// Check returns an error if an interface of the tunnels of the pod is missing on the pod VM
+func (n *podNode) Check() error {
+
+	tun, err := tunneler.PodNodeTunneler(n.config.TunnelType)
+	if err != nil {
+		return fmt.Errorf("failed to get tunneler: %w", err)
+	}
+
+	checker, ok := tun.(tunneler.TunnelerChecker)
+	if !ok {
+		return nil
+	}
+
+	for _, config := range append([]*tunneler.Config{n.config}, n.config.SecondaryNetworks...) {
+		if err := checker.Check(n.nsPath, config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Repair tears down what remains of the tunnels of the pod, and sets them up again
+func (n *podNode) Repair() error {
+
+	tun, err := tunneler.PodNodeTunneler(n.config.TunnelType)
+	if err != nil {
+		return fmt.Errorf("failed to get tunneler: %w", err)
+	}
+
+	// Some interfaces are already gone, so errors are expected
+	for _, config := range append([]*tunneler.Config{n.config}, n.config.SecondaryNetworks...) {
+		if err := tun.Teardown(n.nsPath, n.hostInterface, config); err != nil {
+			logger.Printf("failed to tear down tunnel %q of %s for repair: %v", config.TunnelType, config.InterfaceName, err)
+		}
+	}
+
+	return n.Setup()
+}
+
+// setupInterface creates a tunnel interface of the pod network, and assigns pod IP addresses to it
+func (n *podNode) setupInterface(tun tunneler.Tunneler, podNS netops.Namespace, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	if err := tun.Setup(n.nsPath, podNodeIPs, config); err != nil {
+		return fmt.Errorf("failed to set up tunnel %q for %s: %w", config.TunnelType, config.InterfaceName, err)
+	}
+
+	if !config.PodIP.IsSingleIP() {
+		// Delete the nRoute that was automatically added by kernel for eth0
+		// CNI plugins like PTP and GKE need this trick, otherwise adding a route will fail in a later step.
+		// The deleted route will be restored again in the cases of usual CNI plugins such as Flannel and Calico.
+		// https://github.com/containernetworking/plugins/blob/acf8ddc8e1128e6f68a34f7fe91122afeb1fa93d/plugins/main/ptp/ptp.go#L58-L61
+
+		nRoute := netops.Route{
+			Destination: config.PodIP.Masked(),
+			Device:      config.InterfaceName,
+		}
+		if err := podNS.RouteDel(&nRoute); err != nil {
+			return fmt.Errorf("failed to remove route %s dev %s: %v", nRoute.Destination, nRoute.Device, err)
+		}
+		logger.Printf("removed route %s dev %s", nRoute.Destination, nRoute.Device)
+	}
+
+	if config.PodIPv6.IsValid() {
+		// The tunnel interface carries both address families of a dual-stack pod
+		podLink, err := podNS.LinkFind(config.InterfaceName)
+		if err != nil {
+			return fmt.Errorf("failed to find pod interface %q on netns %s: %w", config.InterfaceName, podNS.Path(), err)
+		}
+		if err := podLink.AddAddr(config.PodIPv6); err != nil {
+			return fmt.Errorf("failed to add pod IPv6 address %s to %s on %s: %w", config.PodIPv6, config.InterfaceName, podNS.Path(), err)
+		}
+
+		if !config.PodIPv6.IsSingleIP() {
+			nRoute := netops.Route{
+				Destination: config.PodIPv6.Masked(),
+				Device:      config.InterfaceName,
+			}
+			if err := podNS.RouteDel(&nRoute); err != nil {
+				return fmt.Errorf("failed to remove route %s dev %s: %v", nRoute.Destination, nRoute.Device, err)
+			}
+			logger.Printf("removed route %s dev %s", nRoute.Destination, nRoute.Device)
+		}
+	}
+
+	return nil
+}
+
 func detectPrimaryInterface(hostNS netops.Namespace, timeout time.Duration) (string, error) {
 
 	timeoutCh := time.After(timeout)
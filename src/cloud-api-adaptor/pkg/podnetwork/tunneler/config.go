@@ -3,6 +3,8 @@
 
 package tunneler
 
+import "net/netip"
+
 type TunnelerConfigurator interface {
 	Tunneler
 	Configure(*NetworkConfig, *Config) error
@@ -12,9 +14,28 @@ type NetworkConfig struct {
 	TunnelType    string
 	HostInterface string
 	VXLAN         VXLANConfig
+	Geneve        GeneveConfig
+	WireGuard     WireGuardConfig
+	// SourceIPPolicy is either "preserve" or "masquerade"
+	SourceIPPolicy string
+	// ClusterCIDRs are the pod and service networks of the cluster, to which traffic is not masqueraded
+	ClusterCIDRs []netip.Prefix
+	// Datapath is either "tc" or "ebpf"
+	Datapath string
 }
 
 type VXLANConfig struct {
 	Port  int
 	MinID int
 }
+
+type GeneveConfig struct {
+	Port  int
+	MinID int
+}
+
+type WireGuardConfig struct {
+	Port int
+	// Overlay is the IPv4 network of the point-to-point WireGuard links between the worker node and the pod VMs
+	Overlay netip.Prefix
+}
@@ -0,0 +1,203 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package geneve
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+)
+
+const (
+	hostGeneveInterface = "geneve0"
+
+	// Option TLVs carrying pod metadata use a class reserved for experimental use by RFC 8926.
+	// The critical bit of the option types is clear, so receivers that do not know them ignore them.
+	geneveOptionClass     = 0xfff0
+	geneveOptionPodIP     = 0x01
+	geneveOptionPodHwAddr = 0x02
+
+	// Overhead is the size of the outer IPv4, UDP and Geneve headers, the inner Ethernet header, and the option TLVs
+	Overhead = 50 + 16
+)
+
+type podNodeTunneler struct {
+}
+
+func NewPodNodeTunneler() (tunneler.Tunneler, error) {
+	return &podNodeTunneler{}, nil
+}
+
+func (t *podNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	podGeneveInterface := config.InterfaceName
+	if podGeneveInterface == "" {
+		return errors.New("InterfaceName is not specified")
+	}
+
+	nodeAddr := config.WorkerNodeIP
+	if !nodeAddr.IsValid() {
+		return fmt.Errorf("WorkerNodeIP is not specified: %#v", config.WorkerNodeIP)
+	}
+
+	podAddr := config.PodIP
+	if !podAddr.IsValid() {
+		return fmt.Errorf("PodIP is not specified: %#v", config.PodIP)
+	}
+
+	if len(podNodeIPs) == 0 {
+		return fmt.Errorf("pod node has no IPs")
+	}
+	srcAddr := podNodeIPs[len(podNodeIPs)-1]
+
+	options, err := geneveOptions(config)
+	if err != nil {
+		return err
+	}
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get host network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a pod network namespace: %s: %w", nsPath, err)
+	}
+	defer podNS.Close()
+
+	// An external interface takes the tunnel parameters, including the option TLVs, from a tc action
+	geneveDevice := &netops.Geneve{
+		Port:     config.GenevePort,
+		External: true,
+	}
+	geneve, err := hostNS.LinkAdd(hostGeneveInterface, geneveDevice)
+	if err != nil {
+		return fmt.Errorf("failed to add geneve interface %s: %w", hostGeneveInterface, err)
+	}
+
+	if err := geneve.SetNamespace(podNS); err != nil {
+		return fmt.Errorf("failed to move geneve interface %s to netns %s: %w", hostGeneveInterface, podNS.Path(), err)
+	}
+
+	if err := geneve.SetName(podGeneveInterface); err != nil {
+		return fmt.Errorf("failed to rename geneve interface %s on netns %s: %w", hostGeneveInterface, podNS.Path(), err)
+	}
+
+	if err := geneve.SetHardwareAddr(config.PodHwAddr); err != nil {
+		return fmt.Errorf("failed to set pod HW address %s on %s: %w", config.PodHwAddr, podGeneveInterface, err)
+	}
+
+	mtu := int(config.MTU)
+	if err := geneve.SetMTU(mtu); err != nil {
+		return fmt.Errorf("failed to set MTU of %s to %d on %s: %w", podGeneveInterface, mtu, nsPath, err)
+	}
+
+	if err := geneve.AddAddr(podAddr); err != nil {
+		return fmt.Errorf("failed to add pod IP %s to %s on %s: %w", podAddr, podGeneveInterface, nsPath, err)
+	}
+
+	if err := podNS.Run(func() error {
+		if err := tc("qdisc", "add", "dev", podGeneveInterface, "clsact"); err != nil {
+			return err
+		}
+		return tc("filter", "add", "dev", podGeneveInterface, "egress", "matchall",
+			"action", "tunnel_key", "set",
+			"src_ip", srcAddr.String(),
+			"dst_ip", nodeAddr.Addr().String(),
+			"id", strconv.Itoa(config.GeneveID),
+			"dst_port", strconv.Itoa(config.GenevePort),
+			"geneve_opts", options,
+			"action", "pipe")
+	}); err != nil {
+		return fmt.Errorf("failed to add a tunnel_key action to %s on %s: %w", podGeneveInterface, nsPath, err)
+	}
+
+	if err := geneve.SetUp(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *podNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
+
+	ifName := config.InterfaceName
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a pod network namespace: %s: %w", nsPath, err)
+	}
+	defer podNS.Close()
+
+	geneve, err := podNS.LinkFind(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to find geneve interface %q on netns %s: %w", ifName, podNS.Path(), err)
+	}
+
+	if err := geneve.Delete(); err != nil {
+		return fmt.Errorf("failed to delete geneve interface %s at %s: %w", ifName, podNS.Path(), err)
+	}
+
+	return nil
+}
+
+func (t *podNodeTunneler) Check(nsPath string, config *tunneler.Config) error {
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a pod network namespace: %s: %w", nsPath, err)
+	}
+	defer podNS.Close()
+
+	return tunneler.CheckLink(podNS, config.InterfaceName)
+}
+
+// geneveOptions returns the option TLVs carrying the pod metadata in the format of tc tunnel_key geneve_opts
+func geneveOptions(config *tunneler.Config) (string, error) {
+
+	if !config.PodIP.Addr().Is4() {
+		return "", fmt.Errorf("pod IP is not an IPv4 address: %s", config.PodIP)
+	}
+	podIP := config.PodIP.Addr().As4()
+
+	hwAddr, err := net.ParseMAC(config.PodHwAddr)
+	if err != nil {
+		return "", fmt.Errorf("invalid pod HW address %q: %w", config.PodHwAddr, err)
+	}
+
+	var options []string
+	for _, option := range []struct {
+		optionType int
+		data       []byte
+	}{
+		{geneveOptionPodIP, podIP[:]},
+		{geneveOptionPodHwAddr, hwAddr},
+	} {
+		// Option data is a multiple of 4 bytes
+		data := option.data
+		if pad := len(data) % 4; pad != 0 {
+			data = append(data, make([]byte, 4-pad)...)
+		}
+		options = append(options, fmt.Sprintf("%04x:%02x:%s", geneveOptionClass, option.optionType, hex.EncodeToString(data)))
+	}
+
+	return strings.Join(options, ","), nil
+}
+
+func tc(args ...string) error {
+	if out, err := exec.Command("tc", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run tc %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
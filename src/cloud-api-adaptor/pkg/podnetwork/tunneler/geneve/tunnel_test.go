@@ -0,0 +1,39 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package geneve
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tuntest"
+)
+
+func TestGeneve(t *testing.T) {
+
+	tuntest.RunTunnelTest(t, "geneve", NewWorkerNodeTunneler, NewPodNodeTunneler, false)
+
+}
+
+func TestGeneveOptions(t *testing.T) {
+
+	config := &tunneler.Config{
+		PodIP:     netip.MustParsePrefix("10.128.0.2/24"),
+		PodHwAddr: "0a:58:0a:84:03:ce",
+	}
+
+	options, err := geneveOptions(config)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := "fff0:01:0a800002,fff0:02:0a580a8403ce0000", options; e != a {
+		t.Errorf("Expect %q, got %q", e, a)
+	}
+
+	config.PodHwAddr = "invalid"
+	if _, err := geneveOptions(config); err == nil {
+		t.Error("Expect error, got nil")
+	}
+}
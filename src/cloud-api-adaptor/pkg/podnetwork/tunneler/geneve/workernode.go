@@ -0,0 +1,201 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package geneve
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+)
+
+var logger = log.New(log.Writer(), "[tunneler/geneve] ", log.LstdFlags|log.Lmsgprefix)
+
+const (
+	DefaultGenevePort         = 6081
+	DefaultGeneveMinID        = 555000
+	hostGeneveInterfacePrefix = "ppgeneve"
+	secondPodInterface        = "geneve1"
+)
+
+type workerNodeTunneler struct {
+}
+
+func NewWorkerNodeTunneler() (tunneler.Tunneler, error) {
+	return &workerNodeTunneler{}, nil
+}
+
+func (t *workerNodeTunneler) Configure(n *tunneler.NetworkConfig, config *tunneler.Config) error {
+
+	// The pod VM end of a geneve tunnel is an external interface, and only one of them can be bound to the port
+	if config.Secondary {
+		return fmt.Errorf("secondary networks are not supported by tunnel type %q", config.TunnelType)
+	}
+
+	config.GenevePort = n.Geneve.Port
+	config.GeneveID = n.Geneve.MinID + config.Index
+
+	return nil
+}
+
+func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	var dstAddr netip.Addr
+
+	numIPs := len(podNodeIPs)
+	if numIPs == 0 {
+		return fmt.Errorf("pod node has no IPs")
+	}
+
+	if config.Dedicated {
+		if numIPs < 2 {
+			return fmt.Errorf("dedicated tunnel missing destination address")
+		}
+		dstAddr = podNodeIPs[1]
+	} else {
+		dstAddr = podNodeIPs[0]
+	}
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a network namespace: %s: %w", nsPath, err)
+	}
+	defer podNS.Close()
+
+	links, err := hostNS.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to get interfaces on host: %w", err)
+	}
+
+	var hostGeneveInterface string
+	var hostGeneveLink netops.Link
+
+	for index := 1; ; index++ {
+		if index > 5 {
+			return fmt.Errorf("failed to create geneve interface %s: too many", hostGeneveInterface)
+		}
+
+		hostGeneveInterface = fmt.Sprintf("%s%d", hostGeneveInterfacePrefix, index)
+		var found bool
+		for _, link := range links {
+			if link.Name() == hostGeneveInterface {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		geneveDevice := &netops.Geneve{
+			Remote: dstAddr,
+			ID:     config.GeneveID,
+			Port:   config.GenevePort,
+		}
+		hostGeneveLink, err = hostNS.LinkAdd(hostGeneveInterface, geneveDevice)
+		if err == nil {
+			logger.Printf("geneve %s (remote %s:%d, id: %d) created at %s", hostGeneveInterface, dstAddr, config.GenevePort, config.GeneveID, hostNS.Path())
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to add geneve interface %s: %w", hostGeneveInterface, err)
+		}
+	}
+
+	if err := hostGeneveLink.SetNamespace(podNS); err != nil {
+		return fmt.Errorf("failed to move geneve interface %s to netns %s: %w", hostGeneveInterface, podNS.Path(), err)
+	}
+
+	podGeneveInterface, err := podNS.LinkFind(hostGeneveInterface)
+	if err != nil {
+		return fmt.Errorf("failed to find geneve interface %q on pod netns %s: %w", hostGeneveInterface, podNS.Path(), err)
+	}
+
+	if err := podGeneveInterface.SetName(secondPodInterface); err != nil {
+		return fmt.Errorf("failed to change geneve interface name %s on netns %s to %s: %w", hostGeneveInterface, podNS.Path(), secondPodInterface, err)
+	}
+
+	if err := podGeneveInterface.SetUp(); err != nil {
+		return err
+	}
+
+	podInterface := config.InterfaceName
+
+	logger.Printf("Add tc redirect filters between %s and %s on pod network namespace %s", podInterface, secondPodInterface, nsPath)
+
+	if err := tunneler.RedirectAdd(podNS, config.Datapath, podInterface, secondPodInterface); err != nil {
+		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", podInterface, secondPodInterface, err)
+	}
+
+	if err := tunneler.RedirectAdd(podNS, config.Datapath, secondPodInterface, podInterface); err != nil {
+		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", secondPodInterface, podInterface, err)
+	}
+
+	if err := tunneler.ShapingSetup(podNS, podInterface, secondPodInterface, config); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a network namespace: %s: %w", nsPath, err)
+	}
+	defer podNS.Close()
+
+	logger.Printf("Delete tc redirect filters on %s and %s in the network namespace %s", config.InterfaceName, secondPodInterface, nsPath)
+
+	if err := podNS.RedirectDel(config.InterfaceName); err != nil {
+		return fmt.Errorf("failed to delete a tc redirect filter from %s to %s: %w", config.InterfaceName, secondPodInterface, err)
+	}
+
+	if err := podNS.RedirectDel(secondPodInterface); err != nil {
+		return fmt.Errorf("failed to delete a tc redirect filter from %s to %s: %w", secondPodInterface, config.InterfaceName, err)
+	}
+
+	if err := tunneler.ShapingTeardown(podNS, config.InterfaceName, config); err != nil {
+		return err
+	}
+
+	logger.Printf("Delete geneve interface %s in the network namespace %s", secondPodInterface, nsPath)
+
+	podGeneveInterface, err := podNS.LinkFind(secondPodInterface)
+	if err != nil {
+		return fmt.Errorf("failed to find geneve interface %q on pod netns %s: %w", secondPodInterface, podNS.Path(), err)
+	}
+
+	if err := podGeneveInterface.Delete(); err != nil {
+		return fmt.Errorf("failed to delete geneve interface %s at %s: %w", secondPodInterface, podNS.Path(), err)
+	}
+
+	return nil
+}
+
+func (t *workerNodeTunneler) Check(nsPath string, config *tunneler.Config) error {
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a network namespace: %s: %w", nsPath, err)
+	}
+	defer podNS.Close()
+
+	if err := tunneler.CheckLink(podNS, secondPodInterface); err != nil {
+		return err
+	}
+
+	return tunneler.ProbeLink(podNS, secondPodInterface, config.PodIP.Addr())
+}
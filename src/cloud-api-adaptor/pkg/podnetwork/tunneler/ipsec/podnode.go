@@ -0,0 +1,75 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package ipsec
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/vxlan"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+)
+
+// Overhead is the size of the VXLAN encapsulation and the ESP headers and trailers in transport mode
+const Overhead = vxlan.Overhead + 73
+
+type podNodeTunneler struct {
+	vxlan tunneler.Tunneler
+}
+
+func NewPodNodeTunneler() (tunneler.Tunneler, error) {
+	t, err := vxlan.NewPodNodeTunneler()
+	if err != nil {
+		return nil, err
+	}
+	return &podNodeTunneler{vxlan: t}, nil
+}
+
+func (t *podNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	if len(podNodeIPs) == 0 {
+		return fmt.Errorf("pod node has no IPs")
+	}
+	srcAddr := podNodeIPs[len(podNodeIPs)-1]
+
+	dstAddr := config.WorkerNodeIP.Addr()
+	if !dstAddr.IsValid() {
+		return fmt.Errorf("WorkerNodeIP is not specified: %#v", config.WorkerNodeIP)
+	}
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get host network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	out := &securityAssociation{src: srcAddr, dst: dstAddr, spi: config.IPsecPodNodeSPI, key: config.IPsecPodNodeKey}
+	in := &securityAssociation{src: dstAddr, dst: srcAddr, spi: config.IPsecWorkerNodeSPI, key: config.IPsecWorkerNodeKey}
+
+	if err := setupSecurityAssociations(hostNS, out, in, config.VXLANPort); err != nil {
+		return err
+	}
+
+	return t.vxlan.Setup(nsPath, podNodeIPs, config)
+}
+
+func (t *podNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
+
+	vxlanErr := t.vxlan.Teardown(nsPath, hostInterface, config)
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get host network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	// Security associations are deleted even if the VXLAN interface is already gone, so that the tunnel can be set up again
+	return errors.Join(vxlanErr, teardownSecurityAssociations(hostNS, config.IPsecPodNodeSPI, config.IPsecWorkerNodeSPI))
+}
+
+func (t *podNodeTunneler) Check(nsPath string, config *tunneler.Config) error {
+	return t.vxlan.(tunneler.TunnelerChecker).Check(nsPath, config)
+}
@@ -0,0 +1,45 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package ipsec
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tuntest"
+)
+
+func TestIPsec(t *testing.T) {
+
+	tuntest.RunTunnelTest(t, "ipsec", NewWorkerNodeTunneler, NewPodNodeTunneler, false)
+
+}
+
+func TestNewSecurityAssociation(t *testing.T) {
+
+	spi1, key1, err := newSecurityAssociation()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	spi2, key2, err := newSecurityAssociation()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	for _, spi := range []int{spi1, spi2} {
+		if spi < minSPI || spi >= 1<<31 {
+			t.Errorf("Expect an SPI in the range [%d, %d), got %d", minSPI, 1<<31, spi)
+		}
+	}
+
+	for _, key := range []string{key1, key2} {
+		if decoded, err := hex.DecodeString(key); err != nil || len(decoded) != keyLen {
+			t.Errorf("Expect a %d byte hex encoded key, got %q", keyLen, key)
+		}
+	}
+
+	if key1 == key2 {
+		t.Error("Expect different keys")
+	}
+}
@@ -0,0 +1,183 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package ipsec
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/vxlan"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+)
+
+var logger = log.New(log.Writer(), "[tunneler/ipsec] ", log.LstdFlags|log.Lmsgprefix)
+
+const (
+	// AES-256 key followed by a 4 byte salt
+	keyLen = 36
+	// SPIs up to 255 are reserved by RFC 4303
+	minSPI = 256
+)
+
+// The VXLAN traffic between the worker node and a pod VM is protected by ESP in transport mode.
+// Each direction has its own security association, whose keys are delivered to the pod VM
+// with the daemon config. cloud-api-adaptor requires sealed userdata with this tunnel type,
+// so that Trustee releases the daemon config, and the keys, only to an attested pod VM.
+type workerNodeTunneler struct {
+	vxlan tunneler.TunnelerConfigurator
+}
+
+func NewWorkerNodeTunneler() (tunneler.Tunneler, error) {
+	t, err := vxlan.NewWorkerNodeTunneler()
+	if err != nil {
+		return nil, err
+	}
+	return &workerNodeTunneler{vxlan: t.(tunneler.TunnelerConfigurator)}, nil
+}
+
+func (t *workerNodeTunneler) Configure(n *tunneler.NetworkConfig, config *tunneler.Config) error {
+
+	// Security policies are selected by the tunnel endpoints, which are shared by all networks of a pod
+	if config.Secondary {
+		return fmt.Errorf("secondary networks are not supported by tunnel type %q", config.TunnelType)
+	}
+
+	if err := t.vxlan.Configure(n, config); err != nil {
+		return err
+	}
+
+	var err error
+	if config.IPsecWorkerNodeSPI, config.IPsecWorkerNodeKey, err = newSecurityAssociation(); err != nil {
+		return err
+	}
+	if config.IPsecPodNodeSPI, config.IPsecPodNodeKey, err = newSecurityAssociation(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	var dstAddr netip.Addr
+
+	numIPs := len(podNodeIPs)
+	if numIPs == 0 {
+		return fmt.Errorf("pod node has no IPs")
+	}
+
+	if config.Dedicated {
+		if numIPs < 2 {
+			return fmt.Errorf("dedicated tunnel missing destination address")
+		}
+		dstAddr = podNodeIPs[1]
+	} else {
+		dstAddr = podNodeIPs[0]
+	}
+
+	srcAddr := config.WorkerNodeIP.Addr()
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	out := &securityAssociation{src: srcAddr, dst: dstAddr, spi: config.IPsecWorkerNodeSPI, key: config.IPsecWorkerNodeKey}
+	in := &securityAssociation{src: dstAddr, dst: srcAddr, spi: config.IPsecPodNodeSPI, key: config.IPsecPodNodeKey}
+
+	if err := setupSecurityAssociations(hostNS, out, in, config.VXLANPort); err != nil {
+		return err
+	}
+	logger.Printf("ESP security associations between %s and %s (spi 0x%x, 0x%x) created at %s", srcAddr, dstAddr, out.spi, in.spi, hostNS.Path())
+
+	return t.vxlan.Setup(nsPath, podNodeIPs, config)
+}
+
+func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
+
+	vxlanErr := t.vxlan.Teardown(nsPath, hostInterface, config)
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	// Security associations are deleted even if the VXLAN interface is already gone, so that the tunnel can be set up again
+	return errors.Join(vxlanErr, teardownSecurityAssociations(hostNS, config.IPsecWorkerNodeSPI, config.IPsecPodNodeSPI))
+}
+
+func (t *workerNodeTunneler) Release(config *tunneler.Config) {
+	t.vxlan.(tunneler.TunnelerReleaser).Release(config)
+}
+
+func (t *workerNodeTunneler) Check(nsPath string, config *tunneler.Config) error {
+	return t.vxlan.(tunneler.TunnelerChecker).Check(nsPath, config)
+}
+
+type securityAssociation struct {
+	src netip.Addr
+	dst netip.Addr
+	spi int
+	key string
+}
+
+func newSecurityAssociation() (spi int, key string, err error) {
+
+	var b [4 + keyLen]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, "", fmt.Errorf("failed to generate an IPsec key: %w", err)
+	}
+
+	spi = minSPI + int(binary.BigEndian.Uint32(b[:4])%(1<<31-minSPI))
+
+	return spi, hex.EncodeToString(b[4:]), nil
+}
+
+// setupSecurityAssociations protects VXLAN traffic sent with out and received with in
+func setupSecurityAssociations(ns netops.Namespace, out, in *securityAssociation, port int) error {
+
+	for _, sa := range []*securityAssociation{out, in} {
+		key, err := hex.DecodeString(sa.key)
+		if err != nil || len(key) != keyLen {
+			return fmt.Errorf("invalid IPsec key of spi 0x%x", sa.spi)
+		}
+
+		if err := ns.XfrmStateAdd(&netops.XfrmState{Src: sa.src, Dst: sa.dst, SPI: sa.spi, Key: key}); err != nil {
+			return err
+		}
+	}
+
+	for _, policy := range []*netops.XfrmPolicy{
+		{Src: out.src, Dst: out.dst, DstPort: port, Dir: netops.XFRM_DIR_OUT, SPI: out.spi},
+		{Src: in.src, Dst: in.dst, DstPort: port, Dir: netops.XFRM_DIR_IN, SPI: in.spi},
+	} {
+		if err := ns.XfrmPolicyAdd(policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func teardownSecurityAssociations(ns netops.Namespace, spis ...int) error {
+
+	for _, spi := range spis {
+		if err := ns.XfrmPolicyDel(spi); err != nil {
+			return err
+		}
+		if err := ns.XfrmStateDel(spi); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ package tunneler
 import (
 	"fmt"
 	"net/netip"
+	"time"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
 )
@@ -15,8 +16,107 @@ type Tunneler interface {
 	Teardown(nsPath, hostInterface string, config *Config) error
 }
 
+// TunnelerChecker is implemented by tunnelers that can check whether the interfaces of a tunnel are still present
+type TunnelerChecker interface {
+	Check(nsPath string, config *Config) error
+}
+
+// TunnelerReleaser is implemented by tunnelers that allocate resources in Configure, which need to be released
+// when a tunnel is not set up or fails to be torn down
+type TunnelerReleaser interface {
+	Release(config *Config)
+}
+
+// CheckLink returns an error if a link is missing or down on a network namespace
+func CheckLink(ns netops.Namespace, name string) error {
+
+	link, err := ns.LinkFind(name)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %q on netns %s: %w", name, ns.Path(), err)
+	}
+	if !link.IsUp() {
+		return fmt.Errorf("interface %s on netns %s is down", name, ns.Path())
+	}
+	return nil
+}
+
+const (
+	probeAttempts = 3
+	probeTimeout  = time.Second
+)
+
+// ProbeLink returns an error if a pod VM does not reply to ARP probes for the pod IP address sent over a tunnel interface,
+// e.g. because the tunnel endpoint on the pod VM is gone while the interfaces on the worker node are still present.
+// A probe is retried, so that a single lost packet is not reported as a failure.
+func ProbeLink(ns netops.Namespace, name string, podIP netip.Addr) error {
+
+	// Pod IP addresses are IPv4 addresses, but neighbor discovery is not implemented for other addresses
+	if !podIP.Is4() {
+		return nil
+	}
+
+	var err error
+	for i := 0; i < probeAttempts; i++ {
+		if err = ns.ARPProbe(name, podIP, probeTimeout); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("pod VM is not reachable over interface %s on netns %s: %w", name, ns.Path(), err)
+}
+
+const (
+	// DatapathTC redirects traffic between the pod interface and the tunnel interface with a tc u32 filter and a mirred action
+	DatapathTC = "tc"
+	// DatapathEBPF redirects traffic between the pod interface and the tunnel interface with a tc eBPF program
+	DatapathEBPF = "ebpf"
+)
+
+// RedirectAdd redirects all traffic from src to dst on a network namespace with the specified datapath
+func RedirectAdd(ns netops.Namespace, datapath, src, dst string) error {
+
+	switch datapath {
+	case "", DatapathTC:
+		return ns.RedirectAdd(src, dst)
+	case DatapathEBPF:
+		return ns.BPFRedirectAdd(src, dst)
+	}
+	return fmt.Errorf("unknown datapath: %q", datapath)
+}
+
+// ShapingSetup limits the bandwidth of the pod on the pod network namespace of the worker node.
+// Traffic from the pod VM is sent out of the pod interface, and traffic to the pod VM is sent out of the tunnel interface.
+func ShapingSetup(ns netops.Namespace, podInterface, tunnelInterface string, config *Config) error {
+
+	if config.EgressBandwidth > 0 {
+		if err := ns.ShapingAdd(podInterface, config.EgressBandwidth); err != nil {
+			return fmt.Errorf("failed to limit egress bandwidth of %s: %w", podInterface, err)
+		}
+	}
+
+	if config.IngressBandwidth > 0 {
+		if err := ns.ShapingAdd(tunnelInterface, config.IngressBandwidth); err != nil {
+			return fmt.Errorf("failed to limit ingress bandwidth of %s: %w", tunnelInterface, err)
+		}
+	}
+
+	return nil
+}
+
+// ShapingTeardown removes the bandwidth limit from the pod interface. The limit on the tunnel interface is removed with the interface.
+func ShapingTeardown(ns netops.Namespace, podInterface string, config *Config) error {
+
+	if config.EgressBandwidth > 0 {
+		if err := ns.ShapingDel(podInterface); err != nil {
+			return fmt.Errorf("failed to remove egress bandwidth limit of %s: %w", podInterface, err)
+		}
+	}
+
+	return nil
+}
+
 type Config struct {
 	PodIP         netip.Prefix `json:"podip"`
+	PodIPv6       netip.Prefix `json:"podipv6"`
 	PodHwAddr     string       `json:"pod-hw-addr"`
 	InterfaceName string       `json:"interface"`
 	WorkerNodeIP  netip.Prefix `json:"worker-node-ip"`
@@ -27,7 +127,38 @@ type Config struct {
 	Index         int          `json:"index"`
 	VXLANPort     int          `json:"vxlan-port,omitempty"`
 	VXLANID       int          `json:"vxlan-id,omitempty"`
+	GenevePort    int          `json:"geneve-port,omitempty"`
+	GeneveID      int          `json:"geneve-id,omitempty"`
 	Dedicated     bool         `json:"dedicated"`
+	MTUOverride   bool         `json:"mtu-override,omitempty"`
+
+	// SecondaryNetworks are additional interfaces attached to the pod, e.g. by Multus.
+	// Each secondary network has its own tunnel.
+	SecondaryNetworks []*Config `json:"secondary-networks,omitempty"`
+	Secondary         bool      `json:"secondary,omitempty"`
+
+	// HostPorts are ports of the worker node forwarded to the pod. They are handled only on the worker node.
+	HostPorts []*HostPort `json:"-"`
+	// Masquerade rewrites the source IP of traffic from the pod to the worker node IP
+	Masquerade bool `json:"-"`
+	// Datapath is how traffic is redirected between the pod interface and the tunnel interface on the worker node
+	Datapath string `json:"-"`
+	// IngressBandwidth and EgressBandwidth limit traffic to and from the pod in bits per second. 0 means no limit.
+	IngressBandwidth uint64 `json:"-"`
+	EgressBandwidth  uint64 `json:"-"`
+
+	IPsecWorkerNodeSPI int    `json:"ipsec-worker-node-spi,omitempty"`
+	IPsecWorkerNodeKey string `json:"ipsec-worker-node-key,omitempty"`
+	IPsecPodNodeSPI    int    `json:"ipsec-pod-node-spi,omitempty"`
+	IPsecPodNodeKey    string `json:"ipsec-pod-node-key,omitempty"`
+
+	WireGuardPort           int          `json:"wireguard-port,omitempty"`
+	WireGuardPrivateKey     string       `json:"wireguard-private-key,omitempty"`
+	WireGuardPeerPublicKey  string       `json:"wireguard-peer-public-key,omitempty"`
+	WireGuardPodAddr        netip.Prefix `json:"wireguard-pod-addr"`
+	WireGuardWorkerNodeAddr netip.Prefix `json:"wireguard-worker-node-addr"`
+	// The private key of the worker node end is never sent to the pod VM
+	WireGuardWorkerNodeKey string `json:"-"`
 }
 
 type Route struct {
@@ -38,6 +169,13 @@ type Route struct {
 	Scope    netops.RouteScope    `json:"scope,omitempty"`
 }
 
+type HostPort struct {
+	HostIP        netip.Addr
+	HostPort      int
+	ContainerPort int
+	Protocol      string
+}
+
 type Neighbor struct {
 	IP           netip.Addr           `json:"ip,omitempty"`
 	HardwareAddr string               `json:"hw-addr,omitempty"`
@@ -46,14 +184,17 @@ type Neighbor struct {
 }
 
 type driver struct {
+	overhead              int
 	newWorkerNodeTunneler func() (Tunneler, error)
 	newPodNodeTunneler    func() (Tunneler, error)
 }
 
 var drivers = make(map[string]*driver)
 
-func Register(tunnelType string, newWorkerNodeTunneler, newPodNodeTunneler func() (Tunneler, error)) {
+// Register adds a tunnel type. overhead is the number of bytes the encapsulation adds to packets of the pod network.
+func Register(tunnelType string, overhead int, newWorkerNodeTunneler, newPodNodeTunneler func() (Tunneler, error)) {
 	drivers[tunnelType] = &driver{
+		overhead:              overhead,
 		newWorkerNodeTunneler: newWorkerNodeTunneler,
 		newPodNodeTunneler:    newPodNodeTunneler,
 	}
@@ -85,3 +226,13 @@ func PodNodeTunneler(tunnelType string) (Tunneler, error) {
 	}
 	return driver.newPodNodeTunneler()
 }
+
+// TunnelMTU returns the largest MTU of a pod interface whose encapsulated packets fit into underlayMTU
+func TunnelMTU(tunnelType string, underlayMTU int) (int, error) {
+
+	driver, err := getDriver(tunnelType)
+	if err != nil {
+		return 0, err
+	}
+	return underlayMTU - driver.overhead, nil
+}
@@ -0,0 +1,96 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package vxlan
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+)
+
+// VXLAN IDs are 24 bit numbers
+const maxVXLANID = 1<<24 - 1
+
+// vniAllocator assigns a VXLAN ID to each pod. An ID is not assigned while it is used by another pod,
+// or by another VXLAN interface on the worker node with the same UDP port, such as one created by a CNI plugin.
+type vniAllocator struct {
+	inUse map[vni]bool
+	mutex sync.Mutex
+}
+
+type vni struct {
+	id   int
+	port int
+}
+
+var allocator = &vniAllocator{inUse: make(map[vni]bool)}
+
+// allocate returns the smallest available VXLAN ID that is not less than minID
+func (a *vniAllocator) allocate(minID, port int, existing []*netops.VXLAN) (int, error) {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	used := make(map[vni]bool)
+	for _, dev := range existing {
+		used[vni{id: dev.ID, port: dev.Port}] = true
+	}
+
+	for id := minID; id <= maxVXLANID; id++ {
+		v := vni{id: id, port: port}
+		if a.inUse[v] {
+			continue
+		}
+		if used[v] {
+			logger.Printf("VXLAN ID %d on port %d is used by another interface", id, port)
+			continue
+		}
+		a.inUse[v] = true
+		return id, nil
+	}
+
+	return 0, fmt.Errorf("no VXLAN ID is available on port %d (minimum ID: %d)", port, minID)
+}
+
+// reserve marks a VXLAN ID as used. It is called when a tunnel is set up again with an ID that may have been released.
+func (a *vniAllocator) reserve(id, port int) {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.inUse[vni{id: id, port: port}] = true
+}
+
+func (a *vniAllocator) release(id, port int) {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.inUse, vni{id: id, port: port})
+}
+
+// hostVXLANDevices returns VXLAN interfaces on a network namespace
+func hostVXLANDevices(ns netops.Namespace) ([]*netops.VXLAN, error) {
+
+	links, err := ns.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interfaces on %s: %w", ns.Path(), err)
+	}
+
+	var devices []*netops.VXLAN
+	for _, link := range links {
+		if link.Type() != "vxlan" {
+			continue
+		}
+		device, err := link.GetDevice()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get device info of %s: %w", link.Name(), err)
+		}
+		if dev, ok := device.(*netops.VXLAN); ok {
+			devices = append(devices, dev)
+		}
+	}
+	return devices, nil
+}
@@ -0,0 +1,54 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package vxlan
+
+import (
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+)
+
+func TestVNIAllocator(t *testing.T) {
+
+	a := &vniAllocator{inUse: make(map[vni]bool)}
+
+	// VXLAN ID 555001 is used by another interface on the same port, and 555002 on another port
+	existing := []*netops.VXLAN{
+		{ID: 555001, Port: 4789},
+		{ID: 555002, Port: 8472},
+	}
+
+	for _, expected := range []int{555000, 555002, 555003} {
+		id, err := a.allocate(555000, 4789, existing)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if id != expected {
+			t.Fatalf("Expect %d, got %d", expected, id)
+		}
+	}
+
+	a.release(555002, 4789)
+
+	id, err := a.allocate(555000, 4789, existing)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if id != 555002 {
+		t.Fatalf("Expect a released ID 555002, got %d", id)
+	}
+
+	// Allocations are independent for each port
+	id, err = a.allocate(555000, 4790, existing)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if id != 555000 {
+		t.Fatalf("Expect 555000, got %d", id)
+	}
+
+	if _, err := a.allocate(maxVXLANID, 4789, []*netops.VXLAN{{ID: maxVXLANID, Port: 4789}}); err == nil {
+		t.Fatal("Expect an error when no VXLAN ID is available")
+	}
+}
@@ -70,7 +70,7 @@ func iptablesSetup(ns netops.Namespace, dstAddr netip.Addr, dstPort, vxlanID int
 
 	return ns.Run(func() error {
 
-		ipt, err := iptables.New(iptables.IPFamily(iptables.ProtocolIPv4))
+		ipt, err := iptables.New(iptables.IPFamily(iptablesProtocol(dstAddr)))
 		if err != nil {
 			return fmt.Errorf("failed to initialize iptables: %w", err)
 		}
@@ -113,7 +113,7 @@ func iptablesTeardown(ns netops.Namespace, dstAddr netip.Addr, dstPort, vxlanID
 
 	return ns.Run(func() error {
 
-		ipt, err := iptables.New(iptables.IPFamily(iptables.ProtocolIPv4))
+		ipt, err := iptables.New(iptables.IPFamily(iptablesProtocol(dstAddr)))
 		if err != nil {
 			return fmt.Errorf("failed to initialize iptables: %w", err)
 		}
@@ -147,3 +147,10 @@ func iptablesTeardown(ns netops.Namespace, dstAddr netip.Addr, dstPort, vxlanID
 		return nil
 	})
 }
+
+func iptablesProtocol(addr netip.Addr) iptables.Protocol {
+	if addr.Is6() && !addr.Is4In6() {
+		return iptables.ProtocolIPv6
+	}
+	return iptables.ProtocolIPv4
+}
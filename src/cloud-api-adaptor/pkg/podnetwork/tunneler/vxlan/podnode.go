@@ -14,7 +14,9 @@ import (
 
 const (
 	hostVxlanInterface = "vxlan0"
-	maxMTU             = 1450
+
+	// Overhead is the size of the outer IPv4, UDP and VXLAN headers and the inner Ethernet header
+	Overhead = 50
 )
 
 type podNodeTunneler struct {
@@ -81,9 +83,6 @@ func (t *podNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *
 	}
 
 	mtu := int(config.MTU)
-	if mtu > maxMTU {
-		mtu = maxMTU
-	}
 	if err := vxlan.SetMTU(mtu); err != nil {
 		return fmt.Errorf("failed to set MTU of %s to %d on %s: %w", podVxlanInterface, mtu, nsPath, err)
 	}
@@ -144,3 +143,14 @@ func (t *podNodeTunneler) Teardown(nsPath, hostInterface string, config *tunnele
 
 	return nil
 }
+
+func (t *podNodeTunneler) Check(nsPath string, config *tunneler.Config) error {
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a pod network namespace: %s: %w", nsPath, err)
+	}
+	defer podNS.Close()
+
+	return tunneler.CheckLink(podNS, config.InterfaceName)
+}
@@ -32,8 +32,24 @@ func NewWorkerNodeTunneler() (tunneler.Tunneler, error) {
 
 func (t *workerNodeTunneler) Configure(n *tunneler.NetworkConfig, config *tunneler.Config) error {
 
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	existing, err := hostVXLANDevices(hostNS)
+	if err != nil {
+		return err
+	}
+
+	id, err := allocator.allocate(n.VXLAN.MinID, n.VXLAN.Port, existing)
+	if err != nil {
+		return err
+	}
+
 	config.VXLANPort = n.VXLAN.Port
-	config.VXLANID = n.VXLAN.MinID + config.Index
+	config.VXLANID = id
 
 	return nil
 }
@@ -76,6 +92,8 @@ func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, confi
 		}
 	}()
 
+	allocator.reserve(config.VXLANID, config.VXLANPort)
+
 	if err := iptablesSetup(hostNS, dstAddr, config.VXLANPort, config.VXLANID); err != nil {
 		return err
 	}
@@ -131,11 +149,13 @@ func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, confi
 
 	podVxlanInterface, err := podNS.LinkFind(hostVxlanInterface)
 	if err != nil {
-		return fmt.Errorf("failed to find vxlan interface %q on pod netns %s to %s: %w", hostVxlanInterface, podNS.Path(), secondPodInterface, err)
+		return fmt.Errorf("failed to find vxlan interface %q on pod netns %s: %w", hostVxlanInterface, podNS.Path(), err)
 	}
 
-	if err := podVxlanInterface.SetName(secondPodInterface); err != nil {
-		return fmt.Errorf("failed to change vxlan interface name %s on netns %s to %s: %w", hostVxlanInterface, podNS.Path(), secondPodInterface, err)
+	tunnelInterface := podTunnelInterface(config)
+
+	if err := podVxlanInterface.SetName(tunnelInterface); err != nil {
+		return fmt.Errorf("failed to change vxlan interface name %s on netns %s to %s: %w", hostVxlanInterface, podNS.Path(), tunnelInterface, err)
 	}
 
 	if err := podVxlanInterface.SetUp(); err != nil {
@@ -144,14 +164,18 @@ func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, confi
 
 	podInterface := config.InterfaceName
 
-	logger.Printf("Add tc redirect filters between %s and %s on pod network namespace %s", podInterface, secondPodInterface, nsPath)
+	logger.Printf("Add tc redirect filters between %s and %s on pod network namespace %s", podInterface, tunnelInterface, nsPath)
 
-	if err := podNS.RedirectAdd(podInterface, secondPodInterface); err != nil {
-		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", podInterface, secondPodInterface, err)
+	if err := tunneler.RedirectAdd(podNS, config.Datapath, podInterface, tunnelInterface); err != nil {
+		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", podInterface, tunnelInterface, err)
 	}
 
-	if err := podNS.RedirectAdd(secondPodInterface, podInterface); err != nil {
-		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", secondPodInterface, podInterface, err)
+	if err := tunneler.RedirectAdd(podNS, config.Datapath, tunnelInterface, podInterface); err != nil {
+		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", tunnelInterface, podInterface, err)
+	}
+
+	if err := tunneler.ShapingSetup(podNS, podInterface, tunnelInterface, config); err != nil {
+		return err
 	}
 
 	return nil
@@ -179,31 +203,37 @@ func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunn
 		}
 	}()
 
-	logger.Printf("Delete tc redirect filters on %s and %s in the network namespace %s", config.InterfaceName, hostInterface, nsPath)
+	tunnelInterface := podTunnelInterface(config)
+
+	logger.Printf("Delete tc redirect filters on %s and %s in the network namespace %s", config.InterfaceName, tunnelInterface, nsPath)
 
 	if err := podNS.RedirectDel(config.InterfaceName); err != nil {
-		return fmt.Errorf("failed to delete a tc redirect filter from %s to %s: %w", config.InterfaceName, secondPodInterface, err)
+		return fmt.Errorf("failed to delete a tc redirect filter from %s to %s: %w", config.InterfaceName, tunnelInterface, err)
 	}
 
-	if err := podNS.RedirectDel(secondPodInterface); err != nil {
-		return fmt.Errorf("failed to delete a tc redirect filter from %s to %s: %w", secondPodInterface, config.InterfaceName, err)
+	if err := podNS.RedirectDel(tunnelInterface); err != nil {
+		return fmt.Errorf("failed to delete a tc redirect filter from %s to %s: %w", tunnelInterface, config.InterfaceName, err)
 	}
 
-	logger.Printf("Delete vxlan interface %s in the network namespace %s", secondPodInterface, nsPath)
+	if err := tunneler.ShapingTeardown(podNS, config.InterfaceName, config); err != nil {
+		return err
+	}
+
+	logger.Printf("Delete vxlan interface %s in the network namespace %s", tunnelInterface, nsPath)
 
-	podVxlanInterface, err := podNS.LinkFind(secondPodInterface)
+	podVxlanInterface, err := podNS.LinkFind(tunnelInterface)
 	if err != nil {
-		return fmt.Errorf("failed to find vxlan interface %q on pod netns %s to %s: %w", secondPodInterface, podNS.Path(), secondPodInterface, err)
+		return fmt.Errorf("failed to find vxlan interface %q on pod netns %s to %s: %w", tunnelInterface, podNS.Path(), tunnelInterface, err)
 	}
 
 	device, err := podVxlanInterface.GetDevice()
 	if err != nil {
-		return fmt.Errorf("failed to get device info of %s: %w", secondPodInterface, err)
+		return fmt.Errorf("failed to get device info of %s: %w", tunnelInterface, err)
 	}
 
 	vxlanDevice, ok := device.(*netops.VXLAN)
 	if !ok {
-		return fmt.Errorf("not a VXLAN interface: %s", secondPodInterface)
+		return fmt.Errorf("not a VXLAN interface: %s", tunnelInterface)
 	}
 
 	dstAddr := vxlanDevice.Group
@@ -211,12 +241,42 @@ func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunn
 	vxlanID := vxlanDevice.ID
 
 	if err := podVxlanInterface.Delete(); err != nil {
-		return fmt.Errorf("failed to delete vxlan interface %s at %s: %w", secondPodInterface, podNS.Path(), err)
+		return fmt.Errorf("failed to delete vxlan interface %s at %s: %w", tunnelInterface, podNS.Path(), err)
 	}
 
 	if err := iptablesTeardown(hostNS, dstAddr, dstPort, vxlanID); err != nil {
 		return err
 	}
 
+	allocator.release(vxlanID, dstPort)
+
 	return nil
 }
+
+// Release releases the VXLAN ID allocated by Configure
+func (t *workerNodeTunneler) Release(config *tunneler.Config) {
+	allocator.release(config.VXLANID, config.VXLANPort)
+}
+
+func (t *workerNodeTunneler) Check(nsPath string, config *tunneler.Config) error {
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a network namespace: %s: %w", nsPath, err)
+	}
+	defer podNS.Close()
+
+	if err := tunneler.CheckLink(podNS, podTunnelInterface(config)); err != nil {
+		return err
+	}
+
+	return tunneler.ProbeLink(podNS, podTunnelInterface(config), config.PodIP.Addr())
+}
+
+// podTunnelInterface returns the name of the vxlan interface that is paired with the pod interface on the pod network namespace
+func podTunnelInterface(config *tunneler.Config) string {
+	if config.Secondary {
+		return fmt.Sprintf("%s-%d", secondPodInterface, config.Index)
+	}
+	return secondPodInterface
+}
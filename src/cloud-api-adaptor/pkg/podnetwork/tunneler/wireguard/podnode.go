@@ -0,0 +1,122 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/vxlan"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/wgutil"
+)
+
+const (
+	podWgInterface = "wgtun0"
+
+	// Overhead is the size of the VXLAN encapsulation and the WireGuard encapsulation over IPv6
+	Overhead = vxlan.Overhead + 80
+)
+
+type podNodeTunneler struct {
+	vxlan tunneler.Tunneler
+}
+
+func NewPodNodeTunneler() (tunneler.Tunneler, error) {
+	t, err := vxlan.NewPodNodeTunneler()
+	if err != nil {
+		return nil, err
+	}
+	return &podNodeTunneler{vxlan: t}, nil
+}
+
+func (t *podNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	if !config.WireGuardPodAddr.IsValid() || !config.WireGuardWorkerNodeAddr.IsValid() {
+		return fmt.Errorf("WireGuard overlay addresses are not specified: %#v, %#v", config.WireGuardPodAddr, config.WireGuardWorkerNodeAddr)
+	}
+
+	workerNodePublicKey := []byte(config.WireGuardPeerPublicKey)
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get host network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	link, err := hostNS.LinkAdd(podWgInterface, &netops.WireGuard{})
+	if err != nil {
+		return fmt.Errorf("failed to add WireGuard interface %s: %w", podWgInterface, err)
+	}
+
+	if err := wgutil.Configure(podWgInterface, []byte(config.WireGuardPrivateKey), config.WireGuardPort); err != nil {
+		return err
+	}
+
+	// The worker node initiates the handshake, so its endpoint is learned from incoming packets
+	peer := &wgutil.Peer{
+		PublicKey:  workerNodePublicKey,
+		AllowedIPs: []netip.Prefix{netip.PrefixFrom(config.WireGuardWorkerNodeAddr.Addr(), config.WireGuardWorkerNodeAddr.Addr().BitLen())},
+	}
+	if err := wgutil.AddPeer(podWgInterface, peer); err != nil {
+		return err
+	}
+
+	if err := link.AddAddr(config.WireGuardPodAddr); err != nil {
+		return fmt.Errorf("failed to add address %s to %s: %w", config.WireGuardPodAddr, podWgInterface, err)
+	}
+
+	if err := link.SetUp(); err != nil {
+		return err
+	}
+
+	// The VXLAN tunnel runs over the WireGuard link to the overlay address of the worker node
+	vxlanConfig := *config
+	vxlanConfig.WorkerNodeIP = config.WireGuardWorkerNodeAddr
+
+	return t.vxlan.Setup(nsPath, podNodeIPs, &vxlanConfig)
+}
+
+func (t *podNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
+
+	// The WireGuard interface is deleted even if the VXLAN interface is already gone, so that the tunnel can be set up again
+	return errors.Join(t.vxlan.Teardown(nsPath, hostInterface, config), teardownPodWireGuard())
+}
+
+func (t *podNodeTunneler) Check(nsPath string, config *tunneler.Config) error {
+
+	if err := t.vxlan.(tunneler.TunnelerChecker).Check(nsPath, config); err != nil {
+		return err
+	}
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get host network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	return tunneler.CheckLink(hostNS, podWgInterface)
+}
+
+func teardownPodWireGuard() error {
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get host network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	link, err := hostNS.LinkFind(podWgInterface)
+	if err != nil {
+		return fmt.Errorf("failed to find WireGuard interface %q on netns %s: %w", podWgInterface, hostNS.Path(), err)
+	}
+
+	if err := link.Delete(); err != nil {
+		return fmt.Errorf("failed to delete WireGuard interface %s at %s: %w", podWgInterface, hostNS.Path(), err)
+	}
+
+	return nil
+}
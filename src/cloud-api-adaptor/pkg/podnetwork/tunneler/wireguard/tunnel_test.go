@@ -0,0 +1,64 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wireguard
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tuntest"
+)
+
+func TestWireGuard(t *testing.T) {
+
+	tuntest.RunTunnelTest(t, "wireguard", NewWorkerNodeTunneler, NewPodNodeTunneler, false)
+
+}
+
+func TestOverlayAddrs(t *testing.T) {
+
+	for _, tc := range []struct {
+		index          int
+		workerNodeAddr string
+		podAddr        string
+	}{
+		{index: 0, workerNodeAddr: "10.251.0.0/31", podAddr: "10.251.0.1/31"},
+		{index: 200, workerNodeAddr: "10.251.1.144/31", podAddr: "10.251.1.145/31"},
+	} {
+		workerNodeAddr, podAddr, err := overlayAddrs(netip.MustParsePrefix(DefaultOverlay), tc.index)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if e, a := netip.MustParsePrefix(tc.workerNodeAddr), workerNodeAddr; e != a {
+			t.Errorf("Expect %s, got %s", e, a)
+		}
+		if e, a := netip.MustParsePrefix(tc.podAddr), podAddr; e != a {
+			t.Errorf("Expect %s, got %s", e, a)
+		}
+	}
+
+	if _, _, err := overlayAddrs(netip.MustParsePrefix(DefaultOverlay), 1<<15); err == nil {
+		t.Error("Expect error, got nil")
+	}
+
+	workerNodeAddr, podAddr, err := overlayAddrs(netip.MustParsePrefix("192.168.10.0/24"), 3)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := netip.MustParsePrefix("192.168.10.6/31"), workerNodeAddr; e != a {
+		t.Errorf("Expect %s, got %s", e, a)
+	}
+	if e, a := netip.MustParsePrefix("192.168.10.7/31"), podAddr; e != a {
+		t.Errorf("Expect %s, got %s", e, a)
+	}
+	if _, _, err := overlayAddrs(netip.MustParsePrefix("192.168.10.0/24"), 128); err == nil {
+		t.Error("Expect error, got nil")
+	}
+
+	for _, overlay := range []string{"10.251.0.0/32", "fd00::/64"} {
+		if err := ValidateOverlay(netip.MustParsePrefix(overlay)); err == nil {
+			t.Errorf("Expect error for %s, got nil", overlay)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/podnetwork/tunneler/vxlan"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/wgutil"
+)
+
+var logger = log.New(log.Writer(), "[tunneler/wireguard] ", log.LstdFlags|log.Lmsgprefix)
+
+const (
+	DefaultWireGuardPort    = 51821
+	DefaultOverlay          = "10.251.0.0/16"
+	hostWgInterfacePrefix   = "ppwgtun"
+	persistentKeepalive     = 25
+	wireGuardOverlayMaxBits = 31
+)
+
+type workerNodeTunneler struct {
+	vxlan tunneler.TunnelerConfigurator
+}
+
+func NewWorkerNodeTunneler() (tunneler.Tunneler, error) {
+	t, err := vxlan.NewWorkerNodeTunneler()
+	if err != nil {
+		return nil, err
+	}
+	return &workerNodeTunneler{vxlan: t.(tunneler.TunnelerConfigurator)}, nil
+}
+
+func (t *workerNodeTunneler) Configure(n *tunneler.NetworkConfig, config *tunneler.Config) error {
+
+	// A pod VM has a single WireGuard interface
+	if config.Secondary {
+		return fmt.Errorf("secondary networks are not supported by tunnel type %q", config.TunnelType)
+	}
+
+	if err := t.vxlan.Configure(n, config); err != nil {
+		return err
+	}
+
+	// Pod traffic is carried by a VXLAN tunnel over the point-to-point WireGuard links of the overlay network
+	overlay := n.WireGuard.Overlay
+	if !overlay.IsValid() {
+		overlay = netip.MustParsePrefix(DefaultOverlay)
+	}
+	workerNodeAddr, podAddr, err := overlayAddrs(overlay, config.Index)
+	if err != nil {
+		return err
+	}
+	config.WireGuardWorkerNodeAddr = workerNodeAddr
+	config.WireGuardPodAddr = podAddr
+	config.WireGuardPort = n.WireGuard.Port
+
+	podPrivateKey, _, err := wgutil.GenerateKey()
+	if err != nil {
+		return err
+	}
+	workerNodePrivateKey, workerNodePublicKey, err := wgutil.GenerateKey()
+	if err != nil {
+		return err
+	}
+	config.WireGuardPrivateKey = string(podPrivateKey)
+	config.WireGuardPeerPublicKey = string(workerNodePublicKey)
+	config.WireGuardWorkerNodeKey = string(workerNodePrivateKey)
+
+	return nil
+}
+
+func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	var dstAddr netip.Addr
+
+	numIPs := len(podNodeIPs)
+	if numIPs == 0 {
+		return fmt.Errorf("pod node has no IPs")
+	}
+
+	if config.Dedicated {
+		if numIPs < 2 {
+			return fmt.Errorf("dedicated tunnel missing destination address")
+		}
+		dstAddr = podNodeIPs[1]
+	} else {
+		dstAddr = podNodeIPs[0]
+	}
+
+	if config.WireGuardWorkerNodeKey == "" {
+		return fmt.Errorf("WireGuard key of the worker node is not configured")
+	}
+
+	podPublicKey, err := wgutil.PublicKey([]byte(config.WireGuardPrivateKey))
+	if err != nil {
+		return err
+	}
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	// The WireGuard interface stays in the host network namespace, so that its UDP socket can reach the pod VM
+	hostWgInterface := hostWgInterfaceName(config.Index)
+
+	link, err := hostNS.LinkAdd(hostWgInterface, &netops.WireGuard{})
+	if err != nil {
+		return fmt.Errorf("failed to add WireGuard interface %s: %w", hostWgInterface, err)
+	}
+
+	if err := wgutil.Configure(hostWgInterface, []byte(config.WireGuardWorkerNodeKey), 0); err != nil {
+		return err
+	}
+
+	peer := &wgutil.Peer{
+		PublicKey:           podPublicKey,
+		Endpoint:            net.JoinHostPort(dstAddr.String(), strconv.Itoa(config.WireGuardPort)),
+		AllowedIPs:          []netip.Prefix{netip.PrefixFrom(config.WireGuardPodAddr.Addr(), config.WireGuardPodAddr.Addr().BitLen())},
+		PersistentKeepalive: persistentKeepalive,
+	}
+	if err := wgutil.AddPeer(hostWgInterface, peer); err != nil {
+		return err
+	}
+
+	if err := link.AddAddr(config.WireGuardWorkerNodeAddr); err != nil {
+		return fmt.Errorf("failed to add address %s to %s: %w", config.WireGuardWorkerNodeAddr, hostWgInterface, err)
+	}
+
+	if err := link.SetUp(); err != nil {
+		return err
+	}
+	logger.Printf("WireGuard interface %s (remote %s, overlay %s) created at %s", hostWgInterface, peer.Endpoint, config.WireGuardPodAddr.Addr(), hostNS.Path())
+
+	// The VXLAN tunnel runs over the WireGuard link to the overlay address of the pod VM
+	vxlanConfig := *config
+	vxlanConfig.Dedicated = false
+
+	return t.vxlan.Setup(nsPath, []netip.Addr{config.WireGuardPodAddr.Addr()}, &vxlanConfig)
+}
+
+func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
+
+	// The WireGuard interface is deleted even if the VXLAN interface is already gone, so that the tunnel can be set up again
+	return errors.Join(t.vxlan.Teardown(nsPath, hostInterface, config), teardownWireGuard(config))
+}
+
+func (t *workerNodeTunneler) Release(config *tunneler.Config) {
+	t.vxlan.(tunneler.TunnelerReleaser).Release(config)
+}
+
+func (t *workerNodeTunneler) Check(nsPath string, config *tunneler.Config) error {
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	if err := tunneler.CheckLink(hostNS, hostWgInterfaceName(config.Index)); err != nil {
+		return err
+	}
+
+	// The VXLAN tunnel is probed over the WireGuard link
+	return t.vxlan.(tunneler.TunnelerChecker).Check(nsPath, config)
+}
+
+func teardownWireGuard(config *tunneler.Config) error {
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer hostNS.Close()
+
+	hostWgInterface := hostWgInterfaceName(config.Index)
+
+	logger.Printf("Delete WireGuard interface %s in the network namespace %s", hostWgInterface, hostNS.Path())
+
+	link, err := hostNS.LinkFind(hostWgInterface)
+	if err != nil {
+		return fmt.Errorf("failed to find WireGuard interface %q on netns %s: %w", hostWgInterface, hostNS.Path(), err)
+	}
+
+	if err := link.Delete(); err != nil {
+		return fmt.Errorf("failed to delete WireGuard interface %s at %s: %w", hostWgInterface, hostNS.Path(), err)
+	}
+
+	return nil
+}
+
+func hostWgInterfaceName(index int) string {
+	return fmt.Sprintf("%s%d", hostWgInterfacePrefix, index)
+}
+
+// ValidateOverlay checks that an overlay network has room for the point-to-point link of at least one pod
+func ValidateOverlay(overlay netip.Prefix) error {
+	if !overlay.Addr().Is4() || overlay.Bits() > wireGuardOverlayMaxBits {
+		return fmt.Errorf("WireGuard overlay network %s must be an IPv4 network of at most /%d", overlay, wireGuardOverlayMaxBits)
+	}
+	return nil
+}
+
+// overlayAddrs returns the addresses of the point-to-point link of a pod in the overlay network
+func overlayAddrs(overlay netip.Prefix, index int) (workerNodeAddr, podAddr netip.Prefix, err error) {
+
+	if err := ValidateOverlay(overlay); err != nil {
+		return netip.Prefix{}, netip.Prefix{}, err
+	}
+
+	if index < 0 || index >= 1<<(wireGuardOverlayMaxBits-overlay.Bits()) {
+		return netip.Prefix{}, netip.Prefix{}, fmt.Errorf("pod index %d exceeds the WireGuard overlay network %s", index, overlay)
+	}
+
+	base := overlay.Masked().Addr().As4()
+	offset := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3]) + uint32(index)*2
+
+	addr := func(n uint32) netip.Prefix {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}), wireGuardOverlayMaxBits)
+	}
+
+	return addr(offset), addr(offset + 1), nil
+}
@@ -144,6 +144,22 @@ func RunTunnelTest(t *testing.T, tunnelType string, newWorkerNodeTunneler, newPo
 			pod.config.VXLANID = 555000 + i // vxlan.DefaultVXLANMinID + index
 		}
 
+		if tunnelType == "geneve" {
+			pod.config.GenevePort = 6081     // geneve.DefaultGenevePort
+			pod.config.GeneveID = 555000 + i // geneve.DefaultGeneveMinID + index
+		}
+
+		if configurator, ok := pod.workerNodeTunneler.(tunneler.TunnelerConfigurator); ok && (tunnelType == "wireguard" || tunnelType == "ipsec") {
+			networkConfig := &tunneler.NetworkConfig{
+				TunnelType: tunnelType,
+				VXLAN:      tunneler.VXLANConfig{Port: 4789, MinID: 555000},
+				WireGuard:  tunneler.WireGuardConfig{Port: 51821},
+			}
+			if err := configurator.Configure(networkConfig, pod.config); err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+		}
+
 		podNodeIPs := []netip.Addr{getIP(t, pod.podNodePrimaryAddr)}
 
 		if dedicated {
@@ -14,10 +14,22 @@ import (
 
 const DefaultTunnelType = "vxlan"
 
+const (
+	SourceIPPolicyPreserve   = "preserve"
+	SourceIPPolicyMasquerade = "masquerade"
+
+	DefaultSourceIPPolicy = SourceIPPolicyPreserve
+)
+
+const DefaultDatapath = tunneler.DatapathTC
+
 type WorkerNode interface {
 	Inspect(nsPath string) (*tunneler.Config, error)
 	Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error
 	Teardown(nsPath string, config *tunneler.Config) error
+	Check(nsPath string, config *tunneler.Config) error
+	Repair(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error
+	Release(config *tunneler.Config)
 }
 
 type workerNode struct {
@@ -27,10 +39,12 @@ type workerNode struct {
 
 // TODO: Pod index is reset when this process restarts.
 // We need to manage a persistent unique index number for each pod VM
-var podIndexManager podIndex
+var podIndexManager = podIndex{inUse: make(map[int]bool)}
 
+// podIndex assigns the smallest index that is not used by another tunnel, so that
+// the indexes of deleted pods are reused, and stay within the limits of tunnel types
 type podIndex struct {
-	index int
+	inUse map[int]bool
 	mutex sync.Mutex
 }
 
@@ -38,11 +52,29 @@ func (p *podIndex) Get() int {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	index := p.index
-	p.index++
+	index := 0
+	for p.inUse[index] {
+		index++
+	}
+	p.inUse[index] = true
 	return index
 }
 
+func (p *podIndex) Release(index int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.inUse, index)
+}
+
+// releasePodIndexes releases the indexes of the tunnels of a pod
+func releasePodIndexes(config *tunneler.Config) {
+	podIndexManager.Release(config.Index)
+	for _, secondary := range config.SecondaryNetworks {
+		podIndexManager.Release(secondary.Index)
+	}
+}
+
 func NewWorkerNode(networkConfig *tunneler.NetworkConfig) (WorkerNode, error) {
 
 	t, err := tunneler.WorkerNodeTunneler(networkConfig.TunnelType)
@@ -55,6 +87,23 @@ func NewWorkerNode(networkConfig *tunneler.NetworkConfig) (WorkerNode, error) {
 		return nil, fmt.Errorf("internal error: Configure is not defined: %T", t)
 	}
 
+	switch networkConfig.SourceIPPolicy {
+	case "", SourceIPPolicyPreserve:
+	case SourceIPPolicyMasquerade:
+		// Traffic to other pods and services must keep the pod IP, so that NetworkPolicies apply to it
+		if len(networkConfig.ClusterCIDRs) == 0 {
+			return nil, fmt.Errorf("source IP policy %q requires the cluster CIDRs", SourceIPPolicyMasquerade)
+		}
+	default:
+		return nil, fmt.Errorf("unknown source IP policy: %q", networkConfig.SourceIPPolicy)
+	}
+
+	switch networkConfig.Datapath {
+	case "", tunneler.DatapathTC, tunneler.DatapathEBPF:
+	default:
+		return nil, fmt.Errorf("unknown datapath: %q", networkConfig.Datapath)
+	}
+
 	wn := &workerNode{
 		NetworkConfig: networkConfig,
 		tunneler:      tun,
@@ -63,12 +112,19 @@ func NewWorkerNode(networkConfig *tunneler.NetworkConfig) (WorkerNode, error) {
 	return wn, nil
 }
 
-func (n *workerNode) Inspect(nsPath string) (*tunneler.Config, error) {
+func (n *workerNode) Inspect(nsPath string) (_ *tunneler.Config, err error) {
 
 	config := &tunneler.Config{
 		TunnelType: n.TunnelType,
 		Index:      podIndexManager.Get(),
+		Masquerade: n.SourceIPPolicy == SourceIPPolicyMasquerade,
+		Datapath:   n.Datapath,
 	}
+	defer func() {
+		if err != nil {
+			n.Release(config)
+		}
+	}()
 
 	hostNS, err := netops.OpenCurrentNamespace()
 	if err != nil {
@@ -128,6 +184,31 @@ func (n *workerNode) Inspect(nsPath string) (*tunneler.Config, error) {
 		return nil, err
 	}
 
+	podLink, err := podNS.LinkFind(podInterface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find pod interface %q on netns %s): %w", podInterface, podNS.Path(), err)
+	}
+
+	podIPv6, err := getPodIPv6(podLink)
+	if err != nil {
+		return nil, err
+	}
+
+	if podIPv6.IsValid() {
+		// Dual-stack pods have IPv6 routes in addition to IPv4 routes
+		routes6, err := podNS.RouteList(netops.DefaultRouteFilters(netops.FAMILY_V6)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range routes6 {
+			// Link-local routes are created by the kernel on each interface
+			if r.Destination.Addr().IsLinkLocalUnicast() || r.Destination.Addr().IsMulticast() {
+				continue
+			}
+			routes = append(routes, r)
+		}
+	}
+
 	logger.Printf("routes on netns %s", nsPath)
 	for _, r := range routes {
 		var dst, gw, dev string
@@ -145,17 +226,13 @@ func (n *workerNode) Inspect(nsPath string) (*tunneler.Config, error) {
 		logger.Printf("    %s %s %s", dst, gw, dev)
 	}
 
-	podLink, err := podNS.LinkFind(podInterface)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find pod interface %q on netns %s): %w", podInterface, podNS.Path(), err)
-	}
-
 	podIP, err := getPodIP(podLink)
 	if err != nil {
 		return nil, err
 	}
 
 	config.PodIP = podIP
+	config.PodIPv6 = podIPv6
 	config.PodHwAddr, err = podLink.GetHardwareAddr()
 	if err != nil {
 		logger.Printf("failed to get Mac address of the Pod interface")
@@ -170,6 +247,20 @@ func (n *workerNode) Inspect(nsPath string) (*tunneler.Config, error) {
 	}
 	config.MTU = mtu
 
+	// Encapsulated packets of the pod network need to fit into the MTU of the underlay network
+	hostMTU, err := hostLink.GetMTU()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MTU size of %s: %w", hostInterface, err)
+	}
+	tunnelMTU, err := tunneler.TunnelMTU(n.TunnelType, hostMTU)
+	if err != nil {
+		return nil, err
+	}
+	if tunnelMTU < config.MTU {
+		logger.Printf("MTU of %s is reduced from %d to %d to fit into the MTU %d of %s", podInterface, config.MTU, tunnelMTU, hostMTU, hostInterface)
+		config.MTU = tunnelMTU
+	}
+
 	neighbors, err := podNS.NeighborList(&netops.Neighbor{Dev: podInterface, State: netops.NEIGHBOR_STATE_PERMANENT})
 	if err != nil {
 		return nil, err
@@ -200,20 +291,130 @@ func (n *workerNode) Inspect(nsPath string) (*tunneler.Config, error) {
 		return nil, err
 	}
 
+	secondaries, err := inspectSecondaryNetworks(podNS, config, tunnelMTU)
+	if err != nil {
+		return nil, err
+	}
+	config.SecondaryNetworks = secondaries
+
+	for _, secondary := range secondaries {
+		if err := n.tunneler.Configure(n.NetworkConfig, secondary); err != nil {
+			return nil, fmt.Errorf("failed to configure a tunnel for secondary network %s: %w", secondary.InterfaceName, err)
+		}
+	}
+
 	return config, nil
 }
 
+// inspectSecondaryNetworks returns tunnel configs of interfaces attached to a pod in addition to the primary interface.
+// Meta plugins such as Multus add these interfaces for secondary networks.
+func inspectSecondaryNetworks(podNS netops.Namespace, primary *tunneler.Config, tunnelMTU int) (_ []*tunneler.Config, err error) {
+
+	links, err := podNS.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interfaces on netns %s: %w", podNS.Path(), err)
+	}
+
+	var configs []*tunneler.Config
+	defer func() {
+		if err != nil {
+			for _, config := range configs {
+				podIndexManager.Release(config.Index)
+			}
+		}
+	}()
+
+	for _, link := range links {
+		name := link.Name()
+		if name == "lo" || name == primary.InterfaceName {
+			continue
+		}
+
+		podIP, err := getPodIP(link)
+		if err != nil {
+			logger.Printf("skip interface %s on netns %s: %v", name, podNS.Path(), err)
+			continue
+		}
+
+		podIPv6, err := getPodIPv6(link)
+		if err != nil {
+			return nil, err
+		}
+
+		hwAddr, err := link.GetHardwareAddr()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Mac address for interface %s: %w", name, err)
+		}
+
+		mtu, err := link.GetMTU()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get MTU size of %s: %w", name, err)
+		}
+		if tunnelMTU < mtu {
+			mtu = tunnelMTU
+		}
+
+		config := &tunneler.Config{
+			TunnelType:    primary.TunnelType,
+			Index:         podIndexManager.Get(),
+			WorkerNodeIP:  primary.WorkerNodeIP,
+			Dedicated:     primary.Dedicated,
+			Datapath:      primary.Datapath,
+			InterfaceName: name,
+			PodIP:         podIP,
+			PodIPv6:       podIPv6,
+			PodHwAddr:     hwAddr,
+			MTU:           mtu,
+			Secondary:     true,
+		}
+		logger.Printf("secondary network %s (%s) on netns %s", name, podIP, podNS.Path())
+
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
 func (n *workerNode) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
 
 	if err := n.tunneler.Setup(nsPath, podNodeIPs, config); err != nil {
 		return fmt.Errorf("failed to set up tunnel %q: %w", config.TunnelType, err)
 	}
 
+	for _, secondary := range config.SecondaryNetworks {
+		if err := n.tunneler.Setup(nsPath, podNodeIPs, secondary); err != nil {
+			return fmt.Errorf("failed to set up tunnel %q of secondary network %s: %w", secondary.TunnelType, secondary.InterfaceName, err)
+		}
+	}
+
+	if len(config.HostPorts) > 0 || config.Masquerade {
+		hostNS, err := netops.OpenCurrentNamespace()
+		if err != nil {
+			return fmt.Errorf("failed to open the host network namespace: %w", err)
+		}
+		defer func() {
+			if err := hostNS.Close(); err != nil {
+				logger.Printf("failed to close the host network namespace: %v", err)
+			}
+		}()
+
+		if err := hostPortSetup(hostNS, config); err != nil {
+			return fmt.Errorf("failed to set up host ports of pod %s: %w", config.PodIP.Addr(), err)
+		}
+
+		if err := masqueradeSetup(hostNS, config, n.ClusterCIDRs); err != nil {
+			return fmt.Errorf("failed to set up masquerading of pod %s: %w", config.PodIP.Addr(), err)
+		}
+	}
+
 	return nil
 }
 
 func (n *workerNode) Teardown(nsPath string, config *tunneler.Config) error {
 
+	// The resources of the tunnels are released even if a teardown fails, since the pod is deleted anyway
+	defer n.Release(config)
+
 	hostNS, err := netops.OpenCurrentNamespace()
 	if err != nil {
 		return fmt.Errorf("failed to open the host network namespace: %w", err)
@@ -233,6 +434,24 @@ func (n *workerNode) Teardown(nsPath string, config *tunneler.Config) error {
 		hostInterface = hostPrimaryInterface
 	}
 
+	if len(config.HostPorts) > 0 {
+		if err := hostPortTeardown(hostNS, config); err != nil {
+			return fmt.Errorf("failed to tear down host ports of pod %s: %w", config.PodIP.Addr(), err)
+		}
+	}
+
+	if config.Masquerade {
+		if err := masqueradeTeardown(hostNS, config, n.ClusterCIDRs); err != nil {
+			return fmt.Errorf("failed to tear down masquerading of pod %s: %w", config.PodIP.Addr(), err)
+		}
+	}
+
+	for _, secondary := range config.SecondaryNetworks {
+		if err := n.tunneler.Teardown(nsPath, hostInterface, secondary); err != nil {
+			return fmt.Errorf("failed to tear down tunnel %q of secondary network %s: %w", secondary.TunnelType, secondary.InterfaceName, err)
+		}
+	}
+
 	if err := n.tunneler.Teardown(nsPath, hostInterface, config); err != nil {
 		return fmt.Errorf("failed to tear down tunnel %q: %w", config.TunnelType, err)
 	}
@@ -240,6 +459,61 @@ func (n *workerNode) Teardown(nsPath string, config *tunneler.Config) error {
 	return nil
 }
 
+// Release releases the pod indexes and the tunnel resources allocated by Inspect
+func (n *workerNode) Release(config *tunneler.Config) {
+
+	if releaser, ok := n.tunneler.(tunneler.TunnelerReleaser); ok {
+		for _, c := range append([]*tunneler.Config{config}, config.SecondaryNetworks...) {
+			releaser.Release(c)
+		}
+	}
+	releasePodIndexes(config)
+}
+
+// Check returns an error if an interface of the tunnels of a pod is missing on the worker node
+func (n *workerNode) Check(nsPath string, config *tunneler.Config) error {
+
+	checker, ok := n.tunneler.(tunneler.TunnelerChecker)
+	if !ok {
+		return nil
+	}
+
+	for _, c := range append([]*tunneler.Config{config}, config.SecondaryNetworks...) {
+		if err := checker.Check(nsPath, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Repair tears down what remains of the tunnels of a pod, and sets them up again
+func (n *workerNode) Repair(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	hostInterface := n.HostInterface
+	if hostInterface == "" {
+		hostNS, err := netops.OpenCurrentNamespace()
+		if err != nil {
+			return fmt.Errorf("failed to open the host network namespace: %w", err)
+		}
+		hostPrimaryInterface, err := findPrimaryInterface(hostNS)
+		hostNS.Close()
+		if err != nil {
+			return fmt.Errorf("failed to identify the host primary interface: %w", err)
+		}
+		hostInterface = hostPrimaryInterface
+	}
+
+	// Some interfaces are already gone, so errors are expected
+	for _, c := range append([]*tunneler.Config{config}, config.SecondaryNetworks...) {
+		if err := n.tunneler.Teardown(nsPath, hostInterface, c); err != nil {
+			logger.Printf("failed to tear down tunnel %q of %s for repair: %v", c.TunnelType, c.InterfaceName, err)
+		}
+	}
+
+	return n.Setup(nsPath, podNodeIPs, config)
+}
+
 func getPodIP(podLink netops.Link) (netip.Prefix, error) {
 
 	prefixes, err := podLink.GetAddr()
@@ -261,3 +535,26 @@ func getPodIP(podLink netops.Link) (netip.Prefix, error) {
 	}
 	return ips[0], nil
 }
+
+// getPodIPv6 returns the IPv6 address of a dual-stack pod, or an invalid prefix if the pod has no IPv6 address
+func getPodIPv6(podLink netops.Link) (netip.Prefix, error) {
+
+	prefixes, err := podLink.GetAddrByFamily(netops.FAMILY_V6)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("failed to get IPv6 address on %s of netns %s: %w", podLink.Name(), podLink.Namespace().Path(), err)
+	}
+
+	var ips []netip.Prefix
+	for _, prefix := range prefixes {
+		if prefix.IsValid() && prefix.Addr().Is6() && prefix.Addr().IsGlobalUnicast() {
+			ips = append(ips, prefix)
+		}
+	}
+	if len(ips) > 1 {
+		return netip.Prefix{}, fmt.Errorf("more than one IPv6 addresses found on %s of netns %s", podLink.Name(), podLink.Namespace().Path())
+	}
+	if len(ips) == 0 {
+		return netip.Prefix{}, nil
+	}
+	return ips[0], nil
+}
@@ -28,7 +28,12 @@ func NewApiClient(servicePort uint16, nsPath string) *ApiClient {
 
 // GetKey uses kbs-client to obtain keys such as pp-sid/privateKey, sshclient/publicKey
 func (c *ApiClient) GetKey(key string) (data []byte, err error) {
-	url := fmt.Sprintf("http://127.0.0.1:%d/cdh/resource/default/%s", c.servicePort, key)
+	return c.GetResource("default/" + key)
+}
+
+// GetResource obtains a resource of any repository, e.g. default/pp-sid/userdata-key
+func (c *ApiClient) GetResource(key string) (data []byte, err error) {
+	url := fmt.Sprintf("http://127.0.0.1:%d/cdh/resource/%s", c.servicePort, key)
 
 	client := http.Client{
 		Transport: &http.Transport{
@@ -139,27 +139,36 @@ func (kubeMgr *KubeMgrStruct) DeleteSecret(secretName string) {
 	logger.Printf("DeleteSecret '%s'", secretName)
 }
 
-func (kubeMgr *KubeMgrStruct) CreateSecret(secretName string) (privateKey []byte, publicKey []byte, err error) {
+// GenerateKeys generates an RSA key pair in the format stored in secrets
+func GenerateKeys() (privateKey []byte, publicKey []byte, err error) {
 	bitSize := 4096
 	clientPrivateKey, err := rsa.GenerateKey(rand.Reader, bitSize)
 	if err != nil {
-		return nil, nil, fmt.Errorf("CreateSecret rsa.GenerateKey err: %w", err)
+		return nil, nil, fmt.Errorf("GenerateKeys rsa.GenerateKey err: %w", err)
 	}
 
 	// Validate Private Key
 	err = clientPrivateKey.Validate()
 	if err != nil {
-		return nil, nil, fmt.Errorf("CreateSecret clientPrivateKey.Validate err: %w", err)
+		return nil, nil, fmt.Errorf("GenerateKeys clientPrivateKey.Validate err: %w", err)
 	}
 
 	clientPublicKey, err := ssh.NewPublicKey(&clientPrivateKey.PublicKey)
 	if err != nil {
-		return nil, nil, fmt.Errorf("CreateSecret ssh.NewPublicKey err: %w", err)
+		return nil, nil, fmt.Errorf("GenerateKeys ssh.NewPublicKey err: %w", err)
 	}
 
 	publicKey = ssh.MarshalAuthorizedKey(clientPublicKey)
 
 	privateKey = sshutil.RsaPrivateKeyPEM(clientPrivateKey)
+	return
+}
+
+func (kubeMgr *KubeMgrStruct) CreateSecret(secretName string) (privateKey []byte, publicKey []byte, err error) {
+	privateKey, publicKey, err = GenerateKeys()
+	if err != nil {
+		return nil, nil, fmt.Errorf("CreateSecret: %w", err)
+	}
 
 	secrets := kubeMgr.Client.CoreV1().Secrets(kubeMgr.CocoNamespace)
 	s := corev1.Secret{}
@@ -176,3 +185,22 @@ func (kubeMgr *KubeMgrStruct) CreateSecret(secretName string) (privateKey []byte
 	logger.Printf("CreateSecret '%s'", secretName)
 	return
 }
+
+// UpdateSecret replaces the keys stored in an existing secret
+func (kubeMgr *KubeMgrStruct) UpdateSecret(secretName string, privateKey []byte, publicKey []byte) error {
+	secrets := kubeMgr.Client.CoreV1().Secrets(kubeMgr.CocoNamespace)
+	s, err := secrets.Get(context.Background(), secretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("UpdateSecret secrets.Get err: %w", err)
+	}
+	s.Data = map[string][]byte{}
+	s.Data["privateKey"] = privateKey
+	s.Data["publicKey"] = publicKey
+
+	_, err = secrets.Update(context.Background(), s, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("UpdateSecret secrets.Update err: %w", err)
+	}
+	logger.Printf("UpdateSecret '%s'", secretName)
+	return nil
+}
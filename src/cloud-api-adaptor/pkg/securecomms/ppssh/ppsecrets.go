@@ -1,12 +1,15 @@
 package ppssh
 
 import (
+	"fmt"
+	"sync"
 	"time"
 )
 
 type PpSecrets struct {
 	secrets   map[string][]byte
 	getSecret GetSecret
+	mutex     sync.Mutex
 }
 
 type GetSecret func(name string) ([]byte, error)
@@ -19,6 +22,9 @@ func NewPpSecrets(getSecret GetSecret) *PpSecrets {
 }
 
 func (sec *PpSecrets) AddKey(key string) {
+	sec.mutex.Lock()
+	defer sec.mutex.Unlock()
+
 	if _, ok := sec.secrets[key]; ok {
 		return
 	}
@@ -26,20 +32,37 @@ func (sec *PpSecrets) AddKey(key string) {
 }
 
 func (sec *PpSecrets) GetKey(key string) []byte {
+	sec.mutex.Lock()
+	defer sec.mutex.Unlock()
+
 	return sec.secrets[key]
 }
 
 func (sec *PpSecrets) SetKey(key string, keydata []byte) {
+	sec.mutex.Lock()
+	defer sec.mutex.Unlock()
+
 	sec.secrets[key] = keydata
 }
 
-func (sec *PpSecrets) Go() {
-	sleeptime := time.Duration(1)
+// missingKeys returns the keys that were not obtained yet
+func (sec *PpSecrets) missingKeys() []string {
+	sec.mutex.Lock()
+	defer sec.mutex.Unlock()
 
+	var keys []string
 	for key, keydata := range sec.secrets {
-		if keydata != nil {
-			continue
+		if keydata == nil {
+			keys = append(keys, key)
 		}
+	}
+	return keys
+}
+
+func (sec *PpSecrets) Go() {
+	sleeptime := time.Duration(1)
+
+	for _, key := range sec.missingKeys() {
 		logger.Printf("PpSecrets obtaining key %s", key)
 
 		// loop until we get a valid key
@@ -47,7 +70,7 @@ func (sec *PpSecrets) Go() {
 			keydata, err := sec.getSecret(key)
 			if err == nil && len(keydata) > 0 {
 				logger.Printf("PpSecrets %s success", key)
-				sec.secrets[key] = keydata
+				sec.SetKey(key, keydata)
 				break
 			}
 			if err != nil {
@@ -64,3 +87,31 @@ func (sec *PpSecrets) Go() {
 		}
 	}
 }
+
+// Refresh obtains the current value of all keys once, and keeps the previous values if any key cannot be obtained
+func (sec *PpSecrets) Refresh() error {
+	sec.mutex.Lock()
+	keys := make([]string, 0, len(sec.secrets))
+	for key := range sec.secrets {
+		keys = append(keys, key)
+	}
+	sec.mutex.Unlock()
+
+	refreshed := make(map[string][]byte)
+	for _, key := range keys {
+		keydata, err := sec.getSecret(key)
+		if err != nil {
+			return fmt.Errorf("PpSecrets %s getSecret err: %w", key, err)
+		}
+		if len(keydata) == 0 {
+			return fmt.Errorf("PpSecrets %s getSecret returned an empty secret", key)
+		}
+		refreshed[key] = keydata
+	}
+
+	for key, keydata := range refreshed {
+		sec.SetKey(key, keydata)
+	}
+	logger.Printf("PpSecrets refreshed %d keys", len(refreshed))
+	return nil
+}
@@ -34,6 +34,10 @@ type SshServer struct {
 	sshport   string
 	listener  net.Listener
 	ctx       context.Context
+
+	mutex            sync.Mutex
+	kubernetesConfig *ssh.ServerConfig
+	peer             *sshproxy.SshPeer
 }
 
 // NewSshServer initializes an SSH Server at the PP
@@ -63,12 +67,10 @@ func (s *SshServer) Ready() chan struct{} {
 	return s.readyCh
 }
 
-func (s *SshServer) kubernetesPhase(kubernetesPhaseConfig *ssh.ServerConfig) {
-	ctx, cancel := context.WithCancel(s.ctx)
-	defer cancel()
-
-	var peer *sshproxy.SshPeer
-	for peer == nil && (ctx.Err() == nil) {
+func (s *SshServer) kubernetesPhase() {
+	// Keep accepting connections while serving a peer, so that the client can connect
+	// with rotated keys before it closes the connection that uses the previous keys
+	for s.ctx.Err() == nil {
 		logger.Printf("Kubernetes phase: waiting for client to connect\n")
 		nConn, err := s.listener.Accept()
 		if err != nil {
@@ -76,19 +78,65 @@ func (s *SshServer) kubernetesPhase(kubernetesPhaseConfig *ssh.ServerConfig) {
 		}
 
 		logger.Printf("Kubernetes client connected\n")
-		peer, err = kubernetesSShService(ctx, nConn, kubernetesPhaseConfig)
-		if err != nil {
-			logger.Printf("Retrying after Kubernetes phase failed with: %s", err)
-			peer = nil
-			continue
-		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveKubernetes(nConn)
+		}()
+	}
+}
+
+func (s *SshServer) serveKubernetes(nConn net.Conn) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	peer, err := kubernetesSShService(ctx, nConn, s.getKubernetesConfig())
+	if err != nil {
+		logger.Printf("Kubernetes phase failed with: %s", err)
+		return
+	}
 
-		peer.AddTags(s.inbounds, s.outbounds)
-		peer.Ready()
+	peer.OnRekey(s.rekey)
+	peer.AddTags(s.inbounds, s.outbounds)
+	peer.Ready()
 
-		peer.Wait()
-		logger.Printf("KubernetesSShService exiting")
+	// Inbound connections are tunneled through the latest peer
+	s.mutex.Lock()
+	previous := s.peer
+	s.peer = peer
+	s.mutex.Unlock()
+	if previous != nil {
+		previous.Drain()
 	}
+
+	peer.Wait()
+	logger.Printf("KubernetesSShService exiting")
+}
+
+// rekey obtains the rotated keys and uses them for new Kubernetes phase connections
+func (s *SshServer) rekey() error {
+	if err := s.ppSecrets.Refresh(); err != nil {
+		return err
+	}
+	config, err := initKubernetesPhaseSshConfig(s.ppSecrets)
+	if err != nil {
+		return err
+	}
+	s.setKubernetesConfig(config)
+	logger.Printf("Kubernetes phase: rotated keys are ready")
+	return nil
+}
+
+func (s *SshServer) getKubernetesConfig() *ssh.ServerConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.kubernetesConfig
+}
+
+func (s *SshServer) setKubernetesConfig(config *ssh.ServerConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.kubernetesConfig = config
 }
 
 func (s *SshServer) attestationPhase() *ssh.ServerConfig {
@@ -147,9 +195,8 @@ func (s *SshServer) Start(ctx context.Context) error {
 		if kubernetesPhaseConfig == nil {
 			logger.Fatal("Attestation phase failed")
 		}
-		for ctx.Err() == nil {
-			s.kubernetesPhase(kubernetesPhaseConfig)
-		}
+		s.setKubernetesConfig(kubernetesPhaseConfig)
+		s.kubernetesPhase()
 		s.listener.Close()
 	}()
 	return nil
@@ -0,0 +1,117 @@
+package ppwg
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/ppssh"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/sshutil"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/wgutil"
+)
+
+const (
+	PP_INTERFACE      = "ppwg0"
+	PP_WG_PRIVATE_KEY = "pp-sid/wgPrivateKey" // Peer Pod WireGuard Private Key
+	WN_WG_PUBLIC_KEY  = "wgclient/publicKey"  // Worker Node WireGuard Public Key
+)
+
+var logger = sshutil.Logger
+
+type WgServer struct {
+	ppSecrets *ppssh.PpSecrets
+	ppAddr    netip.Prefix
+	wnAddr    netip.Addr
+	wgport    string
+	readyCh   chan struct{}
+}
+
+// NewWgServer initializes a WireGuard interface at the PP
+// ppAddr is the overlay address of the PP, and wnAddr the overlay address of the WN
+func NewWgServer(ppAddr netip.Prefix, wnAddr netip.Addr, ppSecrets *ppssh.PpSecrets, wgport string) *WgServer {
+	logger.Printf("Using PP SecureComms: InitWgServer version %s", sshutil.PpSecureCommsVersion)
+
+	return &WgServer{
+		ppSecrets: ppSecrets,
+		ppAddr:    ppAddr,
+		wnAddr:    wnAddr,
+		wgport:    wgport,
+		readyCh:   make(chan struct{}),
+	}
+}
+
+func (s *WgServer) Ready() chan struct{} {
+	return s.readyCh
+}
+
+func (s *WgServer) Start(ctx context.Context) error {
+	port, err := strconv.Atoi(s.wgport)
+	if err != nil {
+		return fmt.Errorf("invalid WireGuard port %q: %w", s.wgport, err)
+	}
+
+	go func() {
+		logger.Printf("WireGuard service: getting keys")
+		s.ppSecrets.Go() // wait for the keys
+
+		if err := s.setup(port); err != nil {
+			logger.Fatalf("WireGuard service: %v", err)
+		}
+		logger.Printf("WireGuard service started on port: %s", s.wgport)
+		close(s.readyCh)
+
+		<-ctx.Done()
+		s.teardown()
+	}()
+
+	return nil
+}
+
+func (s *WgServer) setup(port int) error {
+	ns, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to open the current network namespace: %w", err)
+	}
+	defer ns.Close()
+
+	link, err := ns.LinkAdd(PP_INTERFACE, &netops.WireGuard{})
+	if err != nil {
+		return err
+	}
+	if err := wgutil.Configure(PP_INTERFACE, s.ppSecrets.GetKey(PP_WG_PRIVATE_KEY), port); err != nil {
+		return err
+	}
+
+	peer := &wgutil.Peer{
+		PublicKey:  s.ppSecrets.GetKey(WN_WG_PUBLIC_KEY),
+		AllowedIPs: []netip.Prefix{netip.PrefixFrom(s.wnAddr, s.wnAddr.BitLen())},
+	}
+	if err := wgutil.AddPeer(PP_INTERFACE, peer); err != nil {
+		return err
+	}
+
+	if err := link.AddAddr(s.ppAddr); err != nil {
+		return err
+	}
+
+	return link.SetUp()
+}
+
+func (s *WgServer) teardown() {
+	ns, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		logger.Printf("WireGuard service: failed to open the current network namespace: %v", err)
+		return
+	}
+	defer ns.Close()
+
+	link, err := ns.LinkFind(PP_INTERFACE)
+	if err != nil {
+		return
+	}
+	if err := link.Delete(); err != nil {
+		logger.Printf("WireGuard service: failed to delete interface %s: %v", PP_INTERFACE, err)
+	}
+}
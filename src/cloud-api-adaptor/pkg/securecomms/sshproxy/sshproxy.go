@@ -29,6 +29,7 @@ const (
 	BOTH_PHASES       = "BOTH_PHASES"
 	PHASE             = "Phase"
 	UPGRADE           = "Upgrade"
+	REKEY             = "Rekey"
 )
 
 var logger = sshutil.Logger
@@ -45,6 +46,9 @@ type SshPeer struct {
 	upgrade        bool
 	outboundsReady chan bool
 	closeOnce      sync.Once
+	draining       chan struct{}
+	drainOnce      sync.Once
+	rekey          func() error
 }
 
 // Inbound side of the Tunnel - incoming tcp connections from local clients
@@ -93,7 +97,7 @@ func (outbounds *Outbounds) Add(port int, host, name, phase string) {
 	outbound := &Outbound{
 		Phase:   phase,
 		Name:    name,
-		OutAddr: fmt.Sprintf("%s:%d", host, port),
+		OutAddr: net.JoinHostPort(host, strconv.Itoa(port)),
 	}
 	outbounds.list = append(outbounds.list, outbound)
 }
@@ -247,6 +251,7 @@ func NewSshPeer(ctx context.Context, phase string, sshConn ssh.Conn, chans <-cha
 		outbounds:      make(map[string]*Outbound),
 		inbounds:       make(map[string]*Inbound),
 		outboundsReady: make(chan bool),
+		draining:       make(chan struct{}),
 	}
 
 	if chans == nil || sshReqs == nil {
@@ -275,6 +280,26 @@ func NewSshPeer(ctx context.Context, phase string, sshConn ssh.Conn, chans <-cha
 						peer.upgrade = true
 						continue
 					}
+					if phase == KUBERNETES && req.Type == REKEY {
+						<-peer.outboundsReady
+						if peer.rekey == nil {
+							_ = req.Reply(false, nil)
+							continue
+						}
+						logger.Printf("%s phase: peer requested to rekey", phase)
+						// Rekeying fetches keys through the tunnel, so it must not block serving channels
+						peer.wg.Add(1)
+						go func(req *ssh.Request) {
+							defer peer.wg.Done()
+							if err := peer.rekey(); err != nil {
+								logger.Printf("%s phase: rekey failed: %v", phase, err)
+								_ = req.Reply(false, []byte(err.Error()))
+								return
+							}
+							_ = req.Reply(true, nil)
+						}(req)
+						continue
+					}
 					_ = req.Reply(false, nil)
 				}
 
@@ -363,6 +388,37 @@ func (peer *SshPeer) Upgrade() {
 	}
 }
 
+// OnRekey sets the function that reloads the keys when the peer requests to rekey.
+// It must be called before Ready.
+func (peer *SshPeer) OnRekey(rekey func() error) {
+	peer.rekey = rekey
+}
+
+// Rekey requests the peer to reload its keys. Connections established before remain open.
+func (peer *SshPeer) Rekey() error {
+	ok, payload, err := peer.sshConn.SendRequest(REKEY, true, []byte{})
+	if err != nil {
+		return fmt.Errorf("%s phase: rekey request failed: %w", peer.phase, err)
+	}
+	if !ok {
+		return fmt.Errorf("%s phase: peer failed to rekey: %s", peer.phase, string(payload))
+	}
+	return nil
+}
+
+// Drain stops tunneling new inbound connections through the peer, while tunneled connections remain open
+func (peer *SshPeer) Drain() {
+	peer.drainOnce.Do(func() {
+		logger.Printf("%s phase: peer draining", peer.phase)
+		close(peer.draining)
+	})
+}
+
+// Done returns a channel that is closed when the peer is closed
+func (peer *SshPeer) Done() <-chan bool {
+	return peer.done
+}
+
 func (peer *SshPeer) AddTags(inbounds Inbounds, outbounds Outbounds) {
 	peer.AddOutbounds(outbounds)
 	peer.AddInbounds(inbounds)
@@ -387,6 +443,8 @@ func (peer *SshPeer) AddInbound(inbound *Inbound) {
 				NewInboundInstance(*conn, peer, inbound)
 			case <-peer.done:
 				return
+			case <-peer.draining:
+				return
 			}
 		}
 	}()
@@ -84,3 +84,36 @@ func (kc *KbsClient) PostResource(path string, data []byte) error {
 	}
 	return fmt.Errorf("KbsClient failed to set secret at Trustee: %s %s", resp.Status, string([]byte(body)))
 }
+
+// DeleteResource deletes a resource from Trustee. A resource is overwritten with empty data if Trustee does not
+// support deleting resources, so that it is not released anymore.
+func (kc *KbsClient) DeleteResource(path string) error {
+	url := fmt.Sprintf("%s/kbs/v0/resource/%s", kc.url, path)
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("KbsClient failed to create a Delete request - %w", err)
+	}
+	err = kc.addToken(req)
+	if err != nil {
+		return fmt.Errorf("KbsClient: %w", err)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("KbsClient failed to send delete-resource request to Trustee - %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound: // Success or already deleted
+		return nil
+	case http.StatusMethodNotAllowed:
+		return kc.PostResource(path, []byte{})
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("KbsClient while reading the response bytes - %w", err)
+	}
+	return fmt.Errorf("KbsClient failed to delete secret at Trustee: %s %s", resp.Status, string([]byte(body)))
+}
@@ -19,6 +19,9 @@ import (
 
 var logger = sshutil.Logger
 
+// KeyRotationGrace is how long connections tunneled with a rotated key remain open
+var KeyRotationGrace = 5 * time.Minute
+
 type SshClient struct {
 	kc              *KbsClient
 	wnSigner        *ssh.Signer
@@ -26,6 +29,7 @@ type SshClient struct {
 	outboundStrings []string
 	sshport         string
 	wnPublicKey     []byte
+	keyRotation     time.Duration
 }
 
 type SshClientInstance struct {
@@ -40,12 +44,19 @@ type SshClientInstance struct {
 	outbounds       sshproxy.Outbounds
 	inboundPorts    map[string]string
 	wg              sync.WaitGroup
+	mutex           sync.Mutex
+	peer            *sshproxy.SshPeer
 }
 
 func PpSecretName(sid string) string {
 	return "pp-" + sid
 }
 
+// ppSecretPath is the KBS resource path of the private key of a peer pod
+func ppSecretPath(sid string) string {
+	return fmt.Sprintf("default/pp-%s/privateKey", sid)
+}
+
 // InitSshClient initializes an SSH Client at the WN
 // inbound_strings is a slice of strings where each string is an inbound tag
 // outbounds_strings is a slice of strings where each string is an outbound tag
@@ -106,6 +117,15 @@ func InitSshClient(inbound_strings, outbound_strings []string, secureCommsTruste
 	return sshClient, nil
 }
 
+// SetKeyRotation sets the interval at which peer pod keys are rotated through Trustee, 0 disables rotation
+func (c *SshClient) SetKeyRotation(interval time.Duration) {
+	if interval > 0 && c.kc == nil {
+		logger.Printf("Key rotation requires Trustee, keys will not be rotated")
+		return
+	}
+	c.keyRotation = interval
+}
+
 func (ci *SshClientInstance) GetPort(name string) string {
 	var ok bool
 	var inPort string
@@ -154,7 +174,7 @@ func (c *SshClient) InitPP(ctx context.Context, sid string) (ci *SshClientInstan
 
 	if c.kc != nil {
 		// >>> Update the KBS about the SID's Secret !!! <<<
-		sidSecretPath := fmt.Sprintf("default/pp-%s/privateKey", sid)
+		sidSecretPath := ppSecretPath(sid)
 		logger.Printf("Updating KBS with secret for: %s", sidSecretPath)
 		err = c.kc.PostResource(sidSecretPath, ppPrivateKey)
 		if err != nil {
@@ -199,7 +219,7 @@ func (c *SshClient) InitPP(ctx context.Context, sid string) (ci *SshClientInstan
 func (ci *SshClientInstance) Start(ipAddr []netip.Addr) error {
 	ppAddr := make([]string, len(ipAddr))
 	for i, ip := range ipAddr {
-		ppAddr[i] = ip.String() + ":" + ci.sshClient.sshport
+		ppAddr[i] = net.JoinHostPort(ip.String(), ci.sshClient.sshport)
 	}
 
 	ci.ppAddr = ppAddr
@@ -234,22 +254,151 @@ func (ci *SshClientInstance) Start(ipAddr []netip.Addr) error {
 			}
 		}
 	}()
+
+	if ci.sshClient.keyRotation > 0 {
+		ci.wg.Add(1)
+		go func() {
+			defer ci.wg.Done()
+			ticker := time.NewTicker(ci.sshClient.keyRotation)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ci.ctx.Done():
+					return
+				case <-ticker.C:
+					if err := ci.RotateKey(); err != nil {
+						logger.Printf("Key rotation failed: %v", err)
+					}
+				}
+			}
+		}()
+	}
 	return nil
 }
 
 func (ci *SshClientInstance) StartKubernetes() error {
 	ctx, cancel := context.WithCancel(ci.ctx)
 	defer cancel()
-	peer := ci.StartSshClient(ctx, sshproxy.KUBERNETES, ci.ppPublicKey, ci.sid)
+	peer := ci.startKubernetesPeer(ctx)
 	if peer == nil {
 
 		return fmt.Errorf("kubernetes phase: failed StartSshClient")
 	}
+	ci.mutex.Lock()
+	ci.peer = peer
+	ci.mutex.Unlock()
+
+	// Key rotation replaces the peer with a peer connected using the rotated key
+	for peer != nil {
+		peer.Wait()
+		peer = ci.nextPeer(peer)
+	}
+	return nil
+}
+
+func (ci *SshClientInstance) startKubernetesPeer(ctx context.Context) *sshproxy.SshPeer {
+	peer := ci.StartSshClient(ctx, sshproxy.KUBERNETES, ci.getPpPublicKey(), ci.sid)
+	if peer == nil {
+		return nil
+	}
 
 	peer.AddTags(ci.inbounds, ci.outbounds)
 
 	peer.Ready()
-	peer.Wait()
+	return peer
+}
+
+// nextPeer returns the peer that replaced a closed peer, if any
+func (ci *SshClientInstance) nextPeer(peer *sshproxy.SshPeer) *sshproxy.SshPeer {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	if ci.peer == peer {
+		ci.peer = nil
+		return nil
+	}
+	return ci.peer
+}
+
+func (ci *SshClientInstance) getPpPublicKey() []byte {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	return ci.ppPublicKey
+}
+
+// RotateKey replaces the key of the peer pod with a new key delivered through Trustee.
+// A new connection is established with the new key before the previous connection is drained,
+// so that tunneled connections are not dropped.
+func (ci *SshClientInstance) RotateKey() error {
+	if ci.sshClient.kc == nil {
+		return fmt.Errorf("key rotation requires Trustee")
+	}
+
+	ci.mutex.Lock()
+	peer := ci.peer
+	ci.mutex.Unlock()
+	if peer == nil {
+		return fmt.Errorf("kubernetes phase is not connected")
+	}
+
+	ppPrivateKey, ppPublicKey, err := kubemgr.GenerateKeys()
+	if err != nil {
+		return err
+	}
+	ppSshPublicKey, _, _, _, err := ssh.ParseAuthorizedKey(ppPublicKey)
+	if err != nil {
+		return fmt.Errorf("unable to ParseAuthorizedKey serverPublicKey: %w", err)
+	}
+
+	logger.Printf("Rotating key of %s", PpSecretName(ci.sid))
+	if err := ci.sshClient.kc.PostResource(ppSecretPath(ci.sid), ppPrivateKey); err != nil {
+		return fmt.Errorf("failed to PostResource PP Secret: %w", err)
+	}
+
+	// The peer pod obtains the new key from Trustee and uses it for new connections
+	if err := peer.Rekey(); err != nil {
+		return err
+	}
+
+	if err := kubemgr.KubeMgr.UpdateSecret(PpSecretName(ci.sid), ppPrivateKey, ppPublicKey); err != nil {
+		logger.Printf("Failed to store rotated key: %v", err)
+	}
+
+	ci.mutex.Lock()
+	ci.ppPublicKey = ppSshPublicKey.Marshal()
+	ci.mutex.Unlock()
+
+	newPeer := ci.startKubernetesPeer(ci.ctx)
+	if newPeer == nil {
+		// The current connection remains in use until it is reconnected with the new key
+		return fmt.Errorf("kubernetes phase: failed to connect with the rotated key")
+	}
+
+	ci.mutex.Lock()
+	replaced := ci.peer == peer
+	if replaced {
+		ci.peer = newPeer
+	}
+	ci.mutex.Unlock()
+	if !replaced {
+		// The connection was lost and re-established meanwhile
+		newPeer.Close("Superseded")
+		return nil
+	}
+
+	peer.Drain()
+	ci.wg.Add(1)
+	go func() {
+		defer ci.wg.Done()
+		select {
+		case <-time.After(KeyRotationGrace):
+			peer.Close("Key rotated")
+		case <-peer.Done():
+		case <-ci.ctx.Done():
+		}
+	}()
+
+	logger.Printf("Rotated key of %s", PpSecretName(ci.sid))
 	return nil
 }
 
@@ -277,7 +426,7 @@ func (ci *SshClientInstance) StartSshClient(ctx context.Context, phase string, p
 				logger.Printf("%s phase: ssh skipped validating server's host key (type %s) during attestation", phase, key.Type())
 				return nil
 			}
-			if !bytes.Equal(key.Marshal(), ci.ppPublicKey) {
+			if !bytes.Equal(key.Marshal(), publicKey) {
 				logger.Printf("%s phase: ssh host key mismatch - %s", phase, key.Type())
 				return fmt.Errorf("%s phase: ssh host key mismatch", phase)
 			}
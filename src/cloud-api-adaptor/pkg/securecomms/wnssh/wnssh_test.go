@@ -1,11 +1,20 @@
 package wnssh
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/kubemgr"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/ppssh"
@@ -70,3 +79,126 @@ func TestSshProxyReverseKBS(t *testing.T) {
 	ci.DisconnectPP("sid")
 	cancel2()
 }
+
+func TestSshKeyRotation(t *testing.T) {
+	sshport := "6004"
+	kubemgr.InitKubeMgrMock()
+	KeyRotationGrace = time.Second
+
+	s9002 := test.KBSServer("9002")
+	s7122 := test.HttpServer("7122")
+	if s9002 == nil || s7122 == nil {
+		t.Error("Failed - could not create server")
+	}
+	test.CreatePKCS8Secret(t)
+
+	// CAA Initialization
+	sshClient, err := InitSshClient([]string{"KUBERNETES_PHASE:KATAAGENT:0"}, []string{"BOTH_PHASES:KBS:9002"}, true, "127.0.0.1:9002", sshport)
+	if err != nil {
+		log.Fatalf("InitSshClient %v", err)
+	}
+
+	////////// CAA StartVM
+	ipAddr, _ := netip.ParseAddr("127.0.0.1") // ipAddr of the VM
+	ipAddrs := []netip.Addr{ipAddr}
+	ci, _ := sshClient.InitPP(context.Background(), "rotated")
+	if ci == nil {
+		log.Fatalf("failed InitiatePeerPodTunnel")
+	}
+
+	inPort := ci.GetPort("KATAAGENT")
+	if inPort == "" {
+		log.Fatalf("failed find port")
+	}
+
+	// create a podvm
+	gkc := test.NewGetKeyClient("7031")
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	ppSecrets := ppssh.NewPpSecrets(ppssh.GetSecret(gkc.GetKey))
+	ppSecrets.AddKey(ppssh.WN_PUBLIC_KEY)
+	ppSecrets.AddKey(ppssh.PP_PRIVATE_KEY)
+
+	sshServer := ppssh.NewSshServer([]string{"BOTH_PHASES:KBS:7031"}, []string{"KUBERNETES_PHASE:KATAAGENT:127.0.0.1:7122"}, ppSecrets, sshport)
+	_ = sshServer.Start(ctx2)
+
+	if err := ci.Start(ipAddrs); err != nil {
+		log.Fatalf("failed ci.Start: %s", err)
+	}
+
+	if !test.HttpClient(fmt.Sprintf("http://127.0.0.1:%s", inPort)) {
+		t.Fatal("Expected success before key rotation")
+	}
+
+	_, publicKey, _ := kubemgr.KubeMgr.ReadSecret(PpSecretName("rotated"))
+
+	if err := ci.RotateKey(); err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+
+	_, rotatedPublicKey, _ := kubemgr.KubeMgr.ReadSecret(PpSecretName("rotated"))
+	if bytes.Equal(publicKey, rotatedPublicKey) {
+		t.Error("Expected the stored key to be rotated")
+	}
+
+	if !test.HttpClient(fmt.Sprintf("http://127.0.0.1:%s", inPort)) {
+		t.Error("Expected success after key rotation")
+	}
+
+	// The connection with the previous key is closed after the grace period
+	time.Sleep(2 * KeyRotationGrace)
+	if !test.HttpClient(fmt.Sprintf("http://127.0.0.1:%s", inPort)) {
+		t.Error("Expected success after the grace period")
+	}
+
+	////////// CAA StopVM
+	ci.DisconnectPP("rotated")
+}
+
+func TestKbsClientDeleteResource(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+
+	for _, deleteSupported := range []bool{true, false} {
+		var methods []string
+		var posted []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method)
+			if r.URL.Path != "/kbs/v0/resource/default/pp-sid/userdata-key" || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			switch {
+			case r.Method == http.MethodPost:
+				posted, _ = io.ReadAll(r.Body)
+			case r.Method == http.MethodDelete && !deleteSupported:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}))
+
+		kc := InitKbsClient(strings.TrimPrefix(srv.URL, "http://"))
+		if err := kc.SetPemSecret(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+			t.Fatalf("SetPemSecret: %v", err)
+		}
+		if err := kc.DeleteResource("default/pp-sid/userdata-key"); err != nil {
+			t.Errorf("DeleteResource: %v", err)
+		}
+		srv.Close()
+
+		// A resource is overwritten with empty data if Trustee does not support deleting it
+		expected := []string{http.MethodDelete}
+		if !deleteSupported {
+			expected = append(expected, http.MethodPost)
+		}
+		if strings.Join(methods, ",") != strings.Join(expected, ",") || len(posted) != 0 {
+			t.Errorf("expected requests %v, got %v (posted %q)", expected, methods, posted)
+		}
+	}
+}
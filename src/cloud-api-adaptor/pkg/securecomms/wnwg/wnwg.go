@@ -0,0 +1,256 @@
+package wnwg
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/kubemgr"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/sshutil"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/wnssh"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/netops"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/wgutil"
+)
+
+const (
+	WN_INTERFACE = "caa-wg0"
+
+	// Keepalives keep NAT and firewall state of the PP endpoint alive
+	persistentKeepalive = 25
+)
+
+// DefaultOverlay is the default network used for the WireGuard tunnels between the WN and the PPs
+const DefaultOverlay = "10.250.0.0/16"
+
+var logger = sshutil.Logger
+
+type WgClient struct {
+	kc          *wnssh.KbsClient
+	link        netops.Link
+	wgport      string
+	overlay     netip.Prefix
+	wnAddr      netip.Addr
+	wnPublicKey []byte
+	mutex       sync.Mutex
+	ppAddrs     map[netip.Addr]string
+}
+
+type WgClientInstance struct {
+	sid         string
+	ppAddr      netip.Addr
+	ppPublicKey []byte
+	wgClient    *WgClient
+}
+
+// InitWgClient initializes a WireGuard interface at the WN, used as the alternative to
+// the SSH tunnels for communication with the PPs. The WN and the PPs get addresses of the overlay network.
+// The private keys of the PPs are delivered through the KBS only, so Trustee mode is required
+func InitWgClient(secureCommsTrustee bool, kbsAddress string, wgport string, overlay netip.Prefix) (*WgClient, error) {
+	logger.Printf("Using PP SecureComms: InitWgClient version %s", sshutil.PpSecureCommsVersion)
+
+	if !secureCommsTrustee {
+		return nil, fmt.Errorf("the WireGuard transport requires Trustee mode")
+	}
+
+	overlay = overlay.Masked()
+	// The overlay needs the network address, the WN address, a PP address and an IPv4 broadcast address
+	if !overlay.IsValid() || overlay.Bits() > overlay.Addr().BitLen()-2 {
+		return nil, fmt.Errorf("WireGuard overlay network %s is too small", overlay)
+	}
+
+	port, err := strconv.Atoi(wgport)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WireGuard port %q: %w", wgport, err)
+	}
+
+	wnPrivateKey, wnPublicKey, err := wgutil.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	kbscPrivateKey, _, err := kubemgr.KubeMgr.ReadSecret(sshutil.KBS_CLIENT_SECRET)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KBS client secret: %w", err)
+	}
+
+	kc := wnssh.InitKbsClient(kbsAddress)
+	if err := kc.SetPemSecret(kbscPrivateKey); err != nil {
+		return nil, fmt.Errorf("KbsClient - %v", err)
+	}
+
+	wnSecretPath := "default/wgclient/publicKey"
+	logger.Printf("Updating KBS with secret for: %s", wnSecretPath)
+	if err := kc.PostResource(wnSecretPath, wnPublicKey); err != nil {
+		return nil, fmt.Errorf("failed to PostResource WN WireGuard Secret: %v", err)
+	}
+
+	ns, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the current network namespace: %w", err)
+	}
+	defer ns.Close()
+
+	// Recreate the interface, since the keys of a previous run are gone
+	if link, err := ns.LinkFind(WN_INTERFACE); err == nil {
+		if err := link.Delete(); err != nil {
+			return nil, fmt.Errorf("failed to delete interface %s: %w", WN_INTERFACE, err)
+		}
+	}
+
+	link, err := ns.LinkAdd(WN_INTERFACE, &netops.WireGuard{})
+	if err != nil {
+		return nil, err
+	}
+	if err := wgutil.Configure(WN_INTERFACE, wnPrivateKey, port); err != nil {
+		return nil, err
+	}
+
+	wnAddr := overlay.Addr().Next()
+	if err := link.AddAddr(netip.PrefixFrom(wnAddr, overlay.Bits())); err != nil {
+		return nil, err
+	}
+	if err := link.SetUp(); err != nil {
+		return nil, err
+	}
+
+	return &WgClient{
+		kc:          kc,
+		link:        link,
+		wgport:      wgport,
+		overlay:     overlay,
+		wnAddr:      wnAddr,
+		wnPublicKey: wnPublicKey,
+		ppAddrs:     make(map[netip.Addr]string),
+	}, nil
+}
+
+func (c *WgClient) GetWnPublicKey() []byte {
+	return c.wnPublicKey
+}
+
+func (c *WgClient) GetWnAddr() netip.Addr {
+	return c.wnAddr
+}
+
+// GetOverlay returns the overlay network of the WN and the PPs
+func (c *WgClient) GetOverlay() netip.Prefix {
+	return c.overlay
+}
+
+// broadcast returns the last address of an IPv4 network, which is not allocated to PPs
+func broadcast(prefix netip.Prefix) netip.Addr {
+	if !prefix.Addr().Is4() {
+		return netip.Addr{}
+	}
+	addr := prefix.Masked().Addr().As4()
+	for i := prefix.Bits(); i < 32; i++ {
+		addr[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom4(addr)
+}
+
+func (c *WgClient) allocate(sid string) (netip.Addr, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	last := broadcast(c.overlay)
+	for addr := c.wnAddr.Next(); c.overlay.Contains(addr) && addr != last; addr = addr.Next() {
+		if _, used := c.ppAddrs[addr]; used {
+			continue
+		}
+		c.ppAddrs[addr] = sid
+		return addr, nil
+	}
+	return netip.Addr{}, fmt.Errorf("no WireGuard overlay address is available in %s", c.overlay)
+}
+
+func (c *WgClient) release(addr netip.Addr) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.ppAddrs, addr)
+}
+
+// ppSecretPath returns the KBS resource path of the WireGuard private key of a PP
+func ppSecretPath(sid string) string {
+	return fmt.Sprintf("default/pp-%s/wgPrivateKey", sid)
+}
+
+// InitPP generates the WireGuard keys of a PP, stores its private key in the KBS and allocates its overlay address
+func (c *WgClient) InitPP(sid string) (*WgClientInstance, error) {
+	ppPrivateKey, ppPublicKey, err := wgutil.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ppAddr, err := c.allocate(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	ci := &WgClientInstance{
+		sid:         sid,
+		ppAddr:      ppAddr,
+		ppPublicKey: ppPublicKey,
+		wgClient:    c,
+	}
+
+	sidSecretPath := ppSecretPath(sid)
+	logger.Printf("Updating KBS with secret for: %s", sidSecretPath)
+	if err := c.kc.PostResource(sidSecretPath, ppPrivateKey); err != nil {
+		c.release(ppAddr)
+		return nil, fmt.Errorf("failed to PostResource PP WireGuard Secret: %w", err)
+	}
+
+	return ci, nil
+}
+
+// GetPpPrefix returns the overlay address of the PP with the length of the overlay network
+func (ci *WgClientInstance) GetPpPrefix() netip.Prefix {
+	return netip.PrefixFrom(ci.ppAddr, ci.wgClient.overlay.Bits())
+}
+
+// GetPpAddr returns the overlay address of the PP, used to reach the PP services
+func (ci *WgClientInstance) GetPpAddr() netip.Addr {
+	return ci.ppAddr
+}
+
+// Start adds the PP as a peer of the WN interface
+func (ci *WgClientInstance) Start(ipAddr []netip.Addr) error {
+	if len(ipAddr) == 0 {
+		return fmt.Errorf("no IP address for peer pod %s", ci.sid)
+	}
+
+	peer := &wgutil.Peer{
+		PublicKey:           ci.ppPublicKey,
+		Endpoint:            net.JoinHostPort(ipAddr[0].String(), ci.wgClient.wgport),
+		AllowedIPs:          []netip.Prefix{netip.PrefixFrom(ci.ppAddr, ci.ppAddr.BitLen())},
+		PersistentKeepalive: persistentKeepalive,
+	}
+	if err := wgutil.AddPeer(WN_INTERFACE, peer); err != nil {
+		return fmt.Errorf("failed to add WireGuard peer for peer pod %s: %w", ci.sid, err)
+	}
+
+	logger.Printf("WgClientInstance %s: peer %s via %s", ci.sid, ci.ppAddr, peer.Endpoint)
+	return nil
+}
+
+func (ci *WgClientInstance) DisconnectPP() {
+	if err := wgutil.RemovePeer(WN_INTERFACE, ci.ppPublicKey); err != nil {
+		logger.Printf("WgClientInstance %s: failed to remove WireGuard peer: %v", ci.sid, err)
+	}
+	ci.Release()
+	logger.Print("WgClientInstance DisconnectPP success")
+}
+
+// Release deletes the WireGuard private key of a PP from the KBS and releases its overlay address,
+// also when the PP was not started, e.g. when its creation failed. Keys are not left in the KBS, where
+// they would pile up and could be released to a later PP with the same sandbox ID
+func (ci *WgClientInstance) Release() {
+	if err := ci.wgClient.kc.DeleteResource(ppSecretPath(ci.sid)); err != nil {
+		logger.Printf("WgClientInstance %s: failed to delete the WireGuard private key from the KBS: %v", ci.sid, err)
+	}
+	ci.wgClient.release(ci.ppAddr)
+}
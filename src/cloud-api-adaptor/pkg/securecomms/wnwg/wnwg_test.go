@@ -0,0 +1,119 @@
+package wnwg
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/wnssh"
+)
+
+func TestAllocate(t *testing.T) {
+	overlay := netip.MustParsePrefix(DefaultOverlay)
+	c := &WgClient{
+		overlay: overlay,
+		wnAddr:  overlay.Addr().Next(),
+		ppAddrs: make(map[netip.Addr]string),
+	}
+
+	addr1, err := c.allocate("sid1")
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if addr1 != netip.MustParseAddr("10.250.0.2") {
+		t.Errorf("unexpected address %s", addr1)
+	}
+
+	addr2, err := c.allocate("sid2")
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if addr2 == addr1 {
+		t.Errorf("address %s is allocated twice", addr2)
+	}
+
+	c.release(addr1)
+
+	addr3, err := c.allocate("sid3")
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if addr3 != addr1 {
+		t.Errorf("expected released address %s to be reused, got %s", addr1, addr3)
+	}
+}
+
+func TestAllocateExcludesBroadcast(t *testing.T) {
+	overlay := netip.MustParsePrefix("192.168.100.0/30")
+	c := &WgClient{
+		overlay: overlay,
+		wnAddr:  overlay.Addr().Next(),
+		ppAddrs: make(map[netip.Addr]string),
+	}
+
+	addr, err := c.allocate("sid1")
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if addr != netip.MustParseAddr("192.168.100.2") {
+		t.Errorf("unexpected address %s", addr)
+	}
+
+	// 192.168.100.3 is the broadcast address
+	if addr, err := c.allocate("sid2"); err == nil {
+		t.Errorf("expected no address to be available, got %s", addr)
+	}
+}
+
+func TestPpKeyLifecycle(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+
+	var mutex sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, "/kbs/v0/resource/"))
+	}))
+	defer srv.Close()
+
+	kc := wnssh.InitKbsClient(strings.TrimPrefix(srv.URL, "http://"))
+	if err := kc.SetPemSecret(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+		t.Fatalf("SetPemSecret: %v", err)
+	}
+
+	overlay := netip.MustParsePrefix(DefaultOverlay)
+	c := &WgClient{
+		kc:      kc,
+		overlay: overlay,
+		wnAddr:  overlay.Addr().Next(),
+		ppAddrs: make(map[netip.Addr]string),
+	}
+
+	ci, err := c.InitPP("sid1")
+	if err != nil {
+		t.Fatalf("InitPP: %v", err)
+	}
+	ci.Release()
+
+	expected := []string{"POST default/pp-sid1/wgPrivateKey", "DELETE default/pp-sid1/wgPrivateKey"}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected KBS requests %v, got %v", expected, requests)
+	}
+	if len(c.ppAddrs) != 0 {
+		t.Errorf("overlay addresses are not released: %v", c.ppAddrs)
+	}
+}
@@ -0,0 +1,136 @@
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// isUserDataFile checks if path is in the directory of files from ConfigMaps and Secrets
+func isUserDataFile(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && filepath.IsLocal(rel) && filepath.Clean(path) == path
+}
+
+// decodeContent decodes the content of a write_files entry
+func decodeContent(wf WriteFile) ([]byte, error) {
+	switch wf.Encoding {
+	case "":
+		return []byte(wf.Content), nil
+	case "b64", "base64":
+		return base64.StdEncoding.DecodeString(wf.Content)
+	case "gz+b64", "gzip+base64", "gz+base64", "gzip+b64":
+		// Merged cloud-configs may have gzip compressed content, which cloud-init decodes in the same way
+		compressed, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(wf.Content), ""))
+		if err != nil {
+			return nil, err
+		}
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(io.LimitReader(r, maxUserDataSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(content) > maxUserDataSize {
+			return nil, fmt.Errorf("decompressed content exceeds %d bytes", maxUserDataSize)
+		}
+		return content, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", wf.Encoding)
+}
+
+// lookupOwner returns the uid and gid of an owner in the form of user[:group]. Numeric IDs are accepted too
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName, hasGroup := strings.Cut(owner, ":")
+
+	uid, err := strconv.Atoi(userName)
+	gid := -1
+	if err != nil {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return -1, -1, err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+
+	if hasGroup {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return -1, -1, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// appendFile appends content to a file, which is created if it does not exist
+func appendFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(content); err != nil {
+		return fmt.Errorf("failed to append to file %s: %w", path, err)
+	}
+	logger.Printf("Appended to %s\n", path)
+	return nil
+}
+
+// writeUserDataFile writes a file from a ConfigMap or a Secret, or a file that cloud-init does not write, with its permissions and owner
+func writeUserDataFile(wf WriteFile) error {
+	content, err := decodeContent(wf)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", wf.Path, err)
+	}
+
+	mode := os.FileMode(0644)
+	if wf.Permissions != "" {
+		m, err := strconv.ParseUint(wf.Permissions, 8, 32)
+		if err != nil || m > 0o777 {
+			return fmt.Errorf("invalid permissions of %s: %q", wf.Path, wf.Permissions)
+		}
+		mode = os.FileMode(m)
+	}
+
+	if wf.Append == "true" {
+		if err := appendFile(wf.Path, content); err != nil {
+			return err
+		}
+	} else if err := writeFile(wf.Path, content); err != nil {
+		return err
+	}
+	if err := os.Chmod(wf.Path, mode); err != nil {
+		return fmt.Errorf("failed to change permissions of %s: %w", wf.Path, err)
+	}
+
+	if wf.Owner != "" {
+		uid, gid, err := lookupOwner(wf.Owner)
+		if err != nil {
+			return fmt.Errorf("failed to look up owner of %s: %w", wf.Path, err)
+		}
+		if err := os.Chown(wf.Path, uid, gid); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %w", wf.Path, err)
+		}
+	}
+
+	return nil
+}
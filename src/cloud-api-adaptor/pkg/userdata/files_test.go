@@ -0,0 +1,98 @@
+package userdata
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+func TestProcessCloudConfigWithUserDataFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	filesDir := filepath.Join(tempDir, "files")
+
+	caPath := filepath.Join(filesDir, "certs", "ca.crt")
+	keyPath := filepath.Join(filesDir, "tls", "tls.key")
+
+	generated, err := (&cloudinit.CloudConfig{
+		WriteFiles: []cloudinit.WriteFile{
+			{Path: caPath, Encoding: "b64", Content: "Y2VydGlmaWNhdGU="},
+			{Path: keyPath, Encoding: "b64", Permissions: "0600", Content: "a2V5"},
+			{Path: filepath.Join(filesDir, "..", "daemon.json"), Encoding: "b64", Content: "bWFsaWNpb3Vz"},
+		},
+	}).Generate()
+	if err != nil {
+		t.Fatalf("failed to generate cloud config: %v", err)
+	}
+
+	cc, err := retrieveCloudConfig(context.TODO(), &TestProvider{content: generated}, nil)
+	if err != nil {
+		t.Fatalf("couldn't retrieve cloud config: %v", err)
+	}
+
+	cfg := Config{filesDir: filesDir}
+	if err := processCloudConfig(&cfg, cc); err != nil {
+		t.Fatalf("failed to process cloud config file: %v", err)
+	}
+
+	data, _ := os.ReadFile(caPath)
+	if string(data) != "certificate" {
+		t.Fatalf("file content does not match: got %q", data)
+	}
+
+	data, _ = os.ReadFile(keyPath)
+	if string(data) != "key" {
+		t.Fatalf("file content does not match: got %q", data)
+	}
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", keyPath, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("file mode does not match: got %o", info.Mode().Perm())
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "daemon.json")); err == nil {
+		t.Fatalf("file out of the files directory was written")
+	}
+}
+
+func TestIsUserDataFile(t *testing.T) {
+	dir := "/run/peerpod/files"
+
+	for path, want := range map[string]bool{
+		"/run/peerpod/files/ca.crt":       true,
+		"/run/peerpod/files/certs/ca.crt": true,
+		"/run/peerpod/files":              false,
+		"/run/peerpod/files/../aa.toml":   false,
+		"/run/peerpod/aa.toml":            false,
+		"/run/peerpod/filesystem":         false,
+	} {
+		if got := isUserDataFile(path, dir); got != want {
+			t.Errorf("isUserDataFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestRetrieveCloudConfigWithOtherModules(t *testing.T) {
+	config := &cloudinit.CloudConfig{
+		WriteFiles: []cloudinit.WriteFile{{Path: "/run/peerpod/daemon.json", Content: "{}"}},
+	}
+	if err := config.Merge("runcmd:\n- echo hello\nwrite_files:\n- path: /opt/motd\n  append: true\n  content: hello\n"); err != nil {
+		t.Fatalf("failed to merge cloud config: %v", err)
+	}
+	generated, err := config.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate cloud config: %v", err)
+	}
+
+	cc, err := retrieveCloudConfig(context.TODO(), &TestProvider{content: generated}, nil)
+	if err != nil {
+		t.Fatalf("couldn't retrieve cloud config: %v", err)
+	}
+	if len(cc.WriteFiles) != 2 || cc.WriteFiles[0].Content != "{}\n" {
+		t.Fatalf("unexpected write_files: %+v", cc.WriteFiles)
+	}
+}
@@ -3,33 +3,51 @@ package userdata
 import (
 	"context"
 	"os"
+	"strings"
 
-	. "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
 	"github.com/klauspost/cpuid/v2"
 )
 
-func isAzureVM() bool {
-	return cpuid.CPU.HypervisorVendorID == cpuid.MSVM
+func isAzureVM(ctx context.Context) bool {
+	if cpuid.CPU.HypervisorVendorID != cpuid.MSVM {
+		return false
+	}
+	_, err := imdsGet(ctx, AzureImdsUrl, false, []kvPair{{"Metadata", "true"}})
+	return err == nil
 }
 
 func isAWSVM(ctx context.Context) bool {
 	if cpuid.CPU.HypervisorVendorID != cpuid.KVM {
 		return false
 	}
-	_, err := imdsGet(ctx, AWSImdsUrl, false, nil)
+	token, err := awsImdsToken(ctx, AWSImdsTokenUrl)
+	if err != nil {
+		return false
+	}
+	_, err = imdsGet(ctx, AWSImdsUrl, false, []kvPair{{awsImdsTokenHeader, token}})
 	return err == nil
 }
 
+// gceProductName is the DMI product name of GCE instances
+// Ref: https://cloud.google.com/compute/docs/instances/detect-compute-engine
+const gceProductName = "Google Compute Engine"
+
+var dmiProductNamePath = "/sys/class/dmi/id/product_name"
+
 func isGCPVM(ctx context.Context) bool {
+	// DMI tells a GCE instance without a request, which fails until the network is up
+	if productName, err := os.ReadFile(dmiProductNamePath); err == nil {
+		return strings.TrimSpace(string(productName)) == gceProductName
+	}
 	if cpuid.CPU.HypervisorVendorID != cpuid.KVM {
 		return false
 	}
-	_, err := imdsGet(ctx, GcpImdsUrl, false, []kvPair{{"Metadata-Flavor", "Google"}})
+	_, err := gcpMetadataGet(ctx, GcpImdsUrl+"/id")
 	return err == nil
 }
 
-func hasUserDataFile() bool {
-	_, err := os.Stat(UserDataPath)
+func hasUserDataFile(path string) bool {
+	_, err := os.Stat(path)
 	if err != nil && os.IsNotExist(err) {
 		return false
 	}
@@ -0,0 +1,158 @@
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Pod VM images based on Fedora CoreOS or RHCOS are provisioned by Ignition instead of cloud-init.
+// Ignition writes files of the userdata in early boot, and process-user-data converts the same userdata
+// to a cloud-config, so that the files of the Pod VM configuration are processed as with other images.
+// Ref: https://coreos.github.io/ignition/configuration-v3_4/
+
+type ignitionConfig struct {
+	Ignition *struct {
+		Version string `json:"version"`
+		Config  struct {
+			Replace *ignitionResource `json:"replace"`
+		} `json:"config"`
+	} `json:"ignition"`
+	Storage struct {
+		Files []ignitionFile `json:"files"`
+	} `json:"storage"`
+}
+
+type ignitionFile struct {
+	Path     string             `json:"path"`
+	Mode     *int               `json:"mode"`
+	User     *ignitionNode      `json:"user"`
+	Group    *ignitionNode      `json:"group"`
+	Contents *ignitionResource  `json:"contents"`
+	Append   []ignitionResource `json:"append"`
+}
+
+type ignitionNode struct {
+	Name string `json:"name"`
+}
+
+type ignitionResource struct {
+	Source       string `json:"source"`
+	Compression  string `json:"compression"`
+	Verification struct {
+		Hash string `json:"hash"`
+	} `json:"verification"`
+}
+
+// isIgnition returns true if user data is an Ignition config, which is a JSON object with an ignition section
+func isIgnition(userData []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(userData), []byte("{")) {
+		return false
+	}
+	var ign ignitionConfig
+	return json.Unmarshal(userData, &ign) == nil && ign.Ignition != nil
+}
+
+// parseIgnition converts an Ignition config to a cloud-config. A config that is replaced with a remote config
+// is converted to bootstrap user data that points to the remote config.
+func parseIgnition(userData []byte) (*CloudConfig, error) {
+	var ign ignitionConfig
+	if err := json.Unmarshal(userData, &ign); err != nil {
+		return nil, fmt.Errorf("failed to parse ignition config: %w", err)
+	}
+
+	if replace := ign.Ignition.Config.Replace; replace != nil {
+		digest, ok := strings.CutPrefix(replace.Verification.Hash, "sha512-")
+		if !ok {
+			return nil, fmt.Errorf("remote ignition config %s is not verified by a sha512 hash", replace.Source)
+		}
+		return &CloudConfig{Source: &UserDataSource{URL: replace.Source, SHA512: digest}}, nil
+	}
+
+	var cc CloudConfig
+	for _, file := range ign.Storage.Files {
+		wf := WriteFile{Path: file.Path}
+
+		if file.Mode != nil {
+			wf.Permissions = "0" + strconv.FormatInt(int64(*file.Mode), 8)
+		}
+		if file.User != nil {
+			wf.Owner = file.User.Name
+		}
+		if file.Group != nil {
+			wf.Owner += ":" + file.Group.Name
+		}
+
+		resource := file.Contents
+		if len(file.Append) > 0 {
+			if len(file.Append) > 1 || resource != nil {
+				return nil, fmt.Errorf("ignition file %s has more than one content", file.Path)
+			}
+			resource = &file.Append[0]
+			wf.Append = "true"
+		}
+		if resource != nil {
+			content, err := ignitionContent(resource)
+			if err != nil {
+				return nil, fmt.Errorf("invalid content of ignition file %s: %w", file.Path, err)
+			}
+			wf.Content = base64.StdEncoding.EncodeToString(content)
+			wf.Encoding = "b64"
+		}
+
+		cc.WriteFiles = append(cc.WriteFiles, wf)
+	}
+	return &cc, nil
+}
+
+// ignitionContent decodes an inline resource of an Ignition config, which is a data URL (RFC 2397)
+func ignitionContent(resource *ignitionResource) ([]byte, error) {
+	data, ok := strings.CutPrefix(resource.Source, "data:")
+	if !ok {
+		return nil, fmt.Errorf("unsupported source %q, only data URLs are supported", resource.Source)
+	}
+	mediaType, encoded, ok := strings.Cut(data, ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data URL")
+	}
+
+	var content []byte
+	if strings.HasSuffix(mediaType, ";base64") {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		content = decoded
+	} else {
+		unescaped, err := url.PathUnescape(encoded)
+		if err != nil {
+			return nil, err
+		}
+		content = []byte(unescaped)
+	}
+
+	switch resource.Compression {
+	case "":
+		return content, nil
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		decompressed, err := io.ReadAll(io.LimitReader(r, maxUserDataSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decompressed) > maxUserDataSize {
+			return nil, fmt.Errorf("decompressed content exceeds %d bytes", maxUserDataSize)
+		}
+		return decompressed, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", resource.Compression)
+}
@@ -0,0 +1,97 @@
+package userdata
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+// TestProcessIgnition tests processing of ignition user data of CoreOS based pod VM images
+func TestProcessIgnition(t *testing.T) {
+	tempDir := t.TempDir()
+	daemonPath := filepath.Join(tempDir, "daemon.json")
+	motdPath := filepath.Join(tempDir, "motd")
+
+	generated, err := (&cloudinit.IgnitionConfig{
+		CloudConfig: &cloudinit.CloudConfig{
+			WriteFiles: []cloudinit.WriteFile{
+				{Path: daemonPath, Content: "{}", Permissions: "0600"},
+				{Path: motdPath, Content: "hello"},
+			},
+		},
+	}).Generate()
+	if err != nil {
+		t.Fatalf("failed to generate ignition config: %v", err)
+	}
+
+	cc, err := retrieveCloudConfig(context.TODO(), &TestProvider{content: generated}, nil)
+	if err != nil {
+		t.Fatalf("couldn't retrieve ignition config: %v", err)
+	}
+	if len(cc.WriteFiles) != 2 || cc.WriteFiles[0].Permissions != "0600" {
+		t.Fatalf("unexpected cloud config: %#v", cc)
+	}
+
+	// Files are allowed in the same way as files of a cloud-config
+	cfg := Config{writeFiles: []string{daemonPath}}
+	if err := processCloudConfig(&cfg, cc); err != nil {
+		t.Fatalf("failed to process ignition config: %v", err)
+	}
+	if data, err := os.ReadFile(daemonPath); err != nil || string(data) != "{}" {
+		t.Fatalf("unexpected content of %s: %q, %v", daemonPath, data, err)
+	}
+	if _, err := os.Stat(motdPath); err == nil {
+		t.Fatalf("file %s is not allowed", motdPath)
+	}
+
+	// Ignition configs cannot be signed
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	if _, err := parseUserData([]byte(generated), publicKey); err == nil {
+		t.Fatal("expected an error for ignition user data with a signing key")
+	}
+}
+
+// TestRetrieveIgnitionFromSource tests retrieving an ignition config that bootstrap ignition config replaces itself with
+func TestRetrieveIgnitionFromSource(t *testing.T) {
+	generated, err := (&cloudinit.IgnitionConfig{
+		CloudConfig: &cloudinit.CloudConfig{WriteFiles: []cloudinit.WriteFile{{Path: "/test", Content: "test"}}},
+	}).Generate()
+	if err != nil {
+		t.Fatalf("failed to generate ignition config: %v", err)
+	}
+	payload := gzipUserData(t, generated)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(payload); err != nil {
+			http.Error(w, "Error writing response.", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	bootstrap, err := cloudinit.BootstrapUserData(cloudinit.UserDataFormatIgnition, srv.URL+"/userdata.gz", payload)
+	if err != nil {
+		t.Fatalf("failed to generate bootstrap ignition config: %v", err)
+	}
+
+	cc, err := retrieveCloudConfig(context.TODO(), &TestProvider{content: bootstrap}, nil)
+	if err != nil {
+		t.Fatalf("couldn't retrieve ignition config from source: %v", err)
+	}
+	if len(cc.WriteFiles) != 1 || cc.WriteFiles[0].Path != "/test" || cc.Source != nil {
+		t.Fatalf("unexpected cloud config: %#v", cc)
+	}
+
+	bootstrap, err = cloudinit.BootstrapUserData(cloudinit.UserDataFormatIgnition, srv.URL+"/userdata.gz", []byte("other"))
+	if err != nil {
+		t.Fatalf("failed to generate bootstrap ignition config: %v", err)
+	}
+	if _, err := retrieveCloudConfig(context.TODO(), &TestProvider{content: bootstrap}, nil); err == nil {
+		t.Fatal("expected an error for a digest mismatch")
+	}
+}
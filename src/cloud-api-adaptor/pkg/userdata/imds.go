@@ -3,6 +3,7 @@ package userdata
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -61,3 +62,72 @@ func imdsGet(ctx context.Context, url string, b64 bool, headers []kvPair) ([]byt
 	}
 	return decoded, nil
 }
+
+const (
+	awsImdsTokenHeader    = "X-aws-ec2-metadata-token"
+	awsImdsTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	awsImdsTokenTTL       = "300"
+)
+
+// awsImdsToken obtains a session token of AWS IMDSv2, which instances may require for metadata requests
+// Ref: https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/configuring-instance-metadata-service.html
+func awsImdsToken(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %s", err)
+	}
+	req.Header.Set(awsImdsTokenTTLHeader, awsImdsTokenTTL)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("endpoint %s returned != 200 status code: %s", url, resp.Status)
+	}
+
+	token, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %s", err)
+	}
+	return string(token), nil
+}
+
+// errMetadataNotFound is returned when a metadata server doesn't have a requested value
+var errMetadataNotFound = errors.New("metadata not found")
+
+// gcpMetadataGet fetches a value from the GCE metadata server. The server requires the Metadata-Flavor header
+// in requests and sets it in responses, so responses of anything else listening on the address are rejected
+func gcpMetadataGet(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %s", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if flavor := resp.Header.Get("Metadata-Flavor"); flavor != "Google" {
+		return nil, fmt.Errorf("endpoint %s is not the GCE metadata server: Metadata-Flavor is %q", url, flavor)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("endpoint %s: %w", url, errMetadataNotFound)
+	default:
+		return nil, fmt.Errorf("endpoint %s returned != 200 status code: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %s", err)
+	}
+	return body, nil
+}
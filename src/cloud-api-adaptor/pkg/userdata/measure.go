@@ -0,0 +1,65 @@
+package userdata
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
+)
+
+// hasFile checks if a cloud config writes path
+func hasFile(cc *CloudConfig, path string) bool {
+	for _, wf := range cc.WriteFiles {
+		if wf.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// measure extends initdata.MeasurementPCR of the vTPM with a SHA-256 digest. Pod VMs without a vTPM are skipped
+func measure(cfg *Config, name string, digest []byte) error {
+	if cfg.tpmPath == "" {
+		return nil
+	}
+	if _, err := os.Stat(cfg.tpmPath); errors.Is(err, os.ErrNotExist) {
+		logger.Printf("TPM %s not found, %s is not measured.\n", cfg.tpmPath, name)
+		return nil
+	}
+
+	if err := extendPCR(cfg.tpmPath, initdata.MeasurementPCR, digest); err != nil {
+		return fmt.Errorf("failed to measure %s into PCR %d: %w", name, initdata.MeasurementPCR, err)
+	}
+	logger.Printf("Measured %s into PCR %d: %x\n", name, initdata.MeasurementPCR, digest)
+	return nil
+}
+
+// measureInitdata measures the initdata digest written by extractInitdataAndHash
+func measureInitdata(cfg *Config) error {
+	digest, err := os.ReadFile(cfg.digestPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read initdata digest: %w", err)
+	}
+
+	pcrDigest, err := initdata.PCRDigest(string(digest))
+	if err != nil {
+		return err
+	}
+	return measure(cfg, "initdata", pcrDigest)
+}
+
+// measureDaemonConfig measures the SHA-256 digest of the daemon config
+func measureDaemonConfig(cfg *Config) error {
+	data, err := os.ReadFile(cfg.daemonConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read daemon config: %w", err)
+	}
+
+	digest := sha256.Sum256(data)
+	return measure(cfg, "daemon config", digest[:])
+}
@@ -1,24 +1,36 @@
 package userdata
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/avast/retry-go/v4"
 	toml "github.com/pelletier/go-toml/v2"
 	"gopkg.in/yaml.v2"
 
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
 	. "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
 )
 
 const (
@@ -28,45 +40,93 @@ const (
 	// Ref: https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instance-identity-documents.html
 	AWSImdsUrl         = "http://169.254.169.254/latest/dynamic/instance-identity/document"
 	AWSUserDataImdsUrl = "http://169.254.169.254/latest/user-data"
+	AWSImdsTokenUrl    = "http://169.254.169.254/latest/api/token"
 	// Ref: https://docs.microsoft.com/en-us/azure/virtual-machines/linux/instance-metadata-service
 	AzureImdsUrl         = "http://169.254.169.254/metadata/instance/compute?api-version=2021-01-01"
 	AzureUserDataImdsUrl = "http://169.254.169.254/metadata/instance/compute/userData?api-version=2021-01-01&format=text"
-	// Ref: https://cloud.google.com/compute/docs/storing-retrieving-metadata
-	GcpImdsUrl         = "http://metadata.google.internal/computeMetadata/v1/instance"
-	GcpUserDataImdsUrl = "http://metadata.google.internal/computeMetadata/v1/instance/attributes/user-data"
+	// Ref: https://cloud.google.com/compute/docs/metadata/querying-metadata
+	// The metadata server is accessed by its IP address, since DNS may not be configured yet in early boot
+	GcpImdsUrl         = "http://169.254.169.254/computeMetadata/v1/instance"
+	GcpUserDataImdsUrl = "http://169.254.169.254/computeMetadata/v1/instance/attributes/user-data"
+	// GcpUserDataEncodingImdsUrl is the attribute that tells whether user-data is base64 encoded
+	GcpUserDataEncodingImdsUrl = "http://169.254.169.254/computeMetadata/v1/instance/attributes/user-data-encoding"
 )
 
+// maxUserDataSize limits the size of decompressed user data
+const maxUserDataSize = 16 * 1024 * 1024
+
 var logger = log.New(log.Writer(), "[userdata/provision] ", log.LstdFlags|log.Lmsgprefix)
-var WriteFilesList = []string{AACfgPath, CDHCfgPath, ForwarderCfgPath, AuthFilePath, InitDataPath}
+var WriteFilesList = []string{AACfgPath, CDHCfgPath, ForwarderCfgPath, AuthFilePath, InitDataPath, InitDataSourcePath, SealedFilesPath}
 var InitdDataFilesList = []string{AACfgPath, CDHCfgPath, PolicyPath}
 
 type Config struct {
-	fetchTimeout  int
-	digestPath    string
-	initdataPath  string
-	parentPath    string
-	writeFiles    []string
-	initdataFiles []string
+	fetchTimeout        int
+	digestPath          string
+	initdataPath        string
+	initdataSource      string
+	parentPath          string
+	sealedPath          string
+	filesDir            string
+	publicKeyPath       string
+	statusPath          string
+	tpmPath             string
+	daemonConfigPath    string
+	sandboxIDPath       string
+	cloudInitConfigPath string
+	writeFiles          []string
+	initdataFiles       []string
+	getResource         func(string) ([]byte, error)
 }
 
 func NewConfig(fetchTimeout int) *Config {
 	return &Config{
-		fetchTimeout:  fetchTimeout,
-		parentPath:    ConfigParent,
-		initdataPath:  InitDataPath,
-		digestPath:    DigestPath,
-		writeFiles:    WriteFilesList,
-		initdataFiles: InitdDataFilesList,
+		fetchTimeout:        fetchTimeout,
+		parentPath:          ConfigParent,
+		initdataPath:        InitDataPath,
+		initdataSource:      InitDataSourcePath,
+		digestPath:          DigestPath,
+		sealedPath:          SealedFilesPath,
+		filesDir:            UserDataFilesDir,
+		publicKeyPath:       SigningPublicKeyPath,
+		statusPath:          status.DefaultStatusPath,
+		tpmPath:             TPMDevicePath,
+		daemonConfigPath:    ForwarderCfgPath,
+		sandboxIDPath:       SandboxIDPath,
+		cloudInitConfigPath: CloudInitConfigPath,
+		writeFiles:          WriteFilesList,
+		initdataFiles:       InitdDataFilesList,
+		getResource:         newResourceGetter(),
 	}
 }
 
 type WriteFile struct {
-	Path    string `yaml:"path"`
-	Content string `yaml:"content"`
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Owner       string `yaml:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+	Encoding    string `yaml:"encoding,omitempty"`
+	Append      string `yaml:"append,omitempty"`
 }
 
 type CloudConfig struct {
 	WriteFiles []WriteFile `yaml:"write_files"`
+	// Source is set in bootstrap userdata when the actual userdata exceeds the size limit of instance metadata
+	Source *UserDataSource `yaml:"peerpod_userdata,omitempty"`
+	// Modules is other cloud-config modules, such as runcmd merged from pod annotations, which only cloud-init processes
+	Modules map[string]interface{} `yaml:",inline"`
+	// SandboxID is the sandbox that signed user data is bound to
+	SandboxID string `yaml:"-"`
+	// cloudInitSkipped is set if cloud-init does not process the user data, since it is signed or fetched from an
+	// external source, so that process-user-data writes all files and passes other modules to cloud-init
+	cloudInitSkipped bool
+}
+
+// UserDataSource specifies userdata stored outside of instance metadata, e.g. in object storage
+type UserDataSource struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+	// SHA512 is the digest of a remote Ignition config, which is not set in a cloud-config
+	SHA512 string `yaml:"-"`
 }
 
 type UserDataProvider interface {
@@ -88,59 +148,88 @@ func (a AzureUserDataProvider) GetUserData(ctx context.Context) ([]byte, error)
 	return imdsGet(ctx, url, true, []kvPair{{"Metadata", "true"}})
 }
 
-type AWSUserDataProvider struct{ DefaultRetry }
+type AWSUserDataProvider struct {
+	DefaultRetry
+	// tokenUrl and userDataUrl override the URLs of IMDS in tests
+	tokenUrl    string
+	userDataUrl string
+}
 
 func (a AWSUserDataProvider) GetUserData(ctx context.Context) ([]byte, error) {
-	url := AWSUserDataImdsUrl
+	url, tokenUrl := AWSUserDataImdsUrl, AWSImdsTokenUrl
+	if a.userDataUrl != "" {
+		url, tokenUrl = a.userDataUrl, a.tokenUrl
+	}
 	logger.Printf("provider: AWS, userDataUrl: %s\n", url)
+
+	// IMDSv2 requests carry a session token, which works whether or not instances require it
+	token, err := awsImdsToken(ctx, tokenUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IMDSv2 token: %w", err)
+	}
 	// aws user data is not base64 encoded
-	return imdsGet(ctx, url, false, nil)
+	return imdsGet(ctx, url, false, []kvPair{{awsImdsTokenHeader, token}})
 }
 
-type GCPUserDataProvider struct{ DefaultRetry }
+type GCPUserDataProvider struct {
+	DefaultRetry
+	// userDataUrl and encodingUrl override the URLs of the metadata server in tests
+	userDataUrl string
+	encodingUrl string
+}
 
 func (g GCPUserDataProvider) GetUserData(ctx context.Context) ([]byte, error) {
-	url := GcpUserDataImdsUrl
+	url, encodingUrl := GcpUserDataImdsUrl, GcpUserDataEncodingImdsUrl
+	if g.userDataUrl != "" {
+		url, encodingUrl = g.userDataUrl, g.encodingUrl
+	}
 	logger.Printf("provider: GCP, userDataUrl: %s\n", url)
-	return imdsGet(ctx, url, true, []kvPair{{"Metadata-Flavor", "Google"}})
-}
 
-type FileUserDataProvider struct{ DefaultRetry }
+	// cloud-api-adaptor sets user-data-encoding to base64, while user-data without the attribute is plain text
+	encoding, err := gcpMetadataGet(ctx, encodingUrl)
+	if err != nil && !errors.Is(err, errMetadataNotFound) {
+		return nil, err
+	}
 
-func (a FileUserDataProvider) GetUserData(ctx context.Context) ([]byte, error) {
-	path := UserDataPath
-	logger.Printf("provider: File, userDataPath: %s\n", path)
-	userData, err := os.ReadFile(path)
+	userData, err := gcpMetadataGet(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %s", err)
+		return nil, err
 	}
 
-	return userData, nil
-}
-
-func newProvider(ctx context.Context) (UserDataProvider, error) {
-	// This checks for the presence of a file and doesn't rely on http req like the
-	// azure, aws ones, thereby making it faster and hence checking this first
-	if hasUserDataFile() {
-		return FileUserDataProvider{}, nil
+	switch strings.TrimSpace(string(encoding)) {
+	case "":
+		return userData, nil
+	case "base64":
+		decoded, err := base64.StdEncoding.DecodeString(string(userData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode b64 encoded userData: %s", err)
+		}
+		return decoded, nil
 	}
+	return nil, fmt.Errorf("unsupported user-data-encoding: %q", encoding)
+}
 
-	if isAzureVM() {
-		return AzureUserDataProvider{}, nil
-	}
+type FileUserDataProvider struct {
+	DefaultRetry
+	// path is the userdata file, which is UserDataPath of cidata if empty
+	path string
+}
 
-	if isAWSVM(ctx) {
-		return AWSUserDataProvider{}, nil
+func (a FileUserDataProvider) GetUserData(ctx context.Context) ([]byte, error) {
+	path := a.path
+	if path == "" {
+		path = UserDataPath
 	}
-
-	if isGCPVM(ctx) {
-		return GCPUserDataProvider{}, nil
+	logger.Printf("provider: File, userDataPath: %s\n", path)
+	userData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %s", err)
 	}
 
-	return nil, fmt.Errorf("unsupported user data provider")
+	return userData, nil
 }
 
-func retrieveCloudConfig(ctx context.Context, provider UserDataProvider) (*CloudConfig, error) {
+func retrieveCloudConfig(ctx context.Context, provider UserDataProvider, publicKey ed25519.PublicKey) (*CloudConfig, error) {
 	var cc CloudConfig
 
 	// Use retry.Do to retry the getUserData function until it succeeds
@@ -153,10 +242,21 @@ func retrieveCloudConfig(ctx context.Context, provider UserDataProvider) (*Cloud
 			}
 
 			// We parse user data now, b/c we want to retry if it's not valid
-			parsed, err := parseUserData(ud)
+			parsed, err := parseUserData(ud, publicKey)
 			if err != nil {
 				return fmt.Errorf("failed to parse user data: %w", err)
 			}
+
+			if parsed.Source != nil {
+				if parsed, err = retrieveUserDataSource(ctx, parsed.Source, publicKey); err != nil {
+					return err
+				}
+				parsed.cloudInitSkipped = true
+			}
+			// Bootstrap user data is not bound to a sandbox, but the user data that it points to is
+			if publicKey != nil && parsed.SandboxID == "" {
+				return errors.New("signed user data is not bound to a sandbox")
+			}
 			cc = *parsed
 
 			// Valid user data, stop retrying
@@ -174,12 +274,76 @@ func retrieveCloudConfig(ctx context.Context, provider UserDataProvider) (*Cloud
 	return &cc, err
 }
 
-func parseUserData(userData []byte) (*CloudConfig, error) {
+// retrieveUserDataSource fetches userdata that bootstrap userdata points to, and verifies its digest
+func retrieveUserDataSource(ctx context.Context, source *UserDataSource, publicKey ed25519.PublicKey) (*CloudConfig, error) {
+	logger.Printf("fetching user data from external source\n")
+
+	payload, err := imdsGet(ctx, source.URL, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user data from external source: %w", err)
+	}
+
+	if source.SHA512 != "" {
+		sum := sha512.Sum512(payload)
+		if digest := hex.EncodeToString(sum[:]); digest != source.SHA512 {
+			return nil, fmt.Errorf("digest of user data from external source %s does not match %s", digest, source.SHA512)
+		}
+	} else {
+		sum := sha256.Sum256(payload)
+		if digest := hex.EncodeToString(sum[:]); digest != source.SHA256 {
+			return nil, fmt.Errorf("digest of user data from external source %s does not match %s", digest, source.SHA256)
+		}
+	}
+
+	cc, err := parseUserData(payload, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user data from external source: %w", err)
+	}
+	if cc.Source != nil {
+		return nil, fmt.Errorf("user data from external source must not point to another source")
+	}
+	return cc, nil
+}
+
+// parseUserData decompresses user data if needed, and verifies its signature with publicKey unless it is nil
+func parseUserData(userData []byte, publicKey ed25519.PublicKey) (*CloudConfig, error) {
+	// Large user data is gzip compressed to fit in instance metadata
+	if bytes.HasPrefix(userData, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(userData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress user data: %w", err)
+		}
+		decompressed, err := io.ReadAll(io.LimitReader(r, maxUserDataSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress user data: %w", err)
+		}
+		if len(decompressed) > maxUserDataSize {
+			return nil, fmt.Errorf("decompressed user data exceeds %d bytes", maxUserDataSize)
+		}
+		userData = decompressed
+	}
+
+	// Ignition configs are JSON, which cannot have a signature line
+	if isIgnition(userData) {
+		if publicKey != nil {
+			return nil, errors.New("user data is an ignition config, which cannot be signed")
+		}
+		return parseIgnition(userData)
+	}
+
+	content, sandboxID, err := verifyUserData(userData, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
 	var cc CloudConfig
-	err := yaml.UnmarshalStrict(userData, &cc)
+	err = yaml.UnmarshalStrict(content, &cc)
 	if err != nil {
 		return nil, err
 	}
+	cc.SandboxID = sandboxID
+	// cloud-init ignores signed user data, which does not start with #cloud-config
+	cc.cloudInitSkipped = bytes.HasPrefix(userData, []byte(signedHeaderPrefix))
 	return &cc, nil
 }
 
@@ -210,19 +374,151 @@ func isAllowed(path string, filesList []string) bool {
 func processCloudConfig(cfg *Config, cc *CloudConfig) error {
 	for _, wf := range cc.WriteFiles {
 		path := wf.Path
-		bytes := []byte(wf.Content)
 		if isAllowed(path, cfg.writeFiles) {
+			bytes, err := decodeContent(wf)
+			if err != nil {
+				return fmt.Errorf("failed to decode config file %s: %w", path, err)
+			}
 			if err := writeFile(path, bytes); err != nil {
 				return fmt.Errorf("failed to write config file %s: %w", path, err)
 			}
+		} else if (cfg.filesDir != "" && isUserDataFile(path, cfg.filesDir)) || cc.cloudInitSkipped {
+			// Files from ConfigMaps and Secrets referenced by the pod, and other files that cloud-init does not write
+			if err := writeUserDataFile(wf); err != nil {
+				return fmt.Errorf("failed to write file %s: %w", path, err)
+			}
 		} else {
 			logger.Printf("File: %s is not allowed in WriteFiles.\n", path)
 		}
 	}
 
+	if cc.cloudInitSkipped && len(cc.Modules) > 0 {
+		// The config and final stages of cloud-init run after process-user-data, and read the modules from its config
+		modules, err := yaml.Marshal(cc.Modules)
+		if err != nil {
+			return fmt.Errorf("failed to marshal cloud-init modules: %w", err)
+		}
+		if err := writeFile(cfg.cloudInitConfigPath, modules); err != nil {
+			return fmt.Errorf("failed to write cloud-init config: %w", err)
+		}
+	}
+
+	if cc.SandboxID != "" {
+		if err := writeFile(cfg.sandboxIDPath, []byte(cc.SandboxID)); err != nil {
+			return fmt.Errorf("failed to write sandbox ID: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// initdataTLSConfig is the part of the daemon config with the TLS certificates of the agent protocol
+type initdataTLSConfig struct {
+	TLSServerKey  string `json:"tls-server-key"`
+	TLSServerCert string `json:"tls-server-cert"`
+	TLSClientCA   string `json:"tls-client-ca"`
+}
+
+// receiveInitdata receives initdata that cloud-api-adaptor sends instead of including it in userdata. It listens on
+// the forwarder port with the TLS certificates of the daemon config, before agent-protocol-forwarder is started, and
+// verifies the digest of the initdata before writing it to the initdata path
+func receiveInitdata(ctx context.Context, cfg *Config) error {
+	if cfg.initdataSource == "" {
+		return nil
+	}
+	data, err := os.ReadFile(cfg.initdataSource)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read initdata source: %w", err)
+	}
+
+	var source initdata.Source
+	if err := json.Unmarshal(data, &source); err != nil {
+		return fmt.Errorf("failed to parse initdata source: %w", err)
+	}
+
+	daemonConfig, err := os.ReadFile(cfg.daemonConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read daemon config: %w", err)
+	}
+	var certs initdataTLSConfig
+	if err := json.Unmarshal(daemonConfig, &certs); err != nil {
+		return fmt.Errorf("failed to parse daemon config: %w", err)
+	}
+	if certs.TLSServerCert == "" || certs.TLSClientCA == "" {
+		return fmt.Errorf("receiving initdata requires the TLS certificates of the daemon config")
+	}
+
+	// The client certificate of cloud-api-adaptor is required, as for the agent protocol
+	tlsConfig, err := tlsutil.GetTLSConfigFor(&tlsutil.TLSConfig{
+		CertData: []byte(certs.TLSServerCert),
+		KeyData:  []byte(certs.TLSServerKey),
+		CAData:   []byte(certs.TLSClientCA),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create TLS config: %w", err)
+	}
+
+	listener, err := tls.Listen("tcp", net.JoinHostPort("", source.Port), tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to listen for initdata: %w", err)
+	}
+
+	received := make(chan struct{})
+	var once sync.Once
+	mux := http.NewServeMux()
+	mux.HandleFunc(initdata.SendPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUserDataSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256(payload)
+		if digest := hex.EncodeToString(sum[:]); digest != source.SHA256 {
+			logger.Printf("digest of received initdata %s does not match %s\n", digest, source.SHA256)
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		if err := writeFile(cfg.initdataPath, payload); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		once.Do(func() { close(received) })
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+
+	logger.Printf("waiting for initdata from cloud-api-adaptor on port %s\n", source.Port)
+
+	select {
+	case <-received:
+		err = nil
+	case err = <-serverErr:
+		err = fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+		err = fmt.Errorf("initdata was not received: %w", ctx.Err())
+	}
+
+	// The response is completed before the forwarder port is released
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.Printf("failed to shut down initdata receiver: %v\n", shutdownErr)
+	}
+	return err
+}
+
 func extractInitdataAndHash(cfg *Config) error {
 	path := cfg.initdataPath
 	_, err := os.Stat(path)
@@ -243,13 +539,13 @@ func extractInitdataAndHash(cfg *Config) error {
 	if err != nil {
 		return fmt.Errorf("Error base64 decode initdata: %w", err)
 	}
-	initdata := initdata.InitData{}
-	err = toml.Unmarshal(decodedBytes, &initdata)
+	data := initdata.InitData{}
+	err = toml.Unmarshal(decodedBytes, &data)
 	if err != nil {
 		return fmt.Errorf("Error unmarshalling initdata: %w", err)
 	}
 
-	for key, value := range initdata.Data {
+	for key, value := range data.Data {
 		path := filepath.Join(cfg.parentPath, key)
 		if isAllowed(path, cfg.initdataFiles) {
 			if err := writeFile(path, []byte(value)); err != nil {
@@ -260,19 +556,9 @@ func extractInitdataAndHash(cfg *Config) error {
 		}
 	}
 
-	checksumStr := ""
-	switch initdata.Algorithm {
-	case "sha256":
-		hash := sha256.Sum256(decodedBytes)
-		checksumStr = hex.EncodeToString(hash[:])
-	case "sha384":
-		hash := sha512.Sum384(decodedBytes)
-		checksumStr = hex.EncodeToString(hash[:])
-	case "sha512":
-		hash := sha512.Sum512(decodedBytes)
-		checksumStr = hex.EncodeToString(hash[:])
-	default:
-		return fmt.Errorf("Error creating initdata hash, the Algorithm %s not supported", initdata.Algorithm)
+	checksumStr, err := initdata.Digest(data.Algorithm, decodedBytes)
+	if err != nil {
+		return err
 	}
 
 	err = writeFile(cfg.digestPath, []byte(checksumStr)) // the hash in digestPath will also be used by attester
@@ -283,7 +569,23 @@ func extractInitdataAndHash(cfg *Config) error {
 	return nil
 }
 
+// recordPhase reports a boot phase of the pod VM, which cloud-api-adaptor reads through agent-protocol-forwarder
+func recordPhase(cfg *Config, phase status.Phase, phaseErr error) {
+	if cfg.statusPath == "" {
+		return
+	}
+	if err := status.Record(cfg.statusPath, phase, phaseErr); err != nil {
+		logger.Printf("failed to record boot phase %s: %v\n", phase, err)
+	}
+}
+
 func ProvisionFiles(cfg *Config) error {
+	err := provisionFiles(cfg)
+	recordPhase(cfg, status.PhaseUserDataApplied, err)
+	return err
+}
+
+func provisionFiles(cfg *Config) error {
 	bg := context.Background()
 	duration := time.Duration(cfg.fetchTimeout) * time.Second
 	ctx, cancel := context.WithTimeout(bg, duration)
@@ -292,9 +594,15 @@ func ProvisionFiles(cfg *Config) error {
 	// some providers provision config files via process-user-data
 	// some providers rely on cloud-init provision config files
 	// all providers need extract files from initdata and calculate the hash value for attesters usage
-	provider, _ := newProvider(ctx)
-	if provider != nil {
-		cc, err := retrieveCloudConfig(ctx, provider)
+	var hasDaemonConfig bool
+	provider, err := detectProvider(ctx, userDataSources(), detectAttempts, detectDelay)
+	if err == nil {
+		publicKey, err := loadSigningPublicKey(cfg.publicKeyPath)
+		if err != nil {
+			return err
+		}
+
+		cc, err := retrieveCloudConfig(ctx, provider, publicKey)
 		if err != nil {
 			return fmt.Errorf("failed to retrieve cloud config: %w", err)
 		}
@@ -302,13 +610,28 @@ func ProvisionFiles(cfg *Config) error {
 		if err = processCloudConfig(cfg, cc); err != nil {
 			return fmt.Errorf("failed to process cloud config: %w", err)
 		}
+		hasDaemonConfig = hasFile(cc, cfg.daemonConfigPath)
+
+		if err := receiveInitdata(ctx, cfg); err != nil {
+			return fmt.Errorf("failed to receive initdata: %w", err)
+		}
 	} else {
-		logger.Printf("unsupported user data provider, we extract and calculate initdata hash only.\n")
+		logger.Printf("%v, we extract and calculate initdata hash only.\n", err)
 	}
 
 	if err := extractInitdataAndHash(cfg); err != nil {
 		return fmt.Errorf("failed to extract initdata hash: %w", err)
 	}
 
+	// initdata is measured before the daemon config, which may be in sealed files instead
+	if err := measureInitdata(cfg); err != nil {
+		return err
+	}
+	if hasDaemonConfig {
+		if err := measureDaemonConfig(cfg); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
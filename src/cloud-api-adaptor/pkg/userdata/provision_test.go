@@ -1,10 +1,18 @@
 package userdata
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -12,6 +20,11 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/initdata"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/util/tlsutil"
 )
 
 var testDaemonConfig string = `{
@@ -294,13 +307,13 @@ func TestRetrieveCloudConfig(t *testing.T) {
 	var provider TestProvider
 
 	provider = TestProvider{content: "write_files: []"}
-	_, err := retrieveCloudConfig(context.TODO(), &provider)
+	_, err := retrieveCloudConfig(context.TODO(), &provider, nil)
 	if err != nil {
 		t.Fatalf("couldn't retrieve and parse empty cloud config: %v", err)
 	}
 
 	provider = TestProvider{failNext: true, content: "write_files: []"}
-	_, err = retrieveCloudConfig(context.TODO(), &provider)
+	_, err = retrieveCloudConfig(context.TODO(), &provider, nil)
 	if err != nil {
 		t.Fatalf("retry failed: %v", err)
 	}
@@ -311,7 +324,7 @@ write_files:
   content: |
     test
     test`}
-	_, err = retrieveCloudConfig(context.TODO(), &provider)
+	_, err = retrieveCloudConfig(context.TODO(), &provider, nil)
 	if err != nil {
 		t.Fatalf("couldn't retrieve valid cloud config: %v", err)
 	}
@@ -369,7 +382,7 @@ write_files:
 
 	provider := TestProvider{content: content}
 
-	cc, err := retrieveCloudConfig(context.TODO(), &provider)
+	cc, err := retrieveCloudConfig(context.TODO(), &provider, nil)
 	if err != nil {
 		t.Fatalf("couldn't retrieve cloud config: %v", err)
 	}
@@ -460,7 +473,7 @@ write_files:
 
 	provider := TestProvider{content: content}
 
-	cc, err := retrieveCloudConfig(context.TODO(), &provider)
+	cc, err := retrieveCloudConfig(context.TODO(), &provider, nil)
 	if err != nil {
 		t.Fatalf("couldn't retrieve cloud config: %v", err)
 	}
@@ -639,3 +652,329 @@ func TestExtractInitdataWithMalicious(t *testing.T) {
 		t.Fatalf("Should not read malicious file but got %s", string(bytes))
 	}
 }
+
+func gzipUserData(t *testing.T, text string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(text)); err != nil {
+		t.Fatalf("failed to compress user data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to compress user data: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestRetrieveCompressedCloudConfig tests retrieving and parsing of gzip compressed user data
+func TestRetrieveCompressedCloudConfig(t *testing.T) {
+	provider := TestProvider{content: string(gzipUserData(t, `#cloud-config
+write_files:
+- path: /test
+  content: test`))}
+
+	cc, err := retrieveCloudConfig(context.TODO(), &provider, nil)
+	if err != nil {
+		t.Fatalf("couldn't retrieve compressed cloud config: %v", err)
+	}
+	if len(cc.WriteFiles) != 1 || cc.WriteFiles[0].Path != "/test" {
+		t.Fatalf("unexpected cloud config: %#v", cc)
+	}
+}
+
+// TestRetrieveCloudConfigFromSource tests retrieving user data that bootstrap user data points to
+func TestRetrieveCloudConfigFromSource(t *testing.T) {
+	payload := gzipUserData(t, `#cloud-config
+write_files:
+- path: /test
+  content: test`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(payload); err != nil {
+			http.Error(w, "Error writing response.", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(payload)
+
+	bootstrap := func(digest string) string {
+		return fmt.Sprintf("#cloud-config\npeerpod_userdata:\n  url: %s/userdata.gz\n  sha256: %s\n", srv.URL, digest)
+	}
+
+	provider := TestProvider{content: bootstrap(hex.EncodeToString(sum[:]))}
+	cc, err := retrieveCloudConfig(context.TODO(), &provider, nil)
+	if err != nil {
+		t.Fatalf("couldn't retrieve cloud config from source: %v", err)
+	}
+	if len(cc.WriteFiles) != 1 || cc.WriteFiles[0].Path != "/test" || cc.Source != nil {
+		t.Fatalf("unexpected cloud config: %#v", cc)
+	}
+
+	provider = TestProvider{content: bootstrap(strings.Repeat("0", 64))}
+	if _, err := retrieveCloudConfig(context.TODO(), &provider, nil); err == nil {
+		t.Fatal("expected an error for a digest mismatch")
+	}
+}
+
+// TestReceiveInitdata tests receiving initdata that cloud-api-adaptor sends instead of including it in user data
+func TestReceiveInitdata(t *testing.T) {
+	tempDir := t.TempDir()
+	initdataPath := filepath.Join(tempDir, "initdata")
+	sourcePath := filepath.Join(tempDir, "initdata-source.json")
+	daemonConfigPath := filepath.Join(tempDir, "daemon.json")
+
+	cfg := Config{
+		initdataPath:     initdataPath,
+		initdataSource:   sourcePath,
+		daemonConfigPath: daemonConfigPath,
+	}
+
+	// Without a source, initdata is expected in user data
+	if err := receiveInitdata(context.TODO(), &cfg); err != nil {
+		t.Fatalf("receiveInitdata returned err: %v", err)
+	}
+
+	caService, err := tlsutil.NewCAService("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, serverKey, err := caService.Issue("podvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, clientKey, err := tlsutil.NewClientCertificate("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemonConfig, _ := json.Marshal(&initdataTLSConfig{TLSServerCert: string(serverCert), TLSServerKey: string(serverKey), TLSClientCA: string(clientCert)})
+	_ = writeFile(daemonConfigPath, daemonConfig)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	sum := sha256.Sum256([]byte(cc_init_data))
+	_ = writeFile(sourcePath, []byte(fmt.Sprintf(`{"port":"%s","sha256":"%s"}`, port, hex.EncodeToString(sum[:]))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result := make(chan error)
+	go func() {
+		result <- receiveInitdata(ctx, &cfg)
+	}()
+
+	clientTLS, err := tlsutil.GetTLSConfigFor(&tlsutil.TLSConfig{CAData: caService.RootCertificate(), CertData: clientCert, KeyData: clientKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTLS.ServerName = "podvm"
+	// The first request is retried until process-user-data listens
+	send := func(config *tls.Config, data string, attempts uint) (int, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPut, "https://127.0.0.1:"+port+initdata.SendPath, strings.NewReader(data))
+		var resp *http.Response
+		err := retry.Do(func() (err error) {
+			resp, err = client.Do(req)
+			return err
+		}, retry.Context(ctx), retry.Attempts(attempts), retry.Delay(100*time.Millisecond), retry.LastErrorOnly(true))
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if code, err := send(clientTLS, "other initdata", 50); err != nil || code != http.StatusBadRequest {
+		t.Fatalf("expected a digest mismatch, got %d: %v", code, err)
+	}
+
+	// Only cloud-api-adaptor, which has the client certificate, can send initdata
+	anonymous := clientTLS.Clone()
+	anonymous.Certificates = nil
+	if _, err := send(anonymous, cc_init_data, 1); err == nil {
+		t.Fatal("expected an error without a client certificate")
+	}
+
+	if code, err := send(clientTLS, cc_init_data, 1); err != nil || code != http.StatusNoContent {
+		t.Fatalf("failed to send initdata, got %d: %v", code, err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("receiveInitdata returned err: %v", err)
+	}
+	data, _ := os.ReadFile(initdataPath)
+	if string(data) != cc_init_data {
+		t.Fatalf("unexpected initdata: %s", string(data))
+	}
+}
+
+// TestGCPUserDataProvider tests fetching user data from a fake GCE metadata server
+func TestGCPUserDataProvider(t *testing.T) {
+	userData := "#cloud-config\nwrite_files: []\n"
+	attributes := map[string]string{}
+	flavor := "Google"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Metadata-Flavor", flavor)
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "Missing Metadata-Flavor header.", http.StatusForbidden)
+			return
+		}
+		value, ok := attributes[r.URL.Path]
+		if !ok {
+			http.Error(w, "404 not found.", http.StatusNotFound)
+			return
+		}
+		if _, err := io.WriteString(w, value); err != nil {
+			http.Error(w, "Error writing response.", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	provider := GCPUserDataProvider{
+		userDataUrl: srv.URL + "/user-data",
+		encodingUrl: srv.URL + "/user-data-encoding",
+	}
+
+	// user-data set by cloud-api-adaptor is base64 encoded
+	attributes["/user-data"] = base64.StdEncoding.EncodeToString([]byte(userData))
+	attributes["/user-data-encoding"] = "base64"
+	data, err := provider.GetUserData(context.TODO())
+	if err != nil {
+		t.Fatalf("couldn't get user data: %v", err)
+	}
+	if string(data) != userData {
+		t.Fatalf("unexpected user data: %q", data)
+	}
+
+	// user-data without user-data-encoding is plain text
+	attributes["/user-data"] = userData
+	delete(attributes, "/user-data-encoding")
+	if data, err = provider.GetUserData(context.TODO()); err != nil {
+		t.Fatalf("couldn't get user data: %v", err)
+	}
+	if string(data) != userData {
+		t.Fatalf("unexpected user data: %q", data)
+	}
+
+	// Missing user-data is retried by retrieveCloudConfig
+	delete(attributes, "/user-data")
+	if _, err := provider.GetUserData(context.TODO()); err == nil {
+		t.Fatal("expected an error for missing user data")
+	}
+
+	// Responses without the Metadata-Flavor header are not from the GCE metadata server
+	attributes["/user-data"] = userData
+	flavor = ""
+	if _, err := provider.GetUserData(context.TODO()); err == nil {
+		t.Fatal("expected an error for a response without the Metadata-Flavor header")
+	}
+}
+
+// TestAWSUserDataProvider tests fetching user data from a fake AWS IMDS that requires IMDSv2
+func TestAWSUserDataProvider(t *testing.T) {
+	userData := "#cloud-config\nwrite_files: []\n"
+	token := "test-token"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/latest/api/token" && r.Method == http.MethodPut && r.Header.Get(awsImdsTokenTTLHeader) != "":
+			_, _ = io.WriteString(w, token)
+		case r.URL.Path == "/latest/user-data" && r.Header.Get(awsImdsTokenHeader) == "test-token":
+			_, _ = io.WriteString(w, userData)
+		default:
+			http.Error(w, "401 unauthorized.", http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	provider := AWSUserDataProvider{
+		tokenUrl:    srv.URL + "/latest/api/token",
+		userDataUrl: srv.URL + "/latest/user-data",
+	}
+	data, err := provider.GetUserData(context.TODO())
+	if err != nil {
+		t.Fatalf("couldn't get user data: %v", err)
+	}
+	if string(data) != userData {
+		t.Fatalf("unexpected user data: %q", data)
+	}
+
+	// A token that IMDS doesn't accept
+	token = "expired-token"
+	if _, err := provider.GetUserData(context.TODO()); err == nil {
+		t.Fatal("expected an error for a request without a valid token")
+	}
+}
+
+// TestDetectProvider tests that user data sources are probed in order with retries
+func TestDetectProvider(t *testing.T) {
+	var probes []string
+	available := map[string]int{}
+	source := func(name string) userDataSource {
+		return userDataSource{
+			name: name,
+			detect: func(context.Context) bool {
+				probes = append(probes, name)
+				// A source becomes available after the number of failed probes
+				available[name]--
+				return available[name] == 0
+			},
+			provider: &TestProvider{content: name},
+		}
+	}
+	sources := []userDataSource{source("imds"), source("config-drive"), source("vendor-file")}
+
+	available["config-drive"] = 2
+	available["vendor-file"] = 1
+	provider, err := detectProvider(context.TODO(), sources, 3, time.Millisecond)
+	if err != nil {
+		t.Fatalf("couldn't detect a provider: %v", err)
+	}
+	// vendor-file is available in the first attempt, while config-drive that precedes it isn't yet
+	if p := provider.(*TestProvider); p.content != "vendor-file" {
+		t.Fatalf("unexpected provider: %s", p.content)
+	}
+	if strings.Join(probes, ",") != "imds,config-drive,vendor-file" {
+		t.Fatalf("unexpected probes: %v", probes)
+	}
+
+	probes = nil
+	available = map[string]int{"config-drive": 2}
+	if provider, err = detectProvider(context.TODO(), sources, 3, time.Millisecond); err != nil {
+		t.Fatalf("couldn't detect a provider: %v", err)
+	}
+	if p := provider.(*TestProvider); p.content != "config-drive" {
+		t.Fatalf("unexpected provider: %s", p.content)
+	}
+	if strings.Join(probes, ",") != "imds,config-drive,vendor-file,imds,config-drive" {
+		t.Fatalf("unexpected probes: %v", probes)
+	}
+
+	available = map[string]int{}
+	if _, err := detectProvider(context.TODO(), sources, 2, time.Millisecond); !errors.Is(err, errNoUserDataSource) {
+		t.Fatalf("expected errNoUserDataSource, got %v", err)
+	}
+}
+
+func TestUserDataSources(t *testing.T) {
+	var names []string
+	for _, source := range userDataSources() {
+		names = append(names, source.name)
+	}
+
+	// Files are probed before metadata services, and GCE before AWS, whose IMDS listens on the same address
+	expected := []string{
+		"config drive " + paths.UserDataPath,
+		"config drive " + paths.ConfigDriveUserDataPath,
+		"Azure IMDS",
+		"GCP metadata server",
+		"AWS IMDSv2",
+		"vendor file " + paths.VendorUserDataPath,
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected order of sources: %v", names)
+	}
+}
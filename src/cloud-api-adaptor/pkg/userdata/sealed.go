@@ -0,0 +1,139 @@
+package userdata
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/forwarder/status"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/securecomms/apic"
+)
+
+const (
+	// api-server-rest of the confidential data hub listens in the pod network namespace
+	apiServerRestPort = 8006
+	podNamespacePath  = "/run/netns/podns"
+
+	unsealRetryDelay = 5 * time.Second
+)
+
+// SealedFiles contains files of user data encrypted with a key that Trustee releases after attestation
+type SealedFiles struct {
+	Resource string `json:"resource"`
+	Data     string `json:"data"`
+}
+
+func newResourceGetter() func(string) ([]byte, error) {
+	return apic.NewApiClient(apiServerRestPort, podNamespacePath).GetResource
+}
+
+// unsealCloudConfig decrypts sealed files with key, which is AES-256-GCM authenticated with the resource path
+func unsealCloudConfig(sealed *SealedFiles, key []byte) (*CloudConfig, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sealed files: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key of sealed files: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key of sealed files: %w", err)
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("sealed files are too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(sealed.Resource))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sealed files: %w", err)
+	}
+
+	// Sealed files are authenticated by the cipher
+	cc, err := parseUserData(plaintext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sealed files: %w", err)
+	}
+	if cc.Source != nil {
+		return nil, fmt.Errorf("sealed files must not point to another source")
+	}
+	return cc, nil
+}
+
+// UnsealFiles decrypts sealed files written by ProvisionFiles, and writes them.
+// The key is obtained from the confidential data hub, which releases it only after successful attestation.
+func UnsealFiles(cfg *Config) error {
+	content, err := os.ReadFile(cfg.sealedPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Printf("File %s not found, no files to unseal.\n", cfg.sealedPath)
+			return nil
+		}
+		return fmt.Errorf("failed to read sealed files: %w", err)
+	}
+
+	var sealed SealedFiles
+	if err := json.Unmarshal(content, &sealed); err != nil {
+		return fmt.Errorf("failed to parse sealed files: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.fetchTimeout)*time.Second)
+	defer cancel()
+
+	var key []byte
+
+	// The confidential data hub may not be ready yet, and attestation may take a while
+	err = retry.Do(
+		func() error {
+			key, err = cfg.getResource(sealed.Resource)
+			return err
+		},
+		retry.Context(ctx),
+		retry.Delay(unsealRetryDelay),
+		retry.LastErrorOnly(true),
+		retry.DelayType(retry.FixedDelay),
+		retry.OnRetry(func(n uint, err error) {
+			logger.Printf("Retry attempt %d: %v\n", n, err)
+		}),
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to get key of sealed files %s: %w", sealed.Resource, err)
+		recordPhase(cfg, status.PhaseAttested, err)
+		return err
+	}
+	// The proof tells cloud-api-adaptor that the key was released by Trustee
+	if cfg.statusPath != "" {
+		if err := status.RecordAttested(cfg.statusPath, status.AttestationProof(key, sealed.Resource)); err != nil {
+			logger.Printf("failed to record boot phase %s: %v\n", status.PhaseAttested, err)
+		}
+	}
+
+	cc, err := unsealCloudConfig(&sealed, key)
+	if err != nil {
+		return err
+	}
+
+	if err := processCloudConfig(cfg, cc); err != nil {
+		return fmt.Errorf("failed to process sealed files: %w", err)
+	}
+
+	if hasFile(cc, cfg.daemonConfigPath) {
+		if err := measureDaemonConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package userdata
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+func TestUnsealFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	daemonPath := filepath.Join(tempDir, "daemon.json")
+	sealedPath := filepath.Join(tempDir, "sealed-files.json")
+	resource := "default/pp-123/userdata-key"
+
+	key, err := cloudinit.NewSealKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	sealed, err := cloudinit.SealFiles([]cloudinit.WriteFile{
+		{Path: daemonPath, Content: testDaemonConfig},
+		{Path: filepath.Join(tempDir, "malicious"), Content: "malicious"},
+	}, resource, key)
+	if err != nil {
+		t.Fatalf("failed to seal files: %v", err)
+	}
+	if err := os.WriteFile(sealedPath, []byte(sealed.Content), 0600); err != nil {
+		t.Fatalf("failed to write sealed files: %v", err)
+	}
+
+	attempts := 0
+	cfg := Config{
+		fetchTimeout: 30,
+		sealedPath:   sealedPath,
+		writeFiles:   []string{daemonPath},
+		getResource: func(path string) ([]byte, error) {
+			if path != resource {
+				t.Fatalf("unexpected resource: %s", path)
+			}
+			// The first attempt fails as if attestation were still in progress
+			if attempts++; attempts == 1 {
+				return nil, errors.New("attestation in progress")
+			}
+			return key, nil
+		},
+	}
+
+	if err := UnsealFiles(&cfg); err != nil {
+		t.Fatalf("failed to unseal files: %v", err)
+	}
+
+	data, _ := os.ReadFile(daemonPath)
+	if string(data) != testDaemonConfig {
+		t.Fatalf("file content does not match daemon config fixture: got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "malicious")); err == nil {
+		t.Fatalf("file not in the allowed list was written")
+	}
+
+	// A key of another pod VM must not decrypt the files
+	otherKey, _ := cloudinit.NewSealKey()
+	cfg.getResource = func(string) ([]byte, error) { return otherKey, nil }
+	if err := UnsealFiles(&cfg); err == nil {
+		t.Fatalf("expected an error with a wrong key")
+	}
+}
+
+func TestUnsealFilesWithoutSealedFiles(t *testing.T) {
+	cfg := Config{
+		sealedPath: filepath.Join(t.TempDir(), "sealed-files.json"),
+		getResource: func(string) ([]byte, error) {
+			t.Fatalf("unexpected key request")
+			return nil, nil
+		},
+	}
+
+	if err := UnsealFiles(&cfg); err != nil {
+		t.Fatalf("expected no error without sealed files: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package userdata
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SigningPublicKeyPath is the path of the public key that verifies user data.
+// It is installed in pod VM images, and user data must be signed if it exists.
+const SigningPublicKeyPath = "/etc/peerpod/userdata-signing.pub"
+
+// signaturePrefix starts the last line of signed user data
+const signaturePrefix = "#peerpod-signature: ed25519:"
+
+// signedHeaderPrefix starts the first line of signed user data, which is followed by the sandbox ID that it is bound to.
+// cloud-init ignores signed user data, since it does not start with #cloud-config.
+const signedHeaderPrefix = "#peerpod-signed-userdata: "
+
+// loadSigningPublicKey loads a PEM encoded Ed25519 public key. It returns nil if the key does not exist
+func loadSigningPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Printf("File %s not found, user data signature is not verified.\n", path)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read user data signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode user data signing key %s: no PEM data", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user data signing key %s: %w", path, err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("user data signing key %s is %T, not an Ed25519 key", path, key)
+	}
+	return publicKey, nil
+}
+
+// verifyUserData verifies the signature line of user data with publicKey, and returns user data without the header
+// and signature lines, and the sandbox ID that it is bound to. Signed user data is not verified if publicKey is nil.
+func verifyUserData(userData []byte, publicKey ed25519.PublicKey) ([]byte, string, error) {
+	if !bytes.HasPrefix(userData, []byte(signedHeaderPrefix)) {
+		if publicKey != nil {
+			return nil, "", errors.New("user data is not signed")
+		}
+		return userData, "", nil
+	}
+
+	i := bytes.LastIndex(userData, []byte("\n"+signaturePrefix))
+	if i < 0 {
+		return nil, "", errors.New("signed user data has no signature")
+	}
+	signed, line := userData[:i+1], userData[i+1+len(signaturePrefix):]
+
+	if publicKey != nil {
+		signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimRight(line, "\r\n")))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid user data signature: %w", err)
+		}
+		if !ed25519.Verify(publicKey, signed, signature) {
+			return nil, "", errors.New("user data signature verification failed")
+		}
+		logger.Printf("user data signature verified\n")
+	}
+
+	header, content, _ := bytes.Cut(signed, []byte("\n"))
+	return content, string(header[len(signedHeaderPrefix):]), nil
+}
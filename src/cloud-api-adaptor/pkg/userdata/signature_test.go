@@ -0,0 +1,153 @@
+package userdata
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+func TestRetrieveSignedCloudConfig(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	publicKeyPath := filepath.Join(t.TempDir(), "userdata-signing.pub")
+	if err := os.WriteFile(publicKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatalf("failed to write public key: %v", err)
+	}
+	loaded, err := loadSigningPublicKey(publicKeyPath)
+	if err != nil {
+		t.Fatalf("failed to load public key: %v", err)
+	}
+
+	cloudinit.SetSigningKey(privateKey)
+	defer cloudinit.SetSigningKey(nil)
+
+	signed, err := (&cloudinit.CloudConfig{
+		WriteFiles: []cloudinit.WriteFile{{Path: "/run/peerpod/daemon.json", Content: testDaemonConfig}},
+		SandboxID:  "0123456789abcdef",
+	}).Generate()
+	if err != nil {
+		t.Fatalf("failed to generate cloud config: %v", err)
+	}
+
+	cc, err := retrieveCloudConfig(context.TODO(), &TestProvider{content: signed}, loaded)
+	if err != nil {
+		t.Fatalf("couldn't retrieve signed cloud config: %v", err)
+	}
+	if len(cc.WriteFiles) != 1 || cc.WriteFiles[0].Content != testDaemonConfig {
+		t.Fatalf("unexpected write_files: %+v", cc.WriteFiles)
+	}
+	if cc.SandboxID != "0123456789abcdef" || !cc.cloudInitSkipped {
+		t.Fatalf("unexpected sandbox of signed user data: %q", cc.SandboxID)
+	}
+
+	// Compressed user data is verified after decompression
+	compressed, err := cloudinit.CompressUserData([]byte(signed))
+	if err != nil {
+		t.Fatalf("failed to compress user data: %v", err)
+	}
+	if _, err := parseUserData(compressed, loaded); err != nil {
+		t.Fatalf("couldn't parse compressed signed user data: %v", err)
+	}
+
+	tampered := strings.Replace(signed, "/run/peerpod/daemon.json", "/run/peerpod/auth.json", 1)
+	if _, err := parseUserData([]byte(tampered), loaded); err == nil {
+		t.Fatalf("expected an error with tampered user data")
+	}
+
+	// Signed user data of another pod VM cannot be bound to this pod VM
+	replayed := strings.Replace(signed, "0123456789abcdef", "fedcba9876543210", 1)
+	if _, err := parseUserData([]byte(replayed), loaded); err == nil {
+		t.Fatalf("expected an error with user data bound to another sandbox")
+	}
+
+	unbound, err := (&cloudinit.CloudConfig{}).Generate()
+	if err != nil {
+		t.Fatalf("failed to generate cloud config: %v", err)
+	}
+	if _, err := retrieveCloudConfig(context.TODO(), &TestProvider{content: unbound}, loaded); err == nil {
+		t.Fatalf("expected an error with user data that is not bound to a sandbox")
+	}
+
+	cloudinit.SetSigningKey(nil)
+	unsigned, _ := (&cloudinit.CloudConfig{}).Generate()
+	if _, err := parseUserData([]byte(unsigned), loaded); err == nil {
+		t.Fatalf("expected an error with unsigned user data")
+	}
+
+	// Without a public key, signature is not verified
+	if _, err := parseUserData([]byte(signed), nil); err != nil {
+		t.Fatalf("couldn't parse signed user data without a public key: %v", err)
+	}
+	if key, err := loadSigningPublicKey(filepath.Join(t.TempDir(), "missing.pub")); key != nil || err != nil {
+		t.Fatalf("expected no key and no error without a public key file: %v %v", key, err)
+	}
+}
+
+func TestProcessSignedCloudConfig(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	cloudinit.SetSigningKey(privateKey)
+	defer cloudinit.SetSigningKey(nil)
+
+	tempDir := t.TempDir()
+	motdPath := filepath.Join(tempDir, "opt", "motd")
+	if err := os.MkdirAll(filepath.Dir(motdPath), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(motdPath, []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	config := &cloudinit.CloudConfig{
+		WriteFiles: []cloudinit.WriteFile{{Path: motdPath, Append: "true", Encoding: "b64", Content: "d29ybGQ="}},
+		SandboxID:  "0123456789abcdef",
+	}
+	if err := config.Merge("runcmd:\n- echo hello\n"); err != nil {
+		t.Fatalf("failed to merge cloud config: %v", err)
+	}
+	signed, err := config.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate cloud config: %v", err)
+	}
+
+	cc, err := parseUserData([]byte(signed), nil)
+	if err != nil {
+		t.Fatalf("couldn't parse signed user data: %v", err)
+	}
+
+	// cloud-init ignores signed user data, so process-user-data applies all of it
+	cfg := Config{
+		sandboxIDPath:       filepath.Join(tempDir, "sandbox-id"),
+		cloudInitConfigPath: filepath.Join(tempDir, "cloud.cfg.d", "99-peerpod-userdata.cfg"),
+	}
+	if err := processCloudConfig(&cfg, cc); err != nil {
+		t.Fatalf("failed to process cloud config: %v", err)
+	}
+
+	if data, _ := os.ReadFile(motdPath); string(data) != "hello\nworld" {
+		t.Fatalf("file content does not match: got %q", data)
+	}
+	if data, _ := os.ReadFile(cfg.sandboxIDPath); string(data) != "0123456789abcdef" {
+		t.Fatalf("sandbox ID does not match: got %q", data)
+	}
+	if data, _ := os.ReadFile(cfg.cloudInitConfigPath); string(data) != "runcmd:\n- echo hello\n" {
+		t.Fatalf("cloud-init config does not match: got %q", data)
+	}
+}
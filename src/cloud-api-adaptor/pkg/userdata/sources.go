@@ -0,0 +1,81 @@
+package userdata
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+
+	. "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/paths"
+)
+
+// Detection of a userdata source is retried, since metadata services may not be reachable until the network is up,
+// and config drives may not be mounted yet. The attempts are bounded, so that pod VMs of providers that rely on
+// cloud-init only don't wait for the whole fetch timeout.
+const (
+	detectAttempts = 3
+	detectDelay    = 2 * time.Second
+	probeTimeout   = 3 * time.Second
+)
+
+var errNoUserDataSource = errors.New("no userdata source is available")
+
+// userDataSource is a source of userdata that process-user-data probes
+type userDataSource struct {
+	name     string
+	detect   func(ctx context.Context) bool
+	provider UserDataProvider
+}
+
+// userDataSources returns the sources of userdata in the order that they are probed,
+// so that a pod VM image works with any provider. Config drives are probed first, since checking for a file
+// is faster than requests to metadata services. GCE is probed before AWS, since its metadata server listens on
+// the same address as AWS IMDS. The vendor file of an image is a fallback for providers without userdata.
+func userDataSources() []userDataSource {
+	fileExists := func(path string) func(context.Context) bool {
+		return func(context.Context) bool { return hasUserDataFile(path) }
+	}
+
+	return []userDataSource{
+		{"config drive " + UserDataPath, fileExists(UserDataPath), FileUserDataProvider{path: UserDataPath}},
+		{"config drive " + ConfigDriveUserDataPath, fileExists(ConfigDriveUserDataPath), FileUserDataProvider{path: ConfigDriveUserDataPath}},
+		{"Azure IMDS", isAzureVM, AzureUserDataProvider{}},
+		{"GCP metadata server", isGCPVM, GCPUserDataProvider{}},
+		{"AWS IMDSv2", isAWSVM, AWSUserDataProvider{}},
+		{"vendor file " + VendorUserDataPath, fileExists(VendorUserDataPath), FileUserDataProvider{path: VendorUserDataPath}},
+	}
+}
+
+// detectProvider probes sources in order, and returns the provider of the first available one
+func detectProvider(ctx context.Context, sources []userDataSource, attempts uint, delay time.Duration) (UserDataProvider, error) {
+	var provider UserDataProvider
+
+	err := retry.Do(
+		func() error {
+			for _, source := range sources {
+				probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+				available := source.detect(probeCtx)
+				cancel()
+
+				if available {
+					logger.Printf("userdata source: %s\n", source.name)
+					provider = source.provider
+					return nil
+				}
+				logger.Printf("userdata source %s is not available\n", source.name)
+			}
+			return errNoUserDataSource
+		},
+		retry.Context(ctx),
+		retry.Attempts(attempts),
+		retry.Delay(delay),
+		retry.LastErrorOnly(true),
+		retry.DelayType(retry.FixedDelay),
+		retry.OnRetry(func(n uint, err error) {
+			logger.Printf("Retry attempt %d: %v\n", n, err)
+		}),
+	)
+
+	return provider, err
+}
@@ -0,0 +1,90 @@
+package userdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TPMDevicePath is the in-kernel resource manager of the vTPM, which serializes commands of concurrent users
+const TPMDevicePath = "/dev/tpmrm0"
+
+// TPM 2.0 constants of TPM2_PCR_Extend
+// Ref: https://trustedcomputinggroup.org/resource/tpm-library-specification/ Part 2 and Part 3
+const (
+	tpmSTSessions  uint16 = 0x8002
+	tpmCCPCRExtend uint32 = 0x00000182
+	tpmRSPW        uint32 = 0x40000009
+	tpmAlgSHA256   uint16 = 0x000b
+	tpmRCSuccess   uint32 = 0
+	tpmHeaderSize         = 10
+	tpmMaxResponse        = 4096
+)
+
+// pcrExtendCommand encodes TPM2_PCR_Extend of the SHA-256 bank of pcr with a password session of the empty auth value
+func pcrExtendCommand(pcr uint32, digest []byte) []byte {
+	var body bytes.Buffer
+
+	write := func(v interface{}) {
+		_ = binary.Write(&body, binary.BigEndian, v)
+	}
+
+	// pcrHandle
+	write(pcr)
+	// Authorization area: TPMS_AUTH_COMMAND with an empty nonce and hmac
+	write(uint32(4 + 2 + 1 + 2))
+	write(tpmRSPW)
+	write(uint16(0))
+	write(uint8(0))
+	write(uint16(0))
+	// TPML_DIGEST_VALUES
+	write(uint32(1))
+	write(tpmAlgSHA256)
+	body.Write(digest)
+
+	var cmd bytes.Buffer
+	_ = binary.Write(&cmd, binary.BigEndian, tpmSTSessions)
+	_ = binary.Write(&cmd, binary.BigEndian, uint32(tpmHeaderSize+body.Len()))
+	_ = binary.Write(&cmd, binary.BigEndian, tpmCCPCRExtend)
+	cmd.Write(body.Bytes())
+
+	return cmd.Bytes()
+}
+
+// checkResponse checks the response code in the header of a TPM response
+func checkResponse(res []byte) error {
+	if len(res) < tpmHeaderSize {
+		return fmt.Errorf("TPM response is too short: %d bytes", len(res))
+	}
+	if rc := binary.BigEndian.Uint32(res[6:10]); rc != tpmRCSuccess {
+		return fmt.Errorf("TPM returned error code 0x%x", rc)
+	}
+	return nil
+}
+
+// extendPCR extends the SHA-256 bank of pcr of the TPM at devicePath with a SHA-256 digest
+func extendPCR(devicePath string, pcr uint32, digest []byte) error {
+	if len(digest) != 32 {
+		return errors.New("PCR digest must be 32 bytes")
+	}
+
+	device, err := os.OpenFile(devicePath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open TPM %s: %w", devicePath, err)
+	}
+	defer device.Close()
+
+	if _, err := device.Write(pcrExtendCommand(pcr, digest)); err != nil {
+		return fmt.Errorf("failed to send TPM2_PCR_Extend: %w", err)
+	}
+
+	res := make([]byte, tpmMaxResponse)
+	n, err := device.Read(res)
+	if err != nil {
+		return fmt.Errorf("failed to read response of TPM2_PCR_Extend: %w", err)
+	}
+
+	return checkResponse(res[:n])
+}
@@ -0,0 +1,39 @@
+package userdata
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPCRExtendCommand(t *testing.T) {
+	digest := bytes.Repeat([]byte{0xab}, 32)
+
+	cmd := pcrExtendCommand(8, digest)
+
+	expected, _ := hex.DecodeString("8002" + "00000041" + "00000182" + // header
+		"00000008" + // pcrHandle
+		"00000009" + "40000009" + "0000" + "00" + "0000" + // password session
+		"00000001" + "000b") // TPML_DIGEST_VALUES
+	expected = append(expected, digest...)
+
+	if !bytes.Equal(cmd, expected) {
+		t.Fatalf("expected %x, got %x", expected, cmd)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	success, _ := hex.DecodeString("80020000001300000000" + "00000000" + "0000" + "00" + "00")
+	if err := checkResponse(success); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	failure, _ := hex.DecodeString("80010000000a00000921")
+	if err := checkResponse(failure); err == nil {
+		t.Error("expected an error for a failed response")
+	}
+
+	if err := checkResponse([]byte{0x80}); err == nil {
+		t.Error("expected an error for a short response")
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/containerd/ttrpc"
 	"github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols"
@@ -17,6 +19,8 @@ import (
 	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
 )
 
+var logger = log.New(log.Writer(), "[util/agentproto] ", log.LstdFlags|log.Lmsgprefix)
+
 type Redirector interface {
 	pb.AgentServiceService
 	pb.HealthService
@@ -29,379 +33,520 @@ type redirector struct {
 	agentClient *client
 	ttrpcClient *ttrpc.Client
 	dialer      func(context.Context) (net.Conn, error)
-	once        sync.Once
+	keepalive   time.Duration
+	mutex       sync.Mutex
+	// dialing is closed when the connection that is being established is established or fails
+	dialing chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
+// maxReconnectBackoff is the longest interval between attempts to re-establish a dropped connection
+const maxReconnectBackoff = time.Minute
+
 type client struct {
 	pb.AgentServiceService
 	pb.HealthService
+
+	ttrpcClient *ttrpc.Client
+}
+
+type RedirectorOption func(*redirector)
+
+// WithKeepalive enables application level keepalives. A health check is sent over
+// the connection every interval, and the connection is re-established when it fails.
+func WithKeepalive(interval time.Duration) RedirectorOption {
+	return func(s *redirector) {
+		s.keepalive = interval
+	}
 }
 
-func NewRedirector(dialer func(context.Context) (net.Conn, error)) Redirector {
+func NewRedirector(dialer func(context.Context) (net.Conn, error), opts ...RedirectorOption) Redirector {
 
-	return &redirector{
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &redirector{
 		dialer: dialer,
+		ctx:    ctx,
+		cancel: cancel,
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
+// Connect establishes a connection unless one is already established.
+// A connection that was dropped is re-established by the dialer.
 func (s *redirector) Connect(ctx context.Context) error {
+	_, err := s.connect(ctx)
+	return err
+}
 
-	var err error
-
-	s.once.Do(func() {
+// connect returns the established connection, or establishes one. The connection is dialed without holding
+// the mutex, so that calls that fail fast, like Close, are not blocked by a slow dialer, and concurrent
+// callers wait for the connection that is being established instead of dialing their own.
+func (s *redirector) connect(ctx context.Context) (*client, error) {
+	for {
+		s.mutex.Lock()
 
-		conn, e := s.dialer(ctx)
-		if e != nil {
-			err = e
-			return
+		if s.ctx.Err() != nil {
+			s.mutex.Unlock()
+			return nil, errors.New("agent connection is closed")
 		}
 
-		s.ttrpcClient = ttrpc.NewClient(conn)
+		if s.agentClient != nil {
+			c := s.agentClient
+			s.mutex.Unlock()
+			return c, nil
+		}
 
-		s.agentClient = &client{
-			AgentServiceService: pb.NewAgentServiceClient(s.ttrpcClient),
-			HealthService:       pb.NewHealthClient(s.ttrpcClient),
+		if dialing := s.dialing; dialing != nil {
+			s.mutex.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, fmt.Errorf("agent connection is not established: %w", ctx.Err())
+			}
 		}
-	})
+
+		dialing := make(chan struct{})
+		s.dialing = dialing
+		s.mutex.Unlock()
+
+		return s.dial(ctx, dialing)
+	}
+}
+
+func (s *redirector) dial(ctx context.Context, dialing chan struct{}) (*client, error) {
+	// Closing the redirector cancels the dialer
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+
+	conn, err := s.dialer(ctx)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.dialing = nil
+	close(dialing)
 
 	if err != nil {
-		return fmt.Errorf("agent connection is not established: %w", err)
+		return nil, fmt.Errorf("agent connection is not established: %w", err)
+	}
+
+	if s.ctx.Err() != nil {
+		conn.Close()
+		return nil, errors.New("agent connection is closed")
+	}
+
+	c := &client{}
+	c.ttrpcClient = ttrpc.NewClient(conn, ttrpc.WithOnClose(func() {
+		s.disconnected(c)
+	}))
+	c.AgentServiceService = pb.NewAgentServiceClient(c.ttrpcClient)
+	c.HealthService = pb.NewHealthClient(c.ttrpcClient)
+
+	s.agentClient = c
+	s.ttrpcClient = c.ttrpcClient
+
+	if s.keepalive > 0 {
+		go s.keepaliveLoop(c)
 	}
 
-	if s.agentClient == nil {
-		return errors.New("agent connection is not established")
+	return c, nil
+}
+
+// disconnected forgets a dropped connection so that the next call re-establishes it
+func (s *redirector) disconnected(c *client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.agentClient == c {
+		s.agentClient = nil
+	}
+}
+
+func (s *redirector) keepaliveLoop(c *client) {
+	ticker := time.NewTicker(s.keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(s.ctx, s.keepalive)
+		_, err := c.Check(ctx, &pb.CheckRequest{})
+		cancel()
+
+		if err == nil {
+			continue
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		logger.Printf("agent connection keepalive failed, reconnecting: %v", err)
+		c.ttrpcClient.Close()
+		s.disconnected(c)
+
+		// Re-establish the connection in the background, so that the next call does not have to wait for it
+		s.reconnect()
+		return
 	}
+}
 
-	return nil
+// reconnect establishes a connection, retrying with exponential backoff until it succeeds or the redirector is closed
+func (s *redirector) reconnect() {
+	backoff := s.keepalive
+	for {
+		_, err := s.connect(s.ctx)
+		if err == nil || s.ctx.Err() != nil {
+			return
+		}
+		logger.Printf("failed to re-establish agent connection, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxReconnectBackoff)
+	}
 }
 
 func (s *redirector) Close() error {
+	s.cancel()
+
+	s.mutex.Lock()
 	client := s.ttrpcClient
+	s.agentClient = nil
+	s.mutex.Unlock()
+
 	if client == nil {
 		return nil
 	}
 	return client.Close()
 }
 
+// invoke calls f with an established connection. When the connection drops during an idempotent
+// call, the call is repeated over a new connection. Calls that change the state of the pod VM, or
+// consume it, like reading the output of a process, are not idempotent, since the agent may have
+// handled them before the connection dropped.
+func invoke[T any](ctx context.Context, s *redirector, idempotent bool, f func(*client) (T, error)) (T, error) {
+	var zero T
+
+	c, err := s.connect(ctx)
+	if err != nil {
+		return zero, err
+	}
+
+	res, err := f(c)
+	if err == nil || !errors.Is(err, ttrpc.ErrClosed) {
+		return res, err
+	}
+
+	s.disconnected(c)
+	if !idempotent || s.ctx.Err() != nil {
+		return res, err
+	}
+
+	logger.Printf("agent connection dropped, retrying the call over a new connection: %v", err)
+	c, connErr := s.connect(ctx)
+	if connErr != nil {
+		return zero, fmt.Errorf("%w (%v)", err, connErr)
+	}
+	return f(c)
+}
+
 // AgentServiceService methods
 
 func (s *redirector) CreateContainer(ctx context.Context, req *pb.CreateContainerRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.CreateContainer(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.CreateContainer(ctx, req)
+	})
 }
 
 func (s *redirector) StartContainer(ctx context.Context, req *pb.StartContainerRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.StartContainer(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.StartContainer(ctx, req)
+	})
 }
 
 func (s *redirector) RemoveContainer(ctx context.Context, req *pb.RemoveContainerRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.RemoveContainer(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.RemoveContainer(ctx, req)
+	})
 }
 
 func (s *redirector) ExecProcess(ctx context.Context, req *pb.ExecProcessRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ExecProcess(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.ExecProcess(ctx, req)
+	})
 }
 
 func (s *redirector) SignalProcess(ctx context.Context, req *pb.SignalProcessRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.SignalProcess(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.SignalProcess(ctx, req)
+	})
 }
 
 func (s *redirector) WaitProcess(ctx context.Context, req *pb.WaitProcessRequest) (res *pb.WaitProcessResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.WaitProcess(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*pb.WaitProcessResponse, error) {
+		return c.WaitProcess(ctx, req)
+	})
 }
 
 func (s *redirector) UpdateContainer(ctx context.Context, req *pb.UpdateContainerRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.UpdateContainer(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.UpdateContainer(ctx, req)
+	})
 }
 
 func (s *redirector) UpdateEphemeralMounts(ctx context.Context, req *pb.UpdateEphemeralMountsRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.UpdateEphemeralMounts(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.UpdateEphemeralMounts(ctx, req)
+	})
 }
 
 func (s *redirector) StatsContainer(ctx context.Context, req *pb.StatsContainerRequest) (res *pb.StatsContainerResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.StatsContainer(ctx, req)
+	return invoke(ctx, s, true, func(c *client) (*pb.StatsContainerResponse, error) {
+		return c.StatsContainer(ctx, req)
+	})
 }
 
 func (s *redirector) PauseContainer(ctx context.Context, req *pb.PauseContainerRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.PauseContainer(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.PauseContainer(ctx, req)
+	})
 }
 
 func (s *redirector) ResumeContainer(ctx context.Context, req *pb.ResumeContainerRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ResumeContainer(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.ResumeContainer(ctx, req)
+	})
 }
 
 func (s *redirector) RemoveStaleVirtiofsShareMounts(ctx context.Context, req *pb.RemoveStaleVirtiofsShareMountsRequest) (res *emptypb.Empty, err error) {
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.RemoveStaleVirtiofsShareMounts(ctx, req)
+
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.RemoveStaleVirtiofsShareMounts(ctx, req)
+	})
 }
 
 func (s *redirector) WriteStdin(ctx context.Context, req *pb.WriteStreamRequest) (res *pb.WriteStreamResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.WriteStdin(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*pb.WriteStreamResponse, error) {
+		return c.WriteStdin(ctx, req)
+	})
 }
 
 func (s *redirector) ReadStdout(ctx context.Context, req *pb.ReadStreamRequest) (res *pb.ReadStreamResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ReadStdout(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*pb.ReadStreamResponse, error) {
+		return c.ReadStdout(ctx, req)
+	})
 }
 
 func (s *redirector) ReadStderr(ctx context.Context, req *pb.ReadStreamRequest) (res *pb.ReadStreamResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ReadStderr(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*pb.ReadStreamResponse, error) {
+		return c.ReadStderr(ctx, req)
+	})
 }
 
 func (s *redirector) CloseStdin(ctx context.Context, req *pb.CloseStdinRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.CloseStdin(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.CloseStdin(ctx, req)
+	})
 }
 
 func (s *redirector) TtyWinResize(ctx context.Context, req *pb.TtyWinResizeRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.TtyWinResize(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.TtyWinResize(ctx, req)
+	})
 }
 
 func (s *redirector) UpdateInterface(ctx context.Context, req *pb.UpdateInterfaceRequest) (res *protocols.Interface, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.UpdateInterface(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*protocols.Interface, error) {
+		return c.UpdateInterface(ctx, req)
+	})
 }
 
 func (s *redirector) UpdateRoutes(ctx context.Context, req *pb.UpdateRoutesRequest) (res *pb.Routes, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.UpdateRoutes(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*pb.Routes, error) {
+		return c.UpdateRoutes(ctx, req)
+	})
 }
 
 func (s *redirector) ListInterfaces(ctx context.Context, req *pb.ListInterfacesRequest) (res *pb.Interfaces, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ListInterfaces(ctx, req)
+	return invoke(ctx, s, true, func(c *client) (*pb.Interfaces, error) {
+		return c.ListInterfaces(ctx, req)
+	})
 }
 
 func (s *redirector) ListRoutes(ctx context.Context, req *pb.ListRoutesRequest) (res *pb.Routes, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ListRoutes(ctx, req)
+	return invoke(ctx, s, true, func(c *client) (*pb.Routes, error) {
+		return c.ListRoutes(ctx, req)
+	})
 }
 
 func (s *redirector) AddARPNeighbors(ctx context.Context, req *pb.AddARPNeighborsRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.AddARPNeighbors(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.AddARPNeighbors(ctx, req)
+	})
 }
 
 func (s *redirector) GetIPTables(ctx context.Context, req *pb.GetIPTablesRequest) (res *pb.GetIPTablesResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetIPTables(ctx, req)
+	return invoke(ctx, s, true, func(c *client) (*pb.GetIPTablesResponse, error) {
+		return c.GetIPTables(ctx, req)
+	})
 }
 
 func (s *redirector) SetIPTables(ctx context.Context, req *pb.SetIPTablesRequest) (res *pb.SetIPTablesResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.SetIPTables(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*pb.SetIPTablesResponse, error) {
+		return c.SetIPTables(ctx, req)
+	})
 }
 
 func (s *redirector) GetMetrics(ctx context.Context, req *pb.GetMetricsRequest) (res *pb.Metrics, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetMetrics(ctx, req)
+	return invoke(ctx, s, true, func(c *client) (*pb.Metrics, error) {
+		return c.GetMetrics(ctx, req)
+	})
 }
 
 func (s *redirector) CreateSandbox(ctx context.Context, req *pb.CreateSandboxRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.CreateSandbox(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.CreateSandbox(ctx, req)
+	})
 }
 
 func (s *redirector) DestroySandbox(ctx context.Context, req *pb.DestroySandboxRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.DestroySandbox(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.DestroySandbox(ctx, req)
+	})
 }
 
 func (s *redirector) OnlineCPUMem(ctx context.Context, req *pb.OnlineCPUMemRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.OnlineCPUMem(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.OnlineCPUMem(ctx, req)
+	})
 }
 
 func (s *redirector) ReseedRandomDev(ctx context.Context, req *pb.ReseedRandomDevRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ReseedRandomDev(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.ReseedRandomDev(ctx, req)
+	})
 }
 
 func (s *redirector) GetGuestDetails(ctx context.Context, req *pb.GuestDetailsRequest) (res *pb.GuestDetailsResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetGuestDetails(ctx, req)
+	return invoke(ctx, s, true, func(c *client) (*pb.GuestDetailsResponse, error) {
+		return c.GetGuestDetails(ctx, req)
+	})
 }
 
 func (s *redirector) MemHotplugByProbe(ctx context.Context, req *pb.MemHotplugByProbeRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.MemHotplugByProbe(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.MemHotplugByProbe(ctx, req)
+	})
 }
 
 func (s *redirector) SetGuestDateTime(ctx context.Context, req *pb.SetGuestDateTimeRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.SetGuestDateTime(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.SetGuestDateTime(ctx, req)
+	})
 }
 
 func (s *redirector) CopyFile(ctx context.Context, req *pb.CopyFileRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.CopyFile(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.CopyFile(ctx, req)
+	})
 }
 
 func (s *redirector) GetOOMEvent(ctx context.Context, req *pb.GetOOMEventRequest) (res *pb.OOMEvent, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetOOMEvent(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*pb.OOMEvent, error) {
+		return c.GetOOMEvent(ctx, req)
+	})
 }
 
 func (s *redirector) AddSwap(ctx context.Context, req *pb.AddSwapRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.AddSwap(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.AddSwap(ctx, req)
+	})
 }
 
 func (s *redirector) GetVolumeStats(ctx context.Context, req *pb.VolumeStatsRequest) (res *pb.VolumeStatsResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetVolumeStats(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*pb.VolumeStatsResponse, error) {
+		return c.GetVolumeStats(ctx, req)
+	})
 }
 
 func (s *redirector) ResizeVolume(ctx context.Context, req *pb.ResizeVolumeRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ResizeVolume(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.ResizeVolume(ctx, req)
+	})
 }
 
 func (s *redirector) SetPolicy(ctx context.Context, req *pb.SetPolicyRequest) (res *emptypb.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.SetPolicy(ctx, req)
+	return invoke(ctx, s, false, func(c *client) (*emptypb.Empty, error) {
+		return c.SetPolicy(ctx, req)
+	})
 }
 
 // HealthService methods
 
 func (s *redirector) Check(ctx context.Context, req *pb.CheckRequest) (res *pb.HealthCheckResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.Check(ctx, req)
+	return invoke(ctx, s, true, func(c *client) (*pb.HealthCheckResponse, error) {
+		return c.Check(ctx, req)
+	})
 }
 
 func (s *redirector) Version(ctx context.Context, req *pb.CheckRequest) (res *pb.VersionCheckResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.Version(ctx, req)
+	return invoke(ctx, s, true, func(c *client) (*pb.VersionCheckResponse, error) {
+		return c.Version(ctx, req)
+	})
 }
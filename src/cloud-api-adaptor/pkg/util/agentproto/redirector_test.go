@@ -0,0 +1,272 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package agentproto
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
+)
+
+type healthService struct{}
+
+func (healthService) Check(ctx context.Context, req *pb.CheckRequest) (*pb.HealthCheckResponse, error) {
+	return &pb.HealthCheckResponse{Status: pb.HealthCheckResponse_SERVING}, nil
+}
+
+func (healthService) Version(ctx context.Context, req *pb.CheckRequest) (*pb.VersionCheckResponse, error) {
+	return &pb.VersionCheckResponse{AgentVersion: "test"}, nil
+}
+
+// startAgent starts a ttrpc health service, and the services registered by register, and returns
+// a dialer that records the connections it makes
+func startAgent(t *testing.T, register ...func(*ttrpc.Server)) (func(context.Context) (net.Conn, error), func() []net.Conn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server, err := ttrpc.NewServer()
+	if err != nil {
+		t.Fatalf("failed to create ttrpc server: %v", err)
+	}
+	pb.RegisterHealthService(server, healthService{})
+	for _, r := range register {
+		r(server)
+	}
+
+	go func() {
+		_ = server.Serve(context.Background(), listener)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	var mutex sync.Mutex
+	var conns []net.Conn
+
+	dialer := func(ctx context.Context) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		conns = append(conns, conn)
+		return conn, nil
+	}
+
+	dialed := func() []net.Conn {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]net.Conn{}, conns...)
+	}
+
+	return dialer, dialed
+}
+
+func TestRedirectorReconnect(t *testing.T) {
+	dialer, dialed := startAgent(t)
+
+	redirector := NewRedirector(dialer)
+	defer redirector.Close()
+
+	ctx := context.Background()
+
+	if _, err := redirector.Check(ctx, &pb.CheckRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Drop the connection
+	dialed()[0].Close()
+
+	// Calls that can be repeated transparently use a new connection
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := redirector.Check(ctx, &pb.CheckRequest{})
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection was not re-established: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := len(dialed()); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+}
+
+func TestRedirectorKeepalive(t *testing.T) {
+	dialer, dialed := startAgent(t)
+
+	redirector := NewRedirector(dialer, WithKeepalive(20*time.Millisecond))
+	defer redirector.Close()
+
+	if err := redirector.Connect(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dialed()[0].Close()
+
+	// The failed keepalive re-establishes the connection without any call
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dialed()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("connection was not re-established by keepalive")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedirectorClose(t *testing.T) {
+	dialer, _ := startAgent(t)
+
+	redirector := NewRedirector(dialer)
+
+	if err := redirector.Connect(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := redirector.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := redirector.Check(context.Background(), &pb.CheckRequest{}); err == nil {
+		t.Error("expected an error after the redirector is closed")
+	}
+}
+
+func TestRedirectorSlowDialer(t *testing.T) {
+	agentDialer, _ := startAgent(t)
+
+	var mutex sync.Mutex
+	dials := 0
+	release := make(chan struct{})
+
+	dialer := func(ctx context.Context) (net.Conn, error) {
+		mutex.Lock()
+		dials++
+		mutex.Unlock()
+		select {
+		case <-release:
+			return agentDialer(ctx)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	redirector := NewRedirector(dialer)
+	defer redirector.Close()
+
+	// Concurrent callers wait for the connection that is being established
+	errCh := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errCh <- redirector.Connect(context.Background())
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		n := dials
+		mutex.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection was not dialed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A caller whose context expires does not wait for the dialer
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := redirector.Connect(ctx); err == nil {
+		t.Error("expected an error when the context expires while connecting")
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-errCh; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if dials != 1 {
+		t.Errorf("expected 1 dial, got %d", dials)
+	}
+}
+
+func TestRedirectorCloseWhileDialing(t *testing.T) {
+	dialer := func(ctx context.Context) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	redirector := NewRedirector(dialer)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- redirector.Connect(context.Background())
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := redirector.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("expected an error when the redirector is closed while connecting")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the redirector did not cancel the dialer")
+	}
+}
+
+// dropService drops the connections of the client while it reads the output of a process
+type dropService struct {
+	pb.AgentServiceService
+	drop func()
+}
+
+func (s *dropService) ReadStdout(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error) {
+	s.drop()
+	return &pb.ReadStreamResponse{Data: []byte("lost")}, nil
+}
+
+func TestRedirectorNotIdempotent(t *testing.T) {
+	service := &dropService{}
+	dialer, dialed := startAgent(t, func(server *ttrpc.Server) {
+		pb.RegisterAgentServiceService(server, service)
+	})
+	service.drop = func() {
+		for _, conn := range dialed() {
+			conn.Close()
+		}
+	}
+
+	redirector := NewRedirector(dialer)
+	defer redirector.Close()
+
+	// Output that was read before the connection dropped would be lost if the call was repeated
+	if _, err := redirector.ReadStdout(context.Background(), &pb.ReadStreamRequest{}); err == nil {
+		t.Error("expected ReadStdout to fail instead of being repeated over a new connection")
+	}
+	if n := len(dialed()); n != 1 {
+		t.Errorf("expected 1 connection, got %d", n)
+	}
+}
@@ -1,12 +1,16 @@
 package util
 
 import (
+	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
 	cri "github.com/containerd/containerd/pkg/cri/annotations"
 	hypannotations "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/annotations"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func GetPodName(annotations map[string]string) string {
@@ -43,6 +47,13 @@ func GetImageFromAnnotation(annotations map[string]string) string {
 	return annotations[hypannotations.ImagePath]
 }
 
+// Method to get GPU model from annotation
+func GetGPUModelFromAnnotation(annotations map[string]string) string {
+	// The default_gpu_model annotation in Kata refers to the model of the GPUs of the VM
+	// We use it for Kata/remote to select an instance type with GPUs of the model
+	return annotations[hypannotations.DefaultGPUModel]
+}
+
 // Method to get vCPU, memory and gpus from annotations
 func GetPodvmResourcesFromAnnotation(annotations map[string]string) (int64, int64, int64) {
 
@@ -88,6 +99,162 @@ func GetPodvmResourcesFromAnnotation(annotations map[string]string) (int64, int6
 	return vcpuInt, memoryInt, gpuInt
 }
 
+// ForwarderPortAnnotation selects the port agent-protocol-forwarder listens on in the pod VM of a pod
+const ForwarderPortAnnotation = "io.confidentialcontainers.org.peerpods.forwarder_port"
+
+// Method to get the agent-protocol-forwarder port from annotation
+func GetForwarderPortFromAnnotation(annotations map[string]string) (string, error) {
+	port, ok := annotations[ForwarderPortAnnotation]
+	if !ok {
+		return "", nil
+	}
+
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return "", fmt.Errorf("invalid %s annotation: %q is not a port number", ForwarderPortAnnotation, port)
+	}
+
+	return port, nil
+}
+
+// TunnelMTUAnnotation overrides the MTU of the pod network interface in the pod VM of a pod
+const TunnelMTUAnnotation = "io.confidentialcontainers.org.peerpods.tunnel_mtu"
+
+// Method to get the pod network MTU from annotation
+func GetTunnelMTUFromAnnotation(annotations map[string]string) (int, error) {
+	mtu, ok := annotations[TunnelMTUAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	// 68 is the minimum MTU of IPv4 links
+	if n, err := strconv.ParseUint(mtu, 10, 16); err != nil || n < 68 {
+		return 0, fmt.Errorf("invalid %s annotation: %q is not a valid MTU", TunnelMTUAnnotation, mtu)
+	}
+
+	n, _ := strconv.Atoi(mtu)
+	return n, nil
+}
+
+// Bandwidth annotations of pods, which are also honored by the CNI bandwidth plugin for regular pods
+const (
+	IngressBandwidthAnnotation = "kubernetes.io/ingress-bandwidth"
+	EgressBandwidthAnnotation  = "kubernetes.io/egress-bandwidth"
+)
+
+// The same limits as kubelet
+var (
+	minBandwidth = resource.MustParse("1k")
+	maxBandwidth = resource.MustParse("1P")
+)
+
+// Method to get a bandwidth limit in bits per second from annotation
+func GetBandwidthFromAnnotation(annotations map[string]string, key string) (uint64, error) {
+	value, ok := annotations[key]
+	if !ok {
+		return 0, nil
+	}
+
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation: %q: %w", key, value, err)
+	}
+	if q.Cmp(minBandwidth) < 0 || q.Cmp(maxBandwidth) > 0 {
+		return 0, fmt.Errorf("invalid %s annotation: %q is out of range [%s, %s]", key, value, minBandwidth.String(), maxBandwidth.String())
+	}
+
+	return uint64(q.Value()), nil
+}
+
+// UserDataFilesAnnotation references ConfigMaps and Secrets whose keys are written as files in the pod VM of a pod.
+// The value is a JSON array of UserDataFile, e.g.
+// [{"configMap":"ca-bundle","key":"ca.crt","path":"certs/ca.crt"},{"secret":"tls","path":"tls","mode":"0600"}]
+const UserDataFilesAnnotation = "io.confidentialcontainers.org.peerpods.userdata_files"
+
+// UserDataFile references keys of a ConfigMap or a Secret in the namespace of a pod
+type UserDataFile struct {
+	ConfigMap string `json:"configMap,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	// Key selects a key of the object. All keys are written if it is empty
+	Key string `json:"key,omitempty"`
+	// Path is relative to /run/peerpod/files in the pod VM.
+	// It is the path of the file if Key is set, and the directory of the files otherwise
+	Path  string `json:"path"`
+	Mode  string `json:"mode,omitempty"`
+	Owner string `json:"owner,omitempty"`
+}
+
+// Paths and owners are written to the cloud config as they are, so they are limited to safe characters
+var (
+	userDataFilePathRegexp  = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	userDataFileOwnerRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+(:[A-Za-z0-9._-]+)?$`)
+)
+
+// Method to get ConfigMaps and Secrets to write in the pod VM from annotation
+func GetUserDataFilesFromAnnotation(annotations map[string]string) ([]UserDataFile, error) {
+	value, ok := annotations[UserDataFilesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var files []UserDataFile
+	if err := json.Unmarshal([]byte(value), &files); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", UserDataFilesAnnotation, err)
+	}
+
+	for i, file := range files {
+		if (file.ConfigMap == "") == (file.Secret == "") {
+			return nil, fmt.Errorf("invalid %s annotation: entry %d must have either configMap or secret", UserDataFilesAnnotation, i)
+		}
+		if !userDataFilePathRegexp.MatchString(file.Path) || !filepath.IsLocal(file.Path) {
+			return nil, fmt.Errorf("invalid %s annotation: %q is not a valid relative path", UserDataFilesAnnotation, file.Path)
+		}
+		if file.Mode != "" {
+			if mode, err := strconv.ParseUint(file.Mode, 8, 32); err != nil || mode > 0o777 {
+				return nil, fmt.Errorf("invalid %s annotation: %q is not a valid file mode", UserDataFilesAnnotation, file.Mode)
+			}
+		}
+		if file.Owner != "" && !userDataFileOwnerRegexp.MatchString(file.Owner) {
+			return nil, fmt.Errorf("invalid %s annotation: %q is not a valid owner", UserDataFilesAnnotation, file.Owner)
+		}
+	}
+
+	return files, nil
+}
+
+// CloudConfigAnnotation carries an extra cloud-config document that is merged with the generated one.
+// It is honored on pods and on namespaces, and the one of a pod is merged after the one of its namespace.
+const CloudConfigAnnotation = "io.confidentialcontainers.org.peerpods.cloud_config"
+
+// CredentialsSecretAnnotation names a Secret in the namespace of a pod with the cloud credentials its pod VM is created with.
+// It is honored on pods and on namespaces, and the one of a pod takes precedence over the one of its namespace.
+const CredentialsSecretAnnotation = "io.confidentialcontainers.org.peerpods.credentials_secret"
+
+// TagsAnnotation are the custom tags (key=value pairs, comma separated) of the pod VM of a pod, on providers with
+// custom tags. It is honored on pods and on namespaces, and the tags of a pod take precedence over the ones of its namespace.
+const TagsAnnotation = "io.confidentialcontainers.org.peerpods.tags"
+
+// KBSURLAnnotation selects the KBS that the pod VM of a pod attests against, instead of the one in its initdata.
+// KBSCertAnnotation is the PEM certificate of the KBS. They are honored on pods and on namespaces, and the ones of
+// a pod take precedence over the ones of its namespace. The URL must be allowed by cloud-api-adaptor.
+const (
+	KBSURLAnnotation  = "io.confidentialcontainers.org.peerpods.kbs_url"
+	KBSCertAnnotation = "io.confidentialcontainers.org.peerpods.kbs_cert"
+)
+
+// AAConfigAnnotation and CDHConfigAnnotation carry TOML fragments that are merged into aa.toml and cdh.toml in the
+// initdata of a pod, e.g. to set resource URIs, policies or certificates per pod. The fragments may use the variables
+// of userdata templates, such as {{.Namespace}}. They are honored on pods and on namespaces, and the ones of a pod are
+// merged after the ones of its namespace.
+const (
+	AAConfigAnnotation  = "io.confidentialcontainers.org.peerpods.aa_config"
+	CDHConfigAnnotation = "io.confidentialcontainers.org.peerpods.cdh_config"
+)
+
+// AttestationConfigMapAnnotation names a ConfigMap in the namespace of a pod with aa.toml and cdh.toml fragments,
+// which are merged before the fragments in annotations. It is honored on pods and on namespaces, and the one of
+// a pod takes precedence over the one of its namespace.
+const AttestationConfigMapAnnotation = "io.confidentialcontainers.org.peerpods.attestation_configmap"
+
 // Method to get initdata from annotation
 func GetInitdataFromAnnotation(annotations map[string]string) string {
 	return annotations["io.katacontainers.config.runtime.cc_init_data"]
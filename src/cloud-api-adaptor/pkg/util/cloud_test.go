@@ -1,6 +1,7 @@
 package util
 
 import (
+	"reflect"
 	"testing"
 
 	hypannotations "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/annotations"
@@ -189,3 +190,282 @@ func TestGetImageFromAnnotation(t *testing.T) {
 		})
 	}
 }
+
+func TestGetGPUModelFromAnnotation(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{name: "gpu model", annotations: map[string]string{hypannotations.DefaultGPUModel: "A10G"}, want: "A10G"},
+		{name: "no gpu model", annotations: map[string]string{hypannotations.DefaultGPUs: "1"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetGPUModelFromAnnotation(tt.annotations); got != tt.want {
+				t.Errorf("GetGPUModelFromAnnotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetForwarderPortFromAnnotation(t *testing.T) {
+	type args struct {
+		annotations map[string]string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "forwarder port",
+			args: args{
+				annotations: map[string]string{
+					ForwarderPortAnnotation: "15160",
+				},
+			},
+			want: "15160",
+		},
+		{
+			name: "no forwarder port",
+			args: args{
+				annotations: map[string]string{},
+			},
+			want: "",
+		},
+		{
+			name: "invalid forwarder port",
+			args: args{
+				annotations: map[string]string{
+					ForwarderPortAnnotation: "70000",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "zero forwarder port",
+			args: args{
+				annotations: map[string]string{
+					ForwarderPortAnnotation: "0",
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetForwarderPortFromAnnotation(tt.args.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetForwarderPortFromAnnotation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetForwarderPortFromAnnotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTunnelMTUFromAnnotation(t *testing.T) {
+	type args struct {
+		annotations map[string]string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "tunnel MTU",
+			args: args{
+				annotations: map[string]string{
+					TunnelMTUAnnotation: "1360",
+				},
+			},
+			want: 1360,
+		},
+		{
+			name: "no tunnel MTU",
+			args: args{
+				annotations: map[string]string{},
+			},
+			want: 0,
+		},
+		{
+			name: "too small tunnel MTU",
+			args: args{
+				annotations: map[string]string{
+					TunnelMTUAnnotation: "60",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid tunnel MTU",
+			args: args{
+				annotations: map[string]string{
+					TunnelMTUAnnotation: "large",
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetTunnelMTUFromAnnotation(tt.args.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetTunnelMTUFromAnnotation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetTunnelMTUFromAnnotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetBandwidthFromAnnotation(t *testing.T) {
+	type args struct {
+		annotations map[string]string
+		key         string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name: "ingress bandwidth",
+			args: args{
+				annotations: map[string]string{
+					IngressBandwidthAnnotation: "10M",
+				},
+				key: IngressBandwidthAnnotation,
+			},
+			want: 10000000,
+		},
+		{
+			name: "egress bandwidth",
+			args: args{
+				annotations: map[string]string{
+					IngressBandwidthAnnotation: "10M",
+					EgressBandwidthAnnotation:  "1Gi",
+				},
+				key: EgressBandwidthAnnotation,
+			},
+			want: 1 << 30,
+		},
+		{
+			name: "no bandwidth",
+			args: args{
+				annotations: map[string]string{},
+				key:         IngressBandwidthAnnotation,
+			},
+			want: 0,
+		},
+		{
+			name: "too small bandwidth",
+			args: args{
+				annotations: map[string]string{
+					EgressBandwidthAnnotation: "100",
+				},
+				key: EgressBandwidthAnnotation,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid bandwidth",
+			args: args{
+				annotations: map[string]string{
+					IngressBandwidthAnnotation: "fast",
+				},
+				key: IngressBandwidthAnnotation,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetBandwidthFromAnnotation(tt.args.annotations, tt.args.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetBandwidthFromAnnotation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetBandwidthFromAnnotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUserDataFilesFromAnnotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []UserDataFile
+		wantErr bool
+	}{
+		{
+			name:  "configmap key and secret",
+			value: `[{"configMap":"ca-bundle","key":"ca.crt","path":"certs/ca.crt"},{"secret":"tls","path":"tls","mode":"0600","owner":"root:root"}]`,
+			want: []UserDataFile{
+				{ConfigMap: "ca-bundle", Key: "ca.crt", Path: "certs/ca.crt"},
+				{Secret: "tls", Path: "tls", Mode: "0600", Owner: "root:root"},
+			},
+		},
+		{
+			name:  "no annotation",
+			value: "",
+		},
+		{
+			name:    "invalid json",
+			value:   `{"configMap":"ca-bundle"}`,
+			wantErr: true,
+		},
+		{
+			name:    "both configmap and secret",
+			value:   `[{"configMap":"ca-bundle","secret":"tls","path":"certs"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "absolute path",
+			value:   `[{"configMap":"ca-bundle","path":"/etc/ssl"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "path out of the directory",
+			value:   `[{"configMap":"ca-bundle","path":"../daemon.json"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid mode",
+			value:   `[{"configMap":"ca-bundle","path":"certs","mode":"0999"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid owner",
+			value:   `[{"configMap":"ca-bundle","path":"certs","owner":"root\npermissions: 0777"}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.value != "" {
+				annotations[UserDataFilesAnnotation] = tt.value
+			}
+			got, err := GetUserDataFilesFromAnnotation(annotations)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetUserDataFilesFromAnnotation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetUserDataFilesFromAnnotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
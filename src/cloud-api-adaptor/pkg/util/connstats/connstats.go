@@ -0,0 +1,221 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package connstats tracks bandwidth and latency statistics of individual connections
+package connstats
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rateInterval is the interval over which transfer rates are averaged
+const rateInterval = time.Second
+
+// Snapshot holds the statistics of a connection at a point in time
+type Snapshot struct {
+	Stream            string        `json:"stream"`
+	ID                string        `json:"id"`
+	RemoteAddr        string        `json:"remote-addr"`
+	BytesIn           uint64        `json:"bytes-in"`
+	BytesOut          uint64        `json:"bytes-out"`
+	InBytesPerSecond  float64       `json:"in-bytes-per-second"`
+	OutBytesPerSecond float64       `json:"out-bytes-per-second"`
+	RTT               time.Duration `json:"rtt"`
+}
+
+// Tracker keeps track of the statistics of open connections. The transfer rates are sampled
+// every rateInterval while connections are open, so that reading them doesn't affect them.
+type Tracker struct {
+	mutex    sync.Mutex
+	conns    map[*conn]struct{}
+	sampling bool
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		conns: make(map[*conn]struct{}),
+	}
+}
+
+type conn struct {
+	net.Conn
+	tracker  *Tracker
+	stream   string
+	id       string
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	// Guarded by tracker.mutex, and updated by Tracker.sample
+	sampledAt  time.Time
+	sampledIn  uint64
+	sampledOut uint64
+	rateIn     float64
+	rateOut    float64
+}
+
+// Track returns a connection whose statistics are tracked until it is closed.
+// stream names the kind of connection, and id identifies the connection within the stream.
+func (t *Tracker) Track(c net.Conn, stream, id string) net.Conn {
+	tc := &conn{
+		Conn:      c,
+		tracker:   t,
+		stream:    stream,
+		id:        id,
+		sampledAt: time.Now(),
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.conns[tc] = struct{}{}
+
+	if !t.sampling {
+		t.sampling = true
+		go t.sample()
+	}
+
+	return tc
+}
+
+// sample updates the transfer rates of the open connections every rateInterval, until all connections are closed
+func (t *Tracker) sample() {
+	ticker := time.NewTicker(rateInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		t.mutex.Lock()
+		if len(t.conns) == 0 {
+			t.sampling = false
+			t.mutex.Unlock()
+			return
+		}
+		for c := range t.conns {
+			c.sample(now)
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// sample computes the transfer rates since the previous sample. The caller holds tracker.mutex
+func (c *conn) sample(now time.Time) {
+	bytesIn, bytesOut := c.bytesIn.Load(), c.bytesOut.Load()
+	if elapsed := now.Sub(c.sampledAt); elapsed > 0 {
+		c.rateIn = float64(bytesIn-c.sampledIn) / elapsed.Seconds()
+		c.rateOut = float64(bytesOut-c.sampledOut) / elapsed.Seconds()
+	}
+	c.sampledAt, c.sampledIn, c.sampledOut = now, bytesIn, bytesOut
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+func (c *conn) Close() error {
+	c.tracker.mutex.Lock()
+	delete(c.tracker.conns, c)
+	c.tracker.mutex.Unlock()
+
+	return c.Conn.Close()
+}
+
+// NetConn returns the wrapped connection
+func (c *conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Snapshot returns the statistics of all open connections. Transfer rates are averaged
+// over the last rateInterval, and are the same for all the callers in the interval.
+func (t *Tracker) Snapshot() []Snapshot {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	snapshots := make([]Snapshot, 0, len(t.conns))
+	for c := range t.conns {
+		var remoteAddr string
+		if addr := c.RemoteAddr(); addr != nil {
+			remoteAddr = addr.String()
+		}
+
+		snapshots = append(snapshots, Snapshot{
+			Stream:            c.stream,
+			ID:                c.id,
+			RemoteAddr:        remoteAddr,
+			BytesIn:           c.bytesIn.Load(),
+			BytesOut:          c.bytesOut.Load(),
+			InBytesPerSecond:  c.rateIn,
+			OutBytesPerSecond: c.rateOut,
+			RTT:               rtt(c.Conn),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Stream != snapshots[j].Stream {
+			return snapshots[i].Stream < snapshots[j].Stream
+		}
+		return snapshots[i].ID < snapshots[j].ID
+	})
+
+	return snapshots
+}
+
+// Handler returns an HTTP handler serving the connection statistics as JSON
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(t.Snapshot()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+type collector struct {
+	tracker *Tracker
+	rate    *prometheus.Desc
+	rtt     *prometheus.Desc
+}
+
+// NewCollector returns a Prometheus collector exposing the statistics of the
+// connections of tracker as <namespace>_<subsystem>_connection_* metrics
+func NewCollector(tracker *Tracker, namespace, subsystem string) prometheus.Collector {
+	return &collector{
+		tracker: tracker,
+		rate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "connection_bytes_per_second"),
+			"Transfer rate of an open connection per direction",
+			[]string{"stream", "id", "direction"}, nil),
+		rtt: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "connection_rtt_seconds"),
+			"Smoothed round trip time of an open TCP connection",
+			[]string{"stream", "id"}, nil),
+	}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.rate
+	ch <- c.rtt
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.tracker.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(c.rate, prometheus.GaugeValue, s.InBytesPerSecond, s.Stream, s.ID, "in")
+		ch <- prometheus.MustNewConstMetric(c.rate, prometheus.GaugeValue, s.OutBytesPerSecond, s.Stream, s.ID, "out")
+		if s.RTT > 0 {
+			ch <- prometheus.MustNewConstMetric(c.rtt, prometheus.GaugeValue, s.RTT.Seconds(), s.Stream, s.ID)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package connstats
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTracker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = io.Copy(c, c)
+	}()
+
+	c, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	tracker := NewTracker()
+	conn := tracker.Track(c, "test", "pod-1")
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	// Rates are sampled every rateInterval
+	time.Sleep(rateInterval + rateInterval/2)
+
+	snapshots := tracker.Snapshot()
+	if len(snapshots) != 1 {
+		t.Fatalf("expected 1 connection, got %d", len(snapshots))
+	}
+	s := snapshots[0]
+	if s.Stream != "test" || s.ID != "pod-1" || s.RemoteAddr != listener.Addr().String() {
+		t.Errorf("unexpected connection: %+v", s)
+	}
+	if s.BytesIn != 5 || s.BytesOut != 5 {
+		t.Errorf("expected 5 bytes in each direction, got %d in and %d out", s.BytesIn, s.BytesOut)
+	}
+	if s.InBytesPerSecond <= 0 || s.OutBytesPerSecond <= 0 {
+		t.Errorf("expected positive transfer rates, got %v in and %v out", s.InBytesPerSecond, s.OutBytesPerSecond)
+	}
+	if s.RTT <= 0 {
+		t.Errorf("expected a positive RTT, got %v", s.RTT)
+	}
+
+	// Reading the statistics doesn't reset the rates
+	if again := tracker.Snapshot()[0]; again.InBytesPerSecond != s.InBytesPerSecond || again.OutBytesPerSecond != s.OutBytesPerSecond {
+		t.Errorf("expected the same transfer rates, got %v in and %v out, and then %v in and %v out",
+			s.InBytesPerSecond, s.OutBytesPerSecond, again.InBytesPerSecond, again.OutBytesPerSecond)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(tracker, "test", "conn"))
+	if n, err := testutil.GatherAndCount(registry, "test_conn_connection_bytes_per_second"); err != nil || n != 2 {
+		t.Errorf("expected 2 rate metrics, got %d: %v", n, err)
+	}
+
+	conn.Close()
+
+	if snapshots := tracker.Snapshot(); len(snapshots) != 0 {
+		t.Errorf("expected no connections after close, got %d", len(snapshots))
+	}
+
+	if err := testutil.CollectAndCompare(NewCollector(tracker, "test", "conn"), strings.NewReader("")); err != nil {
+		t.Errorf("unexpected metrics after close: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package connstats
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// rtt returns the round trip time the kernel estimated for the TCP connection underlying c,
+// or zero when c is not a TCP connection
+func rtt(c net.Conn) time.Duration {
+	for {
+		wrapper, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = wrapper.NetConn()
+	}
+
+	tcpConn, ok := c.(*net.TCPConn)
+	if !ok {
+		return 0
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+
+	var info *unix.TCPInfo
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil || sockErr != nil {
+		return 0
+	}
+
+	return time.Duration(info.Rtt) * time.Microsecond
+}
@@ -0,0 +1,15 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package connstats
+
+import (
+	"net"
+	"time"
+)
+
+func rtt(c net.Conn) time.Duration {
+	return 0
+}
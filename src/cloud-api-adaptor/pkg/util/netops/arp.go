@@ -0,0 +1,133 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netops
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ethHeaderLen = 14
+	arpLen       = 28
+
+	arpOpRequest = 1
+	arpOpReply   = 2
+)
+
+// ErrNoARPReply is returned by ARPProbe when no reply is received before the timeout
+var ErrNoARPReply = errors.New("no ARP reply")
+
+// ARPProbe sends an ARP probe (RFC 5227) for target from an interface, and waits for a reply from the owner of the address.
+// The sender IP address of a probe is zero, so the probe does not update the neighbor tables of the receiver, and
+// does not require an IP address on the interface.
+func (ns *namespace) ARPProbe(name string, target netip.Addr, timeout time.Duration) error {
+
+	if !target.Is4() {
+		return fmt.Errorf("ARP probe requires an IPv4 address: %s", target)
+	}
+
+	link, err := ns.handle.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to get interface %s: %w", name, err)
+	}
+	hwAddr := link.Attrs().HardwareAddr
+	if len(hwAddr) != 6 {
+		return fmt.Errorf("interface %s has no Ethernet address", name)
+	}
+
+	// A packet socket is bound to the network namespace where it is created
+	return ns.Run(func() error {
+
+		fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+		if err != nil {
+			return fmt.Errorf("failed to open a packet socket: %w", err)
+		}
+		defer unix.Close(fd)
+
+		addr := &unix.SockaddrLinklayer{
+			Protocol: htons(unix.ETH_P_ARP),
+			Ifindex:  link.Attrs().Index,
+		}
+		if err := unix.Bind(fd, addr); err != nil {
+			return fmt.Errorf("failed to bind a packet socket to %s: %w", name, err)
+		}
+
+		tv := unix.NsecToTimeval(timeout.Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return fmt.Errorf("failed to set a receive timeout: %w", err)
+		}
+
+		if _, err := unix.Write(fd, arpProbeFrame(hwAddr, target)); err != nil {
+			return fmt.Errorf("failed to send an ARP probe for %s on %s: %w", target, name, err)
+		}
+
+		deadline := time.Now().Add(timeout)
+		buf := make([]byte, 1500)
+
+		// Packet sockets see frames before tc ingress filters, so replies are received even if they are redirected
+		for time.Now().Before(deadline) {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+					continue
+				}
+				return fmt.Errorf("failed to receive an ARP reply on %s: %w", name, err)
+			}
+			if isARPReply(buf[:n], target) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%w from %s on %s", ErrNoARPReply, target, name)
+	})
+}
+
+// arpProbeFrame returns a broadcast Ethernet frame of an ARP request for target with a zero sender IP address
+func arpProbeFrame(hwAddr net.HardwareAddr, target netip.Addr) []byte {
+
+	frame := make([]byte, ethHeaderLen+arpLen)
+
+	copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], hwAddr)
+	binary.BigEndian.PutUint16(frame[12:14], unix.ETH_P_ARP)
+
+	arp := frame[ethHeaderLen:]
+	binary.BigEndian.PutUint16(arp[0:2], 1) // Ethernet
+	binary.BigEndian.PutUint16(arp[2:4], unix.ETH_P_IP)
+	arp[4] = 6
+	arp[5] = 4
+	binary.BigEndian.PutUint16(arp[6:8], arpOpRequest)
+	copy(arp[8:14], hwAddr)
+	// Sender IP address at arp[14:18] is zero, and target hardware address at arp[18:24] is unknown
+	ip := target.As4()
+	copy(arp[24:28], ip[:])
+
+	return frame
+}
+
+// isARPReply returns true if a frame is an ARP reply from target
+func isARPReply(frame []byte, target netip.Addr) bool {
+
+	if len(frame) < ethHeaderLen+arpLen || binary.BigEndian.Uint16(frame[12:14]) != unix.ETH_P_ARP {
+		return false
+	}
+	arp := frame[ethHeaderLen:]
+	if binary.BigEndian.Uint16(arp[6:8]) != arpOpReply {
+		return false
+	}
+	ip := target.As4()
+	return bytes.Equal(arp[14:18], ip[:])
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
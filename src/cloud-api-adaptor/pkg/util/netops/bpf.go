@@ -0,0 +1,151 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netops
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	bpfRedirectFilterName = "peerpod-redirect"
+
+	// Helper function ID of bpf_redirect in include/uapi/linux/bpf.h
+	bpfFuncRedirect = 23
+
+	bpfLogSize = 4096
+)
+
+// bpfInsn corresponds to struct bpf_insn
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// bpfProgLoadAttr corresponds to the BPF_PROG_LOAD part of union bpf_attr
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+	progName    [unix.BPF_OBJ_NAME_LEN]byte
+}
+
+// bpfRegs encodes the dst_reg and src_reg bit fields of struct bpf_insn, whose layout depends on the byte order
+func bpfRegs(dst, src uint8) uint8 {
+	if binary.NativeEndian.Uint16([]byte{0, 1}) == 1 {
+		return dst<<4 | src
+	}
+	return src<<4 | dst
+}
+
+// bpfRedirectProgram returns instructions of "return bpf_redirect(ifindex, 0);"
+func bpfRedirectProgram(ifindex int) []bpfInsn {
+	return []bpfInsn{
+		// r1 = ifindex
+		{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K, regs: bpfRegs(1, 0), imm: int32(ifindex)},
+		// r2 = 0
+		{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K, regs: bpfRegs(2, 0), imm: 0},
+		// r0 = bpf_redirect(r1, r2)
+		{code: unix.BPF_JMP | unix.BPF_CALL, imm: bpfFuncRedirect},
+		// return r0
+		{code: unix.BPF_JMP | unix.BPF_EXIT},
+	}
+}
+
+// loadBPFRedirectProgram loads a tc classifier program that redirects all packets to the egress of an interface
+func loadBPFRedirectProgram(ifindex int) (int, error) {
+
+	insns := bpfRedirectProgram(ifindex)
+	license := []byte("Apache-2.0\x00")
+	logBuf := make([]byte, bpfLogSize)
+
+	attr := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_SCHED_CLS,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	copy(attr.progName[:unix.BPF_OBJ_NAME_LEN-1], "peerpod_redir")
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(logBuf)
+
+	if errno != 0 {
+		if log := string(bytes.TrimRight(logBuf, "\x00")); log != "" {
+			return -1, fmt.Errorf("failed to load BPF program: %w: %s", errno, log)
+		}
+		return -1, fmt.Errorf("failed to load BPF program: %w", errno)
+	}
+
+	return int(fd), nil
+}
+
+// BPFRedirectAdd adds a tc ingress qdisc and an eBPF filter that redirects all traffic from src to dst.
+// Packets are redirected by bpf_redirect in direct action mode, instead of a u32 classifier and a mirred action.
+func (ns *namespace) BPFRedirectAdd(src, dst string) error {
+	srcLink, err := ns.handle.LinkByName(src)
+	if err != nil {
+		return fmt.Errorf("failed to get interface %s: %w", src, err)
+	}
+
+	dstLink, err := ns.handle.LinkByName(dst)
+	if err != nil {
+		return fmt.Errorf("failed to get interface %s: %w", dst, err)
+	}
+
+	fd, err := loadBPFRedirectProgram(dstLink.Attrs().Index)
+	if err != nil {
+		return fmt.Errorf("failed to load a BPF program to redirect traffic from %s to %s: %w", src, dst, err)
+	}
+	// The filter holds a reference to the program
+	defer unix.Close(fd)
+
+	qdisc := &netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: srcLink.Attrs().Index,
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}
+	if err := ns.handle.QdiscAdd(qdisc); err != nil {
+		return fmt.Errorf("failed to add qdisc to %s: %w", src, err)
+	}
+
+	filter := &netlink.BpfFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: srcLink.Attrs().Index,
+			Parent:    netlink.MakeHandle(0xffff, 0),
+			Handle:    netlink.MakeHandle(0, 1),
+			Protocol:  unix.ETH_P_ALL,
+		},
+		Fd:           fd,
+		Name:         bpfRedirectFilterName,
+		DirectAction: true,
+	}
+
+	if err := ns.handle.FilterAdd(filter); err != nil {
+		return fmt.Errorf("failed to add a filter to %s : %w", src, err)
+	}
+
+	return nil
+}
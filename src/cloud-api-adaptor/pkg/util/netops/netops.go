@@ -7,12 +7,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net"
 	"net/netip"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"time"
 
 	"golang.org/x/exp/maps"
 
@@ -28,7 +30,10 @@ type Namespace interface {
 	LinkList() ([]Link, error)
 	Path() string
 	RedirectAdd(src, dst string) error
+	BPFRedirectAdd(src, dst string) error
 	RedirectDel(src string) error
+	ShapingAdd(name string, rate uint64) error
+	ShapingDel(name string) error
 	RouteAdd(route *Route) error
 	RouteDel(route *Route) error
 	RouteList(filters ...*Route) ([]*Route, error)
@@ -37,6 +42,11 @@ type Namespace interface {
 	RuleList(rule *Rule) ([]*Rule, error)
 	NeighborAdd(neighbor *Neighbor) error
 	NeighborList(filters ...*Neighbor) ([]*Neighbor, error)
+	XfrmStateAdd(state *XfrmState) error
+	XfrmStateDel(spi int) error
+	XfrmPolicyAdd(policy *XfrmPolicy) error
+	XfrmPolicyDel(spi int) error
+	ARPProbe(name string, target netip.Addr, timeout time.Duration) error
 	Run(fn func() error) error
 }
 
@@ -181,6 +191,7 @@ type Link interface {
 	Delete() error
 
 	GetAddr() ([]netip.Prefix, error)
+	GetAddrByFamily(family Family) ([]netip.Prefix, error)
 	AddAddr(prefix netip.Prefix) error
 	GetHardwareAddr() (string, error)
 	SetHardwareAddr(hwAddr string) error
@@ -192,6 +203,7 @@ type Link interface {
 	SetNamespace(target Namespace) error
 	SetName(name string) error
 	SetUp() error
+	IsUp() bool
 }
 
 type link struct {
@@ -213,7 +225,12 @@ func (l *link) Type() string {
 
 func (l *link) GetAddr() ([]netip.Prefix, error) {
 
-	addrs, err := l.ns.handle.AddrList(l.nlLink, netlink.FAMILY_V4)
+	return l.GetAddrByFamily(FAMILY_V4)
+}
+
+func (l *link) GetAddrByFamily(family Family) ([]netip.Prefix, error) {
+
+	addrs, err := l.ns.handle.AddrList(l.nlLink, int(family))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get IP addresses assigned to %s interface %q:  %w", l.Type(), l.Name(), err)
 	}
@@ -228,7 +245,13 @@ func (l *link) GetAddr() ([]netip.Prefix, error) {
 
 func (l *link) AddAddr(prefix netip.Prefix) error {
 
-	if err := l.ns.handle.AddrAdd(l.nlLink, &netlink.Addr{IPNet: toIPNet(prefix)}); err != nil {
+	addr := &netlink.Addr{IPNet: toIPNet(prefix)}
+	if prefix.Addr().Is6() {
+		// Addresses are assigned by the pod network, so duplicate address detection only delays their use
+		addr.Flags = unix.IFA_F_NODAD
+	}
+
+	if err := l.ns.handle.AddrAdd(l.nlLink, addr); err != nil {
 		return fmt.Errorf("failed to assign an IP address %q to %s: %w", prefix.String(), l.Name(), err)
 	}
 
@@ -309,6 +332,11 @@ func (l *link) SetUp() error {
 	return nil
 }
 
+// IsUp returns whether the link was administratively up when it was found
+func (l *link) IsUp() bool {
+	return l.nlLink.Attrs().Flags&net.FlagUp != 0
+}
+
 func (l *link) Delete() error {
 
 	if err := l.ns.handle.LinkDel(l.nlLink); err != nil {
@@ -326,6 +354,13 @@ func (l *link) GetDevice() (dev Device, err error) {
 			ID:    v.VxlanId,
 			Port:  v.Port,
 		}
+	case *netlink.Geneve:
+		dev = &Geneve{
+			Remote:   toAddr(v.Remote),
+			ID:       int(v.ID),
+			Port:     int(v.Dport),
+			External: v.FlowBased,
+		}
 	default:
 		// TODO: Support Bridge, VXLAN, ...
 		return nil, fmt.Errorf("device info is not available: %s", l.nlLink.Type())
@@ -372,6 +407,32 @@ func (d *VXLAN) getLink() netlink.Link {
 	}
 }
 
+// Geneve is a Geneve interface. An external interface takes the tunnel parameters of each packet
+// from metadata set by tc actions instead of Remote and ID.
+type Geneve struct {
+	Remote   netip.Addr
+	ID       int
+	Port     int
+	External bool
+}
+
+func (d *Geneve) getLink() netlink.Link {
+
+	return &netlink.Geneve{
+		Remote:    toIP(d.Remote),
+		ID:        uint32(d.ID),
+		Dport:     uint16(d.Port),
+		FlowBased: d.External,
+	}
+}
+
+type WireGuard struct{}
+
+func (d *WireGuard) getLink() netlink.Link {
+
+	return &netlink.Wireguard{}
+}
+
 func (ns *namespace) LinkFind(name string) (Link, error) {
 
 	nlLinks, err := ns.handle.LinkList()
@@ -432,6 +493,13 @@ func (ns *namespace) LinkAdd(name string, device Device) (Link, error) {
 
 var DefaultPrefix = netip.MustParsePrefix("0.0.0.0/0")
 
+type Family int
+
+const (
+	FAMILY_V4 = Family(netlink.FAMILY_V4)
+	FAMILY_V6 = Family(netlink.FAMILY_V6)
+)
+
 type Route struct {
 	Destination netip.Prefix
 	Source      netip.Addr
@@ -443,6 +511,8 @@ type Route struct {
 	Protocol    RouteProtocol
 	Scope       RouteScope
 	Onlink      bool
+	// Family selects the address family of routes to list. IPv4 is listed if it is not specified.
+	Family Family
 }
 
 func (r1 *Route) compare(r2 *Route) bool {
@@ -515,7 +585,15 @@ func (ns *namespace) routeListFiltered(filter *Route) ([]*netlink.Route, error)
 		filterMask |= netlink.RT_FILTER_PROTOCOL
 	}
 
-	list, err := ns.handle.RouteListFiltered(netlink.FAMILY_V4, &nlRoute, filterMask)
+	family := filter.Family
+	if family == 0 {
+		family = FAMILY_V4
+		if filter.Destination.Addr().Is6() || filter.Gateway.Is6() {
+			family = FAMILY_V6
+		}
+	}
+
+	list, err := ns.handle.RouteListFiltered(int(family), &nlRoute, filterMask)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get routes on namespace %q: %w", ns.Path(), err)
 	}
@@ -529,17 +607,22 @@ func (ns *namespace) routeListFiltered(filter *Route) ([]*netlink.Route, error)
 	return nlRoutes, nil
 }
 
-// RouteList gets a list of routes on the main table
+// DefaultRouteFilters returns filters that match the unicast routes of an address family on the main table
+func DefaultRouteFilters(family Family) []*Route {
+
+	return []*Route{
+		{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_STATIC, Family: family},
+		{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_BOOT, Family: family},
+		{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_DHCP, Family: family},
+		{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_KERNEL, Family: family},
+	}
+}
+
+// RouteList gets a list of routes on the main table. IPv4 routes are listed if no filter is specified.
 func (ns *namespace) RouteList(filters ...*Route) ([]*Route, error) {
 
 	if len(filters) == 0 {
-		defaultFilters := []*Route{
-			{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_STATIC},
-			{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_BOOT},
-			{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_DHCP},
-			{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_KERNEL},
-		}
-		filters = append(filters, defaultFilters...)
+		filters = DefaultRouteFilters(FAMILY_V4)
 	}
 
 	var routes []*Route
@@ -562,8 +645,13 @@ func (ns *namespace) RouteList(filters ...*Route) ([]*Route, error) {
 
 			onlink := r.Flags&int(netlink.FLAG_ONLINK) != 0
 
+			dst := toPrefix(r.Dst)
+			if r.Dst == nil && r.Family == netlink.FAMILY_V6 {
+				dst = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+			}
+
 			route := &Route{
-				Destination: toPrefix(r.Dst),
+				Destination: dst,
 				Source:      toAddr(r.Src),
 				Gateway:     toAddr(r.Gw),
 				Device:      dev,
@@ -573,6 +661,7 @@ func (ns *namespace) RouteList(filters ...*Route) ([]*Route, error) {
 				Protocol:    RouteProtocol(r.Protocol),
 				Scope:       RouteScope(r.Scope),
 				Onlink:      onlink,
+				Family:      Family(r.Family),
 			}
 
 			routes = append(routes, route)
@@ -787,6 +876,119 @@ func (ns *namespace) RuleList(rule *Rule) ([]*Rule, error) {
 	return rules, nil
 }
 
+// XfrmState is an ESP security association in transport mode that uses AES-GCM (RFC 4106).
+// Key holds the AES key followed by a 4 byte salt.
+type XfrmState struct {
+	Src netip.Addr
+	Dst netip.Addr
+	SPI int
+	Key []byte
+}
+
+const xfrmAeadICVLen = 128
+
+// XfrmStateAdd adds a security association
+func (ns *namespace) XfrmStateAdd(state *XfrmState) error {
+	nlState := &netlink.XfrmState{
+		Src:   toIP(state.Src),
+		Dst:   toIP(state.Dst),
+		Proto: netlink.XFRM_PROTO_ESP,
+		Mode:  netlink.XFRM_MODE_TRANSPORT,
+		Spi:   state.SPI,
+		Aead: &netlink.XfrmStateAlgo{
+			Name:   "rfc4106(gcm(aes))",
+			Key:    state.Key,
+			ICVLen: xfrmAeadICVLen,
+		},
+		ReplayWindow: 32,
+	}
+
+	if err := ns.handle.XfrmStateAdd(nlState); err != nil {
+		return fmt.Errorf("failed to add a security association %s -> %s (spi 0x%x): %w", state.Src, state.Dst, state.SPI, err)
+	}
+	return nil
+}
+
+// XfrmStateDel deletes the ESP security associations with an SPI
+func (ns *namespace) XfrmStateDel(spi int) error {
+	nlStates, err := ns.handle.XfrmStateList(netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to get security associations: %w", err)
+	}
+
+	for i := range nlStates {
+		nlState := &nlStates[i]
+		if nlState.Proto != netlink.XFRM_PROTO_ESP || nlState.Spi != spi {
+			continue
+		}
+		if err := ns.handle.XfrmStateDel(nlState); err != nil {
+			return fmt.Errorf("failed to delete a security association (spi 0x%x): %w", spi, err)
+		}
+	}
+	return nil
+}
+
+type XfrmDir int
+
+const (
+	XFRM_DIR_IN  = XfrmDir(netlink.XFRM_DIR_IN)
+	XFRM_DIR_OUT = XfrmDir(netlink.XFRM_DIR_OUT)
+)
+
+// XfrmPolicy requires UDP traffic between two hosts to a destination port to be protected by the
+// security association with an SPI
+type XfrmPolicy struct {
+	Src     netip.Addr
+	Dst     netip.Addr
+	DstPort int
+	Dir     XfrmDir
+	SPI     int
+}
+
+// XfrmPolicyAdd adds a security policy
+func (ns *namespace) XfrmPolicyAdd(policy *XfrmPolicy) error {
+	nlPolicy := &netlink.XfrmPolicy{
+		Src:     toIPNet(netip.PrefixFrom(policy.Src, policy.Src.BitLen())),
+		Dst:     toIPNet(netip.PrefixFrom(policy.Dst, policy.Dst.BitLen())),
+		Proto:   unix.IPPROTO_UDP,
+		DstPort: policy.DstPort,
+		Dir:     netlink.Dir(policy.Dir),
+		Tmpls: []netlink.XfrmPolicyTmpl{
+			{
+				Src:   toIP(policy.Src),
+				Dst:   toIP(policy.Dst),
+				Proto: netlink.XFRM_PROTO_ESP,
+				Mode:  netlink.XFRM_MODE_TRANSPORT,
+				Spi:   policy.SPI,
+			},
+		},
+	}
+
+	if err := ns.handle.XfrmPolicyAdd(nlPolicy); err != nil {
+		return fmt.Errorf("failed to add a security policy %s -> %s:%d: %w", policy.Src, policy.Dst, policy.DstPort, err)
+	}
+	return nil
+}
+
+// XfrmPolicyDel deletes the security policies that use the security association with an SPI
+func (ns *namespace) XfrmPolicyDel(spi int) error {
+	nlPolicies, err := ns.handle.XfrmPolicyList(netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to get security policies: %w", err)
+	}
+
+	for i := range nlPolicies {
+		nlPolicy := &nlPolicies[i]
+		if len(nlPolicy.Tmpls) != 1 || nlPolicy.Tmpls[0].Spi != spi {
+			continue
+		}
+		if err := ns.handle.XfrmPolicyDel(nlPolicy); err != nil {
+			return fmt.Errorf("failed to delete a security policy (spi 0x%x): %w", spi, err)
+		}
+	}
+	return nil
+}
+
 type Neighbor struct {
 	IP           netip.Addr
 	HardwareAddr string
@@ -974,7 +1176,7 @@ func (ns *namespace) RedirectAdd(src, dst string) error {
 	return nil
 }
 
-// RedirectDel deletes a tc ingress qdisc and redirect filters on src
+// RedirectDel deletes a tc ingress qdisc and redirect filters on src, which are added by either RedirectAdd or BPFRedirectAdd
 func (ns *namespace) RedirectDel(src string) error {
 	srcLink, err := ns.handle.LinkByName(src)
 	if err != nil {
@@ -986,7 +1188,8 @@ func (ns *namespace) RedirectDel(src string) error {
 		return fmt.Errorf("failed to get a list of filters on %s: %w", src, err)
 	}
 	for _, filter := range filters {
-		if _, ok := filter.(*netlink.U32); ok {
+		switch filter.(type) {
+		case *netlink.U32, *netlink.BpfFilter:
 			if err = ns.handle.FilterDel(filter); err != nil {
 				return fmt.Errorf("failed to delete a filter to %s : %w", src, err)
 			}
@@ -1047,3 +1250,65 @@ func toIPNet(prefix netip.Prefix) *net.IPNet {
 		Mask: net.CIDRMask(prefix.Bits(), addr.BitLen()),
 	}
 }
+
+// Latency of packets queued by the token bucket filter. This is the same as the default of the CNI bandwidth plugin.
+const shapingLatency = 25 * time.Millisecond
+
+// ShapingAdd adds a token bucket filter as the root qdisc of an interface to limit its transmission rate in bits per second
+func (ns *namespace) ShapingAdd(name string, rate uint64) error {
+	link, err := ns.handle.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to get interface %s: %w", name, err)
+	}
+
+	rateInBytes := rate / 8
+	if rateInBytes == 0 {
+		return fmt.Errorf("rate is too small: %d bits per second", rate)
+	}
+
+	// Allow bursts of 100 milliseconds, and at least one GSO packet
+	burst := max(rateInBytes/10, 1<<16)
+	if burst > math.MaxUint32 {
+		burst = math.MaxUint32
+	}
+	limit := min(rateInBytes*uint64(shapingLatency.Microseconds())/1e6+burst, math.MaxUint32)
+
+	qdisc := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rateInBytes,
+		Limit:  uint32(limit),
+		Buffer: netlink.Xmittime(rateInBytes, uint32(burst)),
+	}
+
+	if err := ns.handle.QdiscReplace(qdisc); err != nil {
+		return fmt.Errorf("failed to add a token bucket filter to %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// ShapingDel deletes a token bucket filter added by ShapingAdd
+func (ns *namespace) ShapingDel(name string) error {
+	link, err := ns.handle.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to get interface %s: %w", name, err)
+	}
+
+	qdiscs, err := ns.handle.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("failed to get a list of qdiscs on %s: %w", name, err)
+	}
+	for _, qdisc := range qdiscs {
+		if tbf, ok := qdisc.(*netlink.Tbf); ok && tbf.Attrs().Parent == netlink.HANDLE_ROOT {
+			if err := ns.handle.QdiscDel(qdisc); err != nil {
+				return fmt.Errorf("failed to delete a token bucket filter on %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
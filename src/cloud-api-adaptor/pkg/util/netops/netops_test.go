@@ -4,10 +4,15 @@
 package netops
 
 import (
+	"errors"
+	"net"
+	"net/netip"
 	"runtime"
 	"testing"
+	"time"
 
 	testutils "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/pkg/internal/testing"
+	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 )
 
@@ -54,3 +59,327 @@ func TestRouteList(t *testing.T) {
 		t.Logf("Route: dst:%s, gw:%s, dev:%s, prio: %d", route.Destination.String(), route.Gateway.String(), route.Device, route.Priority)
 	}
 }
+
+func TestRouteListIPv6(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	oldns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Failed to get the current network namespace: %v", err)
+	}
+
+	podns, err := netns.New()
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer func() {
+		if err := netns.Set(oldns); err != nil {
+			t.Fatalf("Failed to set a network namespace: %v", err)
+		}
+		if err := podns.Close(); err != nil {
+			t.Fatalf("Failed to close a network namespace: %v", err)
+		}
+	}()
+
+	ns, err := OpenCurrentNamespace()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer ns.Close()
+
+	link, err := ns.LinkAdd("veth0", &VEth{PeerName: "veth1", PeerNamespace: ns})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	peer, err := ns.LinkFind("veth1")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	for _, l := range []Link{link, peer} {
+		if err := l.SetUp(); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+
+	if err := link.AddAddr(netip.MustParsePrefix("192.168.0.2/24")); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := link.AddAddr(netip.MustParsePrefix("fd00::2/64")); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	addrs, err := link.GetAddrByFamily(FAMILY_V6)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	var found bool
+	for _, addr := range addrs {
+		if addr == netip.MustParsePrefix("fd00::2/64") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expect fd00::2/64 in %v", addrs)
+	}
+
+	if err := ns.RouteAdd(&Route{Gateway: netip.MustParseAddr("fd00::1"), Device: "veth0"}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	routes, err := ns.RouteList(DefaultRouteFilters(FAMILY_V6)...)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	var hasDefault, hasPrefix bool
+	for _, route := range routes {
+		if route.Family != FAMILY_V6 {
+			t.Fatalf("Expect IPv6 routes only, got %s", route.Destination)
+		}
+		switch route.Destination {
+		case netip.MustParsePrefix("::/0"):
+			hasDefault = route.Gateway == netip.MustParseAddr("fd00::1")
+		case netip.MustParsePrefix("fd00::/64"):
+			hasPrefix = true
+		}
+	}
+	if !hasDefault || !hasPrefix {
+		t.Fatalf("Expect a default route and a prefix route, got %v", routes)
+	}
+
+	routes, err = ns.RouteList()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	for _, route := range routes {
+		if route.Family != FAMILY_V4 {
+			t.Fatalf("Expect IPv4 routes only, got %s", route.Destination)
+		}
+	}
+}
+
+func TestBPFRedirect(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	oldns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Failed to get the current network namespace: %v", err)
+	}
+
+	podns, err := netns.New()
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer func() {
+		if err := netns.Set(oldns); err != nil {
+			t.Fatalf("Failed to set a network namespace: %v", err)
+		}
+		if err := podns.Close(); err != nil {
+			t.Fatalf("Failed to close a network namespace: %v", err)
+		}
+	}()
+
+	ns, err := OpenCurrentNamespace()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer ns.Close()
+
+	for _, name := range []string{"veth0", "veth2"} {
+		if _, err := ns.LinkAdd(name, &VEth{PeerName: name + "p", PeerNamespace: ns}); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+
+	if err := ns.BPFRedirectAdd("veth0", "veth2"); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	impl := ns.(*namespace)
+	link, err := impl.handle.LinkByName("veth0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	filters, err := impl.handle.FilterList(link, netlink.MakeHandle(0xffff, 0))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if len(filters) != 1 {
+		t.Fatalf("Expect 1 filter, got %d", len(filters))
+	}
+	if filter, ok := filters[0].(*netlink.BpfFilter); !ok || !filter.DirectAction {
+		t.Fatalf("Expect a BPF filter in direct action mode, got %#v", filters[0])
+	}
+
+	if err := ns.RedirectDel("veth0"); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	filters, err = impl.handle.FilterList(link, netlink.MakeHandle(0xffff, 0))
+	if err == nil && len(filters) != 0 {
+		t.Fatalf("Expect no filters, got %d", len(filters))
+	}
+}
+
+func TestShaping(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	oldns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Failed to get the current network namespace: %v", err)
+	}
+
+	podns, err := netns.New()
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer func() {
+		if err := netns.Set(oldns); err != nil {
+			t.Fatalf("Failed to set a network namespace: %v", err)
+		}
+		if err := podns.Close(); err != nil {
+			t.Fatalf("Failed to close a network namespace: %v", err)
+		}
+	}()
+
+	ns, err := OpenCurrentNamespace()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer ns.Close()
+
+	if _, err := ns.LinkAdd("veth0", &VEth{PeerName: "veth1", PeerNamespace: ns}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	// A limit can be replaced
+	for _, rate := range []uint64{10_000_000, 1_000_000} {
+		if err := ns.ShapingAdd("veth0", rate); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+
+	impl := ns.(*namespace)
+	link, err := impl.handle.LinkByName("veth0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	findTbf := func() *netlink.Tbf {
+		qdiscs, err := impl.handle.QdiscList(link)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		for _, qdisc := range qdiscs {
+			if tbf, ok := qdisc.(*netlink.Tbf); ok {
+				return tbf
+			}
+		}
+		return nil
+	}
+
+	tbf := findTbf()
+	if tbf == nil {
+		t.Fatal("Expect a token bucket filter")
+	}
+	if tbf.Rate != 1_000_000/8 {
+		t.Fatalf("Expect rate %d, got %d", 1_000_000/8, tbf.Rate)
+	}
+
+	if err := ns.ShapingDel("veth0"); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if tbf := findTbf(); tbf != nil {
+		t.Fatalf("Expect no token bucket filter, got %#v", tbf)
+	}
+}
+
+func TestARPProbeFrame(t *testing.T) {
+
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:01")
+	target := netip.MustParseAddr("172.16.0.2")
+
+	frame := arpProbeFrame(hwAddr, target)
+	if len(frame) != ethHeaderLen+arpLen {
+		t.Fatalf("Expect %d bytes, got %d", ethHeaderLen+arpLen, len(frame))
+	}
+	if isARPReply(frame, target) {
+		t.Fatal("Expect a request not to be a reply")
+	}
+
+	// A reply swaps the sender and target of the request
+	reply := append([]byte{}, frame...)
+	reply[ethHeaderLen+7] = arpOpReply
+	copy(reply[ethHeaderLen+14:ethHeaderLen+18], frame[ethHeaderLen+24:ethHeaderLen+28])
+	if !isARPReply(reply, target) {
+		t.Fatal("Expect a reply from the target")
+	}
+	if isARPReply(reply, netip.MustParseAddr("172.16.0.3")) {
+		t.Fatal("Expect a reply from another address not to match")
+	}
+}
+
+func TestARPProbe(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	oldns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Failed to get the current network namespace: %v", err)
+	}
+
+	podns, err := netns.New()
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer func() {
+		if err := netns.Set(oldns); err != nil {
+			t.Fatalf("Failed to set a network namespace: %v", err)
+		}
+		if err := podns.Close(); err != nil {
+			t.Fatalf("Failed to close a network namespace: %v", err)
+		}
+	}()
+
+	ns, err := OpenCurrentNamespace()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer ns.Close()
+
+	veth0, err := ns.LinkAdd("veth0", &VEth{PeerName: "veth1", PeerNamespace: ns})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	veth1, err := ns.LinkFind("veth1")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := veth1.AddAddr(netip.MustParsePrefix("172.16.0.2/24")); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	for _, link := range []Link{veth0, veth1} {
+		if err := link.SetUp(); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+
+	if err := ns.ARPProbe("veth0", netip.MustParseAddr("172.16.0.2"), time.Second); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	err = ns.ARPProbe("veth0", netip.MustParseAddr("172.16.0.3"), 100*time.Millisecond)
+	if !errors.Is(err, ErrNoARPReply) {
+		t.Fatalf("Expect %v, got %v", ErrNoARPReply, err)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package streamcompress implements negotiated compression of stream connections.
+//
+// A client that wants compression starts the connection with a preface line listing the
+// algorithms it supports in order of preference, and the server answers with the algorithm
+// it selected, or "none". Both sides then compress everything they send with that algorithm.
+// A server also accepts clients that do not send a preface, and passes their data through unchanged.
+// A client detects a server that does not support compression when it does not answer the preface,
+// and has to reconnect without compression, since the server received the preface as data.
+package streamcompress
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	S2   = "s2"
+	Zstd = "zstd"
+	None = "none"
+
+	prefaceMagic     = "CAA-COMPRESS/1 "
+	maxPrefaceLength = 256
+
+	// NegotiationTimeout is the maximum time a client waits for the answer of a server
+	NegotiationTimeout = 10 * time.Second
+)
+
+// ErrUnsupported is returned by Client when the server does not answer the preface, e.g. because it does not support compression
+var ErrUnsupported = errors.New("server does not support compression")
+
+// Supported lists the supported compression algorithms in order of preference
+var Supported = []string{Zstd, S2}
+
+// ParseAlgorithms parses a comma separated list of compression algorithms.
+// An empty string or "none" disables compression.
+func ParseAlgorithms(list string) ([]string, error) {
+	if list == "" || list == None {
+		return nil, nil
+	}
+
+	var algorithms []string
+	for _, algorithm := range strings.Split(list, ",") {
+		algorithm = strings.TrimSpace(algorithm)
+		if !isSupported(algorithm) {
+			return nil, fmt.Errorf("unsupported compression algorithm %q: supported algorithms are %s", algorithm, strings.Join(Supported, ", "))
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	return algorithms, nil
+}
+
+func isSupported(algorithm string) bool {
+	for _, a := range Supported {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// Client negotiates compression with the server end of conn, offering algorithms in order of preference.
+// The returned connection compresses data with the selected algorithm, if any. The answer of the server is
+// awaited until the deadline of ctx, or at most NegotiationTimeout. ErrUnsupported is returned if the server
+// does not answer with a valid preface, and conn must not be used anymore then.
+func Client(ctx context.Context, conn net.Conn, algorithms []string) (net.Conn, error) {
+	if len(algorithms) == 0 {
+		return conn, nil
+	}
+
+	deadline := time.Now().Add(NegotiationTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(conn, prefaceMagic+strings.Join(algorithms, ",")+"\n"); err != nil {
+		return nil, fmt.Errorf("sending compression preface: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading compression preface: %w", ErrUnsupported, err)
+	}
+
+	selected, ok := strings.CutPrefix(line, prefaceMagic)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected compression preface %q", ErrUnsupported, line)
+	}
+	if selected != None && !contains(algorithms, selected) {
+		return nil, fmt.Errorf("%w: server selected an algorithm that was not offered: %q", ErrUnsupported, selected)
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return newConn(conn, reader, selected)
+}
+
+// Server returns a connection that negotiates compression with the client end of conn,
+// selecting the first of the algorithms offered by the client that is in algorithms.
+// Negotiation happens on the first read or write, so that it does not block the accept loop.
+func Server(conn net.Conn, algorithms []string) net.Conn {
+	return &serverConn{Conn: conn, algorithms: algorithms}
+}
+
+// Listener returns a listener whose accepted connections negotiate compression as a server
+func Listener(l net.Listener, algorithms []string) net.Listener {
+	return &listener{Listener: l, algorithms: algorithms}
+}
+
+type listener struct {
+	net.Listener
+	algorithms []string
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Server(conn, l.algorithms), nil
+}
+
+type serverConn struct {
+	net.Conn
+	algorithms []string
+	once       sync.Once
+	conn       net.Conn
+	err        error
+}
+
+func (c *serverConn) negotiate() (net.Conn, error) {
+	c.once.Do(func() {
+		reader := bufio.NewReader(c.Conn)
+
+		head, err := reader.Peek(len(prefaceMagic))
+		if err != nil && !(err == io.EOF && len(head) > 0) {
+			c.err = err
+			return
+		}
+
+		if !bytes.Equal(head, []byte(prefaceMagic)) {
+			// The client does not use compression
+			c.conn = &bufferedConn{Conn: c.Conn, reader: reader}
+			return
+		}
+
+		line, err := readLine(reader)
+		if err != nil {
+			c.err = fmt.Errorf("reading compression preface: %w", err)
+			return
+		}
+
+		selected := None
+		for _, offered := range strings.Split(strings.TrimPrefix(line, prefaceMagic), ",") {
+			if contains(c.algorithms, offered) && isSupported(offered) {
+				selected = offered
+				break
+			}
+		}
+
+		if _, err := io.WriteString(c.Conn, prefaceMagic+selected+"\n"); err != nil {
+			c.err = fmt.Errorf("sending compression preface: %w", err)
+			return
+		}
+
+		c.conn, c.err = newConn(c.Conn, reader, selected)
+	})
+	return c.conn, c.err
+}
+
+func (c *serverConn) Read(b []byte) (int, error) {
+	conn, err := c.negotiate()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(b)
+}
+
+func (c *serverConn) Write(b []byte) (int, error) {
+	conn, err := c.negotiate()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(b)
+}
+
+func (c *serverConn) Close() error {
+	// Closing the connection first unblocks a negotiation in progress
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.err = net.ErrClosed
+	})
+	if conn, ok := c.conn.(*compressedConn); ok {
+		conn.release()
+	}
+	return err
+}
+
+// NetConn returns the wrapped connection
+func (c *serverConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// readLine reads a preface line without its line terminator
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for len(line) <= maxPrefaceLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b)
+	}
+	return "", fmt.Errorf("compression preface exceeds %d bytes", maxPrefaceLength)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// compressedConn compresses written data and decompresses read data. Each write is
+// flushed, so that messages are delivered without waiting for more data.
+type compressedConn struct {
+	net.Conn
+	reader     io.Reader
+	writer     flushWriter
+	writeMutex sync.Mutex
+	closer     func()
+	closeOnce  sync.Once
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, algorithm string) (net.Conn, error) {
+	switch algorithm {
+	case None:
+		if reader.Buffered() > 0 {
+			return &bufferedConn{Conn: conn, reader: reader}, nil
+		}
+		return conn, nil
+	case S2:
+		return &compressedConn{
+			Conn:   conn,
+			reader: s2.NewReader(reader),
+			writer: s2.NewWriter(conn, s2.WriterConcurrency(1)),
+		}, nil
+	case Zstd:
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		encoder, err := zstd.NewWriter(conn, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			decoder.Close()
+			return nil, err
+		}
+		return &compressedConn{
+			Conn:   conn,
+			reader: decoder,
+			writer: encoder,
+			closer: decoder.Close,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
+
+func (c *compressedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *compressedConn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	n, err := c.writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.writer.Flush()
+}
+
+func (c *compressedConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// release frees the resources of the decompressor
+func (c *compressedConn) release() {
+	c.closeOnce.Do(func() {
+		if c.closer != nil {
+			c.closer()
+		}
+	})
+}
+
+// NetConn returns the wrapped connection
+func (c *compressedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// bufferedConn returns data that was read ahead during negotiation before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// NetConn returns the wrapped connection
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,189 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package streamcompress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echo starts a server that negotiates with serverAlgorithms and echoes what it reads
+func echo(t *testing.T, serverAlgorithms []string) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	listener := Listener(l, serverAlgorithms)
+
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// countingConn records the bytes written to the wire
+type countingConn struct {
+	net.Conn
+	written int
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written += n
+	return n, err
+}
+
+func roundTrip(t *testing.T, addr string, clientAlgorithms []string, message []byte) int {
+	t.Helper()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	counting := &countingConn{Conn: c}
+
+	conn, err := Client(context.Background(), counting, clientAlgorithms)
+	if err != nil {
+		t.Fatalf("failed to negotiate compression: %v", err)
+	}
+	defer conn.Close()
+
+	// Several messages must each be delivered without waiting for more data
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write(message); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		buf := make([]byte, len(message))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if !bytes.Equal(buf, message) {
+			t.Fatalf("unexpected echo")
+		}
+	}
+
+	return counting.written
+}
+
+func TestCompression(t *testing.T) {
+	message := []byte(strings.Repeat("compressible agent protocol message ", 100))
+
+	for _, algorithm := range Supported {
+		t.Run(algorithm, func(t *testing.T) {
+			addr := echo(t, Supported)
+			written := roundTrip(t, addr, []string{algorithm}, message)
+			if written >= len(message) {
+				t.Errorf("expected compressed data, wrote %d bytes for %d bytes of messages", written, 3*len(message))
+			}
+		})
+	}
+}
+
+func TestNoCommonAlgorithm(t *testing.T) {
+	message := []byte("hello")
+
+	addr := echo(t, []string{S2})
+	written := roundTrip(t, addr, []string{Zstd}, message)
+	if written != len(prefaceMagic+Zstd+"\n")+3*len(message) {
+		t.Errorf("expected uncompressed data, wrote %d bytes", written)
+	}
+}
+
+func TestClientWithoutCompression(t *testing.T) {
+	message := []byte("plain agent protocol message")
+
+	addr := echo(t, Supported)
+	written := roundTrip(t, addr, nil, message)
+	if written != 3*len(message) {
+		t.Errorf("expected uncompressed data, wrote %d bytes", written)
+	}
+}
+
+// plain starts a server that does not support compression, and handles its connections with handle
+func plain(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestServerWithoutCompression(t *testing.T) {
+	for name, handle := range map[string]func(net.Conn){
+		// A server that waits for a message it can parse never answers the preface
+		"silent": func(c net.Conn) { _, _ = io.Copy(io.Discard, c) },
+		"echo":   func(c net.Conn) { _, _ = io.Copy(c, c) },
+		"close":  func(c net.Conn) {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			addr := plain(t, handle)
+
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			if _, err := Client(ctx, c, Supported); !errors.Is(err, ErrUnsupported) {
+				t.Fatalf("expected ErrUnsupported, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("negotiation took %s, which exceeds the deadline of the context", elapsed)
+			}
+		})
+	}
+}
+
+func TestParseAlgorithms(t *testing.T) {
+	if algorithms, err := ParseAlgorithms("zstd, s2"); err != nil || len(algorithms) != 2 {
+		t.Errorf("unexpected result: %v, %v", algorithms, err)
+	}
+	if algorithms, err := ParseAlgorithms("none"); err != nil || algorithms != nil {
+		t.Errorf("unexpected result: %v, %v", algorithms, err)
+	}
+	if _, err := ParseAlgorithms("gzip"); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var logger = log.New(log.Writer(), "[util/tlsutil] ", log.LstdFlags|log.Lmsgprefix)
+
+// reloader keeps a tls.Config up to date with the certificate, key and root certificate files
+// it was created from. Files are checked at most once per reloadInterval when a handshake happens.
+type reloader struct {
+	config   TLSConfig
+	mutex    sync.Mutex
+	current  *tls.Config
+	modTimes map[string]time.Time
+	checked  time.Time
+}
+
+var reloadInterval = 10 * time.Second
+
+// reloadsCA reports whether the root certificates are reloaded from a file
+func (r *reloader) reloadsCA() bool {
+	return len(r.config.CAData) == 0 && r.config.CAFile != ""
+}
+
+// newReloader returns nil if none of the TLS data was loaded from files
+func newReloader(t *TLSConfig, current *tls.Config) *reloader {
+	files := make(map[string]time.Time)
+	if len(t.CAData) == 0 && t.CAFile != "" {
+		files[t.CAFile] = time.Time{}
+	}
+	if len(t.CertData) == 0 && t.CertFile != "" {
+		files[t.CertFile] = time.Time{}
+	}
+	if len(t.KeyData) == 0 && t.KeyFile != "" {
+		files[t.KeyFile] = time.Time{}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	r := &reloader{
+		config:   *t,
+		current:  current.Clone(),
+		modTimes: files,
+		checked:  time.Now(),
+	}
+	r.changed()
+
+	return r
+}
+
+// changed updates the recorded modification times and reports whether any file changed
+func (r *reloader) changed() bool {
+	changed := false
+	for file, modTime := range r.modTimes {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(modTime) {
+			r.modTimes[file] = info.ModTime()
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (r *reloader) get() *tls.Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.checked) < reloadInterval {
+		return r.current
+	}
+	r.checked = time.Now()
+
+	if !r.changed() {
+		return r.current
+	}
+
+	loaded := r.config
+	if err := loadTLSFiles(&loaded); err != nil {
+		logger.Printf("failed to reload TLS files, keep using the current certificates: %v", err)
+		return r.current
+	}
+	config, err := newTLSConfig(&loaded)
+	if err != nil {
+		logger.Printf("failed to reload TLS files, keep using the current certificates: %v", err)
+		return r.current
+	}
+
+	logger.Printf("reloaded TLS certificates")
+	r.current = config
+
+	return r.current
+}
+
+func (r *reloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.get(), nil
+}
+
+func (r *reloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	config := r.get()
+	if len(config.Certificates) == 0 {
+		return &tls.Certificate{}, nil
+	}
+	return &config.Certificates[0], nil
+}
+
+// verifyServerCertificate verifies the certificate of a server with the current root certificates.
+// The RootCAs of a client can't be replaced after the handshake starts, so clients that reload their root
+// certificates skip the default verification and verify the server certificate with this function instead
+func (r *reloader) verifyServerCertificate(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.get().RootCAs,
+		DNSName:       state.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// CertificatePEM returns the PEM encoded certificate chain of a tls.Config returned by GetTLSConfigFor,
+// or nil if it has no certificate. The current certificate is returned when the certificate file is reloaded
+func CertificatePEM(config *tls.Config) []byte {
+	var cert *tls.Certificate
+	if config.GetClientCertificate != nil {
+		c, err := config.GetClientCertificate(&tls.CertificateRequestInfo{})
+		if err != nil {
+			return nil
+		}
+		cert = c
+	} else if len(config.Certificates) > 0 {
+		cert = &config.Certificates[0]
+	}
+	if cert == nil {
+		return nil
+	}
+
+	var certPEM []byte
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return certPEM
+}
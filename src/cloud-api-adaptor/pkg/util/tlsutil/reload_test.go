@@ -0,0 +1,124 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package tlsutil
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadCertificate(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	certPEM, keyPEM, err := NewClientCertificate("cloud-api-adaptor")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+
+	tlsConfig := &TLSConfig{CertFile: certFile, KeyFile: keyFile, SkipVerify: true}
+
+	config, err := GetTLSConfigFor(tlsConfig)
+	require.NoError(t, err)
+	require.NotNil(t, config.GetClientCertificate)
+
+	// The caller's config must keep referring to the files
+	assert.Empty(t, tlsConfig.CertData)
+
+	cert, err := config.GetClientCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, config.Certificates[0].Certificate, cert.Certificate)
+
+	defer func(interval time.Duration) { reloadInterval = interval }(reloadInterval)
+	reloadInterval = 0
+
+	newCertPEM, newKeyPEM, err := NewClientCertificate("cloud-api-adaptor")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, newCertPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, newKeyPEM, 0600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.NoError(t, os.Chtimes(keyFile, future, future))
+
+	newCert, err := tls.X509KeyPair(newCertPEM, newKeyPEM)
+	require.NoError(t, err)
+
+	cert, err = config.GetClientCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, newCert.Certificate, cert.Certificate)
+	assert.Equal(t, newCertPEM, CertificatePEM(config))
+
+	serverConfig, err := config.GetConfigForClient(nil)
+	require.NoError(t, err)
+	assert.Equal(t, newCert.Certificate, serverConfig.Certificates[0].Certificate)
+}
+
+func TestReloadCA(t *testing.T) {
+
+	serverName := "server1"
+
+	caService, err := NewCAService("agent-protocol-forwarder")
+	require.NoError(t, err)
+	serverCertPEM, serverKeyPEM, err := caService.Issue(serverName)
+	require.NoError(t, err)
+	otherCAService, err := NewCAService("agent-protocol-forwarder")
+	require.NoError(t, err)
+
+	serverConfig, err := GetTLSConfigFor(&TLSConfig{CertData: serverCertPEM, KeyData: serverKeyPEM})
+	require.NoError(t, err)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, otherCAService.RootCertificate(), 0600))
+
+	clientConfig, err := GetTLSConfigFor(&TLSConfig{CAFile: caFile})
+	require.NoError(t, err)
+	clientConfig.ServerName = serverName
+
+	dial := func() error {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// The server certificate is not issued by the root certificate of the client
+	assert.Error(t, dial())
+
+	defer func(interval time.Duration) { reloadInterval = interval }(reloadInterval)
+	reloadInterval = 0
+
+	require.NoError(t, os.WriteFile(caFile, caService.RootCertificate(), 0600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(caFile, future, future))
+
+	assert.NoError(t, dial())
+
+	// The server name is still verified
+	clientConfig.ServerName = "server2"
+	assert.Error(t, dial())
+}
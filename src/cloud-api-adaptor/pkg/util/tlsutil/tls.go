@@ -112,6 +112,8 @@ func createErrorParsingCAData(pemCerts []byte) error {
 
 // GetTLSConfigFor returns a tls.Config that will provide the transport level security defined
 // by the provided Config. Will return nil if no transport level security is requested.
+// Certificates, keys and root certificates given as files are reloaded when the files change,
+// so that they can be rotated without restarting.
 func GetTLSConfigFor(t *TLSConfig) (*tls.Config, error) {
 	if !(t.HasCA() || t.HasCertAuth() || t.SkipVerify) {
 		return nil, nil
@@ -119,10 +121,33 @@ func GetTLSConfigFor(t *TLSConfig) (*tls.Config, error) {
 	if t.HasCA() && t.SkipVerify {
 		return nil, fmt.Errorf("specifying a root certificates file with the insecure flag is not allowed")
 	}
-	if err := loadTLSFiles(t); err != nil {
+
+	// Load files into a copy, so that the caller's config keeps referring to the files
+	loaded := *t
+	if err := loadTLSFiles(&loaded); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(&loaded)
+	if err != nil {
 		return nil, err
 	}
 
+	if r := newReloader(t, tlsConfig); r != nil {
+		tlsConfig.GetConfigForClient = r.getConfigForClient
+		tlsConfig.GetClientCertificate = r.getClientCertificate
+		if r.reloadsCA() && !t.SkipVerify {
+			// Servers get the reloaded ClientCAs from GetConfigForClient, and clients verify
+			// the server certificate with the reloaded RootCAs in VerifyConnection
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyConnection = r.verifyServerCertificate
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func newTLSConfig(t *TLSConfig) (*tls.Config, error) {
 	tlsConfig := &tls.Config{
 		// Can't use SSLv3 because of POODLE and BEAST
 		// Can't use TLSv1.0 because of POODLE and BEAST using CBC cipher
@@ -0,0 +1,134 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wgutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	// DefaultPort is the default WireGuard listen port
+	DefaultPort = "51820"
+
+	keyLen = 32
+)
+
+// GenerateKey returns a new base64 encoded WireGuard private key and its public key
+func GenerateKey() (privateKey, publicKey []byte, err error) {
+	var key [keyLen]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate a WireGuard private key: %w", err)
+	}
+
+	// Clamp the private key as described in https://cr.yp.to/ecdh.html
+	key[0] &= 248
+	key[31] = (key[31] & 127) | 64
+
+	privateKey = []byte(base64.StdEncoding.EncodeToString(key[:]))
+
+	publicKey, err = PublicKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return privateKey, publicKey, nil
+}
+
+// PublicKey returns the base64 encoded public key of a base64 encoded WireGuard private key
+func PublicKey(privateKey []byte) ([]byte, error) {
+	key, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive a WireGuard public key: %w", err)
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(pub)), nil
+}
+
+func decodeKey(key []byte) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(key)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WireGuard key: %w", err)
+	}
+	if len(decoded) != keyLen {
+		return nil, fmt.Errorf("invalid WireGuard key length: %d", len(decoded))
+	}
+	return decoded, nil
+}
+
+// Peer holds the configuration of a WireGuard peer
+type Peer struct {
+	PublicKey           []byte
+	Endpoint            string
+	AllowedIPs          []netip.Prefix
+	PersistentKeepalive int
+}
+
+// Configure sets the private key and the listen port of a WireGuard interface
+func Configure(ifName string, privateKey []byte, listenPort int) error {
+	if _, err := decodeKey(privateKey); err != nil {
+		return err
+	}
+
+	args := []string{"set", ifName, "private-key", "/dev/stdin"}
+	if listenPort > 0 {
+		args = append(args, "listen-port", strconv.Itoa(listenPort))
+	}
+
+	return run(bytes.TrimSpace(privateKey), args...)
+}
+
+// AddPeer adds a peer to a WireGuard interface, or updates the peer if it already exists
+func AddPeer(ifName string, peer *Peer) error {
+	if _, err := decodeKey(peer.PublicKey); err != nil {
+		return err
+	}
+
+	args := []string{"set", ifName, "peer", strings.TrimSpace(string(peer.PublicKey))}
+
+	if peer.Endpoint != "" {
+		args = append(args, "endpoint", peer.Endpoint)
+	}
+
+	var allowedIPs []string
+	for _, prefix := range peer.AllowedIPs {
+		allowedIPs = append(allowedIPs, prefix.String())
+	}
+	args = append(args, "allowed-ips", strings.Join(allowedIPs, ","))
+
+	if peer.PersistentKeepalive > 0 {
+		args = append(args, "persistent-keepalive", strconv.Itoa(peer.PersistentKeepalive))
+	}
+
+	return run(nil, args...)
+}
+
+// RemovePeer removes a peer from a WireGuard interface
+func RemovePeer(ifName string, publicKey []byte) error {
+	return run(nil, "set", ifName, "peer", strings.TrimSpace(string(publicKey)), "remove")
+}
+
+func run(stdin []byte, args ...string) error {
+	cmd := exec.Command("wg", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run wg %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wgutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	privateKey, publicKey, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	derived, err := PublicKey(privateKey)
+	if err != nil {
+		t.Fatalf("PublicKey: %v", err)
+	}
+	if !bytes.Equal(derived, publicKey) {
+		t.Errorf("expected public key %s, got %s", publicKey, derived)
+	}
+
+	otherPrivateKey, _, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if bytes.Equal(privateKey, otherPrivateKey) {
+		t.Errorf("expected different private keys")
+	}
+}
+
+func TestPublicKeyInvalid(t *testing.T) {
+	for _, key := range []string{"", "not base64", "c2hvcnQ="} {
+		if _, err := PublicKey([]byte(key)); err == nil {
+			t.Errorf("expected an error for key %q", key)
+		}
+	}
+}
@@ -11,10 +11,11 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 // TODO: Use IAM role
-func NewEC2Client(cloudCfg Config) (*ec2.Client, error) {
+func loadAWSConfig(cloudCfg Config) (aws.Config, error) {
 
 	var cfg aws.Config
 	var err error
@@ -23,7 +24,7 @@ func NewEC2Client(cloudCfg Config) (*ec2.Client, error) {
 		cfg, err = config.LoadDefaultConfig(context.TODO(),
 			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cloudCfg.AccessKeyId, cloudCfg.SecretKey, "")), config.WithRegion(cloudCfg.Region))
 		if err != nil {
-			return nil, fmt.Errorf("configuration error when using creds: %s", err)
+			return cfg, fmt.Errorf("configuration error when using creds: %s", err)
 		}
 
 	} else {
@@ -32,9 +33,28 @@ func NewEC2Client(cloudCfg Config) (*ec2.Client, error) {
 			config.WithRegion(cloudCfg.Region),
 			config.WithSharedConfigProfile(cloudCfg.LoginProfile))
 		if err != nil {
-			return nil, fmt.Errorf("configuration error when using shared profile: %s", err)
+			return cfg, fmt.Errorf("configuration error when using shared profile: %s", err)
 		}
 	}
+	return cfg, nil
+}
+
+func NewEC2Client(cloudCfg Config) (*ec2.Client, error) {
+
+	cfg, err := loadAWSConfig(cloudCfg)
+	if err != nil {
+		return nil, err
+	}
 	client := ec2.NewFromConfig(cfg)
 	return client, nil
 }
+
+func NewS3Client(cloudCfg Config) (*s3.Client, error) {
+
+	cfg, err := loadAWSConfig(cloudCfg)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg)
+	return client, nil
+}
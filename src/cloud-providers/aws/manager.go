@@ -7,6 +7,7 @@ import (
 	"flag"
 
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
 )
 
 var awscfg Config
@@ -39,6 +40,8 @@ func (_ *Manager) ParseCmd(flags *flag.FlagSet) {
 	// Default is 30GiBs for free tier. Hence use it as default
 	flags.IntVar(&awscfg.RootVolumeSize, "root-volume-size", 30, "Root volume size (in GiB) for the Pod VMs")
 	flags.BoolVar(&awscfg.DisableCVM, "disable-cvm", false, "Use non-CVMs for peer pods")
+	flags.StringVar(&awscfg.UserDataFormat, "userdata-format", cloudinit.DefaultUserDataFormat, "Userdata format of the Pod VMs: cloud-config or ignition")
+	flags.StringVar(&awscfg.UserDataBucket, "userdata-s3-bucket", "", "S3 bucket to store userdata that exceeds the EC2 limit even when compressed. The bucket must not be public")
 
 }
 
@@ -52,6 +55,36 @@ func (_ *Manager) NewProvider() (provider.Provider, error) {
 	return NewProvider(&awscfg)
 }
 
+func (_ *Manager) NewProviderWithCredentials(credentials map[string]string) (provider.Provider, error) {
+	cfg, err := credentialsConfig(credentials)
+	if err != nil {
+		return nil, err
+	}
+	return NewProvider(cfg)
+}
+
+// credentialsConfig returns a copy of the configuration with the credentials of another account,
+// and the resources of that account unless they are shared with it
+func credentialsConfig(credentials map[string]string) (*Config, error) {
+	cfg := awscfg
+	setters := map[string]func(string) error{
+		"AWS_ACCESS_KEY_ID":     provider.SetString(&cfg.AccessKeyId),
+		"AWS_SECRET_ACCESS_KEY": provider.SetString(&cfg.SecretKey),
+		"AWS_REGION":            provider.SetString(&cfg.Region),
+		"AWS_SUBNET_ID":         provider.SetString(&cfg.SubnetId),
+		"PODVM_AMI_ID":          provider.SetString(&cfg.ImageId),
+		"SSH_KP_NAME":           provider.SetString(&cfg.KeyName),
+		"AWS_SG_IDS": func(value string) error {
+			cfg.SecurityGroupIds = nil
+			return cfg.SecurityGroupIds.Set(value)
+		},
+	}
+	if err := provider.ApplyCredentials(credentials, setters, "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
 func (_ *Manager) GetConfig() (config *Config) {
 	return &awscfg
 }
@@ -6,6 +6,7 @@ package aws
 import (
 	"flag"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -236,3 +237,39 @@ func comparestructs(expected, actual Config) bool {
 
 	return true
 }
+
+func TestCredentialsConfig(t *testing.T) {
+	defer func(saved Config) { awscfg = saved }(awscfg)
+	awscfg = Config{
+		AccessKeyId:      "default-key",
+		SecretKey:        "default-secret",
+		Region:           "default-region",
+		SubnetId:         "default-subnet",
+		SecurityGroupIds: []string{"sg-default"},
+		ImageId:          "ami-shared",
+	}
+
+	cfg, err := credentialsConfig(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "tenant-key",
+		"AWS_SECRET_ACCESS_KEY": "tenant-secret",
+		"AWS_SUBNET_ID":         "tenant-subnet",
+		"AWS_SG_IDS":            "sg-1,sg-2",
+	})
+	if err != nil {
+		t.Fatalf("credentialsConfig failed: %v", err)
+	}
+	if cfg.AccessKeyId != "tenant-key" || cfg.SecretKey != "tenant-secret" || cfg.SubnetId != "tenant-subnet" ||
+		!reflect.DeepEqual([]string(cfg.SecurityGroupIds), []string{"sg-1", "sg-2"}) {
+		t.Errorf("credentials were not applied: %+v", cfg.Redact())
+	}
+	if cfg.Region != "default-region" || cfg.ImageId != "ami-shared" {
+		t.Errorf("configuration was not kept: %+v", cfg.Redact())
+	}
+	if awscfg.AccessKeyId != "default-key" || !reflect.DeepEqual([]string(awscfg.SecurityGroupIds), []string{"sg-default"}) {
+		t.Errorf("default configuration was changed: %+v", awscfg.Redact())
+	}
+
+	if _, err := credentialsConfig(map[string]string{"AWS_ACCESS_KEY_ID": "tenant-key"}); err == nil {
+		t.Error("expected an error without a secret key")
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util"
@@ -33,12 +34,15 @@ var (
 )
 
 const (
-	maxInstanceNameLen = 63
-	maxWaitTime        = 120 * time.Second
-	maxInt32           = 1<<31 - 1
+	maxWaitTime = 120 * time.Second
+	maxInt32    = 1<<31 - 1
 )
 
 // Make ec2Client a mockable interface
+// instanceNameRules are the rules of the Name tags of EC2 instances, which are also part of the keys of
+// userdata objects
+var instanceNameRules = util.InstanceNameRules{MaxLen: 255, Chars: "._"}
+
 type ec2Client interface {
 	RunInstances(ctx context.Context,
 		params *ec2.RunInstancesInput,
@@ -74,6 +78,9 @@ type awsProvider struct {
 	// Make waiter a mockable interface
 	waiter        instanceRunningWaiter
 	serviceConfig *Config
+	// s3Client and s3Presigner are set when userdata that exceeds the limit is stored in S3
+	s3Client    s3Client
+	s3Presigner s3Presigner
 }
 
 func NewProvider(config *Config) (provider.Provider, error) {
@@ -96,6 +103,20 @@ func NewProvider(config *Config) (provider.Provider, error) {
 		serviceConfig: config,
 	}
 
+	if config.UserDataBucket != "" {
+		s3Client, err := NewS3Client(*config)
+		if err != nil {
+			return nil, err
+		}
+		provider.s3Client = s3Client
+		provider.s3Presigner = s3.NewPresignClient(s3Client)
+
+		// Objects are deleted with their instances, and the rule is a fallback, so a missing permission is not fatal
+		if err := provider.ensureUserDataLifecycle(context.TODO()); err != nil {
+			logger.Printf("Userdata objects that are left behind will not expire: %v", err)
+		}
+	}
+
 	// If root volume size is set, then get the device name from the AMI and update the serviceConfig
 	if config.RootVolumeSize > 0 {
 		// Get the device name from the AMI
@@ -154,19 +175,36 @@ func getIPs(instance types.Instance) ([]netip.Addr, error) {
 	return podNodeIPs, nil
 }
 
+func (p *awsProvider) InstanceNameRules() util.InstanceNameRules {
+	return instanceNameRules
+}
+
 func (p *awsProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
 	// Public IP address
 	var publicIPAddr netip.Addr
 
-	instanceName := util.GenerateInstanceName(podName, sandboxID, maxInstanceNameLen)
+	instanceName, err := util.GenerateInstanceName(ctx, podName, sandboxID, instanceNameRules)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudConfig, err = cloudinit.WithUserDataFormat(cloudConfig, p.serviceConfig.UserDataFormat)
+	if err != nil {
+		return nil, err
+	}
 
 	cloudConfigData, err := cloudConfig.Generate()
 	if err != nil {
 		return nil, err
 	}
 
+	userData, userDataKey, err := p.prepareUserData(ctx, instanceName, []byte(cloudConfigData))
+	if err != nil {
+		return nil, err
+	}
+
 	// Convert userData to base64
-	b64EncData := base64.StdEncoding.EncodeToString([]byte(cloudConfigData))
+	b64EncData := base64.StdEncoding.EncodeToString(userData)
 
 	instanceType, err := p.selectInstanceType(ctx, spec)
 	if err != nil {
@@ -180,14 +218,44 @@ func (p *awsProvider) CreateInstance(ctx context.Context, podName, sandboxID str
 		},
 	}
 
-	// Add custom tags (k=v) from serviceConfig.Tags to the instance
+	// Add custom tags (k=v) from serviceConfig.Tags and the spec to the instance
+	customTags := map[string]string{}
 	for k, v := range p.serviceConfig.Tags {
+		customTags[k] = v
+	}
+	for k, v := range spec.Tags {
+		customTags[k] = v
+	}
+	for k, v := range customTags {
 		instanceTags = append(instanceTags, types.Tag{
 			Key:   aws.String(k),
 			Value: aws.String(v),
 		})
 	}
 
+	if clusterID := util.ClusterID(ctx); clusterID != "" {
+		instanceTags = append(instanceTags, types.Tag{
+			Key:   aws.String(provider.ClusterTagKey),
+			Value: aws.String(clusterID),
+		}, types.Tag{
+			Key:   aws.String(provider.SandboxTagKey),
+			Value: aws.String(sandboxID),
+		})
+		if pod := util.InstancePod(ctx, podName); pod != "" {
+			instanceTags = append(instanceTags, types.Tag{
+				Key:   aws.String(provider.PodTagKey),
+				Value: aws.String(pod),
+			})
+		}
+	}
+
+	if userDataKey != "" {
+		instanceTags = append(instanceTags, types.Tag{
+			Key:   aws.String(userDataTagKey),
+			Value: aws.String(userDataKey),
+		})
+	}
+
 	// Create TagSpecifications for the instance
 	tagSpecifications := []types.TagSpecification{
 		{
@@ -282,6 +350,9 @@ func (p *awsProvider) CreateInstance(ctx context.Context, podName, sandboxID str
 
 	result, err := p.ec2Client.RunInstances(ctx, input)
 	if err != nil {
+		if userDataKey != "" {
+			p.deleteUserData(ctx, userDataKey)
+		}
 		return nil, fmt.Errorf("creating instance %s (%v): %w", instanceName, result, err)
 	}
 
@@ -307,9 +378,14 @@ func (p *awsProvider) CreateInstance(ctx context.Context, podName, sandboxID str
 	}
 
 	instance := &provider.Instance{
-		ID:   instanceID,
-		Name: instanceName,
-		IPs:  ips,
+		ID:           instanceID,
+		Name:         instanceName,
+		IPs:          ips,
+		InstanceType: string(result.Instances[0].InstanceType),
+		Zone:         p.serviceConfig.Region,
+	}
+	if placement := result.Instances[0].Placement; placement != nil && placement.AvailabilityZone != nil {
+		instance.Zone = *placement.AvailabilityZone
 	}
 
 	return instance, nil
@@ -324,6 +400,16 @@ func (p *awsProvider) DeleteInstance(ctx context.Context, instanceID string) err
 
 	logger.Printf("Deleting instance %s", instanceID)
 
+	// The key of a userdata object is looked up before the instance and its tags are deleted
+	var userDataKey string
+	if p.s3Client != nil {
+		key, err := p.userDataKey(ctx, instanceID)
+		if err != nil {
+			logger.Printf("failed to look up userdata of instance %s: %v", instanceID, err)
+		}
+		userDataKey = key
+	}
+
 	resp, err := p.ec2Client.TerminateInstances(ctx, terminateInput)
 	if err != nil {
 		logger.Printf("failed to delete instance %v: %v and the response is %v", instanceID, err, resp)
@@ -332,9 +418,61 @@ func (p *awsProvider) DeleteInstance(ctx context.Context, instanceID string) err
 
 	logger.Printf("Deleted instance %s", instanceID)
 
+	if userDataKey != "" {
+		p.deleteUserData(ctx, userDataKey)
+	}
+
 	return nil
 }
 
+func (p *awsProvider) ListInstances(ctx context.Context, clusterID string) ([]provider.InstanceInfo, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:" + provider.ClusterTagKey),
+				Values: []string{clusterID},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running", "stopping", "stopped"},
+			},
+		},
+	}
+
+	var instances []provider.InstanceInfo
+	for {
+		output, err := p.ec2Client.DescribeInstances(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("describing instances of cluster %s: %w", clusterID, err)
+		}
+
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				info := provider.InstanceInfo{ID: aws.ToString(instance.InstanceId)}
+				if instance.LaunchTime != nil {
+					info.Created = *instance.LaunchTime
+				}
+				for _, tag := range instance.Tags {
+					switch aws.ToString(tag.Key) {
+					case "Name":
+						info.Name = aws.ToString(tag.Value)
+					case provider.PodTagKey:
+						info.Pod = aws.ToString(tag.Value)
+					case provider.SandboxTagKey:
+						info.SandboxID = aws.ToString(tag.Value)
+					}
+				}
+				instances = append(instances, info)
+			}
+		}
+
+		if output.NextToken == nil {
+			return instances, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
 func (p *awsProvider) Teardown() error {
 	return nil
 }
@@ -366,12 +504,12 @@ func (p *awsProvider) updateInstanceTypeSpecList() error {
 
 	// Iterate over the instance types and populate the instanceTypeSpecList
 	for _, instanceType := range instanceTypes {
-		vcpus, memory, gpuCount, err := p.getInstanceTypeInformation(instanceType)
+		vcpus, memory, gpuCount, gpuModel, err := p.getInstanceTypeInformation(instanceType)
 		if err != nil {
 			return err
 		}
 		instanceTypeSpecList = append(instanceTypeSpecList,
-			provider.InstanceTypeSpec{InstanceType: instanceType, VCPUs: vcpus, Memory: memory, GPUs: gpuCount})
+			provider.InstanceTypeSpec{InstanceType: instanceType, VCPUs: vcpus, Memory: memory, GPUs: gpuCount, GPUModel: gpuModel})
 	}
 
 	// Sort the instanceTypeSpecList and update the serviceConfig
@@ -382,9 +520,9 @@ func (p *awsProvider) updateInstanceTypeSpecList() error {
 
 var errInstanceTypeNotFound = errors.New("instance type not found")
 
-// Add a method to retrieve cpu, memory, and storage from the instance type
+// Add a method to retrieve cpu, memory, gpu count and gpu model from the instance type
 func (p *awsProvider) getInstanceTypeInformation(instanceType string) (int64, int64,
-	int64, error,
+	int64, string, error,
 ) {
 	// Get the instance type information from the instance type using AWS API
 	input := &ec2.DescribeInstanceTypesInput{
@@ -395,7 +533,7 @@ func (p *awsProvider) getInstanceTypeInformation(instanceType string) (int64, in
 	// Get the instance type information from the instance type using AWS API
 	result, err := p.ec2Client.DescribeInstanceTypes(context.Background(), input)
 	if err != nil {
-		return 0, 0, 0, err
+		return 0, 0, 0, "", err
 	}
 
 	// Get the vcpu, memory and gpu from the result
@@ -406,16 +544,21 @@ func (p *awsProvider) getInstanceTypeInformation(instanceType string) (int64, in
 
 		// Get the GPU information
 		gpuCount := int64(0)
+		gpuModel := ""
 		if instanceInfo.GpuInfo != nil {
 			for _, gpu := range instanceInfo.GpuInfo.Gpus {
 				gpuCount += int64(*gpu.Count)
+				// Instance types have GPUs of a single model
+				if gpu.Name != nil {
+					gpuModel = *gpu.Name
+				}
 			}
 		}
 
-		return vcpu, memory, gpuCount, nil
+		return vcpu, memory, gpuCount, gpuModel, nil
 	}
 
-	return 0, 0, 0, errInstanceTypeNotFound
+	return 0, 0, 0, "", errInstanceTypeNotFound
 }
 
 // Add a method to get public IP address of the instance
@@ -37,7 +37,11 @@ func (m mockEC2Client) RunInstances(ctx context.Context,
 	return &ec2.RunInstancesOutput{
 		Instances: []types.Instance{
 			{
-				InstanceId: &mockInstanceID,
+				InstanceId:   &mockInstanceID,
+				InstanceType: types.InstanceTypeT2Small,
+				Placement: &types.Placement{
+					AvailabilityZone: aws.String("us-east-1a"),
+				},
 				// Add public DNS name
 				PublicDnsName: aws.String("ec2-192-168-100-1.compute-1.amazonaws.com"),
 				// Add private IP address to mock instance
@@ -97,7 +101,7 @@ func (m mockEC2Client) DescribeInstanceTypes(ctx context.Context,
 					{
 						Count:        aws.Int32(4),
 						Manufacturer: aws.String("NVIDIA"),
-						Name:         aws.String("Tesla"),
+						Name:         aws.String("V100"),
 					},
 				},
 			},
@@ -126,6 +130,11 @@ func (m mockEC2Client) DescribeInstances(ctx context.Context,
 				Instances: []types.Instance{
 					{
 						InstanceId: &mockInstanceID,
+						LaunchTime: aws.Time(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+						Tags: []types.Tag{
+							{Key: aws.String("Name"), Value: aws.String("podvm-podtest-123")},
+							{Key: aws.String(userDataTagKey), Value: aws.String(userDataObjectPrefix + "podvm-podtest-123.gz")},
+						},
 						// Add private IP address to mock instance
 						PrivateIpAddress: aws.String("10.0.0.2"),
 						// Add private IP address to network interface
@@ -300,9 +309,11 @@ func TestCreateInstance(t *testing.T) {
 				spec:        provider.InstanceTypeSpec{InstanceType: "t2.small"},
 			},
 			want: &provider.Instance{
-				ID:   "i-1234567890abcdef0",
-				Name: "podvm-podtest-123",
-				IPs:  []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+				ID:           "i-1234567890abcdef0",
+				Name:         "podvm-podtest-123",
+				IPs:          []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+				InstanceType: "t2.small",
+				Zone:         "us-east-1a",
 			},
 			// Test should not return an error
 			wantErr: false,
@@ -327,9 +338,11 @@ func TestCreateInstance(t *testing.T) {
 				spec:        provider.InstanceTypeSpec{InstanceType: "t2.small"},
 			},
 			want: &provider.Instance{
-				ID:   "i-1234567890abcdef0",
-				Name: "podvm-podpublicip-123",
-				IPs:  []netip.Addr{netip.MustParseAddr("192.168.100.1")},
+				ID:           "i-1234567890abcdef0",
+				Name:         "podvm-podpublicip-123",
+				IPs:          []netip.Addr{netip.MustParseAddr("192.168.100.1")},
+				InstanceType: "t2.small",
+				Zone:         "us-east-1a",
 			},
 			// Test should not return an error
 			wantErr: false,
@@ -400,9 +413,11 @@ func TestCreateInstance(t *testing.T) {
 				spec:        provider.InstanceTypeSpec{InstanceType: ""},
 			},
 			want: &provider.Instance{
-				ID:   "i-1234567890abcdef0",
-				Name: "podvm-podemptyinstance-123",
-				IPs:  []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+				ID:           "i-1234567890abcdef0",
+				Name:         "podvm-podemptyinstance-123",
+				IPs:          []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+				InstanceType: "t2.small",
+				Zone:         "us-east-1a",
 			},
 			// Test should not return an error
 			wantErr: false,
@@ -428,9 +443,11 @@ func TestCreateInstance(t *testing.T) {
 				spec:        provider.InstanceTypeSpec{InstanceType: ""},
 			},
 			want: &provider.Instance{
-				ID:   "i-1234567890abcdef0",
-				Name: "podvm-podemptyinstance-123",
-				IPs:  []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+				ID:           "i-1234567890abcdef0",
+				Name:         "podvm-podemptyinstance-123",
+				IPs:          []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+				InstanceType: "t2.small",
+				Zone:         "us-east-1a",
 			},
 			// Test should not return an error
 			wantErr: false,
@@ -458,6 +475,29 @@ func TestCreateInstance(t *testing.T) {
 	}
 }
 
+func TestListInstances(t *testing.T) {
+	p := &awsProvider{
+		ec2Client:     newMockEC2Client(),
+		serviceConfig: serviceConfig,
+	}
+
+	got, err := p.ListInstances(context.Background(), "test-cluster")
+	if err != nil {
+		t.Fatalf("awsProvider.ListInstances() error = %v", err)
+	}
+
+	want := []provider.InstanceInfo{
+		{
+			ID:      "i-1234567890abcdef0",
+			Name:    "podvm-podtest-123",
+			Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("awsProvider.ListInstances() = %v, want %v", got, want)
+	}
+}
+
 func TestDeleteInstance(t *testing.T) {
 	type fields struct {
 		ec2Client     ec2Client
@@ -516,6 +556,7 @@ func TestGetInstanceTypeInformation(t *testing.T) {
 		wantVcpu   int64
 		wantMemory int64
 		wantGpu    int64
+		wantModel  string
 		wantErr    bool
 	}{
 		// Test getting instance type information for a valid instance type
@@ -547,6 +588,7 @@ func TestGetInstanceTypeInformation(t *testing.T) {
 			wantVcpu:   32,
 			wantMemory: 244000,
 			wantGpu:    4,
+			wantModel:  "V100",
 			// Test should not return an error
 			wantErr: false,
 		},
@@ -573,7 +615,7 @@ func TestGetInstanceTypeInformation(t *testing.T) {
 				ec2Client:     tt.fields.ec2Client,
 				serviceConfig: tt.fields.serviceConfig,
 			}
-			gotVcpu, gotMemory, gotGpu, err := p.getInstanceTypeInformation(tt.args.instanceType)
+			gotVcpu, gotMemory, gotGpu, gotModel, err := p.getInstanceTypeInformation(tt.args.instanceType)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("awsProvider.getInstanceTypeInformation() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -591,6 +633,9 @@ func TestGetInstanceTypeInformation(t *testing.T) {
 			if gotGpu != tt.wantGpu {
 				t.Errorf("awsProvider.getInstanceTypeInformation() gotGpu = %v, want %v", gotGpu, tt.wantGpu)
 			}
+			if gotModel != tt.wantModel {
+				t.Errorf("awsProvider.getInstanceTypeInformation() gotModel = %v, want %v", gotModel, tt.wantModel)
+			}
 		})
 	}
 }
@@ -55,6 +55,8 @@ type Config struct {
 	RootVolumeSize       int
 	RootDeviceName       string
 	DisableCVM           bool
+	UserDataFormat       string
+	UserDataBucket       string
 }
 
 func (c Config) Redact() Config {
@@ -0,0 +1,200 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package aws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+const (
+	userDataObjectPrefix = "peerpod-userdata/"
+	// The pod VM fetches userdata when it boots, so the presigned URL does not need to be valid for long
+	userDataURLExpiry = 15 * time.Minute
+
+	// userDataTagKey tags userdata objects, and tags instances with the key of their userdata object,
+	// so that the object is deleted with the instance even after cloud-api-adaptor restarts
+	userDataTagKey = "peerpod-userdata"
+
+	// userDataLifecycleRuleID identifies the lifecycle rule that expires userdata objects that are left behind,
+	// e.g. when cloud-api-adaptor is deleted before its instances
+	userDataLifecycleRuleID = "peerpod-userdata-expiration"
+	userDataExpirationDays  = 1
+)
+
+// Make s3Client a mockable interface
+type s3Client interface {
+	PutObject(ctx context.Context,
+		params *s3.PutObjectInput,
+		optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context,
+		params *s3.DeleteObjectInput,
+		optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	GetBucketLifecycleConfiguration(ctx context.Context,
+		params *s3.GetBucketLifecycleConfigurationInput,
+		optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfiguration(ctx context.Context,
+		params *s3.PutBucketLifecycleConfigurationInput,
+		optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error)
+}
+
+// Make s3Presigner a mockable interface
+type s3Presigner interface {
+	PresignGetObject(ctx context.Context,
+		params *s3.GetObjectInput,
+		optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// ensureUserDataLifecycle adds a lifecycle rule that expires userdata objects to the bucket.
+// Existing rules of the bucket are kept, since a lifecycle configuration replaces all rules.
+func (p *awsProvider) ensureUserDataLifecycle(ctx context.Context) error {
+
+	bucket := p.serviceConfig.UserDataBucket
+
+	var rules []s3types.LifecycleRule
+
+	output, err := p.s3Client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("getting lifecycle configuration of s3://%s: %w", bucket, err)
+		}
+	} else {
+		rules = output.Rules
+	}
+
+	for _, rule := range rules {
+		if aws.ToString(rule.ID) == userDataLifecycleRuleID {
+			return nil
+		}
+	}
+
+	rules = append(rules, s3types.LifecycleRule{
+		ID:         aws.String(userDataLifecycleRuleID),
+		Status:     s3types.ExpirationStatusEnabled,
+		Filter:     &s3types.LifecycleRuleFilterMemberPrefix{Value: userDataObjectPrefix},
+		Expiration: &s3types.LifecycleExpiration{Days: userDataExpirationDays},
+	})
+
+	if _, err := p.s3Client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{Rules: rules},
+	}); err != nil {
+		return fmt.Errorf("putting lifecycle configuration of s3://%s: %w", bucket, err)
+	}
+
+	logger.Printf("Added lifecycle rule %s to expire userdata objects in s3://%s", userDataLifecycleRuleID, bucket)
+
+	return nil
+}
+
+// userDataTagging returns the tags of a userdata object in the URL query format of PutObject
+func userDataTagging(ctx context.Context, instanceName string) string {
+
+	tags := url.Values{}
+	tags.Set(userDataTagKey, instanceName)
+	if clusterID := util.ClusterID(ctx); clusterID != "" {
+		tags.Set(provider.ClusterTagKey, clusterID)
+	}
+	return tags.Encode()
+}
+
+// userDataKey returns the key of the userdata object of an instance from its tags
+func (p *awsProvider) userDataKey(ctx context.Context, instanceID string) (string, error) {
+
+	output, err := p.ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("describing instance %s: %w", instanceID, err)
+	}
+
+	for _, reservation := range output.Reservations {
+		for _, instance := range reservation.Instances {
+			for _, tag := range instance.Tags {
+				if aws.ToString(tag.Key) == userDataTagKey {
+					return aws.ToString(tag.Value), nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// prepareUserData returns userdata that fits in the AWS limit.
+// Userdata is compressed if it is too large. If it is still too large and a bucket is configured,
+// the compressed userdata is uploaded to S3 and bootstrap userdata that fetches it is returned with the object key.
+func (p *awsProvider) prepareUserData(ctx context.Context, instanceName string, userData []byte) ([]byte, string, error) {
+
+	fitted, err := cloudinit.FitUserData(userData, cloudinit.AWSUserDataLimit)
+	if err == nil || !errors.Is(err, cloudinit.ErrUserDataTooLarge) || p.s3Client == nil {
+		return fitted, "", err
+	}
+
+	payload, err := cloudinit.CompressUserData(userData)
+	if err != nil {
+		return nil, "", err
+	}
+
+	bucket := p.serviceConfig.UserDataBucket
+	key := userDataObjectPrefix + instanceName + ".gz"
+
+	logger.Printf("Uploading userdata of instance %s (%d bytes) to s3://%s/%s", instanceName, len(payload), bucket, key)
+
+	if _, err := p.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(payload),
+		ServerSideEncryption: s3types.ServerSideEncryptionAes256,
+		Tagging:              aws.String(userDataTagging(ctx, instanceName)),
+	}); err != nil {
+		return nil, "", fmt.Errorf("uploading userdata to s3://%s/%s: %w", bucket, key, err)
+	}
+
+	presigned, err := p.s3Presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(userDataURLExpiry))
+	if err != nil {
+		p.deleteUserData(ctx, key)
+		return nil, "", fmt.Errorf("presigning URL of s3://%s/%s: %w", bucket, key, err)
+	}
+
+	bootstrap, err := cloudinit.BootstrapUserData(p.serviceConfig.UserDataFormat, presigned.URL, payload)
+	if err != nil {
+		p.deleteUserData(ctx, key)
+		return nil, "", err
+	}
+
+	return []byte(bootstrap), key, nil
+}
+
+func (p *awsProvider) deleteUserData(ctx context.Context, key string) {
+
+	bucket := p.serviceConfig.UserDataBucket
+
+	if _, err := p.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		logger.Printf("failed to delete userdata s3://%s/%s: %v", bucket, key, err)
+	}
+}
@@ -0,0 +1,155 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package aws
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+type mockS3Client struct {
+	objects map[string][]byte
+	tagging map[string]string
+	rules   []s3types.LifecycleRule
+}
+
+func (m *mockS3Client) PutObject(ctx context.Context,
+	params *s3.PutObjectInput,
+	optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[*params.Bucket+"/"+*params.Key] = data
+	m.tagging[*params.Bucket+"/"+*params.Key] = aws.ToString(params.Tagging)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *mockS3Client) DeleteObject(ctx context.Context,
+	params *s3.DeleteObjectInput,
+	optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+
+	delete(m.objects, *params.Bucket+"/"+*params.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3Client) GetBucketLifecycleConfiguration(ctx context.Context,
+	params *s3.GetBucketLifecycleConfigurationInput,
+	optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+
+	if m.rules == nil {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchLifecycleConfiguration"}
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: m.rules}, nil
+}
+
+func (m *mockS3Client) PutBucketLifecycleConfiguration(ctx context.Context,
+	params *s3.PutBucketLifecycleConfigurationInput,
+	optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+
+	m.rules = params.LifecycleConfiguration.Rules
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+type mockS3Presigner struct{}
+
+func (m mockS3Presigner) PresignGetObject(ctx context.Context,
+	params *s3.GetObjectInput,
+	optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+
+	return &v4.PresignedHTTPRequest{URL: "https://" + *params.Bucket + ".s3.amazonaws.com/" + *params.Key + "?X-Amz-Signature=test"}, nil
+}
+
+func TestPrepareUserData(t *testing.T) {
+
+	// Random data is not compressible
+	large := make([]byte, 2*cloudinit.AWSUserDataLimit)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	p := &awsProvider{serviceConfig: &Config{}}
+
+	userData, key, err := p.prepareUserData(context.TODO(), "podvm-test", []byte("#cloud-config\n"))
+	if err != nil || key != "" || string(userData) != "#cloud-config\n" {
+		t.Fatalf("Expect userdata as is, got %q, %q, %v", userData, key, err)
+	}
+
+	if _, _, err := p.prepareUserData(context.TODO(), "podvm-test", large); !errors.Is(err, cloudinit.ErrUserDataTooLarge) {
+		t.Fatalf("Expect %v without a bucket, got %v", cloudinit.ErrUserDataTooLarge, err)
+	}
+
+	client := &mockS3Client{objects: make(map[string][]byte), tagging: make(map[string]string)}
+	p = &awsProvider{
+		ec2Client:     newMockEC2Client(),
+		serviceConfig: &Config{UserDataBucket: "bucket"},
+		s3Client:      client,
+		s3Presigner:   mockS3Presigner{},
+	}
+
+	userData, key, err = p.prepareUserData(context.TODO(), "podvm-podtest-123", large)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := userDataObjectPrefix+"podvm-podtest-123.gz", key; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if _, ok := client.objects["bucket/"+key]; !ok {
+		t.Fatalf("Expect userdata to be uploaded")
+	}
+	if e, a := userDataTagKey+"=podvm-podtest-123", client.tagging["bucket/"+key]; e != a {
+		t.Fatalf("Expect tags %q, got %q", e, a)
+	}
+	if len(userData) > cloudinit.AWSUserDataLimit || !strings.Contains(string(userData), "https://bucket.s3.amazonaws.com/"+key) {
+		t.Fatalf("Expect bootstrap userdata, got %q", userData)
+	}
+
+	// The key of the object is looked up from the tags of the instance
+	if err := p.DeleteInstance(context.TODO(), "i-1234567890abcdef0"); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if len(client.objects) != 0 {
+		t.Fatalf("Expect userdata to be deleted with the instance")
+	}
+}
+
+func TestEnsureUserDataLifecycle(t *testing.T) {
+
+	client := &mockS3Client{}
+	p := &awsProvider{
+		serviceConfig: &Config{UserDataBucket: "bucket"},
+		s3Client:      client,
+	}
+
+	if err := p.ensureUserDataLifecycle(context.TODO()); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if len(client.rules) != 1 || aws.ToString(client.rules[0].ID) != userDataLifecycleRuleID {
+		t.Fatalf("Expect a lifecycle rule for userdata, got %#v", client.rules)
+	}
+
+	// Existing rules are kept, and the rule is added only once
+	client.rules = []s3types.LifecycleRule{{ID: aws.String("other"), Status: s3types.ExpirationStatusEnabled}}
+	for i := 0; i < 2; i++ {
+		if err := p.ensureUserDataLifecycle(context.TODO()); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+	if len(client.rules) != 2 || aws.ToString(client.rules[0].ID) != "other" || aws.ToString(client.rules[1].ID) != userDataLifecycleRuleID {
+		t.Fatalf("Expect an existing rule and a lifecycle rule for userdata, got %#v", client.rules)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"flag"
 
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
 )
 
 var azurecfg Config
@@ -38,6 +39,9 @@ func (_ *Manager) ParseCmd(flags *flag.FlagSet) {
 	flags.Var(&azurecfg.Tags, "tags", "Custom tags (key=value pairs) to be used for the Pod VMs, comma separated")
 	flags.BoolVar(&azurecfg.EnableSecureBoot, "enable-secure-boot", false, "Enable secure boot for the VMs")
 	flags.BoolVar(&azurecfg.UsePublicIP, "use-public-ip", false, "Assign public IP to the PoD VM and use to connect to kata-agent")
+	flags.StringVar(&azurecfg.UserDataFormat, "userdata-format", cloudinit.DefaultUserDataFormat, "Userdata format of the Pod VMs: cloud-config or ignition")
+	flags.StringVar(&azurecfg.UserDataStorageAccount, "userdata-storage-account", "", "Storage account to store userdata that exceeds the VM limit even when compressed")
+	flags.StringVar(&azurecfg.UserDataContainer, "userdata-container", "peerpod-userdata", "Blob container of the userdata storage account. The container must not be public")
 }
 
 func (_ *Manager) LoadEnv() {
@@ -54,6 +58,36 @@ func (_ *Manager) NewProvider() (provider.Provider, error) {
 	return NewProvider(&azurecfg)
 }
 
+func (_ *Manager) NewProviderWithCredentials(credentials map[string]string) (provider.Provider, error) {
+	cfg, err := credentialsConfig(credentials)
+	if err != nil {
+		return nil, err
+	}
+	return NewProvider(cfg)
+}
+
+// credentialsConfig returns a copy of the configuration with the credentials of another service principal,
+// and the resources of its subscription unless they are shared with it
+func credentialsConfig(credentials map[string]string) (*Config, error) {
+	cfg := azurecfg
+	setters := map[string]func(string) error{
+		"AZURE_CLIENT_ID":       provider.SetString(&cfg.ClientId),
+		"AZURE_CLIENT_SECRET":   provider.SetString(&cfg.ClientSecret),
+		"AZURE_TENANT_ID":       provider.SetString(&cfg.TenantId),
+		"AZURE_SUBSCRIPTION_ID": provider.SetString(&cfg.SubscriptionId),
+		"AZURE_RESOURCE_GROUP":  provider.SetString(&cfg.ResourceGroupName),
+		"AZURE_REGION":          provider.SetString(&cfg.Region),
+		"AZURE_SUBNET_ID":       provider.SetString(&cfg.SubnetId),
+		"AZURE_NSG_ID":          provider.SetString(&cfg.SecurityGroupId),
+		"AZURE_IMAGE_ID":        provider.SetString(&cfg.ImageId),
+	}
+	// Without a client secret, the workload identity of the adaptor would be used
+	if err := provider.ApplyCredentials(credentials, setters, "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_TENANT_ID"); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
 func (_ *Manager) GetConfig() (config *Config) {
 	return &azurecfg
 }
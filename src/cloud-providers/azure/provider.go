@@ -28,13 +28,16 @@ var logger = log.New(log.Writer(), "[adaptor/cloud/azure] ", log.LstdFlags|log.L
 var errNotReady = errors.New("address not ready")
 var errNotFound = errors.New("VM name not found")
 
-const (
-	maxInstanceNameLen = 63
-)
+// instanceNameRules are the rules of Azure VM names, which are also the computer names of Linux VMs
+// and must not have periods or underscores
+var instanceNameRules = util.InstanceNameRules{MaxLen: 63}
 
 type azureProvider struct {
 	azureClient   azcore.TokenCredential
 	serviceConfig *Config
+	// blobClient and blobSigner are set when userdata that exceeds the limit is stored in a blob
+	blobClient blobClient
+	blobSigner blobURLSigner
 }
 
 func NewProvider(config *Config) (provider.Provider, error) {
@@ -55,6 +58,13 @@ func NewProvider(config *Config) (provider.Provider, error) {
 		serviceConfig: config,
 	}
 
+	if config.UserDataStorageAccount != "" {
+		provider.blobClient, provider.blobSigner, err = newBlobClients(config, azureClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if err = provider.updateInstanceSizeSpecList(); err != nil {
 		return nil, err
 	}
@@ -201,9 +211,21 @@ func (p *azureProvider) buildNetworkConfig(nicName string) *armcompute.VirtualMa
 	return &config
 }
 
+func (p *azureProvider) InstanceNameRules() util.InstanceNameRules {
+	return instanceNameRules
+}
+
 func (p *azureProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
 
-	instanceName := util.GenerateInstanceName(podName, sandboxID, maxInstanceNameLen)
+	instanceName, err := util.GenerateInstanceName(ctx, podName, sandboxID, instanceNameRules)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudConfig, err = cloudinit.WithUserDataFormat(cloudConfig, p.serviceConfig.UserDataFormat)
+	if err != nil {
+		return nil, err
+	}
 
 	cloudConfigData, err := cloudConfig.Generate()
 	if err != nil {
@@ -241,15 +263,32 @@ func (p *azureProvider) CreateInstance(ctx context.Context, podName, sandboxID s
 		imageId = spec.Image
 	}
 
-	vmParameters, err := p.getVMParameters(instanceSize, diskName, cloudConfigData, sshBytes, instanceName, nicName, imageId)
+	// Compress userdata if it exceeds the Azure limit, or store it in a blob if it is still too large
+	userData, err := p.prepareUserData(ctx, instanceName, []byte(cloudConfigData))
 	if err != nil {
 		return nil, err
 	}
 
+	vmParameters, err := p.getVMParameters(ctx, instanceSize, diskName, string(userData), sshBytes, instanceName, nicName, imageId)
+	if err != nil {
+		p.deleteUserData(ctx, instanceName)
+		return nil, err
+	}
+	for k, v := range spec.Tags {
+		vmParameters.Tags[k] = to.Ptr(v)
+	}
+	if util.ClusterID(ctx) != "" {
+		vmParameters.Tags[provider.SandboxTagKey] = to.Ptr(sandboxID)
+		if pod := util.InstancePod(ctx, podName); pod != "" {
+			vmParameters.Tags[provider.PodTagKey] = to.Ptr(pod)
+		}
+	}
+
 	logger.Printf("CreateInstance: name: %q", instanceName)
 
 	vm, err := p.create(ctx, vmParameters)
 	if err != nil {
+		p.deleteUserData(ctx, instanceName)
 		return nil, fmt.Errorf("Creating instance (%v): %s", vm, err)
 	}
 
@@ -260,9 +299,11 @@ func (p *azureProvider) CreateInstance(ctx context.Context, podName, sandboxID s
 	}
 
 	instance := &provider.Instance{
-		ID:   *vm.ID,
-		Name: instanceName,
-		IPs:  ips,
+		ID:           *vm.ID,
+		Name:         instanceName,
+		IPs:          ips,
+		InstanceType: instanceSize,
+		Zone:         p.serviceConfig.Region,
 	}
 
 	return instance, nil
@@ -294,9 +335,55 @@ func (p *azureProvider) DeleteInstance(ctx context.Context, instanceID string) e
 	}
 
 	logger.Printf("deleted VM successfully: %s", vmName)
+
+	p.deleteUserData(ctx, vmName)
 	return nil
 }
 
+func (p *azureProvider) ListInstances(ctx context.Context, clusterID string) ([]provider.InstanceInfo, error) {
+	vmClient, err := armcompute.NewVirtualMachinesClient(p.serviceConfig.SubscriptionId, p.azureClient, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating VM client: %w", err)
+	}
+
+	var instances []provider.InstanceInfo
+	pager := vmClient.NewListPager(p.serviceConfig.ResourceGroupName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing VMs of cluster %s: %w", clusterID, err)
+		}
+
+		for _, vm := range page.Value {
+			if vm.ID == nil || vm.Tags[provider.ClusterTagKey] == nil || *vm.Tags[provider.ClusterTagKey] != clusterID {
+				continue
+			}
+			info := provider.InstanceInfo{ID: *vm.ID}
+			if vm.Name != nil {
+				info.Name = *vm.Name
+			}
+			if vm.Properties != nil && vm.Properties.TimeCreated != nil {
+				info.Created = *vm.Properties.TimeCreated
+			}
+			if pod := vm.Tags[provider.PodTagKey]; pod != nil {
+				info.Pod = *pod
+			}
+			if sandboxID := vm.Tags[provider.SandboxTagKey]; sandboxID != nil {
+				info.SandboxID = *sandboxID
+			}
+			instances = append(instances, info)
+		}
+	}
+
+	return instances, nil
+}
+
+// NormalizeInstanceID lower-cases the resource ID of a VM, since Azure resource IDs are case-insensitive and
+// the API does not always return the same case as in the ID of the created VM
+func (p *azureProvider) NormalizeInstanceID(id string) string {
+	return strings.ToLower(id)
+}
+
 func (p *azureProvider) Teardown() error {
 	return nil
 }
@@ -363,17 +450,20 @@ func (p *azureProvider) updateInstanceSizeSpecList() error {
 	return nil
 }
 
-func (p *azureProvider) getResourceTags() map[string]*string {
+func (p *azureProvider) getResourceTags(ctx context.Context) map[string]*string {
 	tags := map[string]*string{}
 
 	// Add custom tags from serviceConfig.Tags
 	for k, v := range p.serviceConfig.Tags {
 		tags[k] = to.Ptr(v)
 	}
+	if clusterID := util.ClusterID(ctx); clusterID != "" {
+		tags[provider.ClusterTagKey] = to.Ptr(clusterID)
+	}
 	return tags
 }
 
-func (p *azureProvider) getVMParameters(instanceSize, diskName, cloudConfig string, sshBytes []byte, instanceName, nicName string, imageId string) (*armcompute.VirtualMachine, error) {
+func (p *azureProvider) getVMParameters(ctx context.Context, instanceSize, diskName, cloudConfig string, sshBytes []byte, instanceName, nicName string, imageId string) (*armcompute.VirtualMachine, error) {
 	userDataB64 := base64.StdEncoding.EncodeToString([]byte(cloudConfig))
 
 	// Azure limits the base64 encrypted userData to 64KB.
@@ -460,7 +550,7 @@ func (p *azureProvider) getVMParameters(instanceSize, diskName, cloudConfig stri
 			},
 			UserData: to.Ptr(userDataB64),
 		},
-		Tags: p.getResourceTags(),
+		Tags: p.getResourceTags(ctx),
 	}
 
 	return &vmParameters, nil
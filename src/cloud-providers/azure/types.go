@@ -49,6 +49,11 @@ type Config struct {
 	// Secure boot brings no additional security.
 	EnableSecureBoot bool
 	UsePublicIP      bool
+	UserDataFormat   string
+	// UserDataStorageAccount and UserDataContainer select the blob container that stores userdata which exceeds the
+	// limit even when compressed
+	UserDataStorageAccount string
+	UserDataContainer      string
 }
 
 func (c Config) Redact() Config {
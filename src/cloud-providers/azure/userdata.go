@@ -0,0 +1,135 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+// The pod VM fetches userdata when it boots, so the SAS URL does not need to be valid for long
+const userDataURLExpiry = 15 * time.Minute
+
+// Make blobClient a mockable interface of azblob.Client
+type blobClient interface {
+	UploadBuffer(ctx context.Context, containerName string, blobName string, buffer []byte, o *azblob.UploadBufferOptions) (azblob.UploadBufferResponse, error)
+	DeleteBlob(ctx context.Context, containerName string, blobName string, o *azblob.DeleteBlobOptions) (azblob.DeleteBlobResponse, error)
+}
+
+// blobURLSigner returns URLs of blobs that are readable until expiry
+type blobURLSigner interface {
+	SignedURL(ctx context.Context, containerName string, blobName string, expiry time.Time) (string, error)
+}
+
+// userDelegationSigner signs URLs with a user delegation SAS, so that no storage account key is needed
+type userDelegationSigner struct {
+	client *service.Client
+}
+
+func (s *userDelegationSigner) SignedURL(ctx context.Context, containerName string, blobName string, expiry time.Time) (string, error) {
+
+	start := time.Now().UTC().Add(-5 * time.Minute)
+	credential, err := s.client.GetUserDelegationCredential(ctx, service.KeyInfo{
+		Start:  to.Ptr(start.Format(sas.TimeFormat)),
+		Expiry: to.Ptr(expiry.UTC().Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("getting user delegation key: %w", err)
+	}
+
+	params, err := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		StartTime:     start,
+		ExpiryTime:    expiry.UTC(),
+		Permissions:   (&sas.BlobPermissions{Read: true}).String(),
+		ContainerName: containerName,
+		BlobName:      blobName,
+	}.SignWithUserDelegation(credential)
+	if err != nil {
+		return "", fmt.Errorf("signing SAS: %w", err)
+	}
+
+	return fmt.Sprintf("%s%s/%s?%s", s.client.URL(), containerName, blobName, params.Encode()), nil
+}
+
+// newBlobClients returns the clients of the storage account that stores userdata which exceeds the limit
+func newBlobClients(config *Config, credential azcore.TokenCredential) (blobClient, blobURLSigner, error) {
+
+	client, err := azblob.NewClient(fmt.Sprintf("https://%s.blob.core.windows.net/", config.UserDataStorageAccount), credential, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating blob client of storage account %s: %w", config.UserDataStorageAccount, err)
+	}
+	return client, &userDelegationSigner{client: client.ServiceClient()}, nil
+}
+
+// userDataBlobName returns the name of the userdata blob of an instance. The name is derived from the instance name,
+// so that the blob is deleted with the instance even after cloud-api-adaptor restarts
+func userDataBlobName(instanceName string) string {
+	return strings.ToLower(instanceName) + ".gz"
+}
+
+// prepareUserData returns userdata that fits in the Azure limit.
+// Userdata is compressed if it is too large. If it is still too large and a storage account is configured,
+// the compressed userdata is uploaded to a blob and bootstrap userdata that fetches it is returned.
+func (p *azureProvider) prepareUserData(ctx context.Context, instanceName string, userData []byte) ([]byte, error) {
+
+	fitted, err := cloudinit.FitUserData(userData, cloudinit.AzureUserDataLimit)
+	if err == nil || !errors.Is(err, cloudinit.ErrUserDataTooLarge) || p.blobClient == nil {
+		return fitted, err
+	}
+
+	payload, err := cloudinit.CompressUserData(userData)
+	if err != nil {
+		return nil, err
+	}
+
+	container := p.serviceConfig.UserDataContainer
+	blob := userDataBlobName(instanceName)
+
+	logger.Printf("Uploading userdata of instance %s (%d bytes) to blob %s/%s of storage account %s", instanceName, len(payload), container, blob, p.serviceConfig.UserDataStorageAccount)
+
+	if _, err := p.blobClient.UploadBuffer(ctx, container, blob, payload, nil); err != nil {
+		return nil, fmt.Errorf("uploading userdata to blob %s/%s: %w", container, blob, err)
+	}
+
+	url, err := p.blobSigner.SignedURL(ctx, container, blob, time.Now().Add(userDataURLExpiry))
+	if err != nil {
+		p.deleteUserData(ctx, instanceName)
+		return nil, fmt.Errorf("signing URL of blob %s/%s: %w", container, blob, err)
+	}
+
+	bootstrap, err := cloudinit.BootstrapUserData(p.serviceConfig.UserDataFormat, url, payload)
+	if err != nil {
+		p.deleteUserData(ctx, instanceName)
+		return nil, err
+	}
+
+	return []byte(bootstrap), nil
+}
+
+// deleteUserData deletes the userdata blob of an instance, if any
+func (p *azureProvider) deleteUserData(ctx context.Context, instanceName string) {
+	if p.blobClient == nil {
+		return
+	}
+
+	container := p.serviceConfig.UserDataContainer
+	blob := userDataBlobName(instanceName)
+
+	if _, err := p.blobClient.DeleteBlob(ctx, container, blob, nil); err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		logger.Printf("failed to delete userdata blob %s/%s: %v", container, blob, err)
+	}
+}
@@ -0,0 +1,94 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package azure
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+type mockBlobClient struct {
+	blobs map[string][]byte
+}
+
+func (m *mockBlobClient) UploadBuffer(ctx context.Context, containerName string, blobName string, buffer []byte, o *azblob.UploadBufferOptions) (azblob.UploadBufferResponse, error) {
+	m.blobs[containerName+"/"+blobName] = buffer
+	return azblob.UploadBufferResponse{}, nil
+}
+
+func (m *mockBlobClient) DeleteBlob(ctx context.Context, containerName string, blobName string, o *azblob.DeleteBlobOptions) (azblob.DeleteBlobResponse, error) {
+	delete(m.blobs, containerName+"/"+blobName)
+	return azblob.DeleteBlobResponse{}, nil
+}
+
+type mockBlobURLSigner struct {
+	err error
+}
+
+func (m mockBlobURLSigner) SignedURL(ctx context.Context, containerName string, blobName string, expiry time.Time) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	return "https://account.blob.core.windows.net/" + containerName + "/" + blobName + "?sig=test", nil
+}
+
+func TestPrepareUserData(t *testing.T) {
+
+	// Random data is not compressible
+	large := make([]byte, 2*cloudinit.AzureUserDataLimit)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	p := &azureProvider{serviceConfig: &Config{}}
+
+	userData, err := p.prepareUserData(context.TODO(), "podvm-test", []byte("#cloud-config\n"))
+	if err != nil || string(userData) != "#cloud-config\n" {
+		t.Fatalf("Expect userdata as is, got %q, %v", userData, err)
+	}
+
+	if _, err := p.prepareUserData(context.TODO(), "podvm-test", large); !errors.Is(err, cloudinit.ErrUserDataTooLarge) {
+		t.Fatalf("Expect %v without a storage account, got %v", cloudinit.ErrUserDataTooLarge, err)
+	}
+
+	client := &mockBlobClient{blobs: make(map[string][]byte)}
+	p = &azureProvider{
+		serviceConfig: &Config{UserDataStorageAccount: "account", UserDataContainer: "container"},
+		blobClient:    client,
+		blobSigner:    mockBlobURLSigner{},
+	}
+
+	userData, err = p.prepareUserData(context.TODO(), "podvm-PodTest-123", large)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, ok := client.blobs["container/podvm-podtest-123.gz"]; !ok {
+		t.Fatalf("Expect userdata to be uploaded, got %v", client.blobs)
+	}
+	if len(userData) > cloudinit.AzureUserDataLimit || !strings.Contains(string(userData), "https://account.blob.core.windows.net/container/podvm-podtest-123.gz") {
+		t.Fatalf("Expect bootstrap userdata, got %q", userData)
+	}
+
+	p.deleteUserData(context.TODO(), "podvm-PodTest-123")
+	if len(client.blobs) != 0 {
+		t.Fatalf("Expect userdata to be deleted, got %v", client.blobs)
+	}
+
+	// The blob is deleted if its URL cannot be signed
+	p.blobSigner = mockBlobURLSigner{err: errors.New("no user delegation key")}
+	if _, err := p.prepareUserData(context.TODO(), "podvm-test", large); err == nil {
+		t.Fatalf("Expect an error")
+	}
+	if len(client.blobs) != 0 {
+		t.Fatalf("Expect userdata to be deleted, got %v", client.blobs)
+	}
+}
@@ -26,8 +26,6 @@ type dockerProvider struct {
 	NetworkName      string
 }
 
-const maxInstanceNameLen = 63
-
 func NewProvider(config *Config) (*dockerProvider, error) {
 
 	logger.Printf("docker config: %#v", config)
@@ -54,7 +52,10 @@ func NewProvider(config *Config) (*dockerProvider, error) {
 func (p *dockerProvider) CreateInstance(ctx context.Context, podName, sandboxID string,
 	cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
 
-	instanceName := putil.GenerateInstanceName(podName, sandboxID, maxInstanceNameLen)
+	instanceName, err := putil.GenerateInstanceName(ctx, podName, sandboxID, putil.DefaultInstanceNameRules)
+	if err != nil {
+		return nil, err
+	}
 
 	logger.Printf("CreateInstance: name: %q", instanceName)
 
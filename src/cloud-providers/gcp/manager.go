@@ -7,6 +7,7 @@ import (
 	"flag"
 
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
 )
 
 var gcpcfg Config
@@ -26,6 +27,8 @@ func (_ *Manager) ParseCmd(flags *flag.FlagSet) {
 	flags.StringVar(&gcpcfg.MachineType, "machine-type", "e2-medium", "Pod VM instance type")
 	flags.StringVar(&gcpcfg.Network, "network", "", "Network ID to be used for the Pod VMs")
 	flags.StringVar(&gcpcfg.DiskType, "disk-type", "pd-standard", "Any GCP disk type (pd-standard, pd-ssd, pd-balanced or pd-extreme)")
+	flags.StringVar(&gcpcfg.UserDataFormat, "userdata-format", cloudinit.DefaultUserDataFormat, "Userdata format of the Pod VMs: cloud-config or ignition")
+	flags.StringVar(&gcpcfg.UserDataBucket, "userdata-gcs-bucket", "", "GCS bucket to store userdata that exceeds the metadata limit even when compressed. The bucket must not be public")
 }
 
 func (_ *Manager) LoadEnv() {
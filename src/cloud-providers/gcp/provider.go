@@ -24,11 +24,15 @@ import (
 var logger = log.New(log.Writer(), "[adaptor/cloud/gcp] ", log.LstdFlags|log.Lmsgprefix)
 var computeScope = "https://www.googleapis.com/auth/compute"
 
-const maxInstanceNameLen = 63
+// instanceNameRules are the rules of GCE instance names, which are RFC 1035 labels
+var instanceNameRules = util.InstanceNameRules{MaxLen: 63}
 
 type gcpProvider struct {
 	serviceConfig   *Config
 	instancesClient *compute.InstancesClient
+	// gcsClient and gcsSigner are set when userdata that exceeds the limit is stored in GCS
+	gcsClient gcsClient
+	gcsSigner blobSigner
 }
 
 func (p *gcpProvider) ConfigVerifier() error {
@@ -37,6 +41,7 @@ func (p *gcpProvider) ConfigVerifier() error {
 
 func NewProvider(config *Config) (provider.Provider, error) {
 	logger.Printf("gcp config: %#v", config.Redact())
+
 	provider := &gcpProvider{
 		serviceConfig:   config,
 		instancesClient: nil,
@@ -57,6 +62,19 @@ func NewProvider(config *Config) (provider.Provider, error) {
 			return nil, fmt.Errorf("NewInstancesRESTClient error: %s", err)
 		}
 	}
+
+	if config.UserDataBucket != "" {
+		var err error
+		provider.gcsClient, provider.gcsSigner, err = newGCSClients(context.TODO(), config)
+		if err != nil {
+			return nil, err
+		}
+
+		// Objects are deleted with their instances, and the rule is a fallback, so a missing permission is not fatal
+		if err := provider.ensureUserDataLifecycle(context.TODO()); err != nil {
+			logger.Printf("Userdata objects that are left behind will not be deleted: %v", err)
+		}
+	}
 	return provider, nil
 }
 
@@ -76,18 +94,36 @@ func getIPs(instance *computepb.Instance) ([]netip.Addr, error) {
 	return podNodeIPs, nil
 }
 
+func (p *gcpProvider) InstanceNameRules() util.InstanceNameRules {
+	return instanceNameRules
+}
+
 func (p *gcpProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
 
-	instanceName := util.GenerateInstanceName(podName, sandboxID, maxInstanceNameLen)
+	instanceName, err := util.GenerateInstanceName(ctx, podName, sandboxID, instanceNameRules)
+	if err != nil {
+		return nil, err
+	}
 	logger.Printf("CreateInstance: name: %q", instanceName)
 
+	cloudConfig, err = cloudinit.WithUserDataFormat(cloudConfig, p.serviceConfig.UserDataFormat)
+	if err != nil {
+		return nil, err
+	}
+
 	userData, err := cloudConfig.Generate()
 	if err != nil {
 		return nil, err
 	}
 
+	// Compress userdata if it exceeds the GCP limit, or store it in GCS if it is still too large
+	fittedUserData, err := p.prepareUserData(ctx, instanceName, []byte(userData))
+	if err != nil {
+		return nil, err
+	}
+
 	//Convert userData to base64
-	userDataEnc := base64.StdEncoding.EncodeToString([]byte(userData))
+	userDataEnc := base64.StdEncoding.EncodeToString(fittedUserData)
 	logger.Printf("userDataEnc:  %s", userDataEnc)
 
 	// It's expected that the image from the annotation will follow the format
@@ -151,10 +187,12 @@ func (p *gcpProvider) CreateInstance(ctx context.Context, podName, sandboxID str
 	}
 	op, err := p.instancesClient.Insert(ctx, insertReq)
 	if err != nil {
+		p.deleteUserData(ctx, instanceName)
 		return nil, fmt.Errorf("Instances.Insert error: %s. req: %v", err, insertReq)
 	}
 	err = op.Wait(ctx)
 	if err != nil {
+		p.deleteUserData(ctx, instanceName)
 		return nil, fmt.Errorf("waiting for Instances.Insert error: %s. req: %v", err, insertReq)
 	}
 	logger.Printf("created an instance %s for sandbox %s", instanceName, sandboxID)
@@ -178,9 +216,11 @@ func (p *gcpProvider) CreateInstance(ctx context.Context, podName, sandboxID str
 	}
 
 	return &provider.Instance{
-		ID:   instance.GetName(),
-		Name: instance.GetName(),
-		IPs:  ips,
+		ID:           instance.GetName(),
+		Name:         instance.GetName(),
+		IPs:          ips,
+		InstanceType: p.serviceConfig.MachineType,
+		Zone:         p.serviceConfig.Zone,
 	}, nil
 }
 
@@ -199,6 +239,8 @@ func (p *gcpProvider) DeleteInstance(ctx context.Context, instanceID string) err
 		return fmt.Errorf("waiting for Instances.Delete error: %s. req: %v", err, req)
 	}
 	logger.Printf("deleted an instance %s", instanceID)
+
+	p.deleteUserData(ctx, instanceID)
 	return nil
 }
 
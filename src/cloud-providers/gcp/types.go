@@ -15,6 +15,9 @@ type Config struct {
 	MachineType    string
 	Network        string
 	DiskType       string
+	UserDataFormat string
+	// UserDataBucket is the GCS bucket that stores userdata which exceeds the limit even when compressed
+	UserDataBucket string
 }
 
 func (c Config) Redact() Config {
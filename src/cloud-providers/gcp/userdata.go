@@ -0,0 +1,330 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iamcredentials/v1"
+	option "google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+const (
+	// userDataObjectPrefix is the prefix of the names of userdata objects. The name of an object is derived from
+	// the instance name, so that the object is deleted with the instance even after cloud-api-adaptor restarts
+	userDataObjectPrefix = "peerpod-userdata/"
+
+	// The pod VM fetches userdata when it boots, so the signed URL does not need to be valid for long
+	userDataURLExpiry = 15 * time.Minute
+
+	// userDataExpirationDays is the age of userdata objects that are left behind, e.g. when cloud-api-adaptor
+	// is deleted before its instances, at which a lifecycle rule deletes them
+	userDataExpirationDays = 1
+
+	gcsHost = "storage.googleapis.com"
+)
+
+// Make gcsClient a mockable interface of the GCS objects and buckets services
+type gcsClient interface {
+	InsertObject(ctx context.Context, bucket, name string, data []byte) error
+	DeleteObject(ctx context.Context, bucket, name string) error
+	GetBucketLifecycle(ctx context.Context, bucket string) (*storage.BucketLifecycle, error)
+	SetBucketLifecycle(ctx context.Context, bucket string, lifecycle *storage.BucketLifecycle) error
+}
+
+type gcsService struct {
+	service *storage.Service
+}
+
+func (s *gcsService) InsertObject(ctx context.Context, bucket, name string, data []byte) error {
+	_, err := s.service.Objects.Insert(bucket, &storage.Object{Name: name}).Media(bytes.NewReader(data)).Context(ctx).Do()
+	return err
+}
+
+func (s *gcsService) DeleteObject(ctx context.Context, bucket, name string) error {
+	return s.service.Objects.Delete(bucket, name).Context(ctx).Do()
+}
+
+func (s *gcsService) GetBucketLifecycle(ctx context.Context, bucket string) (*storage.BucketLifecycle, error) {
+	b, err := s.service.Buckets.Get(bucket).Fields("lifecycle").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return b.Lifecycle, nil
+}
+
+func (s *gcsService) SetBucketLifecycle(ctx context.Context, bucket string, lifecycle *storage.BucketLifecycle) error {
+	_, err := s.service.Buckets.Patch(bucket, &storage.Bucket{Lifecycle: lifecycle}).Context(ctx).Do()
+	return err
+}
+
+// Make blobSigner a mockable interface of the service account that signs userdata URLs
+type blobSigner interface {
+	Email() string
+	SignBlob(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// keySigner signs with the private key of service account credentials
+type keySigner struct {
+	email string
+	key   *rsa.PrivateKey
+}
+
+func (s *keySigner) Email() string {
+	return s.email
+}
+
+func (s *keySigner) SignBlob(ctx context.Context, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+}
+
+// iamSigner signs with the IAM Credentials API, which needs no private key. The service account needs
+// the iam.serviceAccounts.signBlob permission on itself
+type iamSigner struct {
+	email   string
+	service *iamcredentials.Service
+}
+
+func (s *iamSigner) Email() string {
+	return s.email
+}
+
+func (s *iamSigner) SignBlob(ctx context.Context, data []byte) ([]byte, error) {
+	resp, err := s.service.Projects.ServiceAccounts.SignBlob("projects/-/serviceAccounts/"+s.email, &iamcredentials.SignBlobRequest{
+		Payload: base64.StdEncoding.EncodeToString(data),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.SignedBlob)
+}
+
+// newGCSClients returns the clients of the bucket that stores userdata which exceeds the limit. URLs are signed with
+// the private key of the configured credentials, or with the IAM Credentials API for the service account of the node
+func newGCSClients(ctx context.Context, config *Config) (gcsClient, blobSigner, error) {
+
+	if config.GcpCredentials != "" {
+		service, err := storage.NewService(ctx, option.WithCredentialsJSON([]byte(config.GcpCredentials)), option.WithScopes(storage.DevstorageReadWriteScope))
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating GCS client: %w", err)
+		}
+		jwt, err := google.JWTConfigFromJSON([]byte(config.GcpCredentials))
+		if err != nil {
+			return nil, nil, fmt.Errorf("userdata bucket requires service account credentials: %w", err)
+		}
+		key, err := parsePrivateKey(jwt.PrivateKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &gcsService{service: service}, &keySigner{email: jwt.Email, key: key}, nil
+	}
+
+	service, err := storage.NewService(ctx, option.WithScopes(storage.DevstorageReadWriteScope))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating GCS client: %w", err)
+	}
+	iam, err := iamcredentials.NewService(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating IAM Credentials client: %w", err)
+	}
+	email, err := metadata.Email("default")
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting the service account of the node: %w", err)
+	}
+	return &gcsService{service: service}, &iamSigner{email: email, service: iam}, nil
+}
+
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key of credentials is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key of credentials: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key of credentials is not an RSA key")
+	}
+	return key, nil
+}
+
+// signedURL returns a V4 signed URL that allows GET of an object until expiry.
+// See https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+func signedURL(ctx context.Context, signer blobSigner, bucket, object string, now time.Time, expiry time.Duration) (string, error) {
+
+	now = now.UTC()
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	scope := date + "/auto/storage/goog4_request"
+
+	segments := strings.Split(object, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	path := "/" + bucket + "/" + strings.Join(segments, "/")
+
+	query := url.Values{}
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", signer.Email()+"/"+scope)
+	query.Set("X-Goog-Date", timestamp)
+	query.Set("X-Goog-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Goog-SignedHeaders", "host")
+	// Encode sorts the parameters by key, and escapes spaces as "+", which the canonical query string must not contain
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		path,
+		canonicalQuery,
+		"host:" + gcsHost + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	digest := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"GOOG4-RSA-SHA256",
+		timestamp,
+		scope,
+		hex.EncodeToString(digest[:]),
+	}, "\n")
+
+	signature, err := signer.SignBlob(ctx, []byte(stringToSign))
+	if err != nil {
+		return "", fmt.Errorf("signing URL of gs://%s/%s: %w", bucket, object, err)
+	}
+
+	return "https://" + gcsHost + path + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
+
+// userDataLifecycleRule is the lifecycle rule that deletes userdata objects that are left behind
+func userDataLifecycleRule() *storage.BucketLifecycleRule {
+	return &storage.BucketLifecycleRule{
+		Action: &storage.BucketLifecycleRuleAction{Type: "Delete"},
+		Condition: &storage.BucketLifecycleRuleCondition{
+			Age:           googleapi.Int64(userDataExpirationDays),
+			MatchesPrefix: []string{userDataObjectPrefix},
+		},
+	}
+}
+
+// ensureUserDataLifecycle adds a lifecycle rule that deletes userdata objects to the bucket.
+// Existing rules of the bucket are kept, since a lifecycle configuration replaces all rules.
+func (p *gcpProvider) ensureUserDataLifecycle(ctx context.Context) error {
+
+	bucket := p.serviceConfig.UserDataBucket
+
+	lifecycle, err := p.gcsClient.GetBucketLifecycle(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("getting lifecycle configuration of gs://%s: %w", bucket, err)
+	}
+	if lifecycle == nil {
+		lifecycle = &storage.BucketLifecycle{}
+	}
+
+	rule := userDataLifecycleRule()
+	for _, existing := range lifecycle.Rule {
+		if existing.Action != nil && existing.Action.Type == rule.Action.Type && existing.Condition != nil &&
+			reflect.DeepEqual(existing.Condition.MatchesPrefix, rule.Condition.MatchesPrefix) {
+			return nil
+		}
+	}
+
+	lifecycle.Rule = append(lifecycle.Rule, rule)
+	if err := p.gcsClient.SetBucketLifecycle(ctx, bucket, lifecycle); err != nil {
+		return fmt.Errorf("setting lifecycle configuration of gs://%s: %w", bucket, err)
+	}
+
+	logger.Printf("Added lifecycle rule to delete userdata objects in gs://%s", bucket)
+
+	return nil
+}
+
+func userDataObjectName(instanceName string) string {
+	return userDataObjectPrefix + instanceName + ".gz"
+}
+
+// prepareUserData returns userdata that fits in the GCP limit.
+// Userdata is compressed if it is too large. If it is still too large and a bucket is configured,
+// the compressed userdata is uploaded to GCS and bootstrap userdata that fetches it is returned.
+func (p *gcpProvider) prepareUserData(ctx context.Context, instanceName string, userData []byte) ([]byte, error) {
+
+	fitted, err := cloudinit.FitUserData(userData, cloudinit.GCPUserDataLimit)
+	if err == nil || !errors.Is(err, cloudinit.ErrUserDataTooLarge) || p.gcsClient == nil {
+		return fitted, err
+	}
+
+	payload, err := cloudinit.CompressUserData(userData)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := p.serviceConfig.UserDataBucket
+	object := userDataObjectName(instanceName)
+
+	logger.Printf("Uploading userdata of instance %s (%d bytes) to gs://%s/%s", instanceName, len(payload), bucket, object)
+
+	if err := p.gcsClient.InsertObject(ctx, bucket, object, payload); err != nil {
+		return nil, fmt.Errorf("uploading userdata to gs://%s/%s: %w", bucket, object, err)
+	}
+
+	url, err := signedURL(ctx, p.gcsSigner, bucket, object, time.Now(), userDataURLExpiry)
+	if err != nil {
+		p.deleteUserData(ctx, instanceName)
+		return nil, err
+	}
+
+	bootstrap, err := cloudinit.BootstrapUserData(p.serviceConfig.UserDataFormat, url, payload)
+	if err != nil {
+		p.deleteUserData(ctx, instanceName)
+		return nil, err
+	}
+
+	return []byte(bootstrap), nil
+}
+
+// deleteUserData deletes the userdata object of an instance, if any
+func (p *gcpProvider) deleteUserData(ctx context.Context, instanceName string) {
+	if p.gcsClient == nil {
+		return
+	}
+
+	bucket := p.serviceConfig.UserDataBucket
+	object := userDataObjectName(instanceName)
+
+	if err := p.gcsClient.DeleteObject(ctx, bucket, object); err != nil {
+		var apiErr *googleapi.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+			logger.Printf("failed to delete userdata object gs://%s/%s: %v", bucket, object, err)
+		}
+	}
+}
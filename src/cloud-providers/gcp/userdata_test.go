@@ -0,0 +1,171 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+type mockGCSClient struct {
+	objects   map[string][]byte
+	lifecycle *storage.BucketLifecycle
+}
+
+func (m *mockGCSClient) InsertObject(ctx context.Context, bucket, name string, data []byte) error {
+	m.objects[bucket+"/"+name] = data
+	return nil
+}
+
+func (m *mockGCSClient) DeleteObject(ctx context.Context, bucket, name string) error {
+	if _, ok := m.objects[bucket+"/"+name]; !ok {
+		return &googleapi.Error{Code: 404}
+	}
+	delete(m.objects, bucket+"/"+name)
+	return nil
+}
+
+func (m *mockGCSClient) GetBucketLifecycle(ctx context.Context, bucket string) (*storage.BucketLifecycle, error) {
+	return m.lifecycle, nil
+}
+
+func (m *mockGCSClient) SetBucketLifecycle(ctx context.Context, bucket string, lifecycle *storage.BucketLifecycle) error {
+	m.lifecycle = lifecycle
+	return nil
+}
+
+func newTestSigner(t *testing.T) *keySigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	return &keySigner{email: "caa@project.iam.gserviceaccount.com", key: key}
+}
+
+func TestPrepareUserData(t *testing.T) {
+
+	// Random data is not compressible
+	large := make([]byte, 2*cloudinit.GCPUserDataLimit)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	p := &gcpProvider{serviceConfig: &Config{}}
+
+	userData, err := p.prepareUserData(context.TODO(), "podvm-test", []byte("#cloud-config\n"))
+	if err != nil || string(userData) != "#cloud-config\n" {
+		t.Fatalf("Expect userdata as is, got %q, %v", userData, err)
+	}
+
+	if _, err := p.prepareUserData(context.TODO(), "podvm-test", large); !errors.Is(err, cloudinit.ErrUserDataTooLarge) {
+		t.Fatalf("Expect %v without a bucket, got %v", cloudinit.ErrUserDataTooLarge, err)
+	}
+
+	client := &mockGCSClient{objects: make(map[string][]byte)}
+	p = &gcpProvider{
+		serviceConfig: &Config{UserDataBucket: "bucket"},
+		gcsClient:     client,
+		gcsSigner:     newTestSigner(t),
+	}
+
+	userData, err = p.prepareUserData(context.TODO(), "podvm-podtest-123", large)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, ok := client.objects["bucket/"+userDataObjectPrefix+"podvm-podtest-123.gz"]; !ok {
+		t.Fatalf("Expect userdata to be uploaded, got %v", client.objects)
+	}
+	if len(userData) > cloudinit.GCPUserDataLimit || !strings.Contains(string(userData), "https://storage.googleapis.com/bucket/peerpod-userdata/podvm-podtest-123.gz?") {
+		t.Fatalf("Expect bootstrap userdata, got %q", userData)
+	}
+
+	p.deleteUserData(context.TODO(), "podvm-podtest-123")
+	if len(client.objects) != 0 {
+		t.Fatalf("Expect userdata to be deleted, got %v", client.objects)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+
+	signer := newTestSigner(t)
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	signed, err := signedURL(context.TODO(), signer, "bucket", "peerpod-userdata/podvm-test.gz", now, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := "/bucket/peerpod-userdata/podvm-test.gz", u.Path; e != a {
+		t.Fatalf("Expect path %q, got %q", e, a)
+	}
+	query := u.Query()
+	if e, a := "caa@project.iam.gserviceaccount.com/20240506/auto/storage/goog4_request", query.Get("X-Goog-Credential"); e != a {
+		t.Fatalf("Expect credential %q, got %q", e, a)
+	}
+	if e, a := "900", query.Get("X-Goog-Expires"); e != a {
+		t.Fatalf("Expect expiry %q, got %q", e, a)
+	}
+
+	// The signature is over the string to sign of the canonical request
+	canonicalRequest := "GET\n/bucket/peerpod-userdata/podvm-test.gz\n" +
+		"X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Credential=caa%40project.iam.gserviceaccount.com%2F20240506%2Fauto%2Fstorage%2Fgoog4_request&X-Goog-Date=20240506T070809Z&X-Goog-Expires=900&X-Goog-SignedHeaders=host\n" +
+		"host:storage.googleapis.com\n\nhost\nUNSIGNED-PAYLOAD"
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "GOOG4-RSA-SHA256\n20240506T070809Z\n20240506/auto/storage/goog4_request\n" + hex.EncodeToString(digest[:])
+	hashed := sha256.Sum256([]byte(stringToSign))
+
+	signature, err := hex.DecodeString(query.Get("X-Goog-Signature"))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := rsa.VerifyPKCS1v15(&signer.key.PublicKey, crypto.SHA256, hashed[:], signature); err != nil {
+		t.Fatalf("Expect a valid signature, got %v", err)
+	}
+}
+
+func TestEnsureUserDataLifecycle(t *testing.T) {
+
+	client := &mockGCSClient{}
+	p := &gcpProvider{
+		serviceConfig: &Config{UserDataBucket: "bucket"},
+		gcsClient:     client,
+	}
+
+	if err := p.ensureUserDataLifecycle(context.TODO()); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if client.lifecycle == nil || len(client.lifecycle.Rule) != 1 {
+		t.Fatalf("Expect a lifecycle rule for userdata, got %#v", client.lifecycle)
+	}
+
+	// Existing rules are kept, and the rule is added only once
+	other := &storage.BucketLifecycleRule{Action: &storage.BucketLifecycleRuleAction{Type: "Delete"}, Condition: &storage.BucketLifecycleRuleCondition{Age: googleapi.Int64(30)}}
+	client.lifecycle = &storage.BucketLifecycle{Rule: []*storage.BucketLifecycleRule{other}}
+	for i := 0; i < 2; i++ {
+		if err := p.ensureUserDataLifecycle(context.TODO()); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+	if len(client.lifecycle.Rule) != 2 || client.lifecycle.Rule[0] != other {
+		t.Fatalf("Expect an existing rule and a lifecycle rule for userdata, got %#v", client.lifecycle.Rule)
+	}
+}
@@ -19,8 +19,6 @@ import (
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
 )
 
-const maxInstanceNameLen = 63
-
 var logger = log.New(log.Writer(), "[adaptor/cloud/ibmcloud-powervs] ", log.LstdFlags|log.Lmsgprefix)
 
 type ibmcloudPowerVSProvider struct {
@@ -45,7 +43,10 @@ func NewProvider(config *Config) (provider.Provider, error) {
 
 func (p *ibmcloudPowerVSProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
 
-	instanceName := util.GenerateInstanceName(podName, sandboxID, maxInstanceNameLen)
+	instanceName, err := util.GenerateInstanceName(ctx, podName, sandboxID, util.DefaultInstanceNameRules)
+	if err != nil {
+		return nil, err
+	}
 
 	userData, err := cloudConfig.Generate()
 	if err != nil {
@@ -130,6 +131,7 @@ func (p *ibmcloudPowerVSProvider) CreateInstance(ctx context.Context, podName, s
 		ID:   instanceID,
 		Name: instanceName,
 		IPs:  ips,
+		Zone: p.serviceConfig.Zone,
 	}, nil
 }
 
@@ -28,7 +28,8 @@ const (
 var logger = log.New(log.Writer(), "[adaptor/cloud/ibmcloud] ", log.LstdFlags|log.Lmsgprefix)
 var errNotReady = errors.New("address not ready")
 
-const maxInstanceNameLen = 63
+// instanceNameRules are the rules of VPC instance names, which are lower case letters, digits and hyphens
+var instanceNameRules = util.InstanceNameRules{MaxLen: 63}
 
 type vpcV1 interface {
 	CreateInstanceWithContext(context.Context, *vpcv1.CreateInstanceOptions) (*vpcv1.Instance, *core.DetailedResponse, error)
@@ -249,9 +250,21 @@ func getIPs(instance *vpcv1.Instance, instanceID string, numInterfaces int) ([]n
 	return ips, nil
 }
 
+func (p *ibmcloudVPCProvider) InstanceNameRules() util.InstanceNameRules {
+	return instanceNameRules
+}
+
 func (p *ibmcloudVPCProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
 
-	instanceName := util.GenerateInstanceName(podName, sandboxID, maxInstanceNameLen)
+	instanceName, err := util.GenerateInstanceName(ctx, podName, sandboxID, instanceNameRules)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudConfig, err = cloudinit.WithUserDataFormat(cloudConfig, p.serviceConfig.UserDataFormat)
+	if err != nil {
+		return nil, err
+	}
 
 	userData, err := cloudConfig.Generate()
 	if err != nil {
@@ -305,9 +318,11 @@ func (p *ibmcloudVPCProvider) CreateInstance(ctx context.Context, podName, sandb
 	}
 
 	instance := &provider.Instance{
-		ID:   instanceID,
-		Name: instanceName,
-		IPs:  ips,
+		ID:           instanceID,
+		Name:         instanceName,
+		IPs:          ips,
+		InstanceType: instanceProfile,
+		Zone:         p.serviceConfig.ZoneName,
 	}
 
 	return instance, nil
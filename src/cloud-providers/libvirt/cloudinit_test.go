@@ -15,6 +15,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"libvirt.org/go/libvirtxml"
 
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/configdrive"
 	"github.com/kdomanski/iso9660"
 )
 
@@ -27,9 +28,9 @@ func TestCloudInit(t *testing.T) {
 	fmt.Printf("temp file: %s", file.Name())
 
 	userDataContent := []byte("userdata")
-	metaDataContent := []byte("metadata")
+	metaDataContent := []byte("instance-id: podvm-test\nlocal-hostname: podvm-test\n")
 
-	isoData, err := createCloudInit(userDataContent, metaDataContent)
+	isoData, err := createCloudInitISO(&vmConfig{name: "podvm-test", userData: string(userDataContent)})
 	require.NoError(t, err)
 
 	err = os.WriteFile(file.Name(), isoData, os.ModePerm)
@@ -56,8 +57,8 @@ func TestCloudInit(t *testing.T) {
 		files[key] = data
 	}
 
-	assert.Equal(t, userDataContent, files[userDataFilename])
-	assert.Equal(t, metaDataContent, files[metaDataFilename])
+	assert.Equal(t, userDataContent, files[configdrive.UserDataFilename])
+	assert.Equal(t, metaDataContent, files[configdrive.MetaDataFilename])
 
 	err = isoFile.Close()
 	require.NoError(t, err)
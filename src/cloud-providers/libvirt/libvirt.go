@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/avast/retry-go/v4"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/configdrive"
 	libvirt "libvirt.org/go/libvirt"
 	libvirtxml "libvirt.org/go/libvirtxml"
 )
@@ -64,14 +65,11 @@ func (s *sevGuestPolicy) getGuestPolicy() uint {
 	return res
 }
 
-// createCloudInitISO creates an ISO file with a userdata and a metadata file. The ISO image will be created in-memory since it is small
+// createCloudInitISO creates a NoCloud config drive with a userdata and a metadata file. The ISO image will be created in-memory since it is small
 func createCloudInitISO(v *vmConfig) ([]byte, error) {
 	logger.Println("Create cloudInit iso")
 
-	userData := v.userData
-	metaData := fmt.Sprintf("local-hostname: %s", v.name)
-
-	return createCloudInit([]byte(userData), []byte(metaData))
+	return configdrive.Build(configdrive.NoCloud, []byte(v.userData), configdrive.MetaData{InstanceID: v.name, Hostname: v.name})
 }
 
 func checkDomainExistsByName(name string, libvirtClient *libvirtClient) (exist bool, err error) {
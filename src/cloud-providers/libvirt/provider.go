@@ -18,7 +18,8 @@ import (
 
 var logger = log.New(log.Writer(), "[adaptor/cloud/libvirt] ", log.LstdFlags|log.Lmsgprefix)
 
-const maxInstanceNameLen = 63
+// instanceNameRules are the rules of libvirt domain names, which are also part of volume names
+var instanceNameRules = util.InstanceNameRules{MaxLen: 63, Chars: "._"}
 
 type libvirtProvider struct {
 	libvirtClient *libvirtClient
@@ -47,9 +48,16 @@ func getIPs(instance *vmConfig) ([]netip.Addr, error) {
 	return instance.ips, nil
 }
 
+func (p *libvirtProvider) InstanceNameRules() util.InstanceNameRules {
+	return instanceNameRules
+}
+
 func (p *libvirtProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
 
-	instanceName := util.GenerateInstanceName(podName, sandboxID, maxInstanceNameLen)
+	instanceName, err := util.GenerateInstanceName(ctx, podName, sandboxID, instanceNameRules)
+	if err != nil {
+		return nil, err
+	}
 
 	userData, err := cloudConfig.Generate()
 	if err != nil {
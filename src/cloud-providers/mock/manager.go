@@ -0,0 +1,42 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package mock
+
+import (
+	"flag"
+
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+)
+
+var mockCfg Config
+
+type Manager struct{}
+
+const (
+	defaultInstanceIP = "127.0.0.1"
+	defaultDataDir    = "/run/peerpod/mock"
+)
+
+func init() {
+	provider.AddCloudProvider("mock", &Manager{})
+}
+
+func (_ *Manager) ParseCmd(flags *flag.FlagSet) {
+	flags.StringVar(&mockCfg.InstanceIP, "mock-instance-ip", defaultInstanceIP, "IP address of the first fake Pod VM instance, e.g. a loopback or veth address. Each further instance gets the next free address")
+	flags.StringVar(&mockCfg.DataDir, "data-dir", defaultDataDir, "Directory to store the userdata of fake Pod VM instances")
+	flags.StringVar(&mockCfg.ForwarderCommand, "mock-forwarder-cmd", "", "Command started for each fake Pod VM instance, e.g. a local agent-protocol-forwarder")
+}
+
+func (_ *Manager) LoadEnv() {
+	provider.DefaultToEnv(&mockCfg.InstanceIP, "MOCK_INSTANCE_IP", defaultInstanceIP)
+	provider.DefaultToEnv(&mockCfg.ForwarderCommand, "MOCK_FORWARDER_CMD", "")
+}
+
+func (_ *Manager) NewProvider() (provider.Provider, error) {
+	return NewProvider(&mockCfg)
+}
+
+func (_ *Manager) GetConfig() (config *Config) {
+	return &mockCfg
+}
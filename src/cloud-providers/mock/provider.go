@@ -0,0 +1,190 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package mock
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	putil "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+var logger = log.New(log.Writer(), "[adaptor/cloud/mock] ", log.LstdFlags|log.Lmsgprefix)
+
+type mockInstance struct {
+	ip           netip.Addr
+	userDataFile string
+	cmd          *exec.Cmd
+}
+
+// mockProvider fakes Pod VM instances without talking to any cloud. The userdata of each
+// instance is generated and stored in the data directory, and an optional command, typically
+// a local agent-protocol-forwarder, is started to stand in for the Pod VM.
+//
+// Each instance gets its own IP address, starting with the instance IP of the configuration,
+// so that the forwarders of concurrent instances can listen on the same port, e.g. 127.0.0.1,
+// 127.0.0.2 and so on, which are all loopback addresses.
+type mockProvider struct {
+	serviceConfig *Config
+	instanceIP    netip.Addr
+
+	mutex     sync.Mutex
+	instances map[string]*mockInstance
+}
+
+func NewProvider(config *Config) (*mockProvider, error) {
+
+	logger.Printf("mock config: %#v", config)
+
+	ip, err := netip.ParseAddr(config.InstanceIP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mock instance IP %q: %w", config.InstanceIP, err)
+	}
+
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &mockProvider{
+		serviceConfig: config,
+		instanceIP:    ip,
+		instances:     make(map[string]*mockInstance),
+	}, nil
+}
+
+func (p *mockProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
+
+	instanceName, err := putil.GenerateInstanceName(ctx, podName, sandboxID, putil.DefaultInstanceNameRules)
+	if err != nil {
+		return nil, err
+	}
+
+	userData, err := cloudConfig.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	userDataFile, err := provider.WriteUserData(instanceName, userData, p.serviceConfig.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := &mockInstance{userDataFile: userDataFile}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	instance.ip, err = p.allocateIP()
+	if err != nil {
+		os.Remove(userDataFile)
+		return nil, err
+	}
+
+	if p.serviceConfig.ForwarderCommand != "" {
+		cmd := exec.Command("/bin/sh", "-c", p.serviceConfig.ForwarderCommand)
+		cmd.Env = append(os.Environ(),
+			"MOCK_INSTANCE_NAME="+instanceName,
+			"MOCK_INSTANCE_IP="+instance.ip.String(),
+			"MOCK_USERDATA_FILE="+userDataFile,
+		)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		// Run the command in its own process group, so that its children are killed with it
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+		if err := cmd.Start(); err != nil {
+			os.Remove(userDataFile)
+			return nil, fmt.Errorf("starting mock forwarder command for instance %s: %w", instanceName, err)
+		}
+		go func() {
+			if err := cmd.Wait(); err != nil {
+				logger.Printf("mock forwarder command for instance %s exited: %v", instanceName, err)
+			}
+		}()
+		instance.cmd = cmd
+	}
+
+	p.instances[instanceName] = instance
+
+	logger.Printf("created fake instance %s with IP %s for sandbox %s", instanceName, instance.ip, sandboxID)
+
+	return &provider.Instance{
+		ID:   instanceName,
+		Name: instanceName,
+		IPs:  []netip.Addr{instance.ip},
+	}, nil
+}
+
+// allocateIP returns the lowest address, starting with the instance IP, that no instance uses.
+// It must be called with the mutex held
+func (p *mockProvider) allocateIP() (netip.Addr, error) {
+	used := make(map[netip.Addr]bool, len(p.instances))
+	for _, instance := range p.instances {
+		used[instance.ip] = true
+	}
+	for ip := p.instanceIP; ip.IsValid(); ip = ip.Next() {
+		if !used[ip] {
+			return ip, nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no IP address is available for a fake instance")
+}
+
+func (p *mockProvider) DeleteInstance(ctx context.Context, instanceID string) error {
+
+	p.mutex.Lock()
+	instance, ok := p.instances[instanceID]
+	delete(p.instances, instanceID)
+	p.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("instance %s does not exist", instanceID)
+	}
+
+	stop(instance)
+
+	logger.Printf("deleted fake instance %s", instanceID)
+
+	return nil
+}
+
+func (p *mockProvider) Teardown() error {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for id, instance := range p.instances {
+		stop(instance)
+		delete(p.instances, id)
+	}
+
+	return nil
+}
+
+func (p *mockProvider) ConfigVerifier() error {
+	if !p.instanceIP.IsValid() {
+		return fmt.Errorf("mock instance IP is not set")
+	}
+	return nil
+}
+
+func stop(instance *mockInstance) {
+	if instance.cmd != nil && instance.cmd.Process != nil {
+		// Kill the process group of the command, since the shell doesn't pass signals on to its children
+		if err := syscall.Kill(-instance.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			logger.Printf("failed to kill mock forwarder command: %v", err)
+		}
+	}
+	if err := os.Remove(instance.userDataFile); err != nil && !os.IsNotExist(err) {
+		logger.Printf("failed to remove %s: %v", instance.userDataFile, err)
+	}
+}
@@ -0,0 +1,151 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package mock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+)
+
+func TestMockProvider(t *testing.T) {
+	dataDir := t.TempDir()
+
+	p, err := NewProvider(&Config{
+		InstanceIP:       "127.0.0.1",
+		DataDir:          dataDir,
+		ForwarderCommand: "sleep 60",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+
+	cloudConfig := &cloudinit.CloudConfig{
+		WriteFiles: []cloudinit.WriteFile{
+			{Path: "/run/peerpod/daemon.json", Content: "{}"},
+		},
+	}
+
+	instance, err := p.CreateInstance(context.Background(), "test", "0123456789", cloudConfig, provider.InstanceTypeSpec{})
+	if err != nil {
+		t.Fatalf("CreateInstance: %v", err)
+	}
+	if len(instance.IPs) != 1 || instance.IPs[0].String() != "127.0.0.1" {
+		t.Errorf("unexpected instance IPs: %v", instance.IPs)
+	}
+
+	userDataFile := filepath.Join(dataDir, instance.Name+"-userdata")
+	if _, err := os.Stat(userDataFile); err != nil {
+		t.Errorf("userdata is not stored: %v", err)
+	}
+
+	if err := p.DeleteInstance(context.Background(), instance.ID); err != nil {
+		t.Fatalf("DeleteInstance: %v", err)
+	}
+	if _, err := os.Stat(userDataFile); !os.IsNotExist(err) {
+		t.Errorf("userdata is not removed: %v", err)
+	}
+	if err := p.DeleteInstance(context.Background(), instance.ID); err == nil {
+		t.Errorf("expected an error when deleting a non-existing instance")
+	}
+}
+
+func TestNewProviderInvalidIP(t *testing.T) {
+	if _, err := NewProvider(&Config{InstanceIP: "invalid", DataDir: t.TempDir()}); err == nil {
+		t.Errorf("expected an error for an invalid instance IP")
+	}
+}
+
+func TestMockProviderInstances(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// The shell starts the command as a child process, whose PID is written next to the userdata
+	p, err := NewProvider(&Config{
+		InstanceIP:       "127.0.0.1",
+		DataDir:          dataDir,
+		ForwarderCommand: `sleep 60 & echo $! > "$MOCK_USERDATA_FILE.pid"; wait`,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Teardown() })
+
+	var instances []*provider.Instance
+	for _, sandboxID := range []string{"0123456789", "abcdef0123"} {
+		instance, err := p.CreateInstance(context.Background(), "test", sandboxID, &cloudinit.CloudConfig{}, provider.InstanceTypeSpec{})
+		if err != nil {
+			t.Fatalf("CreateInstance: %v", err)
+		}
+		instances = append(instances, instance)
+	}
+	if instances[0].IPs[0].String() != "127.0.0.1" || instances[1].IPs[0].String() != "127.0.0.2" {
+		t.Fatalf("unexpected instance IPs: %v, %v", instances[0].IPs, instances[1].IPs)
+	}
+
+	pids := make([]int, len(instances))
+	for i, instance := range instances {
+		pids[i] = childPID(t, filepath.Join(dataDir, instance.Name+"-userdata.pid"))
+	}
+
+	if err := p.DeleteInstance(context.Background(), instances[0].ID); err != nil {
+		t.Fatalf("DeleteInstance: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for running(pids[0]) {
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %d of the deleted instance is still running", pids[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !running(pids[1]) {
+		t.Errorf("child process %d of the other instance is not running", pids[1])
+	}
+
+	// The address of the deleted instance is reused
+	instance, err := p.CreateInstance(context.Background(), "test", "fedcba9876", &cloudinit.CloudConfig{}, provider.InstanceTypeSpec{})
+	if err != nil {
+		t.Fatalf("CreateInstance: %v", err)
+	}
+	if instance.IPs[0].String() != "127.0.0.1" {
+		t.Errorf("unexpected instance IPs: %v", instance.IPs)
+	}
+}
+
+// childPID waits for the command of an instance to write the PID of its child process
+func childPID(t *testing.T, pidFile string) int {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(pidFile)
+		if err == nil {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+				return pid
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reading %s: %v", pidFile, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// running reports whether a process exists and is not a zombie
+func running(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
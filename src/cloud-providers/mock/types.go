@@ -0,0 +1,10 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package mock
+
+type Config struct {
+	InstanceIP       string
+	DataDir          string
+	ForwarderCommand string
+}
@@ -17,6 +17,14 @@ type CloudProvider interface {
 	NewProvider() (Provider, error)
 }
 
+// CredentialsProvider is implemented by cloud providers that can create providers with other credentials than
+// those of their configuration, e.g. to create pod VMs in the cloud accounts of tenants
+type CredentialsProvider interface {
+	// NewProviderWithCredentials creates a provider from the configuration of the cloud provider, overridden by
+	// credentials, whose keys are those of peer-pods-cm and peer-pods-secret, e.g. AWS_ACCESS_KEY_ID
+	NewProviderWithCredentials(credentials map[string]string) (Provider, error)
+}
+
 var providerTable map[string]CloudProvider = make(map[string]CloudProvider)
 
 func getFileNameAndSha256sum(providerPath string) (string, string, error) {
@@ -109,6 +117,15 @@ func Get(name string) CloudProvider {
 	return providerTable[name]
 }
 
+// NewProviderWithCredentials creates a provider of the named cloud provider, which must be loaded, with credentials
+func NewProviderWithCredentials(name string, credentials map[string]string) (Provider, error) {
+	cloud, ok := providerTable[name].(CredentialsProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s cloud provider doesn't support other credentials", name)
+	}
+	return cloud.NewProviderWithCredentials(credentials)
+}
+
 func AddCloudProvider(name string, cloud CloudProvider) {
 	providerTable[name] = cloud
 }
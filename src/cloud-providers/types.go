@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"net/netip"
 	"strings"
+	"time"
 
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util"
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
 )
 
@@ -20,6 +22,55 @@ type Provider interface {
 	ConfigVerifier() error
 }
 
+// ClusterTagKey is the key of the tag that providers implementing InstanceLister set to the cluster ID on the instances they create
+const ClusterTagKey = "peerpod-cluster-id"
+
+// PodTagKey and SandboxTagKey are the keys of the tags that providers implementing InstanceLister set to
+// the namespace/name of the pod and to the sandbox ID an instance is created for
+const (
+	PodTagKey     = "peerpod-pod"
+	SandboxTagKey = "peerpod-sandbox-id"
+)
+
+// InstanceLister is implemented by providers that can list the instances they created for a cluster
+type InstanceLister interface {
+	// ListInstances returns the instances, which are not terminated, tagged with clusterID
+	ListInstances(ctx context.Context, clusterID string) ([]InstanceInfo, error)
+}
+
+// InstanceInfo is an instance returned by InstanceLister
+type InstanceInfo struct {
+	// ID is the instance ID that DeleteInstance takes
+	ID      string
+	Name    string
+	Created time.Time
+	// Pod is the namespace/name of the pod and SandboxID the sandbox the instance is created for, if they are tagged
+	Pod       string
+	SandboxID string
+}
+
+// InstanceIDNormalizer is implemented by providers whose instance IDs are not compared verbatim,
+// e.g. Azure resource IDs, which are case-insensitive
+type InstanceIDNormalizer interface {
+	// NormalizeInstanceID returns the same ID for all the spellings of an instance ID
+	NormalizeInstanceID(id string) string
+}
+
+// InstanceNameRuler is implemented by providers whose instance names follow other rules than
+// util.DefaultInstanceNameRules
+type InstanceNameRuler interface {
+	// InstanceNameRules returns the rules that the names of the instances of the provider follow
+	InstanceNameRules() util.InstanceNameRules
+}
+
+// InstanceNameRules returns the rules of the instance names of a provider
+func InstanceNameRules(p Provider) util.InstanceNameRules {
+	if ruler, ok := p.(InstanceNameRuler); ok {
+		return ruler.InstanceNameRules()
+	}
+	return util.DefaultInstanceNameRules
+}
+
 // keyValueFlag represents a flag of key-value pairs
 type KeyValueFlag map[string]string
 
@@ -58,6 +109,10 @@ type Instance struct {
 	ID   string
 	Name string
 	IPs  []netip.Addr
+	// InstanceType and Zone are informational, and empty if a provider doesn't know them.
+	// Zone is the region if the instance is not placed in a zone
+	InstanceType string
+	Zone         string
 }
 
 type InstanceTypeSpec struct {
@@ -66,5 +121,10 @@ type InstanceTypeSpec struct {
 	Memory       int64
 	Arch         string
 	GPUs         int64
-	Image        string
+	// GPUModel is the name of the GPUs, e.g. T4 or A10G. Any model is selected if it is empty
+	GPUModel string
+	Image    string
+	// Tags are the custom tags of the instance, which take precedence over the tags of the provider config.
+	// Providers without custom tags ignore them
+	Tags map[string]string
 }
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util"
 	"golang.org/x/crypto/ssh"
@@ -66,7 +67,10 @@ func SelectInstanceTypeToUse(spec InstanceTypeSpec, specList []InstanceTypeSpec,
 
 	// GPU gets the highest priority
 	if spec.GPUs > 0 {
-		instanceType, err = GetBestFitInstanceTypeWithGPU(specList, spec.GPUs, spec.VCPUs, spec.Memory)
+		instanceType, err = GetBestFitInstanceTypeWithGPU(FilterGPUModel(specList, spec.GPUModel), spec.GPUs, spec.VCPUs, spec.Memory)
+		if err != nil && spec.GPUModel != "" {
+			return "", fmt.Errorf("failed to get instance type based on GPU model (%s), GPU, vCPU, and memory annotations: %w", spec.GPUModel, err)
+		}
 		if err != nil {
 			return "", fmt.Errorf("failed to get instance type based on GPU, vCPU, and memory annotations: %w", err)
 		}
@@ -128,8 +132,40 @@ func FilterOutGPUInstances(instanceTypeSpecList []InstanceTypeSpec) []InstanceTy
 	return filteredList
 }
 
+// Filter the instance types with a GPU model from the instance type spec list.
+// The list is returned as it is if the model is empty
+func FilterGPUModel(instanceTypeSpecList []InstanceTypeSpec, model string) []InstanceTypeSpec {
+	if model == "" {
+		return instanceTypeSpecList
+	}
+	var filteredList []InstanceTypeSpec
+	for _, spec := range instanceTypeSpecList {
+		if MatchGPUModel(spec.GPUModel, model) {
+			filteredList = append(filteredList, spec)
+		}
+	}
+	return filteredList
+}
+
+// MatchGPUModel returns whether the GPUs of an instance type are of a model. Models are matched
+// case-insensitively without vendor prefixes, and a model matches its variants, e.g. A100 matches
+// NVIDIA-A100-SXM4-40GB, the product name that GPU feature discovery labels nodes with
+func MatchGPUModel(instanceModel, model string) bool {
+	normalize := func(model string) string {
+		model = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(model), " ", "-"))
+		for _, prefix := range []string{"nvidia-", "tesla-"} {
+			model = strings.TrimPrefix(model, prefix)
+		}
+		return model
+	}
+	instanceModel, model = normalize(instanceModel), normalize(model)
+	if instanceModel == "" {
+		return false
+	}
+	return model == instanceModel || strings.HasPrefix(model, instanceModel+"-")
+}
+
 // Implement the GetBestFitInstanceTypeWithGPU function
-// TBD: Incorporate GPU model based selection as well
 func GetBestFitInstanceTypeWithGPU(sortedInstanceTypeSpecList []InstanceTypeSpec, gpus, vcpus, memory int64) (string, error) {
 	index := sort.Search(len(sortedInstanceTypeSpecList), func(i int) bool {
 		return sortedInstanceTypeSpecList[i].GPUs >= gpus &&
@@ -158,6 +194,34 @@ func DefaultToEnv(field *string, env, fallback string) {
 	*field = val
 }
 
+// ApplyCredentials sets the fields of a configuration to the values of credentials, using the setters of their keys.
+// Keys without a setter and missing required keys are rejected
+func ApplyCredentials(credentials map[string]string, setters map[string]func(string) error, required ...string) error {
+	for _, key := range required {
+		if credentials[key] == "" {
+			return fmt.Errorf("credentials are missing %s", key)
+		}
+	}
+	for key, value := range credentials {
+		set, ok := setters[key]
+		if !ok {
+			return fmt.Errorf("credentials have unsupported key %s", key)
+		}
+		if err := set(value); err != nil {
+			return fmt.Errorf("credentials have invalid %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// SetString returns a setter of ApplyCredentials that sets field
+func SetString(field *string) func(string) error {
+	return func(value string) error {
+		*field = value
+		return nil
+	}
+}
+
 // Method to write userdata to a file
 
 func WriteUserData(instanceName string, userData string, dataDir string) (string, error) {
@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"strings"
 	"text/template"
+
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -23,6 +25,10 @@ type CloudConfigGenerator interface {
 
 type CloudConfig struct {
 	WriteFiles []WriteFile `yaml:"write_files"`
+	// Extra has other modules of user supplied cloud-configs merged by Merge
+	Extra map[string]interface{} `yaml:"-"`
+	// SandboxID is the sandbox of the pod VM, which signed userdata is bound to
+	SandboxID string `yaml:"-"`
 }
 
 // https://cloudinit.readthedocs.io/en/latest/topics/modules.html#write-files
@@ -91,7 +97,22 @@ func (config *CloudConfig) Generate() (string, error) {
 		return "", fmt.Errorf("Error executing a template for cloudinit userdata: %w", err)
 	}
 
-	return buf.String(), nil
+	if len(config.Extra) > 0 {
+		// cloud-init ignores signed userdata, and process-user-data passes the modules to cloud-init after verifying
+		// it, which is after the init stage of cloud-init
+		if _, ok := config.Extra["bootcmd"]; ok && signingKey != nil {
+			return "", fmt.Errorf("bootcmd is not supported in signed cloudinit userdata")
+		}
+
+		extra, err := yaml.Marshal(config.Extra)
+		if err != nil {
+			return "", fmt.Errorf("Error marshaling extra modules of cloudinit userdata: %w", err)
+		}
+		buf.WriteString("\n")
+		buf.Write(extra)
+	}
+
+	return signUserData(buf.String(), config.SandboxID)
 }
 
 func AuthJSONToResourcesJSON(text string) string {
@@ -0,0 +1,174 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Userdata formats of pod VMs
+const (
+	UserDataFormatCloudConfig = "cloud-config"
+	UserDataFormatIgnition    = "ignition"
+
+	DefaultUserDataFormat = UserDataFormatCloudConfig
+)
+
+// https://coreos.github.io/ignition/configuration-v3_4/
+
+const ignitionVersion = "3.4.0"
+
+// IgnitionConfig generates an Ignition config that writes the same files as a cloud-config.
+// It is used for pod VM images based on Fedora CoreOS or RHCOS, which are provisioned by Ignition instead of cloud-init.
+// Ignition writes the files in early boot, and process-user-data of the pod VM converts the same config to process them.
+type IgnitionConfig struct {
+	*CloudConfig
+}
+
+type ignition struct {
+	Ignition ignitionMeta    `json:"ignition"`
+	Storage  ignitionStorage `json:"storage,omitempty"`
+}
+
+type ignitionMeta struct {
+	Version string `json:"version"`
+}
+
+type ignitionStorage struct {
+	Files []ignitionFile `json:"files,omitempty"`
+}
+
+type ignitionFile struct {
+	Path      string             `json:"path"`
+	Overwrite *bool              `json:"overwrite,omitempty"`
+	Mode      *int               `json:"mode,omitempty"`
+	User      *ignitionNode      `json:"user,omitempty"`
+	Group     *ignitionNode      `json:"group,omitempty"`
+	Contents  *ignitionResource  `json:"contents,omitempty"`
+	Append    []ignitionResource `json:"append,omitempty"`
+}
+
+type ignitionNode struct {
+	Name string `json:"name"`
+}
+
+type ignitionResource struct {
+	Source      string `json:"source"`
+	Compression string `json:"compression,omitempty"`
+}
+
+func toIgnitionFile(file WriteFile) (ignitionFile, error) {
+
+	f := ignitionFile{Path: file.Path}
+
+	if file.Permissions != "" {
+		mode, err := strconv.ParseUint(file.Permissions, 8, 32)
+		if err != nil {
+			return f, fmt.Errorf("invalid permissions of %s: %q", file.Path, file.Permissions)
+		}
+		m := int(mode)
+		f.Mode = &m
+	}
+
+	if file.Owner != "" {
+		user, group, _ := strings.Cut(file.Owner, ":")
+		if user != "" {
+			f.User = &ignitionNode{Name: user}
+		}
+		if group != "" {
+			f.Group = &ignitionNode{Name: group}
+		}
+	}
+
+	var content []byte
+	var compression string
+
+	switch file.Encoding {
+	case "":
+		content = []byte(file.Content)
+	case "b64", "base64":
+		decoded, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return f, fmt.Errorf("invalid base64 content of %s: %w", file.Path, err)
+		}
+		content = decoded
+	case "gz+b64", "gzip+base64", "gz+base64", "gzip+b64":
+		decoded, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return f, fmt.Errorf("invalid base64 content of %s: %w", file.Path, err)
+		}
+		content = decoded
+		compression = "gzip"
+	default:
+		return f, fmt.Errorf("unsupported encoding of %s: %q", file.Path, file.Encoding)
+	}
+
+	resource := ignitionResource{
+		Source:      "data:;base64," + base64.StdEncoding.EncodeToString(content),
+		Compression: compression,
+	}
+
+	if isAppend, _ := strconv.ParseBool(file.Append); isAppend {
+		f.Append = []ignitionResource{resource}
+	} else {
+		overwrite := true
+		f.Overwrite = &overwrite
+		f.Contents = &resource
+	}
+
+	return f, nil
+}
+
+func (config *IgnitionConfig) Generate() (string, error) {
+
+	// An Ignition config is JSON, which cannot have a signature line
+	if signingKey != nil {
+		return "", fmt.Errorf("ignition userdata cannot be signed, use the cloud-config format with a signing key")
+	}
+
+	ign := ignition{
+		Ignition: ignitionMeta{Version: ignitionVersion},
+	}
+
+	if config.CloudConfig != nil {
+		if len(config.Extra) > 0 {
+			return "", fmt.Errorf("cloud-config modules other than write_files cannot be converted to ignition userdata")
+		}
+		for _, file := range config.WriteFiles {
+			f, err := toIgnitionFile(file)
+			if err != nil {
+				return "", fmt.Errorf("Error converting a file for ignition userdata: %w", err)
+			}
+			ign.Storage.Files = append(ign.Storage.Files, f)
+		}
+	}
+
+	data, err := json.Marshal(&ign)
+	if err != nil {
+		return "", fmt.Errorf("Error marshaling ignition userdata: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// WithUserDataFormat returns a generator that emits userdata of a format with the same content as a cloud-config
+func WithUserDataFormat(generator CloudConfigGenerator, format string) (CloudConfigGenerator, error) {
+
+	switch format {
+	case "", UserDataFormatCloudConfig:
+		return generator, nil
+	case UserDataFormatIgnition:
+		cloudConfig, ok := generator.(*CloudConfig)
+		if !ok {
+			return nil, fmt.Errorf("%T cannot be converted to ignition userdata", generator)
+		}
+		return &IgnitionConfig{CloudConfig: cloudConfig}, nil
+	}
+
+	return nil, fmt.Errorf("unknown userdata format: %q", format)
+}
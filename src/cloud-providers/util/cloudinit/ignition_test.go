@@ -0,0 +1,100 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIgnition(t *testing.T) {
+	cloudConfig := &CloudConfig{
+		WriteFiles: []WriteFile{
+			{Path: "/123", Content: "Hello\n"},
+			{Path: "/456", Content: base64.StdEncoding.EncodeToString([]byte("Hello\nWorld\n")), Encoding: "b64", Owner: "root:root", Permissions: "0600"},
+			{Path: "/789", Content: "World\n", Append: "true"},
+		},
+	}
+
+	generator, err := WithUserDataFormat(cloudConfig, UserDataFormatIgnition)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	userData, err := generator.Generate()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	var output ignition
+	if err := json.Unmarshal([]byte(userData), &output); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	if e, a := ignitionVersion, output.Ignition.Version; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := 3, len(output.Storage.Files); e != a {
+		t.Fatalf("Expect %d files, got %d", e, a)
+	}
+
+	decode := func(source string) string {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(source, "data:;base64,"))
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		return string(data)
+	}
+
+	first := output.Storage.Files[0]
+	if e, a := "Hello\n", decode(first.Contents.Source); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if first.Overwrite == nil || !*first.Overwrite {
+		t.Fatalf("Expect overwrite of %s", first.Path)
+	}
+
+	second := output.Storage.Files[1]
+	if e, a := "Hello\nWorld\n", decode(second.Contents.Source); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if second.Mode == nil || *second.Mode != 0600 {
+		t.Fatalf("Expect mode 0600, got %v", second.Mode)
+	}
+	if second.User == nil || second.User.Name != "root" || second.Group == nil || second.Group.Name != "root" {
+		t.Fatalf("Expect owner root:root, got %v:%v", second.User, second.Group)
+	}
+
+	third := output.Storage.Files[2]
+	if third.Contents != nil || len(third.Append) != 1 {
+		t.Fatalf("Expect appended content, got %#v", third)
+	}
+	if e, a := "World\n", decode(third.Append[0].Source); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+}
+
+func TestWithUserDataFormat(t *testing.T) {
+	cloudConfig := &CloudConfig{}
+
+	for _, format := range []string{"", UserDataFormatCloudConfig} {
+		generator, err := WithUserDataFormat(cloudConfig, format)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if generator != CloudConfigGenerator(cloudConfig) {
+			t.Fatalf("Expect the cloud-config generator for format %q, got %T", format, generator)
+		}
+	}
+
+	if _, err := WithUserDataFormat(cloudConfig, "yaml"); err == nil {
+		t.Fatal("Expect an error for an unknown format")
+	}
+
+	if _, err := (&IgnitionConfig{CloudConfig: &CloudConfig{WriteFiles: []WriteFile{{Path: "/1", Permissions: "rw"}}}}).Generate(); err == nil {
+		t.Fatal("Expect an error for invalid permissions")
+	}
+}
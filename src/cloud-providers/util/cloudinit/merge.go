@@ -0,0 +1,138 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// allowedModules are cloud-config keys that a user supplied cloud-config may have. Other modules are rejected,
+// since many of them allow logging in to pod VMs or weaken their security, e.g. users, ssh_authorized_keys and ca_certs
+// Ref: https://cloudinit.readthedocs.io/en/latest/reference/modules.html
+var allowedModules = map[string]bool{
+	"write_files":                true,
+	"bootcmd":                    true,
+	"runcmd":                     true,
+	"packages":                   true,
+	"package_update":             true,
+	"package_upgrade":            true,
+	"package_reboot_if_required": true,
+	"timezone":                   true,
+	"locale":                     true,
+	"ntp":                        true,
+	"final_message":              true,
+}
+
+// Values of write_files that are written to the generated cloud-config as they are
+var (
+	writeFilePathRegexp        = regexp.MustCompile(`^/[A-Za-z0-9._/@+-]+$`)
+	writeFileOwnerRegexp       = regexp.MustCompile(`^[A-Za-z0-9._-]+(:[A-Za-z0-9._-]+)?$`)
+	writeFilePermissionsRegexp = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+)
+
+// allowedWriteFileDirs are the directories where a user supplied cloud-config may write files.
+// Configuration of pod VMs such as /etc, /usr and /run/peerpod is not included, so that files of the image
+// and the ones written by cloud-api-adaptor are not overwritten.
+var allowedWriteFileDirs = []string{"/opt", "/srv", "/var/opt"}
+
+// Merge merges a user supplied cloud-config into config.
+// write_files entries are appended, and other lists such as runcmd and packages are appended to the ones of previous merges.
+// Other values override the ones of previous merges. Keys of modules other than allowedModules and files outside allowedWriteFileDirs are rejected.
+func (config *CloudConfig) Merge(userData string) error {
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal([]byte(userData), &doc); err != nil {
+		return fmt.Errorf("invalid cloud-config: %w", err)
+	}
+
+	for key, value := range doc {
+		if !allowedModules[key] {
+			return fmt.Errorf("cloud-config module %q is not allowed", key)
+		}
+
+		if key == "write_files" {
+			files, err := mergeWriteFiles([]byte(userData))
+			if err != nil {
+				return err
+			}
+			config.WriteFiles = append(config.WriteFiles, files...)
+			continue
+		}
+
+		if config.Extra == nil {
+			config.Extra = make(map[string]interface{})
+		}
+
+		list, isList := value.([]interface{})
+		prev, prevIsList := config.Extra[key].([]interface{})
+		if isList && prevIsList {
+			config.Extra[key] = append(prev, list...)
+		} else {
+			config.Extra[key] = value
+		}
+	}
+
+	return nil
+}
+
+func mergeWriteFiles(userData []byte) ([]WriteFile, error) {
+
+	// write_files are decoded from the original document, so that values such as permissions 0644 are kept as they are
+	var doc struct {
+		WriteFiles []WriteFile `yaml:"write_files"`
+	}
+	if err := yaml.Unmarshal(userData, &doc); err != nil {
+		return nil, fmt.Errorf("invalid write_files in cloud-config: %w", err)
+	}
+	files := doc.WriteFiles
+
+	for i, file := range files {
+		if !writeFilePathRegexp.MatchString(file.Path) {
+			return nil, fmt.Errorf("invalid path of write_files in cloud-config: %q", file.Path)
+		}
+		if !isAllowedWriteFilePath(file.Path) {
+			return nil, fmt.Errorf("write_files in cloud-config must write to one of %s: %q", strings.Join(allowedWriteFileDirs, ", "), file.Path)
+		}
+		if file.Owner != "" && !writeFileOwnerRegexp.MatchString(file.Owner) {
+			return nil, fmt.Errorf("invalid owner of %s in cloud-config: %q", file.Path, file.Owner)
+		}
+		if file.Permissions != "" && !writeFilePermissionsRegexp.MatchString(file.Permissions) {
+			return nil, fmt.Errorf("invalid permissions of %s in cloud-config: %q", file.Path, file.Permissions)
+		}
+		if file.Append != "" && file.Append != "true" && file.Append != "false" {
+			return nil, fmt.Errorf("invalid append of %s in cloud-config: %q", file.Path, file.Append)
+		}
+
+		// Values are written to the generated cloud-config as they are, so content is always base64 encoded
+		switch file.Encoding {
+		case "":
+			files[i].Encoding = "b64"
+			files[i].Content = base64.StdEncoding.EncodeToString([]byte(file.Content))
+		case "b64", "base64", "gz+b64", "gzip+base64", "gz+base64", "gzip+b64":
+			if _, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(file.Content), "")); err != nil {
+				return nil, fmt.Errorf("invalid base64 content of %s in cloud-config: %w", file.Path, err)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported encoding of %s in cloud-config: %q", file.Path, file.Encoding)
+		}
+	}
+
+	return files, nil
+}
+
+func isAllowedWriteFilePath(p string) bool {
+	p = path.Clean(p)
+	for _, dir := range allowedWriteFileDirs {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
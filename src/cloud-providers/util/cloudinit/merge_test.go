@@ -0,0 +1,113 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMerge(t *testing.T) {
+	config := &CloudConfig{
+		WriteFiles: []WriteFile{
+			{Path: "/run/peerpod/daemon.json", Content: "{}"},
+		},
+	}
+
+	namespaceConfig := `#cloud-config
+runcmd:
+- echo namespace
+timezone: UTC
+`
+	podConfig := `#cloud-config
+runcmd:
+- [sh, -c, "echo pod"]
+packages:
+- jq
+timezone: Asia/Tokyo
+write_files:
+- path: /opt/app/motd
+  permissions: 0644
+  content: |
+    hello
+    world
+`
+
+	if err := config.Merge(namespaceConfig); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := config.Merge(podConfig); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	if len(config.WriteFiles) != 2 {
+		t.Fatalf("Expect 2 files, got %v", config.WriteFiles)
+	}
+	motd := config.WriteFiles[1]
+	if motd.Path != "/opt/app/motd" || motd.Permissions != "0644" || motd.Encoding != "b64" || motd.Content != "aGVsbG8Kd29ybGQK" {
+		t.Fatalf("Unexpected file: %+v", motd)
+	}
+
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !strings.HasPrefix(output, "#cloud-config\n") {
+		t.Fatalf("Expect a cloud-config header, got %q", output)
+	}
+
+	var generated struct {
+		WriteFiles []WriteFile   `yaml:"write_files"`
+		RunCmd     []interface{} `yaml:"runcmd"`
+		Packages   []string      `yaml:"packages"`
+		Timezone   string        `yaml:"timezone"`
+	}
+	if err := yaml.UnmarshalStrict([]byte(output), &generated); err != nil {
+		t.Fatalf("Expect a valid cloud-config, got %v\n%s", err, output)
+	}
+	// The template of the cloud-config terminates content with a newline
+	generated.WriteFiles[1].Content = strings.TrimSuffix(generated.WriteFiles[1].Content, "\n")
+	if len(generated.WriteFiles) != 2 || generated.WriteFiles[1] != motd {
+		t.Fatalf("Unexpected write_files: %+v", generated.WriteFiles)
+	}
+	if len(generated.RunCmd) != 2 || generated.RunCmd[0] != "echo namespace" {
+		t.Fatalf("Unexpected runcmd: %v", generated.RunCmd)
+	}
+	if len(generated.Packages) != 1 || generated.Packages[0] != "jq" {
+		t.Fatalf("Unexpected packages: %v", generated.Packages)
+	}
+	if generated.Timezone != "Asia/Tokyo" {
+		t.Fatalf("Unexpected timezone: %v", generated.Timezone)
+	}
+
+	if _, err := (&IgnitionConfig{CloudConfig: config}).Generate(); err == nil {
+		t.Fatal("Expect an error converting extra modules to ignition")
+	}
+}
+
+func TestMergeRejected(t *testing.T) {
+	for _, userData := range []string{
+		"ssh_authorized_keys:\n- ssh-rsa AAAA\n",
+		"users:\n- name: admin\n",
+		"write_files:\n- path: /run/peerpod/daemon.json\n  content: '{}'\n",
+		"write_files:\n- path: /run/peerpod/../peerpod/aa.toml\n  content: ''\n",
+		"write_files:\n- path: opt/motd\n",
+		"write_files:\n- path: /etc/motd\n  content: hello\n",
+		"write_files:\n- path: /root/.ssh/authorized_keys\n  content: ''\n",
+		"write_files:\n- path: /opt/../etc/containers/policy.json\n  content: '{}'\n",
+		"write_files:\n- path: /opt\n  content: ''\n",
+		"write_files:\n- path: /opt/motd\n  owner: \"root\\nruncmd: [reboot]\"\n",
+		"write_files:\n- path: /opt/motd\n  encoding: b64\n  content: '!!!'\n",
+		"disk_setup:\n  /dev/sdb: {}\n",
+		"unknown_module: true\n",
+		"- not a mapping\n",
+	} {
+		config := &CloudConfig{}
+		if err := config.Merge(userData); err == nil {
+			t.Errorf("Expect an error merging %q", userData)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SealedFilesPath is the path of a file in userdata that contains other files encrypted with a key stored in Trustee.
+// process-user-data obtains the key through the confidential data hub after attestation, and writes the decrypted files.
+const SealedFilesPath = "/run/peerpod/sealed-files.json"
+
+// SealKeySize is the size of AES-256-GCM keys that seal files
+const SealKeySize = 32
+
+// SealedFiles is the content of SealedFilesPath
+type SealedFiles struct {
+	// Resource is the Trustee resource path of the key, e.g. default/pp-<sandbox id>/userdata-key
+	Resource string `json:"resource"`
+	// Data is base64 encoded nonce and ciphertext of a cloud-config that has write_files only
+	Data string `json:"data"`
+}
+
+// NewSealKey generates a random key to seal files
+func NewSealKey() ([]byte, error) {
+	key := make([]byte, SealKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("Error generating a key to seal files: %w", err)
+	}
+	return key, nil
+}
+
+// SealFiles encrypts files with key, and returns a file to be written to SealedFilesPath instead of them
+func SealFiles(files []WriteFile, resource string, key []byte) (*WriteFile, error) {
+
+	plaintext, err := yaml.Marshal(&CloudConfig{WriteFiles: files})
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling files to seal: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Error initializing cipher to seal files: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("Error initializing cipher to seal files: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("Error generating a nonce to seal files: %w", err)
+	}
+
+	// The resource path is authenticated, so that sealed files cannot be paired with a key of another pod VM
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(resource))
+
+	data, err := json.Marshal(&SealedFiles{
+		Resource: resource,
+		Data:     base64.StdEncoding.EncodeToString(sealed),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling sealed files: %w", err)
+	}
+
+	return &WriteFile{
+		Path:        SealedFilesPath,
+		Permissions: "0600",
+		Content:     string(data),
+	}, nil
+}
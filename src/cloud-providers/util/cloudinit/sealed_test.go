@@ -0,0 +1,73 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSealFiles(t *testing.T) {
+	files := []WriteFile{
+		{Path: "/run/peerpod/daemon.json", Content: `{"pod-name":"test"}`},
+		{Path: "/run/peerpod/auth.json", Content: `{"auths":{}}`},
+	}
+	resource := "default/pp-123/userdata-key"
+
+	key, err := NewSealKey()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	file, err := SealFiles(files, resource, key)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if file.Path != SealedFilesPath {
+		t.Fatalf("Expect path %s, got %s", SealedFilesPath, file.Path)
+	}
+
+	var sealed SealedFiles
+	if err := json.Unmarshal([]byte(file.Content), &sealed); err != nil {
+		t.Fatalf("Expect sealed files in JSON, got %v", err)
+	}
+	if sealed.Resource != resource {
+		t.Fatalf("Expect resource %s, got %s", resource, sealed.Resource)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed.Data)
+	if err != nil {
+		t.Fatalf("Expect base64 encoded data, got %v", err)
+	}
+
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	if _, err := aead.Open(nil, nonce, ciphertext, []byte("default/pp-456/userdata-key")); err == nil {
+		t.Fatal("Expect an error when the resource path does not match")
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(resource))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	var cc CloudConfig
+	if err := yaml.Unmarshal(plaintext, &cc); err != nil {
+		t.Fatalf("Expect a cloud config, got %v", err)
+	}
+	if len(cc.WriteFiles) != 2 || cc.WriteFiles[0] != files[0] || cc.WriteFiles[1] != files[1] {
+		t.Fatalf("Expect %v, got %v", files, cc.WriteFiles)
+	}
+
+	if _, err := SealFiles(files, resource, key[:10]); err == nil {
+		t.Fatal("Expect an error with an invalid key")
+	}
+}
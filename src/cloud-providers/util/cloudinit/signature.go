@@ -0,0 +1,78 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SignaturePrefix starts the last line of signed cloud-config userdata.
+// The line is a comment for cloud-init, and process-user-data verifies the preceding content with it.
+const SignaturePrefix = "#peerpod-signature: ed25519:"
+
+// SignedHeaderPrefix starts the first line of signed cloud-config userdata, which is followed by the sandbox ID
+// of the pod VM. cloud-init ignores userdata with an unknown header, so that nothing is applied before
+// process-user-data verifies the signature, which covers the header as well.
+const SignedHeaderPrefix = "#peerpod-signed-userdata: "
+
+var signingKey ed25519.PrivateKey
+
+// SetSigningKey configures the key that signs cloud-config userdata. A nil key disables signing.
+func SetSigningKey(key ed25519.PrivateKey) {
+	signingKey = key
+}
+
+// LoadSigningKey loads a PEM encoded PKCS #8 Ed25519 private key, e.g. generated by "openssl genpkey -algorithm ed25519"
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading userdata signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Error decoding userdata signing key %s: no PEM data", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing userdata signing key %s: %w", path, err)
+	}
+
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("userdata signing key %s is %T, not an Ed25519 key", path, key)
+	}
+
+	return ed25519Key, nil
+}
+
+// signUserData binds cloud-config userdata to a sandbox ID with a header line, and appends a signature line
+// if a signing key is configured. sandboxID is empty for bootstrap userdata, which only points to signed userdata.
+func signUserData(userData, sandboxID string) (string, error) {
+
+	if signingKey == nil {
+		return userData, nil
+	}
+
+	if strings.ContainsAny(sandboxID, "\r\n") {
+		return "", fmt.Errorf("invalid sandbox ID of signed userdata: %q", sandboxID)
+	}
+
+	userData = SignedHeaderPrefix + sandboxID + "\n" + userData
+	if !strings.HasSuffix(userData, "\n") {
+		userData += "\n"
+	}
+
+	signature := ed25519.Sign(signingKey, []byte(userData))
+
+	return userData + SignaturePrefix + base64.StdEncoding.EncodeToString(signature) + "\n", nil
+}
@@ -0,0 +1,103 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSignUserData(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "signing.key")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	key, err := LoadSigningKey(keyPath)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	SetSigningKey(key)
+	defer SetSigningKey(nil)
+
+	cloudConfig := &CloudConfig{
+		WriteFiles: []WriteFile{{Path: "/run/peerpod/daemon.json", Content: "{}"}},
+		SandboxID:  "0123456789abcdef",
+	}
+
+	for sandboxID, generate := range map[string]func() (string, error){
+		cloudConfig.SandboxID: cloudConfig.Generate,
+		"": func() (string, error) {
+			return BootstrapUserData(UserDataFormatCloudConfig, "https://example.com/userdata", []byte("payload"))
+		},
+	} {
+		userData, err := generate()
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+
+		// cloud-init ignores userdata that does not start with #cloud-config
+		if header := SignedHeaderPrefix + sandboxID + "\n#cloud-config\n"; !strings.HasPrefix(userData, header) {
+			t.Fatalf("Expect a header %q, got %q", header, userData)
+		}
+
+		i := strings.LastIndex(userData, "\n"+SignaturePrefix)
+		if i < 0 {
+			t.Fatalf("Expect a signature line, got %q", userData)
+		}
+		content := userData[:i+1]
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(userData[i+1+len(SignaturePrefix):]))
+		if err != nil {
+			t.Fatalf("Expect a base64 encoded signature, got %v", err)
+		}
+		if !ed25519.Verify(publicKey, []byte(content), signature) {
+			t.Fatalf("Expect a valid signature of %q", content)
+		}
+	}
+
+	if _, err := (&IgnitionConfig{CloudConfig: cloudConfig}).Generate(); err == nil {
+		t.Fatal("Expect an error for ignition userdata with a signing key")
+	}
+
+	// bootcmd runs before process-user-data verifies signed userdata
+	if err := cloudConfig.Merge("bootcmd:\n- echo hello\n"); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := cloudConfig.Generate(); err == nil {
+		t.Fatal("Expect an error for bootcmd in signed userdata")
+	}
+	cloudConfig.SandboxID = "invalid\nsandbox"
+	delete(cloudConfig.Extra, "bootcmd")
+	if _, err := cloudConfig.Generate(); err == nil {
+		t.Fatal("Expect an error for an invalid sandbox ID")
+	}
+
+	SetSigningKey(nil)
+	userData, err := cloudConfig.Generate()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !strings.HasPrefix(userData, "#cloud-config\n") || strings.Contains(userData, SignaturePrefix) {
+		t.Fatalf("Expect no signature without a signing key, got %q", userData)
+	}
+
+	if _, err := LoadSigningKey(filepath.Join(t.TempDir(), "missing.key")); err == nil {
+		t.Fatal("Expect an error loading a missing key")
+	}
+}
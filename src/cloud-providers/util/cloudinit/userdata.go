@@ -0,0 +1,122 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Userdata size limits of cloud providers in bytes, before base64 encoding
+const (
+	// Ref: https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instancedata-add-user-data.html
+	AWSUserDataLimit = 16 * 1024
+	// Azure limits base64 encoded userdata to 64KB
+	// Ref: https://learn.microsoft.com/en-us/azure/virtual-machines/user-data
+	AzureUserDataLimit = 64 * 1024 / 4 * 3
+	// GCP limits a metadata value to 256KB, and userdata is stored as a base64 encoded value
+	// Ref: https://cloud.google.com/compute/docs/metadata/setting-custom-metadata#limitations
+	GCPUserDataLimit = 256 * 1024 / 4 * 3
+)
+
+var ErrUserDataTooLarge = errors.New("userdata is too large")
+
+// CompressUserData compresses userdata with gzip.
+// cloud-init, Ignition and process-user-data detect gzip compressed userdata by its magic number.
+func CompressUserData(userData []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, fmt.Errorf("Error initializing gzip writer for userdata: %w", err)
+	}
+	if _, err := w.Write(userData); err != nil {
+		return nil, fmt.Errorf("Error compressing userdata: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("Error compressing userdata: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// FitUserData returns userdata as is if it does not exceed limit, and compressed userdata otherwise.
+// ErrUserDataTooLarge is returned if the compressed userdata still exceeds limit.
+func FitUserData(userData []byte, limit int) ([]byte, error) {
+
+	if len(userData) <= limit {
+		return userData, nil
+	}
+
+	compressed, err := CompressUserData(userData)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(compressed) > limit {
+		return nil, fmt.Errorf("%w: %d bytes (%d bytes compressed) exceeds the limit of %d bytes", ErrUserDataTooLarge, len(userData), len(compressed), limit)
+	}
+
+	return compressed, nil
+}
+
+// BootstrapUserDataKey is the key of a cloud-config that tells process-user-data to fetch the actual userdata from a URL
+const BootstrapUserDataKey = "peerpod_userdata"
+
+// UserDataSource specifies userdata stored outside of instance metadata, e.g. in object storage
+type UserDataSource struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// BootstrapUserData returns small userdata that fetches payload from url and verifies it before use.
+// payload is the actual userdata of the format, optionally gzip compressed.
+func BootstrapUserData(format, url string, payload []byte) (string, error) {
+
+	switch format {
+	case "", UserDataFormatCloudConfig:
+		sum := sha256.Sum256(payload)
+		data, err := yaml.Marshal(map[string]*UserDataSource{
+			BootstrapUserDataKey: {URL: url, SHA256: hex.EncodeToString(sum[:])},
+		})
+		if err != nil {
+			return "", fmt.Errorf("Error marshaling bootstrap userdata: %w", err)
+		}
+		// The userdata that bootstrap userdata points to is bound to a sandbox ID
+		return signUserData("#cloud-config\n"+string(data), "")
+
+	case UserDataFormatIgnition:
+		// Ignition replaces the config with a remote config, and verifies it by itself
+		// Ref: https://coreos.github.io/ignition/configuration-v3_4/
+		sum := sha512.Sum512(payload)
+		resource := map[string]any{
+			"source":       url,
+			"verification": map[string]string{"hash": "sha512-" + hex.EncodeToString(sum[:])},
+		}
+		if bytes.HasPrefix(payload, []byte{0x1f, 0x8b}) {
+			resource["compression"] = "gzip"
+		}
+		data, err := json.Marshal(map[string]any{
+			"ignition": map[string]any{
+				"version": ignitionVersion,
+				"config":  map[string]any{"replace": resource},
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("Error marshaling bootstrap userdata: %w", err)
+		}
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("unknown userdata format: %q", format)
+}
@@ -0,0 +1,103 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestFitUserData(t *testing.T) {
+	small := []byte("#cloud-config\n")
+
+	fitted, err := FitUserData(small, 1024)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !bytes.Equal(small, fitted) {
+		t.Fatalf("Expect %q, got %q", small, fitted)
+	}
+
+	large := []byte("#cloud-config\n" + strings.Repeat("a", 4096))
+
+	fitted, err = FitUserData(large, 1024)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(fitted))
+	if err != nil {
+		t.Fatalf("Expect gzip compressed userdata, got %v", err)
+	}
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !bytes.Equal(large, decompressed) {
+		t.Fatal("Expect decompressed userdata to be the same as the original")
+	}
+
+	if _, err := FitUserData(large, 16); !errors.Is(err, ErrUserDataTooLarge) {
+		t.Fatalf("Expect %v, got %v", ErrUserDataTooLarge, err)
+	}
+}
+
+func TestBootstrapUserData(t *testing.T) {
+	url := "https://bucket.example.com/userdata.gz?X-Amz-Signature=abc&X-Amz-Expires=900"
+	payload, err := CompressUserData([]byte("#cloud-config\n"))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	userData, err := BootstrapUserData(UserDataFormatCloudConfig, url, payload)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !strings.HasPrefix(userData, "#cloud-config\n") {
+		t.Fatalf("Expect a cloud-config, got %q", userData)
+	}
+	var cc map[string]*UserDataSource
+	if err := yaml.Unmarshal([]byte(userData), &cc); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	source := cc[BootstrapUserDataKey]
+	if source == nil || source.URL != url || len(source.SHA256) != 64 {
+		t.Fatalf("Unexpected bootstrap userdata: %q", userData)
+	}
+
+	userData, err = BootstrapUserData(UserDataFormatIgnition, url, payload)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	var ign struct {
+		Ignition struct {
+			Config struct {
+				Replace struct {
+					Source       string `json:"source"`
+					Compression  string `json:"compression"`
+					Verification struct {
+						Hash string `json:"hash"`
+					} `json:"verification"`
+				} `json:"replace"`
+			} `json:"config"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal([]byte(userData), &ign); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	replace := ign.Ignition.Config.Replace
+	if replace.Source != url || replace.Compression != "gzip" || !strings.HasPrefix(replace.Verification.Hash, "sha512-") {
+		t.Fatalf("Unexpected bootstrap userdata: %q", userData)
+	}
+
+	if _, err := BootstrapUserData("yaml", url, payload); err == nil {
+		t.Fatal("Expect an error for an unknown format")
+	}
+}
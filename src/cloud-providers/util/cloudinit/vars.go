@@ -0,0 +1,38 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// UserDataVars holds the variables available to user supplied userdata fragments,
+// e.g. "{{.Namespace}}/{{.PodName}}", so that pod VMs can identify themselves
+type UserDataVars struct {
+	PodName   string
+	Namespace string
+	SandboxID string
+	NodeName  string
+	ClusterID string
+	Provider  string
+}
+
+// ExpandUserDataVars executes text as a template of text/template syntax with vars.
+// References to unknown variables are errors.
+func ExpandUserDataVars(text string, vars *UserDataVars) (string, error) {
+
+	tmpl, err := template.New("userdata").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid userdata template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("failed to expand userdata template: %w", err)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,32 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudinit
+
+import "testing"
+
+func TestExpandUserDataVars(t *testing.T) {
+	vars := &UserDataVars{
+		PodName:   "nginx",
+		Namespace: "default",
+		SandboxID: "123",
+		NodeName:  "worker-1",
+		ClusterID: "prod",
+		Provider:  "aws",
+	}
+
+	got, err := ExpandUserDataVars("runcmd:\n- echo {{.ClusterID}}/{{.Namespace}}/{{.PodName}} {{.SandboxID}} {{.NodeName}} {{.Provider}}\n", vars)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if want := "runcmd:\n- echo prod/default/nginx 123 worker-1 aws\n"; got != want {
+		t.Fatalf("Expect %q, got %q", want, got)
+	}
+
+	if _, err := ExpandUserDataVars("{{.Unknown}}", vars); err == nil {
+		t.Fatal("Expect an error with an unknown variable")
+	}
+	if _, err := ExpandUserDataVars("{{.PodName", vars); err == nil {
+		t.Fatal("Expect an error with an invalid template")
+	}
+}
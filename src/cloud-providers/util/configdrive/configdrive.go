@@ -0,0 +1,119 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package configdrive builds ISO images that pass userdata to pod VMs of providers without a userdata API
+package configdrive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kdomanski/iso9660"
+	"gopkg.in/yaml.v2"
+)
+
+// Format is the layout of a config drive
+type Format string
+
+const (
+	// NoCloud is the layout of the cloud-init NoCloud datasource. process-user-data reads user-data of it
+	// Ref: https://cloudinit.readthedocs.io/en/latest/reference/datasources/nocloud.html
+	NoCloud Format = "nocloud"
+	// ConfigDrive is the layout of the OpenStack config drive
+	// Ref: https://cloudinit.readthedocs.io/en/latest/reference/datasources/configdrive.html
+	ConfigDrive Format = "configdrive"
+)
+
+const (
+	NoCloudVolumeName  = "cidata"
+	UserDataFilename   = "user-data"
+	MetaDataFilename   = "meta-data"
+	VendorDataFilename = "vendor-data"
+
+	ConfigDriveVolumeName      = "config-2"
+	ConfigDriveUserDataPath    = "openstack/latest/user_data"
+	ConfigDriveMetaDataPath    = "openstack/latest/meta_data.json"
+	ConfigDriveVendorDataPath  = "openstack/latest/vendor_data.json"
+	configDriveEmptyVendorData = "{}"
+)
+
+// MetaData is the instance metadata written to a config drive
+type MetaData struct {
+	InstanceID string
+	Hostname   string
+}
+
+// ParseFormat returns the format of a name. An empty name is NoCloud
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", NoCloud:
+		return NoCloud, nil
+	case ConfigDrive:
+		return ConfigDrive, nil
+	}
+	return "", fmt.Errorf("unknown config drive format %q", name)
+}
+
+// Build produces an ISO image of a config drive as a data blob
+func Build(format Format, userData []byte, metaData MetaData) ([]byte, error) {
+	var files map[string][]byte
+	var volumeName string
+
+	switch format {
+	case NoCloud:
+		meta, err := yaml.Marshal(struct {
+			InstanceID string `yaml:"instance-id,omitempty"`
+			Hostname   string `yaml:"local-hostname,omitempty"`
+		}{metaData.InstanceID, metaData.Hostname})
+		if err != nil {
+			return nil, err
+		}
+		files = map[string][]byte{
+			UserDataFilename:   userData,
+			MetaDataFilename:   meta,
+			VendorDataFilename: {},
+		}
+		volumeName = NoCloudVolumeName
+	case ConfigDrive:
+		meta, err := json.Marshal(struct {
+			UUID     string `json:"uuid,omitempty"`
+			Hostname string `json:"hostname,omitempty"`
+			Name     string `json:"name,omitempty"`
+		}{metaData.InstanceID, metaData.Hostname, metaData.Hostname})
+		if err != nil {
+			return nil, err
+		}
+		files = map[string][]byte{
+			ConfigDriveUserDataPath:   userData,
+			ConfigDriveMetaDataPath:   meta,
+			ConfigDriveVendorDataPath: []byte(configDriveEmptyVendorData),
+		}
+		volumeName = ConfigDriveVolumeName
+	default:
+		return nil, fmt.Errorf("unknown config drive format %q", format)
+	}
+
+	return createISO(volumeName, files)
+}
+
+func createISO(volumeName string, files map[string][]byte) ([]byte, error) {
+	writer, err := iso9660.NewWriter()
+	if err != nil {
+		return nil, err
+	}
+	defer writer.Cleanup() //nolint:errcheck // no need to check error in deferal
+
+	for name, data := range files {
+		if err := writer.AddFile(bytes.NewReader(data), name); err != nil {
+			return nil, fmt.Errorf("failed to add %s to config drive: %w", name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writer.WriteTo(&buf, volumeName); err != nil {
+		return nil, fmt.Errorf("failed to write config drive: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package configdrive
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"testing"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// readISO returns the volume identifier and the files of an ISO image
+func readISO(t *testing.T, data []byte) (string, map[string]string) {
+	img, err := iso9660.OpenImage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	label, err := img.Label()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	root, err := img.RootDir()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	files := map[string]string{}
+	var walk func(dir string, f *iso9660.File)
+	walk = func(dir string, f *iso9660.File) {
+		children, err := f.GetChildren()
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		for _, child := range children {
+			name := path.Join(dir, child.Name())
+			if child.IsDir() {
+				walk(name, child)
+				continue
+			}
+			content, err := io.ReadAll(child.Reader())
+			if err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+			files[name] = string(content)
+		}
+	}
+	walk("", root)
+
+	return label, files
+}
+
+func TestBuild(t *testing.T) {
+	userData := "#cloud-config\nwrite_files: []\n"
+	metaData := MetaData{InstanceID: "123", Hostname: "podvm-test"}
+
+	tests := []struct {
+		format Format
+		label  string
+		files  map[string]string
+	}{
+		{
+			format: NoCloud,
+			label:  "cidata",
+			files: map[string]string{
+				"user-data":   userData,
+				"meta-data":   "instance-id: \"123\"\nlocal-hostname: podvm-test\n",
+				"vendor-data": "",
+			},
+		},
+		{
+			format: ConfigDrive,
+			label:  "config-2",
+			files: map[string]string{
+				"openstack/latest/user_data":        userData,
+				"openstack/latest/meta_data.json":   `{"uuid":"123","hostname":"podvm-test","name":"podvm-test"}`,
+				"openstack/latest/vendor_data.json": "{}",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.format), func(t *testing.T) {
+			data, err := Build(tc.format, []byte(userData), metaData)
+			if err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+
+			label, files := readISO(t, data)
+			if label != tc.label {
+				t.Errorf("Expect label %q, got %q", tc.label, label)
+			}
+			if len(files) != len(tc.files) {
+				t.Errorf("Expect files %v, got %v", tc.files, files)
+			}
+			for name, content := range tc.files {
+				if files[name] != content {
+					t.Errorf("Expect %s to be %q, got %q", name, content, files[name])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildUnknownFormat(t *testing.T) {
+	if _, err := Build("unknown", nil, MetaData{}); err == nil {
+		t.Fatalf("Expect an error with an unknown format")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, expected := range map[string]Format{"": NoCloud, "nocloud": NoCloud, "configdrive": ConfigDrive} {
+		format, err := ParseFormat(name)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if format != expected {
+			t.Errorf("Expect %q, got %q", expected, format)
+		}
+	}
+	if _, err := ParseFormat("iso"); err == nil {
+		t.Fatalf("Expect an error with an unknown format")
+	}
+}
@@ -1,20 +1,184 @@
 package util
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"log"
 	"strings"
+	"text/template"
 )
 
 const (
 	podvmNamePrefix = "podvm"
+	hashLen         = 8
+
+	// minInstanceNameLen is the length of "podvm-" followed by a character, a hyphen and a hash,
+	// or of the default name with an empty pod name
+	minInstanceNameLen = len(podvmNamePrefix) + hashLen + 2
 )
 
+var logger = log.New(log.Writer(), "[util/instance] ", log.LstdFlags|log.Lmsgprefix)
+
+// InstanceNameData holds the fields available to an instance name template
+type InstanceNameData struct {
+	PodName   string
+	Namespace string
+	SandboxID string
+	NodeName  string
+	ClusterID string
+	Hash      string
+}
+
+// InstanceNameRules describe the instance names that a provider accepts. Generated names always start with a
+// lower case letter and end with a lower case letter or a digit.
+type InstanceNameRules struct {
+	// MaxLen is the maximum length of instance names, or 0 if it is not limited
+	MaxLen int
+	// Chars are the characters allowed in instance names besides lower case letters, digits and hyphens
+	Chars string
+}
+
+// DefaultInstanceNameRules are the rules of providers that accept DNS labels as instance names
+var DefaultInstanceNameRules = InstanceNameRules{MaxLen: 63}
+
+func (r InstanceNameRules) allowed(c rune) bool {
+	return ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || strings.ContainsRune(r.Chars, c)
+}
+
+// InstanceNamer generates instance names from a template. It is created once at startup and passed to
+// providers in the context of CreateInstance, see WithInstanceNamer
+type InstanceNamer struct {
+	template  *template.Template
+	text      string
+	nodeName  string
+	clusterID string
+}
+
+// NewInstanceNamer creates an instance namer. The template uses text/template syntax and can refer to the fields of
+// InstanceNameData, e.g. "{{.ClusterID}}-{{.Namespace}}-{{.PodName}}-{{.Hash}}". An empty text keeps the default
+// "podvm-<pod name>-<sandbox id>" naming.
+func NewInstanceNamer(text, nodeName, clusterID string) (*InstanceNamer, error) {
+	n := &InstanceNamer{
+		text:      text,
+		nodeName:  nodeName,
+		clusterID: clusterID,
+	}
+	if text == "" {
+		return n, nil
+	}
+
+	tmpl, err := template.New("instance-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance name template %q: %w", text, err)
+	}
+
+	// Catch references to unknown fields early instead of at instance creation time
+	if err := tmpl.Execute(&bytes.Buffer{}, InstanceNameData{}); err != nil {
+		return nil, fmt.Errorf("invalid instance name template %q: %w", text, err)
+	}
+	n.template = tmpl
+
+	return n, nil
+}
+
+// ClusterID returns the cluster ID of the instance namer
+func (n *InstanceNamer) ClusterID() string {
+	if n == nil {
+		return ""
+	}
+	return n.clusterID
+}
+
+// Validate checks that the template of the instance namer generates valid names for a provider with the given
+// rules, so that an invalid template is reported at startup instead of when instances are created
+func (n *InstanceNamer) Validate(rules InstanceNameRules) error {
+	if rules.MaxLen > 0 && rules.MaxLen < minInstanceNameLen {
+		return fmt.Errorf("maximum instance name length %d is less than %d", rules.MaxLen, minInstanceNameLen)
+	}
+	if n == nil || n.template == nil {
+		return nil
+	}
+
+	// The values of the fields are sanitized, so only the characters of the template itself are checked
+	var buf bytes.Buffer
+	if err := n.template.Execute(&buf, InstanceNameData{
+		PodName:   "pod",
+		Namespace: "namespace",
+		SandboxID: "0123456789abcdef",
+		NodeName:  "node",
+		ClusterID: "cluster",
+		Hash:      "01234567",
+	}); err != nil {
+		return fmt.Errorf("invalid instance name template %q: %w", n.text, err)
+	}
+	for _, c := range strings.ToLower(buf.String()) {
+		if !rules.allowed(c) {
+			return fmt.Errorf("instance name template %q has the character %q, which is not allowed in instance names of the cloud provider", n.text, c)
+		}
+	}
+
+	return nil
+}
+
+type instanceNamerKey struct{}
+
+// WithInstanceNamer returns a copy of ctx carrying the instance namer that providers use to generate instance names
+func WithInstanceNamer(ctx context.Context, namer *InstanceNamer) context.Context {
+	return context.WithValue(ctx, instanceNamerKey{}, namer)
+}
+
+func instanceNamerFrom(ctx context.Context) *InstanceNamer {
+	if ctx == nil {
+		return nil
+	}
+	namer, _ := ctx.Value(instanceNamerKey{}).(*InstanceNamer)
+	return namer
+}
+
+// ClusterID returns the cluster ID of the instance namer in ctx, or an empty string if there is none
+func ClusterID(ctx context.Context) string {
+	return instanceNamerFrom(ctx).ClusterID()
+}
+
+type podNamespaceKey struct{}
+
+// WithPodNamespace returns a copy of ctx carrying the namespace of the pod an instance is created for,
+// so that it can be referred to by an instance name template
+func WithPodNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, podNamespaceKey{}, namespace)
+}
+
+func podNamespaceFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	namespace, _ := ctx.Value(podNamespaceKey{}).(string)
+	return namespace
+}
+
+// InstancePod returns the namespace/name of the pod an instance is created for, which providers set in the
+// PodTagKey tag of the instance. It returns an empty string if the namespace is not in ctx
+func InstancePod(ctx context.Context, podName string) string {
+	namespace := podNamespaceFrom(ctx)
+	if namespace == "" {
+		return ""
+	}
+	return namespace + "/" + podName
+}
+
 func sanitize(input string) string {
+	return sanitizeWith(input, InstanceNameRules{})
+}
+
+// sanitizeWith lower cases input and replaces the characters that rules don't allow with hyphens
+func sanitizeWith(input string, rules InstanceNameRules) string {
 
 	var output string
 
 	for _, c := range strings.ToLower(input) {
-		if !(('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-') {
+		if !rules.allowed(c) {
 			c = '-'
 		}
 		output += string(c)
@@ -23,7 +187,66 @@ func sanitize(input string) string {
 	return output
 }
 
-func GenerateInstanceName(podName, sandboxID string, podvmNameMax int) string {
+// trimName removes the characters other than lower case letters and digits from the ends of a name
+func trimName(name string) string {
+	return strings.TrimFunc(name, func(c rune) bool {
+		return !(('a' <= c && c <= 'z') || ('0' <= c && c <= '9'))
+	})
+}
+
+func instanceNameHash(namespace, podName, sandboxID string) string {
+	sum := sha256.Sum256([]byte(namespace + "/" + podName + "/" + sandboxID))
+	return fmt.Sprintf("%x", sum)[:hashLen]
+}
+
+// GenerateInstanceName returns the name of the instance created for the given pod and sandbox with the instance
+// namer in ctx. The name only contains lower case alphanumeric characters, hyphens and the characters allowed by
+// rules, starts with a letter, and is no longer than rules.MaxLen characters when it is positive.
+func GenerateInstanceName(ctx context.Context, podName, sandboxID string, rules InstanceNameRules) (string, error) {
+
+	podvmNameMax := rules.MaxLen
+	if podvmNameMax > 0 && podvmNameMax < minInstanceNameLen {
+		return "", fmt.Errorf("maximum instance name length %d is less than %d", podvmNameMax, minInstanceNameLen)
+	}
+
+	namer := instanceNamerFrom(ctx)
+	if namer == nil || namer.template == nil {
+		return generateDefaultInstanceName(podName, sandboxID, podvmNameMax)
+	}
+
+	namespace := podNamespaceFrom(ctx)
+	hash := instanceNameHash(namespace, podName, sandboxID)
+
+	data := InstanceNameData{
+		PodName:   podName,
+		Namespace: namespace,
+		SandboxID: sandboxID,
+		NodeName:  namer.nodeName,
+		ClusterID: namer.clusterID,
+		Hash:      hash,
+	}
+
+	var buf bytes.Buffer
+	if err := namer.template.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute instance name template: %w", err)
+	}
+
+	name := trimName(sanitizeWith(buf.String(), rules))
+	if name == "" {
+		name = podvmNamePrefix + "-" + hash
+	} else if name[0] < 'a' || name[0] > 'z' {
+		name = podvmNamePrefix + "-" + name
+	}
+
+	// Keep the hash at the end of truncated names so that they stay unique
+	if podvmNameMax > 0 && len(name) > podvmNameMax {
+		name = trimName(name[:podvmNameMax-hashLen-1]) + "-" + hash
+	}
+
+	return name, nil
+}
+
+func generateDefaultInstanceName(podName, sandboxID string, podvmNameMax int) (string, error) {
 
 	podName = sanitize(podName)
 	sandboxID = sanitize(sandboxID)
@@ -32,13 +255,10 @@ func GenerateInstanceName(podName, sandboxID string, podvmNameMax int) string {
 	podNameLen := len(podName)
 	if podvmNameMax > 0 && prefixLen+podNameLen+10 > podvmNameMax {
 		podNameLen = podvmNameMax - prefixLen - 10
-		if podNameLen < 0 {
-			panic(fmt.Errorf("podvmNameMax is too small: %d", podvmNameMax))
-		}
-		fmt.Printf("podNameLen: %d", podNameLen)
+		logger.Printf("Truncating pod name %s to %d characters in the instance name", podName, podNameLen)
 	}
 
 	instanceName := fmt.Sprintf("%s-%.*s-%.8s", podvmNamePrefix, podNameLen, podName, sandboxID)
 
-	return instanceName
+	return instanceName, nil
 }
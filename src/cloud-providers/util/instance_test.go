@@ -0,0 +1,119 @@
+package util
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestGenerateInstanceNameDefault(t *testing.T) {
+	namer, err := NewInstanceNamer("", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, ctx := range []context.Context{context.Background(), WithInstanceNamer(context.Background(), namer)} {
+		name, err := GenerateInstanceName(ctx, "My_Pod", "0123456789abcdef", DefaultInstanceNameRules)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "podvm-my-pod-01234567" {
+			t.Errorf("unexpected instance name: %q", name)
+		}
+	}
+}
+
+func TestGenerateInstanceNameTemplate(t *testing.T) {
+	namer, err := NewInstanceNamer("{{.ClusterID}}-{{.Namespace}}-{{.PodName}}-{{.NodeName}}", "Worker.1", "prod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx := WithPodNamespace(WithInstanceNamer(context.Background(), namer), "team-a")
+
+	if clusterID := ClusterID(ctx); clusterID != "prod" {
+		t.Errorf("unexpected cluster ID: %q", clusterID)
+	}
+
+	name, err := GenerateInstanceName(ctx, "nginx", "0123456789abcdef", DefaultInstanceNameRules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "prod-team-a-nginx-worker-1" {
+		t.Errorf("unexpected instance name: %q", name)
+	}
+
+	// Characters allowed by the rules of a provider are kept
+	name, err = GenerateInstanceName(ctx, "nginx", "0123456789abcdef", InstanceNameRules{MaxLen: 63, Chars: "."})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "prod-team-a-nginx-worker.1" {
+		t.Errorf("unexpected instance name: %q", name)
+	}
+
+	// Truncated names keep the hash suffix and the length limit
+	long, err := GenerateInstanceName(ctx, strings.Repeat("x", 80), "0123456789abcdef", InstanceNameRules{MaxLen: 30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(long) > 30 {
+		t.Errorf("instance name %q exceeds the maximum length", long)
+	}
+	if !strings.HasSuffix(long, "-"+instanceNameHash("team-a", strings.Repeat("x", 80), "0123456789abcdef")) {
+		t.Errorf("truncated instance name %q does not end with the hash", long)
+	}
+
+	// Names must start with a letter
+	namer, err = NewInstanceNamer("{{.Hash}}", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx = WithInstanceNamer(ctx, namer)
+	if name, err := GenerateInstanceName(ctx, "nginx", "0123456789abcdef", DefaultInstanceNameRules); err != nil || !strings.HasPrefix(name, "podvm-") {
+		t.Errorf("unexpected instance name: %q", name)
+	}
+}
+
+func TestNewInstanceNamerInvalid(t *testing.T) {
+	for _, text := range []string{"{{.PodName", "{{.Unknown}}"} {
+		if _, err := NewInstanceNamer(text, "", ""); err == nil {
+			t.Errorf("expected an error for template %q", text)
+		}
+	}
+}
+
+func TestValidateInstanceNamer(t *testing.T) {
+	var namer *InstanceNamer
+	if err := namer.Validate(DefaultInstanceNameRules); err != nil {
+		t.Errorf("unexpected error for the default name: %v", err)
+	}
+	if err := namer.Validate(InstanceNameRules{MaxLen: minInstanceNameLen - 1}); err == nil {
+		t.Error("expected an error for a too short maximum length")
+	}
+	if _, err := GenerateInstanceName(context.Background(), "nginx", "0123456789abcdef", InstanceNameRules{MaxLen: minInstanceNameLen - 1}); err == nil {
+		t.Error("expected an error for a too short maximum length")
+	}
+
+	for _, tc := range []struct {
+		text  string
+		rules InstanceNameRules
+		valid bool
+	}{
+		{"{{.ClusterID}}-{{.Namespace}}-{{.PodName}}-{{.Hash}}", DefaultInstanceNameRules, true},
+		{"PodVM-{{.PodName}}", DefaultInstanceNameRules, true},
+		{"{{.Namespace}}_{{.PodName}}", DefaultInstanceNameRules, false},
+		{"{{.Namespace}}.{{.PodName}}", DefaultInstanceNameRules, false},
+		{"{{.Namespace}}_{{.PodName}}", InstanceNameRules{MaxLen: 255, Chars: "._"}, true},
+		{"{{.Namespace}}.{{.PodName}}", InstanceNameRules{MaxLen: 63, Chars: "._"}, true},
+		{"{{.Namespace}}/{{.PodName}}", InstanceNameRules{MaxLen: 63, Chars: "._"}, false},
+	} {
+		namer, err := NewInstanceNamer(tc.text, "", "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := namer.Validate(tc.rules); (err == nil) != tc.valid {
+			t.Errorf("template %q with rules %+v: expected valid %v, got %v", tc.text, tc.rules, tc.valid, err)
+		}
+	}
+}
@@ -613,3 +613,77 @@ func TestGetBestFitInstanceTypeWithGPU(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectInstanceTypeWithGPUModel(t *testing.T) {
+	specList := SortInstanceTypesOnResources([]InstanceTypeSpec{
+		{InstanceType: "g4dn.xlarge", GPUs: 1, GPUModel: "T4", VCPUs: 4, Memory: 16384},
+		{InstanceType: "g5.xlarge", GPUs: 1, GPUModel: "A10G", VCPUs: 4, Memory: 16384},
+		{InstanceType: "p4d.24xlarge", GPUs: 8, GPUModel: "A100", VCPUs: 96, Memory: 1179648},
+	})
+	validInstanceTypes := []string{"g4dn.xlarge", "g5.xlarge", "p4d.24xlarge"}
+
+	tests := []struct {
+		name          string
+		spec          InstanceTypeSpec
+		expected      string
+		expectedError bool
+	}{
+		{name: "any model", spec: InstanceTypeSpec{GPUs: 1}, expected: "g4dn.xlarge"},
+		{name: "model", spec: InstanceTypeSpec{GPUs: 1, GPUModel: "A10G"}, expected: "g5.xlarge"},
+		{name: "product name", spec: InstanceTypeSpec{GPUs: 1, GPUModel: "NVIDIA-A100-SXM4-40GB"}, expected: "p4d.24xlarge"},
+		{name: "vendor prefix", spec: InstanceTypeSpec{GPUs: 1, GPUModel: "Tesla-T4"}, expected: "g4dn.xlarge"},
+		{name: "unknown model", spec: InstanceTypeSpec{GPUs: 1, GPUModel: "H100"}, expectedError: true},
+		{name: "too many GPUs of model", spec: InstanceTypeSpec{GPUs: 2, GPUModel: "T4"}, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SelectInstanceTypeToUse(tt.spec, specList, validInstanceTypes, "")
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("expected error but got %s", result)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if result != tt.expected {
+					t.Errorf("expected %s but got %s", tt.expected, result)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyCredentials(t *testing.T) {
+	var id, secret string
+	setters := map[string]func(string) error{
+		"ID":     SetString(&id),
+		"SECRET": SetString(&secret),
+		"BAD":    func(string) error { return fmt.Errorf("bad") },
+	}
+
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		wantErr     bool
+	}{
+		{name: "all keys", credentials: map[string]string{"ID": "id", "SECRET": "secret"}},
+		{name: "missing required key", credentials: map[string]string{"SECRET": "secret"}, wantErr: true},
+		{name: "unsupported key", credentials: map[string]string{"ID": "id", "OTHER": "other"}, wantErr: true},
+		{name: "invalid value", credentials: map[string]string{"ID": "id", "BAD": "bad"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, secret = "", ""
+			err := ApplyCredentials(tt.credentials, setters, "ID")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (id != "id" || secret != "secret") {
+				t.Errorf("unexpected fields: %q, %q", id, secret)
+			}
+		})
+	}
+}
@@ -24,8 +24,6 @@ import (
 
 var logger = log.New(log.Writer(), "[adaptor/cloud/vsphere] ", log.LstdFlags|log.Lmsgprefix)
 
-const maxInstanceNameLen = 63
-
 type vsphereProvider struct {
 	gclient       *govmomi.Client
 	serviceConfig *Config
@@ -78,11 +76,14 @@ type VmConfig []types.BaseOptionValue
 
 func (p *vsphereProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, requirement provider.InstanceTypeSpec) (*provider.Instance, error) {
 
-	vmname := util.GenerateInstanceName(podName, sandboxID, maxInstanceNameLen)
+	vmname, err := util.GenerateInstanceName(ctx, podName, sandboxID, util.DefaultInstanceNameRules)
+	if err != nil {
+		return nil, err
+	}
 
 	logger.Printf("Start CreateInstance VM name %s", vmname)
 
-	err := CheckSessionWithRestore(ctx, p.serviceConfig, p.gclient)
+	err = CheckSessionWithRestore(ctx, p.serviceConfig, p.gclient)
 	if err != nil {
 		logger.Printf("CreateInstance cannot find or create a new vcenter session")
 		return nil, err
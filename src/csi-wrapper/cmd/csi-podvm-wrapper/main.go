@@ -7,6 +7,7 @@ import (
 	"context"
 	"flag"
 	"os"
+	"time"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/csi-wrapper/pkg/apis/peerpodvolume/v1alpha1"
 	"github.com/confidential-containers/cloud-api-adaptor/src/csi-wrapper/pkg/config"
@@ -29,6 +30,8 @@ func main() {
 	flag.StringVar(&cfg.Endpoint, "endpoint", "/csi/csi-podvm-wrapper.sock", "Wrapper CSI Node service endpoint path")
 	flag.StringVar(&cfg.Namespace, "namespace", "default", "The namespace where the peer pod volume crd object will be created")
 	flag.StringVar(&cfg.TargetEndpoint, "target-endpoint", "/csi/csi.sock", "Target CSI Node service endpoint path")
+	var volumeStatsInterval time.Duration
+	flag.DurationVar(&volumeStatsInterval, "volume-stats-interval", time.Minute, "Interval of reporting the stats of the volumes of the peer pod, 0 to disable")
 
 	flag.Parse()
 
@@ -80,24 +83,21 @@ func main() {
 	for idx, savedPeerpodvolume := range peerpodVolumes.Items {
 		glog.Infof("Index of peerpodVolumes.Items: %v ", idx)
 		glog.Infof("peerpodVolumes detail: %v ", savedPeerpodvolume)
-		savedPeerpodvolume.Spec.PodName = podName
-		savedPeerpodvolume.Spec.PodNamespace = podNamespace
-		savedPeerpodvolume.Spec.NodeName = podNodeName
-		savedPeerpodvolume.Labels["podName"] = podName
-		savedPeerpodvolume.Labels["podNamespace"] = podNamespace
-		savedPeerpodvolume.Labels["podNodeName"] = podNodeName
-		updatedPeerpodvolume, err := peerPodVolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(cfg.Namespace).Update(context.Background(), &savedPeerpodvolume, metav1.UpdateOptions{})
-		if err != nil {
-			glog.Fatalf("Error happens while Update podName and podNamespace to PeerpodVolume, err: %v", err.Error())
-		}
-		updatedPeerpodvolume.Status = v1alpha1.PeerpodVolumeStatus{
-			State: v1alpha1.PeerPodVSIRunning,
-		}
-		_, err = peerPodVolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(cfg.Namespace).UpdateStatus(context.Background(), updatedPeerpodvolume, metav1.UpdateOptions{})
+		_, err := wrapper.UpdatePeerpodVolume(context.Background(), peerPodVolumeClient, cfg.Namespace, savedPeerpodvolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+			peerpodVolume.Spec.PodName = podName
+			peerpodVolume.Spec.PodNamespace = podNamespace
+			peerpodVolume.Spec.NodeName = podNodeName
+			peerpodVolume.Labels["podName"] = podName
+			peerpodVolume.Labels["podNamespace"] = podNamespace
+			peerpodVolume.Labels["podNodeName"] = podNodeName
+		}, v1alpha1.PeerPodVSIRunning)
 		if err != nil {
 			glog.Fatalf("Error happens while Update PeerpodVolume status to PeerPodVSIRunning, err: %v", err.Error())
 		}
 	}
+	if volumeStatsInterval > 0 {
+		go podvmService.ReportVolumeStats(context.Background(), podUid, volumeStatsInterval)
+	}
 	if err := wrapper.Run(cfg.Endpoint, identityService, nil, podvmService); err != nil {
 		glog.Fatalf("Failed to run csi podvm plugin wrapper: %s", err.Error())
 	}
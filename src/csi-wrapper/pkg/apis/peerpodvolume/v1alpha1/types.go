@@ -39,6 +39,12 @@ type PeerpodVolumeSpec struct {
 	WrapperNodePublishVolumeReq       string `json:"wrapperNodePublishVolumeReq"`
 	WrapperNodeUnpublishVolumeReq     string `json:"wrapperNodeUnpublishVolumeReq"`
 	WrapperNodeUnstageVolumeReq       string `json:"wrapperNodeUnstageVolumeReq"`
+	WrapperNodeExpandVolumeReq        string `json:"wrapperNodeExpandVolumeReq,omitempty"`
+	// SourceSnapshotID is the snapshot that the volume is restored from, if any
+	SourceSnapshotID string `json:"sourceSnapshotID,omitempty"`
+	// EncryptionKeyResource is the KBS resource path of the key of the volume, which is formatted with LUKS
+	// in the peer pod VM if it is set
+	EncryptionKeyResource string `json:"encryptionKeyResource,omitempty"`
 }
 
 type PeerpodVolumeState string
@@ -50,6 +56,7 @@ const (
 	NodePublishVolumeCached       PeerpodVolumeState = "nodePublishVolumeCached"
 	NodeUnpublishVolumeCached     PeerpodVolumeState = "nodeUnpublishVolumeCached"
 	NodeUnstageVolumeCached       PeerpodVolumeState = "nodeUnstageVolumeCached"
+	NodeExpandVolumeCached        PeerpodVolumeState = "nodeExpandVolumeCached"
 	// The VSI instance id MUST be set when update the status to `peerPodVSIIDReady`
 	PeerPodVSIIDReady PeerpodVolumeState = "peerPodVSIIDReady"
 	// We can get the VSI instance from cloud-api-adaptor podVMInfoService when update the status to `peerPodVSIRunning`
@@ -59,6 +66,10 @@ const (
 	ControllerPublishVolumeApplied PeerpodVolumeState = "controllerPublishVolumeApplied"
 	NodeStageVolumeApplied         PeerpodVolumeState = "nodeStageVolumeApplied"
 	NodePublishVolumeApplied       PeerpodVolumeState = "nodePublishVolumeApplied"
+	// The cached NodeExpandVolume will be reproduced in the peer-pod to resize the filesystem of a published volume
+	// after the csi-driver expands the volume
+	NodeExpandVolumeApplied PeerpodVolumeState = "nodeExpandVolumeApplied"
+	NodeExpandVolumeFailed  PeerpodVolumeState = "nodeExpandVolumeFailed"
 	// csi-wrapper plugins will call original csi-driver to release volumes when peer-pod be deleted
 	NodeUnpublishVolumeApplied       PeerpodVolumeState = "nodeUnpublishVolumeApplied"
 	NodeUnstageVolumeApplied         PeerpodVolumeState = "nodeUnstageVolumeApplied"
@@ -68,6 +79,9 @@ const (
 // PeerpodVolumeStatus is the status for a PeerpodVolume resource
 type PeerpodVolumeStatus struct {
 	State PeerpodVolumeState `json:"state"`
+	// VolumeStats is the NodeGetVolumeStatsResponse of the volume published in the peer pod VM, which is
+	// reported by the podvm wrapper
+	VolumeStats string `json:"volumeStats,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
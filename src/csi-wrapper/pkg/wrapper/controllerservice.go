@@ -20,7 +20,9 @@ import (
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/jsonpb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -52,6 +54,20 @@ const (
 // azureVMRegexp checks if used to validate an Azure resource ID for a VM, or scale set VM.
 var azureVMRegexp = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Compute/(virtualMachines|virtualMachineScaleSets/[^/]+/virtualMachines)/[^/]+$`)
 
+// gceNodeRegexp matches the node ID of the GCE PD csi driver, which is the path of the instance of the worker node.
+var gceNodeRegexp = regexp.MustCompile(`^(projects/[^/]+/zones/[^/]+/instances/)[^/]+$`)
+
+// gceNodeID returns the node ID of a peer pod VM for the GCE PD csi driver, which attaches disks to the instance in the
+// path of the node ID. The peer pod VMs are in the project and zone of the worker nodes, so only the name of the instance
+// of the worker node is replaced.
+func gceNodeID(nodeID, vmID string) (string, bool) {
+	match := gceNodeRegexp.FindStringSubmatch(nodeID)
+	if match == nil {
+		return "", false
+	}
+	return match[1] + utils.NormalizeVMID(vmID), true
+}
+
 type ControllerService struct {
 	TargetEndpoint      string
 	Namespace           string
@@ -81,22 +97,35 @@ func (s *ControllerService) redirect(ctx context.Context, req interface{}, fn fu
 }
 
 func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (res *csi.CreateVolumeResponse, err error) {
+	peerpod := req.Parameters[PeerpodParamKey]
+	encryptionKey := req.Parameters[PeerpodEncryptionKeyParamKey]
+	if encryptionKey != "" && peerpod == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "parameter %s requires parameter %s", PeerpodEncryptionKeyParamKey, PeerpodParamKey)
+	}
+
 	if e := s.redirect(ctx, req, func(ctx context.Context, client csi.ControllerClient) {
-		peerpod := req.Parameters[PeerpodParamKey]
-		if peerpod != "" {
-			// Delete peerpod key from req parameters because csi driver may check parameters strictly.
-			delete(req.Parameters, PeerpodParamKey)
-		}
+		// Delete peerpod keys from req parameters because csi driver may check parameters strictly.
+		delete(req.Parameters, PeerpodParamKey)
+		delete(req.Parameters, PeerpodEncryptionKeyParamKey)
 
 		volumeName := req.Name
+		// A volume restored from a VolumeSnapshot is created by the csi driver from the snapshot
+		snapshotID := req.GetVolumeContentSource().GetSnapshot().GetSnapshotId()
+		if snapshotID != "" {
+			glog.Infof("Restoring volume %s from snapshot %s", volumeName, snapshotID)
+		}
 		res, err = client.CreateVolume(ctx, req)
+		if err != nil {
+			glog.Errorf("Failed to create volume %s, err: %v", volumeName, err.Error())
+			return
+		}
 		glog.Infof("Created volume response: %s\n", res)
 
 		// Create PeerpodVolume CRD object only when peerpod parameter is found in request
 		if peerpod != "" {
-			volumeID := res.GetVolume().VolumeId
+			volumeID := res.GetVolume().GetVolumeId()
 			normalizedVolumeID := utils.NormalizeVolumeID(volumeID)
-			_, _ = s.createPeerpodVolume(normalizedVolumeID, volumeName)
+			_, _ = s.createPeerpodVolume(normalizedVolumeID, volumeName, snapshotID, encryptionKey)
 		}
 	}); e != nil {
 		return nil, e
@@ -108,11 +137,16 @@ func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 func (s *ControllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (res *csi.DeleteVolumeResponse, err error) {
 	if e := s.redirect(ctx, req, func(ctx context.Context, client csi.ControllerClient) {
 		res, err = client.DeleteVolume(ctx, req)
-
 		volumeID := utils.NormalizeVolumeID(req.GetVolumeId())
-		_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Get(context.Background(), volumeID, metav1.GetOptions{})
 		if err != nil {
-			glog.Infof("Not found PeerpodVolume with volumeID: %v, err: %v", volumeID, err.Error())
+			// Keep the PeerpodVolume of a volume that isn't deleted, e.g. because the csi driver
+			// doesn't delete volumes with snapshots, so that the deletion can be retried
+			glog.Errorf("Failed to delete volume %v, err: %v", volumeID, err.Error())
+			return
+		}
+
+		if _, getErr := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Get(context.Background(), volumeID, metav1.GetOptions{}); getErr != nil {
+			glog.Infof("Not found PeerpodVolume with volumeID: %v, err: %v", volumeID, getErr.Error())
 		} else {
 			ppErr := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Delete(context.Background(), volumeID, metav1.DeleteOptions{})
 			if ppErr != nil {
@@ -147,11 +181,13 @@ func (s *ControllerService) ControllerPublishVolume(ctx context.Context, req *cs
 		}
 		glog.Info("PeerPod parameter found in ControllerPublishVolumeRequest. Creating a new PeerpodVolume object")
 
-		// Delete peerpod key from req parameters because csi driver may check parameters strictly.
+		// Delete peerpod keys from req parameters because csi driver may check parameters strictly.
+		encryptionKey := req.VolumeContext[PeerpodEncryptionKeyParamKey]
 		delete(req.VolumeContext, PeerpodParamKey)
+		delete(req.VolumeContext, PeerpodEncryptionKeyParamKey)
 
 		volumeName := peerpodVolumeNamePlaceholder
-		savedPeerpodvolume, err = s.createPeerpodVolume(volumeID, volumeName)
+		savedPeerpodvolume, err = s.createPeerpodVolume(volumeID, volumeName, "", encryptionKey)
 		if err != nil {
 			return nil, err
 		}
@@ -185,19 +221,12 @@ func (s *ControllerService) ControllerPublishVolume(ctx context.Context, req *cs
 	resJsonString := resBuf.String()
 	glog.Infof("ControllerPublishVolumeResponse JSON string: %s\n", resJsonString)
 
-	savedPeerpodvolume.Labels["nodeID"] = nodeID
-	savedPeerpodvolume.Spec.NodeID = nodeID
-	savedPeerpodvolume.Spec.WrapperControllerPublishVolumeReq = string(reqJsonString)
-	savedPeerpodvolume.Spec.WrapperControllerPublishVolumeRes = string(resJsonString)
-	savedPeerpodvolume, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Update(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
-	if err != nil {
-		glog.Errorf("Error happens while Update PeerpodVolume in ControllerPublishVolume, err: %v", err.Error())
-		return
-	}
-	savedPeerpodvolume.Status = v1alpha1.PeerpodVolumeStatus{
-		State: v1alpha1.ControllerPublishVolumeCached,
-	}
-	_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
+	_, err = UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, savedPeerpodvolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+		peerpodVolume.Labels["nodeID"] = nodeID
+		peerpodVolume.Spec.NodeID = nodeID
+		peerpodVolume.Spec.WrapperControllerPublishVolumeReq = string(reqJsonString)
+		peerpodVolume.Spec.WrapperControllerPublishVolumeRes = string(resJsonString)
+	}, v1alpha1.ControllerPublishVolumeCached)
 	if err != nil {
 		glog.Errorf("Error happens while Update PeerpodVolume status to ControllerPublishVolumeCached, err: %v", err.Error())
 	}
@@ -219,7 +248,11 @@ func (s *ControllerService) ControllerUnpublishVolume(ctx context.Context, req *
 		statusString := string(savedPeerpodvolume.Status.State)
 		if strings.Contains(statusString, "Applied") {
 			// volume is attached to peer pod vm if the status.state end with `Applied`
-			req.NodeId = savedPeerpodvolume.Spec.VMID
+			if nodeID, ok := gceNodeID(req.NodeId, savedPeerpodvolume.Spec.VMID); ok {
+				req.NodeId = nodeID
+			} else {
+				req.NodeId = savedPeerpodvolume.Spec.VMID
+			}
 			glog.Infof("The modified ControllerUnpublishVolumeRequest is :%v", req)
 			ctx := context.Background()
 			// TODO: error check
@@ -233,37 +266,29 @@ func (s *ControllerService) ControllerUnpublishVolume(ctx context.Context, req *
 			})
 		}
 
-		labels := map[string]string{
-			"volumeName": savedPeerpodvolume.Spec.VolumeName,
-		}
-		savedPeerpodvolume.Labels = labels
-		savedPeerpodvolume.Spec.NodeID = ""
-		savedPeerpodvolume.Spec.DevicePath = ""
-		savedPeerpodvolume.Spec.NodeName = ""
-		savedPeerpodvolume.Spec.PodName = ""
-		savedPeerpodvolume.Spec.PodNamespace = ""
-		savedPeerpodvolume.Spec.PodUid = ""
-		savedPeerpodvolume.Spec.StagingTargetPath = ""
-		savedPeerpodvolume.Spec.TargetPath = ""
-		savedPeerpodvolume.Spec.VMID = ""
-		savedPeerpodvolume.Spec.VMName = ""
-		savedPeerpodvolume.Spec.WrapperControllerPublishVolumeReq = ""
-		savedPeerpodvolume.Spec.WrapperControllerPublishVolumeRes = ""
-		savedPeerpodvolume.Spec.WrapperNodePublishVolumeReq = ""
-		savedPeerpodvolume.Spec.WrapperNodeStageVolumeReq = ""
-		savedPeerpodvolume.Spec.WrapperNodeUnpublishVolumeReq = ""
-		savedPeerpodvolume.Spec.WrapperNodeUnstageVolumeReq = ""
-		updatedSavedPeerpodvolume, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Update(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
-		if err != nil {
-			glog.Errorf("Error happens while clean PeerpodVolume specs, err: %v", err.Error())
-		}
-
-		updatedSavedPeerpodvolume.Status = v1alpha1.PeerpodVolumeStatus{
-			State: "",
-		}
-		_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), updatedSavedPeerpodvolume, metav1.UpdateOptions{})
-		if err != nil {
-			glog.Errorf("Error happens while Update PeerpodVolume status to ControllerUnpublishVolumeApplied, err: %v", err.Error())
+		_, updateErr := UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, savedPeerpodvolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+			peerpodVolume.Labels = map[string]string{
+				"volumeName": peerpodVolume.Spec.VolumeName,
+			}
+			peerpodVolume.Spec.NodeID = ""
+			peerpodVolume.Spec.DevicePath = ""
+			peerpodVolume.Spec.NodeName = ""
+			peerpodVolume.Spec.PodName = ""
+			peerpodVolume.Spec.PodNamespace = ""
+			peerpodVolume.Spec.PodUid = ""
+			peerpodVolume.Spec.StagingTargetPath = ""
+			peerpodVolume.Spec.TargetPath = ""
+			peerpodVolume.Spec.VMID = ""
+			peerpodVolume.Spec.VMName = ""
+			peerpodVolume.Spec.WrapperControllerPublishVolumeReq = ""
+			peerpodVolume.Spec.WrapperControllerPublishVolumeRes = ""
+			peerpodVolume.Spec.WrapperNodePublishVolumeReq = ""
+			peerpodVolume.Spec.WrapperNodeStageVolumeReq = ""
+			peerpodVolume.Spec.WrapperNodeUnpublishVolumeReq = ""
+			peerpodVolume.Spec.WrapperNodeUnstageVolumeReq = ""
+		}, "")
+		if updateErr != nil {
+			glog.Errorf("Error happens while clean PeerpodVolume specs and status, err: %v", updateErr.Error())
 		}
 
 		res = &csi.ControllerUnpublishVolumeResponse{}
@@ -314,7 +339,15 @@ func (s *ControllerService) ControllerGetCapabilities(ctx context.Context, req *
 
 func (s *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (res *csi.CreateSnapshotResponse, err error) {
 	if e := s.redirect(ctx, req, func(ctx context.Context, client csi.ControllerClient) {
+		volumeID := utils.NormalizeVolumeID(req.GetSourceVolumeId())
+		if _, getErr := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Get(context.Background(), volumeID, metav1.GetOptions{}); getErr == nil {
+			// The volume is snapshotted by the csi driver, even if it is attached to a peer pod VM
+			glog.Infof("Creating snapshot %s of peer pod volume %s", req.GetName(), volumeID)
+		}
 		res, err = client.CreateSnapshot(ctx, req)
+		if err != nil {
+			glog.Errorf("Failed to create snapshot %s of volume %s, err: %v", req.GetName(), volumeID, err.Error())
+		}
 	}); e != nil {
 		return nil, e
 	}
@@ -325,6 +358,9 @@ func (s *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateS
 func (s *ControllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (res *csi.DeleteSnapshotResponse, err error) {
 	if e := s.redirect(ctx, req, func(ctx context.Context, client csi.ControllerClient) {
 		res, err = client.DeleteSnapshot(ctx, req)
+		if err != nil {
+			glog.Errorf("Failed to delete snapshot %s, err: %v", req.GetSnapshotId(), err.Error())
+		}
 	}); e != nil {
 		return nil, e
 	}
@@ -381,7 +417,12 @@ func (s *ControllerService) SyncHandler(peerPodVolume *peerpodvolumeV1alpha1.Pee
 		if err := (&jsonpb.Unmarshaler{}).Unmarshal(bytes.NewReader([]byte(wrapperRequest)), &modifiedRequest); err != nil {
 			glog.Errorf("Failed to convert to ControllerPublishVolumeRequest, err: %v", err.Error())
 		} else {
-			modifiedRequest.NodeId = vsiID
+			if nodeID, ok := gceNodeID(modifiedRequest.NodeId, vsiID); ok {
+				// The GCE PD csi driver requires the nodeID to be the path of the instance
+				modifiedRequest.NodeId = nodeID
+			} else {
+				modifiedRequest.NodeId = vsiID
+			}
 			glog.Infof("The modified ControllerPublishVolumeRequest is :%v", modifiedRequest)
 			ctx := context.Background()
 			// TODO: error check
@@ -397,19 +438,12 @@ func (s *ControllerService) SyncHandler(peerPodVolume *peerpodvolumeV1alpha1.Pee
 					}
 					resJsonString := resBuf.String()
 					glog.Infof("ControllerPublishVolumeResponse for peer pod JSON string: %s\n", resJsonString)
-					peerPodVolume.Spec.WrapperControllerPublishVolumeRes = resJsonString
 					devicePath := response.PublishContext["device-path"]
 					glog.Infof("device-path for peer pod VM: %s\n", devicePath)
-					peerPodVolume.Spec.DevicePath = devicePath
-					updatedPeerPodVolume, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Update(context.Background(), peerPodVolume, metav1.UpdateOptions{})
-					if err != nil {
-						glog.Errorf("Error happens while Update PeerpodVolume with ControllerPublishVolumeResponse for peer pod, err: %v", err.Error())
-						return
-					}
-					updatedPeerPodVolume.Status = v1alpha1.PeerpodVolumeStatus{
-						State: v1alpha1.ControllerPublishVolumeApplied,
-					}
-					_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), updatedPeerPodVolume, metav1.UpdateOptions{})
+					_, err := UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, peerPodVolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+						peerpodVolume.Spec.WrapperControllerPublishVolumeRes = resJsonString
+						peerpodVolume.Spec.DevicePath = devicePath
+					}, v1alpha1.ControllerPublishVolumeApplied)
 					if err != nil {
 						glog.Errorf("Error happens while Update PeerpodVolume status to ControllerPublishVolumeApplied, err: %v", err.Error())
 					}
@@ -423,7 +457,9 @@ func (s *ControllerService) DeleteFunction(peerPodVolume *peerpodvolumeV1alpha1.
 	glog.Infof("deleteFunction from controllerService: %v ", peerPodVolume)
 }
 
-func (s *ControllerService) createPeerpodVolume(volumeID, volumeName string) (*v1alpha1.PeerpodVolume, error) {
+// createPeerpodVolume creates the PeerpodVolume of a volume. sourceSnapshotID is the snapshot that the volume is
+// restored from, and encryptionKeyResource is the KBS resource path of the key of an encrypted volume, if any
+func (s *ControllerService) createPeerpodVolume(volumeID, volumeName, sourceSnapshotID, encryptionKeyResource string) (*v1alpha1.PeerpodVolume, error) {
 	labels := map[string]string{
 		"volumeName": volumeName,
 	}
@@ -434,8 +470,10 @@ func (s *ControllerService) createPeerpodVolume(volumeID, volumeName string) (*v
 			Labels:    labels,
 		},
 		Spec: v1alpha1.PeerpodVolumeSpec{
-			VolumeID:   volumeID,
-			VolumeName: volumeName,
+			VolumeID:              volumeID,
+			VolumeName:            volumeName,
+			SourceSnapshotID:      sourceSnapshotID,
+			EncryptionKeyResource: encryptionKeyResource,
 		},
 	}
 	peerpodVolume, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Create(context.Background(), newPeerpodvolume, metav1.CreateOptions{})
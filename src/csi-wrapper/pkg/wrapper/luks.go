@@ -0,0 +1,222 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wrapper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+)
+
+const (
+	// Parameter key for the KBS resource path of the key of an encrypted Peer Pod volume, e.g. default/csi/volume-key.
+	// Peer Pod volumes with the parameter are formatted with LUKS in the peer pod VM, with the key released by KBS
+	PeerpodEncryptionKeyParamKey = "peerpodEncryptionKey"
+
+	// The confidential data hub serves the resources released by KBS after attestation in the pod network namespace
+	cdhResourceURL = "http://127.0.0.1:8006/cdh/resource/"
+	cdhTimeout     = 60 * time.Second
+
+	defaultLUKSFsType = "ext4"
+
+	// Exit codes of cryptsetup isLuks for a device that is not LUKS, and of blkid -p for a device without a signature
+	cryptsetupNotLUKSExitCode = 1
+	blkidNoSignatureExitCode  = 2
+)
+
+// getKBSResource gets a resource released by KBS from the confidential data hub of the peer pod VM
+func getKBSResource(resourcePath string) ([]byte, error) {
+	client := http.Client{Timeout: cdhTimeout}
+	resp, err := client.Get(cdhResourceURL + strings.TrimPrefix(resourcePath, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("getting KBS resource %s: %w", resourcePath, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading KBS resource %s: %w", resourcePath, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting KBS resource %s: status code %d: %s", resourcePath, resp.StatusCode, string(data))
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("KBS resource %s is empty", resourcePath)
+	}
+	return data, nil
+}
+
+// blockVolumeCapability returns the block volume capability with the access mode of a volume capability.
+// The csi-driver publishes the device of an encrypted volume, which is opened with LUKS by the podvm wrapper
+func blockVolumeCapability(capability *csi.VolumeCapability) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: capability.GetAccessMode(),
+	}
+}
+
+// luksDevicePath returns the path where the csi-driver publishes the device of an encrypted volume
+func luksDevicePath(targetPath string) string {
+	return filepath.Join(filepath.Dir(targetPath), "luks-device")
+}
+
+// luksMapperName returns the name of the device mapper of an encrypted volume. Volume IDs may contain slashes,
+// e.g. the ones of GCE PD, and be longer than device mapper names, so the name is derived from a hash of the ID
+func luksMapperName(volumeID string) string {
+	sum := sha256.Sum256([]byte(volumeID))
+	return "peerpod-" + hex.EncodeToString(sum[:16])
+}
+
+// runCommand is a variable so that tests can replace the commands
+var runCommand = func(stdin []byte, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, string(out))
+	}
+	return string(out), nil
+}
+
+// exitCode returns the exit code of a command that failed with err, or -1 if it didn't exit
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// isLUKS returns whether a device is formatted with LUKS
+func isLUKS(devicePath string) (bool, error) {
+	_, err := runCommand(nil, "cryptsetup", "isLuks", devicePath)
+	if err == nil {
+		return true, nil
+	}
+	if exitCode(err) == cryptsetupNotLUKSExitCode {
+		return false, nil
+	}
+	return false, err
+}
+
+// deviceType returns the type of the signature of a device, e.g. ext4 or crypto_LUKS, or an empty string if the device
+// has no signature. Only the exit code of blkid for no signature means that a device is empty, since a device that
+// blkid fails to probe may have data
+func deviceType(devicePath string) (string, error) {
+	out, err := runCommand(nil, "blkid", "-p", "-o", "value", "-s", "TYPE", devicePath)
+	if err != nil {
+		if exitCode(err) == blkidNoSignatureExitCode {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// needsLUKSFormat returns whether the device of an encrypted volume has to be formatted with LUKS.
+// Only a device without a signature is formatted, so that the data of a device that isn't LUKS is never overwritten
+func needsLUKSFormat(volumeID, devicePath string) (bool, error) {
+	luks, err := isLUKS(devicePath)
+	if err != nil || luks {
+		return false, err
+	}
+	signature, err := deviceType(devicePath)
+	if err != nil {
+		return false, err
+	}
+	if signature != "" {
+		return false, fmt.Errorf("device of volume %s has a %s signature instead of LUKS", volumeID, signature)
+	}
+	return true, nil
+}
+
+// publishLUKSVolume opens the device of an encrypted volume with LUKS, and mounts it to the target path.
+// The device is formatted with LUKS and the filesystem of the volume capability the first time it is published
+func publishLUKSVolume(volumeID, devicePath, targetPath string, capability *csi.VolumeCapability, key []byte) error {
+	mapperName := luksMapperName(volumeID)
+	mapperPath := filepath.Join("/dev/mapper", mapperName)
+
+	if _, err := os.Stat(mapperPath); os.IsNotExist(err) {
+		format, err := needsLUKSFormat(volumeID, devicePath)
+		if err != nil {
+			return err
+		}
+		if format {
+			// A new volume has no data, so it is formatted instead of failing
+			glog.Infof("Formatting the device %s of volume %s with LUKS", devicePath, volumeID)
+			if _, err := runCommand(key, "cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2", "--key-file", "-", devicePath); err != nil {
+				return err
+			}
+		}
+		glog.Infof("Opening the device %s of volume %s with LUKS", devicePath, volumeID)
+		if _, err := runCommand(key, "cryptsetup", "open", "--type", "luks2", "--key-file", "-", devicePath, mapperName); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	mount := capability.GetMount()
+	fsType := mount.GetFsType()
+	if fsType == "" {
+		fsType = defaultLUKSFsType
+	}
+	mapperType, err := deviceType(mapperPath)
+	if err != nil {
+		return err
+	}
+	if mapperType == "" {
+		glog.Infof("Creating a %s filesystem on the LUKS device of volume %s", fsType, volumeID)
+		if _, err := runCommand(nil, "mkfs."+fsType, mapperPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(targetPath, os.FileMode(0755)); err != nil {
+		return err
+	}
+	if _, err := runCommand(nil, "mountpoint", "-q", targetPath); err == nil {
+		glog.Infof("The LUKS device of volume %s is already mounted to %s", volumeID, targetPath)
+		return nil
+	}
+	args := []string{"-t", fsType}
+	if flags := mount.GetMountFlags(); len(flags) > 0 {
+		args = append(args, "-o", strings.Join(flags, ","))
+	}
+	args = append(args, mapperPath, targetPath)
+	_, err = runCommand(nil, "mount", args...)
+	return err
+}
+
+// unpublishLUKSVolume unmounts an encrypted volume from the target path and closes its LUKS device
+func unpublishLUKSVolume(volumeID, targetPath string) error {
+	if _, err := runCommand(nil, "mountpoint", "-q", targetPath); err == nil {
+		if _, err := runCommand(nil, "umount", targetPath); err != nil {
+			return err
+		}
+	}
+
+	mapperName := luksMapperName(volumeID)
+	if _, err := os.Stat(filepath.Join("/dev/mapper", mapperName)); err == nil {
+		glog.Infof("Closing the LUKS device of volume %s", volumeID)
+		if _, err := runCommand(nil, "cryptsetup", "close", mapperName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
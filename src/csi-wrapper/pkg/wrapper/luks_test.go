@@ -0,0 +1,114 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wrapper
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLUKSMapperName(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	ids := []string{
+		"vol-0123456789abcdef0",
+		"projects/my-project/zones/us-central1-a/disks/pvc-e9d79b06-fd06-487f-ac93-ea6424819a7d",
+		strings.Repeat("a", 200),
+	}
+	names := make(map[string]bool)
+	for _, id := range ids {
+		name := luksMapperName(id)
+		if !valid.MatchString(name) {
+			t.Errorf("luksMapperName(%q) = %q, not a valid device mapper name", id, name)
+		}
+		if len(name) != len(luksMapperName(ids[0])) {
+			t.Errorf("luksMapperName(%q) = %q, expected a fixed length", id, name)
+		}
+		if name != luksMapperName(id) {
+			t.Errorf("luksMapperName(%q) isn't stable", id)
+		}
+		names[name] = true
+	}
+	if len(names) != len(ids) {
+		t.Errorf("luksMapperName returned %d names for %d volume IDs", len(names), len(ids))
+	}
+}
+
+// exitWith returns the error of a command that exited with an exit code
+func exitWith(code int) error {
+	return exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+}
+
+func TestNeedsLUKSFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		isLuksErr  error
+		blkidOut   string
+		blkidErr   error
+		wantFormat bool
+		wantErr    bool
+		wantBlkid  bool
+	}{
+		{
+			name:       "LUKS device",
+			wantFormat: false,
+		},
+		{
+			name:       "empty device",
+			isLuksErr:  exitWith(cryptsetupNotLUKSExitCode),
+			blkidErr:   exitWith(blkidNoSignatureExitCode),
+			wantFormat: true,
+			wantBlkid:  true,
+		},
+		{
+			name:      "device with a filesystem",
+			isLuksErr: exitWith(cryptsetupNotLUKSExitCode),
+			blkidOut:  "ext4\n",
+			wantErr:   true,
+			wantBlkid: true,
+		},
+		{
+			name:      "blkid fails to probe",
+			isLuksErr: exitWith(cryptsetupNotLUKSExitCode),
+			blkidErr:  exitWith(4),
+			wantErr:   true,
+			wantBlkid: true,
+		},
+		{
+			name:      "cryptsetup fails",
+			isLuksErr: exitWith(4),
+			wantErr:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			blkid := false
+			defer func(orig func([]byte, string, ...string) (string, error)) { runCommand = orig }(runCommand)
+			runCommand = func(stdin []byte, name string, args ...string) (string, error) {
+				switch name {
+				case "cryptsetup":
+					return "", tc.isLuksErr
+				case "blkid":
+					blkid = true
+					return tc.blkidOut, tc.blkidErr
+				}
+				t.Fatalf("unexpected command %s %v", name, args)
+				return "", nil
+			}
+
+			format, err := needsLUKSFormat("vol-1", "/dev/sdb")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if format != tc.wantFormat {
+				t.Errorf("expected format %v, got %v", tc.wantFormat, format)
+			}
+			if blkid != tc.wantBlkid {
+				t.Errorf("expected blkid to be run %v, got %v", tc.wantBlkid, blkid)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	podvminfo "github.com/confidential-containers/cloud-api-adaptor/src/cloud-api-adaptor/proto/podvminfo"
 	"github.com/confidential-containers/cloud-api-adaptor/src/csi-wrapper/pkg/apis/peerpodvolume/v1alpha1"
@@ -20,18 +21,26 @@ import (
 	"github.com/containerd/ttrpc"
 	volume "github.com/kata-containers/kata-containers/src/runtime/pkg/direct-volume"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/jsonpb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	DefaultKubeletLibDir  = "/var/lib/kubelet"
 	DefaultKubeletDataDir = "/var/data/kubelet"
 	DefaultMountInfo      = "{\"Device\": \"/dev/zero\", \"fstype\": \"ext4\"}"
+
+	// nodeExpandVolumeInterval and nodeExpandVolumeTimeout are how often and how long NodeExpandVolume waits
+	// for the expansion of a volume in the peer pod VM. The timeout is shorter than the one of kubelet
+	nodeExpandVolumeInterval = 2 * time.Second
+	nodeExpandVolumeTimeout  = 90 * time.Second
 )
 
 type NodeService struct {
@@ -122,33 +131,24 @@ func (s *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		}
 		nodePublishVolumeRequest := reqBuf.String()
 		glog.Infof("NodePublishVolumeRequest JSON string: %s\n", nodePublishVolumeRequest)
-		savedPeerpodvolume.Spec.TargetPath = targetPath
 		podUid, volumeName := s.getPodUIDandVolumeName(targetPath)
-		savedPeerpodvolume.Labels["podUid"] = podUid
-		savedPeerpodvolume.Spec.PodUid = podUid
 		savedVolumeName := savedPeerpodvolume.Spec.VolumeName
 		if volumeName != savedVolumeName && savedVolumeName != peerpodVolumeNamePlaceholder {
 			glog.Error("The volume name from target path doesn't match with the CR")
 			return nil, errors.New("the volume name from target path doesn't match with the CR")
 		}
-		if savedVolumeName == peerpodVolumeNamePlaceholder {
-			glog.Info("Detected a placeholder volume name in the CR. Updating the CR with the volume name from the target path")
-			savedPeerpodvolume.Labels["volumeName"] = volumeName
-			savedPeerpodvolume.Spec.VolumeName = volumeName
-		}
 
-		savedPeerpodvolume.Spec.WrapperNodePublishVolumeReq = nodePublishVolumeRequest
-		_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Update(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
-		if err != nil {
-			glog.Errorf("Error happens while Update PeerpodVolume, err: %v", err.Error())
-			return
-		}
-		// TODO: error check
-		savedPeerpodvolume, _ = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Get(context.Background(), volumeID, metav1.GetOptions{})
-		savedPeerpodvolume.Status = v1alpha1.PeerpodVolumeStatus{
-			State: v1alpha1.NodePublishVolumeCached,
-		}
-		_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
+		_, err = UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, savedPeerpodvolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+			peerpodVolume.Spec.TargetPath = targetPath
+			peerpodVolume.Labels["podUid"] = podUid
+			peerpodVolume.Spec.PodUid = podUid
+			if peerpodVolume.Spec.VolumeName == peerpodVolumeNamePlaceholder {
+				glog.Info("Detected a placeholder volume name in the CR. Updating the CR with the volume name from the target path")
+				peerpodVolume.Labels["volumeName"] = volumeName
+				peerpodVolume.Spec.VolumeName = volumeName
+			}
+			peerpodVolume.Spec.WrapperNodePublishVolumeReq = nodePublishVolumeRequest
+		}, v1alpha1.NodePublishVolumeCached)
 		if err != nil {
 			glog.Errorf("Error happens while Update PeerpodVolume status to NodePublishVolumeCached, err: %v", err.Error())
 			return
@@ -188,19 +188,12 @@ func (s *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		nodeUnpublishVolumeRequest := reqBuf.String()
 		glog.Infof("NodeUnpublishVolumeRequest JSON string: %s\n", nodeUnpublishVolumeRequest)
 
-		savedPeerpodvolume.Spec.WrapperNodeUnpublishVolumeReq = nodeUnpublishVolumeRequest
-		updatedPeerpodvolume, upErr := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Update(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
-		if upErr != nil {
-			glog.Errorf("Error happens while Update PeerpodVolume, err: %v", upErr.Error())
-			return nil, upErr
-		}
-		updatedPeerpodvolume.Status = v1alpha1.PeerpodVolumeStatus{
-			State: v1alpha1.NodeUnpublishVolumeCached,
-		}
-		_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
+		_, err = UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, savedPeerpodvolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+			peerpodVolume.Spec.WrapperNodeUnpublishVolumeReq = nodeUnpublishVolumeRequest
+		}, v1alpha1.NodeUnpublishVolumeCached)
 		if err != nil {
 			glog.Errorf("Error happens while Update PeerpodVolume status to NodeUnpublishVolumeCached, err: %v", err.Error())
-			return
+			return nil, err
 		}
 
 		res = &csi.NodeUnpublishVolumeResponse{}
@@ -233,19 +226,10 @@ func (s *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		}
 		nodeStageVolumeRequest := reqBuf.String()
 		glog.Infof("NodeStageVolumeRequest JSON string: %s\n", nodeStageVolumeRequest)
-		savedPeerpodvolume.Spec.StagingTargetPath = stagingTargetPath
-		savedPeerpodvolume.Spec.WrapperNodeStageVolumeReq = nodeStageVolumeRequest
-		_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Update(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
-		if err != nil {
-			glog.Errorf("Error happens while Update PeerpodVolume, err: %v", err.Error())
-			return
-		}
-		// TODO: error check
-		savedPeerpodvolume, _ = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Get(context.Background(), volumeID, metav1.GetOptions{})
-		savedPeerpodvolume.Status = v1alpha1.PeerpodVolumeStatus{
-			State: v1alpha1.NodeStageVolumeCached,
-		}
-		_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
+		_, err = UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, savedPeerpodvolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+			peerpodVolume.Spec.StagingTargetPath = stagingTargetPath
+			peerpodVolume.Spec.WrapperNodeStageVolumeReq = nodeStageVolumeRequest
+		}, v1alpha1.NodeStageVolumeCached)
 		if err != nil {
 			glog.Errorf("Error happens while Update PeerpodVolume status to NodeStageVolumeCached, err: %v", err.Error())
 			return
@@ -283,19 +267,12 @@ func (s *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		nodeUnstageVolumeRequest := reqBuf.String()
 		glog.Infof("NodeUnstageVolumeRequest JSON string: %s\n", nodeUnstageVolumeRequest)
 
-		savedPeerpodvolume.Spec.WrapperNodeUnstageVolumeReq = nodeUnstageVolumeRequest
-		updatedPeerpodvolume, upErr := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Update(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
-		if upErr != nil {
-			glog.Errorf("Error happens while Update PeerpodVolume, err: %v", upErr.Error())
-			return nil, upErr
-		}
-		updatedPeerpodvolume.Status = v1alpha1.PeerpodVolumeStatus{
-			State: v1alpha1.NodeUnstageVolumeCached,
-		}
-		_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), savedPeerpodvolume, metav1.UpdateOptions{})
+		_, err = UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, savedPeerpodvolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+			peerpodVolume.Spec.WrapperNodeUnstageVolumeReq = nodeUnstageVolumeRequest
+		}, v1alpha1.NodeUnstageVolumeCached)
 		if err != nil {
 			glog.Errorf("Error happens while Update PeerpodVolume status to NodeUnstageVolumeCached, err: %v", err.Error())
-			return
+			return nil, err
 		}
 
 		res = &csi.NodeUnstageVolumeResponse{}
@@ -325,25 +302,104 @@ func (s *NodeService) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetC
 }
 
 func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (res *csi.NodeGetVolumeStatsResponse, err error) {
-	if e := s.redirect(ctx, req, func(ctx context.Context, client csi.NodeClient) {
-		res, err = client.NodeGetVolumeStats(ctx, req)
-	}); e != nil {
-		return nil, e
+	volumeID := utils.NormalizeVolumeID(req.GetVolumeId())
+	savedPeerpodvolume, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Get(context.Background(), volumeID, metav1.GetOptions{})
+	if err != nil {
+		if e := s.redirect(ctx, req, func(ctx context.Context, client csi.NodeClient) {
+			res, err = client.NodeGetVolumeStats(ctx, req)
+		}); e != nil {
+			return nil, e
+		}
+		return
 	}
 
-	return
+	// The volume is only mounted in the peer pod VM, where the podvm wrapper reports its stats
+	volumeStats := savedPeerpodvolume.Status.VolumeStats
+	if volumeStats == "" {
+		return nil, status.Errorf(codes.Unavailable, "no stats of volume %s are reported by the peer pod VM yet", volumeID)
+	}
+	res = &csi.NodeGetVolumeStatsResponse{}
+	if err := (&jsonpb.Unmarshaler{}).Unmarshal(bytes.NewReader([]byte(volumeStats)), res); err != nil {
+		glog.Errorf("Failed to convert to NodeGetVolumeStatsResponse, err: %v", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return res, nil
 }
 
 func (s *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (res *csi.NodeExpandVolumeResponse, err error) {
-	if e := s.redirect(ctx, req, func(ctx context.Context, client csi.NodeClient) {
-		res, err = client.NodeExpandVolume(ctx, req)
-	}); e != nil {
-		return nil, e
+	volumeID := utils.NormalizeVolumeID(req.GetVolumeId())
+	savedPeerpodvolume, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Get(context.Background(), volumeID, metav1.GetOptions{})
+	if err != nil {
+		glog.Infof("Not found PeerpodVolume with volumeID: %v, err: %v", volumeID, err.Error())
+		if e := s.redirect(ctx, req, func(ctx context.Context, client csi.NodeClient) {
+			res, err = client.NodeExpandVolume(ctx, req)
+		}); e != nil {
+			return nil, e
+		}
+		return
 	}
 
+	// The filesystem of the volume is in the peer pod VM, so the NodeExpandVolumeRequest is cached and
+	// reproduced by the podvm wrapper, like NodeStageVolume and NodePublishVolume
+	switch savedPeerpodvolume.Status.State {
+	case v1alpha1.NodePublishVolumeApplied, v1alpha1.NodeExpandVolumeApplied, v1alpha1.NodeExpandVolumeFailed:
+		var reqBuf bytes.Buffer
+		if err := (&jsonpb.Marshaler{}).Marshal(&reqBuf, req); err != nil {
+			glog.Error(err, "Error happens while Marshal NodeExpandVolumeRequest")
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		nodeExpandVolumeRequest := reqBuf.String()
+		glog.Infof("NodeExpandVolumeRequest JSON string: %s\n", nodeExpandVolumeRequest)
+
+		_, err = UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, savedPeerpodvolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+			peerpodVolume.Spec.WrapperNodeExpandVolumeReq = nodeExpandVolumeRequest
+		}, v1alpha1.NodeExpandVolumeCached)
+		if err != nil {
+			glog.Errorf("Error happens while Update PeerpodVolume status to NodeExpandVolumeCached, err: %v", err.Error())
+			return
+		}
+	case v1alpha1.NodeExpandVolumeCached:
+		// A previous NodeExpandVolumeRequest is still being reproduced in the peer pod VM
+		glog.Infof("Waiting for the expansion of volume %v in the peer pod VM", volumeID)
+	default:
+		// Kubelet retries the expansion after the volume is published in the peer pod VM
+		glog.Infof("Volume %v isn't published in the peer pod VM yet, state: %v", volumeID, savedPeerpodvolume.Status.State)
+		return nil, status.Errorf(codes.Unavailable, "volume %s isn't published in the peer pod VM yet", volumeID)
+	}
+
+	if err := s.waitForNodeExpandVolume(ctx, volumeID); err != nil {
+		return nil, err
+	}
+
+	res = &csi.NodeExpandVolumeResponse{
+		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+	}
 	return
 }
 
+// waitForNodeExpandVolume waits until the podvm wrapper has reproduced the NodeExpandVolumeRequest of a volume
+func (s *NodeService) waitForNodeExpandVolume(ctx context.Context, volumeID string) error {
+	var state v1alpha1.PeerpodVolumeState
+	err := wait.PollImmediateWithContext(ctx, nodeExpandVolumeInterval, nodeExpandVolumeTimeout, func(ctx context.Context) (bool, error) {
+		peerPodVolume, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Get(ctx, volumeID, metav1.GetOptions{})
+		if err != nil {
+			glog.Warningf("Failed to get PeerpodVolume with volumeID: %v, err: %v", volumeID, err.Error())
+			return false, nil
+		}
+		state = peerPodVolume.Status.State
+		return state == v1alpha1.NodeExpandVolumeApplied || state == v1alpha1.NodeExpandVolumeFailed, nil
+	})
+	if err != nil {
+		glog.Errorf("Timed out waiting for the expansion of volume %v in the peer pod VM, state: %v", volumeID, state)
+		return status.Errorf(codes.DeadlineExceeded, "timed out waiting for the expansion of volume %s in the peer pod VM", volumeID)
+	}
+	if state == v1alpha1.NodeExpandVolumeFailed {
+		return status.Errorf(codes.Internal, "failed to expand volume %s in the peer pod VM", volumeID)
+	}
+	glog.Infof("Volume %v is expanded in the peer pod VM", volumeID)
+	return nil
+}
+
 func (s *NodeService) SyncHandler(peerPodVolume *peerpodvolumeV1alpha1.PeerpodVolume) {
 	if peerPodVolume.Spec.NodeName != os.Getenv("POD_NODE_NAME") {
 		// Only handle the PeerpodVolume CRD which is assigned to the same compute node
@@ -374,20 +430,15 @@ func (s *NodeService) SyncHandler(peerPodVolume *peerpodvolumeV1alpha1.PeerpodVo
 		} else {
 			vmID := res.VMID
 			glog.Infof("Got the vm instance id from cloud-api-adaptor podVMInfoService vmID:%v", vmID)
-			peerPodVolume.Spec.VMID = vmID
-			peerPodVolume.Labels["vmID"] = utils.NormalizeVMID(vmID)
-			updatedPeerPodVolume, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Update(context.Background(), peerPodVolume, metav1.UpdateOptions{})
-			if err != nil {
-				glog.Errorf("Error happens while Update vmID to PeerpodVolume, err: %v", err.Error())
-			}
-			updatedPeerPodVolume.Status = v1alpha1.PeerpodVolumeStatus{
-				State: v1alpha1.PeerPodVSIIDReady,
-			}
-			_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), updatedPeerPodVolume, metav1.UpdateOptions{})
+			_, err := UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, peerPodVolume.Name, func(peerpodVolume *v1alpha1.PeerpodVolume) {
+				peerpodVolume.Spec.VMID = vmID
+				peerpodVolume.Labels["vmID"] = utils.NormalizeVMID(vmID)
+			}, v1alpha1.PeerPodVSIIDReady)
 			if err != nil {
 				glog.Errorf("Error happens while Update PeerpodVolume status to PeerPodVSIIDReady, err: %v", err.Error())
+			} else {
+				glog.Infof("The PeerpodVolume status updated to PeerPodVSIIDReady")
 			}
-			glog.Infof("The PeerpodVolume status updated to PeerPodVSIIDReady")
 		}
 	}
 }
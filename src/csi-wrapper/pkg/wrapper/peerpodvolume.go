@@ -0,0 +1,48 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wrapper
+
+import (
+	"context"
+
+	"github.com/confidential-containers/cloud-api-adaptor/src/csi-wrapper/pkg/apis/peerpodvolume/v1alpha1"
+	peerpodvolume "github.com/confidential-containers/cloud-api-adaptor/src/csi-wrapper/pkg/generated/peerpodvolume/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// UpdatePeerpodVolume applies update to the spec of the latest PeerpodVolume with a name, unless update is nil, and
+// sets its state. The PeerpodVolume is read again and the updates are retried on conflicts, since the podvm wrapper
+// patches the volume stats of published volumes concurrently with the state updates of the wrappers
+func UpdatePeerpodVolume(ctx context.Context, client peerpodvolume.Interface, namespace, name string, update func(*v1alpha1.PeerpodVolume), state v1alpha1.PeerpodVolumeState) (*v1alpha1.PeerpodVolume, error) {
+	peerpodVolumes := client.ConfidentialcontainersV1alpha1().PeerpodVolumes(namespace)
+
+	var updated *v1alpha1.PeerpodVolume
+	if update != nil {
+		if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			latest, err := peerpodVolumes.Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			update(latest)
+			updated, err = peerpodVolumes.Update(ctx, latest, metav1.UpdateOptions{})
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := peerpodVolumes.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		latest.Status = v1alpha1.PeerpodVolumeStatus{
+			State: state,
+		}
+		updated, err = peerpodVolumes.UpdateStatus(ctx, latest, metav1.UpdateOptions{})
+		return err
+	})
+	return updated, err
+}
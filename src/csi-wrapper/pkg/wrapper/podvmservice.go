@@ -6,8 +6,10 @@ package wrapper
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/csi-wrapper/pkg/apis/peerpodvolume/v1alpha1"
 	peerpodvolumeV1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/csi-wrapper/pkg/apis/peerpodvolume/v1alpha1"
@@ -15,9 +17,13 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/jsonpb"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 type PodVMNodeService struct {
@@ -151,6 +157,10 @@ func (s *PodVMNodeService) ReproduceNodeStageVolume(peerPodVolume *peerpodvolume
 		}
 		publishContext["device-path"] = peerPodVolume.Spec.DevicePath
 		modifiedRequest.PublishContext = publishContext
+		if peerPodVolume.Spec.EncryptionKeyResource != "" {
+			// The device of an encrypted volume is staged and published by the csi-driver as a raw block volume
+			modifiedRequest.VolumeCapability = blockVolumeCapability(modifiedRequest.VolumeCapability)
+		}
 
 		glog.Infof("The modified NodeStageVolumeRequest is :%v", modifiedRequest)
 		ctx := context.Background()
@@ -165,10 +175,7 @@ func (s *PodVMNodeService) ReproduceNodeStageVolume(peerPodVolume *peerpodvolume
 				if err != nil {
 					glog.Errorf("Failed to reproduce NodeStageVolume with modified NodeStageVolumeRequest, err: %v", err.Error())
 				} else {
-					peerPodVolume.Status = v1alpha1.PeerpodVolumeStatus{
-						State: v1alpha1.NodeStageVolumeApplied,
-					}
-					_, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), peerPodVolume, metav1.UpdateOptions{})
+					_, err := UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, peerPodVolume.Name, nil, v1alpha1.NodeStageVolumeApplied)
 					if err != nil {
 						glog.Errorf("Error happens while Update PeerpodVolume status to NodeStageVolumeApplied, err: %v", err.Error())
 					} else {
@@ -198,6 +205,14 @@ func (s *PodVMNodeService) ReproduceNodePublishVolume(peerPodVolume *peerpodvolu
 		glog.Errorf("Failed to convert to NodePublishVolumeRequest, err: %v", err.Error())
 	} else {
 		glog.Infof("The NodePublishVolumeRequest is :%v", nodePublishVolumeRequest)
+		encrypted := peerPodVolume.Spec.EncryptionKeyResource != ""
+		targetPath := nodePublishVolumeRequest.TargetPath
+		volumeCapability := nodePublishVolumeRequest.VolumeCapability
+		if encrypted {
+			// The csi-driver publishes the device of an encrypted volume, which is opened with LUKS and mounted to the target path
+			nodePublishVolumeRequest.TargetPath = luksDevicePath(targetPath)
+			nodePublishVolumeRequest.VolumeCapability = blockVolumeCapability(volumeCapability)
+		}
 		ctx := context.Background()
 		count := 0
 		reproduced := false
@@ -207,13 +222,13 @@ func (s *PodVMNodeService) ReproduceNodePublishVolume(peerPodVolume *peerpodvolu
 			_ = s.redirect(ctx, nodePublishVolumeRequest, func(ctx context.Context, client csi.NodeClient) {
 				response, err := client.NodePublishVolume(ctx, &nodePublishVolumeRequest)
 				glog.Infof("The NodePublishVolumeResponse for peer pod is :%v", response)
+				if err == nil && encrypted {
+					err = s.publishEncryptedVolume(peerPodVolume, nodePublishVolumeRequest.TargetPath, targetPath, volumeCapability)
+				}
 				if err != nil {
 					glog.Errorf("Failed to reproduce NodePublishVolume with the NodePublishVolumeRequest, err: %v", err.Error())
 				} else {
-					peerPodVolume.Status = v1alpha1.PeerpodVolumeStatus{
-						State: v1alpha1.NodePublishVolumeApplied,
-					}
-					_, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), peerPodVolume, metav1.UpdateOptions{})
+					_, err := UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, peerPodVolume.Name, nil, v1alpha1.NodePublishVolumeApplied)
 					if err != nil {
 						glog.Errorf("Error happens while Update PeerpodVolume status to NodePublishVolumeApplied, err: %v", err.Error())
 					} else {
@@ -234,6 +249,16 @@ func (s *PodVMNodeService) ReproduceNodePublishVolume(peerPodVolume *peerpodvolu
 	}
 }
 
+// publishEncryptedVolume opens the device of an encrypted volume published by the csi-driver with LUKS, using the key
+// released by KBS after attestation, and mounts it to the target path of the volume
+func (s *PodVMNodeService) publishEncryptedVolume(peerPodVolume *peerpodvolumeV1alpha1.PeerpodVolume, devicePath, targetPath string, capability *csi.VolumeCapability) error {
+	key, err := getKBSResource(peerPodVolume.Spec.EncryptionKeyResource)
+	if err != nil {
+		return err
+	}
+	return publishLUKSVolume(peerPodVolume.Spec.VolumeID, devicePath, targetPath, capability, key)
+}
+
 func (s *PodVMNodeService) ReproduceNodeUnpublishVolume(peerPodVolume *peerpodvolumeV1alpha1.PeerpodVolume) {
 	glog.Infof("Reproducing nodeUnPublishVolumeRequest for peer pod")
 	wrapperRequest := peerPodVolume.Spec.WrapperNodeUnpublishVolumeReq
@@ -242,6 +267,14 @@ func (s *PodVMNodeService) ReproduceNodeUnpublishVolume(peerPodVolume *peerpodvo
 		glog.Errorf("Failed to convert to NodeUnpublishVolumeRequest, err: %v", err.Error())
 	} else {
 		glog.Infof("The NodeUnpublishVolumeRequest is :%v", nodeUnpublishVolumeRequest)
+		if peerPodVolume.Spec.EncryptionKeyResource != "" {
+			// The LUKS device of an encrypted volume is closed before the csi-driver unpublishes its device
+			if err := unpublishLUKSVolume(peerPodVolume.Spec.VolumeID, nodeUnpublishVolumeRequest.TargetPath); err != nil {
+				glog.Errorf("Failed to unpublish the encrypted volume %v, err: %v", peerPodVolume.Spec.VolumeID, err.Error())
+				return
+			}
+			nodeUnpublishVolumeRequest.TargetPath = luksDevicePath(nodeUnpublishVolumeRequest.TargetPath)
+		}
 		ctx := context.Background()
 		// TODO: error check
 		_ = s.redirect(ctx, nodeUnpublishVolumeRequest, func(ctx context.Context, client csi.NodeClient) {
@@ -249,10 +282,7 @@ func (s *PodVMNodeService) ReproduceNodeUnpublishVolume(peerPodVolume *peerpodvo
 			if err != nil {
 				glog.Errorf("Failed to reproduce NodeUnpublishVolume with the NodeUnpublishVolumeRequest, err: %v", err.Error())
 			} else {
-				peerPodVolume.Status = v1alpha1.PeerpodVolumeStatus{
-					State: v1alpha1.NodeUnpublishVolumeApplied,
-				}
-				_, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), peerPodVolume, metav1.UpdateOptions{})
+				_, err := UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, peerPodVolume.Name, nil, v1alpha1.NodeUnpublishVolumeApplied)
 				if err != nil {
 					glog.Errorf("Error happens while Update PeerpodVolume status to NodeUnpublishVolumeApplied, err: %v", err.Error())
 				}
@@ -277,10 +307,7 @@ func (s *PodVMNodeService) ReproduceNodeUnstageVolume(peerPodVolume *peerpodvolu
 			if err != nil {
 				glog.Errorf("Failed to reproduce NodeUnstageVolume with the NodeUnstageVolumeRequest, err: %v", err.Error())
 			} else {
-				peerPodVolume.Status = v1alpha1.PeerpodVolumeStatus{
-					State: v1alpha1.NodeUnstageVolumeApplied,
-				}
-				_, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).UpdateStatus(context.Background(), peerPodVolume, metav1.UpdateOptions{})
+				_, err := UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, peerPodVolume.Name, nil, v1alpha1.NodeUnstageVolumeApplied)
 				if err != nil {
 					glog.Errorf("Error happens while Update PeerpodVolume status to NodeUnstageVolumeApplied, err: %v", err.Error())
 				}
@@ -290,6 +317,145 @@ func (s *PodVMNodeService) ReproduceNodeUnstageVolume(peerPodVolume *peerpodvolu
 	}
 }
 
+func (s *PodVMNodeService) ReproduceNodeExpandVolume(peerPodVolume *peerpodvolumeV1alpha1.PeerpodVolume) {
+	glog.Infof("Reproducing NodeExpandVolumeRequest for peer pod")
+	wrapperRequest := peerPodVolume.Spec.WrapperNodeExpandVolumeReq
+	var nodeExpandVolumeRequest csi.NodeExpandVolumeRequest
+	state := v1alpha1.NodeExpandVolumeFailed
+	if peerPodVolume.Spec.EncryptionKeyResource != "" {
+		glog.Errorf("Expanding the encrypted volume %v isn't supported", peerPodVolume.Spec.VolumeID)
+	} else if err := (&jsonpb.Unmarshaler{}).Unmarshal(bytes.NewReader([]byte(wrapperRequest)), &nodeExpandVolumeRequest); err != nil {
+		glog.Errorf("Failed to convert to NodeExpandVolumeRequest, err: %v", err.Error())
+	} else {
+		// The volume path and staging target path are the same in the peer pod VM, where the
+		// NodeStageVolumeRequest and NodePublishVolumeRequest are reproduced
+		glog.Infof("The NodeExpandVolumeRequest is :%v", nodeExpandVolumeRequest)
+		ctx := context.Background()
+		for count := 0; count <= 5 && state != v1alpha1.NodeExpandVolumeApplied; count++ {
+			glog.Infof("start to Reproducing NodeExpandVolumeRequest for peer pod (retrying... %d/%d)", count, 5)
+			// TODO: error check
+			_ = s.redirect(ctx, nodeExpandVolumeRequest, func(ctx context.Context, client csi.NodeClient) {
+				response, err := client.NodeExpandVolume(ctx, &nodeExpandVolumeRequest)
+				if err != nil {
+					glog.Errorf("Failed to reproduce NodeExpandVolume with the NodeExpandVolumeRequest, err: %v", err.Error())
+				} else {
+					glog.Infof("The NodeExpandVolumeResponse for peer pod is :%v", response)
+					state = v1alpha1.NodeExpandVolumeApplied
+				}
+			})
+		}
+	}
+
+	// The node wrapper waits for the status to reply to the NodeExpandVolumeRequest of kubelet
+	_, err := UpdatePeerpodVolume(context.Background(), s.PeerpodvolumeClient, s.Namespace, peerPodVolume.Name, nil, state)
+	if err != nil {
+		glog.Errorf("Error happens while Update PeerpodVolume status to %v, err: %v", state, err.Error())
+	}
+}
+
+// ReportVolumeStats reports the stats of the volumes published in the peer pod VM to their PeerpodVolumes
+// every interval, so that the node wrapper replies to the NodeGetVolumeStatsRequests of kubelet
+func (s *PodVMNodeService) ReportVolumeStats(ctx context.Context, podUid string, interval time.Duration) {
+	options := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{"podUid": podUid}).String(),
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		peerpodVolumes, err := s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).List(ctx, options)
+		if err != nil {
+			glog.Errorf("Failed to list PeerpodVolumes by podUid: %v, err: %v", podUid, err.Error())
+			return
+		}
+		for i := range peerpodVolumes.Items {
+			s.reportVolumeStats(ctx, &peerpodVolumes.Items[i])
+		}
+	}, interval)
+}
+
+func (s *PodVMNodeService) reportVolumeStats(ctx context.Context, peerPodVolume *peerpodvolumeV1alpha1.PeerpodVolume) {
+	switch peerPodVolume.Status.State {
+	case v1alpha1.NodePublishVolumeApplied, v1alpha1.NodeExpandVolumeApplied, v1alpha1.NodeExpandVolumeFailed:
+	default:
+		// The volume isn't published in the peer pod VM
+		return
+	}
+
+	var nodePublishVolumeRequest csi.NodePublishVolumeRequest
+	if err := (&jsonpb.Unmarshaler{}).Unmarshal(bytes.NewReader([]byte(peerPodVolume.Spec.WrapperNodePublishVolumeReq)), &nodePublishVolumeRequest); err != nil {
+		glog.Errorf("Failed to convert to NodePublishVolumeRequest, err: %v", err.Error())
+		return
+	}
+
+	var response *csi.NodeGetVolumeStatsResponse
+	var err error
+	if peerPodVolume.Spec.EncryptionKeyResource != "" {
+		// The csi-driver publishes the device of an encrypted volume, so the stats are of the filesystem mounted by the podvm wrapper
+		response, err = filesystemVolumeStats(nodePublishVolumeRequest.TargetPath)
+	} else {
+		req := &csi.NodeGetVolumeStatsRequest{
+			VolumeId:          nodePublishVolumeRequest.VolumeId,
+			VolumePath:        nodePublishVolumeRequest.TargetPath,
+			StagingTargetPath: nodePublishVolumeRequest.StagingTargetPath,
+		}
+		if e := s.redirect(ctx, req, func(ctx context.Context, client csi.NodeClient) {
+			response, err = client.NodeGetVolumeStats(ctx, req)
+		}); e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		glog.Errorf("Failed to get the stats of volume %v, err: %v", peerPodVolume.Spec.VolumeID, err.Error())
+		return
+	}
+
+	var resBuf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&resBuf, response); err != nil {
+		glog.Error(err, "Error happens while Marshal NodeGetVolumeStatsResponse")
+		return
+	}
+	if resBuf.String() == peerPodVolume.Status.VolumeStats {
+		return
+	}
+
+	// The stats are patched without the resource version, and the state updates of the wrappers are retried on the
+	// conflicts caused by the patches, see UpdatePeerpodVolume
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]string{"volumeStats": resBuf.String()},
+	})
+	if err != nil {
+		glog.Error(err, "Error happens while Marshal the patch of the volume stats")
+		return
+	}
+	_, err = s.PeerpodvolumeClient.ConfidentialcontainersV1alpha1().PeerpodVolumes(s.Namespace).Patch(ctx, peerPodVolume.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		glog.Errorf("Error happens while Patch the volume stats of PeerpodVolume, err: %v", err.Error())
+	}
+}
+
+// filesystemVolumeStats returns the stats of the filesystem mounted to a path
+func filesystemVolumeStats(path string) (*csi.NodeGetVolumeStatsResponse, error) {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(path, &statfs); err != nil {
+		return nil, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	blockSize := int64(statfs.Bsize)
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     int64(statfs.Blocks) * blockSize,
+				Available: int64(statfs.Bavail) * blockSize,
+				Used:      int64(statfs.Blocks-statfs.Bfree) * blockSize,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     int64(statfs.Files),
+				Available: int64(statfs.Ffree),
+				Used:      int64(statfs.Files - statfs.Ffree),
+			},
+		},
+	}, nil
+}
+
 func (s *PodVMNodeService) SyncHandler(peerPodVolume *peerpodvolumeV1alpha1.PeerpodVolume) {
 	if peerPodVolume.Spec.PodName != os.Getenv("POD_NAME") || peerPodVolume.Spec.PodNamespace != os.Getenv("POD_NAME_SPACE") {
 		// Only handle the podvm related PeerpodVolume CRD
@@ -302,6 +468,8 @@ func (s *PodVMNodeService) SyncHandler(peerPodVolume *peerpodvolumeV1alpha1.Peer
 		s.ReproduceNodeStageVolume(peerPodVolume)
 	case peerpodvolumeV1alpha1.NodeStageVolumeApplied:
 		s.ReproduceNodePublishVolume(peerPodVolume)
+	case peerpodvolumeV1alpha1.NodeExpandVolumeCached:
+		s.ReproduceNodeExpandVolume(peerPodVolume)
 	case peerpodvolumeV1alpha1.NodeUnpublishVolumeCached:
 		s.ReproduceNodeUnpublishVolume(peerPodVolume)
 	case peerpodvolumeV1alpha1.NodeUnstageVolumeCached:
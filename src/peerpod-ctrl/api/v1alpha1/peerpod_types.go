@@ -27,15 +27,70 @@ import (
 type PeerPodSpec struct {
 	CloudProvider string `json:"cloudProvider,omitempty"`
 	InstanceID    string `json:"instanceID,omitempty"`
+	// CredentialsSecret is the name of the Secret in the namespace of the PeerPod with the cloud credentials
+	// the instance was created with. The credentials of the controller are used if it is empty
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
 }
 
+// PeerPodPhase is the lifecycle phase of the instance of a PeerPod
+type PeerPodPhase string
+
+const (
+	// PeerPodCreated means that the instance is created and its details are recorded
+	PeerPodCreated PeerPodPhase = "Created"
+	// PeerPodRunning means that the kata agent in the instance is reachable
+	PeerPodRunning PeerPodPhase = "Running"
+	// PeerPodTerminating means that the instance is being deleted
+	PeerPodTerminating PeerPodPhase = "Terminating"
+)
+
 // PeerPodStatus defines the observed state of PeerPod
 type PeerPodStatus struct {
 	Cleaned bool `json:"cleand,omitempty"`
+
+	Phase        PeerPodPhase `json:"phase,omitempty"`
+	InstanceName string       `json:"instanceName,omitempty"`
+	InstanceType string       `json:"instanceType,omitempty"`
+	// Zone is the zone, or the region if the zone is unknown, of the instance
+	Zone string   `json:"zone,omitempty"`
+	IPs  []string `json:"ips,omitempty"`
+
+	// DeletionAttempts is the number of failed attempts of the controller to delete the instance
+	DeletionAttempts    int32        `json:"deletionAttempts,omitempty"`
+	LastDeletionAttempt *metav1.Time `json:"lastDeletionAttempt,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// ConditionInstanceDeleted is the condition type of the deletion of the instance by the controller
+const ConditionInstanceDeleted = "InstanceDeleted"
+
+// Reasons of ConditionInstanceDeleted
+const (
+	ReasonDeletionFailed   = "DeletionFailed"
+	ReasonRetriesExhausted = "RetriesExhausted"
+)
+
+// ConditionFinalizerTimedOut is the condition type of a finalizer that blocked the deletion for longer than the timeout of the controller
+const ConditionFinalizerTimedOut = "FinalizerTimedOut"
+
+// ReasonTimeoutExceeded is the reason of ConditionFinalizerTimedOut
+const ReasonTimeoutExceeded = "TimeoutExceeded"
+
+// SandboxIDAnnotation is the annotation of a PeerPod with the ID of the sandbox its instance is created for
+const SandboxIDAnnotation = "confidentialcontainers.org/sandbox-id"
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.spec.cloudProvider`
+//+kubebuilder:printcolumn:name="Instance ID",type=string,JSONPath=`.spec.instanceID`
+//+kubebuilder:printcolumn:name="Instance Type",type=string,JSONPath=`.status.instanceType`,priority=1
+//+kubebuilder:printcolumn:name="Zone",type=string,JSONPath=`.status.zone`,priority=1
+//+kubebuilder:printcolumn:name="IPs",type=string,JSONPath=`.status.ips`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // PeerPod is the Schema for the peerpods API
 type PeerPod struct {
@@ -0,0 +1,169 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
+)
+
+// FinalizerPolicy is what the controller does when the finalizer of a PeerPod blocked its deletion for too long
+type FinalizerPolicy string
+
+const (
+	// FinalizerPolicyBlock keeps the finalizer, and alerts with a condition and a Warning event
+	FinalizerPolicyBlock FinalizerPolicy = "block"
+	// FinalizerPolicyForce records the instance in the orphans ConfigMap and removes the finalizer
+	FinalizerPolicyForce FinalizerPolicy = "force"
+)
+
+// OrphansConfigMap is the name of the ConfigMap that records the instances of PeerPods whose finalizer was removed by force
+const OrphansConfigMap = "peerpod-orphans"
+
+// FinalizerTimeout is how long the finalizer of a PeerPod may block its deletion
+type FinalizerTimeout struct {
+	// Timeout since the deletion of a PeerPod. 0 disables it
+	Timeout time.Duration
+	Policy  FinalizerPolicy
+	// Namespace of OrphansConfigMap, required by FinalizerPolicyForce
+	Namespace string
+}
+
+// orphanRecord is an entry of OrphansConfigMap
+type orphanRecord struct {
+	InstanceID        string      `json:"instanceID"`
+	CloudProvider     string      `json:"cloudProvider,omitempty"`
+	CredentialsSecret string      `json:"credentialsSecret,omitempty"`
+	DeletionTimestamp metav1.Time `json:"deletionTimestamp"`
+	FinalizerRemoved  metav1.Time `json:"finalizerRemoved"`
+	LastError         string      `json:"lastError,omitempty"`
+}
+
+// deadline returns when the finalizer of a deleted PeerPod times out
+func (t FinalizerTimeout) deadline(pp *confidentialcontainersorgv1alpha1.PeerPod) (time.Time, bool) {
+	if t.Timeout <= 0 || pp.DeletionTimestamp == nil {
+		return time.Time{}, false
+	}
+	return pp.DeletionTimestamp.Add(t.Timeout), true
+}
+
+// finalize deletes the instance of a deleted PeerPod, and applies the finalizer policy once its finalizer timed out
+func (r *PeerPodReconciler) finalize(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) (ctrl.Result, error) {
+	deadline, ok := r.FinalizerTimeout.deadline(pp)
+	if !ok {
+		return r.deleteInstance(ctx, pp)
+	}
+
+	if wait := time.Until(deadline); wait > 0 {
+		result, err := r.deleteInstance(ctx, pp)
+		// Reconcile at the deadline, even when the attempts are exhausted
+		if err == nil && controllerutil.ContainsFinalizer(pp, ppFinalizer) && (result.RequeueAfter == 0 || result.RequeueAfter > wait) {
+			result.RequeueAfter = wait
+		}
+		return result, err
+	}
+
+	if r.FinalizerTimeout.Policy == FinalizerPolicyForce {
+		return r.removeFinalizer(ctx, pp)
+	}
+	r.finalizerBlocked(ctx, pp)
+	return r.deleteInstance(ctx, pp)
+}
+
+// finalizerBlocked alerts once that the finalizer of a PeerPod timed out
+func (r *PeerPodReconciler) finalizerBlocked(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) {
+	logger := log.FromContext(ctx)
+
+	if meta.IsStatusConditionTrue(pp.Status.Conditions, confidentialcontainersorgv1alpha1.ConditionFinalizerTimedOut) {
+		return
+	}
+	meta.SetStatusCondition(&pp.Status.Conditions, metav1.Condition{
+		Type:    confidentialcontainersorgv1alpha1.ConditionFinalizerTimedOut,
+		Status:  metav1.ConditionTrue,
+		Reason:  confidentialcontainersorgv1alpha1.ReasonTimeoutExceeded,
+		Message: fmt.Sprintf("finalizer %s has blocked the deletion for longer than %v", ppFinalizer, r.FinalizerTimeout.Timeout),
+	})
+	if err := r.Status().Update(ctx, pp); err != nil {
+		// The alert is repeated at the next reconciliation
+		logger.Info("Failed to update PeerPod status", "error", err)
+		return
+	}
+
+	finalizerTimeouts.WithLabelValues(string(FinalizerPolicyBlock)).Inc()
+	logger.Info("finalizer timed out", "InstanceID", pp.Spec.InstanceID, "timeout", r.FinalizerTimeout.Timeout)
+	r.Recorder.Eventf(pp, corev1.EventTypeWarning, "FinalizerTimeout",
+		"Finalizer %s has blocked the deletion for longer than %v, instance %s is not deleted yet", ppFinalizer, r.FinalizerTimeout.Timeout, pp.Spec.InstanceID)
+}
+
+// removeFinalizer records the instance of a PeerPod whose finalizer timed out as an orphan, and removes the finalizer
+func (r *PeerPodReconciler) removeFinalizer(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	// Without a record the instance could be forgotten, so the finalizer is kept until the instance is recorded
+	if err := r.recordOrphan(ctx, pp); err != nil {
+		return ctrl.Result{}, fmt.Errorf("recording orphaned instance %s: %w", pp.Spec.InstanceID, err)
+	}
+
+	finalizerTimeouts.WithLabelValues(string(FinalizerPolicyForce)).Inc()
+	logger.Info("finalizer timed out, removing it", "InstanceID", pp.Spec.InstanceID, "timeout", r.FinalizerTimeout.Timeout)
+	r.Recorder.Eventf(pp, corev1.EventTypeWarning, "FinalizerRemoved",
+		"Removed finalizer %s after %v, instance %s may be left in the cloud and is recorded in ConfigMap %s/%s",
+		ppFinalizer, r.FinalizerTimeout.Timeout, pp.Spec.InstanceID, r.FinalizerTimeout.Namespace, OrphansConfigMap)
+
+	controllerutil.RemoveFinalizer(pp, ppFinalizer)
+	if err := r.Update(ctx, pp); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// recordOrphan adds the instance of a PeerPod to OrphansConfigMap, with the key <namespace>.<name> of the PeerPod
+func (r *PeerPodReconciler) recordOrphan(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) error {
+	record := orphanRecord{
+		InstanceID:        pp.Spec.InstanceID,
+		CloudProvider:     pp.Spec.CloudProvider,
+		CredentialsSecret: pp.Spec.CredentialsSecret,
+		DeletionTimestamp: *pp.DeletionTimestamp,
+		FinalizerRemoved:  metav1.Now(),
+	}
+	if condition := meta.FindStatusCondition(pp.Status.Conditions, confidentialcontainersorgv1alpha1.ConditionInstanceDeleted); condition != nil {
+		record.LastError = condition.Message
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := pp.Namespace + "." + pp.Name
+
+	cm := corev1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Name: OrphansConfigMap, Namespace: r.FinalizerTimeout.Namespace}, &cm)
+	if apierrors.IsNotFound(err) {
+		cm = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: OrphansConfigMap, Namespace: r.FinalizerTimeout.Namespace},
+			Data:       map[string]string{key: string(value)},
+		}
+		return r.Create(ctx, &cm)
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[key] = string(value)
+	return r.Update(ctx, &cm)
+}
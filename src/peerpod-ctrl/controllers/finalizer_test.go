@@ -0,0 +1,137 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
+)
+
+func newFinalizerTestReconciler(t *testing.T, deleted time.Time, timeout FinalizerTimeout) (*PeerPodReconciler, *record.FakeRecorder, types.NamespacedName) {
+	scheme := runtime.NewScheme()
+	if err := confidentialcontainersorgv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	deletionTimestamp := metav1.NewTime(deleted)
+	pp := &confidentialcontainersorgv1alpha1.PeerPod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "pp",
+			Namespace:         "default",
+			Finalizers:        []string{ppFinalizer},
+			DeletionTimestamp: &deletionTimestamp,
+		},
+		Spec: confidentialcontainersorgv1alpha1.PeerPodSpec{CloudProvider: "test", InstanceID: "i-locked"},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	r := &PeerPodReconciler{
+		Client:           fake.NewClientBuilder().WithScheme(scheme).WithObjects(pp).Build(),
+		Scheme:           scheme,
+		Provider:         &failingProvider{},
+		Recorder:         recorder,
+		RetryPolicy:      RetryPolicy{MaxAttempts: 1},
+		FinalizerTimeout: timeout,
+	}
+	return r, recorder, types.NamespacedName{Name: pp.Name, Namespace: pp.Namespace}
+}
+
+func TestFinalizerTimeoutRequeue(t *testing.T) {
+	r, _, key := newFinalizerTestReconciler(t, time.Now(), FinalizerTimeout{Timeout: time.Hour, Policy: FinalizerPolicyBlock})
+
+	// The attempts are exhausted, but the timeout is still handled
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > time.Hour {
+		t.Errorf("expected a requeue before the timeout, got %v", result.RequeueAfter)
+	}
+}
+
+func TestFinalizerTimeoutBlock(t *testing.T) {
+	r, recorder, key := newFinalizerTestReconciler(t, time.Now().Add(-2*time.Hour), FinalizerTimeout{Timeout: time.Hour, Policy: FinalizerPolicyBlock})
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("reconcile failed: %v", err)
+		}
+	}
+
+	pp := &confidentialcontainersorgv1alpha1.PeerPod{}
+	if err := r.Get(context.Background(), key, pp); err != nil {
+		t.Fatalf("failed to get PeerPod: %v", err)
+	}
+	if !controllerutil.ContainsFinalizer(pp, ppFinalizer) {
+		t.Error("expected the finalizer to be kept")
+	}
+	if !meta.IsStatusConditionTrue(pp.Status.Conditions, confidentialcontainersorgv1alpha1.ConditionFinalizerTimedOut) {
+		t.Errorf("expected the %s condition, got %+v", confidentialcontainersorgv1alpha1.ConditionFinalizerTimedOut, pp.Status.Conditions)
+	}
+
+	var timeouts int
+	for len(recorder.Events) > 0 {
+		if strings.HasPrefix(<-recorder.Events, "Warning FinalizerTimeout") {
+			timeouts++
+		}
+	}
+	if timeouts != 1 {
+		t.Errorf("expected one FinalizerTimeout event, got %d", timeouts)
+	}
+}
+
+func TestFinalizerTimeoutForce(t *testing.T) {
+	r, recorder, key := newFinalizerTestReconciler(t, time.Now().Add(-2*time.Hour), FinalizerTimeout{Timeout: time.Hour, Policy: FinalizerPolicyForce, Namespace: "peerpods"})
+
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+
+	pp := &confidentialcontainersorgv1alpha1.PeerPod{}
+	if err := r.Get(context.Background(), key, pp); err == nil && controllerutil.ContainsFinalizer(pp, ppFinalizer) {
+		t.Error("expected the finalizer to be removed")
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatalf("failed to get PeerPod: %v", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: OrphansConfigMap, Namespace: "peerpods"}, cm); err != nil {
+		t.Fatalf("failed to get orphans ConfigMap: %v", err)
+	}
+	record := orphanRecord{}
+	if err := json.Unmarshal([]byte(cm.Data["default.pp"]), &record); err != nil {
+		t.Fatalf("invalid orphan record: %v", err)
+	}
+	if record.InstanceID != "i-locked" || record.CloudProvider != "test" {
+		t.Errorf("unexpected orphan record: %+v", record)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning FinalizerRemoved") {
+			t.Errorf("unexpected event: %s", event)
+		}
+	default:
+		t.Error("expected a Warning event")
+	}
+}
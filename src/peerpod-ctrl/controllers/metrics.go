@@ -0,0 +1,205 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
+)
+
+const metricsTimeout = 5 * time.Second
+
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "peerpod",
+		Subsystem: "ctrl",
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of PeerPod reconciliations, including the deletion of instances",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"result"})
+
+	deletionRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "ctrl",
+		Name:      "instance_deletion_retries_total",
+		Help:      "Number of failed instance deletions of PeerPods that are retried",
+	})
+
+	deletionsExhausted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "ctrl",
+		Name:      "instance_deletions_exhausted_total",
+		Help:      "Number of instances of PeerPods that the controller gave up deleting",
+	})
+
+	finalizerTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "ctrl",
+		Name:      "finalizer_timeouts_total",
+		Help:      "Number of PeerPods whose finalizer blocked the deletion for longer than the timeout, by policy",
+	}, []string{"policy"})
+
+	providerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerpod",
+		Subsystem: "ctrl",
+		Name:      "provider_errors_total",
+		Help:      "Number of errors returned by the cloud provider",
+	}, []string{"operation", "class"})
+
+	orphanedInstances = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "peerpod",
+		Subsystem: "ctrl",
+		Name:      "orphaned_instances",
+		Help:      "Number of instances without a PeerPod that are not deleted, at the last run of the orphan collector",
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(reconcileDuration, deletionRetries, deletionsExhausted, finalizerTimeouts, providerErrors, orphanedInstances)
+}
+
+// Operations of the cloud provider in providerErrors
+const (
+	opSetProvider    = "set_provider"
+	opDeleteInstance = "delete_instance"
+	opListInstances  = "list_instances"
+)
+
+// observeReconcile records the duration of a reconciliation started at start that returned err
+func observeReconcile(start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	reconcileDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+// countProviderError counts an error of the cloud provider by its class
+func countProviderError(operation string, err error) {
+	providerErrors.WithLabelValues(operation, errorClass(err)).Inc()
+}
+
+// errorClass classifies the errors of cloud providers by the error codes of the cloud APIs and the HTTP status codes
+// of their responses. The errors of providers that don't return typed errors are classified as "other"
+func errorClass(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+
+	var code string
+	var status int
+
+	var azureErr *azcore.ResponseError
+	var apiErr smithy.APIError
+	var responseErr *smithyhttp.ResponseError
+	if errors.As(err, &azureErr) {
+		code, status = azureErr.ErrorCode, azureErr.StatusCode
+	} else {
+		if errors.As(err, &apiErr) {
+			code = apiErr.ErrorCode()
+		}
+		if errors.As(err, &responseErr) {
+			status = responseErr.HTTPStatusCode()
+		}
+	}
+
+	if class := errorCodeClass(code); class != "" {
+		return class
+	}
+	switch status {
+	case http.StatusNotFound, http.StatusGone:
+		return "not_found"
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "unauthorized"
+	case http.StatusTooManyRequests:
+		return "throttled"
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return "timeout"
+	}
+	return "other"
+}
+
+// errorCodeClass classifies the error codes of the AWS and Azure APIs, e.g. InvalidInstanceID.NotFound or
+// AuthorizationFailed. Throttling is checked before quotas, since RequestLimitExceeded is a throttling error
+func errorCodeClass(code string) string {
+	if code == "" {
+		return ""
+	}
+	code = strings.ToLower(code)
+	for _, class := range []struct {
+		name     string
+		patterns []string
+	}{
+		{"not_found", []string{"notfound"}},
+		{"unauthorized", []string{"unauthorized", "authfailure", "authorizationfailed", "accessdenied", "forbidden"}},
+		{"throttled", []string{"throttl", "requestlimitexceeded", "toomanyrequests"}},
+		{"quota", []string{"quota", "limitexceeded", "insufficient", "skunotavailable", "allocationfailed"}},
+	} {
+		for _, pattern := range class.patterns {
+			if strings.Contains(code, pattern) {
+				return class.name
+			}
+		}
+	}
+	return ""
+}
+
+// danglingCollector exposes the number of deleted PeerPods whose instances are not deleted yet
+type danglingCollector struct {
+	reader client.Reader
+	desc   *prometheus.Desc
+}
+
+func newDanglingCollector(reader client.Reader) *danglingCollector {
+	return &danglingCollector{
+		reader: reader,
+		desc: prometheus.NewDesc("peerpod_ctrl_dangling_peerpods",
+			"Number of deleted PeerPods whose instances are not deleted yet", nil, nil),
+	}
+}
+
+func (c *danglingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *danglingCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+	defer cancel()
+
+	ppList := confidentialcontainersorgv1alpha1.PeerPodList{}
+	if err := c.reader.List(ctx, &ppList); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	count := 0
+	for i := range ppList.Items {
+		pp := &ppList.Items[i]
+		if pp.GetDeletionTimestamp() != nil && controllerutil.ContainsFinalizer(pp, ppFinalizer) {
+			count++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
@@ -0,0 +1,47 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func awsResponseError(status int, err error) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+		Err:      err,
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("deleting: %w", context.DeadlineExceeded), "timeout"},
+		{context.Canceled, "canceled"},
+		{fmt.Errorf("deleting: %w", awsResponseError(400, &smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound"})), "not_found"},
+		{&azcore.ResponseError{StatusCode: 403, ErrorCode: "AuthorizationFailed"}, "unauthorized"},
+		{awsResponseError(503, &smithy.GenericAPIError{Code: "RequestLimitExceeded"}), "throttled"},
+		{&smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"}, "quota"},
+		{&azcore.ResponseError{StatusCode: 409, ErrorCode: "OperationNotAllowed"}, "other"},
+		{awsResponseError(404, errors.New("not found")), "not_found"},
+		{&azcore.ResponseError{StatusCode: 429}, "throttled"},
+		// Messages of untyped errors are not classified
+		{errors.New("instance not found"), "other"},
+		{errors.New("connection refused"), "other"},
+	} {
+		if got := errorClass(tc.err); got != tc.want {
+			t.Errorf("errorClass(%q) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
+)
+
+var errListNotSupported = errors.New("cloud provider does not support listing instances")
+
+// OrphanCollector periodically deletes the cloud instances tagged with the cluster ID that no PeerPod refers to.
+// Such instances are leaked when cloud-api-adaptor stops, e.g. because its node crashed, after it created
+// an instance but before it created the PeerPod of the instance.
+// Instances are matched with PeerPods by their normalized instance ID or by their sandbox ID, and the instances
+// of pods that are running without a PeerPod, e.g. because cloud-api-adaptor failed to create it, are kept.
+type OrphanCollector struct {
+	client.Client
+	// PodReader gets the pods of instances. The Client is used if it is nil
+	PodReader client.Reader
+	Provider  provider.Provider
+	ClusterID string
+	Interval  time.Duration
+	// MinAge is the age below which instances are never deleted, since their PeerPod may not be created yet
+	MinAge time.Duration
+	// DryRun only logs the instances that would be deleted
+	DryRun bool
+}
+
+// Start runs the collector until ctx is canceled
+func (c *OrphanCollector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("orphan-collector")
+	logger.Info("starting", "ClusterID", c.ClusterID, "Interval", c.Interval, "MinAge", c.MinAge, "DryRun", c.DryRun)
+	ctx = log.IntoContext(ctx, logger)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.collect(ctx); err != nil {
+			if errors.Is(err, errListNotSupported) {
+				logger.Info("stopping", "reason", err.Error())
+				return nil
+			}
+			logger.Info("Failed to collect orphaned instances", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes only the leader delete instances
+func (c *OrphanCollector) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *OrphanCollector) collect(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	if c.Provider == nil {
+		p, err := SetProvider()
+		if err != nil {
+			countProviderError(opSetProvider, err)
+			return err
+		}
+		c.Provider = p
+	}
+
+	lister, ok := c.Provider.(provider.InstanceLister)
+	if !ok {
+		return errListNotSupported
+	}
+
+	// Instances are listed before PeerPods, so that the PeerPod of a new instance is not missed
+	instances, err := lister.ListInstances(ctx, c.ClusterID)
+	if err != nil {
+		countProviderError(opListInstances, err)
+		return err
+	}
+
+	ppList := confidentialcontainersorgv1alpha1.PeerPodList{}
+	if err := c.List(ctx, &ppList); err != nil {
+		return err
+	}
+	normalize := func(id string) string { return id }
+	if normalizer, ok := c.Provider.(provider.InstanceIDNormalizer); ok {
+		normalize = normalizer.NormalizeInstanceID
+	}
+
+	known := map[string]bool{}
+	knownSandboxes := map[string]bool{}
+	podsWithPeerPod := map[string]bool{}
+	for _, pp := range ppList.Items {
+		known[normalize(pp.Spec.InstanceID)] = true
+		if sandboxID := pp.Annotations[confidentialcontainersorgv1alpha1.SandboxIDAnnotation]; sandboxID != "" {
+			knownSandboxes[sandboxID] = true
+		}
+		for _, owner := range pp.OwnerReferences {
+			if owner.Kind == "Pod" {
+				podsWithPeerPod[pp.Namespace+"/"+owner.Name] = true
+			}
+		}
+	}
+
+	remaining := 0
+	defer func() {
+		orphanedInstances.Set(float64(remaining))
+	}()
+
+	for _, instance := range instances {
+		if known[normalize(instance.ID)] || (instance.SandboxID != "" && knownSandboxes[instance.SandboxID]) {
+			continue
+		}
+		// Instances without a creation time are kept, since their age is unknown
+		if instance.Created.IsZero() || time.Since(instance.Created) < c.MinAge {
+			continue
+		}
+		if instance.Pod != "" && !podsWithPeerPod[instance.Pod] {
+			running, err := c.isPodRunning(ctx, instance.Pod)
+			if err != nil {
+				logger.Info("Failed to get the pod of instance", "InstanceID", instance.ID, "Pod", instance.Pod, "error", err)
+				remaining++
+				continue
+			}
+			if running {
+				logger.Info("keeping instance without a PeerPod, its pod is running", "InstanceID", instance.ID, "Pod", instance.Pod)
+				continue
+			}
+		}
+
+		if c.DryRun {
+			logger.Info("found orphaned instance, not deleting it in dry run mode", "InstanceID", instance.ID, "Name", instance.Name, "Created", instance.Created)
+			remaining++
+			continue
+		}
+
+		logger.Info("deleting orphaned instance", "InstanceID", instance.ID, "Name", instance.Name, "Created", instance.Created)
+		if err := c.Provider.DeleteInstance(ctx, instance.ID); err != nil {
+			countProviderError(opDeleteInstance, err)
+			logger.Info("Failed to delete orphaned instance", "InstanceID", instance.ID, "error", err)
+			remaining++
+		}
+	}
+
+	return nil
+}
+
+// isPodRunning returns true if the pod with namespace/name exists and has not terminated
+func (c *OrphanCollector) isPodRunning(ctx context.Context, namespacedName string) (bool, error) {
+	namespace, name, ok := strings.Cut(namespacedName, "/")
+	if !ok {
+		return false, nil
+	}
+
+	reader := c.PodReader
+	if reader == nil {
+		reader = c.Client
+	}
+
+	pod := corev1.Pod{}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return pod.DeletionTimestamp == nil && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed, nil
+}
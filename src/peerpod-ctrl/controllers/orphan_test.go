@@ -0,0 +1,172 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	"github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers/util/cloudinit"
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
+)
+
+type listingProvider struct {
+	instances []provider.InstanceInfo
+	deleted   []string
+}
+
+func (p *listingProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec provider.InstanceTypeSpec) (*provider.Instance, error) {
+	return nil, nil
+}
+
+func (p *listingProvider) DeleteInstance(ctx context.Context, instanceID string) error {
+	p.deleted = append(p.deleted, instanceID)
+	return nil
+}
+
+func (p *listingProvider) Teardown() error {
+	return nil
+}
+
+func (p *listingProvider) ConfigVerifier() error {
+	return nil
+}
+
+func (p *listingProvider) ListInstances(ctx context.Context, clusterID string) ([]provider.InstanceInfo, error) {
+	return p.instances, nil
+}
+
+// normalizingProvider compares instance IDs case-insensitively like the Azure provider
+type normalizingProvider struct {
+	*listingProvider
+}
+
+func (p normalizingProvider) NormalizeInstanceID(id string) string {
+	return strings.ToLower(id)
+}
+
+func TestOrphanCollector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := confidentialcontainersorgv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	pp := &confidentialcontainersorgv1alpha1.PeerPod{
+		ObjectMeta: metav1.ObjectMeta{Name: "known", Namespace: "default"},
+		Spec:       confidentialcontainersorgv1alpha1.PeerPodSpec{InstanceID: "i-known"},
+	}
+	old := time.Now().Add(-2 * time.Hour)
+
+	for _, dryRun := range []bool{false, true} {
+		p := &listingProvider{
+			instances: []provider.InstanceInfo{
+				{ID: "i-known", Created: old},
+				{ID: "i-orphan", Created: old},
+				{ID: "i-other-orphan", Created: old},
+				{ID: "i-new", Created: time.Now()},
+				{ID: "i-unknown-age"},
+			},
+		}
+		c := &OrphanCollector{
+			Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(pp).Build(),
+			Provider:  p,
+			ClusterID: "test-cluster",
+			MinAge:    time.Hour,
+			DryRun:    dryRun,
+		}
+
+		if err := c.collect(context.Background()); err != nil {
+			t.Fatalf("collect failed: %v", err)
+		}
+
+		var want []string
+		if !dryRun {
+			want = []string{"i-orphan", "i-other-orphan"}
+		}
+		sort.Strings(p.deleted)
+		if !reflect.DeepEqual(p.deleted, want) {
+			t.Errorf("dry run %v: expected %v to be deleted, got %v", dryRun, want, p.deleted)
+		}
+	}
+}
+
+func TestOrphanCollectorCrossCheck(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := confidentialcontainersorgv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	newPod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	objects := []runtime.Object{
+		&confidentialcontainersorgv1alpha1.PeerPod{
+			ObjectMeta: metav1.ObjectMeta{Name: "by-id", Namespace: "default"},
+			Spec:       confidentialcontainersorgv1alpha1.PeerPodSpec{InstanceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/podvm-a"},
+		},
+		&confidentialcontainersorgv1alpha1.PeerPod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "by-sandbox",
+				Namespace:   "default",
+				Annotations: map[string]string{confidentialcontainersorgv1alpha1.SandboxIDAnnotation: "sandbox-b"},
+			},
+			Spec: confidentialcontainersorgv1alpha1.PeerPodSpec{InstanceID: "i-b-other"},
+		},
+		&confidentialcontainersorgv1alpha1.PeerPod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "with-peerpod",
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: "with-peerpod", UID: "uid"}},
+			},
+			Spec: confidentialcontainersorgv1alpha1.PeerPodSpec{InstanceID: "i-current"},
+		},
+		newPod("running", corev1.PodRunning),
+		newPod("succeeded", corev1.PodSucceeded),
+		newPod("with-peerpod", corev1.PodRunning),
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	p := &listingProvider{
+		instances: []provider.InstanceInfo{
+			{ID: "/SUBSCRIPTIONS/SUB/RESOURCEGROUPS/RG/providers/Microsoft.Compute/virtualMachines/podvm-a", Created: old},
+			{ID: "i-b", SandboxID: "sandbox-b", Created: old},
+			{ID: "i-running", Pod: "default/running", Created: old},
+			{ID: "i-succeeded", Pod: "default/succeeded", Created: old},
+			{ID: "i-deleted-pod", Pod: "default/deleted", Created: old},
+			{ID: "i-previous-sandbox", Pod: "default/with-peerpod", Created: old},
+		},
+	}
+	c := &OrphanCollector{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objects...).Build(),
+		Provider:  normalizingProvider{p},
+		ClusterID: "test-cluster",
+		MinAge:    time.Hour,
+	}
+
+	if err := c.collect(context.Background()); err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+
+	want := []string{"i-deleted-pod", "i-previous-sandbox", "i-succeeded"}
+	sort.Strings(p.deleted)
+	if !reflect.DeepEqual(p.deleted, want) {
+		t.Errorf("expected %v to be deleted, got %v", want, p.deleted)
+	}
+}
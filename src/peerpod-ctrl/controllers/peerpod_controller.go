@@ -20,14 +20,19 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
 	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
@@ -37,8 +42,11 @@ import (
 // PeerPodReconciler reconciles a PeerPod object
 type PeerPodReconciler struct {
 	client.Client
-	Scheme   *runtime.Scheme
-	Provider provider.Provider
+	Scheme           *runtime.Scheme
+	Provider         provider.Provider
+	Recorder         record.EventRecorder
+	RetryPolicy      RetryPolicy
+	FinalizerTimeout FinalizerTimeout
 }
 
 const (
@@ -48,12 +56,21 @@ const (
 )
 
 //+kubebuilder:rbac:groups="",resourceNames=peer-pods-cm;peer-pods-secret,resources=configmaps;secrets,verbs=get
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=create
+//+kubebuilder:rbac:groups="",resourceNames=peerpod-orphans,resources=configmaps,verbs=get;update
 
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=peerpods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=peerpods/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=peerpods/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get
+
+func (r *PeerPodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	defer func(start time.Time) {
+		observeReconcile(start, err)
+	}(time.Now())
 
-func (r *PeerPodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 	pp := confidentialcontainersorgv1alpha1.PeerPod{}
 
@@ -69,6 +86,7 @@ func (r *PeerPodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		var pErr error
 		r.Provider, pErr = SetProvider()
 		if pErr != nil {
+			countProviderError(opSetProvider, pErr)
 			return ctrl.Result{}, pErr
 		}
 	}
@@ -104,26 +122,133 @@ func (r *PeerPodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 
 	if controllerutil.ContainsFinalizer(&pp, ppFinalizer) {
-		logger.Info("deleting instance", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
-		if err := r.Provider.DeleteInstance(ctx, pp.Spec.InstanceID); err != nil {
-			return ctrl.Result{}, err
+		return r.finalize(ctx, &pp)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// deleteInstance deletes the instance of a deleted PeerPod and removes its finalizer.
+// Failed attempts are retried according to the retry policy, and recorded in the status of the PeerPod.
+func (r *PeerPodReconciler) deleteInstance(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	status := &pp.Status
+
+	// The controller gave up, until the attempts are reset in the status
+	if r.RetryPolicy.exhausted(status.DeletionAttempts) {
+		return ctrl.Result{}, nil
+	}
+	// Updates of the status trigger reconciliations before the delay
+	if status.LastDeletionAttempt != nil {
+		if wait := time.Until(status.LastDeletionAttempt.Add(r.RetryPolicy.delay(status.DeletionAttempts))); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
 		}
+	}
 
-		controllerutil.RemoveFinalizer(&pp, ppFinalizer)
-		if err := r.Update(ctx, &pp); err != nil {
-			if !apierrors.IsNotFound(err) { // object exist but fail to update, try again
-				return ctrl.Result{}, err
-			}
+	logger.Info("deleting instance", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
+	if status.Phase != confidentialcontainersorgv1alpha1.PeerPodTerminating {
+		status.Phase = confidentialcontainersorgv1alpha1.PeerPodTerminating
+		if err := r.Status().Update(ctx, pp); err != nil {
+			logger.Info("Failed to update PeerPod status", "error", err)
 		}
+	}
+	p, err := r.instanceProvider(ctx, pp)
+	if err != nil {
+		countProviderError(opSetProvider, err)
+		return r.deletionFailed(ctx, pp, err)
+	}
+	if err := p.DeleteInstance(ctx, pp.Spec.InstanceID); err != nil {
+		countProviderError(opDeleteInstance, err)
+		return r.deletionFailed(ctx, pp, err)
+	}
 
-		logger.Info("instance deleted", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
+	controllerutil.RemoveFinalizer(pp, ppFinalizer)
+	if err := r.Update(ctx, pp); err != nil {
+		if !apierrors.IsNotFound(err) { // object exist but fail to update, try again
+			return ctrl.Result{}, err
+		}
 	}
 
+	logger.Info("instance deleted", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
+
 	return ctrl.Result{}, nil
 }
 
+// instanceProvider returns a provider with the credentials that the instance of a PeerPod was created with
+func (r *PeerPodReconciler) instanceProvider(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) (provider.Provider, error) {
+	if pp.Spec.CredentialsSecret == "" {
+		return r.Provider, nil
+	}
+
+	secret := corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pp.Spec.CredentialsSecret, Namespace: pp.Namespace}, &secret); err != nil {
+		return nil, fmt.Errorf("getting credentials Secret %s/%s: %w", pp.Namespace, pp.Spec.CredentialsSecret, err)
+	}
+	credentials := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		credentials[k] = string(v)
+	}
+
+	cloudName := pp.Spec.CloudProvider
+	if cloudName == "" {
+		cloudName = os.Getenv("CLOUD_PROVIDER")
+	}
+	p, err := provider.NewProviderWithCredentials(cloudName, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("credentials Secret %s/%s: %w", pp.Namespace, pp.Spec.CredentialsSecret, err)
+	}
+	return p, nil
+}
+
+// deletionFailed records a failed attempt to delete the instance of a PeerPod, and schedules the next attempt.
+// When the attempts are exhausted, a Warning event is recorded instead.
+func (r *PeerPodReconciler) deletionFailed(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod, deleteErr error) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	status := &pp.Status
+
+	now := metav1.Now()
+	status.DeletionAttempts++
+	status.LastDeletionAttempt = &now
+	exhausted := r.RetryPolicy.exhausted(status.DeletionAttempts)
+
+	condition := metav1.Condition{
+		Type:    confidentialcontainersorgv1alpha1.ConditionInstanceDeleted,
+		Status:  metav1.ConditionFalse,
+		Reason:  confidentialcontainersorgv1alpha1.ReasonDeletionFailed,
+		Message: deleteErr.Error(),
+	}
+	if exhausted {
+		condition.Reason = confidentialcontainersorgv1alpha1.ReasonRetriesExhausted
+		condition.Message = fmt.Sprintf("gave up after %d attempts: %v", status.DeletionAttempts, deleteErr)
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if err := r.Status().Update(ctx, pp); err != nil {
+		// Without the attempts in the status, the deletion is retried with the backoff of the controller
+		logger.Info("Failed to update PeerPod status", "error", err)
+		deletionRetries.Inc()
+		return ctrl.Result{}, deleteErr
+	}
+
+	if exhausted {
+		deletionsExhausted.Inc()
+		logger.Info("giving up deleting instance", "InstanceID", pp.Spec.InstanceID, "attempts", status.DeletionAttempts, "error", deleteErr)
+		r.Recorder.Eventf(pp, corev1.EventTypeWarning, "InstanceDeletionFailed",
+			"Gave up deleting instance %s after %d attempts, the instance needs to be deleted manually: %v", pp.Spec.InstanceID, status.DeletionAttempts, deleteErr)
+		return ctrl.Result{}, nil
+	}
+
+	deletionRetries.Inc()
+	delay := r.RetryPolicy.delay(status.DeletionAttempts)
+	logger.Info("Failed to delete instance, retrying", "InstanceID", pp.Spec.InstanceID, "attempts", status.DeletionAttempts, "delay", delay, "error", deleteErr)
+	return ctrl.Result{Requeue: true, RequeueAfter: delay}, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *PeerPodReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := ctrlmetrics.Registry.Register(newDanglingCollector(mgr.GetClient())); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&confidentialcontainersorgv1alpha1.PeerPod{}).
 		Complete(r)
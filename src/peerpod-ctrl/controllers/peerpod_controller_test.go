@@ -0,0 +1,87 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
+)
+
+// credentialsCloud is a cloud provider that creates providers with other credentials
+type credentialsCloud struct {
+	credentials map[string]string
+	provider    *listingProvider
+}
+
+func (c *credentialsCloud) ParseCmd(flags *flag.FlagSet) {}
+
+func (c *credentialsCloud) LoadEnv() {}
+
+func (c *credentialsCloud) NewProvider() (provider.Provider, error) {
+	return &listingProvider{}, nil
+}
+
+func (c *credentialsCloud) NewProviderWithCredentials(credentials map[string]string) (provider.Provider, error) {
+	c.credentials = credentials
+	return c.provider, nil
+}
+
+func TestInstanceProvider(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := confidentialcontainersorgv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	cloud := &credentialsCloud{provider: &listingProvider{}}
+	provider.AddCloudProvider("test-credentials", cloud)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-credentials", Namespace: "tenant"},
+		Data:       map[string][]byte{"KEY": []byte("value")},
+	}
+	defaultProvider := &listingProvider{}
+	r := &PeerPodReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build(),
+		Scheme:   scheme,
+		Provider: defaultProvider,
+	}
+
+	pp := &confidentialcontainersorgv1alpha1.PeerPod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pp", Namespace: "tenant"},
+		Spec:       confidentialcontainersorgv1alpha1.PeerPodSpec{CloudProvider: "test-credentials", InstanceID: "i-tenant"},
+	}
+	if p, err := r.instanceProvider(context.Background(), pp); err != nil || p != defaultProvider {
+		t.Errorf("expected the default provider without a credentials Secret, got %v, %v", p, err)
+	}
+
+	pp.Spec.CredentialsSecret = "tenant-credentials"
+	p, err := r.instanceProvider(context.Background(), pp)
+	if err != nil {
+		t.Fatalf("instanceProvider failed: %v", err)
+	}
+	if p != cloud.provider {
+		t.Error("expected the provider with the credentials of the Secret")
+	}
+	if !reflect.DeepEqual(cloud.credentials, map[string]string{"KEY": "value"}) {
+		t.Errorf("unexpected credentials: %v", cloud.credentials)
+	}
+
+	pp.Namespace = "other"
+	if _, err := r.instanceProvider(context.Background(), pp); err == nil {
+		t.Error("expected an error for a Secret in another namespace")
+	}
+}
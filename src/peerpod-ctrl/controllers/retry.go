@@ -0,0 +1,46 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"time"
+)
+
+// RetryPolicy is how the controller retries the deletion of an instance that failed
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts after which the controller gives up. 0 retries forever
+	MaxAttempts int32
+	// BaseDelay is the delay after the first failed attempt, which doubles after each failed attempt up to MaxDelay.
+	// Both must be positive for retries to be delayed
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy gives up about 50 minutes after the first failed attempt
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 12,
+	BaseDelay:   5 * time.Second,
+	MaxDelay:    10 * time.Minute,
+}
+
+// delay returns the delay before the next attempt after failed attempts
+func (p RetryPolicy) delay(attempts int32) time.Duration {
+	if attempts <= 0 || p.BaseDelay <= 0 || p.MaxDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := int32(1); i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// exhausted tells if the controller gives up after failed attempts
+func (p RetryPolicy) exhausted(attempts int32) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
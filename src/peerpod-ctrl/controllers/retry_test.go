@@ -0,0 +1,112 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	provider "github.com/confidential-containers/cloud-api-adaptor/src/cloud-providers"
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/src/peerpod-ctrl/api/v1alpha1"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	for attempts, want := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := policy.delay(int32(attempts)); got != want {
+			t.Errorf("delay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+
+	if policy.exhausted(2) || !policy.exhausted(3) {
+		t.Error("expected the policy to be exhausted after 3 attempts")
+	}
+	if (RetryPolicy{}).exhausted(100) {
+		t.Error("expected a policy without MaxAttempts to retry forever")
+	}
+}
+
+type failingProvider struct {
+	listingProvider
+}
+
+func (p *failingProvider) DeleteInstance(ctx context.Context, instanceID string) error {
+	p.deleted = append(p.deleted, instanceID)
+	return errors.New("instance is locked")
+}
+
+var _ provider.Provider = &failingProvider{}
+
+func TestDeletionRetries(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := confidentialcontainersorgv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	now := metav1.Now()
+	pp := &confidentialcontainersorgv1alpha1.PeerPod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "pp",
+			Namespace:         "default",
+			Finalizers:        []string{ppFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Spec: confidentialcontainersorgv1alpha1.PeerPodSpec{InstanceID: "i-locked"},
+	}
+	key := types.NamespacedName{Name: pp.Name, Namespace: pp.Namespace}
+
+	p := &failingProvider{}
+	recorder := record.NewFakeRecorder(10)
+	r := &PeerPodReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(pp).Build(),
+		Scheme:   scheme,
+		Provider: p,
+		Recorder: recorder,
+		// Zero delays retry immediately
+		RetryPolicy: RetryPolicy{MaxAttempts: 2},
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("reconcile failed: %v", err)
+		}
+	}
+
+	if len(p.deleted) != 2 {
+		t.Errorf("expected 2 attempts, got %d", len(p.deleted))
+	}
+
+	got := &confidentialcontainersorgv1alpha1.PeerPod{}
+	if err := r.Get(context.Background(), key, got); err != nil {
+		t.Fatalf("failed to get PeerPod: %v", err)
+	}
+	if got.Status.DeletionAttempts != 2 || got.Status.Phase != confidentialcontainersorgv1alpha1.PeerPodTerminating {
+		t.Errorf("unexpected status: %+v", got.Status)
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, confidentialcontainersorgv1alpha1.ConditionInstanceDeleted)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != confidentialcontainersorgv1alpha1.ReasonRetriesExhausted {
+		t.Errorf("unexpected condition: %+v", condition)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning InstanceDeletionFailed") {
+			t.Errorf("unexpected event: %s", event)
+		}
+	default:
+		t.Error("expected a Warning event")
+	}
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -54,11 +55,30 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var orphanGCInterval time.Duration
+	var orphanGCMinAge time.Duration
+	var orphanGCDryRun bool
+	retryPolicy := controllers.DefaultRetryPolicy
+	var maxDeletionAttempts int
+	var finalizerTimeout time.Duration
+	var finalizerTimeoutPolicy string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&orphanGCInterval, "orphan-gc-interval", 0,
+		"Interval at which cloud instances tagged with CLUSTER_ID and without a PeerPod are deleted. 0 disables it.")
+	flag.DurationVar(&orphanGCMinAge, "orphan-gc-min-age", time.Hour, "Minimum age of the instances deleted for not having a PeerPod.")
+	flag.IntVar(&maxDeletionAttempts, "deletion-max-attempts", int(retryPolicy.MaxAttempts),
+		"Number of failed attempts to delete an instance after which the controller gives up and records a Warning event. 0 retries forever.")
+	flag.DurationVar(&retryPolicy.BaseDelay, "deletion-backoff-base", retryPolicy.BaseDelay, "Delay after the first failed attempt to delete an instance, doubled after each failed attempt.")
+	flag.DurationVar(&retryPolicy.MaxDelay, "deletion-backoff-max", retryPolicy.MaxDelay, "Maximum delay between attempts to delete an instance.")
+	flag.BoolVar(&orphanGCDryRun, "orphan-gc-dry-run", false, "Only log the instances that would be deleted for not having a PeerPod.")
+	flag.DurationVar(&finalizerTimeout, "finalizer-timeout", 0,
+		"Maximum time the finalizer of a deleted PeerPod may block its deletion before finalizer-timeout-policy applies. 0 disables it.")
+	flag.StringVar(&finalizerTimeoutPolicy, "finalizer-timeout-policy", string(controllers.FinalizerPolicyBlock),
+		"What to do when the finalizer of a PeerPod times out: block keeps it and records a Warning event, force records the instance in the peerpod-orphans ConfigMap of PEERPODS_NAMESPACE and removes the finalizer.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -67,6 +87,30 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if maxDeletionAttempts < 0 || retryPolicy.BaseDelay <= 0 || retryPolicy.MaxDelay < retryPolicy.BaseDelay {
+		setupLog.Error(nil, "invalid deletion retry policy", "deletion-max-attempts", maxDeletionAttempts,
+			"deletion-backoff-base", retryPolicy.BaseDelay, "deletion-backoff-max", retryPolicy.MaxDelay)
+		os.Exit(1)
+	}
+	retryPolicy.MaxAttempts = int32(maxDeletionAttempts)
+
+	finalizerTimeoutConfig := controllers.FinalizerTimeout{
+		Timeout:   finalizerTimeout,
+		Policy:    controllers.FinalizerPolicy(finalizerTimeoutPolicy),
+		Namespace: os.Getenv("PEERPODS_NAMESPACE"),
+	}
+	switch {
+	case finalizerTimeout < 0:
+		setupLog.Error(nil, "invalid finalizer timeout", "finalizer-timeout", finalizerTimeout)
+		os.Exit(1)
+	case finalizerTimeoutConfig.Policy != controllers.FinalizerPolicyBlock && finalizerTimeoutConfig.Policy != controllers.FinalizerPolicyForce:
+		setupLog.Error(nil, "invalid finalizer timeout policy, must be block or force", "finalizer-timeout-policy", finalizerTimeoutPolicy)
+		os.Exit(1)
+	case finalizerTimeoutConfig.Policy == controllers.FinalizerPolicyForce && finalizerTimeoutConfig.Namespace == "":
+		setupLog.Error(nil, "PEERPODS_NAMESPACE is required by finalizer-timeout-policy=force")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -102,15 +146,38 @@ func main() {
 	}
 
 	if err = (&controllers.PeerPodReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Provider: provider,
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		Provider:         provider,
+		Recorder:         mgr.GetEventRecorderFor("peerpod-ctrl"),
+		RetryPolicy:      retryPolicy,
+		FinalizerTimeout: finalizerTimeoutConfig,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PeerPod")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
 
+	if orphanGCInterval > 0 {
+		clusterID := os.Getenv("CLUSTER_ID")
+		if clusterID == "" {
+			setupLog.Error(nil, "CLUSTER_ID is required by orphan-gc-interval")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&controllers.OrphanCollector{
+			Client:    mgr.GetClient(),
+			PodReader: mgr.GetAPIReader(),
+			Provider:  provider,
+			ClusterID: clusterID,
+			Interval:  orphanGCInterval,
+			MinAge:    orphanGCMinAge,
+			DryRun:    orphanGCDryRun,
+		}); err != nil {
+			setupLog.Error(err, "unable to add orphan collector")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
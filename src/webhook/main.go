@@ -53,11 +53,29 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var runtimeClassConfig string
+	var namespaceDefaults bool
+	var mode string
+	var namespaceSelector, objectSelector string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&runtimeClassConfig, "runtime-class-config", "",
+		"Path of a YAML file with the extended resource and defaults of each RuntimeClass of peer pods, and the policies of namespaces. "+
+			"Defaults to the RuntimeClass TARGET_RUNTIMECLASS with the extended resource POD_VM_EXTENDED_RESOURCE.")
+	flag.BoolVar(&namespaceDefaults, "namespace-defaults", true,
+		"Set the RuntimeClass and the annotations of peer pods from the annotations of their namespace, "+
+			"if the pods don't set them.")
+	flag.StringVar(&mode, "mode", string(mutating_webhook.ModeEnforce),
+		"Mode of the webhook: enforce mutates the pods, audit only logs the mutations and records them in the "+
+			mutating_webhook.PEERPODS_AUDIT_ANNOTATION+" annotation of the pods.")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "",
+		"Label selector of the namespaces whose peer pods are mutated, e.g. peerpods=enabled. Defaults to all namespaces.")
+	flag.StringVar(&objectSelector, "object-selector", "",
+		"Label selector of the peer pods that are mutated. Defaults to all peer pods. Pods with the label "+
+			mutating_webhook.PEERPODS_OPT_OUT_LABEL+"="+mutating_webhook.PEERPODS_OPT_OUT_VALUE+" are never mutated.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -85,14 +103,53 @@ func main() {
 		os.Exit(1)
 	}
 
+	webhookMode, err := mutating_webhook.ParseMode(mode)
+	if err != nil {
+		setupLog.Error(err, "unable to parse mode")
+		os.Exit(1)
+	}
+	setupLog.Info("Webhook mode", "mode", webhookMode)
+
+	selector, err := mutating_webhook.ParseSelector(namespaceSelector, objectSelector)
+	if err != nil {
+		setupLog.Error(err, "unable to parse selectors")
+		os.Exit(1)
+	}
+	setupLog.Info("Mutating selected peer pods", "selector", selector.String())
+
+	config := mutating_webhook.DefaultConfig()
+	if runtimeClassConfig != "" {
+		if config, err = mutating_webhook.LoadConfig(runtimeClassConfig); err != nil {
+			setupLog.Error(err, "unable to load RuntimeClass config")
+			os.Exit(1)
+		}
+	}
+	for name, runtimeClass := range config.RuntimeClasses {
+		setupLog.Info("Mutating pods of RuntimeClass", "runtimeClass", name, "extendedResource", runtimeClass.ExtendedResource)
+	}
+	for namespace := range config.Policies {
+		setupLog.Info("Validating peer pods with policy", "namespace", namespace)
+	}
+
 	setupLog.Info("Setting up webhook server")
 	podMutator := &mutating_webhook.PodMutator{
-		Client:  mgr.GetClient(),
-		Decoder: admission.NewDecoder(mgr.GetScheme()),
+		Client:            mgr.GetClient(),
+		Decoder:           admission.NewDecoder(mgr.GetScheme()),
+		Config:            config,
+		NamespaceDefaults: namespaceDefaults,
+		Mode:              webhookMode,
+		Selector:          selector,
 	}
 
 	mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{Handler: podMutator})
 
+	podValidator := &mutating_webhook.PodValidator{
+		Decoder: admission.NewDecoder(mgr.GetScheme()),
+		Config:  config,
+		Mode:    webhookMode,
+	}
+	mgr.GetWebhookServer().Register("/validate-v1-pod", &webhook.Admission{Handler: podValidator})
+
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,79 @@
+package mutating_webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Mode is how the webhook handles the pods it mutates
+type Mode string
+
+const (
+	// ModeEnforce mutates the pods
+	ModeEnforce Mode = "enforce"
+	// ModeAudit only logs the mutations of the pods, and records them in PEERPODS_AUDIT_ANNOTATION
+	ModeAudit Mode = "audit"
+)
+
+const PEERPODS_AUDIT_ANNOTATION = "io.confidentialcontainers.org.peerpods.webhook_audit"
+
+// ParseMode parses the mode of the webhook
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case ModeEnforce, ModeAudit:
+		return Mode(mode), nil
+	}
+	return "", fmt.Errorf("invalid mode %q: must be %s or %s", mode, ModeEnforce, ModeAudit)
+}
+
+// auditRecord is the value of PEERPODS_AUDIT_ANNOTATION
+type auditRecord struct {
+	// Patch is the JSON patch that would be applied to the pod
+	Patch []jsonpatch.JsonPatchOperation `json:"patch,omitempty"`
+	// Error is why the pod would be rejected
+	Error string `json:"error,omitempty"`
+}
+
+// audit logs what would be done to a pod, and records it in an annotation of the pod instead.
+// The pod is the original pod of the request, without any mutation
+func (a *PodMutator) audit(req admission.Request, pod *corev1.Pod, record auditRecord) admission.Response {
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName + "*"
+	}
+
+	if len(record.Patch) == 0 && record.Error == "" {
+		logger.Printf("Audit: pod %s/%s would not be mutated", req.Namespace, name)
+		return admission.Allowed("audit: no mutation")
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if record.Error != "" {
+		logger.Printf("Audit: pod %s/%s would be rejected: %s", req.Namespace, name, record.Error)
+	} else {
+		logger.Printf("Audit: pod %s/%s would be mutated with patch %s", req.Namespace, name, value)
+	}
+
+	// Record the audit in the pod, which is its only mutation
+	auditedPod := pod.DeepCopy()
+	annotations := auditedPod.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[PEERPODS_AUDIT_ANNOTATION] = string(value)
+	auditedPod.SetAnnotations(annotations)
+
+	marshaledPod, err := json.Marshal(auditedPod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+}
@@ -0,0 +1,132 @@
+package mutating_webhook
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	PEERPODS_INSTANCE_TYPE_ANNOTATION = "io.katacontainers.config.hypervisor.machine_type"
+	PEERPODS_IMAGE_ANNOTATION         = "io.katacontainers.config.hypervisor.image"
+)
+
+// RuntimeClassConfig is how the pods of a RuntimeClass of peer pods are mutated
+type RuntimeClassConfig struct {
+	// ExtendedResource is the extended resource requested by the pods. Defaults to POD_VM_EXTENDED_RESOURCE_DEFAULT
+	ExtendedResource string `json:"extendedResource,omitempty"`
+	// InstanceType and Image are the defaults of the instance type and image of the pod VMs
+	InstanceType string `json:"instanceType,omitempty"`
+	Image        string `json:"image,omitempty"`
+	// VCPUs, Memory in MiB and GPUs are the defaults for pods without resource requests and limits
+	VCPUs  int64 `json:"vcpus,omitempty"`
+	Memory int64 `json:"memory,omitempty"`
+	GPUs   int64 `json:"gpus,omitempty"`
+	// GPUModel is the default model of the GPUs of the pod VMs, e.g. A10G
+	GPUModel string `json:"gpuModel,omitempty"`
+	// Annotations are the defaults of other annotations of the pods
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Config is the configuration of the RuntimeClasses of peer pods by their names
+type Config struct {
+	RuntimeClasses map[string]RuntimeClassConfig `json:"runtimeClasses"`
+	// Policies are the policies of the peer pods of namespaces by their names.
+	// The policy DEFAULT_POLICY_NAMESPACE applies to the namespaces without their own policy
+	Policies map[string]Policy `json:"policies,omitempty"`
+}
+
+// DefaultConfig returns the configuration of a single RuntimeClass from the environment variables
+// TARGET_RUNTIMECLASS and POD_VM_EXTENDED_RESOURCE
+func DefaultConfig() *Config {
+	runtimeClassName := os.Getenv("TARGET_RUNTIMECLASS")
+	if runtimeClassName == "" {
+		runtimeClassName = RUNTIME_CLASS_NAME_DEFAULT
+	}
+	extendedResource := os.Getenv("POD_VM_EXTENDED_RESOURCE")
+	if extendedResource == "" {
+		extendedResource = POD_VM_EXTENDED_RESOURCE_DEFAULT
+	}
+
+	return &Config{
+		RuntimeClasses: map[string]RuntimeClassConfig{
+			runtimeClassName: {ExtendedResource: extendedResource},
+		},
+	}
+}
+
+// LoadConfig reads the configuration of the RuntimeClasses of peer pods from a YAML or JSON file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(config.RuntimeClasses) == 0 {
+		return nil, fmt.Errorf("%s has no runtimeClasses", path)
+	}
+
+	for name, rc := range config.RuntimeClasses {
+		if rc.ExtendedResource == "" {
+			rc.ExtendedResource = POD_VM_EXTENDED_RESOURCE_DEFAULT
+		}
+		// Extended resources are qualified with a domain
+		if errs := validation.IsQualifiedName(rc.ExtendedResource); len(errs) > 0 || !strings.Contains(rc.ExtendedResource, "/") {
+			return nil, fmt.Errorf("RuntimeClass %s has invalid extendedResource %q: must be a domain-prefixed name, e.g. %s",
+				name, rc.ExtendedResource, POD_VM_EXTENDED_RESOURCE_DEFAULT)
+		}
+		if rc.VCPUs < 0 || rc.Memory < 0 || rc.GPUs < 0 {
+			return nil, fmt.Errorf("RuntimeClass %s has negative resources", name)
+		}
+		config.RuntimeClasses[name] = rc
+	}
+
+	for namespace, policy := range config.Policies {
+		if policy.MaxGPUs != nil && *policy.MaxGPUs < 0 {
+			return nil, fmt.Errorf("policy of namespace %s has negative maxGPUs", namespace)
+		}
+	}
+
+	return config, nil
+}
+
+// applyDefaults sets the annotations that a pod doesn't have to the defaults of its RuntimeClass
+func (rc RuntimeClassConfig) applyDefaults(annotations map[string]string) {
+	defaults := map[string]string{}
+	for key, value := range rc.Annotations {
+		defaults[key] = value
+	}
+	if rc.InstanceType != "" {
+		defaults[PEERPODS_INSTANCE_TYPE_ANNOTATION] = rc.InstanceType
+	}
+	if rc.Image != "" {
+		defaults[PEERPODS_IMAGE_ANNOTATION] = rc.Image
+	}
+	if rc.VCPUs > 0 {
+		defaults[PEERPODS_CPU_ANNOTATION] = strconv.FormatInt(rc.VCPUs, 10)
+	}
+	if rc.Memory > 0 {
+		defaults[PEERPODS_MEMORY_ANNOTATION] = strconv.FormatInt(rc.Memory, 10)
+	}
+	if rc.GPUs > 0 {
+		defaults[PEERPODS_GPU_ANNOTATION] = strconv.FormatInt(rc.GPUs, 10)
+	}
+	if rc.GPUModel != "" {
+		defaults[PEERPODS_GPU_MODEL_ANNOTATION] = rc.GPUModel
+	}
+
+	for key, value := range defaults {
+		if _, ok := annotations[key]; !ok {
+			logger.Printf("Adding default annotation %s: %s", key, value)
+			annotations[key] = value
+		}
+	}
+}
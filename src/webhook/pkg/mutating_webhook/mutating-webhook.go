@@ -32,6 +32,15 @@ import (
 type PodMutator struct {
 	Client  client.Client
 	Decoder *admission.Decoder
+	// Config of the RuntimeClasses of peer pods. DefaultConfig is used if it is nil
+	Config *Config
+	// NamespaceDefaults enables the defaults of the RuntimeClass and the annotations of pods from the annotations
+	// of their namespace
+	NamespaceDefaults bool
+	// Mode of the webhook. ModeEnforce is used if it is empty
+	Mode Mode
+	// Selector of the peer pods that are mutated. All peer pods are mutated if it is empty
+	Selector Selector
 }
 
 // podMutator adds peer-pod extended resource to the pod spec add removes all other resource specs
@@ -43,11 +52,36 @@ func (a *PodMutator) Handle(ctx context.Context, req admission.Request) admissio
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
+	originalPod := pod.DeepCopy()
+
+	// Pods are created without their namespace, which is the namespace of the request
+	err = a.applyNamespaceRuntimeClass(ctx, pod, req)
+	selected := false
+	if err == nil {
+		selected, err = a.selected(ctx, pod, req.Namespace)
+	}
+	if err == nil && !selected {
+		return admission.Allowed("pod is not selected")
+	}
+	if err == nil {
+		err = a.applyNamespaceDefaults(ctx, pod, req.Namespace)
+	}
+	if err != nil {
+		if a.Mode == ModeAudit {
+			return a.audit(req, originalPod, auditRecord{Error: err.Error()})
+		}
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
 	mutatedPod, _ := a.mutatePod(pod)
 	marshaledPod, err := json.Marshal(mutatedPod)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	response := admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	if a.Mode == ModeAudit && response.Allowed {
+		return a.audit(req, originalPod, auditRecord{Patch: response.Patches})
+	}
+	return response
 }
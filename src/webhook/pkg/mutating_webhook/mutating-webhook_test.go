@@ -1,11 +1,23 @@
 package mutating_webhook
 
 import (
+	"context"
+	"encoding/json"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 func TestMutatePod_CpuMemReqLimit(t *testing.T) {
@@ -789,3 +801,665 @@ func TestMutatePod_FractionalCpu_MultipleContainers(t *testing.T) {
 			mutatedPod.Spec.Containers[0].Resources.Requests[corev1.ResourceName(POD_VM_EXTENDED_RESOURCE_DEFAULT)])
 	}
 }
+
+// Add test case with defaults of multiple runtime classes
+func TestMutatePod_RuntimeClassDefaults(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configYAML := `
+runtimeClasses:
+  kata-remote:
+    instanceType: m6a.large
+  kata-remote-gpu:
+    extendedResource: kata.peerpods.io/gpu-vm
+    instanceType: g5.xlarge
+    image: ami-gpu
+    vcpus: 4
+    memory: 16384
+    gpus: 1
+    annotations:
+      io.katacontainers.config.hypervisor.kernel_params: "agent.log=debug"
+`
+	if err := os.WriteFile(configPath, []byte(configYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.RuntimeClasses["kata-remote"].ExtendedResource != POD_VM_EXTENDED_RESOURCE_DEFAULT {
+		t.Errorf("Expected default extended resource, got %s", config.RuntimeClasses["kata-remote"].ExtendedResource)
+	}
+
+	// Create a sample pod spec without resources, overriding the default image
+	runtimeClassName := "kata-remote-gpu"
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{PEERPODS_IMAGE_ANNOTATION: "ami-custom"},
+		},
+		Spec: corev1.PodSpec{
+			RuntimeClassName: &runtimeClassName,
+			Containers: []corev1.Container{
+				{
+					Name:  "container1",
+					Image: "busybox",
+				},
+			},
+		},
+	}
+
+	podMutator := &PodMutator{Config: config}
+	mutatedPod, err := podMutator.mutatePod(pod)
+	if err != nil {
+		t.Fatalf("mutatePod() error = %v", err)
+	}
+
+	expected := map[string]string{
+		PEERPODS_INSTANCE_TYPE_ANNOTATION:                   "g5.xlarge",
+		PEERPODS_IMAGE_ANNOTATION:                           "ami-custom",
+		PEERPODS_CPU_ANNOTATION:                             "4",
+		PEERPODS_MEMORY_ANNOTATION:                          "16384",
+		PEERPODS_GPU_ANNOTATION:                             "1",
+		"io.katacontainers.config.hypervisor.kernel_params": "agent.log=debug",
+	}
+	if !reflect.DeepEqual(mutatedPod.Annotations, expected) {
+		t.Errorf("Expected annotations %v, got %v", expected, mutatedPod.Annotations)
+	}
+
+	expectedResource := resource.MustParse("1")
+	if !mutatedPod.Spec.Containers[0].Resources.Requests[corev1.ResourceName("kata.peerpods.io/gpu-vm")].Equal(expectedResource) {
+		t.Errorf("Expected peer-pod GPU VM resource request to be 1, got %v", mutatedPod.Spec.Containers[0].Resources.Requests)
+	}
+
+	// Pods of other runtime classes are not mutated
+	otherRuntimeClassName := "kata-qemu"
+	pod.Spec.RuntimeClassName = &otherRuntimeClassName
+	if mutatedPod, _ = podMutator.mutatePod(pod); len(mutatedPod.Spec.Containers[0].Resources.Requests) != 0 {
+		t.Errorf("Expected no resources for runtime class %s, got %v", otherRuntimeClassName, mutatedPod.Spec.Containers[0].Resources.Requests)
+	}
+}
+
+// Add test case with invalid runtime class configs
+func TestLoadConfig_Invalid(t *testing.T) {
+	for name, configYAML := range map[string]string{
+		"no runtime classes":          "runtimeClasses: {}\n",
+		"unknown field":               "runtimeClasses:\n  kata-remote:\n    machineType: m6a.large\n",
+		"unqualified resource":        "runtimeClasses:\n  kata-remote:\n    extendedResource: vm\n",
+		"negative resources":          "runtimeClasses:\n  kata-remote:\n    vcpus: -1\n",
+		"invalid resource characters": "runtimeClasses:\n  kata-remote:\n    extendedResource: kata.peerpods.io/vm gpu\n",
+		"negative maxGPUs":            "runtimeClasses:\n  kata-remote: {}\npolicies:\n  default:\n    maxGPUs: -1\n",
+	} {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configPath, []byte(configYAML), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(configPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// Add test case with defaults of the namespace of a pod
+func TestMutatePod_NamespaceDefaults(t *testing.T) {
+	tenantA := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "tenant-a",
+			Annotations: map[string]string{
+				PEERPODS_INSTANCE_TYPE_ANNOTATION: "m6a.xlarge",
+				PEERPODS_CPU_ANNOTATION:           "4",
+				PEERPODS_INITDATA_ANNOTATION:      "initdata",
+				// Annotations that cloud-api-adaptor reads from the namespace itself are not added to the pods
+				"io.confidentialcontainers.org.peerpods.kbs_url": "https://kbs.tenant-a.example.com",
+			},
+		},
+	}
+	defaultNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+
+	podMutator := &PodMutator{
+		Client: fake.NewClientBuilder().WithObjects(tenantA, defaultNamespace).Build(),
+		Config: &Config{
+			RuntimeClasses: map[string]RuntimeClassConfig{
+				"kata-remote": {ExtendedResource: POD_VM_EXTENDED_RESOURCE_DEFAULT, InstanceType: "m6a.large", Image: "ami-default"},
+			},
+		},
+		NamespaceDefaults: true,
+	}
+
+	// Create a sample pod spec with a memory limit and an explicit initdata annotation
+	runtimeClassName := "kata-remote"
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{PEERPODS_INITDATA_ANNOTATION: "pod"},
+			},
+			Spec: corev1.PodSpec{
+				RuntimeClassName: &runtimeClassName,
+				Containers: []corev1.Container{
+					{
+						Name:  "container1",
+						Image: "busybox",
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceCPU: resource.MustParse("2"),
+							},
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU: resource.MustParse("2"),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	pod := newPod()
+	if err := podMutator.applyNamespaceDefaults(context.Background(), pod, "tenant-a"); err != nil {
+		t.Fatalf("applyNamespaceDefaults() error = %v", err)
+	}
+	mutatedPod, err := podMutator.mutatePod(pod)
+	if err != nil {
+		t.Fatalf("mutatePod() error = %v", err)
+	}
+
+	// Annotations of the pod take precedence over the defaults of the namespace,
+	// which take precedence over the defaults of the runtime class
+	expected := map[string]string{
+		PEERPODS_INITDATA_ANNOTATION:      "pod",
+		PEERPODS_INSTANCE_TYPE_ANNOTATION: "m6a.xlarge",
+		PEERPODS_IMAGE_ANNOTATION:         "ami-default",
+		PEERPODS_CPU_ANNOTATION:           "2",
+	}
+	if !reflect.DeepEqual(mutatedPod.Annotations, expected) {
+		t.Errorf("Expected annotations %v, got %v", expected, mutatedPod.Annotations)
+	}
+
+	// Namespaces without defaults are ignored
+	pod = newPod()
+	if err := podMutator.applyNamespaceDefaults(context.Background(), pod, "default"); err != nil {
+		t.Fatalf("applyNamespaceDefaults() error = %v", err)
+	}
+	if len(pod.Annotations) != 1 {
+		t.Errorf("Expected no defaults, got %v", pod.Annotations)
+	}
+
+	// Unknown namespaces are errors
+	if err := podMutator.applyNamespaceDefaults(context.Background(), newPod(), "unknown"); err == nil {
+		t.Error("Expected an error for an unknown namespace")
+	}
+
+	// Pods of other runtime classes are not mutated
+	pod = newPod()
+	otherRuntimeClassName := "kata-qemu"
+	pod.Spec.RuntimeClassName = &otherRuntimeClassName
+	if err := podMutator.applyNamespaceDefaults(context.Background(), pod, "tenant-a"); err != nil {
+		t.Fatalf("applyNamespaceDefaults() error = %v", err)
+	}
+	if len(pod.Annotations) != 1 {
+		t.Errorf("Expected no defaults for runtime class %s, got %v", otherRuntimeClassName, pod.Annotations)
+	}
+
+	// Namespace defaults are disabled
+	pod = newPod()
+	if err := (&PodMutator{Config: podMutator.Config}).applyNamespaceDefaults(context.Background(), pod, "tenant-a"); err != nil {
+		t.Fatalf("applyNamespaceDefaults() error = %v", err)
+	}
+	if len(pod.Annotations) != 1 {
+		t.Errorf("Expected no defaults when they are disabled, got %v", pod.Annotations)
+	}
+}
+
+// Add test case with the default RuntimeClass of a namespace
+func TestHandle_NamespaceRuntimeClass(t *testing.T) {
+	namespaces := []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Annotations: map[string]string{PEERPODS_RUNTIME_CLASS_ANNOTATION: "kata-remote-azure"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-b", Annotations: map[string]string{PEERPODS_RUNTIME_CLASS_ANNOTATION: "runc"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	}
+	podMutator := &PodMutator{
+		Client:  fake.NewClientBuilder().WithObjects(namespaces...).Build(),
+		Decoder: admission.NewDecoder(runtime.NewScheme()),
+		Config: &Config{
+			RuntimeClasses: map[string]RuntimeClassConfig{
+				"kata-remote":       {ExtendedResource: POD_VM_EXTENDED_RESOURCE_DEFAULT},
+				"kata-remote-azure": {ExtendedResource: POD_VM_EXTENDED_RESOURCE_DEFAULT},
+			},
+		},
+		NamespaceDefaults: true,
+	}
+
+	handle := func(namespace string, operation admissionv1.Operation, runtimeClassName *string, podLabels map[string]string) admission.Response {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: namespace, Labels: podLabels},
+			Spec: corev1.PodSpec{
+				RuntimeClassName: runtimeClassName,
+				Containers:       []corev1.Container{{Name: "container1", Image: "busybox"}},
+			},
+		}
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return podMutator.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Namespace: namespace,
+				Operation: operation,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+	}
+	runtimeClassPatch := func(response admission.Response) interface{} {
+		for _, patch := range response.Patches {
+			if patch.Path == "/spec/runtimeClassName" {
+				return patch.Value
+			}
+		}
+		return nil
+	}
+
+	response := handle("tenant-a", admissionv1.Create, nil, nil)
+	if !response.Allowed || runtimeClassPatch(response) != "kata-remote-azure" {
+		t.Errorf("Expected the RuntimeClass of the namespace, got %+v", response)
+	}
+
+	// The RuntimeClass of a pod takes precedence
+	runtimeClassName := "kata-remote"
+	if response := handle("tenant-a", admissionv1.Create, &runtimeClassName, nil); !response.Allowed || runtimeClassPatch(response) != nil {
+		t.Errorf("Expected the RuntimeClass of the pod, got %+v", response)
+	}
+
+	// The RuntimeClass of a pod can't be changed after it is created
+	if response := handle("tenant-a", admissionv1.Update, nil, nil); !response.Allowed || len(response.Patches) != 0 {
+		t.Errorf("Expected no patch for an update, got %+v", response)
+	}
+
+	// Opted out pods and pods of namespaces without a RuntimeClass are not mutated
+	optOut := map[string]string{PEERPODS_OPT_OUT_LABEL: PEERPODS_OPT_OUT_VALUE}
+	if response := handle("tenant-a", admissionv1.Create, nil, optOut); !response.Allowed || len(response.Patches) != 0 {
+		t.Errorf("Expected no patch for an opted out pod, got %+v", response)
+	}
+	if response := handle("default", admissionv1.Create, nil, nil); !response.Allowed || len(response.Patches) != 0 {
+		t.Errorf("Expected no patch without a default RuntimeClass, got %+v", response)
+	}
+
+	// A RuntimeClass that is not a RuntimeClass of peer pods is rejected
+	if response := handle("tenant-b", admissionv1.Create, nil, nil); response.Allowed {
+		t.Errorf("Expected the pod to be rejected, got %+v", response)
+	}
+}
+
+// Add test case with a standard GPU pod spec
+func TestMutatePod_GPUModelNodeSelector(t *testing.T) {
+	runtimeClassName := "kata-remote"
+	os.Setenv("TARGET_RUNTIMECLASS", runtimeClassName)
+
+	// Create a sample pod spec with GPU limits only, a GPU init container and a GPU model node selector
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			RuntimeClassName: &runtimeClassName,
+			NodeSelector: map[string]string{
+				GPU_PRODUCT_LABEL:        "NVIDIA-A10G",
+				"kubernetes.io/hostname": "worker-0",
+			},
+			InitContainers: []corev1.Container{
+				{
+					Name:  "init1",
+					Image: "busybox",
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceName(GPU_RESOURCE_NAME): resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:  "container1",
+					Image: "busybox",
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceName(GPU_RESOURCE_NAME): resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					Name:  "container2",
+					Image: "busybox",
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceName(GPU_RESOURCE_NAME): resource.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	podMutator := &PodMutator{}
+	mutatedPod, err := podMutator.mutatePod(pod)
+	if err != nil {
+		t.Fatalf("mutatePod() error = %v", err)
+	}
+
+	expected := map[string]string{
+		PEERPODS_GPU_ANNOTATION:       "2",
+		PEERPODS_GPU_MODEL_ANNOTATION: "NVIDIA-A10G",
+	}
+	if !reflect.DeepEqual(mutatedPod.Annotations, expected) {
+		t.Errorf("Expected annotations %v, got %v", expected, mutatedPod.Annotations)
+	}
+
+	// The GPU model node selector is removed, other node selectors are kept
+	expectedNodeSelector := map[string]string{"kubernetes.io/hostname": "worker-0"}
+	if !reflect.DeepEqual(mutatedPod.Spec.NodeSelector, expectedNodeSelector) {
+		t.Errorf("Expected node selector %v, got %v", expectedNodeSelector, mutatedPod.Spec.NodeSelector)
+	}
+
+	// The GPU resources are removed
+	for _, container := range append(mutatedPod.Spec.InitContainers, mutatedPod.Spec.Containers...) {
+		if _, ok := container.Resources.Limits[corev1.ResourceName(GPU_RESOURCE_NAME)]; ok {
+			t.Errorf("Expected no GPU resources in container %s", container.Name)
+		}
+	}
+
+	// The GPU model annotation of the pod takes precedence over the node selector
+	pod.Annotations = map[string]string{PEERPODS_GPU_MODEL_ANNOTATION: "T4"}
+	if mutatedPod, _ = podMutator.mutatePod(pod); mutatedPod.Annotations[PEERPODS_GPU_MODEL_ANNOTATION] != "T4" {
+		t.Errorf("Expected GPU model annotation T4, got %s", mutatedPod.Annotations[PEERPODS_GPU_MODEL_ANNOTATION])
+	}
+}
+
+// Add test case with the audit mode
+func TestHandle_AuditMode(t *testing.T) {
+	runtimeClassName := "kata-remote"
+	os.Setenv("TARGET_RUNTIMECLASS", runtimeClassName)
+
+	// Create a sample pod spec with cpu requests
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			RuntimeClassName: &runtimeClassName,
+			Containers: []corev1.Container{
+				{
+					Name:  "container1",
+					Image: "busybox",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: "default",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	decoder := admission.NewDecoder(runtime.NewScheme())
+
+	// The enforce mode mutates the resources and the annotations
+	enforced := (&PodMutator{Decoder: decoder}).Handle(context.Background(), req)
+	if !enforced.Allowed || len(enforced.Patches) == 0 {
+		t.Fatalf("Expected the pod to be mutated, got %+v", enforced)
+	}
+
+	// The audit mode only adds the audit annotation, with the patch of the enforce mode
+	audited := (&PodMutator{Decoder: decoder, Mode: ModeAudit}).Handle(context.Background(), req)
+	if !audited.Allowed || len(audited.Patches) != 1 {
+		t.Fatalf("Expected a single patch, got %+v", audited)
+	}
+	patch := audited.Patches[0]
+	if patch.Operation != "add" || patch.Path != "/metadata/annotations" {
+		t.Fatalf("Expected the annotations to be added, got %+v", patch)
+	}
+	annotations, ok := patch.Value.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected annotations, got %v", patch.Value)
+	}
+	record := auditRecord{}
+	if err := json.Unmarshal([]byte(annotations[PEERPODS_AUDIT_ANNOTATION].(string)), &record); err != nil {
+		t.Fatalf("Invalid %s annotation: %v", PEERPODS_AUDIT_ANNOTATION, err)
+	}
+	// The operations of a patch are not ordered
+	paths := func(patch []jsonpatch.JsonPatchOperation) map[string]string {
+		operations := map[string]string{}
+		for _, operation := range patch {
+			operations[operation.Path] = operation.Operation
+		}
+		return operations
+	}
+	if !reflect.DeepEqual(paths(record.Patch), paths(enforced.Patches)) {
+		t.Errorf("Expected the audited patch %v, got %v", enforced.Patches, record.Patch)
+	}
+
+	// Pods of other runtime classes are not annotated
+	pod.Spec.RuntimeClassName = nil
+	if req.Object.Raw, err = json.Marshal(pod); err != nil {
+		t.Fatal(err)
+	}
+	if response := (&PodMutator{Decoder: decoder, Mode: ModeAudit}).Handle(context.Background(), req); !response.Allowed || len(response.Patches) != 0 {
+		t.Errorf("Expected no patch, got %+v", response.Patches)
+	}
+}
+
+// Add test case with namespace and object selectors
+func TestSelected(t *testing.T) {
+	runtimeClassName := "kata-remote"
+	os.Setenv("TARGET_RUNTIMECLASS", runtimeClassName)
+
+	enabled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "enabled", Labels: map[string]string{"peerpods": "enabled"}}}
+	disabled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "disabled"}}
+
+	selector, err := ParseSelector("peerpods=enabled", "tier!=system")
+	if err != nil {
+		t.Fatalf("ParseSelector() error = %v", err)
+	}
+	podMutator := &PodMutator{
+		Client:   fake.NewClientBuilder().WithObjects(enabled, disabled).Build(),
+		Selector: selector,
+	}
+
+	tests := []struct {
+		name             string
+		namespace        string
+		labels           map[string]string
+		runtimeClassName string
+		want             bool
+		wantErr          bool
+	}{
+		{name: "selected", namespace: "enabled", runtimeClassName: runtimeClassName, want: true},
+		{name: "namespace not selected", namespace: "disabled", runtimeClassName: runtimeClassName},
+		{name: "object not selected", namespace: "enabled", labels: map[string]string{"tier": "system"}, runtimeClassName: runtimeClassName},
+		{name: "opted out", namespace: "enabled", labels: map[string]string{PEERPODS_OPT_OUT_LABEL: PEERPODS_OPT_OUT_VALUE}, runtimeClassName: runtimeClassName},
+		{name: "other runtime class", namespace: "enabled", runtimeClassName: "kata-qemu"},
+		{name: "unknown namespace", namespace: "unknown", runtimeClassName: runtimeClassName, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod1", Labels: tt.labels},
+				Spec:       corev1.PodSpec{RuntimeClassName: &tt.runtimeClassName},
+			}
+			got, err := podMutator.selected(context.Background(), pod, tt.namespace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selected() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("selected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The opt-out label is honored without selectors
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{PEERPODS_OPT_OUT_LABEL: PEERPODS_OPT_OUT_VALUE}},
+		Spec:       corev1.PodSpec{RuntimeClassName: &runtimeClassName},
+	}
+	if got, _ := (&PodMutator{}).selected(context.Background(), pod, "default"); got {
+		t.Error("Expected the opted out pod not to be selected")
+	}
+
+	if _, err := ParseSelector("peerpods in (enabled", ""); err == nil {
+		t.Error("Expected an error for an invalid selector")
+	}
+}
+
+// Add test case with the policies of namespaces
+func TestHandle_Policy(t *testing.T) {
+	runtimeClassName := "kata-remote"
+	maxGPUs := int64(1)
+	maxVCPUs := int64(4)
+	maxMemory := int64(8192)
+	config := &Config{
+		RuntimeClasses: map[string]RuntimeClassConfig{runtimeClassName: {}},
+		Policies: map[string]Policy{
+			"team-a": {
+				InstanceTypes: []string{"t3.small", "t3.medium"},
+				Images:        []string{"ami-1234"},
+				MaxGPUs:       &maxGPUs,
+			},
+			"team-gpu": {
+				MaxGPUs:   &maxGPUs,
+				GPUModels: []string{"A10G"},
+				MaxVCPUs:  &maxVCPUs,
+				MaxMemory: &maxMemory,
+			},
+			DEFAULT_POLICY_NAMESPACE: {
+				InstanceTypes: []string{"t3.small"},
+			},
+		},
+	}
+	decoder := admission.NewDecoder(runtime.NewScheme())
+
+	handle := func(validator *PodValidator, namespace string, runtimeClassName *string, annotations map[string]string) admission.Response {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: namespace, Annotations: annotations},
+			Spec: corev1.PodSpec{
+				RuntimeClassName: runtimeClassName,
+				Containers:       []corev1.Container{{Name: "container1", Image: "busybox"}},
+			},
+		}
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return validator.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Namespace: namespace,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+	}
+
+	validator := &PodValidator{Decoder: decoder, Config: config}
+	for _, tc := range []struct {
+		name        string
+		namespace   string
+		annotations map[string]string
+		allowed     bool
+	}{
+		{"allowed", "team-a", map[string]string{PEERPODS_INSTANCE_TYPE_ANNOTATION: "t3.medium", PEERPODS_IMAGE_ANNOTATION: "ami-1234", PEERPODS_GPU_ANNOTATION: "0"}, true},
+		{"no annotations", "team-a", nil, true},
+		{"instance type", "team-a", map[string]string{PEERPODS_INSTANCE_TYPE_ANNOTATION: "p3.2xlarge"}, false},
+		{"image", "team-a", map[string]string{PEERPODS_IMAGE_ANNOTATION: "ami-5678"}, false},
+		{"GPUs", "team-a", map[string]string{PEERPODS_GPU_ANNOTATION: "2"}, false},
+		{"invalid GPUs", "team-a", map[string]string{PEERPODS_GPU_ANNOTATION: "two"}, false},
+		{"default policy", "team-b", map[string]string{PEERPODS_INSTANCE_TYPE_ANNOTATION: "t3.medium"}, false},
+		{"unrestricted image of default policy", "team-b", map[string]string{PEERPODS_IMAGE_ANNOTATION: "ami-5678"}, true},
+		// cloud-api-adaptor selects the instance type from GPUs, or from both vCPUs and memory, instead of the annotation
+		{"instance type selected by GPUs", "team-a", map[string]string{PEERPODS_INSTANCE_TYPE_ANNOTATION: "t3.medium", PEERPODS_GPU_ANNOTATION: "1"}, false},
+		{"instance type selected by vCPUs and memory", "team-a", map[string]string{PEERPODS_INSTANCE_TYPE_ANNOTATION: "t3.medium", PEERPODS_CPU_ANNOTATION: "64", PEERPODS_MEMORY_ANNOTATION: "262144"}, false},
+		{"vCPUs only", "team-a", map[string]string{PEERPODS_CPU_ANNOTATION: "2"}, true},
+		{"sizes", "team-gpu", map[string]string{PEERPODS_GPU_ANNOTATION: "1", PEERPODS_GPU_MODEL_ANNOTATION: "A10G", PEERPODS_CPU_ANNOTATION: "4", PEERPODS_MEMORY_ANNOTATION: "8192"}, true},
+		{"GPU model", "team-gpu", map[string]string{PEERPODS_GPU_ANNOTATION: "1", PEERPODS_GPU_MODEL_ANNOTATION: "H100"}, false},
+		{"vCPUs", "team-gpu", map[string]string{PEERPODS_CPU_ANNOTATION: "8"}, false},
+		{"memory", "team-gpu", map[string]string{PEERPODS_MEMORY_ANNOTATION: "16384"}, false},
+		{"invalid memory", "team-gpu", map[string]string{PEERPODS_MEMORY_ANNOTATION: "8Gi"}, false},
+	} {
+		response := handle(validator, tc.namespace, &runtimeClassName, tc.annotations)
+		if response.Allowed != tc.allowed {
+			t.Errorf("%s: expected allowed %v, got %+v", tc.name, tc.allowed, response.Result)
+		}
+		if !tc.allowed && !strings.Contains(response.Result.Message, tc.namespace) {
+			t.Errorf("%s: expected the namespace in the message, got %q", tc.name, response.Result.Message)
+		}
+	}
+
+	violation := map[string]string{PEERPODS_INSTANCE_TYPE_ANNOTATION: "p3.2xlarge", PEERPODS_GPU_ANNOTATION: "4"}
+
+	// All the violations are reported
+	response := handle(validator, "team-a", &runtimeClassName, violation)
+	if !strings.Contains(response.Result.Message, "p3.2xlarge") || !strings.Contains(response.Result.Message, "4 GPUs") {
+		t.Errorf("Expected the instance type and the GPUs in the message, got %q", response.Result.Message)
+	}
+
+	// Pods of other runtime classes are not validated
+	otherRuntimeClassName := "kata-qemu"
+	if response := handle(validator, "team-a", &otherRuntimeClassName, violation); !response.Allowed {
+		t.Errorf("Expected pod of runtime class %s to be allowed, got %+v", otherRuntimeClassName, response.Result)
+	}
+	if response := handle(validator, "team-a", nil, violation); !response.Allowed {
+		t.Errorf("Expected pod without runtime class to be allowed, got %+v", response.Result)
+	}
+
+	// Namespaces without a policy are not validated
+	delete(config.Policies, DEFAULT_POLICY_NAMESPACE)
+	if response := handle(validator, "team-b", &runtimeClassName, violation); !response.Allowed {
+		t.Errorf("Expected pod of namespace without policy to be allowed, got %+v", response.Result)
+	}
+
+	// The audit mode allows the pod with a warning
+	response = handle(&PodValidator{Decoder: decoder, Config: config, Mode: ModeAudit}, "team-a", &runtimeClassName, violation)
+	if !response.Allowed || len(response.Warnings) != 1 {
+		t.Errorf("Expected pod to be allowed with a warning, got %+v", response)
+	}
+}
+
+func TestHandle_OptOut(t *testing.T) {
+	runtimeClassName := "kata-remote"
+	validator := &PodValidator{
+		Decoder: admission.NewDecoder(runtime.NewScheme()),
+		Config: &Config{
+			RuntimeClasses: map[string]RuntimeClassConfig{runtimeClassName: {ExtendedResource: POD_VM_EXTENDED_RESOURCE_DEFAULT}},
+		},
+	}
+
+	handle := func(podLabels map[string]string, limits corev1.ResourceList) admission.Response {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default", Labels: podLabels},
+			Spec: corev1.PodSpec{
+				RuntimeClassName: &runtimeClassName,
+				Containers:       []corev1.Container{{Name: "container1", Image: "busybox", Resources: corev1.ResourceRequirements{Limits: limits}}},
+			},
+		}
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return validator.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+	}
+
+	optOut := map[string]string{PEERPODS_OPT_OUT_LABEL: PEERPODS_OPT_OUT_VALUE}
+	extendedResource := corev1.ResourceList{POD_VM_EXTENDED_RESOURCE_DEFAULT: resource.MustParse("1")}
+
+	if response := handle(optOut, nil); response.Allowed || !strings.Contains(response.Result.Message, POD_VM_EXTENDED_RESOURCE_DEFAULT) {
+		t.Errorf("Expected opted out pod without the extended resource to be rejected, got %+v", response.Result)
+	}
+	if response := handle(optOut, extendedResource); !response.Allowed {
+		t.Errorf("Expected opted out pod with the extended resource to be allowed, got %+v", response.Result)
+	}
+	// Pods that didn't opt out are mutated before they are validated
+	if response := handle(nil, nil); !response.Allowed {
+		t.Errorf("Expected pod without the label to be allowed, got %+v", response.Result)
+	}
+}
@@ -0,0 +1,100 @@
+package mutating_webhook
+
+import (
+	"context"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// PEERPODS_RUNTIME_CLASS_ANNOTATION is the annotation of a namespace with the default RuntimeClass of its pods,
+	// which selects the cloud-api-adaptor and thus the cloud provider of their pod VMs
+	PEERPODS_RUNTIME_CLASS_ANNOTATION = "io.confidentialcontainers.org.peerpods.runtime_class"
+	PEERPODS_INITDATA_ANNOTATION      = "io.katacontainers.config.runtime.cc_init_data"
+)
+
+// namespaceDefaultAnnotations are the annotations of a namespace that the webhook adds to its peer pods.
+// Kata Containers only passes the annotations of a pod to cloud-api-adaptor, which reads the other peer pod
+// annotations of a namespace, such as the KBS and the tags of the pod VMs, itself
+var namespaceDefaultAnnotations = []string{
+	PEERPODS_INSTANCE_TYPE_ANNOTATION,
+	PEERPODS_IMAGE_ANNOTATION,
+	PEERPODS_CPU_ANNOTATION,
+	PEERPODS_MEMORY_ANNOTATION,
+	PEERPODS_GPU_ANNOTATION,
+	PEERPODS_GPU_MODEL_ANNOTATION,
+	PEERPODS_INITDATA_ANNOTATION,
+}
+
+// namespaceAnnotations returns the annotations of a namespace. Namespaces are cluster-scoped, so only cluster admins,
+// and not the users of a namespace, can usually set them
+func (a *PodMutator) namespaceAnnotations(ctx context.Context, namespace string) (map[string]string, error) {
+	ns := &corev1.Namespace{}
+	if err := a.Client.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return nil, fmt.Errorf("getting namespace %s: %w", namespace, err)
+	}
+	return ns.GetAnnotations(), nil
+}
+
+// applyNamespaceRuntimeClass sets the RuntimeClass of a pod that is created without one to the default RuntimeClass
+// of its namespace. The RuntimeClass of a pod can't be changed after it is created
+func (a *PodMutator) applyNamespaceRuntimeClass(ctx context.Context, pod *corev1.Pod, req admission.Request) error {
+	if !a.NamespaceDefaults || req.Operation != admissionv1.Create || req.Namespace == "" || pod.Spec.RuntimeClassName != nil {
+		return nil
+	}
+	if pod.GetLabels()[PEERPODS_OPT_OUT_LABEL] == PEERPODS_OPT_OUT_VALUE {
+		return nil
+	}
+
+	annotations, err := a.namespaceAnnotations(ctx, req.Namespace)
+	if err != nil {
+		return err
+	}
+	runtimeClassName, ok := annotations[PEERPODS_RUNTIME_CLASS_ANNOTATION]
+	if !ok {
+		return nil
+	}
+	pod.Spec.RuntimeClassName = &runtimeClassName
+	if _, ok := a.runtimeClass(pod); !ok {
+		return fmt.Errorf("%s annotation of namespace %s: %q is not a RuntimeClass of peer pods", PEERPODS_RUNTIME_CLASS_ANNOTATION, req.Namespace, runtimeClassName)
+	}
+	logger.Printf("Setting RuntimeClass of pod %s/%s to the default of its namespace: %s", req.Namespace, pod.Name, runtimeClassName)
+	return nil
+}
+
+// applyNamespaceDefaults sets the annotations that a peer pod doesn't have to the annotations of its namespace.
+// The defaults of a namespace take precedence over the defaults of the runtimeClass of the pod
+func (a *PodMutator) applyNamespaceDefaults(ctx context.Context, pod *corev1.Pod, namespace string) error {
+	if !a.NamespaceDefaults || namespace == "" {
+		return nil
+	}
+	if _, ok := a.runtimeClass(pod); !ok {
+		return nil
+	}
+
+	nsAnnotations, err := a.namespaceAnnotations(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	annotations := pod.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for _, key := range namespaceDefaultAnnotations {
+		value, ok := nsAnnotations[key]
+		if _, set := annotations[key]; ok && !set {
+			logger.Printf("Adding default annotation of namespace %s %s: %s", namespace, key, value)
+			annotations[key] = value
+		}
+	}
+	if len(annotations) > 0 {
+		pod.SetAnnotations(annotations)
+	}
+
+	return nil
+}
@@ -2,7 +2,6 @@ package mutating_webhook
 
 import (
 	"log"
-	"os"
 	"strconv"
 
 	"github.com/confidential-containers/cloud-api-adaptor/src/webhook/pkg/utils"
@@ -18,6 +17,9 @@ const (
 	PEERPODS_MEMORY_ANNOTATION       = "io.katacontainers.config.hypervisor.default_memory"
 	GPU_RESOURCE_NAME                = "nvidia.com/gpu"
 	PEERPODS_GPU_ANNOTATION          = "io.katacontainers.config.hypervisor.default_gpus"
+	PEERPODS_GPU_MODEL_ANNOTATION    = "io.katacontainers.config.hypervisor.default_gpu_model"
+	// GPU_PRODUCT_LABEL is the node label of the model of the GPUs set by NVIDIA GPU feature discovery
+	GPU_PRODUCT_LABEL = "nvidia.com/gpu.product"
 )
 
 var logger = log.New(log.Writer(), "[pod-mutator] ", log.LstdFlags|log.Lmsgprefix)
@@ -25,27 +27,38 @@ var logger = log.New(log.Writer(), "[pod-mutator] ", log.LstdFlags|log.Lmsgprefi
 // mutate POD spec
 // remove the POD resource spec
 func (a *PodMutator) mutatePod(pod *corev1.Pod) (*corev1.Pod, error) {
-	var runtimeClassName string
 	mpod := pod.DeepCopy()
 
-	if runtimeClassName = os.Getenv("TARGET_RUNTIMECLASS"); runtimeClassName == "" {
-		runtimeClassName = RUNTIME_CLASS_NAME_DEFAULT
-	}
-	// Mutate only if the POD is using specific runtimeClass
-	if mpod.Spec.RuntimeClassName == nil || *mpod.Spec.RuntimeClassName != runtimeClassName {
+	// Mutate only if the POD is using a runtimeClass of peer pods
+	runtimeClass, ok := a.runtimeClass(mpod)
+	if !ok {
 		return mpod, nil
 	}
 
-	mpod = adjustResourceSpec(mpod)
+	mpod = adjustResourceSpec(mpod, runtimeClass)
 
 	return mpod, nil
 }
 
+// runtimeClass returns the config of the runtimeClass of a POD, if it is a runtimeClass of peer pods
+func (a *PodMutator) runtimeClass(pod *corev1.Pod) (RuntimeClassConfig, bool) {
+	config := a.Config
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if pod.Spec.RuntimeClassName == nil {
+		return RuntimeClassConfig{}, false
+	}
+	runtimeClass, ok := config.RuntimeClasses[*pod.Spec.RuntimeClassName]
+	return runtimeClass, ok
+}
+
 // function to remove resource spec from the pod spec
 // add the cumulative resources as annotation to pod spec
 // add the peer-pod resource to the first container in the pod spec
+// add the defaults of the runtimeClass as annotations to pod spec
 
-func adjustResourceSpec(pod *corev1.Pod) *corev1.Pod {
+func adjustResourceSpec(pod *corev1.Pod, runtimeClass RuntimeClassConfig) *corev1.Pod {
 
 	// Get total CPU resource requests
 	cpuRequest := utils.GetResourceRequestQuantity(pod, corev1.ResourceCPU)
@@ -59,10 +72,11 @@ func adjustResourceSpec(pod *corev1.Pod) *corev1.Pod {
 	// Get total Memory resource limits
 	memoryLimit := utils.GetResourceLimitQuantity(pod, corev1.ResourceMemory)
 
-	// Get total GPU resource requests
-	// GPU resources are always requested in whole numbers and request and limits are same.
-	// So we don't need to check for limits
-	gpuRequest := utils.GetResourceRequestQuantity(pod, corev1.ResourceName(GPU_RESOURCE_NAME))
+	// Get total GPU resources
+	// GPU resources are always requested in whole numbers and request and limits are same,
+	// but the request is only defaulted to the limit after the webhook.
+	// Init containers don't add up with the containers since they don't run at the same time
+	gpuRequest := utils.GetEffectiveResourceQuantity(pod, corev1.ResourceName(GPU_RESOURCE_NAME))
 
 	// log the resource values
 	logger.Printf("CPU Request: %s, CPU Limit: %s, Memory Request: %s, Memory Limit: %s, GPU Request: %s",
@@ -113,6 +127,20 @@ func adjustResourceSpec(pod *corev1.Pod) *corev1.Pod {
 		annotations[PEERPODS_GPU_ANNOTATION] = gpuRequest.String()
 	}
 
+	// Add GPU model annotation
+	// The worker nodes don't have the GPUs of the peer pods, so the GPU model node selector
+	// is replaced with the annotation, which takes precedence over it
+	if gpuModel, ok := pod.Spec.NodeSelector[GPU_PRODUCT_LABEL]; ok {
+		if _, ok := annotations[PEERPODS_GPU_MODEL_ANNOTATION]; !ok && gpuModel != "" {
+			logger.Printf("Adding GPU model annotation based on %s node selector: %s", GPU_PRODUCT_LABEL, gpuModel)
+			annotations[PEERPODS_GPU_MODEL_ANNOTATION] = gpuModel
+		}
+		delete(pod.Spec.NodeSelector, GPU_PRODUCT_LABEL)
+	}
+
+	// Annotations of the pod take precedence over the defaults of the runtimeClass
+	runtimeClass.applyDefaults(annotations)
+
 	pod.SetAnnotations(annotations)
 
 	// Remove all resource specs
@@ -125,18 +153,17 @@ func adjustResourceSpec(pod *corev1.Pod) *corev1.Pod {
 	}
 
 	// Add peer-pod resource to one container
-	pod.Spec.Containers[0].Resources = defaultContainerResourceRequirements()
+	pod.Spec.Containers[0].Resources = defaultContainerResourceRequirements(runtimeClass.ExtendedResource)
 	return pod
 }
 
 // defaultContainerResourceRequirements returns the default requirements for a container
-func defaultContainerResourceRequirements() corev1.ResourceRequirements {
+func defaultContainerResourceRequirements(podVmExtResource string) corev1.ResourceRequirements {
 	requirements := corev1.ResourceRequirements{}
 	requirements.Requests = corev1.ResourceList{}
 	requirements.Limits = corev1.ResourceList{}
 
-	var podVmExtResource string
-	if podVmExtResource = os.Getenv("POD_VM_EXTENDED_RESOURCE"); podVmExtResource == "" {
+	if podVmExtResource == "" {
 		podVmExtResource = POD_VM_EXTENDED_RESOURCE_DEFAULT
 	}
 
@@ -0,0 +1,81 @@
+package mutating_webhook
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// PEERPODS_OPT_OUT_LABEL opts a pod out of the webhook when it is set to PEERPODS_OPT_OUT_VALUE,
+	// even if the pod and its namespace are selected
+	PEERPODS_OPT_OUT_LABEL = "kata.peerpods.io/mutate"
+	PEERPODS_OPT_OUT_VALUE = "false"
+)
+
+// Selector selects the peer pods that the webhook mutates by the labels of their namespace and their own labels,
+// like the namespaceSelector and objectSelector of the registration of a webhook
+type Selector struct {
+	// Namespace selects the namespaces of the pods. All namespaces are selected if it is nil
+	Namespace labels.Selector
+	// Object selects the pods. All pods are selected if it is nil
+	Object labels.Selector
+}
+
+// ParseSelector parses the label selectors of namespaces and pods, e.g. "peerpods=enabled,tier!=system".
+// An empty label selector selects everything
+func ParseSelector(namespaceSelector, objectSelector string) (Selector, error) {
+	selector := Selector{}
+	var err error
+	if namespaceSelector != "" {
+		if selector.Namespace, err = labels.Parse(namespaceSelector); err != nil {
+			return Selector{}, fmt.Errorf("invalid namespace selector %q: %w", namespaceSelector, err)
+		}
+	}
+	if objectSelector != "" {
+		if selector.Object, err = labels.Parse(objectSelector); err != nil {
+			return Selector{}, fmt.Errorf("invalid object selector %q: %w", objectSelector, err)
+		}
+	}
+	return selector, nil
+}
+
+// String returns the label selectors of the selector
+func (s Selector) String() string {
+	selectorString := func(selector labels.Selector) string {
+		if selector == nil || selector.Empty() {
+			return "<all>"
+		}
+		return selector.String()
+	}
+	return fmt.Sprintf("namespace: %s, object: %s", selectorString(s.Namespace), selectorString(s.Object))
+}
+
+// selected returns whether a pod is a peer pod that the webhook mutates.
+// The labels of the namespace are only read if the pod is a peer pod and its own labels are selected
+func (a *PodMutator) selected(ctx context.Context, pod *corev1.Pod, namespace string) (bool, error) {
+	if _, ok := a.runtimeClass(pod); !ok {
+		return false, nil
+	}
+
+	podLabels := labels.Set(pod.GetLabels())
+	if podLabels[PEERPODS_OPT_OUT_LABEL] == PEERPODS_OPT_OUT_VALUE {
+		logger.Printf("Pod %s/%s opted out with label %s=%s", namespace, pod.Name, PEERPODS_OPT_OUT_LABEL, PEERPODS_OPT_OUT_VALUE)
+		return false, nil
+	}
+	if a.Selector.Object != nil && !a.Selector.Object.Matches(podLabels) {
+		return false, nil
+	}
+
+	if a.Selector.Namespace == nil || a.Selector.Namespace.Empty() {
+		return true, nil
+	}
+	ns := &corev1.Namespace{}
+	if err := a.Client.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return false, fmt.Errorf("getting namespace %s: %w", namespace, err)
+	}
+	return a.Selector.Namespace.Matches(labels.Set(ns.GetLabels())), nil
+}
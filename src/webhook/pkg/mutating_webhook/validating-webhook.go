@@ -0,0 +1,169 @@
+package mutating_webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:admissionReviewVersions=v1,path=/validate-v1-pod,mutating=false,failurePolicy=fail,groups="",resources=pods,verbs=create;update,versions=v1,name=vwebhook.peerpods.io,sideEffects=None
+
+// DEFAULT_POLICY_NAMESPACE is the key of the policy of the namespaces without their own policy
+const DEFAULT_POLICY_NAMESPACE = "*"
+
+// Policy is what the peer pods of a namespace are allowed to request with their annotations.
+// Pods without an annotation get the default of cloud-api-adaptor, which is always allowed
+type Policy struct {
+	// InstanceTypes and Images are the allowed instance types and images. Any value is allowed if they are empty
+	InstanceTypes []string `json:"instanceTypes,omitempty"`
+	Images        []string `json:"images,omitempty"`
+	// MaxGPUs is the largest allowed number of GPUs. Any number is allowed if it is nil
+	MaxGPUs *int64 `json:"maxGPUs,omitempty"`
+	// GPUModels are the allowed GPU models. Any model is allowed if it is empty
+	GPUModels []string `json:"gpuModels,omitempty"`
+	// MaxVCPUs and MaxMemory in MiB are the largest allowed vCPUs and memory. Any size is allowed if they are nil
+	MaxVCPUs  *int64 `json:"maxVCPUs,omitempty"`
+	MaxMemory *int64 `json:"maxMemory,omitempty"`
+}
+
+// PodValidator rejects peer pods whose annotations are not allowed by the policy of their namespace.
+// It validates the pods after they are mutated, so that the annotations set from defaults and resources are validated too
+type PodValidator struct {
+	Decoder *admission.Decoder
+	// Config of the RuntimeClasses of peer pods and the policies of namespaces. DefaultConfig is used if it is nil
+	Config *Config
+	// Mode of the webhook. In ModeAudit, pods that violate the policy are allowed with a warning
+	Mode Mode
+}
+
+func (v *PodValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+
+	err := v.Decoder.Decode(req, pod)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	config := v.Config
+	if config == nil {
+		config = DefaultConfig()
+	}
+	// The selectors and the opt-out label of the mutation don't apply, since policies restrict what peer pods cost
+	if pod.Spec.RuntimeClassName == nil {
+		return admission.Allowed("pod is not a peer pod")
+	}
+	runtimeClass, ok := config.RuntimeClasses[*pod.Spec.RuntimeClassName]
+	if !ok {
+		return admission.Allowed("pod is not a peer pod")
+	}
+
+	// A pod that opted out of the mutation with its own label must request the extended resource itself,
+	// so that it is not scheduled on nodes without peer pods capacity
+	if pod.GetLabels()[PEERPODS_OPT_OUT_LABEL] == PEERPODS_OPT_OUT_VALUE && !requestsExtendedResource(pod, runtimeClass.ExtendedResource) {
+		message := fmt.Sprintf("peer pod with label %s=%s must request the %s resource", PEERPODS_OPT_OUT_LABEL, PEERPODS_OPT_OUT_VALUE, runtimeClass.ExtendedResource)
+		return v.deny(req, pod, message)
+	}
+
+	policy, ok := config.policy(req.Namespace)
+	if !ok {
+		return admission.Allowed("namespace has no policy")
+	}
+
+	if err := policy.validate(pod.GetAnnotations()); err != nil {
+		return v.deny(req, pod, fmt.Sprintf("peer pod is not allowed by the policy of namespace %s: %v", req.Namespace, err))
+	}
+
+	return admission.Allowed("")
+}
+
+// deny rejects a pod, or allows it with a warning in ModeAudit
+func (v *PodValidator) deny(req admission.Request, pod *corev1.Pod, message string) admission.Response {
+	if v.Mode == ModeAudit {
+		logger.Printf("Audit: pod %s/%s would be rejected: %s", req.Namespace, pod.Name, message)
+		return admission.Allowed("audit: policy violation").WithWarnings(message)
+	}
+	logger.Printf("Rejecting pod %s/%s: %s", req.Namespace, pod.Name, message)
+	return admission.Denied(message)
+}
+
+// requestsExtendedResource returns true if a container of a pod has a limit of the extended resource
+func requestsExtendedResource(pod *corev1.Pod, extendedResource string) bool {
+	for _, container := range pod.Spec.Containers {
+		if limit, ok := container.Resources.Limits[corev1.ResourceName(extendedResource)]; ok && !limit.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// policy returns the policy of a namespace, or the default policy if the namespace has none
+func (c *Config) policy(namespace string) (Policy, bool) {
+	if policy, ok := c.Policies[namespace]; ok {
+		return policy, true
+	}
+	policy, ok := c.Policies[DEFAULT_POLICY_NAMESPACE]
+	return policy, ok
+}
+
+// selectsInstanceTypeBySize returns true if cloud-api-adaptor selects the instance type of a pod from its GPU, vCPU
+// and memory annotations, which it does if the pod requests GPUs, or both vCPUs and memory
+func selectsInstanceTypeBySize(annotations map[string]string) bool {
+	positive := func(annotation string) bool {
+		n, err := strconv.ParseInt(annotations[annotation], 10, 64)
+		return err == nil && n > 0
+	}
+	return positive(PEERPODS_GPU_ANNOTATION) || (positive(PEERPODS_CPU_ANNOTATION) && positive(PEERPODS_MEMORY_ANNOTATION))
+}
+
+// validate returns an error describing the annotations of a pod that the policy doesn't allow
+func (p Policy) validate(annotations map[string]string) error {
+	var violations []string
+
+	allowed := func(annotation, name string, values []string) {
+		value, ok := annotations[annotation]
+		if !ok || len(values) == 0 {
+			return
+		}
+		for _, v := range values {
+			if v == value {
+				return
+			}
+		}
+		violations = append(violations, fmt.Sprintf("%s %q is not one of %s", name, value, strings.Join(values, ", ")))
+	}
+	allowed(PEERPODS_INSTANCE_TYPE_ANNOTATION, "instance type", p.InstanceTypes)
+	allowed(PEERPODS_IMAGE_ANNOTATION, "image", p.Images)
+	allowed(PEERPODS_GPU_MODEL_ANNOTATION, "GPU model", p.GPUModels)
+
+	maximum := func(annotation, name string, max *int64) {
+		value, ok := annotations[annotation]
+		if !ok || max == nil {
+			return
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("%s %q is not an integer", name, value))
+		} else if n > *max {
+			violations = append(violations, fmt.Sprintf("%d %s exceed the maximum of %d", n, name, *max))
+		}
+	}
+	maximum(PEERPODS_GPU_ANNOTATION, "GPUs", p.MaxGPUs)
+	maximum(PEERPODS_CPU_ANNOTATION, "vCPUs", p.MaxVCPUs)
+	maximum(PEERPODS_MEMORY_ANNOTATION, "MiB of memory", p.MaxMemory)
+
+	// cloud-api-adaptor selects the instance type from the GPU, vCPU and memory annotations instead of the instance type
+	// annotation, so the instance type it selects from them can't be checked against the allowed instance types
+	if len(p.InstanceTypes) > 0 && selectsInstanceTypeBySize(annotations) {
+		violations = append(violations, "instance type is selected by the GPU, vCPU and memory annotations, which is not allowed with a list of instance types")
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%s", strings.Join(violations, "; "))
+	}
+	return nil
+}
@@ -65,6 +65,35 @@ func GetResourceLimitQuantity(pod *corev1.Pod, resourceName corev1.ResourceName)
 	return limitQuantity
 }
 
+// GetEffectiveResourceQuantity finds and returns the quantity of a specific resource that a pod uses at once.
+// The quantity of a container is its limit if it is greater than its request, and init containers run one at a
+// time before the containers, like the scheduler computes the effective request of a pod
+func GetEffectiveResourceQuantity(pod *corev1.Pod, resourceName corev1.ResourceName) resource.Quantity {
+
+	containerQuantity := func(container corev1.Container) resource.Quantity {
+		quantity := getResourceQuantity(resourceName)
+		if rQuantity, ok := container.Resources.Requests[resourceName]; ok {
+			quantity = rQuantity.DeepCopy()
+		}
+		if lQuantity, ok := container.Resources.Limits[resourceName]; ok && lQuantity.Cmp(quantity) > 0 {
+			quantity = lQuantity.DeepCopy()
+		}
+		return quantity
+	}
+
+	effectiveQuantity := getResourceQuantity(resourceName)
+	for _, container := range pod.Spec.Containers {
+		effectiveQuantity.Add(containerQuantity(container))
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		if quantity := containerQuantity(container); quantity.Cmp(effectiveQuantity) > 0 {
+			effectiveQuantity = quantity
+		}
+	}
+	return effectiveQuantity
+}
+
 // Method to get the resource Quantity from the resource name
 func getResourceQuantity(resourceName corev1.ResourceName) resource.Quantity {
 